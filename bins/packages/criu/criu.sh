@@ -0,0 +1,54 @@
+CRIU_VERSION="v3.14"
+CRIU_REPOSITORY="https://github.com/checkpoint-restore/criu"
+
+dependencies_criu() {
+    apt-get install -y build-essential pkg-config libprotobuf-dev libprotobuf-c-dev \
+        protobuf-c-compiler protobuf-compiler python-protobuf libnl-3-dev libnet-dev \
+        libcap-dev asciidoc
+}
+
+download_criu() {
+    download_git ${CRIU_REPOSITORY} ${CRIU_VERSION}
+}
+
+extract_criu() {
+    echo "[+] extracting criu"
+    rm -rf ${WORKDIR}/*
+    cp -a criu/* ${WORKDIR}/
+}
+
+prepare_criu() {
+    echo "[+] prepare criu"
+    github_name "criu-${CRIU_VERSION}"
+}
+
+compile_criu() {
+    echo "[+] compile criu"
+    make criu
+}
+
+install_criu() {
+    echo "[+] install criu"
+
+    mkdir -p "${ROOTDIR}/usr/sbin"
+
+    cp ${WORKDIR}/criu/criu ${ROOTDIR}/usr/sbin/criu
+    chmod +x ${ROOTDIR}/usr/sbin/criu
+}
+
+build_criu() {
+    pushd "${DISTDIR}"
+
+    dependencies_criu
+    download_criu
+    extract_criu
+
+    popd
+    pushd ${WORKDIR}
+
+    prepare_criu
+    compile_criu
+    install_criu
+
+    popd
+}
@@ -1,8 +1,8 @@
-FIRECRACKER_BRANCH="master"
-FIRECRACKER_VERSION="1eb80f8d1bbe9287cafdedebaee3fb604095fac6"
+FIRECRACKER_BRANCH="v0.24.0"
+FIRECRACKER_VERSION="v0.24.0"
 FIRECRACKER_REPOSITORY="https://github.com/firecracker-microvm/firecracker"
 FIRECRACKER_LIBC="musl"
-FIRECRACKER_RUST_TOOLCHAIN="1.39.0"
+FIRECRACKER_RUST_TOOLCHAIN="1.49.0"
 
 dependencies_firecracker() {
     # based on:
@@ -0,0 +1,60 @@
+UNBOUND_VERSION="release-1.10.1"
+UNBOUND_REPOSITORY="https://github.com/NLnetLabs/unbound"
+
+dependencies_unbound() {
+    apt-get install -y build-essential bison flex libssl-dev libexpat1-dev
+}
+
+download_unbound() {
+    download_git ${UNBOUND_REPOSITORY} ${UNBOUND_VERSION}
+}
+
+extract_unbound() {
+    echo "[+] extracting unbound"
+    rm -rf ${WORKDIR}/*
+    cp -a unbound/* ${WORKDIR}/
+}
+
+prepare_unbound() {
+    echo "[+] prepare unbound"
+    github_name "unbound-${UNBOUND_VERSION}"
+
+    # the configuration is generated by networkd, and the service is
+    # added to zinit by networkd once the configuration is written
+    ./configure --prefix=/usr --sysconfdir=/etc --disable-static \
+        --with-pidfile="" --with-username="" --with-chroot-dir=""
+}
+
+compile_unbound() {
+    echo "[+] compile unbound"
+    make ${MAKEOPTS}
+}
+
+install_unbound() {
+    echo "[+] install unbound"
+
+    mkdir -p "${ROOTDIR}/usr/sbin"
+    mkdir -p "${ROOTDIR}/usr/lib"
+
+    cp unbound unbound-control unbound-anchor ${ROOTDIR}/usr/sbin/
+    cp -a .libs/libunbound.so* ${ROOTDIR}/usr/lib/
+
+    chmod +x ${ROOTDIR}/usr/sbin/unbound*
+}
+
+build_unbound() {
+    pushd "${DISTDIR}"
+
+    dependencies_unbound
+    download_unbound
+    extract_unbound
+
+    popd
+    pushd ${WORKDIR}
+
+    prepare_unbound
+    compile_unbound
+    install_unbound
+
+    popd
+}
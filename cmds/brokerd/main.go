@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+const module = "broker"
+
+// brokerd runs the privileged operations of the sandboxed modules, it's
+// the only module that keeps all its capabilities with identityd
+func main() {
+	app.Initialize()
+
+	var (
+		msgBrokerCon string
+		workerNr     uint
+		ver          bool
+	)
+
+	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
+	// the health checks of the workloads run concurrently
+	flag.UintVar(&workerNr, "workers", 10, "number of workers")
+	flag.BoolVar(&ver, "v", false, "show version and exit")
+
+	flag.Parse()
+	if ver {
+		version.ShowAndExit(false)
+	}
+
+	server, err := zbus.NewRedisServer(module, msgBrokerCon, workerNr)
+	if err != nil {
+		log.Fatal().Msgf("fail to connect to message broker server: %v", err)
+	}
+
+	server.Register(zbus.ObjectID{Name: module, Version: "0.0.1"}, pkg.Broker(sandbox.NewBroker()))
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
+
+	log.Info().
+		Str("broker", msgBrokerCon).
+		Msg("starting broker module")
+
+	ctx, _ := utils.WithSignal(context.Background())
+	utils.OnDone(ctx, func(_ error) {
+		log.Info().Msg("shutting down")
+	})
+
+	if err := server.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("unexpected error")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"path/filepath"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -10,8 +11,15 @@ import (
 	"github.com/threefoldtech/tfexplorer/client"
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/benchmark"
 	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/environment"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/logging"
 	"github.com/threefoldtech/zos/pkg/monitord"
+	"github.com/threefoldtech/zos/pkg/offline"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
 	"github.com/threefoldtech/zos/pkg/stubs"
 	"github.com/threefoldtech/zos/pkg/utils"
 
@@ -23,17 +31,17 @@ import (
 
 const module = "monitor"
 
-func cap(ctx context.Context, client zbus.Client) {
+func cap(ctx context.Context, client zbus.Client, root string) *capacity.InventoryManager {
 	storage := stubs.NewStorageModuleStub(client)
 	identity := stubs.NewIdentityManagerStub(client)
 	network := stubs.NewNetworkerStub(client)
-	cl, err := bcdbClient()
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to connect to bcdb backend")
-	}
 
 	// call this now so we block here until identityd is ready to serve us
-	nodeID := identity.NodeID().Identity()
+	id, err := identity.NodeID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get node identity")
+	}
+	nodeID := id.Identity()
 
 	// block until networkd is ready to serve request from zbus
 	// this is used to prevent uptime and online status to the explorer if the node is not in a fully ready
@@ -71,11 +79,37 @@ func cap(ctx context.Context, client zbus.Client) {
 		log.Fatal().Err(err).Msgf("failed to read smartctl information from disks")
 	}
 
+	hardware, err := capacity.HardwareInventory(dmi, disks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to read hardware inventory")
+	}
+
+	// the fingerprint is kept across reboots to detect the hardware swaps
+	previous, err := capacity.CheckHardware(filepath.Join(root, "fingerprint"), hardware)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check hardware fingerprint")
+	} else if len(previous) != 0 {
+		log.Warn().
+			Str("previous", previous).
+			Str("current", capacity.HardwareFingerprint(hardware)).
+			Msg("hardware of the node changed")
+	}
+
 	hypervisor, err := r.GetHypervisor()
 	if err != nil {
 		log.Fatal().Err(err).Msgf("failed to read virtualized state")
 	}
 
+	virt, err := r.Virtualization()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to detect hardware virtualization support")
+	}
+	log.Info().
+		Str("extension", virt.Extension).
+		Bool("kvm", virt.KVM).
+		Bool("nested", virt.Nested).
+		Msg("hardware virtualization support")
+
 	ru := directory.ResourceAmount{
 		Cru: resources.CRU,
 		Mru: float64(resources.MRU),
@@ -83,6 +117,21 @@ func cap(ctx context.Context, client zbus.Client) {
 		Sru: float64(resources.SRU),
 	}
 
+	inventory := capacity.NewInventoryManager(hardware, identity, network)
+
+	offlineConfig, err := offline.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid offline configuration")
+	} else if offlineConfig.Enabled {
+		log.Info().Msg("node is offline, capacity and uptime are not reported")
+		return inventory
+	}
+
+	cl, err := bcdbClient()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to bcdb backend")
+	}
+
 	setCapacity := func() error {
 		log.Info().Msg("sends capacity detail to BCDB")
 		return cl.NodeSetCapacity(nodeID, ru, *dmi, disks, hypervisor)
@@ -104,7 +153,7 @@ func cap(ctx context.Context, client zbus.Client) {
 		}
 
 		log.Info().Msg("send heart-beat to BCDB")
-		if err := cl.NodeUpdateUptime(identity.NodeID().Identity(), uptime); err != nil {
+		if err := cl.NodeUpdateUptime(nodeID, uptime); err != nil {
 			log.Error().Err(err).Msgf("failed to send heart-beat to BCDB")
 			return err
 		}
@@ -128,10 +177,12 @@ func cap(ctx context.Context, client zbus.Client) {
 			}
 		}
 	}()
+
+	return inventory
 }
 
-func mon(ctx context.Context, server zbus.Server) {
-	system, err := monitord.NewSystemMonitor(2 * time.Second)
+func mon(ctx context.Context, server zbus.Server, client zbus.Client) {
+	system, err := monitord.NewSystemMonitor(2*time.Second, client)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize system monitor")
 	}
@@ -149,10 +200,12 @@ func main() {
 
 	var (
 		msgBrokerCon string
+		root         string
 		ver          bool
 	)
 
 	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
+	flag.StringVar(&root, "root", "/var/cache/modules/capacityd", "root working directory of the module")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
 
 	flag.Parse()
@@ -160,23 +213,64 @@ func main() {
 		version.ShowAndExit(false)
 	}
 
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
 	redis, err := zbus.NewRedisClient(msgBrokerCon)
 	if err != nil {
 		log.Fatal().Msgf("fail to connect to message broker server: %v", err)
 	}
 
-	server, err := zbus.NewRedisServer(module, msgBrokerCon, 1)
+	// wait for the modules we call to serve requests, we restart
+	// when one of them restarts
+	deps, err := startup.New(module, redis)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unknown module dependencies")
+	}
+	if err := deps.Wait(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to wait for dependencies")
+	}
+
+	// a benchmark run takes minutes, the other requests are served meanwhile
+	server, err := zbus.NewRedisServer(module, msgBrokerCon, 2)
 	if err != nil {
 		log.Fatal().Msgf("fail to connect to message broker server: %v\n", err)
 	}
 
-	ctx, _ := utils.WithSignal(context.Background())
+	ctx, cancel := utils.WithSignal(context.Background())
+	defer cancel()
 	utils.OnDone(ctx, func(_ error) {
 		log.Info().Msg("shutting down")
 	})
+	go func() {
+		// the server stops and the module exits once the running calls
+		// are done, zinit then restarts it
+		if err := deps.Watch(ctx); err != nil {
+			log.Error().Err(err).Msg("restarting module")
+			cancel()
+		}
+	}()
+
+	inventory := cap(ctx, redis, root)
+	mon(ctx, server, redis)
+	server.Register(zbus.ObjectID{Name: "inventory", Version: "0.0.1"}, inventory)
 
-	cap(ctx, redis)
-	mon(ctx, server)
+	env, err := environment.Get()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse node environment")
+	}
+
+	config := benchmark.DefaultConfig
+	config.Directory = env.BcdbURL
+	bench, err := benchmark.New(filepath.Join(root, "benchmark"), config, stubs.NewIdentityManagerStub(redis), inventory)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create benchmark runner")
+	}
+	server.Register(zbus.ObjectID{Name: "benchmark", Version: "0.0.1"}, bench)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	if err := server.Run(ctx); err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("unexpected error")
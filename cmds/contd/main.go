@@ -5,6 +5,9 @@ import (
 	"flag"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -12,7 +15,13 @@ import (
 
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/cancel"
 	"github.com/threefoldtech/zos/pkg/container"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 	"github.com/threefoldtech/zos/pkg/utils"
 	"github.com/threefoldtech/zos/pkg/version"
 )
@@ -28,6 +37,7 @@ func main() {
 		containerdCon string
 		workerNr      uint
 		ver           bool
+		coredump      int
 	)
 
 	flag.StringVar(&moduleRoot, "root", "/var/cache/modules/contd", "root working directory of the module")
@@ -35,12 +45,24 @@ func main() {
 	flag.StringVar(&containerdCon, "containerd", "/run/containerd/containerd.sock", "connection string to containerd")
 	flag.UintVar(&workerNr, "workers", 1, "number of workers")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
+	flag.IntVar(&coredump, "coredump", 0, "store the core dump of this pid read from stdin (used by the kernel)")
 
 	flag.Parse()
 	if ver {
 		version.ShowAndExit(false)
 	}
 
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
+	if coredump != 0 {
+		if err := container.CollectCoreDump(moduleRoot, coredump, os.Stdin); err != nil {
+			log.Fatal().Err(err).Int("pid", coredump).Msg("failed to collect core dump")
+		}
+		return
+	}
+
 	// wait for shim-logs to be available before starting
 	log.Info().Msg("wait for shim-logs binary to be available")
 	bo := backoff.NewExponentialBackOff()
@@ -57,26 +79,68 @@ func main() {
 		log.Fatal().Msgf("fail to create module root: %s", err)
 	}
 
+	client, err := zbus.NewRedisClient(msgBrokerCon)
+	if err != nil {
+		log.Fatal().Msgf("fail to connect to message broker server: %v", err)
+	}
+	broker := stubs.NewBrokerStub(client)
+
+	if err := broker.ConfigureCoreDumps(moduleRoot); err != nil {
+		log.Error().Err(err).Msg("failed to configure core dumps collection")
+	}
+
 	server, err := zbus.NewRedisServer(module, msgBrokerCon, workerNr)
 	if err != nil {
 		log.Fatal().Msgf("fail to connect to message broker server: %v", err)
 	}
 
-	containerd := container.New(moduleRoot, containerdCon)
+	containerd := container.New(moduleRoot, containerdCon, broker)
 
 	server.Register(zbus.ObjectID{Name: module, Version: "0.0.1"}, containerd)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	log.Info().
 		Str("broker", msgBrokerCon).
 		Uint("worker nr", workerNr).
 		Msg("starting containerd module")
 
-	ctx, _ := utils.WithSignal(context.Background())
+	ctx, stop := utils.WithSignal(context.Background())
+
+	// a restart of contd leaves the containers running, they are only
+	// checkpointed when the node goes down, which contd is told with SIGPWR
+	var down int32
+	power := make(chan os.Signal, 1)
+	signal.Notify(power, syscall.SIGPWR)
+	go func() {
+		<-power
+		atomic.StoreInt32(&down, 1)
+		stop()
+	}()
+
 	utils.OnDone(ctx, func(_ error) {
 		log.Info().Msg("shutting down")
 	})
 
+	if err := tracing.Init(ctx, module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("invalid tracing configuration, spans are not exported")
+	}
+
+	if err := cancel.Init(module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("failed to propagate call cancellations")
+	}
+
 	if err := server.Run(ctx); err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("unexpected error")
 	}
+
+	if atomic.LoadInt32(&down) == 0 {
+		return
+	}
+
+	// the node is going down, save the state of the containers that can be restored
+	if err := container.CheckpointAll(containerdCon); err != nil {
+		log.Error().Err(err).Msg("failed to checkpoint containers")
+	}
 }
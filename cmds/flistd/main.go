@@ -5,6 +5,10 @@ import (
 	"flag"
 
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/cancel"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
 	"github.com/threefoldtech/zos/pkg/stubs"
 	"github.com/threefoldtech/zos/pkg/utils"
 
@@ -37,29 +41,59 @@ func main() {
 		version.ShowAndExit(false)
 	}
 
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
 	redis, err := zbus.NewRedisClient(msgBrokerCon)
 	if err != nil {
 		log.Fatal().Msgf("fail to connect to message broker server: %v", err)
 	}
 	storage := stubs.NewStorageModuleStub(redis)
 
+	// wait for the modules we call to serve requests, we restart
+	// when one of them restarts
+	deps, err := startup.New(module, redis)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unknown module dependencies")
+	}
+	if err := deps.Wait(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to wait for dependencies")
+	}
+
 	server, err := zbus.NewRedisServer(module, msgBrokerCon, workerNr)
 	if err != nil {
 		log.Fatal().Msgf("fail to connect to message broker server: %v\n", err)
 	}
 
+	if err := cancel.Init(module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("failed to propagate call cancellations")
+	}
+
 	flist := flist.New(moduleRoot, storage)
 	server.Register(zbus.ObjectID{Name: module, Version: "0.0.1"}, flist)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	log.Info().
 		Str("broker", msgBrokerCon).
 		Uint("worker nr", workerNr).
 		Msg("starting flist module")
 
-	ctx, _ := utils.WithSignal(context.Background())
+	ctx, stop := utils.WithSignal(context.Background())
+	defer stop()
 	utils.OnDone(ctx, func(_ error) {
 		log.Info().Msg("shutting down")
 	})
+	go func() {
+		// the server stops and the module exits once the running calls
+		// are done, zinit then restarts it
+		if err := deps.Watch(ctx); err != nil {
+			log.Error().Err(err).Msg("restarting module")
+			stop()
+		}
+	}()
 
 	if err := server.Run(ctx); err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("unexpected error")
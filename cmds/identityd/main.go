@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/jbenet/go-base58"
 	"github.com/shirou/gopsutil/host"
 
@@ -17,16 +18,23 @@ import (
 	"github.com/threefoldtech/tfexplorer/client"
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/audit"
 	"github.com/threefoldtech/zos/pkg/flist"
 	"github.com/threefoldtech/zos/pkg/geoip"
+	"github.com/threefoldtech/zos/pkg/logging"
 	"github.com/threefoldtech/zos/pkg/network"
+	"github.com/threefoldtech/zos/pkg/startup"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tpm"
 	"github.com/threefoldtech/zos/pkg/upgrade"
 
 	"github.com/cenkalti/backoff/v3"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/environment"
+	"github.com/threefoldtech/zos/pkg/features"
 	"github.com/threefoldtech/zos/pkg/identity"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/offline"
 
 	"github.com/threefoldtech/zos/pkg/zinit"
 
@@ -113,7 +121,10 @@ func main() {
 			log.Fatal().Err(err).Msg("failed to connect to zbus")
 		}
 		stub := stubs.NewIdentityManagerStub(client)
-		nodeID := stub.NodeID()
+		nodeID, err := stub.NodeID()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to get node identity")
+		}
 		fmt.Println(nodeID)
 		os.Exit(0)
 	}
@@ -126,6 +137,30 @@ func main() {
 
 	bootMethod := boot.DetectBootMethod()
 
+	backupConfig, err := identity.BackupConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid seed backup configuration")
+	}
+	seedPath := filepath.Join(root, seedName)
+
+	// a seed sealed to the TPM can't be unsealed anymore once the boot state
+	// of the node changes, it's then restored from its backup like the
+	// seed of a lost node
+	if _, err := identity.LoadSeed(seedPath); identity.IsUnsealError(err) {
+		log.Error().Err(err).Msg("node seed can't be unsealed, the boot state of the node changed")
+		restore, err := identity.SetAside(seedPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("node seed can't be restored")
+		}
+		backupConfig.Restore = restore
+	}
+
+	backup := identity.NewSeedBackup(seedPath, backupConfig)
+
+	if err := waitRestore(broker, root, backupConfig, backup); err != nil {
+		log.Fatal().Err(err).Msg("failed to restore seed")
+	}
+
 	// 2. Register the node to BCDB
 	// at this point we are running latest version
 	idMgr, err := identityMgr(root)
@@ -152,27 +187,81 @@ func main() {
 		current = "not booted from flist"
 	}
 
-	nodeID := idMgr.NodeID()
+	nodeID, err := idMgr.NodeID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to read node identity")
+	}
+
 	farmID, err := idMgr.FarmID()
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to read farm ID")
 	}
 
-	loc, err := geoip.Fetch()
+	offlineConfig, err := offline.ConfigFromParams(kernel.GetParams())
 	if err != nil {
-		log.Fatal().Err(err).Msg("fetch location")
+		log.Fatal().Err(err).Msg("invalid offline configuration")
 	}
 
-	register := func(v string) error {
-		return registerNode(nodeID, farmID, v, idStore, loc)
+	var register func(v string) error
+	if offlineConfig.Enabled {
+		log.Info().Msg("node is offline, it's not registered")
+	} else {
+		loc, err := geoip.Fetch()
+		if err != nil {
+			log.Fatal().Err(err).Msg("fetch location")
+		}
+
+		register = func(v string) error {
+			return registerNode(nodeID, farmID, v, idStore, loc)
+		}
+
+		if err := backoff.RetryNotify(func() error {
+			return register(current)
+		}, backoff.NewExponentialBackOff(), retryNotify); err != nil {
+			log.Error().Err(err).Msg("failed to register node")
+		} else {
+			log.Info().Str("version", current).Msg("node registered successfully")
+		}
 	}
 
-	if err := backoff.RetryNotify(func() error {
-		return register(current)
-	}, backoff.NewExponentialBackOff(), retryNotify); err != nil {
-		log.Error().Err(err).Msg("failed to register node")
-	} else {
-		log.Info().Str("version", current).Msg("node registered successfully")
+	channelConfig, err := upgrade.ChannelConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid upgrade channel configuration, following the boot flist")
+	}
+	channels := upgrade.NewChannels(filepath.Join(root, "channel"), boot.Name(), nodeID.Identity(), channelConfig)
+
+	featuresConfig, err := features.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid features configuration")
+	}
+	flags := features.NewManager(filepath.Join(root, "features"), nodeID.Identity(), featuresConfig, func() (string, error) {
+		channel, err := channels.Channel()
+		return channel.Channel, err
+	})
+
+	zinit, err := zinit.New(zinitSocket)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to zinit")
+	}
+
+	// with volume allocation is set to nil this flister can be
+	// only used for RO mounts. Otherwise it will panic
+	flister := flist.New(root, nil)
+
+	upgradeAudit, err := audit.New(audit.DefaultRoot, "upgrade", idMgr)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create upgrade audit log")
+	}
+
+	env, _ := environment.Get()
+	upgrader := upgrade.Upgrader{
+		FLister:      flister,
+		Zinit:        zinit,
+		NoSelfUpdate: debug,
+		// development flists are not signed with the release key
+		AllowUnsigned: env.RunningMode == environment.RunningDev,
+		Audit:         upgradeAudit,
+		Preflight:     upgrade.DefaultPreflight,
 	}
 
 	monitor := newVersionMonitor(2 * time.Second)
@@ -184,6 +273,14 @@ func main() {
 
 	server.Register(zbus.ObjectID{Name: "manager", Version: "0.0.1"}, idMgr)
 	server.Register(zbus.ObjectID{Name: "monitor", Version: "0.0.1"}, monitor)
+	server.Register(zbus.ObjectID{Name: "audit", Version: "0.0.1"}, audit.NewReader(audit.DefaultRoot, idMgr))
+	server.Register(zbus.ObjectID{Name: "backup", Version: "0.0.1"}, backup)
+	server.Register(zbus.ObjectID{Name: "channel", Version: "0.0.1"}, channels)
+	server.Register(zbus.ObjectID{Name: "features", Version: "0.0.1"}, flags)
+	server.Register(zbus.ObjectID{Name: "planner", Version: "0.0.1"}, &upgrader)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	ctx, cancel := utils.WithSignal(context.Background())
 	// register the cancel function with defer if the process stops because of a update
@@ -195,27 +292,19 @@ func main() {
 		}
 	}()
 
-	zinit, err := zinit.New(zinitSocket)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to connect to zinit")
-	}
-
-	// with volume allocation is set to nil this flister can be
-	// only used for RO mounts. Otherwise it will panic
-	flister := flist.New(root, nil)
-
-	upgrader := upgrade.Upgrader{
-		FLister:      flister,
-		Zinit:        zinit,
-		NoSelfUpdate: debug,
-	}
-
-	installBinaries(&boot, &upgrader)
-
 	utils.OnDone(ctx, func(_ error) {
 		log.Info().Msg("received a termination signal")
 	})
 
+	if offlineConfig.Enabled {
+		// the releases are read from the bundle instead of the hub
+		log.Info().Msg("start offline upgrade daemon")
+		offlineUpgradeLoop(ctx, root, offlineConfig, &upgrader, time.Duration(interval)*time.Second)
+		return
+	}
+
+	installBinaries(ctx, &boot, &upgrader)
+
 	if bootMethod != upgrade.BootMethodFList {
 		log.Info().Msg("node is not booted from an flist. upgrade is not supported")
 		<-ctx.Done()
@@ -228,7 +317,7 @@ func main() {
 	// 4. Start watcher for new version
 	log.Info().Msg("start upgrade daemon")
 
-	upgradeLoop(ctx, &boot, &upgrader, debug, monitor, register)
+	upgradeLoop(ctx, &boot, &upgrader, channels, debug, monitor, register)
 }
 
 func getBinsRepo() string {
@@ -259,7 +348,7 @@ func debugReinstall(boot *upgrade.Boot, up *upgrade.Upgrader) {
 			}
 
 			if err := Safe(func() error {
-				return up.Upgrade(current, current)
+				return up.Upgrade(context.Background(), current, current)
 			}); err != nil {
 				log.Error().Err(err).Msg("reinstall failed")
 			} else {
@@ -269,7 +358,7 @@ func debugReinstall(boot *upgrade.Boot, up *upgrade.Upgrader) {
 	}()
 }
 
-func installBinaries(boot *upgrade.Boot, upgrader *upgrade.Upgrader) {
+func installBinaries(ctx context.Context, boot *upgrade.Boot, upgrader *upgrade.Upgrader) {
 
 	bins, _ := boot.CurrentBins()
 
@@ -290,7 +379,7 @@ func installBinaries(boot *upgrade.Boot, upgrader *upgrade.Upgrader) {
 	}
 
 	for _, pkg := range toAdd {
-		if err := upgrader.InstallBinary(pkg); err != nil {
+		if err := upgrader.InstallBinary(ctx, pkg); err != nil {
 			log.Error().Err(err).Str("package", pkg.Fqdn()).Msg("failed to install package")
 		}
 	}
@@ -302,6 +391,7 @@ func upgradeLoop(
 	ctx context.Context,
 	boot *upgrade.Boot,
 	upgrader *upgrade.Upgrader,
+	channels *upgrade.Channels,
 	debug bool,
 	monitor *monitorStream,
 	register func(string) error) {
@@ -310,18 +400,21 @@ func upgradeLoop(
 		debugReinstall(boot, upgrader)
 	}
 
-	flistWatcher := upgrade.FListSemverWatcher{
-		FList:    boot.Name(),
-		Current:  boot.MustVersion(), //if we are here version must be valid
-		Duration: 600 * time.Second,
-	}
-
 	// make sure we push the current version to monitor
 	monitor.C <- boot.MustVersion()
 
-	flistEvents, err := flistWatcher.Watch(ctx)
+	channel, err := channels.Channel()
 	if err != nil {
-		log.Fatal().Err(err).Str("flist", flistWatcher.FList).Msg("failed to watch flist")
+		log.Fatal().Err(err).Msg("failed to get upgrade channel")
+	}
+
+	flistCtx, flistCancel := context.WithCancel(ctx)
+	defer func() { flistCancel() }()
+
+	//if we are here version must be valid
+	flistEvents, err := watchChannel(flistCtx, channel, boot.MustVersion())
+	if err != nil {
+		log.Fatal().Err(err).Str("flist", channel.FList).Msg("failed to watch flist")
 	}
 
 	bins, err := boot.CurrentBins()
@@ -343,6 +436,24 @@ func upgradeLoop(
 		select {
 		case <-ctx.Done():
 			return
+		case <-channels.Changed():
+			channel, err := channels.Channel()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to get upgrade channel")
+				continue
+			}
+
+			// the current watcher is kept until the new one is started
+			watchCtx, watchCancel := context.WithCancel(ctx)
+			events, err := watchChannel(watchCtx, channel, boot.MustVersion())
+			if err != nil {
+				watchCancel()
+				log.Error().Err(err).Str("flist", channel.FList).Msg("failed to watch new channel flist")
+				continue
+			}
+
+			flistCancel()
+			flistCancel, flistEvents = watchCancel, events
 		case e := <-flistEvents:
 			if e == nil {
 				continue
@@ -362,7 +473,7 @@ func upgradeLoop(
 			}
 
 			err = Safe(func() error {
-				return upgrader.Upgrade(from, *event)
+				return upgrader.Upgrade(ctx, from, *event)
 			})
 
 			if err == upgrade.ErrRestartNeeded {
@@ -398,7 +509,7 @@ func upgradeLoop(
 			}
 
 			for _, bin := range event.ToAdd {
-				if err := upgrader.InstallBinary(bin); err != nil {
+				if err := upgrader.InstallBinary(ctx, bin); err != nil {
 					log.Error().Err(err).Str("flist", bin.Fqdn()).Msg("failed to install flist")
 				}
 			}
@@ -412,6 +523,60 @@ func upgradeLoop(
 	}
 }
 
+// offlineUpgradeLoop installs the releases of the offline bundle, the
+// bundle is checked at every interval
+func offlineUpgradeLoop(ctx context.Context, root string, config offline.Config, upgrader *upgrade.Upgrader, interval time.Duration) {
+	bundle := offline.NewBundle(config)
+	release, err := offline.NewRelease(bundle, filepath.Join(root, "offline"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create offline release")
+	}
+
+	for {
+		dir, hash, err := release.Stage(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("bundle", bundle.String()).Msg("failed to read offline release")
+		} else if len(dir) != 0 {
+			log.Info().Str("release", hash).Msg("installing release of the offline bundle")
+			err = Safe(func() error {
+				return upgrader.InstallRelease(ctx, offline.ReleaseFile, dir)
+			})
+
+			if err == upgrade.ErrRestartNeeded {
+				log.Info().Msg("restarting upgraded")
+				return
+			} else if err != nil {
+				log.Error().Err(err).Str("release", hash).Msg("offline upgrade failed")
+			} else if err := release.Installed(hash); err != nil {
+				log.Error().Err(err).Msg("failed to record offline release")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// watchChannel watches the flist of the channel for versions newer than current
+func watchChannel(ctx context.Context, channel pkg.UpgradeChannel, current semver.Version) (<-chan upgrade.Event, error) {
+	log.Info().
+		Str("channel", channel.Channel).
+		Str("source", channel.Source).
+		Str("flist", channel.FList).
+		Msg("watching upgrade channel")
+
+	flistWatcher := upgrade.FListSemverWatcher{
+		FList:    channel.FList,
+		Current:  current,
+		Duration: 600 * time.Second,
+	}
+
+	return flistWatcher.Watch(ctx)
+}
+
 func retryNotify(err error, d time.Duration) {
 	log.Warn().Err(err).Str("sleep", d.String()).Msg("registration failed")
 }
@@ -424,12 +589,30 @@ func identityMgr(root string) (pkg.IdentityManager, error) {
 		return nil, err
 	}
 
+	// the seed is loaded, a failure to seal it doesn't prevent the
+	// node from running with the file seed
+	if tpm.Enabled(kernel.GetParams()) {
+		if err := identity.SealSeed(seedPath, tpm.DefaultPCRs); err == identity.ErrNoBackup {
+			log.Warn().Msg("seed not sealed to the TPM until it's backed up with 'zoscli identity backup', keeping the file seed")
+		} else if err != nil {
+			log.Error().Err(err).Msg("failed to seal seed to the TPM, keeping the file seed")
+		} else {
+			log.Info().Msg("seed sealed to the TPM")
+		}
+	} else {
+		log.Info().Msg("no TPM 2.0 found, keeping the file seed")
+	}
+
 	env, err := environment.Get()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse node environment")
 	}
 
-	nodeID := manager.NodeID()
+	nodeID, err := manager.NodeID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read node identity")
+	}
+
 	log.Info().
 		Str("identity", nodeID.Identity()).
 		Msg("node identity loaded")
@@ -442,6 +625,43 @@ func identityMgr(root string) (pkg.IdentityManager, error) {
 	return manager, nil
 }
 
+// waitRestore blocks until the seed of the node is restored from its
+// shares when the node restores the identity of a lost node. Only the
+// backup object is served meanwhile
+func waitRestore(broker, root string, config identity.BackupConfig, backup *identity.SeedBackup) error {
+	if len(config.Restore) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(root, seedName)); err == nil {
+		log.Info().Msg("seed exists, nothing to restore")
+		return nil
+	}
+
+	server, err := zbus.NewRedisServer(module, broker, 1)
+	if err != nil {
+		return errors.Wrap(err, "fail to connect to message broker server")
+	}
+	server.Register(zbus.ObjectID{Name: "backup", Version: "0.0.1"}, backup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Run(ctx)
+	}()
+
+	log.Warn().Str("identity", config.Restore).Msg("waiting for the seed shares to restore the node identity")
+	select {
+	case <-backup.Restored():
+		log.Info().Str("identity", config.Restore).Msg("node identity restored")
+		return nil
+	case err := <-errs:
+		return errors.Wrap(err, "zbus server stopped")
+	}
+}
+
 // instantiate the proper client based on the running mode
 func bcdbClient() (client.Directory, error) {
 	client, err := app.ExplorerClient()
@@ -16,6 +16,7 @@ import (
 	"github.com/threefoldtech/zos/pkg/network/bootstrap"
 	"github.com/threefoldtech/zos/pkg/network/bridge"
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 	"github.com/threefoldtech/zos/pkg/network/types"
 	"github.com/threefoldtech/zos/pkg/zinit"
 
@@ -107,6 +108,13 @@ func configureZOS() error {
 			return errors.Wrapf(err, "could not get link %s", zosChild)
 		}
 
+		if mtu.Jumbo() {
+			log.Info().Str("device", link.Attrs().Name).Int("mtu", mtu.Farm()).Msg("enable jumbo frames")
+			if err := netlink.LinkSetMTU(link, mtu.Farm()); err != nil {
+				return errors.Wrapf(err, "could not set mtu of %s", zosChild)
+			}
+		}
+
 		log.Info().
 			Str("device", link.Attrs().Name).
 			Str("bridge", br.Name).
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -13,52 +14,112 @@ import (
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/features"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/logging"
 	"github.com/threefoldtech/zos/pkg/network"
+	"github.com/threefoldtech/zos/pkg/network/bgp"
 	"github.com/threefoldtech/zos/pkg/network/bootstrap"
+	"github.com/threefoldtech/zos/pkg/network/dns"
+	"github.com/threefoldtech/zos/pkg/network/flow"
 	"github.com/threefoldtech/zos/pkg/network/ndmz"
+	"github.com/threefoldtech/zos/pkg/network/nr"
+	"github.com/threefoldtech/zos/pkg/network/offload"
 	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/offline"
+	"github.com/threefoldtech/zos/pkg/proxy"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/timesync"
+	"github.com/threefoldtech/zos/pkg/tracing"
 	"github.com/threefoldtech/zos/pkg/utils"
 	"github.com/threefoldtech/zos/pkg/version"
+	"github.com/threefoldtech/zos/pkg/zinit"
 )
 
 const redisSocket = "unix:///var/run/redis.sock"
 const module = "network"
 
+// drainTimeout is the maximum time we wait for in-flight
+// operations to finish on shutdown
+const drainTimeout = 2 * time.Minute
+
 func main() {
 	app.Initialize()
 
 	var (
-		root   string
-		broker string
-		ver    bool
+		root       string
+		broker     string
+		ver        bool
+		routeTable int
+		protection = network.DefaultPublicProtection
 	)
 
 	flag.StringVar(&root, "root", "/var/cache/modules/networkd", "root path of the module")
 	flag.StringVar(&broker, "broker", redisSocket, "connection string to broker")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
+	flag.IntVar(&routeTable, "route-table", nr.DefaultRouteTable, "routing table of the network resources overlay routes, 0 to use the main table")
+
+	flag.IntVar(&protection.ConntrackMax, "conntrack-max", protection.ConntrackMax, "maximum number of tracked connections, 0 to keep the kernel default")
+	flag.UintVar(&protection.SynRate, "syn-rate", protection.SynRate, "new TCP connections per second accepted from a single source on the public namespace, 0 to disable")
+	flag.UintVar(&protection.SynBurst, "syn-burst", protection.SynBurst, "new TCP connections a single source can open above syn-rate")
+	flag.UintVar(&protection.ConnLimit, "conn-limit", protection.ConnLimit, "concurrent connections of a single source on the public namespace, 0 to disable")
 
 	flag.Parse()
 	if ver {
 		version.ShowAndExit(false)
 	}
 
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
 	if err := bootstrap.DefaultBridgeValid(); err != nil {
 		log.Fatal().Err(err).Msg("invalid setup")
 	}
 
+	offlineConfig, err := offline.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid offline configuration")
+	}
+
+	// an offline node has no explorer to publish its interfaces to, nor to
+	// read its public config from
+	var directory client.Directory
+	if !offlineConfig.Enabled {
+		directory, err = explorerClient()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to connect to BCDB")
+		}
+	}
+
 	client, err := zbus.NewRedisClient(broker)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to connect to zbus broker")
 	}
 
-	directory, err := explorerClient()
+	// wait for the modules we call to serve requests, we restart
+	// when one of them restarts
+	deps, err := startup.New(module, client)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to connect to BCDB")
+		log.Fatal().Err(err).Msg("unknown module dependencies")
+	}
+	if err := deps.Wait(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to wait for dependencies")
 	}
 
 	identity := stubs.NewIdentityManagerStub(client)
-	nodeID := identity.NodeID()
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get node identity")
+	}
+
+	flags := stubs.NewFeatureFlagsStub(client)
+	if !features.Check(flags, features.PublicProtection) {
+		log.Info().Msg("public namespace protection is disabled")
+		protection = network.PublicProtection{}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -68,25 +129,40 @@ func main() {
 		log.Info().Msg("shutting down")
 	})
 
+	if err := tracing.Init(ctx, module, broker); err != nil {
+		log.Error().Err(err).Msg("invalid tracing configuration, spans are not exported")
+	}
+	go func() {
+		// the server stops and the module exits once the running calls
+		// are done, zinit then restarts it
+		if err := deps.Watch(ctx); err != nil {
+			log.Error().Err(err).Msg("restarting module")
+			cancel()
+		}
+	}()
+
 	// already sends all the interfaces detail we find
 	// this won't contains the ndmz IP yet, but this is OK.
 	ifaces, err := getLocalInterfaces()
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to read local network interfaces")
 	}
-	if err := publishIfaces(ifaces, nodeID, directory); err != nil {
-		log.Fatal().Err(err).Msg("failed to publish network interfaces to BCDB")
-	}
-
 	ifaceVersion := -1
+	var exitIface *types.PubIface
 
-	exitIface, err := getPubIface(directory, nodeID.Identity())
-	if err == nil {
-		if err := configurePubIface(exitIface, nodeID); err != nil {
-			log.Error().Err(err).Msg("failed to configure public interface")
-			os.Exit(1)
+	if directory != nil {
+		if err := publishIfaces(ifaces, nodeID, directory); err != nil {
+			log.Fatal().Err(err).Msg("failed to publish network interfaces to BCDB")
+		}
+
+		exitIface, err = getPubIface(directory, nodeID.Identity())
+		if err == nil {
+			if err := configurePubIface(exitIface, nodeID, protection); err != nil {
+				log.Error().Err(err).Msg("failed to configure public interface")
+				os.Exit(1)
+			}
+			ifaceVersion = exitIface.Version
 		}
-		ifaceVersion = exitIface.Version
 	}
 
 	// Try to create ndmz. we retry forever since networkd cannot start without it
@@ -105,33 +181,118 @@ func main() {
 	}
 	ifaces = append(ifaces, ndmzIfaces...)
 
-	if err := publishIfaces(ifaces, nodeID, directory); err != nil {
-		log.Fatal().Err(err).Msg("failed to publish ndmz network interfaces to BCDB")
+	if directory != nil {
+		if err := publishIfaces(ifaces, nodeID, directory); err != nil {
+			log.Fatal().Err(err).Msg("failed to publish ndmz network interfaces to BCDB")
+		}
 	}
 
-	// Start watcher for public NICs configuration
-	go startPublicIfaceUpdate(ctx, nodeID, ifaceVersion, directory)
-
-	// watch modification of the adress on the nic so we can update the explorer
-	// with eventual new values
-	go startAddrWatch(ctx, nodeID, directory, ifaces)
-
-	log.Info().Msg("start zbus server")
 	if err := os.MkdirAll(root, 0750); err != nil {
 		log.Fatal().Err(err).Msgf("fail to create module root")
 	}
 
-	networker, err := network.NewNetworker(identity, directory, root)
+	log.Info().Msg("start dns cache")
+	dnsConfig, err := dns.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid dns cache configuration, resolving from the root servers")
+	}
+	if !features.Check(flags, features.DNSCache) {
+		dnsConfig.Disabled = true
+	}
+
+	z, err := zinit.New("")
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to zinit")
+	}
+	defer z.Close()
+
+	dnsCache, err := dns.NewCache(filepath.Join(root, "dns"), dnsConfig, z, ndmz.NetNSNDMZ)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create dns cache")
+	}
+	go dnsCache.Run(ctx)
+
+	bgpConfig, err := bgp.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid bgp configuration, public prefixes are not announced")
+		bgpConfig = bgp.Config{}
+	}
+
+	speaker, err := bgp.NewSpeaker(filepath.Join(root, "bgp"), bgpConfig, z)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create bgp speaker")
+	}
+	if exitIface != nil {
+		log.Info().Msg("announce public prefixes")
+		if err := speaker.Announce(exitIface); err != nil {
+			log.Error().Err(err).Msg("failed to announce public prefixes")
+		}
+	}
+
+	flowConfig, err := flow.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid flow export configuration, the flows are not exported")
+		flowConfig = flow.Config{}
+	}
+
+	exporter := flow.NewExporter(flowConfig, ndmz.NetNSNDMZ, ndmz.NRSubnets())
+	if exitIface != nil {
+		exporter.Start(ctx)
+	}
+
+	if proxyConfig, err := proxy.ConfigFromParams(kernel.GetParams()); err == nil && proxyConfig.Enabled() {
+		// the ntp servers can't be reached through the proxy
+		log.Info().Str("proxy", proxyConfig.URL.Host).Msg("start clock sync through the proxy")
+		go timesync.Run(ctx, timesync.DefaultURL)
+	}
+
+	// the public interface is configured with SLAAC if it has no static
+	// IPv6 address, the routers can ask for DHCPv6 on top
+	go network.WatchPublicAutoconf(ctx, z)
+
+	if directory != nil {
+		// Start watcher for public NICs configuration
+		go startPublicIfaceUpdate(ctx, nodeID, ifaceVersion, directory, protection, dnsCache, speaker, exporter)
+
+		// watch modification of the adress on the nic so we can update the explorer
+		// with eventual new values
+		go startAddrWatch(ctx, nodeID, directory, ifaces)
+	}
+
+	offloads, err := offload.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid offload configuration, the interfaces keep the kernel defaults")
+		offloads = offload.Config{}
+	}
+
+	log.Info().Msg("start zbus server")
+	var inflight utils.InFlight
+	networker, err := network.NewNetworker(identity, directory, root, &inflight, routeTable, offloads)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error creating network manager")
 	}
 
-	if err := startServer(ctx, broker, networker); err != nil {
+	go network.WatchEndpoints(ctx, networker)
+	go network.WatchNeighbors(ctx, networker)
+	go network.WatchLinks(ctx, networker)
+	go network.WatchGuards(ctx, networker)
+
+	if err := startServer(ctx, broker, networker, dnsCache); err != nil {
 		log.Fatal().Err(err).Msg("unexpected error")
 	}
+
+	// the server doesn't accept new requests anymore, give the running
+	// operations a chance to finish (or roll back) before exiting
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer drainCancel()
+
+	log.Info().Int("in-flight", inflight.Running()).Msg("draining in-flight operations")
+	if err := inflight.Drain(drainCtx); err != nil {
+		log.Error().Err(err).Int("in-flight", inflight.Running()).Msg("in-flight operations didn't finish in time")
+	}
 }
 
-func startServer(ctx context.Context, broker string, networker pkg.Networker) error {
+func startServer(ctx context.Context, broker string, networker pkg.Networker, dnsCache pkg.DNSCache) error {
 
 	server, err := zbus.NewRedisServer(module, broker, 1)
 	if err != nil {
@@ -139,6 +300,12 @@ func startServer(ctx context.Context, broker string, networker pkg.Networker) er
 	}
 
 	server.Register(zbus.ObjectID{Name: module, Version: "0.0.1"}, networker)
+	// advertises the networks can be sent in the wire format
+	server.Register(zbus.ObjectID{Name: module, Version: pkg.NetworkWireVersion}, networker)
+	server.Register(zbus.ObjectID{Name: "dns", Version: "0.0.1"}, dnsCache)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	log.Info().
 		Str("broker", broker).
@@ -175,7 +342,7 @@ func startAddrWatch(ctx context.Context, nodeID pkg.Identifier, cl client.Direct
 	backoff.Retry(f, bo)
 }
 
-func startPublicIfaceUpdate(ctx context.Context, nodeID pkg.Identifier, version int, directory client.Directory) {
+func startPublicIfaceUpdate(ctx context.Context, nodeID pkg.Identifier, version int, directory client.Directory, protection network.PublicProtection, dnsCache *dns.Cache, speaker *bgp.Speaker, exporter *flow.Exporter) {
 	ch := watchPubIface(ctx, nodeID, directory, version)
 
 	for {
@@ -193,7 +360,7 @@ func startPublicIfaceUpdate(ctx context.Context, nodeID pkg.Identifier, version
 				continue
 			}
 
-			if err := configurePubIface(iface, nodeID); err != nil {
+			if err := configurePubIface(iface, nodeID, protection); err != nil {
 				log.Error().Err(err).Msg("error configuring public IP")
 				continue
 			}
@@ -203,6 +370,18 @@ func startPublicIfaceUpdate(ctx context.Context, nodeID pkg.Identifier, version
 				continue
 			}
 
+			// the cache of the workloads was running in the deleted ndmz
+			if err := dnsCache.RestartWorkloads(); err != nil {
+				log.Error().Err(err).Msg("failed to restart the dns cache of the workloads")
+			}
+
+			if err := speaker.Announce(iface); err != nil {
+				log.Error().Err(err).Msg("failed to announce public prefixes")
+			}
+
+			// the flows of the deleted ndmz are not followed anymore
+			exporter.Start(ctx)
+
 		case <-ctx.Done():
 			return
 		}
@@ -75,7 +75,7 @@ func watchPubIface(ctx context.Context, nodeID pkg.Identifier, dir client.Direct
 	return ch
 }
 
-func configurePubIface(iface *types.PubIface, nodeID pkg.Identifier) error {
+func configurePubIface(iface *types.PubIface, nodeID pkg.Identifier, protection network.PublicProtection) error {
 	cleanup := func() error {
 		pubNs, err := namespace.GetByName(types.PublicNamespace)
 		if err != nil {
@@ -94,5 +94,10 @@ func configurePubIface(iface *types.PubIface, nodeID pkg.Identifier) error {
 		return errors.Wrap(err, "failed to configure public namespace")
 	}
 
+	if err := network.ProtectPublicNS(protection); err != nil {
+		_ = cleanup()
+		return errors.Wrap(err, "failed to protect public namespace")
+	}
+
 	return nil
 }
@@ -10,12 +10,24 @@ import (
 	"github.com/cenkalti/backoff/v3"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/cancel"
+	"github.com/threefoldtech/zos/pkg/critical"
 	"github.com/threefoldtech/zos/pkg/environment"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/offline"
+	"github.com/threefoldtech/zos/pkg/provision/cron"
 	"github.com/threefoldtech/zos/pkg/provision/explorer"
 	"github.com/threefoldtech/zos/pkg/provision/primitives"
 	"github.com/threefoldtech/zos/pkg/provision/primitives/cache"
+	"github.com/threefoldtech/zos/pkg/provision/probe"
+	"github.com/threefoldtech/zos/pkg/ratelimit"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
 
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 	"github.com/threefoldtech/zos/pkg/utils"
 
 	"github.com/rs/zerolog"
@@ -38,22 +50,34 @@ func main() {
 		storageDir   string
 		debug        bool
 		ver          bool
+		gcPolicy     string
+		gcInterval   time.Duration
 	)
 
 	flag.StringVar(&storageDir, "root", "/var/cache/modules/provisiond", "root path of the module")
 	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
 	flag.BoolVar(&debug, "debug", false, "enable debug logging")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
+	flag.StringVar(&gcPolicy, "gc-policy", string(primitives.GCPolicyQuarantine), "what to do with the volumes and namespaces without reservation: report, quarantine or remove")
+	flag.DurationVar(&gcInterval, "gc-interval", time.Hour, "interval between two collections of volumes and namespaces without reservation")
 
 	flag.Parse()
 	if ver {
 		version.ShowAndExit(false)
 	}
 
-	// Default level for this example is info, unless debug flag is present
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
+	// Default level for this example is info, unless debug flag is present,
+	// the level can be changed at runtime with zoscli
+	level := zerolog.InfoLevel
 	if debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		level = zerolog.DebugLevel
+	}
+	if err := logging.Control().SetLevel("", level.String()); err != nil {
+		log.Error().Err(err).Msg("failed to set log level")
 	}
 
 	// keep checking if limited-cache flag is set
@@ -85,13 +109,29 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to connect to message broker")
 	}
-	zbusCl, err := zbus.NewRedisClient(msgBrokerCon)
+	redis, err := zbus.NewRedisClient(msgBrokerCon)
 	if err != nil {
 		log.Fatal().Err(err).Msg("fail to connect to message broker server")
 	}
+	// the networks of the reservations are sent in the wire
+	// format once networkd supports it
+	zbusCl := stubs.NewWireClient(redis)
+
+	// wait for the modules we call to serve requests, we restart
+	// when one of them restarts
+	deps, err := startup.New(module, zbusCl)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unknown module dependencies")
+	}
+	if err := deps.Wait(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to wait for dependencies")
+	}
 
 	identity := stubs.NewIdentityManagerStub(zbusCl)
-	nodeID := identity.NodeID()
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get node identity")
+	}
 
 	// block until networkd is ready to serve request from zbus
 	// this is used to prevent uptime and online status to the explorer if the node is not in a fully ready
@@ -105,12 +145,6 @@ func main() {
 		log.Error().Err(err).Msgf("networkd is not ready yet")
 	})
 
-	// to get reservation from tnodb
-	e, err := app.ExplorerClient()
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to instantiate BCDB client")
-	}
-
 	// keep track of resource unnits reserved and amount of workloads provisionned
 	statser := &primitives.Counters{}
 
@@ -122,23 +156,112 @@ func main() {
 	// update stats from the local reservation cache
 	localStore.Sync(statser)
 
-	provisioner := primitives.NewProvisioner(localStore, zbusCl)
+	probes := probe.NewManager(probe.BrokerCheck(stubs.NewBrokerStub(zbusCl)))
+
+	// the scheduled jobs survive restarts of the node
+	jobs, err := cron.NewScheduler(filepath.Join(storageDir, "jobs"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create job scheduler")
+	}
+
+	provisioner := primitives.NewProvisioner(localStore, zbusCl, probes, jobs)
+
+	// restore the readiness probes of the workloads already deployed
+	reservations, err := localStore.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list local reservations")
+	}
+	for _, r := range reservations {
+		if err := provisioner.WatchProbes(r); err != nil {
+			log.Error().Err(err).Str("id", r.ID).Msg("failed to restore readiness probes")
+		}
+	}
+
+	gc, err := primitives.NewGC(localStore, zbusCl, primitives.GCPolicy(gcPolicy))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid garbage collector configuration")
+	}
+
+	auditLog, err := audit.New(audit.DefaultRoot, "provision", identity)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open audit log, reservations won't be audited")
+	}
+
+	// reservations fetched from the explorer are kept on disk until processed
+	// and results are stored until the explorer can be reached again, so the node
+	// keeps working while it has no connection to the grid
+	queue, err := provision.NewQueue(filepath.Join(storageDir, "queue"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create reservation queue")
+	}
+
+	offlineConfig, err := offline.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid offline configuration")
+	}
+
+	var (
+		source   provision.ReservationSource
+		feedback provision.Feedbacker
+		run      func(ctx context.Context)
+	)
+
+	if offlineConfig.Enabled {
+		// the reservations are read from the bundle, and the results are
+		// written back to it
+		bundle := offline.NewBundle(offlineConfig)
+		results, err := offline.NewFeedback(bundle, filepath.Join(storageDir, "results"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create offline results")
+		}
+
+		log.Info().Str("bundle", bundle.String()).Msg("node is offline, reading reservations from the bundle")
+		source = queue.Source(offline.ReservationSource(bundle, nodeID))
+		feedback = results
+		run = func(ctx context.Context) { results.Run(ctx, nodeID, time.Minute) }
+	} else {
+		// to get reservation from tnodb
+		e, err := app.ExplorerClient()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to instantiate BCDB client")
+		}
+
+		outbox, err := provision.NewStoreAndForward(explorer.NewFeedback(e, primitives.ResultToSchemaType), filepath.Join(storageDir, "outbox"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create results outbox")
+		}
+
+		source = queue.Source(provision.PollSource(explorer.NewPoller(e, primitives.WorkloadToProvisionType, primitives.ProvisionOrder), nodeID))
+		feedback = outbox
+		run = func(ctx context.Context) { outbox.Run(ctx, time.Minute) }
+	}
 
 	engine := provision.New(provision.EngineOps{
 		NodeID: nodeID.Identity(),
 		Cache:  localStore,
 		Source: provision.CombinedSource(
-			provision.PollSource(explorer.NewPoller(e, primitives.WorkloadToProvisionType, primitives.ProvisionOrder), nodeID),
+			source,
 			provision.NewDecommissionSource(localStore),
 		),
 		Provisioners:   provisioner.Provisioners,
+		Updaters:       provisioner.Updaters,
 		Decomissioners: provisioner.Decommissioners,
-		Feedback:       explorer.NewFeedback(e, primitives.ResultToSchemaType),
+		Feedback:       feedback,
+		Queue:          queue,
 		Signer:         identity,
 		Statser:        statser,
+		// allow bursts of 50 workloads per user then 1 every second
+		Limiter:  ratelimit.New(1, 50),
+		Audit:    auditLog,
+		Critical: critical.New(critical.DefaultRoot, "provisiond"),
 	})
 
 	server.Register(zbus.ObjectID{Name: module, Version: "0.0.1"}, pkg.ProvisionMonitor(engine))
+	server.Register(zbus.ObjectID{Name: "readiness", Version: "0.0.1"}, pkg.ReadinessMonitor(probes))
+	server.Register(zbus.ObjectID{Name: "jobs", Version: "0.0.1"}, pkg.JobMonitor(jobs))
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	log.Info().
 		Str("broker", msgBrokerCon).
@@ -150,6 +273,23 @@ func main() {
 		log.Info().Msg("shutting down")
 	})
 
+	if err := tracing.Init(ctx, module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("invalid tracing configuration, spans are not exported")
+	}
+
+	if err := cancel.Init(module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("failed to propagate call cancellations")
+	}
+	go func() {
+		if err := deps.Watch(ctx); err != nil {
+			log.Fatal().Err(err).Msg("restarting module")
+		}
+	}()
+
+	go gc.Run(ctx, gcInterval)
+	go run(ctx)
+	go jobs.Run(ctx, provisioner.JobRunner())
+
 	go func() {
 		if err := server.Run(ctx); err != nil && err != context.Canceled {
 			log.Fatal().Err(err).Msg("unexpected error")
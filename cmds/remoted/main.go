@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/remote"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+func main() {
+	app.Initialize()
+
+	var (
+		msgBrokerCon string
+		iface        string
+		port         uint
+		recordings   string
+		ver          bool
+	)
+
+	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
+	flag.StringVar(&iface, "iface", types.DefaultBridge, "management interface to listen on")
+	flag.UintVar(&port, "port", remote.DefaultPort, "port to listen on")
+	flag.StringVar(&recordings, "recordings", remote.DefaultRecordings, "directory of the session recordings")
+	flag.BoolVar(&ver, "v", false, "show version and exit")
+
+	flag.Parse()
+	if ver {
+		version.ShowAndExit(false)
+	}
+
+	ctx, cancel := utils.WithSignal(context.Background())
+	defer cancel()
+
+	config, err := remote.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid remote access configuration")
+	}
+
+	if len(config.Keys) == 0 {
+		log.Info().Msg("no remote key set by the farmer, remote access is disabled")
+		<-ctx.Done()
+		return
+	}
+
+	client, err := zbus.NewRedisClient(msgBrokerCon)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to zbus")
+	}
+
+	identity := stubs.NewIdentityManagerStub(client)
+	auditLog, err := audit.New(audit.DefaultRoot, "remote", identity)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to open audit log, sessions can't be recorded")
+	}
+
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get node identity")
+	}
+
+	server, err := remote.NewServer(nodeID.Identity(), config, recordings, auditLog, identity)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create remote access server")
+	}
+
+	ip, err := ifaceutil.GetIPv4(iface)
+	if err != nil {
+		log.Fatal().Err(err).Str("iface", iface).Msg("failed to find management interface address")
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen")
+	}
+
+	log.Info().
+		Str("address", l.Addr().String()).
+		Bool("shell", config.Shell).
+		Strs("commands", config.Commands).
+		Msg("starting remote access")
+
+	if err := server.Serve(ctx, l); err != nil {
+		log.Fatal().Err(err).Msg("remote access server failed")
+	}
+}
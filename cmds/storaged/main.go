@@ -3,12 +3,20 @@ package main
 import (
 	"context"
 	"flag"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
 	"github.com/threefoldtech/zos/pkg/storage"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 	"github.com/threefoldtech/zos/pkg/utils"
 	"github.com/threefoldtech/zos/pkg/version"
 )
@@ -16,6 +24,10 @@ import (
 const (
 	redisSocket = "unix:///var/run/redis.sock"
 	module      = "storage"
+
+	// drainTimeout is the maximum time we wait for in-flight
+	// allocations to finish on shutdown
+	drainTimeout = 2 * time.Minute
 )
 
 func main() {
@@ -24,11 +36,13 @@ func main() {
 	var (
 		msgBrokerCon string
 		workerNr     uint
+		ownerShare   uint
 		ver          bool
 	)
 
 	flag.StringVar(&msgBrokerCon, "broker", redisSocket, "Connection string to the message broker")
 	flag.UintVar(&workerNr, "workers", 1, "Number of workers")
+	flag.UintVar(&ownerShare, "owner-share", 0, "percentage of a storage pool the volumes of a single user can use, 0 for no limit")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
 
 	flag.Parse()
@@ -36,19 +50,49 @@ func main() {
 		version.ShowAndExit(false)
 	}
 
-	storageModule, err := storage.New()
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
+	client, err := zbus.NewRedisClient(msgBrokerCon)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fail to connect to message broker server")
+	}
+
+	// a failing swap must not prevent the node from serving its storage
+	if zram, err := storage.ZramConfigFromParams(kernel.GetParams()); err != nil {
+		log.Error().Err(err).Msg("invalid zram swap configuration")
+	} else if err := storage.SetupZram(zram, stubs.NewBrokerStub(client)); err != nil {
+		log.Error().Err(err).Msg("failed to set up zram swap")
+	}
+
+	var inflight utils.InFlight
+	storageModule, err := storage.New(&inflight)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize storage module")
 	}
 
+	if err := storage.SetOwnerShare(storageModule, ownerShare); err != nil {
+		log.Fatal().Err(err).Msg("invalid owner share")
+	}
+
+	if swap, err := storage.SwapSizeFromParams(kernel.GetParams()); err != nil {
+		log.Error().Err(err).Msg("invalid swap configuration")
+	} else if err := storage.SetupSwap(storageModule, swap); err != nil {
+		log.Error().Err(err).Msg("failed to set up encrypted swap")
+	}
+
 	server, err := zbus.NewRedisServer(module, msgBrokerCon, workerNr)
 	if err != nil {
 		log.Fatal().Err(err).Msg("fail to connect to message broker server")
 	}
 
 	server.Register(zbus.ObjectID{Name: "storage", Version: "0.0.1"}, storageModule)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
-	vdiskModule, err := storage.NewVDiskModule(storageModule)
+	vdiskModule, err := storage.NewVDiskModule(storageModule, &inflight)
 	if err != nil {
 		log.Error().Err(err).Bool("limited-cache", app.CheckFlag(app.LimitedCache)).Msg("failed to initialize virtual disk module")
 	} else {
@@ -65,7 +109,40 @@ func main() {
 		log.Info().Msg("shutting down")
 	})
 
+	if err := tracing.Init(ctx, module, msgBrokerCon); err != nil {
+		log.Error().Err(err).Msg("invalid tracing configuration, spans are not exported")
+	}
+
+	// identityd starts after storaged, the audit entries are signed
+	// once it serves
+	go func() {
+		if err := startup.WaitFor(ctx, client, "identityd"); err != nil {
+			log.Error().Err(err).Msg("identityd not available, audit entries are not signed")
+			return
+		}
+
+		if err := storage.SetAuditSigner(storageModule, stubs.NewIdentityManagerStub(client)); err != nil {
+			log.Error().Err(err).Msg("failed to sign audit log")
+		}
+	}()
+
+	go storage.WatchDisks(ctx, storageModule)
+	go storage.WatchUsage(ctx, storageModule)
+	go storage.PruneCache(ctx)
+
 	if err := server.Run(ctx); err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("unexpected error")
 	}
+
+	// the server doesn't accept new requests anymore, give the running
+	// allocations a chance to finish before flushing the pools
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer drainCancel()
+
+	log.Info().Int("in-flight", inflight.Running()).Msg("draining in-flight operations")
+	if err := inflight.Drain(drainCtx); err != nil {
+		log.Error().Err(err).Int("in-flight", inflight.Running()).Msg("in-flight operations didn't finish in time")
+	}
+
+	syscall.Sync()
 }
@@ -6,6 +6,10 @@ import (
 	"os"
 
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/sandbox"
+	"github.com/threefoldtech/zos/pkg/startup"
+	"github.com/threefoldtech/zos/pkg/stubs"
 	"github.com/threefoldtech/zos/pkg/utils"
 	"github.com/threefoldtech/zos/pkg/vm"
 
@@ -37,6 +41,10 @@ func main() {
 		version.ShowAndExit(false)
 	}
 
+	if err := sandbox.Enter(module); err != nil {
+		log.Fatal().Err(err).Msg("failed to sandbox module")
+	}
+
 	if err := os.MkdirAll(moduleRoot, 0755); err != nil {
 		log.Fatal().Err(err).Str("root", moduleRoot).Msg("Failed to create module root")
 	}
@@ -52,6 +60,9 @@ func main() {
 	}
 
 	server.Register(zbus.ObjectID{Name: "manager", Version: "0.0.1"}, mod)
+	server.Register(startup.ObjectID, startup.NewInstance())
+	server.Register(logging.ObjectID, logging.Control())
+	server.Register(stubs.FailuresObjectID, stubs.FailureCounters())
 
 	log.Info().
 		Str("broker", msgBrokerCon).
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+// streamTimeout is how long we wait for the first value of a zbus stream
+const streamTimeout = 5 * time.Second
+
+// eventsLimit is the number of audit entries shown on the console
+const eventsLimit = 50
+
+// console serves a read-only view of the node. All the data
+// comes from the zbus APIs of the other modules
+type console struct {
+	client zbus.Client
+}
+
+func newConsole(client zbus.Client) *console {
+	return &console{client: client}
+}
+
+// Identity of the node
+type Identity struct {
+	NodeID  string `json:"node_id"`
+	FarmID  string `json:"farm_id"`
+	Version string `json:"version"`
+}
+
+// Capacity of the node
+type Capacity struct {
+	CPU    int    `json:"cpu"`
+	Memory uint64 `json:"memory"`
+	SSD    uint64 `json:"ssd"`
+	HDD    uint64 `json:"hdd"`
+}
+
+// Overview is everything shown on the console page
+type Overview struct {
+	Identity    Identity            `json:"identity"`
+	Capacity    Capacity            `json:"capacity"`
+	Pools       pkg.PoolsStats      `json:"pools"`
+	BrokenPools []pkg.BrokenPool    `json:"broken_pools"`
+	Networks    []pkg.InterfaceName `json:"networks"`
+	Events      []pkg.AuditEntry    `json:"events"`
+	Errors      map[string]string   `json:"errors,omitempty"`
+}
+
+func (c *console) router() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", c.index)
+	mux.HandleFunc("/api/overview", c.overview)
+
+	return readOnly(mux)
+}
+
+// readOnly rejects all requests that are not GET
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read-only console", http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// collect calls fn and records its error under name
+func collect(errs map[string]string, name string, fn func() error) {
+	if err := fn(); err != nil {
+		errs[name] = err.Error()
+	}
+}
+
+// drain calls recv until it returns false (channel closed)
+func drain(recv func() bool) {
+	for recv() {
+	}
+}
+
+func (c *console) load(ctx context.Context) Overview {
+	ov := Overview{Errors: make(map[string]string)}
+
+	collect(ov.Errors, "identity", func() error {
+		identity := stubs.NewIdentityManagerStub(c.client)
+		id, err := identity.NodeID()
+		if err != nil {
+			return err
+		}
+		ov.Identity.NodeID = id.Identity()
+		ov.Identity.Version = version.Current().String()
+		farm, err := identity.FarmID()
+		if err != nil {
+			ov.Identity.FarmID = "not attached to a farm"
+			return nil
+		}
+		ov.Identity.FarmID = fmt.Sprint(farm)
+		return nil
+	})
+
+	collect(ov.Errors, "capacity", func() error {
+		ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+		defer cancel()
+
+		monitor := stubs.NewSystemMonitorStub(c.client)
+		cpu, err := monitor.CPU(ctx)
+		if err != nil {
+			return err
+		}
+		mem, err := monitor.Memory(ctx)
+		if err != nil {
+			return err
+		}
+		// the streams keep sending until the context is canceled, make
+		// sure the stubs are never blocked on a value we won't read
+		defer func() {
+			cancel()
+			go drain(func() bool { _, ok := <-cpu; return ok })
+			go drain(func() bool { _, ok := <-mem; return ok })
+		}()
+
+		select {
+		case stats := <-cpu:
+			ov.Capacity.CPU = len(stats)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case stats := <-mem:
+			ov.Capacity.Memory = stats.Total
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		storage := stubs.NewStorageModuleStub(c.client)
+		if ov.Capacity.SSD, err = storage.Total(pkg.SSDDevice); err != nil {
+			return err
+		}
+		if ov.Capacity.HDD, err = storage.Total(pkg.HDDDevice); err != nil {
+			return err
+		}
+		return nil
+	})
+
+	collect(ov.Errors, "pools", func() error {
+		ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+		defer cancel()
+
+		storage := stubs.NewStorageModuleStub(c.client)
+		broken, err := storage.BrokenPools()
+		if err != nil {
+			return err
+		}
+		ov.BrokenPools = broken
+
+		ch, err := storage.Monitor(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			cancel()
+			go drain(func() bool { _, ok := <-ch; return ok })
+		}()
+
+		select {
+		case ov.Pools = <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	collect(ov.Errors, "networks", func() (err error) {
+		ov.Networks, err = stubs.NewNetworkerStub(c.client).NamesAudit()
+		return err
+	})
+
+	collect(ov.Errors, "events", func() (err error) {
+		ov.Events, err = stubs.NewAuditorStub(c.client).Query(pkg.AuditFilter{Limit: eventsLimit})
+		return err
+	})
+
+	return ov
+}
+
+func (c *console) overview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.load(r.Context())); err != nil {
+		log.Error().Err(err).Msg("failed to encode overview")
+	}
+}
+
+func (c *console) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, c.load(r.Context())); err != nil {
+		log.Error().Err(err).Msg("failed to render console page")
+	}
+}
+
+var page = template.Must(template.New("console").Funcs(template.FuncMap{
+	"gib": func(v uint64) string {
+		return fmt.Sprintf("%.2f GiB", float64(v)/(1024*1024*1024))
+	},
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Zero-OS {{.Identity.NodeID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #eee; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Zero-OS</h1>
+{{range $name, $err := .Errors}}<p class="error">{{$name}}: {{$err}}</p>{{end}}
+
+<h2>Identity</h2>
+<table>
+<tr><th>Node ID</th><td>{{.Identity.NodeID}}</td></tr>
+<tr><th>Farm ID</th><td>{{.Identity.FarmID}}</td></tr>
+<tr><th>Version</th><td>{{.Identity.Version}}</td></tr>
+</table>
+
+<h2>Capacity</h2>
+<table>
+<tr><th>CPU</th><td>{{.Capacity.CPU}}</td></tr>
+<tr><th>Memory</th><td>{{gib .Capacity.Memory}}</td></tr>
+<tr><th>SSD</th><td>{{gib .Capacity.SSD}}</td></tr>
+<tr><th>HDD</th><td>{{gib .Capacity.HDD}}</td></tr>
+</table>
+
+<h2>Storage pools</h2>
+<table>
+<tr><th>Pool</th><th>Total</th><th>Used</th></tr>
+{{range $name, $stats := .Pools}}<tr><td>{{$name}}</td><td>{{gib $stats.Total}}</td><td>{{gib $stats.Used}}</td></tr>
+{{end}}
+{{range .BrokenPools}}<tr class="error"><td>{{.Label}}</td><td colspan="2">{{.Err}}</td></tr>
+{{end}}
+</table>
+
+<h2>Networks</h2>
+<table>
+<tr><th>Network</th><th>Prefix</th><th>Kind</th><th>Name</th></tr>
+{{range .Networks}}<tr{{if .Collision}} class="error"{{end}}><td>{{.NetID}}</td><td>{{.Prefix}}</td><td>{{.Kind}}</td><td>{{.Name}}</td></tr>
+{{end}}
+</table>
+
+<h2>Recent events</h2>
+<table>
+<tr><th>Time</th><th>Module</th><th>Operation</th><th>Object</th><th>Error</th></tr>
+{{range .Events}}<tr><td>{{time .Time}}</td><td>{{.Module}}</td><td>{{.Operation}}</td><td>{{.Object}}</td><td class="error">{{.Error}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
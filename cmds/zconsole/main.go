@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+func main() {
+	app.Initialize()
+
+	var (
+		msgBrokerCon string
+		iface        string
+		port         uint
+		ver          bool
+	)
+
+	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
+	flag.StringVar(&iface, "iface", types.DefaultBridge, "management interface to listen on")
+	flag.UintVar(&port, "port", 8070, "port to listen on")
+	flag.BoolVar(&ver, "v", false, "show version and exit")
+
+	flag.Parse()
+	if ver {
+		version.ShowAndExit(false)
+	}
+
+	client, err := zbus.NewRedisClient(msgBrokerCon)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to zbus")
+	}
+
+	ip, err := ifaceutil.GetIPv4(iface)
+	if err != nil {
+		log.Fatal().Err(err).Str("iface", iface).Msg("failed to find management interface address")
+	}
+
+	server := &http.Server{
+		Addr:         net.JoinHostPort(ip.String(), fmt.Sprint(port)),
+		Handler:      newConsole(client).router(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	ctx, cancel := utils.WithSignal(context.Background())
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdown); err != nil {
+			log.Error().Err(err).Msg("failed to shutdown console")
+		}
+	}()
+
+	log.Info().Str("address", server.Addr).Msg("starting node console")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal().Err(err).Msg("console server failed")
+	}
+}
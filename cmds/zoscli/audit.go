@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var auditCommand = cli.Command{
+	Name:  "audit",
+	Usage: "query the audit log of the node",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "module, m",
+			Usage: "only show the entries of this module",
+		},
+		cli.StringFlag{
+			Name:  "object, o",
+			Usage: "only show the entries affecting this object",
+		},
+		cli.DurationFlag{
+			Name:  "since, s",
+			Usage: "only show the entries recorded in this duration",
+		},
+		cli.IntFlag{
+			Name:  "limit, l",
+			Usage: "max number of entries",
+			Value: 50,
+		},
+		cli.BoolFlag{
+			Name:  "verify",
+			Usage: "verify the chain and the signatures of the entries of the module (all the modules if not set) instead",
+		},
+	},
+	Action: action(audit),
+}
+
+func audit(c *cli.Context, cl zbus.Client) error {
+	if c.Bool("verify") {
+		if err := stubs.NewAuditorStub(cl).Verify(c.String("module")); err != nil {
+			return err
+		}
+
+		fmt.Println("audit log verified")
+		return nil
+	}
+
+	filter := pkg.AuditFilter{
+		Module: c.String("module"),
+		Object: c.String("object"),
+		Limit:  c.Int("limit"),
+	}
+
+	if since := c.Duration("since"); since > 0 {
+		filter.Since = time.Now().Add(-since)
+	}
+
+	entries, err := stubs.NewAuditorStub(cl).Query(filter)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(entries)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var benchmarkCommand = cli.Command{
+	Name:  "benchmark",
+	Usage: "run the node benchmarks and manage the scorecards",
+	Subcommands: []cli.Command{
+		{
+			Name:   "run",
+			Usage:  "run the benchmarks, it takes a few minutes",
+			Action: action(benchmarkRun),
+		},
+		{
+			Name:   "list",
+			Usage:  "list the stored scorecards",
+			Action: action(benchmarkList),
+		},
+		{
+			Name:   "report",
+			Usage:  "send the last scorecard to the directory",
+			Action: action(benchmarkReport),
+		},
+	},
+}
+
+func benchmarkRun(c *cli.Context, cl zbus.Client) error {
+	scorecard, err := stubs.NewBenchmarkerStub(cl).Run()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(scorecard)
+}
+
+func benchmarkList(c *cli.Context, cl zbus.Client) error {
+	scorecards, err := stubs.NewBenchmarkerStub(cl).Scorecards()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(scorecards)
+}
+
+func benchmarkReport(c *cli.Context, cl zbus.Client) error {
+	return stubs.NewBenchmarkerStub(cl).Report()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var userFlag = cli.StringFlag{
+	Name:  "user, u",
+	Usage: "ID of the owner of the reservation",
+}
+
+var containerCommand = cli.Command{
+	Name:  "container",
+	Usage: "inspect the containers of the reservations",
+	Subcommands: []cli.Command{
+		{
+			Name:      "crashes",
+			Usage:     "show the crash reports of a container",
+			ArgsUsage: "<reservation>",
+			Flags:     []cli.Flag{userFlag},
+			Action:    action(containerCrashes),
+		},
+		{
+			Name:      "core",
+			Usage:     "save the core dump of a crash, encrypted for the owner of the reservation",
+			ArgsUsage: "<reservation> <crash>",
+			Flags: []cli.Flag{
+				userFlag,
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "file to write the encrypted core dump to",
+					Value: "core.enc",
+				},
+			},
+			Action: action(containerCore),
+		},
+		{
+			Name:      "checkpoint",
+			Usage:     "save the state of a container in its checkpoints volume",
+			ArgsUsage: "<reservation>",
+			Flags: []cli.Flag{
+				userFlag,
+				cli.BoolFlag{
+					Name:  "stop",
+					Usage: "stop the container once checkpointed",
+				},
+			},
+			Action: action(containerCheckpoint),
+		},
+	},
+}
+
+// tenantNamespace is the containerd namespace of the containers of a user
+func tenantNamespace(c *cli.Context) (string, error) {
+	user := c.String("user")
+	if user == "" {
+		return "", fmt.Errorf("user is required")
+	}
+
+	return fmt.Sprintf("ns%s", user), nil
+}
+
+func containerCrashes(c *cli.Context, cl zbus.Client) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("reservation is required")
+	}
+
+	ns, err := tenantNamespace(c)
+	if err != nil {
+		return err
+	}
+
+	reports, err := stubs.NewContainerModuleStub(cl).Crashes(ns, pkg.ContainerID(id))
+	if err != nil {
+		return err
+	}
+
+	return printJSON(reports)
+}
+
+func containerCore(c *cli.Context, cl zbus.Client) error {
+	id, crash := c.Args().Get(0), c.Args().Get(1)
+	if id == "" || crash == "" {
+		return fmt.Errorf("reservation and crash are required")
+	}
+
+	ns, err := tenantNamespace(c)
+	if err != nil {
+		return err
+	}
+
+	core, err := stubs.NewContainerModuleStub(cl).CoreDump(ns, pkg.ContainerID(id), crash, c.String("user"))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.String("output"), core, 0600)
+}
+
+func containerCheckpoint(c *cli.Context, cl zbus.Client) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("reservation is required")
+	}
+
+	ns, err := tenantNamespace(c)
+	if err != nil {
+		return err
+	}
+
+	return stubs.NewContainerModuleStub(cl).Checkpoint(ns, pkg.ContainerID(id), c.Bool("stop"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var diagCommand = cli.Command{
+	Name:   "diag",
+	Usage:  "run a set of sanity checks against the node modules",
+	Action: action(diag),
+}
+
+type check struct {
+	name string
+	fn   func(cl zbus.Client) error
+}
+
+var checks = []check{
+	{"identity", func(cl zbus.Client) error {
+		id, err := stubs.NewIdentityManagerStub(cl).NodeID()
+		if err != nil {
+			return err
+		}
+		if id.Identity() == "" {
+			return fmt.Errorf("node has no identity")
+		}
+		return nil
+	}},
+	{"network", func(cl zbus.Client) error {
+		return stubs.NewNetworkerStub(cl).Ready()
+	}},
+	{"network names", func(cl zbus.Client) error {
+		done := handover("network", "NamesAudit", "")
+		names, err := stubs.NewNetworkerStub(cl).NamesAudit()
+		done(err)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if name.Collision {
+				return fmt.Errorf("name %s of network %s collides with another network", name.Name, name.NetID)
+			}
+		}
+		return nil
+	}},
+	{"storage pools", func(cl zbus.Client) error {
+		broken, err := stubs.NewStorageModuleStub(cl).BrokenPools()
+		if err != nil {
+			return err
+		}
+		if len(broken) > 0 {
+			return fmt.Errorf("%d broken pools", len(broken))
+		}
+		return nil
+	}},
+	{"storage devices", func(cl zbus.Client) error {
+		broken, err := stubs.NewStorageModuleStub(cl).BrokenDevices()
+		if err != nil {
+			return err
+		}
+		if len(broken) > 0 {
+			return fmt.Errorf("%d broken devices", len(broken))
+		}
+		return nil
+	}},
+}
+
+func diag(c *cli.Context, cl zbus.Client) error {
+	failed := 0
+	for _, check := range checks {
+		if err := check.fn(cl); err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %s\n", check.name, err)
+			continue
+		}
+		fmt.Printf("[ OK ] %s\n", check.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var featuresCommand = cli.Command{
+	Name:   "features",
+	Usage:  "show the feature flags of the node",
+	Action: action(featuresList),
+	Subcommands: []cli.Command{
+		{
+			Name:      "enable",
+			Usage:     "enable a feature on the node, the modules using it must be restarted",
+			ArgsUsage: "<feature>",
+			Action:    action(featureSet(true)),
+		},
+		{
+			Name:      "disable",
+			Usage:     "disable a feature on the node, the modules using it must be restarted",
+			ArgsUsage: "<feature>",
+			Action:    action(featureSet(false)),
+		},
+		{
+			Name:      "reset",
+			Usage:     "make the node use the feature state of its farm and channel",
+			ArgsUsage: "<feature>",
+			Action:    action(featureReset),
+		},
+	},
+}
+
+func featuresList(c *cli.Context, cl zbus.Client) error {
+	features, err := stubs.NewFeatureFlagsStub(cl).Features()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(features)
+}
+
+func featureSet(enabled bool) func(c *cli.Context, cl zbus.Client) error {
+	return func(c *cli.Context, cl zbus.Client) error {
+		name := c.Args().First()
+		if len(name) == 0 {
+			return fmt.Errorf("feature is required")
+		}
+
+		if err := stubs.NewFeatureFlagsStub(cl).Set(name, enabled); err != nil {
+			return err
+		}
+
+		return featuresList(c, cl)
+	}
+}
+
+func featureReset(c *cli.Context, cl zbus.Client) error {
+	name := c.Args().First()
+	if len(name) == 0 {
+		return fmt.Errorf("feature is required")
+	}
+
+	if err := stubs.NewFeatureFlagsStub(cl).Reset(name); err != nil {
+		return err
+	}
+
+	return featuresList(c, cl)
+}
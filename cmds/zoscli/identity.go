@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var identityCommand = cli.Command{
+	Name:  "identity",
+	Usage: "back up and restore the node identity",
+	Subcommands: []cli.Command{
+		{
+			Name:   "backup",
+			Usage:  "split the node seed in shares encrypted to the farmer backup keys",
+			Action: action(identityBackup),
+		},
+		{
+			Name:      "restore",
+			Usage:     "restore the node seed from the decrypted shares",
+			ArgsUsage: "<hex share>...",
+			Action:    action(identityRestore),
+		},
+	},
+}
+
+func identityBackup(c *cli.Context, cl zbus.Client) error {
+	backup, err := stubs.NewIdentityBackupStub(cl).Backup()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(backup)
+}
+
+func identityRestore(c *cli.Context, cl zbus.Client) error {
+	shares := c.Args()
+	if len(shares) < 2 {
+		return fmt.Errorf("at least 2 shares are required")
+	}
+
+	return stubs.NewIdentityBackupStub(cl).Restore(shares)
+}
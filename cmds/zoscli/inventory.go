@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var inventoryCommand = cli.Command{
+	Name:   "inventory",
+	Usage:  "show the hardware and software inventory of the node",
+	Action: action(inventory),
+	Subcommands: []cli.Command{
+		{
+			Name:      "attest",
+			Usage:     "sign the inventory fingerprint for a nonce",
+			ArgsUsage: "<hex nonce>",
+			Action:    action(attest),
+		},
+	},
+}
+
+func inventory(c *cli.Context, cl zbus.Client) error {
+	inv, err := stubs.NewInventoryManagerStub(cl).Inventory()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(inv)
+}
+
+func attest(c *cli.Context, cl zbus.Client) error {
+	nonce, err := hex.DecodeString(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("invalid nonce: %s", err)
+	}
+	if len(nonce) == 0 {
+		return fmt.Errorf("nonce is required")
+	}
+
+	attestation, err := stubs.NewInventoryManagerStub(cl).Attest(nonce)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(attestation)
+}
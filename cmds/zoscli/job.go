@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var jobCommand = cli.Command{
+	Name:  "job",
+	Usage: "inspect the scheduled jobs",
+	Subcommands: []cli.Command{
+		{
+			Name:      "runs",
+			Usage:     "list the runs of a job",
+			ArgsUsage: "<reservation>",
+			Action:    action(jobRuns),
+		},
+	},
+}
+
+func jobRuns(c *cli.Context, cl zbus.Client) error {
+	id := c.Args().First()
+	if len(id) == 0 {
+		return fmt.Errorf("reservation id is required")
+	}
+
+	runs, err := stubs.NewJobMonitorStub(cl).JobRuns(id)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(runs)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/urfave/cli"
+)
+
+var logsCommand = cli.Command{
+	Name:      "logs",
+	Usage:     "show the log levels of a module, the empty name is the level of the whole module",
+	ArgsUsage: "<module>",
+	Action:    action(logLevels),
+	Subcommands: []cli.Command{
+		{
+			Name:      "level",
+			Usage:     "set the log level of a module, or of one of its components. An empty level makes the component follow the module again",
+			ArgsUsage: "<module> [component] <level>",
+			Action:    action(logSetLevel),
+		},
+		{
+			Name:      "recent",
+			Usage:     "show the last lines logged by a module, of any level",
+			ArgsUsage: "<module>",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "lines, n",
+					Usage: "number of lines",
+					Value: 100,
+				},
+			},
+			Action: action(logRecent),
+		},
+	},
+}
+
+func logLevels(c *cli.Context, cl zbus.Client) error {
+	module := c.Args().First()
+	if len(module) == 0 {
+		return fmt.Errorf("module is required")
+	}
+
+	levels, err := logging.Levels(cl, module)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(levels)
+}
+
+func logSetLevel(c *cli.Context, cl zbus.Client) error {
+	var module, component, level string
+	switch c.NArg() {
+	case 2:
+		module, level = c.Args().Get(0), c.Args().Get(1)
+	case 3:
+		module, component, level = c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+	default:
+		return fmt.Errorf("module and level are required")
+	}
+
+	if err := logging.SetLevel(cl, module, component, level); err != nil {
+		return err
+	}
+
+	levels, err := logging.Levels(cl, module)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(levels)
+}
+
+func logRecent(c *cli.Context, cl zbus.Client) error {
+	module := c.Args().First()
+	if len(module) == 0 {
+		return fmt.Errorf("module is required")
+	}
+
+	lines, err := logging.Recent(cl, module, c.Int("lines"))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	return nil
+}
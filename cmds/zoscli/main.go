@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
+	"github.com/threefoldtech/zos/pkg/version"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	app := cli.NewApp()
+	app.Name = "zoscli"
+	app.Usage = "node local administration of the zos modules"
+	app.Version = version.Current().String()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "broker",
+			Usage: "connection string to the message broker",
+			Value: "unix:///var/run/redis.sock",
+		},
+	}
+
+	app.Commands = []cli.Command{
+		networkCommand,
+		storageCommand,
+		containerCommand,
+		vmCommand,
+		jobCommand,
+		monitorCommand,
+		inventoryCommand,
+		benchmarkCommand,
+		identityCommand,
+		upgradeCommand,
+		featuresCommand,
+		logsCommand,
+		auditCommand,
+		diagCommand,
+		remoteCommand,
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+}
+
+// operator is the caller handed over to the modules for the operations made
+// with zoscli, they record it in their audit log
+const operator = "zoscli"
+
+// client creates the zbus client from the global flags
+func client(c *cli.Context) (zbus.Client, error) {
+	cl, err := zbus.NewRedisClient(c.GlobalString("broker"))
+	if err != nil {
+		return nil, err
+	}
+
+	return stubs.NewWireClient(cl), nil
+}
+
+// action wraps a command so it gets a zbus client
+func action(fn func(c *cli.Context, cl zbus.Client) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		cl, err := client(c)
+		if err != nil {
+			return fmt.Errorf("failed to connect to zbus: %w", err)
+		}
+
+		store, err := tracing.NewRedisStore(c.GlobalString("broker"))
+		if err != nil {
+			return fmt.Errorf("failed to connect to zbus: %w", err)
+		}
+		tracing.SetTracer(tracing.NewTracer(operator, nil, store))
+
+		return fn(c, cl)
+	}
+}
+
+// handover hands the operator over to module as the caller of method about
+// the object identified by key, the returned function ends the call
+func handover(module, method, key string) func(error) {
+	ctx := tracing.WithCaller(context.Background(), operator)
+	_, span := tracing.Call(ctx, module, method, key)
+	return span.Finish
+}
+
+// printJSON writes v as indented json on stdout
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"github.com/urfave/cli"
+)
+
+var monitorCommand = cli.Command{
+	Name:      "monitor",
+	Usage:     "stream the node metrics until interrupted",
+	ArgsUsage: "<cpu|memory|swap|disks|nics|sensors|calls|pools|dns>",
+	Action:    action(monitor),
+}
+
+func monitor(c *cli.Context, cl zbus.Client) error {
+	ctx, cancel := utils.WithSignal(context.Background())
+	defer cancel()
+
+	system := stubs.NewSystemMonitorStub(cl)
+
+	var (
+		ch  interface{}
+		err error
+	)
+
+	switch metric := c.Args().First(); metric {
+	case "cpu":
+		ch, err = system.CPU(ctx)
+	case "memory":
+		ch, err = system.Memory(ctx)
+	case "swap":
+		ch, err = system.Swap(ctx)
+	case "disks":
+		ch, err = system.Disks(ctx)
+	case "nics":
+		ch, err = system.Nics(ctx)
+	case "sensors":
+		ch, err = system.Sensors(ctx)
+	case "calls":
+		ch, err = system.CallFailures(ctx)
+	case "pools":
+		ch, err = stubs.NewStorageModuleStub(cl).Monitor(ctx)
+	case "dns":
+		ch, err = stubs.NewDNSCacheStub(cl).Monitor(ctx)
+	default:
+		return fmt.Errorf("unknown metric '%s'", metric)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// all the streams have different types, so we
+	// receive from them with reflection
+	value := reflect.ValueOf(ch)
+	for {
+		v, ok := value.Recv()
+		if !ok {
+			return nil
+		}
+
+		if err := printJSON(v.Interface()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var networkCommand = cli.Command{
+	Name:    "network",
+	Aliases: []string{"net"},
+	Usage:   "manage the network resources of the node",
+	Subcommands: []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "list the network resources applied on the node",
+			Action: action(networkList),
+		},
+		{
+			Name:      "apply",
+			Usage:     "create or update a network resource from a network object",
+			ArgsUsage: "<network.json>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the interfaces, addresses, peers and routes that would be configured without applying them",
+				},
+				cli.BoolFlag{
+					Name:  "no-wait",
+					Usage: "queue the network resource and return without waiting for it to be applied, see the status command",
+				},
+			},
+			Action: action(networkApply),
+		},
+		{
+			Name:      "status",
+			Usage:     "show the status of the last operation queued on a network resource",
+			ArgsUsage: "<net-id>",
+			Action:    action(networkStatus),
+		},
+		{
+			Name:      "delete",
+			Usage:     "delete a network resource from a network object",
+			ArgsUsage: "<network.json>",
+			Action:    action(networkDelete),
+		},
+		{
+			Name:      "peers",
+			Usage:     "show the connection state and location of the peers of a network resource",
+			ArgsUsage: "<net-id>",
+			Action:    action(networkPeers),
+		},
+		{
+			Name:      "sockets",
+			Usage:     "show the listening ports, the connections and the retransmissions of a network resource, or of a workload of the network",
+			ArgsUsage: "<net-id> [container-id]",
+			Action:    action(networkSockets),
+		},
+		{
+			Name:      "isolation",
+			Usage:     "probe the host and the other networks of the node from a network resource, fails if any of them is reachable",
+			ArgsUsage: "<net-id>",
+			Action:    action(networkIsolation),
+		},
+		{
+			Name:      "offloads",
+			Usage:     "show the offload features of the wireguard interface and of the workload veths of a network resource",
+			ArgsUsage: "<net-id>",
+			Action:    action(networkOffloads),
+		},
+		{
+			Name:   "dns",
+			Usage:  "show the statistics of the dns cache of the node",
+			Action: action(networkDNS),
+			Subcommands: []cli.Command{
+				{
+					Name:      "flush",
+					Usage:     "remove a zone and the names under it from the dns cache, the whole cache is flushed if no zone is given",
+					ArgsUsage: "[zone]",
+					Action:    action(networkDNSFlush),
+				},
+			},
+		},
+	},
+}
+
+// networkSummary is a network resource as listed by the network list command
+type networkSummary struct {
+	NetID     pkg.NetID         `json:"net_id"`
+	Prefix    string            `json:"prefix"`
+	Names     map[string]string `json:"names"`
+	Collision bool              `json:"collision"`
+}
+
+func networkList(c *cli.Context, cl zbus.Client) error {
+	done := handover("network", "NamesAudit", "")
+	names, err := stubs.NewNetworkerStub(cl).NamesAudit()
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	var list []*networkSummary
+	byID := make(map[pkg.NetID]*networkSummary)
+	for _, name := range names {
+		summary, ok := byID[name.NetID]
+		if !ok {
+			summary = &networkSummary{
+				NetID:  name.NetID,
+				Prefix: name.Prefix.String(),
+				Names:  make(map[string]string),
+			}
+			byID[name.NetID] = summary
+			list = append(list, summary)
+		}
+
+		summary.Names[name.Kind] = name.Name
+		summary.Collision = summary.Collision || name.Collision
+	}
+
+	return printJSON(list)
+}
+
+func loadNetwork(c *cli.Context) (pkg.Network, error) {
+	var network pkg.Network
+
+	path := c.Args().First()
+	if path == "" {
+		return network, fmt.Errorf("network object file is required")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return network, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&network); err != nil {
+		return network, fmt.Errorf("failed to decode network object: %w", err)
+	}
+
+	return network, nil
+}
+
+func networkApply(c *cli.Context, cl zbus.Client) error {
+	network, err := loadNetwork(c)
+	if err != nil {
+		return err
+	}
+
+	networker := stubs.NewNetworkerStub(cl)
+	if c.Bool("dry-run") {
+		plan, err := networker.PlanNR(network)
+		if err != nil {
+			return err
+		}
+
+		return printJSON(plan)
+	}
+
+	// the network resource is created under the CreateNR operation,
+	// even when submitted
+	done := handover("network", "CreateNR", string(network.NetID))
+	if c.Bool("no-wait") {
+		status, err := networker.SubmitNR(network)
+		done(err)
+		if err != nil {
+			return err
+		}
+
+		return printJSON(status)
+	}
+
+	ns, err := networker.CreateNR(network)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(ns)
+	return nil
+}
+
+func networkDelete(c *cli.Context, cl zbus.Client) error {
+	network, err := loadNetwork(c)
+	if err != nil {
+		return err
+	}
+
+	done := handover("network", "DeleteNR", string(network.NetID))
+	err = stubs.NewNetworkerStub(cl).DeleteNR(network)
+	done(err)
+	return err
+}
+
+func networkStatus(c *cli.Context, cl zbus.Client) error {
+	netID := c.Args().First()
+	if netID == "" {
+		return fmt.Errorf("network id is required")
+	}
+
+	status, err := stubs.NewNetworkerStub(cl).ApplyStatus(pkg.NetID(netID))
+	if err != nil {
+		return err
+	}
+
+	return printJSON(status)
+}
+
+func networkPeers(c *cli.Context, cl zbus.Client) error {
+	netID := c.Args().First()
+	if netID == "" {
+		return fmt.Errorf("network id is required")
+	}
+
+	done := handover("network", "PeersStatus", netID)
+	status, err := stubs.NewNetworkerStub(cl).PeersStatus(pkg.NetID(netID))
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(status)
+}
+
+func networkSockets(c *cli.Context, cl zbus.Client) error {
+	netID := c.Args().First()
+	if netID == "" {
+		return fmt.Errorf("network id is required")
+	}
+
+	done := handover("network", "SocketStats", netID)
+	stats, err := stubs.NewNetworkerStub(cl).SocketStats(pkg.NetID(netID), c.Args().Get(1))
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(stats)
+}
+
+func networkIsolation(c *cli.Context, cl zbus.Client) error {
+	netID := c.Args().First()
+	if netID == "" {
+		return fmt.Errorf("network id is required")
+	}
+
+	done := handover("network", "VerifyIsolation", netID)
+	report, err := stubs.NewNetworkerStub(cl).VerifyIsolation(pkg.NetID(netID))
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	if err := printJSON(report); err != nil {
+		return err
+	}
+
+	if !report.Passed {
+		return fmt.Errorf("network %s is not isolated", netID)
+	}
+
+	return nil
+}
+
+func networkOffloads(c *cli.Context, cl zbus.Client) error {
+	netID := c.Args().First()
+	if netID == "" {
+		return fmt.Errorf("network id is required")
+	}
+
+	done := handover("network", "Offloads", netID)
+	offloads, err := stubs.NewNetworkerStub(cl).Offloads(pkg.NetID(netID))
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(offloads)
+}
+
+func networkDNS(c *cli.Context, cl zbus.Client) error {
+	stats, err := stubs.NewDNSCacheStub(cl).Stats()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(stats)
+}
+
+func networkDNSFlush(c *cli.Context, cl zbus.Client) error {
+	return stubs.NewDNSCacheStub(cl).Flush(c.Args().First())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/identity"
+	"github.com/threefoldtech/zos/pkg/remote"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var remoteCommand = cli.Command{
+	Name:        "remote",
+	Usage:       "open a remote access session on a node of your farm",
+	ArgsUsage:   "[command [args...]]",
+	Description: "Runs the command on the node, or opens a shell if no command is given. It's run by the farmer from the management network, the node must be booted with remote-key set to the public key of the seed",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "node, n",
+			Usage: "ID of the node",
+		},
+		cli.StringFlag{
+			Name:  "address, a",
+			Usage: "address of the node on the management network, the port defaults to 8071",
+		},
+		cli.StringFlag{
+			Name:  "seed, s",
+			Usage: "seed file of the farmer key",
+			Value: "farmer.seed",
+		},
+		cli.DurationFlag{
+			Name:  "duration, d",
+			Usage: "the session is closed after this duration, at most 1h",
+			Value: 15 * time.Minute,
+		},
+	},
+	Action: remoteSession,
+}
+
+func remoteSession(c *cli.Context) error {
+	var (
+		nodeID  = c.String("node")
+		address = c.String("address")
+	)
+
+	if len(nodeID) == 0 || len(address) == 0 {
+		return fmt.Errorf("node and address are required")
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, fmt.Sprint(remote.DefaultPort))
+	}
+
+	pair, err := identity.LoadKeyPair(c.String("seed"))
+	if err != nil {
+		return errors.Wrap(err, "failed to load farmer key")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	request := remote.Request{
+		NodeID:     nodeID,
+		Command:    c.Args(),
+		Expiration: time.Now().Add(c.Duration("duration")).Unix(),
+		Nonce:      hex.EncodeToString(nonce),
+	}
+
+	// the request is signed once the node proved its identity
+	conn, err := remote.Dial(address, &request, pair.PrivateKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Info().Str("session", conn.Session).Msg("session opened, it's recorded on the node")
+
+	if request.Shell() && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		// the keys are sent as typed to the terminal of the node
+		state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), state)
+	}
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		conn.CloseWrite()
+	}()
+
+	_, err = io.Copy(os.Stdout, conn)
+	return err
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var storageCommand = cli.Command{
+	Name:  "storage",
+	Usage: "inspect the storage of the node",
+	Subcommands: []cli.Command{
+		{
+			Name:   "pools",
+			Usage:  "show the storage pools usage and the broken pools and devices",
+			Action: action(storagePools),
+		},
+		{
+			Name:   "size",
+			Usage:  "show the total size of the ssd and hdd storage",
+			Action: action(storageSize),
+		},
+		{
+			Name:  "stress",
+			Usage: "create volumes of the usual workload sizes at the same time, report the failures and remove them",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "rounds",
+					Usage: "number of times the set of volumes is created",
+					Value: 1,
+				},
+			},
+			Action: action(storageStress),
+		},
+		{
+			Name:   "devices",
+			Usage:  "show the stable identities of the devices of the storage pools and their current paths",
+			Action: action(storageDevices),
+		},
+		{
+			Name:  "swap",
+			Usage: "turn the encrypted swap on or off, it must be off before suspending the node",
+			Subcommands: []cli.Command{
+				{
+					Name:   "on",
+					Usage:  "enable the swap with a new key",
+					Action: action(storageSwapOn),
+				},
+				{
+					Name:   "off",
+					Usage:  "move the swapped out pages back to memory and destroy the key of the swap",
+					Action: action(storageSwapOff),
+				},
+			},
+		},
+		{
+			Name:      "repair",
+			Usage:     "try to bring back a broken storage pool",
+			ArgsUsage: "<pool>",
+			Action:    action(storageRepair),
+		},
+		{
+			Name:      "allocation",
+			Usage:     "show the allocation of a 0-db namespace",
+			ArgsUsage: "<namespace>",
+			Action:    action(storageAllocation),
+		},
+		{
+			Name:      "path",
+			Usage:     "show the path of a volume",
+			ArgsUsage: "<volume>",
+			Action:    action(storagePath),
+		},
+		{
+			Name:      "export",
+			Usage:     "package the data of a 0-db namespace in an archive to move it to another node",
+			ArgsUsage: "<namespace>",
+			Action:    action(storageExport),
+		},
+		{
+			Name:      "import",
+			Usage:     "create a 0-db namespace from an archive made by export",
+			ArgsUsage: "<archive>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "disk-type",
+					Usage: "type of disk to store the namespace on, ssd or hdd",
+					Value: string(pkg.SSDDevice),
+				},
+				cli.StringFlag{
+					Name:  "mode",
+					Usage: "mode of the 0-db running the namespace, user or seq",
+					Value: string(pkg.ZDBModeUser),
+				},
+			},
+			Action: action(storageImport),
+		},
+		{
+			Name:  "forensic",
+			Usage: "examine the data of a volume without giving access to the node",
+			Subcommands: []cli.Command{
+				{
+					Name:      "mount",
+					Usage:     "mount a volume read-only for a limited time, the reason is recorded in the audit log",
+					ArgsUsage: "<volume>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "reason",
+							Usage: "why the volume is examined, required",
+						},
+						cli.DurationFlag{
+							Name:  "duration",
+							Usage: "how long the volume stays mounted",
+							Value: time.Hour,
+						},
+					},
+					Action: action(storageForensicMount),
+				},
+				{
+					Name:      "unmount",
+					Usage:     "end the examination of a volume",
+					ArgsUsage: "<volume>",
+					Action:    action(storageForensicUnmount),
+				},
+			},
+		},
+	},
+}
+
+func storagePools(c *cli.Context, cl zbus.Client) error {
+	storage := stubs.NewStorageModuleStub(cl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ch, err := storage.Monitor(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pools pkg.PoolsStats
+	select {
+	case pools = <-ch:
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for pools statistics")
+	}
+
+	type brokenPool struct {
+		Label       string    `json:"label"`
+		Err         string    `json:"error"`
+		Devices     []string  `json:"devices"`
+		Diagnostics []string  `json:"diagnostics"`
+		Since       time.Time `json:"since"`
+	}
+	type brokenDevice struct {
+		Path string `json:"path"`
+		Err  string `json:"error"`
+	}
+
+	brokenPools, err := storage.BrokenPools()
+	if err != nil {
+		return err
+	}
+	brokenDevices, err := storage.BrokenDevices()
+	if err != nil {
+		return err
+	}
+
+	var bp []brokenPool
+	for _, p := range brokenPools {
+		bp = append(bp, brokenPool{
+			Label:       p.Label,
+			Err:         fmt.Sprint(p.Err),
+			Devices:     p.Devices,
+			Diagnostics: p.Diagnostics,
+			Since:       p.Since,
+		})
+	}
+	var bd []brokenDevice
+	for _, d := range brokenDevices {
+		bd = append(bd, brokenDevice{Path: d.Path, Err: fmt.Sprint(d.Err)})
+	}
+
+	return printJSON(struct {
+		Pools         pkg.PoolsStats `json:"pools"`
+		BrokenPools   []brokenPool   `json:"broken_pools"`
+		BrokenDevices []brokenDevice `json:"broken_devices"`
+	}{pools, bp, bd})
+}
+
+func storageSize(c *cli.Context, cl zbus.Client) error {
+	storage := stubs.NewStorageModuleStub(cl)
+
+	ssd, err := storage.Total(pkg.SSDDevice)
+	if err != nil {
+		return err
+	}
+	hdd, err := storage.Total(pkg.HDDDevice)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(struct {
+		SSD uint64 `json:"ssd"`
+		HDD uint64 `json:"hdd"`
+	}{ssd, hdd})
+}
+
+// stressVolumes are the volumes created by the stress test, with the
+// sizes of the 0-db namespaces usually reserved on the nodes
+var stressVolumes = []struct {
+	kind pkg.DeviceType
+	size uint64
+}{
+	{pkg.SSDDevice, 10 * gib},
+	{pkg.SSDDevice, 100 * gib},
+	{pkg.SSDDevice, 200 * gib},
+	{pkg.HDDDevice, 100 * gib},
+	{pkg.HDDDevice, 400 * gib},
+}
+
+const gib = 1024 * 1024 * 1024
+
+func storageStress(c *cli.Context, cl zbus.Client) error {
+	storage := stubs.NewStorageModuleStub(cl)
+
+	type result struct {
+		Name     string         `json:"name"`
+		Type     pkg.DeviceType `json:"type"`
+		Size     uint64         `json:"size"`
+		Duration string         `json:"duration"`
+		Err      string         `json:"error,omitempty"`
+	}
+
+	var results []result
+	prefix := fmt.Sprintf("stress-%d", time.Now().Unix())
+	for round := 0; round < c.Int("rounds"); round++ {
+		for i, volume := range stressVolumes {
+			results = append(results, result{
+				Name: fmt.Sprintf("%s-%d-%d", prefix, round, i),
+				Type: volume.kind,
+				Size: volume.size,
+			})
+		}
+	}
+
+	// all the volumes are created at the same time, and
+	// only removed once they all are created
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(r *result) {
+			defer wg.Done()
+
+			start := time.Now()
+			done := handover("storage", "CreateFilesystem", r.Name)
+			_, err := storage.CreateFilesystem(r.Name, r.Size, r.Type)
+			done(err)
+			r.Duration = time.Since(start).String()
+			if err != nil {
+				r.Err = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var failed int
+	for _, r := range results {
+		if len(r.Err) != 0 {
+			failed++
+			continue
+		}
+
+		done := handover("storage", "ReleaseFilesystem", r.Name)
+		err := storage.ReleaseFilesystem(r.Name)
+		done(err)
+		if err != nil {
+			log.Error().Err(err).Str("volume", r.Name).Msg("failed to remove stress volume")
+		}
+	}
+
+	if err := printJSON(results); err != nil {
+		return err
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d volumes failed", failed, len(results))
+	}
+	return nil
+}
+
+func storageDevices(c *cli.Context, cl zbus.Client) error {
+	done := handover("storage", "DeviceIdentities", "")
+	identities, err := stubs.NewStorageModuleStub(cl).DeviceIdentities()
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(identities)
+}
+
+func storageSwapOn(c *cli.Context, cl zbus.Client) error {
+	done := handover("storage", "SwapOn", "")
+	err := stubs.NewStorageModuleStub(cl).SwapOn()
+	done(err)
+	return err
+}
+
+func storageSwapOff(c *cli.Context, cl zbus.Client) error {
+	done := handover("storage", "SwapOff", "")
+	err := stubs.NewStorageModuleStub(cl).SwapOff()
+	done(err)
+	return err
+}
+
+func storageRepair(c *cli.Context, cl zbus.Client) error {
+	pool := c.Args().First()
+	if pool == "" {
+		return fmt.Errorf("pool is required")
+	}
+
+	done := handover("storage", "RepairPool", pool)
+	err := stubs.NewStorageModuleStub(cl).RepairPool(pool)
+	done(err)
+	return err
+}
+
+func storageAllocation(c *cli.Context, cl zbus.Client) error {
+	ns := c.Args().First()
+	if ns == "" {
+		return fmt.Errorf("namespace is required")
+	}
+
+	allocation, err := stubs.NewStorageModuleStub(cl).Find(ns)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(allocation)
+}
+
+func storagePath(c *cli.Context, cl zbus.Client) error {
+	volume := c.Args().First()
+	if volume == "" {
+		return fmt.Errorf("volume is required")
+	}
+
+	path, err := stubs.NewStorageModuleStub(cl).Path(volume)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+	return nil
+}
+
+func storageExport(c *cli.Context, cl zbus.Client) error {
+	ns := c.Args().First()
+	if ns == "" {
+		return fmt.Errorf("namespace is required")
+	}
+
+	done := handover("storage", "Export", ns)
+	path, err := stubs.NewStorageModuleStub(cl).Export(ns)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+	return nil
+}
+
+func storageImport(c *cli.Context, cl zbus.Client) error {
+	path := c.Args().First()
+	if path == "" {
+		return fmt.Errorf("archive is required")
+	}
+
+	done := handover("storage", "Import", path)
+	allocation, err := stubs.NewStorageModuleStub(cl).Import(
+		path,
+		pkg.DeviceType(c.String("disk-type")),
+		pkg.ZDBMode(c.String("mode")),
+	)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(allocation)
+}
+
+func storageForensicMount(c *cli.Context, cl zbus.Client) error {
+	volume := c.Args().First()
+	if volume == "" {
+		return fmt.Errorf("volume is required")
+	}
+
+	done := handover("storage", "ForensicMount", volume)
+	path, err := stubs.NewStorageModuleStub(cl).ForensicMount(volume, c.String("reason"), c.Duration("duration"))
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+	return nil
+}
+
+func storageForensicUnmount(c *cli.Context, cl zbus.Client) error {
+	volume := c.Args().First()
+	if volume == "" {
+		return fmt.Errorf("volume is required")
+	}
+
+	done := handover("storage", "ForensicUnmount", volume)
+	err := stubs.NewStorageModuleStub(cl).ForensicUnmount(volume)
+	done(err)
+	return err
+}
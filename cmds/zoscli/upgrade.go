@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/urfave/cli"
+)
+
+var upgradeCommand = cli.Command{
+	Name:  "upgrade",
+	Usage: "manage the upgrades of the node",
+	Subcommands: []cli.Command{
+		{
+			Name:   "channel",
+			Usage:  "show the release channel the node follows",
+			Action: action(upgradeChannel),
+			Subcommands: []cli.Command{
+				{
+					Name:      "set",
+					Usage:     "make the node follow a channel (production, testing or canary)",
+					ArgsUsage: "<channel>",
+					Action:    action(upgradeChannelSet),
+				},
+				{
+					Name:   "reset",
+					Usage:  "make the node follow the channel of its farm",
+					Action: action(upgradeChannelReset),
+				},
+			},
+		},
+		{
+			Name:   "plan",
+			Usage:  "show the pending upgrade and the pre-flight checks it waits for",
+			Action: action(upgradePlan),
+		},
+	},
+}
+
+func upgradeChannel(c *cli.Context, cl zbus.Client) error {
+	channel, err := stubs.NewUpgradeChannelsStub(cl).Channel()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(channel)
+}
+
+func upgradeChannelSet(c *cli.Context, cl zbus.Client) error {
+	channel := c.Args().First()
+	if len(channel) == 0 {
+		return fmt.Errorf("channel is required")
+	}
+
+	if err := stubs.NewUpgradeChannelsStub(cl).SetChannel(channel); err != nil {
+		return err
+	}
+
+	return upgradeChannel(c, cl)
+}
+
+func upgradeChannelReset(c *cli.Context, cl zbus.Client) error {
+	if err := stubs.NewUpgradeChannelsStub(cl).SetChannel(""); err != nil {
+		return err
+	}
+
+	return upgradeChannel(c, cl)
+}
+
+func upgradePlan(c *cli.Context, cl zbus.Client) error {
+	plan, err := stubs.NewUpgradePlannerStub(cl).Plan()
+	if err != nil {
+		return err
+	}
+
+	return printJSON(plan)
+}
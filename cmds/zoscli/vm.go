@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"github.com/urfave/cli"
+)
+
+var vmCommand = cli.Command{
+	Name:  "vm",
+	Usage: "inspect the virtual machines",
+	Subcommands: []cli.Command{
+		{
+			Name:      "console",
+			Usage:     "show the serial console output of a vm",
+			ArgsUsage: "<name>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "follow, f",
+					Usage: "keep printing the output until interrupted",
+				},
+			},
+			Action: action(vmConsole),
+		},
+		{
+			Name:      "migrate-accept",
+			Usage:     "prepare this node to receive a vm migrated from another node of the farm",
+			ArgsUsage: "<name>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Usage: "address on the management network to receive the vm on",
+				},
+				cli.StringSliceFlag{
+					Name:  "disk, d",
+					Usage: "writable disk of the vm as `file=path`, path is the disk on this node",
+				},
+			},
+			Action: action(vmMigrateAccept),
+		},
+		{
+			Name:      "migrate",
+			Usage:     "live migrate a vm to another node of the farm",
+			ArgsUsage: "<name> <target address> <ticket>",
+			Action:    action(vmMigrate),
+		},
+	},
+}
+
+func vmMigrateAccept(c *cli.Context, cl zbus.Client) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("vm name is required")
+	}
+
+	migration := pkg.VMMigration{
+		Listen: c.String("listen"),
+		Disks:  make(map[string]string),
+	}
+
+	if migration.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+
+	for _, disk := range c.StringSlice("disk") {
+		parts := strings.SplitN(disk, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid disk '%s' expected file=path", disk)
+		}
+		migration.Disks[parts[0]] = parts[1]
+	}
+
+	vm := stubs.NewVMModuleStub(cl)
+	ticket, err := vm.MigrationAccept(name, migration)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(ticket)
+	return nil
+}
+
+func vmMigrate(c *cli.Context, cl zbus.Client) error {
+	if c.NArg() != 3 {
+		return fmt.Errorf("vm name, target address and ticket are required")
+	}
+
+	vm := stubs.NewVMModuleStub(cl)
+	return vm.Migrate(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2))
+}
+
+func vmConsole(c *cli.Context, cl zbus.Client) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("vm name is required")
+	}
+
+	ctx, cancel := utils.WithSignal(context.Background())
+	defer cancel()
+
+	vm := stubs.NewVMModuleStub(cl)
+	var offset int64
+	for {
+		output, err := vm.ConsoleLog(name, offset)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stdout.Write(output.Data); err != nil {
+			return err
+		}
+		offset = output.Offset
+
+		if len(output.Data) > 0 {
+			// there might be more to read right away
+			continue
+		}
+
+		if !c.Bool("follow") {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
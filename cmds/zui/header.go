@@ -18,7 +18,11 @@ func headerRenderer(c zbus.Client, h *widgets.Paragraph, r *Flag) error {
 	}
 
 	identity := stubs.NewIdentityManagerStub(c)
-	nodeID := identity.NodeID()
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		return err
+	}
+
 	var farm string
 	farmID, err := identity.FarmID()
 	if err != nil {
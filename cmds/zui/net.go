@@ -22,6 +22,7 @@ func addressRender(ctx context.Context, table *widgets.Table, client zbus.Client
 		{"ZOS", "Not Configured"},
 		{"DMZ", "Not Configured"},
 		{"Public", "Not Configured"},
+		{"Flood", "Not Configured"},
 	}
 
 	stub := stubs.NewNetworkerStub(client)
@@ -39,6 +40,11 @@ func addressRender(ctx context.Context, table *widgets.Table, client zbus.Client
 		return err
 	}
 
+	flood, err := stub.FloodCounters(ctx)
+	if err != nil {
+		return err
+	}
+
 	toString := func(al pkg.NetlinkAddresses) string {
 		var buf strings.Builder
 		for _, a := range al {
@@ -62,6 +68,8 @@ func addressRender(ctx context.Context, table *widgets.Table, client zbus.Client
 				table.Rows[1][1] = toString(a)
 			case a := <-pub:
 				table.Rows[2][1] = toString(a)
+			case c := <-flood:
+				table.Rows[3][1] = fmt.Sprintf("SYN dropped: %d, connections limited: %d", c.SynDropped, c.ConnLimited)
 			}
 
 			render.Signal()
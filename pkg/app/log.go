@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/logging"
 )
 
 const (
@@ -58,12 +58,14 @@ func formatLevel(i interface{}) string {
 	return l
 }
 
-// Initialize Configure a zos app
+// Initialize Configure a zos app. The log levels of the app can be changed
+// at runtime once the logging object is registered on zbus
 func Initialize() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{
+	logging.Install(zerolog.ConsoleWriter{
 		TimeFormat:  time.RFC3339,
 		Out:         os.Stdout,
 		FormatLevel: formatLevel,
-	})
+	}, zerolog.DebugLevel)
 
+	setupProxy()
 }
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/proxy"
+)
+
+// setupProxy makes the module reach the internet through the proxy set by
+// the farmer, if any
+func setupProxy() {
+	config, err := proxy.ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		log.Error().Err(err).Msg("invalid proxy configuration, the traffic is not proxied")
+		return
+	}
+
+	if err := config.Install(); err != nil {
+		log.Error().Err(err).Msg("failed to install proxy")
+	}
+}
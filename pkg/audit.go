@@ -0,0 +1,56 @@
+package pkg
+
+import "time"
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module identityd -version 0.0.1 -name audit -package stubs github.com/threefoldtech/zos/pkg+Auditor stubs/auditor_stub.go
+
+// AuditEntry is a record of a mutating operation executed by a module
+type AuditEntry struct {
+	// Time the operation finished
+	Time time.Time `json:"time"`
+	// Module that executed the operation
+	Module string `json:"module"`
+	// Operation is the name of the API called
+	Operation string `json:"operation"`
+	// Caller is the identity on behalf of which the operation was executed
+	// if known (reservation user for example). It's declared by the client
+	// of the module, it's not authenticated
+	Caller string `json:"caller,omitempty"`
+	// Object is the ID of the resource affected by the operation
+	Object string `json:"object"`
+	// ParamsHash is the hex encoded sha256 of the operation parameters
+	ParamsHash string `json:"params_hash"`
+	// Error is set if the operation failed
+	Error string `json:"error,omitempty"`
+	// Previous is the hash of the previous entry of the module (hex
+	// encoded), the entries of a module form a chain so an entry can't
+	// be removed or altered without breaking it
+	Previous string `json:"previous,omitempty"`
+	// Signature of the entry by the node identity (hex encoded), empty if
+	// the node identity was not available yet. The entries before a signed
+	// entry are covered by its signature through the chain, the entries
+	// after it must be signed
+	Signature string `json:"signature,omitempty"`
+}
+
+// AuditFilter is used to query the audit log
+type AuditFilter struct {
+	// Since only return entries recorded after this time
+	Since time.Time
+	// Module only return entries of this module if set
+	Module string
+	// Object only return entries affecting this object if set
+	Object string
+	// Limit the number of returned entries (most recent first), 0 means no limit
+	Limit int
+}
+
+// Auditor gives access to the audit log of the node
+type Auditor interface {
+	// Query returns the audit entries matching the filter
+	Query(filter AuditFilter) ([]AuditEntry, error)
+	// Verify checks the chain and the signatures of the entries of module,
+	// of all the modules if empty
+	Verify(module string) error
+}
@@ -0,0 +1,745 @@
+// Package audit implements the append-only audit log of the mutating operations
+// executed by the node modules.
+//
+// Each module appends its entries as json lines to its own file under the audit
+// root directory. The entries are also sent to the module logs, so they are
+// shipped with the rest of the logs by the log exporter.
+//
+// Every entry holds the hash of the previous entry of its module and is signed
+// by the node identity, so the entries can't be altered or removed without
+// breaking the chain. Once an entry of a module is signed, all the next ones
+// must be signed too. The number of entries of a module and the hash of the
+// last one are kept, signed, in its head file, so the last entries can't be
+// removed either. A full file is rotated to a numbered segment, the segments
+// are kept and the chain continues in the new file.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/tracing"
+)
+
+const (
+	// DefaultRoot is the default directory where the audit logs are stored
+	DefaultRoot = "/var/cache/modules/audit"
+
+	// System is the caller of the operations the node starts on its own
+	// (health checks, garbage collection, replays)
+	System = "system"
+	// Unknown is the caller of the operations called over zbus by a
+	// client that didn't hand its caller over
+	Unknown = "unknown"
+
+	ext     = ".log"
+	headExt = ".head"
+)
+
+var (
+	// maxSize is the max size of an audit file before it's rotated
+	maxSize int64 = 20 * 1024 * 1024
+	// maxPending is the max number of entries waiting for a signer, the
+	// oldest are dropped, they are still in the logs of the module
+	maxPending = 1000
+)
+
+// Signer is used to sign the audit entries
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// Verifier is used to verify the signatures of the audit entries
+type Verifier interface {
+	Verify(message, sig []byte) error
+}
+
+// Caller returns the caller handed over with the zbus call served with ctx.
+// The caller is declared by the client in the broker, it's not authenticated:
+// any process that can reach the broker (the node modules, zoscli) can hand
+// over any caller. It's an attribution hint, it must never be used to grant
+// or limit anything
+func Caller(ctx context.Context) string {
+	if caller := tracing.Caller(ctx); caller != "" {
+		return caller
+	}
+
+	return Unknown
+}
+
+// Logger records the operations of a single module
+type Logger struct {
+	root   string
+	module string
+
+	mu     sync.Mutex
+	signer Signer
+	// last is the hash of the last entry
+	last string
+	// count is the number of entries of the chain
+	count int
+	// signed is true once the chain has a signed entry, the next
+	// entries can't be written until they can be signed
+	signed bool
+	// pending are the entries not written yet, oldest first
+	pending []pkg.AuditEntry
+	// unverified is the signed head found when the logger was created,
+	// its signature is checked once the signer is known
+	unverified *head
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// New creates a new audit logger for module. signer can be nil
+// in that case the entries are not signed until SetSigner is called
+func New(root, module string, signer Signer) (*Logger, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create audit directory")
+	}
+
+	l := &Logger{
+		root:   root,
+		module: module,
+		signer: signer,
+		now:    time.Now,
+	}
+
+	head, err := readHead(root, module)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("module", module).Msg("invalid audit head")
+	}
+	anchored := head != nil && head.Count == 0
+
+	// the chain continues after the last entry recorded by the
+	// previous run of the module
+	paths, err := segments(root, module)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		err := scan(path, func(e *pkg.AuditEntry) error {
+			l.last = EntryHash(e)
+			l.count++
+			l.signed = l.signed || e.Signature != ""
+			anchored = anchored || (head != nil && head.Count == l.count && head.Last == l.last)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read audit file '%s'", path)
+		}
+	}
+
+	// what happened to the log while the module was not running is
+	// recorded in the chain, the head is written again with the entry
+	var reason error
+	switch {
+	case head == nil && l.count > 0:
+		reason = fmt.Errorf("no audit head, anchoring the %d entries of the log", l.count)
+	case head == nil:
+		// a new log
+	case !anchored:
+		reason = fmt.Errorf("audit log truncated, the head anchors %d entries, %d found", head.Count, l.count)
+	case l.signed && head.Signature == "":
+		reason = fmt.Errorf("audit head is not signed")
+	case head.Signature != "":
+		l.unverified = head
+	}
+
+	if reason != nil {
+		l.pending = append(l.pending, l.entry("AnchorAudit", System, module, nil, reason))
+	}
+
+	l.verifyHead()
+	if err := l.flush(); err != nil {
+		log.Error().Err(err).Msg("failed to write audit entry")
+	}
+
+	return l, nil
+}
+
+// verifyHead records in the chain a head found with an invalid signature,
+// once the signer is known. The identity can verify its own signatures
+func (l *Logger) verifyHead() {
+	verifier, ok := l.signer.(Verifier)
+	if l.unverified == nil || !ok {
+		return
+	}
+
+	head := l.unverified
+	l.unverified = nil
+	if err := verifySignature(verifier, head.bytes(), head.Signature); err != nil {
+		err = errors.Wrap(err, "invalid signature of audit head")
+		l.pending = append(l.pending, l.entry("AnchorAudit", System, l.module, nil, err))
+	}
+}
+
+// SetSigner sets the signer of the next entries, for the modules that
+// start before the node identity is available. The entries waiting for
+// a signer are written. A nil Logger does nothing
+func (l *Logger) SetSigner(signer Signer) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.signer = signer
+
+	l.verifyHead()
+
+	if err := l.flush(); err != nil {
+		log.Error().Err(err).Msg("failed to write pending audit entries")
+	}
+
+	// the head written before is signed too
+	if signer != nil && l.count > 0 {
+		if err := l.writeHead(); err != nil {
+			log.Error().Err(err).Msg("failed to write audit head")
+		}
+	}
+}
+
+// Bytes returns the bytes of the entry covered by the signature. Each field
+// is prefixed with its length so the content of a field can't be moved to
+// its neighbour without changing the bytes
+func Bytes(e *pkg.AuditEntry) []byte {
+	fields := []string{
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Module, e.Operation, e.Caller, e.Object, e.ParamsHash, e.Error,
+	}
+
+	// the entries recorded before the chain have no previous entry
+	if e.Previous != "" {
+		fields = append(fields, e.Previous)
+	}
+
+	var buf bytes.Buffer
+	for _, s := range fields {
+		fmt.Fprintf(&buf, "%d:%s", len(s), s)
+	}
+
+	return buf.Bytes()
+}
+
+// EntryHash returns the hex encoded sha256 of the entry and its signature,
+// it's the Previous field of the next entry
+func EntryHash(e *pkg.AuditEntry) string {
+	h := sha256.New()
+	h.Write(Bytes(e))
+	h.Write([]byte{'|'})
+	h.Write([]byte(e.Signature))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash returns the hex encoded sha256 of the json encoding of params
+func Hash(params interface{}) string {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(params); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Record appends a new entry to the audit log. Failing to write the audit
+// log never fails the operation itself, the error is only logged.
+// A nil Logger does nothing
+func (l *Logger) Record(op, caller, object string, params interface{}, opErr error) {
+	if l == nil {
+		return
+	}
+
+	entry := l.entry(op, caller, object, params, opErr)
+	if err := l.append(&entry); err != nil {
+		log.Error().Err(err).Str("operation", op).Msg("failed to write audit entry")
+	}
+}
+
+// entry creates the entry of an operation, it's sent to the module logs
+func (l *Logger) entry(op, caller, object string, params interface{}, opErr error) pkg.AuditEntry {
+	entry := pkg.AuditEntry{
+		Time:       l.now(),
+		Module:     l.module,
+		Operation:  op,
+		Caller:     caller,
+		Object:     object,
+		ParamsHash: Hash(params),
+	}
+
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	log.Info().
+		Str("audit", op).
+		Str("caller", caller).
+		Str("object", object).
+		Str("params", entry.ParamsHash).
+		Str("error", entry.Error).
+		Msg("audit")
+
+	return entry
+}
+
+// append queues entry and writes the queued entries. The entries are
+// chained in the order they are written, so it's all done under the lock
+func (l *Logger) append(entry *pkg.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) >= maxPending {
+		log.Error().Str("operation", l.pending[0].Operation).Msg("too many audit entries waiting for a signer, dropping the oldest")
+		l.pending = l.pending[1:]
+	}
+	l.pending = append(l.pending, *entry)
+
+	return l.flush()
+}
+
+// flush chains, signs and writes the pending entries in order, then the
+// head. Once the chain is signed, an entry that can't be signed stays
+// pending with the next ones until it can
+func (l *Logger) flush() error {
+	written := false
+	defer func() {
+		if !written {
+			return
+		}
+		if err := l.writeHead(); err != nil {
+			log.Error().Err(err).Msg("failed to write audit head")
+		}
+	}()
+
+	for len(l.pending) > 0 {
+		entry := &l.pending[0]
+		entry.Previous = l.last
+		entry.Signature = ""
+		if l.signer != nil {
+			sig, err := l.signer.Sign(Bytes(entry))
+			if err != nil {
+				log.Error().Err(err).Msg("failed to sign audit entry")
+			} else {
+				entry.Signature = hex.EncodeToString(sig)
+			}
+		}
+
+		if l.signed && entry.Signature == "" {
+			return nil
+		}
+
+		if err := l.rotate(); err != nil {
+			return errors.Wrap(err, "failed to rotate audit log")
+		}
+
+		if err := l.write(entry); err != nil {
+			return err
+		}
+
+		l.last = EntryHash(entry)
+		l.count++
+		l.signed = l.signed || entry.Signature != ""
+		l.pending = l.pending[1:]
+		written = true
+	}
+
+	return nil
+}
+
+func (l *Logger) write(entry *pkg.AuditEntry) error {
+	file, err := os.OpenFile(l.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_SYNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(entry)
+}
+
+// writeHead anchors the last entry of the chain, the head is replaced
+// so it's never half written
+func (l *Logger) writeHead() error {
+	h := head{Count: l.count, Last: l.last}
+	if l.signer != nil {
+		sig, err := l.signer.Sign(h.bytes())
+		if err != nil {
+			return errors.Wrap(err, "failed to sign audit head")
+		}
+		h.Signature = hex.EncodeToString(sig)
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	path := headPath(l.root, l.module)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// head anchors the tail of the chain of a module. Without it the last
+// entries could be removed without breaking the chain. It doesn't detect
+// the replay of an older head together with the entries it anchors, the
+// entries are also shipped with the logs of the module for that
+type head struct {
+	// Count is the number of entries of the chain
+	Count int `json:"count"`
+	// Last is the hash of the last entry
+	Last string `json:"last"`
+	// Signature of the head by the node identity (hex encoded)
+	Signature string `json:"signature,omitempty"`
+}
+
+// bytes returns the bytes of the head covered by the signature
+func (h *head) bytes() []byte {
+	return []byte(fmt.Sprintf("head|%d|%s", h.Count, h.Last))
+}
+
+func headPath(root, module string) string {
+	return filepath.Join(root, module+headExt)
+}
+
+func readHead(root, module string) (*head, error) {
+	data, err := ioutil.ReadFile(headPath(root, module))
+	if err != nil {
+		return nil, err
+	}
+
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func (l *Logger) path() string {
+	return filepath.Join(l.root, l.module+ext)
+}
+
+// rotate moves the file to a new segment once it's full, the segments
+// are numbered in the order they were written
+func (l *Logger) rotate() error {
+	stat, err := os.Stat(l.path())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if stat.Size() <= maxSize {
+		return nil
+	}
+
+	paths, err := segments(l.root, l.module)
+	if err != nil {
+		return err
+	}
+
+	// the current file is the last one
+	next := len(paths)
+	if len(paths) > 1 {
+		_, last, _ := segment(filepath.Base(paths[len(paths)-2]))
+		next = last + 1
+	}
+
+	return os.Rename(l.path(), fmt.Sprintf("%s.%d", l.path(), next))
+}
+
+// segment returns the module and the number of the segment of an audit
+// file name, the current file of a module has number 0. ok is false if name
+// is not an audit file
+func segment(name string) (module string, n int, ok bool) {
+	idx := strings.LastIndex(name, ext)
+	if idx <= 0 {
+		return "", 0, false
+	}
+
+	module, suffix := name[:idx], name[idx+len(ext):]
+	if suffix == "" {
+		return module, 0, true
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(suffix, "."))
+	if err != nil || n <= 0 || !strings.HasPrefix(suffix, ".") {
+		return "", 0, false
+	}
+
+	return module, n, true
+}
+
+// segments returns the audit files of module in the order they were
+// written, the current file last
+func segments(root, module string) ([]string, error) {
+	infos, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		path string
+		n    int
+	}
+
+	var files []file
+	for _, info := range infos {
+		m, n, ok := segment(info.Name())
+		if info.IsDir() || !ok || m != module {
+			continue
+		}
+
+		// the current file sorts after the segments
+		if n == 0 {
+			n = int(^uint(0) >> 1)
+		}
+		files = append(files, file{path: filepath.Join(root, info.Name()), n: n})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].n < files[j].n
+	})
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+
+	return paths, nil
+}
+
+// Reader gives read access to all the audit logs stored in a directory
+type Reader struct {
+	root     string
+	verifier Verifier
+}
+
+// NewReader creates a new audit log reader. verifier checks the signatures
+// of the entries, it can be nil in that case only the chains are verified
+func NewReader(root string, verifier Verifier) *Reader {
+	return &Reader{root: root, verifier: verifier}
+}
+
+var _ pkg.Auditor = (*Reader)(nil)
+
+// Query implements pkg.Auditor
+func (r *Reader) Query(filter pkg.AuditFilter) ([]pkg.AuditEntry, error) {
+	infos, err := ioutil.ReadDir(r.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []pkg.AuditEntry
+	for _, info := range infos {
+		name := info.Name()
+		if _, _, ok := segment(name); info.IsDir() || !ok {
+			continue
+		}
+
+		err := scan(filepath.Join(r.root, name), func(e *pkg.AuditEntry) error {
+			if match(e, filter) {
+				entries = append(entries, *e)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read audit file '%s'", name)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
+
+func match(e *pkg.AuditEntry, filter pkg.AuditFilter) bool {
+	if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
+		return false
+	}
+
+	if filter.Module != "" && e.Module != filter.Module {
+		return false
+	}
+
+	if filter.Object != "" && e.Object != filter.Object {
+		return false
+	}
+
+	return true
+}
+
+// Verify implements pkg.Auditor
+func (r *Reader) Verify(module string) error {
+	modules := []string{module}
+	if module == "" {
+		infos, err := ioutil.ReadDir(r.root)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		modules = nil
+		for _, info := range infos {
+			if m, n, ok := segment(info.Name()); !info.IsDir() && ok && n == 0 {
+				modules = append(modules, m)
+			}
+		}
+	}
+
+	for _, module := range modules {
+		if err := r.verify(module); err != nil {
+			return errors.Wrapf(err, "audit log of %s", module)
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) verify(module string) error {
+	paths, err := segments(r.root, module)
+	if err != nil {
+		return err
+	}
+
+	head, err := readHead(r.root, module)
+	if os.IsNotExist(err) {
+		if len(paths) > 0 {
+			return fmt.Errorf("no audit head, the end of the log can't be verified")
+		}
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "invalid audit head")
+	}
+
+	// the entries recorded before the chain was introduced have no
+	// previous entry, once an entry has one all the next ones must
+	// follow it. Once an entry is signed all the next ones must be
+	var previous string
+	var chained, signed bool
+	var count int
+	anchored := head.Count == 0
+	for _, path := range paths {
+		err := scan(path, func(e *pkg.AuditEntry) error {
+			chained = chained || e.Previous != ""
+			if chained && e.Previous != previous {
+				return fmt.Errorf("%s of %s at %s doesn't follow the previous entry", e.Operation, e.Object, e.Time)
+			}
+
+			if signed && e.Signature == "" {
+				return fmt.Errorf("%s of %s at %s is not signed, the previous entries are", e.Operation, e.Object, e.Time)
+			}
+
+			if e.Signature != "" && r.verifier != nil {
+				if err := verifySignature(r.verifier, Bytes(e), e.Signature); err != nil {
+					return errors.Wrapf(err, "invalid signature of %s of %s at %s", e.Operation, e.Object, e.Time)
+				}
+			}
+
+			previous = EntryHash(e)
+			count++
+			signed = signed || e.Signature != ""
+			// the entries written after the head, by a module that
+			// crashed before it could update it, are checked like the others
+			anchored = anchored || (count == head.Count && previous == head.Last)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "audit file '%s'", filepath.Base(path))
+		}
+	}
+
+	if !anchored {
+		return fmt.Errorf("audit log truncated, the head anchors %d entries, %d found", head.Count, count)
+	}
+
+	// a log rewritten without signatures is not accepted either
+	if (signed || r.verifier != nil) && count > 0 && head.Signature == "" {
+		return fmt.Errorf("audit head is not signed")
+	}
+
+	if head.Signature != "" && r.verifier != nil {
+		if err := verifySignature(r.verifier, head.bytes(), head.Signature); err != nil {
+			return errors.Wrap(err, "invalid signature of audit head")
+		}
+	}
+
+	return nil
+}
+
+func verifySignature(verifier Verifier, message []byte, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	return verifier.Verify(message, sig)
+}
+
+// scan calls fn with each entry of the audit file at path
+func scan(path string, fn func(e *pkg.AuditEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry pkg.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a crash during a write can leave a truncated line
+			log.Warn().Err(err).Str("file", path).Msg("skipping malformed audit entry")
+			continue
+		}
+
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to scan audit file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// testSigner signs with the hash of the message, it's enough
+// to detect an altered entry
+type testSigner struct{}
+
+func (testSigner) Sign(message []byte) ([]byte, error) {
+	sum := sha256.Sum256(message)
+	return sum[:], nil
+}
+
+func (testSigner) Verify(message, sig []byte) error {
+	sum := sha256.Sum256(message)
+	if !bytes.Equal(sum[:], sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func TestRecordAndQuery(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+
+	network, err := New(root, "network", testSigner{})
+	require.NoError(t, err)
+	network.now = func() time.Time { return now }
+
+	storage, err := New(root, "storage", nil)
+	require.NoError(t, err)
+	storage.now = func() time.Time { return now.Add(time.Second) }
+
+	network.Record("CreateNR", "", "net1", "params", nil)
+	storage.Record("Allocate", "", "ns1", "params", fmt.Errorf("no space"))
+
+	reader := NewReader(root, testSigner{})
+	entries, err := reader.Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// most recent first
+	assert.Equal(t, "storage", entries[0].Module)
+	assert.Equal(t, "no space", entries[0].Error)
+	assert.Empty(t, entries[0].Signature)
+
+	assert.Equal(t, "network", entries[1].Module)
+	assert.Equal(t, "CreateNR", entries[1].Operation)
+	assert.Equal(t, Hash("params"), entries[1].ParamsHash)
+	assert.NotEmpty(t, entries[1].Signature)
+
+	entries, err = reader.Query(pkg.AuditFilter{Object: "net1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	entries, err = reader.Query(pkg.AuditFilter{Since: now.Add(500 * time.Millisecond)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "ns1", entries[0].Object)
+
+	entries, err = reader.Query(pkg.AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	assert.NotPanics(t, func() {
+		l.Record("op", "", "object", nil, nil)
+	})
+}
+
+func TestBytes(t *testing.T) {
+	now := time.Now()
+	entry := pkg.AuditEntry{Time: now, Module: "storage", Operation: "CreateFilesystem", Caller: "a|b", Object: "c"}
+	moved := pkg.AuditEntry{Time: now, Module: "storage", Operation: "CreateFilesystem", Caller: "a", Object: "b|c"}
+	assert.NotEqual(t, Bytes(&entry), Bytes(&moved))
+
+	// the previous entry is signed too
+	chained := entry
+	chained.Previous = "abcd"
+	assert.NotEqual(t, Bytes(&entry), Bytes(&chained))
+	assert.True(t, bytes.HasSuffix(Bytes(&chained), []byte("4:abcd")))
+}
+
+func TestChain(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// the first entries are recorded before the identity is available
+	logger, err := New(root, "storage", nil)
+	require.NoError(t, err)
+	logger.Record("CreateVolume", "user", "vol1", "params", nil)
+	logger.SetSigner(testSigner{})
+	logger.Record("CreateVolume", "user", "vol2", "params", nil)
+
+	// a new logger continues the chain
+	logger, err = New(root, "storage", testSigner{})
+	require.NoError(t, err)
+	logger.Record("ReleaseFilesystem", "user", "vol1", "params", nil)
+
+	reader := NewReader(root, testSigner{})
+	require.NoError(t, reader.Verify("storage"))
+	require.NoError(t, reader.Verify(""))
+
+	entries, err := reader.Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Empty(t, entries[2].Signature)
+	assert.NotEmpty(t, entries[1].Signature)
+	assert.Equal(t, EntryHash(&entries[1]), entries[0].Previous)
+
+	path := filepath.Join(root, "storage"+ext)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+
+	// an altered entry breaks the chain, and its signature if signed
+	altered := strings.Replace(string(data), `"object":"vol1"`, `"object":"vol3"`, 1)
+	require.NoError(t, ioutil.WriteFile(path, []byte(altered), 0600))
+	require.Error(t, reader.Verify("storage"))
+
+	altered = strings.Replace(string(data), `"object":"vol2"`, `"object":"vol3"`, 1)
+	require.NoError(t, ioutil.WriteFile(path, []byte(altered), 0600))
+	require.Error(t, reader.Verify("storage"))
+
+	// and so does a removed entry
+	require.NoError(t, ioutil.WriteFile(path, []byte(lines[0]+lines[2]), 0600))
+	require.Error(t, reader.Verify("storage"))
+}
+
+func TestRotation(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	defer func(size int64) { maxSize = size }(maxSize)
+	maxSize = 1
+
+	logger, err := New(root, "network", testSigner{})
+	require.NoError(t, err)
+	for i := 0; i < 12; i++ {
+		logger.Record("CreateNR", "user", fmt.Sprintf("net%d", i), "params", nil)
+	}
+
+	// the rotated segments are kept, in order
+	paths, err := segments(root, "network")
+	require.NoError(t, err)
+	require.Len(t, paths, 12)
+	assert.Equal(t, filepath.Join(root, "network.log.1"), paths[0])
+	assert.Equal(t, filepath.Join(root, "network.log.11"), paths[10])
+	assert.Equal(t, filepath.Join(root, "network.log"), paths[11])
+
+	reader := NewReader(root, testSigner{})
+	require.NoError(t, reader.Verify("network"))
+
+	entries, err := reader.Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 12)
+}
+
+func TestSignedChain(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	logger, err := New(root, "storage", testSigner{})
+	require.NoError(t, err)
+	logger.Record("CreateVolume", "user", "vol1", "params", nil)
+
+	// the module restarts before the identity is available, the
+	// entries wait for the signer
+	logger, err = New(root, "storage", nil)
+	require.NoError(t, err)
+	logger.Record("CreateVolume", "user", "vol2", "params", nil)
+
+	reader := NewReader(root, testSigner{})
+	entries, err := reader.Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, reader.Verify("storage"))
+
+	logger.SetSigner(testSigner{})
+	entries, err = reader.Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.NotEmpty(t, entries[0].Signature)
+	require.NoError(t, reader.Verify("storage"))
+
+	// an unsigned entry after a signed one is rejected
+	path := filepath.Join(root, "storage"+ext)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+
+	entry := entries[0]
+	entry.Signature = ""
+	entry.Previous = EntryHash(&entries[1])
+	unsigned, err := json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, []byte(lines[0]+string(unsigned)+"\n"), 0600))
+	require.Error(t, reader.Verify("storage"))
+}
+
+func TestHead(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	logger, err := New(root, "network", testSigner{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		logger.Record("CreateNR", "user", fmt.Sprintf("net%d", i), "params", nil)
+	}
+
+	reader := NewReader(root, testSigner{})
+	require.NoError(t, reader.Verify("network"))
+
+	// removing the last entries doesn't break the chain, but the head
+	path := filepath.Join(root, "network"+ext)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.NoError(t, ioutil.WriteFile(path, []byte(lines[0]+lines[1]), 0600))
+	require.Error(t, reader.Verify("network"))
+
+	// the head can't be rewritten without the node identity
+	var last pkg.AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &last))
+	h, err := readHead(root, "network")
+	require.NoError(t, err)
+	h.Count = 2
+	h.Last = EntryHash(&last)
+	data, err = json.Marshal(h)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(headPath(root, "network"), data, 0600))
+	require.Error(t, reader.Verify("network"))
+
+	// the forged head is recorded when the module starts again
+	_, err = New(root, "network", testSigner{})
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify("network"))
+
+	entries, err := reader.Query(pkg.AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "AnchorAudit", entries[0].Operation)
+	assert.Contains(t, entries[0].Error, "invalid signature of audit head")
+
+	// and so is a truncation
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines = strings.SplitAfter(string(data), "\n")
+	require.NoError(t, ioutil.WriteFile(path, []byte(lines[0]), 0600))
+	require.Error(t, reader.Verify("network"))
+
+	_, err = New(root, "network", testSigner{})
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify("network"))
+
+	entries, err = reader.Query(pkg.AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0].Error, "truncated")
+
+	// and so is a removed head
+	require.NoError(t, os.Remove(headPath(root, "network")))
+	require.Error(t, reader.Verify("network"))
+
+	_, err = New(root, "network", testSigner{})
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify("network"))
+}
+
+func TestPendingLimit(t *testing.T) {
+	root, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	defer func(max int) { maxPending = max }(maxPending)
+	maxPending = 2
+
+	logger, err := New(root, "storage", testSigner{})
+	require.NoError(t, err)
+	logger.Record("CreateVolume", "user", "vol0", "params", nil)
+
+	logger, err = New(root, "storage", nil)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		logger.Record("CreateVolume", "user", fmt.Sprintf("vol%d", i), "params", nil)
+	}
+
+	// the oldest entry waiting for the signer is dropped
+	logger.SetSigner(testSigner{})
+	entries, err := NewReader(root, nil).Query(pkg.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "vol3", entries[0].Object)
+	assert.Equal(t, "vol2", entries[1].Object)
+}
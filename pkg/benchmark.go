@@ -0,0 +1,39 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module monitor -version 0.0.1 -name benchmark -package stubs github.com/threefoldtech/zos/pkg+Benchmarker stubs/benchmarker_stub.go
+
+import "time"
+
+// BenchmarkResult is the result of a single benchmark
+type BenchmarkResult struct {
+	// Name of the benchmark, like cpu_multi or disk_write
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	// Unit of the value, like MB/s or ms
+	Unit string `json:"unit"`
+	// Error is set if the benchmark failed, the value is then 0
+	Error string `json:"error,omitempty"`
+}
+
+// Scorecard is the signed result of a run of the benchmarks of the node
+type Scorecard struct {
+	NodeID string    `json:"node_id"`
+	Time   time.Time `json:"time"`
+	// HardwareFingerprint ties the scorecard to the hardware it ran on
+	HardwareFingerprint string            `json:"hardware_fingerprint"`
+	Results             []BenchmarkResult `json:"results"`
+	// Signature is the hex encoded signature of the scorecard by the node
+	Signature string `json:"signature"`
+}
+
+// Benchmarker runs the benchmarks of the node on demand
+type Benchmarker interface {
+	// Run runs all the benchmarks, stores and returns the signed scorecard.
+	// It takes a few minutes and fails if a run is already in progress
+	Run() (Scorecard, error)
+	// Scorecards returns the stored scorecards, most recent first
+	Scorecards() ([]Scorecard, error)
+	// Report sends the most recent scorecard to the directory
+	Report() error
+}
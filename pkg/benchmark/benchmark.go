@@ -0,0 +1,301 @@
+// Package benchmark measures the performance of the node: cpu, memory
+// bandwidth, disk and network throughput to reference endpoints. The results
+// are signed by the node identity in a scorecard, so the capacity buyers can
+// compare the nodes and check the scorecards were not altered.
+package benchmark
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity"
+)
+
+const (
+	ext = ".json"
+	// keep is the number of scorecards kept on the node
+	keep = 10
+)
+
+// DefaultEndpoints are the reference endpoints of the network benchmark
+var DefaultEndpoints = []string{
+	"https://hub.grid.tf/api/flist",
+}
+
+// Config of the benchmarks
+type Config struct {
+	// Duration of every cpu and memory benchmark
+	Duration time.Duration
+	// DiskSize is the number of bytes written by the disk benchmark
+	DiskSize int64
+	// Endpoints are the urls downloaded by the network benchmark
+	Endpoints []string
+	// Directory is the base url of the directory the scorecards are reported to
+	Directory string
+}
+
+// DefaultConfig is the configuration used by the node
+var DefaultConfig = Config{
+	Duration:  10 * time.Second,
+	DiskSize:  1024 * mb,
+	Endpoints: DefaultEndpoints,
+}
+
+// Runner implements pkg.Benchmarker
+type Runner struct {
+	root      string
+	config    Config
+	identity  pkg.IdentityManager
+	inventory pkg.InventoryManager
+	client    http.Client
+
+	mu      sync.Mutex
+	running bool
+}
+
+var _ pkg.Benchmarker = (*Runner)(nil)
+
+// New creates a new benchmark runner, the scorecards and the files of the
+// disk benchmark are stored under root
+func New(root string, config Config, identity pkg.IdentityManager, inventory pkg.InventoryManager) (*Runner, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create benchmark directory")
+	}
+
+	return &Runner{
+		root:      root,
+		config:    config,
+		identity:  identity,
+		inventory: inventory,
+		client:    http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// Bytes returns the bytes of the scorecard covered by the signature
+func Bytes(s *pkg.Scorecard) []byte {
+	c := *s
+	c.Signature = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		// the scorecard only holds strings and numbers
+		panic(err)
+	}
+
+	return data
+}
+
+func result(name, unit string, value float64, err error) pkg.BenchmarkResult {
+	r := pkg.BenchmarkResult{Name: name, Unit: unit}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	r.Value = value
+	return r
+}
+
+// results runs all the benchmarks, a failing benchmark doesn't stop the others
+func (r *Runner) results() []pkg.BenchmarkResult {
+	var results []pkg.BenchmarkResult
+	add := func(name, unit string, value float64, err error) {
+		if err != nil {
+			log.Error().Err(err).Str("benchmark", name).Msg("benchmark failed")
+		}
+		results = append(results, result(name, unit, value, err))
+	}
+
+	v, err := cpuSingle(r.config.Duration)
+	add("cpu_single", "MB/s", v, err)
+	v, err = cpuMulti(r.config.Duration)
+	add("cpu_multi", "MB/s", v, err)
+	v, err = memoryBandwidth(r.config.Duration)
+	add("memory_bandwidth", "MB/s", v, err)
+
+	v, err = diskWrite(r.root, r.config.DiskSize)
+	add("disk_write", "MB/s", v, err)
+	v, err = diskRead(r.root)
+	add("disk_read", "MB/s", v, err)
+
+	for _, endpoint := range r.config.Endpoints {
+		latency, rate, err := download(&r.client, endpoint)
+		add("network_latency:"+host(endpoint), "ms", latency, err)
+		add("network_download:"+host(endpoint), "MB/s", rate, err)
+	}
+
+	return results
+}
+
+// Run implements pkg.Benchmarker
+func (r *Runner) Run() (pkg.Scorecard, error) {
+	var scorecard pkg.Scorecard
+	// a benchmark running at the same time would skew the results
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return scorecard, fmt.Errorf("a benchmark is already running")
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	inv, err := r.inventory.Inventory()
+	if err != nil {
+		return scorecard, errors.Wrap(err, "failed to get node inventory")
+	}
+
+	nodeID, err := r.identity.NodeID()
+	if err != nil {
+		return scorecard, errors.Wrap(err, "failed to get node identity")
+	}
+
+	log.Info().Msg("running benchmarks")
+	scorecard = pkg.Scorecard{
+		NodeID:              nodeID.Identity(),
+		Time:                time.Now(),
+		HardwareFingerprint: capacity.HardwareFingerprint(inv),
+		Results:             r.results(),
+	}
+
+	sig, err := r.identity.Sign(Bytes(&scorecard))
+	if err != nil {
+		return scorecard, errors.Wrap(err, "failed to sign scorecard")
+	}
+	scorecard.Signature = hex.EncodeToString(sig)
+
+	if err := r.store(scorecard); err != nil {
+		return scorecard, errors.Wrap(err, "failed to store scorecard")
+	}
+
+	return scorecard, nil
+}
+
+func (r *Runner) store(s pkg.Scorecard) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d%s", s.Time.Unix(), ext)
+	if err := ioutil.WriteFile(filepath.Join(r.root, name), data, 0644); err != nil {
+		return err
+	}
+
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			log.Error().Err(err).Str("file", files[i]).Msg("failed to delete old scorecard")
+		}
+	}
+
+	return nil
+}
+
+// files returns the scorecard files, most recent first
+func (r *Runner) files() ([]string, error) {
+	entries, err := ioutil.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ext) {
+			continue
+		}
+		files = append(files, filepath.Join(r.root, entry.Name()))
+	}
+
+	// the files are named after the unix time of the scorecard
+	sort.Slice(files, func(i, j int) bool {
+		a, b := filepath.Base(files[i]), filepath.Base(files[j])
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a > b
+	})
+
+	return files, nil
+}
+
+// Scorecards implements pkg.Benchmarker
+func (r *Runner) Scorecards() ([]pkg.Scorecard, error) {
+	files, err := r.files()
+	if err != nil {
+		return nil, err
+	}
+
+	scorecards := make([]pkg.Scorecard, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var s pkg.Scorecard
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, errors.Wrapf(err, "invalid scorecard %s", file)
+		}
+		scorecards = append(scorecards, s)
+	}
+
+	return scorecards, nil
+}
+
+// Report implements pkg.Benchmarker. The scorecard is sent as is, the
+// directory checks its signature against the node identity
+func (r *Runner) Report() error {
+	if len(r.config.Directory) == 0 {
+		return fmt.Errorf("no directory configured")
+	}
+
+	scorecards, err := r.Scorecards()
+	if err != nil {
+		return err
+	}
+	if len(scorecards) == 0 {
+		return fmt.Errorf("no scorecard, run the benchmarks first")
+	}
+
+	s := scorecards[0]
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/nodes/%s/scorecard", strings.TrimSuffix(r.config.Directory, "/"), s.NodeID)
+	response, err := r.client.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to report scorecard")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("failed to report scorecard (%s): %s", response.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
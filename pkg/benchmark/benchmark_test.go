@@ -0,0 +1,115 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+type testIdentity struct {
+	pkg.IdentityManager
+}
+
+func (testIdentity) NodeID() (pkg.StrIdentifier, error) {
+	return pkg.StrIdentifier("node"), nil
+}
+
+func (testIdentity) Sign(message []byte) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d", len(message))), nil
+}
+
+type testInventory struct {
+	pkg.InventoryManager
+}
+
+func (testInventory) Inventory() (pkg.Inventory, error) {
+	return pkg.Inventory{NICs: []string{"aa:bb:cc:00:00:01"}}, nil
+}
+
+func TestRun(t *testing.T) {
+	var reported pkg.Scorecard
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/reference":
+			w.Write([]byte(strings.Repeat("x", 1024)))
+		case "/nodes/node/scorecard":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&reported))
+		}
+	}))
+	defer server.Close()
+
+	root, err := ioutil.TempDir("", "benchmark")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	runner, err := New(root, Config{
+		Duration:  10 * time.Millisecond,
+		DiskSize:  8 * mb,
+		Endpoints: []string{server.URL + "/reference", "http://localhost:1/unreachable"},
+		Directory: server.URL,
+	}, testIdentity{}, testInventory{})
+	require.NoError(t, err)
+
+	require.Error(t, runner.Report())
+
+	scorecard, err := runner.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "node", scorecard.NodeID)
+	assert.Len(t, scorecard.HardwareFingerprint, 64)
+	assert.Equal(t, fmt.Sprintf("%x", fmt.Sprintf("%d", len(Bytes(&scorecard)))), scorecard.Signature)
+
+	results := make(map[string]pkg.BenchmarkResult)
+	for _, r := range scorecard.Results {
+		results[r.Name] = r
+	}
+
+	for _, name := range []string{"cpu_single", "cpu_multi", "memory_bandwidth", "disk_write", "disk_read"} {
+		assert.Empty(t, results[name].Error, name)
+		assert.True(t, results[name].Value > 0, name)
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Empty(t, results["network_latency:"+host].Error)
+	assert.True(t, results["network_download:"+host].Value > 0)
+	assert.NotEmpty(t, results["network_download:localhost:1"].Error)
+
+	scorecards, err := runner.Scorecards()
+	require.NoError(t, err)
+	require.Len(t, scorecards, 1)
+	// the signature still covers the stored scorecard
+	assert.Equal(t, Bytes(&scorecard), Bytes(&scorecards[0]))
+
+	require.NoError(t, runner.Report())
+	assert.Equal(t, scorecard.Signature, reported.Signature)
+}
+
+func TestStoreKeep(t *testing.T) {
+	root, err := ioutil.TempDir("", "benchmark")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	runner, err := New(root, DefaultConfig, testIdentity{}, testInventory{})
+	require.NoError(t, err)
+
+	start := time.Unix(999999990, 0)
+	for i := 0; i < keep+5; i++ {
+		require.NoError(t, runner.store(pkg.Scorecard{Time: start.Add(time.Duration(i) * time.Second)}))
+	}
+
+	scorecards, err := runner.Scorecards()
+	require.NoError(t, err)
+	require.Len(t, scorecards, keep)
+	// the unix times cross a power of ten, the most recent is still first
+	assert.Equal(t, start.Add(time.Duration(keep+4)*time.Second).Unix(), scorecards[0].Time.Unix())
+	assert.Equal(t, start.Add(5*time.Second).Unix(), scorecards[keep-1].Time.Unix())
+}
@@ -0,0 +1,171 @@
+package benchmark
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	mb = 1024 * 1024
+
+	// cpuBlock is the size of the blocks hashed by the cpu benchmarks
+	cpuBlock = 1 * mb
+	// memoryBlock is the size of the buffers copied by the memory benchmark,
+	// it's big enough to not fit in the caches of the cpu
+	memoryBlock = 64 * mb
+	// diskBlock is the size of the writes of the disk benchmark
+	diskBlock = 4 * mb
+	// maxDownload is the max number of bytes read from an endpoint
+	maxDownload = 256 * mb
+)
+
+// hashRate hashes blocks on n goroutines for d and returns the rate in MB/s
+func hashRate(n int, d time.Duration) float64 {
+	block := make([]byte, cpuBlock)
+	rand.Read(block)
+
+	var wg sync.WaitGroup
+	counts := make([]int, n)
+	deadline := time.Now().Add(d)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				sha256.Sum256(block)
+				counts[i]++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+
+	return float64(total*cpuBlock) / mb / d.Seconds()
+}
+
+// cpuSingle is the hashing rate of a single cpu
+func cpuSingle(d time.Duration) (float64, error) {
+	return hashRate(1, d), nil
+}
+
+// cpuMulti is the hashing rate of all the cpus
+func cpuMulti(d time.Duration) (float64, error) {
+	return hashRate(runtime.NumCPU(), d), nil
+}
+
+// memoryBandwidth copies a buffer for d and returns the rate in MB/s
+func memoryBandwidth(d time.Duration) (float64, error) {
+	src := make([]byte, memoryBlock)
+	dst := make([]byte, memoryBlock)
+	rand.Read(src)
+
+	var count int
+	start := time.Now()
+	for time.Since(start) < d {
+		copy(dst, src)
+		count++
+	}
+
+	return float64(count*memoryBlock) / mb / time.Since(start).Seconds(), nil
+}
+
+// diskWrite writes size bytes to a file in dir and syncs it, it returns the
+// rate in MB/s. The file is kept for diskRead
+func diskWrite(dir string, size int64) (float64, error) {
+	f, err := os.Create(filepath.Join(dir, "disk.bench"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	block := make([]byte, diskBlock)
+	rand.Read(block)
+
+	start := time.Now()
+	for written := int64(0); written < size; written += diskBlock {
+		if _, err := f.Write(block); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return float64(size) / mb / time.Since(start).Seconds(), nil
+}
+
+// diskRead reads the file written by diskWrite and returns the rate in MB/s.
+// The file is dropped from the page cache first so it's read from the disk
+func diskRead(dir string) (float64, error) {
+	path := filepath.Join(dir, "disk.bench")
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+		return 0, errors.Wrap(err, "failed to drop file from cache")
+	}
+
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, f)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(n) / mb / time.Since(start).Seconds(), nil
+}
+
+// download gets the endpoint and returns the time to the first byte in ms
+// and the download rate in MB/s
+func download(client *http.Client, endpoint string) (latency float64, rate float64, err error) {
+	start := time.Now()
+	response, err := client.Get(endpoint)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("unexpected response: %s", response.Status)
+	}
+	latency = float64(time.Since(start)) / float64(time.Millisecond)
+
+	start = time.Now()
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxDownload))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return latency, float64(n) / mb / time.Since(start).Seconds(), nil
+}
+
+// host returns the host of an endpoint, it names the network results
+func host(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return endpoint
+	}
+
+	return u.Host
+}
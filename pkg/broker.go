@@ -0,0 +1,26 @@
+package pkg
+
+import "time"
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module broker -version 0.0.1 -name broker -package stubs github.com/threefoldtech/zos/pkg+Broker stubs/broker_stub.go
+
+// Broker runs the privileged operations the modules are not allowed
+// to do themselves once they dropped their capabilities
+type Broker interface {
+	// ConfigureCoreDumps makes the kernel hand the core dumps of the
+	// processes to contd, which stores them under root
+	ConfigureCoreDumps(root string) error
+	// LoadKernelModule loads a kernel module with its parameters (key=value),
+	// only the modules needed by the 0-OS modules can be loaded
+	LoadKernelModule(name string, params []string) error
+	// Mount mounts source on target with the mount(8) options, only the
+	// file systems the modules need can be mounted, in their directories
+	Mount(source, target, fstype string, options []string) error
+	// Probe runs a tcp or http check of address from inside the network
+	// namespace netns, path is the path of the http request
+	Probe(netns, kind, address, path string, timeout time.Duration) error
+	// Unmount lazily unmounts target, a mount point in the directories
+	// the broker mounts in
+	Unmount(target string) error
+}
@@ -0,0 +1,141 @@
+// Package cancel propagates the deadline and the cancellation of a zbus call
+// from the caller to the called module, so an operation the caller gave up
+// on (a download from an unreachable hub, a slow disk) is aborted instead of
+// keeping a worker of the module busy.
+//
+// zbus requests carry no context, so the caller publishes the deadline of
+// its call in the redis of the message broker under the key of the call (the
+// module, the method and the object the call is about), and publishes a
+// cancellation on the same key if its context is canceled before the call
+// returns. The called module derives the context of the operation from them,
+// bounded by a timeout of its own.
+package cancel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Store hands the deadlines and the cancellations of the calls over to the
+// called modules
+type Store interface {
+	// Begin publishes the deadline of the call key, a zero deadline means
+	// the caller waits forever
+	Begin(key string, deadline time.Time) error
+	// Cancel publishes the cancellation of the call key
+	Cancel(key string) error
+	// End removes the call key once it returned
+	End(key string) error
+	// Watch returns the deadline of the call key, and a channel closed when
+	// the call is canceled. The watch stops when ctx is done
+	Watch(ctx context.Context, key string) (time.Time, <-chan struct{}, error)
+}
+
+// callKey is the key of a call to method of module about the object key
+func callKey(module, method, key string) string {
+	return fmt.Sprintf("cancel:%s:%s:%s", module, method, key)
+}
+
+var (
+	module string
+	store  Store
+	mu     sync.RWMutex
+)
+
+// Set sets the name of the running module and the store the calls are
+// handed over through. Without store, the calls are only bounded by the
+// timeouts of the called modules
+func Set(name string, s Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	module, store = name, s
+}
+
+func current() (string, Store) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return module, store
+}
+
+// Call hands the deadline of ctx over to the method of module called about
+// the object key, and cancels the call if ctx is done before the returned
+// function is called. The returned function must be called once the call
+// returned
+func Call(ctx context.Context, module, method, key string) func() {
+	_, store := current()
+	if store == nil {
+		return func() {}
+	}
+
+	key = callKey(module, method, key)
+	deadline, _ := ctx.Deadline()
+	if err := store.Begin(key, deadline); err != nil {
+		log.Debug().Err(err).Str("call", key).Msg("failed to hand over call deadline")
+		return func() {}
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		select {
+		case <-returned:
+		case <-ctx.Done():
+			if err := store.Cancel(key); err != nil {
+				log.Error().Err(err).Str("call", key).Msg("failed to cancel call")
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(returned)
+			if err := store.End(key); err != nil {
+				log.Debug().Err(err).Str("call", key).Msg("failed to end call")
+			}
+		})
+	}
+}
+
+// Serve returns the context of the operation of the call to method about
+// the object key. The context is done after timeout, at the deadline of the
+// caller or when the caller cancels the call, whichever comes first. The
+// returned function must be called once the operation is done
+func Serve(parent context.Context, method, key string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	module, store := current()
+	if store == nil {
+		return ctx, cancel
+	}
+
+	deadline, canceled, err := store.Watch(ctx, callKey(module, method, key))
+	if err != nil {
+		log.Debug().Err(err).Str("method", method).Msg("failed to watch call cancellation")
+		return ctx, cancel
+	}
+
+	if !deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+		parentCancel := cancel
+		cancel = func() {
+			cancelDeadline()
+			parentCancel()
+		}
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-canceled:
+			log.Info().Str("method", method).Str("key", key).Msg("call canceled by the caller, aborting")
+			cancel()
+		}
+	}()
+
+	return ctx, cancel
+}
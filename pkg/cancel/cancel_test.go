@@ -0,0 +1,98 @@
+package cancel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeWithoutStore(t *testing.T) {
+	require := require.New(t)
+	Set("flist", nil)
+
+	ctx, release := Serve(context.Background(), "Mount", "url", time.Minute)
+	defer release()
+
+	deadline, ok := ctx.Deadline()
+	require.True(ok)
+	require.WithinDuration(time.Now().Add(time.Minute), deadline, time.Second)
+
+	done := Call(context.Background(), "flist", "Mount", "url")
+	done()
+}
+
+func TestServeDeadline(t *testing.T) {
+	require := require.New(t)
+	Set("flist", NewMemoryStore())
+	defer Set("", nil)
+
+	caller, cancelCaller := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelCaller()
+
+	done := Call(caller, "flist", "NamedMount", "1-1")
+	defer done()
+
+	ctx, release := Serve(context.Background(), "NamedMount", "1-1", time.Hour)
+	defer release()
+
+	expected, _ := caller.Deadline()
+	deadline, ok := ctx.Deadline()
+	require.True(ok)
+	require.Equal(expected.UnixNano(), deadline.UnixNano())
+
+	// the timeout of the module wins when it's shorter
+	short, releaseShort := Serve(context.Background(), "NamedMount", "1-1", time.Second)
+	defer releaseShort()
+
+	deadline, _ = short.Deadline()
+	require.True(deadline.Before(expected))
+}
+
+func TestServeCanceled(t *testing.T) {
+	require := require.New(t)
+	Set("container", NewMemoryStore())
+	defer Set("", nil)
+
+	caller, cancelCaller := context.WithCancel(context.Background())
+
+	done := Call(caller, "container", "Run", "1-1")
+	defer done()
+
+	ctx, release := Serve(context.Background(), "Run", "1-1", time.Hour)
+	defer release()
+
+	_, ok := ctx.Deadline()
+	require.True(ok)
+	require.NoError(ctx.Err())
+
+	cancelCaller()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		require.Fail("call not canceled")
+	}
+}
+
+func TestEndedCallNotCanceled(t *testing.T) {
+	require := require.New(t)
+	Set("container", NewMemoryStore())
+	defer Set("", nil)
+
+	caller, cancelCaller := context.WithCancel(context.Background())
+
+	done := Call(caller, "container", "Update", "1-1")
+	done()
+	cancelCaller()
+
+	ctx, release := Serve(context.Background(), "Update", "1-1", time.Hour)
+	defer release()
+
+	select {
+	case <-ctx.Done():
+		require.Fail("ended call canceled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
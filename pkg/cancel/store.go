@@ -0,0 +1,196 @@
+package cancel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+const (
+	// callTTL is how long a call is kept if the caller never ends it
+	callTTL = time.Hour
+	// canceled is the value of a canceled call
+	canceled = "canceled"
+)
+
+// redisStore keeps the calls in the redis of the message broker, the
+// cancellations are published on the key of the call
+type redisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore creates a store in the redis server at address, a
+// unix:// or tcp:// url as the one of the message broker
+func NewRedisStore(address string) (Store, error) {
+	pool, err := utils.NewRedisPool(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisStore{pool: pool}, nil
+}
+
+// Init sets the store of module to the redis of the message broker at
+// address
+func Init(module, address string) error {
+	store, err := NewRedisStore(address)
+	if err != nil {
+		return err
+	}
+
+	Set(module, store)
+	return nil
+}
+
+func (s *redisStore) Begin(key string, deadline time.Time) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	var value int64
+	if !deadline.IsZero() {
+		value = deadline.UnixNano()
+	}
+
+	_, err := con.Do("SET", key, value, "EX", int(callTTL.Seconds()))
+	return err
+}
+
+func (s *redisStore) Cancel(key string) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	if _, err := con.Do("SET", key, canceled, "EX", int(callTTL.Seconds())); err != nil {
+		return err
+	}
+
+	_, err := con.Do("PUBLISH", key, canceled)
+	return err
+}
+
+func (s *redisStore) End(key string) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	_, err := con.Do("DEL", key)
+	return err
+}
+
+func (s *redisStore) Watch(ctx context.Context, key string) (time.Time, <-chan struct{}, error) {
+	var deadline time.Time
+
+	sub := redis.PubSubConn{Conn: s.pool.Get()}
+	if err := sub.Subscribe(key); err != nil {
+		sub.Close()
+		return deadline, nil, err
+	}
+
+	// the call is read after subscribing, so a cancellation is never missed
+	con := s.pool.Get()
+	value, err := redis.String(con.Do("GET", key))
+	con.Close()
+	if err != nil && err != redis.ErrNil {
+		sub.Close()
+		return deadline, nil, err
+	}
+
+	ch := make(chan struct{})
+	if value == canceled {
+		sub.Close()
+		close(ch)
+		return deadline, ch, nil
+	}
+
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil && nanos > 0 {
+		deadline = time.Unix(0, nanos)
+	}
+
+	var once sync.Once
+	stop := func() { once.Do(func() { sub.Close() }) }
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	go func() {
+		defer stop()
+		for {
+			switch msg := sub.Receive().(type) {
+			case redis.Message:
+				if string(msg.Data) == canceled {
+					close(ch)
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+
+	return deadline, ch, nil
+}
+
+// memoryStore is a Store local to the process
+type memoryStore struct {
+	mu       sync.Mutex
+	calls    map[string]time.Time
+	canceled map[string]chan struct{}
+}
+
+// NewMemoryStore creates a store local to the process, it's used when the
+// caller and the called module share the same process
+func NewMemoryStore() Store {
+	return &memoryStore{
+		calls:    make(map[string]time.Time),
+		canceled: make(map[string]chan struct{}),
+	}
+}
+
+func (s *memoryStore) channel(key string) chan struct{} {
+	ch, ok := s.canceled[key]
+	if !ok {
+		ch = make(chan struct{})
+		s.canceled[key] = ch
+	}
+	return ch
+}
+
+func (s *memoryStore) Begin(key string, deadline time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key] = deadline
+	s.channel(key)
+	return nil
+}
+
+func (s *memoryStore) Cancel(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := s.channel(key)
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+	return nil
+}
+
+func (s *memoryStore) End(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.calls, key)
+	delete(s.canceled, key)
+	return nil
+}
+
+func (s *memoryStore) Watch(ctx context.Context, key string) (time.Time, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[key], s.channel(key), nil
+}
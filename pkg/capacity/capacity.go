@@ -7,6 +7,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/host"
+	"github.com/threefoldtech/zos/pkg/capacity/devicetree"
 	"github.com/threefoldtech/zos/pkg/capacity/dmi"
 	"github.com/threefoldtech/zos/pkg/capacity/smartctl"
 	"github.com/threefoldtech/zos/pkg/stubs"
@@ -54,8 +55,18 @@ func (r *ResourceOracle) Total() (c *Capacity, err error) {
 	return c, nil
 }
 
-// DMI run and parse dmidecode commands
+// DMI run and parse dmidecode commands. The boards without SMBIOS tables,
+// like the arm64 single board computers, are described by their device tree
 func (r *ResourceOracle) DMI() (*dmi.DMI, error) {
+	if !hasSMBIOS() && devicetree.Available() {
+		tree, err := devicetree.Decode()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read device tree")
+		}
+
+		return tree.DMI(), nil
+	}
+
 	return dmi.Decode()
 }
 
@@ -76,12 +87,19 @@ type Disks struct {
 	Devices     []smartctl.Info `json:"devices"`
 }
 
-// Disks list and parse the hardware information using smartctl
+// Disks list and parse the hardware information using smartctl, the eMMC
+// and SD cards are read from sysfs
 func (r *ResourceOracle) Disks() (d Disks, err error) {
+	mmc, err := mmcDisks(sysBlockPath)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list mmc devices")
+	}
+
 	devices, err := smartctl.ListDevices()
 	if errors.Is(err, smartctl.ErrEmpty) {
 		// TODO: for now we allow to not have the smartctl dump of all the disks
 		log.Warn().Err(err).Msg("smartctl did not found any disk on the system")
+		d.Devices = mmc
 		return d, nil
 	}
 	if err != nil {
@@ -105,6 +123,7 @@ func (r *ResourceOracle) Disks() (d Disks, err error) {
 		}
 	}
 
+	d.Devices = append(d.Devices, mmc...)
 	d.Aggregator = "0-OS smartctl aggregator"
 
 	return
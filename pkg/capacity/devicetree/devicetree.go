@@ -0,0 +1,136 @@
+// Package devicetree reads the hardware description of the boards that are
+// described by a device tree instead of the SMBIOS tables, like most of the
+// arm64 single board computers.
+package devicetree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+)
+
+const (
+	// DecoderVersion is the information about the decoder in this package
+	DecoderVersion = `0-OS Go device tree decoder v0.1.0`
+
+	// Root is where the kernel exposes the device tree of the board
+	Root = "/proc/device-tree"
+)
+
+// DeviceTree is the description of the board from its device tree
+type DeviceTree struct {
+	// Model is the name of the board, as "Raspberry Pi 4 Model B Rev 1.4"
+	Model string `json:"model"`
+	// Compatible are the identifiers of the board, from the most specific to
+	// the most generic, as "raspberrypi,4-model-b" and "brcm,bcm2711"
+	Compatible []string `json:"compatible"`
+	// Serial is the serial number of the board, if the firmware sets it
+	Serial string `json:"serial"`
+}
+
+// Available returns true if the board is described by a device tree
+func Available() bool {
+	_, err := os.Stat(filepath.Join(Root, "compatible"))
+	return err == nil
+}
+
+// Decode reads the device tree of the board
+func Decode() (*DeviceTree, error) {
+	return decode(Root)
+}
+
+func decode(root string) (*DeviceTree, error) {
+	compatible, err := readStrings(filepath.Join(root, "compatible"))
+	if err != nil {
+		return nil, err
+	}
+
+	tree := DeviceTree{Compatible: compatible}
+
+	// the model and the serial number are optional
+	if model, err := readStrings(filepath.Join(root, "model")); err == nil && len(model) != 0 {
+		tree.Model = model[0]
+	}
+
+	if serial, err := readStrings(filepath.Join(root, "serial-number")); err == nil && len(serial) != 0 {
+		tree.Serial = serial[0]
+	}
+
+	return &tree, nil
+}
+
+// readStrings reads a property holding a list of nul terminated strings
+func readStrings(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, value := range strings.Split(string(data), "\x00") {
+		if value = strings.TrimSpace(value); len(value) != 0 {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// Vendor returns the vendor of the board, the prefix of its most specific
+// compatible identifier
+func (t *DeviceTree) Vendor() string {
+	if len(t.Compatible) == 0 {
+		return ""
+	}
+
+	return strings.SplitN(t.Compatible[0], ",", 2)[0]
+}
+
+// SoC returns the system on chip of the board, its most generic compatible
+// identifier
+func (t *DeviceTree) SoC() string {
+	if len(t.Compatible) == 0 {
+		return ""
+	}
+
+	return t.Compatible[len(t.Compatible)-1]
+}
+
+// DMI returns the device tree as the DMI system and processor sections,
+// for the consumers of the DMI information of the node
+func (t *DeviceTree) DMI() *dmi.DMI {
+	property := func(value string) dmi.PropertyData {
+		return dmi.PropertyData{Val: value}
+	}
+
+	section := func(typ dmi.Type, name, title string, properties map[string]dmi.PropertyData) dmi.Section {
+		return dmi.Section{
+			HandleLine:  fmt.Sprintf("Device tree, DMI type %d", typ),
+			TypeStr:     name,
+			Type:        typ,
+			SubSections: []dmi.SubSection{{Title: title, Properties: properties}},
+		}
+	}
+
+	return &dmi.DMI{
+		Tooling: dmi.Tooling{
+			Aggregator: "0-OS device tree",
+			Decoder:    DecoderVersion,
+		},
+		Sections: []dmi.Section{
+			section(dmi.TypeSystem, "System", "System Information", map[string]dmi.PropertyData{
+				"Manufacturer":  property(t.Vendor()),
+				"Product Name":  property(t.Model),
+				"Version":       property(strings.Join(t.Compatible, " ")),
+				"Serial Number": property(t.Serial),
+			}),
+			section(dmi.TypeProcessor, "Processor", "Processor Information", map[string]dmi.PropertyData{
+				"Version": property(t.SoC()),
+			}),
+		},
+	}
+}
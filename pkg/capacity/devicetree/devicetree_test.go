@@ -0,0 +1,61 @@
+package devicetree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+)
+
+func TestDecode(t *testing.T) {
+	root, err := ioutil.TempDir("", "devicetree")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	_, err = decode(root)
+	assert.Error(t, err)
+
+	write := func(name, value string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, name), []byte(value), 0644))
+	}
+
+	write("compatible", "raspberrypi,4-model-b\x00brcm,bcm2711\x00")
+	tree, err := decode(root)
+	require.NoError(t, err)
+	assert.Equal(t, &DeviceTree{Compatible: []string{"raspberrypi,4-model-b", "brcm,bcm2711"}}, tree)
+
+	write("model", "Raspberry Pi 4 Model B Rev 1.4\x00")
+	write("serial-number", "10000000a1b2c3d4\x00")
+	tree, err = decode(root)
+	require.NoError(t, err)
+	assert.Equal(t, "Raspberry Pi 4 Model B Rev 1.4", tree.Model)
+	assert.Equal(t, "10000000a1b2c3d4", tree.Serial)
+	assert.Equal(t, "raspberrypi", tree.Vendor())
+	assert.Equal(t, "brcm,bcm2711", tree.SoC())
+}
+
+func TestDMI(t *testing.T) {
+	tree := DeviceTree{
+		Model:      "Pine64 RockPro64 v2.1",
+		Compatible: []string{"pine64,rockpro64-v2.1", "pine64,rockpro64", "rockchip,rk3399"},
+		Serial:     "c3d9b8674f4b94f6",
+	}
+
+	d := tree.DMI()
+	require.Len(t, d.Sections, 2)
+
+	system := d.Sections[0]
+	assert.Equal(t, dmi.TypeSystem, system.Type)
+	properties := system.SubSections[0].Properties
+	assert.Equal(t, "pine64", properties["Manufacturer"].Val)
+	assert.Equal(t, "Pine64 RockPro64 v2.1", properties["Product Name"].Val)
+	assert.Equal(t, "c3d9b8674f4b94f6", properties["Serial Number"].Val)
+
+	processor := d.Sections[1]
+	assert.Equal(t, dmi.TypeProcessor, processor.Type)
+	assert.Equal(t, "rockchip,rk3399", processor.SubSections[0].Properties["Version"].Val)
+}
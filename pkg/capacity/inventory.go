@@ -0,0 +1,382 @@
+package capacity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+	"github.com/threefoldtech/zos/pkg/startup"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+const (
+	cpuInfoPath = "/proc/cpuinfo"
+	sysNetPath  = "/sys/class/net"
+	binPath     = "/bin"
+)
+
+// firmwareSections are the DMI sections reported in the inventory firmware
+// with the properties identifying them
+var firmwareSections = map[string]struct {
+	Type       dmi.Type
+	Properties []string
+}{
+	"bios":      {dmi.TypeBIOS, []string{"Vendor", "Version", "Release Date"}},
+	"system":    {dmi.TypeSystem, []string{"Manufacturer", "Product Name", "Version", "Serial Number"}},
+	"baseboard": {dmi.TypeBaseboard, []string{"Manufacturer", "Product Name", "Version", "Serial Number"}},
+}
+
+// HardwareInventory builds the hardware part of the node inventory from the
+// DMI and the disks information already read by the oracle
+func HardwareInventory(d *dmi.DMI, disks Disks) (inv pkg.Inventory, err error) {
+	f, err := os.Open(cpuInfoPath)
+	if err != nil {
+		return inv, errors.Wrap(err, "failed to read cpu info")
+	}
+	defer f.Close()
+
+	inv.CPUs, err = parseCPUInfo(f)
+	if err != nil {
+		return inv, errors.Wrap(err, "failed to parse cpu info")
+	}
+
+	inv.NICs, err = physicalNICs(sysNetPath)
+	if err != nil {
+		return inv, errors.Wrap(err, "failed to list network interfaces")
+	}
+
+	inv.Disks = diskInventory(disks)
+	inv.Firmware = firmware(d)
+
+	return inv, nil
+}
+
+// parseCPUInfo counts the logical CPUs of every processor model, the CPUs
+// are separated by an empty line
+func parseCPUInfo(r io.Reader) ([]pkg.CPUInventory, error) {
+	threads := make(map[string]int)
+	fields := make(map[string]string)
+	count := func() {
+		if model := cpuModel(fields); len(model) != 0 {
+			threads[model]++
+		}
+		fields = make(map[string]string)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			count()
+			continue
+		}
+		fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	count()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cpus := make([]pkg.CPUInventory, 0, len(threads))
+	for model, n := range threads {
+		cpus = append(cpus, pkg.CPUInventory{Model: model, Threads: n})
+	}
+
+	sort.Slice(cpus, func(i, j int) bool { return cpus[i].Model < cpus[j].Model })
+	return cpus, nil
+}
+
+// physicalNICs returns the MAC addresses of the interfaces backed by a
+// device, the virtual interfaces created by the node are ignored
+func physicalNICs(root string) ([]string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	nics := []string{}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(root, entry.Name(), "device")); err != nil {
+			continue
+		}
+
+		mac, err := ioutil.ReadFile(filepath.Join(root, entry.Name(), "address"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read address of %s", entry.Name())
+		}
+		nics = append(nics, strings.ToLower(strings.TrimSpace(string(mac))))
+	}
+
+	sort.Strings(nics)
+	return nics, nil
+}
+
+func diskInventory(disks Disks) []pkg.DiskInventory {
+	inv := make([]pkg.DiskInventory, 0, len(disks.Devices))
+	for _, info := range disks.Devices {
+		model := info.Information["Device Model"]
+		if len(model) == 0 {
+			// nvme devices
+			model = info.Information["Model Number"]
+		}
+
+		inv = append(inv, pkg.DiskInventory{
+			Serial:   info.Information["Serial Number"],
+			Model:    model,
+			Firmware: info.Information["Firmware Version"],
+		})
+	}
+
+	sort.Slice(inv, func(i, j int) bool {
+		if inv[i].Serial != inv[j].Serial {
+			return inv[i].Serial < inv[j].Serial
+		}
+		return inv[i].Model < inv[j].Model
+	})
+
+	return inv
+}
+
+func firmware(d *dmi.DMI) map[string]string {
+	result := make(map[string]string)
+	if d == nil {
+		return result
+	}
+
+	for name, section := range firmwareSections {
+		for _, s := range d.Sections {
+			if s.Type != section.Type || len(s.SubSections) == 0 {
+				continue
+			}
+
+			properties := s.SubSections[0].Properties
+			values := make([]string, 0, len(section.Properties))
+			for _, property := range section.Properties {
+				values = append(values, strings.TrimSpace(properties[property].Val))
+			}
+			result[name] = strings.Join(values, " | ")
+			break
+		}
+	}
+
+	return result
+}
+
+// moduleVersions returns the versions of the binaries of the 0-OS modules
+func moduleVersions(bin string) map[string]string {
+	versions := make(map[string]string)
+	for name, module := range startup.Modules {
+		v, err := versionOf(filepath.Join(bin, module.Service))
+		if err != nil {
+			log.Error().Err(err).Str("module", name).Msg("failed to get module version")
+			continue
+		}
+		versions[name] = v
+	}
+
+	return versions
+}
+
+func versionOf(bin string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, bin, "-v").CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get '%s' version string", bin)
+	}
+
+	ver, revision, err := version.Parse(strings.TrimSpace(string(output)))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s@%s", ver, revision), nil
+}
+
+// Fingerprint returns the hex encoded sha256 of the canonical json encoding
+// of the inventory. The lists of the inventory are sorted and the json
+// encoding sorts the maps keys, so the fingerprint only depends on the content
+func Fingerprint(inv pkg.Inventory) string {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		// the inventory only holds strings and numbers
+		panic(err)
+	}
+
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// HardwareFingerprint returns the fingerprint of the inventory without
+// the versions of the modules and the uplinks, recabling the node doesn't
+// change its hardware
+func HardwareFingerprint(inv pkg.Inventory) string {
+	inv.Modules = nil
+	inv.Uplinks = nil
+	return Fingerprint(inv)
+}
+
+// AttestationBytes returns the bytes of the attestation covered by the signature
+func AttestationBytes(a *pkg.Attestation) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(a.Time.UTC().Format(time.RFC3339Nano))
+	for _, s := range []string{a.NodeID, a.Nonce, a.Fingerprint, a.HardwareFingerprint} {
+		buf.WriteByte('|')
+		buf.WriteString(s)
+	}
+
+	return buf.Bytes()
+}
+
+// uplinks returns the switch ports the physical interfaces are cabled to
+func uplinks(neighbors []pkg.LLDPNeighbor) []pkg.UplinkInventory {
+	inv := make([]pkg.UplinkInventory, 0, len(neighbors))
+	for _, neighbor := range neighbors {
+		name := neighbor.SystemName
+		if len(name) == 0 {
+			name = neighbor.ChassisID
+		}
+
+		inv = append(inv, pkg.UplinkInventory{
+			Interface: neighbor.Interface,
+			Switch:    name,
+			Port:      neighbor.PortID,
+		})
+	}
+
+	sort.Slice(inv, func(i, j int) bool { return inv[i].Interface < inv[j].Interface })
+	return inv
+}
+
+// NeighborsSource gives the LLDP neighbors of the node, the stub of the
+// network module implements it
+type NeighborsSource interface {
+	LLDPNeighbors() ([]pkg.LLDPNeighbor, error)
+}
+
+// InventoryManager implements pkg.InventoryManager. The hardware is only
+// read once, when the module starts, while the modules versions and the
+// uplinks are read on every request since they change with the upgrades
+// and the cabling
+type InventoryManager struct {
+	hardware pkg.Inventory
+	identity pkg.IdentityManager
+	network  NeighborsSource
+	bin      string
+}
+
+var _ pkg.InventoryManager = (*InventoryManager)(nil)
+
+// NewInventoryManager creates a new inventory manager of the node, the
+// uplinks are read from network
+func NewInventoryManager(hardware pkg.Inventory, identity pkg.IdentityManager, network NeighborsSource) *InventoryManager {
+	return &InventoryManager{
+		hardware: hardware,
+		identity: identity,
+		network:  network,
+		bin:      binPath,
+	}
+}
+
+// Inventory implements pkg.InventoryManager
+func (m *InventoryManager) Inventory() (pkg.Inventory, error) {
+	inv := m.hardware
+	inv.Modules = moduleVersions(m.bin)
+
+	neighbors, err := m.network.LLDPNeighbors()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get lldp neighbors, uplinks are not reported")
+	} else if len(neighbors) != 0 {
+		inv.Uplinks = uplinks(neighbors)
+	}
+
+	return inv, nil
+}
+
+// Fingerprint implements pkg.InventoryManager
+func (m *InventoryManager) Fingerprint() (string, error) {
+	inv, err := m.Inventory()
+	if err != nil {
+		return "", err
+	}
+
+	return Fingerprint(inv), nil
+}
+
+// Attest implements pkg.InventoryManager
+func (m *InventoryManager) Attest(nonce []byte) (pkg.Attestation, error) {
+	if len(nonce) == 0 {
+		return pkg.Attestation{}, fmt.Errorf("nonce is required")
+	}
+
+	inv, err := m.Inventory()
+	if err != nil {
+		return pkg.Attestation{}, err
+	}
+
+	nodeID, err := m.identity.NodeID()
+	if err != nil {
+		return pkg.Attestation{}, errors.Wrap(err, "failed to get node identity")
+	}
+
+	a := pkg.Attestation{
+		NodeID:              nodeID.Identity(),
+		Nonce:               hex.EncodeToString(nonce),
+		Time:                time.Now(),
+		Fingerprint:         Fingerprint(inv),
+		HardwareFingerprint: HardwareFingerprint(inv),
+	}
+
+	sig, err := m.identity.Sign(AttestationBytes(&a))
+	if err != nil {
+		return pkg.Attestation{}, errors.Wrap(err, "failed to sign attestation")
+	}
+	a.Signature = hex.EncodeToString(sig)
+
+	return a, nil
+}
+
+// CheckHardware compares the hardware fingerprint with the one recorded at
+// path during the previous boots, and records the new one. It returns the
+// previous fingerprint if the hardware changed, or an empty string
+func CheckHardware(path string, inv pkg.Inventory) (previous string, err error) {
+	current := HardwareFingerprint(inv)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "failed to read previous fingerprint")
+	}
+
+	previous = strings.TrimSpace(string(data))
+	if previous == current {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(current), 0644); err != nil {
+		return "", errors.Wrap(err, "failed to record fingerprint")
+	}
+
+	return previous, nil
+}
@@ -0,0 +1,153 @@
+package capacity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+	"github.com/threefoldtech/zos/pkg/capacity/smartctl"
+)
+
+func TestParseCPUInfo(t *testing.T) {
+	const cpuinfo = `processor	: 0
+model name	: Intel(R) Xeon(R) CPU E5-2620 v4 @ 2.10GHz
+
+processor	: 1
+model name	: Intel(R) Xeon(R) CPU E5-2620 v4 @ 2.10GHz
+
+processor	: 2
+model name	: AMD EPYC 7302P 16-Core Processor
+`
+	cpus, err := parseCPUInfo(strings.NewReader(cpuinfo))
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.CPUInventory{
+		{Model: "AMD EPYC 7302P 16-Core Processor", Threads: 1},
+		{Model: "Intel(R) Xeon(R) CPU E5-2620 v4 @ 2.10GHz", Threads: 2},
+	}, cpus)
+}
+
+func TestPhysicalNICs(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysnet")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	nic := func(name, mac string, physical bool) {
+		dir := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "address"), []byte(mac+"\n"), 0644))
+		if physical {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "device"), 0755))
+		}
+	}
+
+	nic("eth1", "AA:BB:CC:00:00:02", true)
+	nic("eth0", "aa:bb:cc:00:00:01", true)
+	nic("zos", "aa:bb:cc:00:00:03", false)
+
+	nics, err := physicalNICs(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aa:bb:cc:00:00:01", "aa:bb:cc:00:00:02"}, nics)
+}
+
+func TestDiskInventory(t *testing.T) {
+	disks := Disks{Devices: []smartctl.Info{
+		{Information: map[string]string{"Serial Number": "S2", "Model Number": "Samsung SSD 970", "Firmware Version": "2B2QEXE7"}},
+		{Information: map[string]string{"Serial Number": "S1", "Device Model": "ST4000NM0035", "Firmware Version": "TN04"}},
+	}}
+
+	assert.Equal(t, []pkg.DiskInventory{
+		{Serial: "S1", Model: "ST4000NM0035", Firmware: "TN04"},
+		{Serial: "S2", Model: "Samsung SSD 970", Firmware: "2B2QEXE7"},
+	}, diskInventory(disks))
+}
+
+func TestFirmware(t *testing.T) {
+	d := &dmi.DMI{Sections: []dmi.Section{
+		{
+			Type: dmi.TypeBIOS,
+			SubSections: []dmi.SubSection{{
+				Title: "BIOS Information",
+				Properties: map[string]dmi.PropertyData{
+					"Vendor":       {Val: "American Megatrends Inc."},
+					"Version":      {Val: "3.1"},
+					"Release Date": {Val: "06/19/2018"},
+				},
+			}},
+		},
+	}}
+
+	assert.Equal(t, map[string]string{
+		"bios": "American Megatrends Inc. | 3.1 | 06/19/2018",
+	}, firmware(d))
+}
+
+func TestFingerprint(t *testing.T) {
+	inv := pkg.Inventory{
+		CPUs:     []pkg.CPUInventory{{Model: "AMD EPYC", Threads: 32}},
+		NICs:     []string{"aa:bb:cc:00:00:01"},
+		Firmware: map[string]string{"bios": "a", "system": "b"},
+		Modules:  map[string]string{"storage": "v0.4.0@abc", "network": "v0.4.0@abc"},
+	}
+
+	other := inv
+	other.Firmware = map[string]string{"system": "b", "bios": "a"}
+	assert.Equal(t, Fingerprint(inv), Fingerprint(other))
+	assert.Len(t, Fingerprint(inv), 64)
+
+	// upgrades don't change the hardware fingerprint
+	other.Modules = map[string]string{"storage": "v0.4.1@def"}
+	assert.NotEqual(t, Fingerprint(inv), Fingerprint(other))
+	assert.Equal(t, HardwareFingerprint(inv), HardwareFingerprint(other))
+
+	// neither does recabling
+	other.Uplinks = []pkg.UplinkInventory{{Interface: "eth0", Switch: "tor1", Port: "Eth1/1"}}
+	assert.Equal(t, HardwareFingerprint(inv), HardwareFingerprint(other))
+
+	other.NICs = []string{"aa:bb:cc:00:00:02"}
+	assert.NotEqual(t, HardwareFingerprint(inv), HardwareFingerprint(other))
+}
+
+func TestUplinks(t *testing.T) {
+	inv := uplinks([]pkg.LLDPNeighbor{
+		{Interface: "eth1", ChassisID: "00:1b:21:aa:bb:cc", PortID: "Eth1/2"},
+		{Interface: "eth0", ChassisID: "00:1b:21:aa:bb:cd", PortID: "Eth1/1", SystemName: "tor1"},
+	})
+
+	assert.Equal(t, []pkg.UplinkInventory{
+		{Interface: "eth0", Switch: "tor1", Port: "Eth1/1"},
+		{Interface: "eth1", Switch: "00:1b:21:aa:bb:cc", Port: "Eth1/2"},
+	}, inv)
+}
+
+func TestCheckHardware(t *testing.T) {
+	root, err := ioutil.TempDir("", "fingerprint")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "capacityd", "fingerprint")
+	inv := pkg.Inventory{NICs: []string{"aa:bb:cc:00:00:01"}}
+
+	// first boot
+	previous, err := CheckHardware(path, inv)
+	require.NoError(t, err)
+	assert.Equal(t, "", previous)
+
+	previous, err = CheckHardware(path, inv)
+	require.NoError(t, err)
+	assert.Equal(t, "", previous)
+
+	swapped := pkg.Inventory{NICs: []string{"aa:bb:cc:00:00:02"}}
+	previous, err = CheckHardware(path, swapped)
+	require.NoError(t, err)
+	assert.Equal(t, HardwareFingerprint(inv), previous)
+
+	previous, err = CheckHardware(path, swapped)
+	require.NoError(t, err)
+	assert.Equal(t, "", previous)
+}
@@ -0,0 +1,126 @@
+package capacity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/threefoldtech/zos/pkg/capacity/smartctl"
+)
+
+// the hardware of the arm64 boards is not described like the one of the
+// x86 servers: the single board computers have no SMBIOS tables but a
+// device tree, their cpus have no model name and their eMMC and SD cards
+// have no SMART support
+
+const (
+	smbiosPath   = "/sys/firmware/dmi/tables/DMI"
+	sysBlockPath = "/sys/block"
+)
+
+// hasSMBIOS returns true if the firmware of the node provides the SMBIOS
+// tables read by dmidecode, the arm64 servers do, unlike the single board
+// computers
+func hasSMBIOS() bool {
+	_, err := os.Stat(smbiosPath)
+	return err == nil
+}
+
+// armImplementers are the names of the arm cpus implementers, by their
+// "CPU implementer" in /proc/cpuinfo
+var armImplementers = map[string]string{
+	"0x41": "ARM",
+	"0x42": "Broadcom",
+	"0x43": "Cavium",
+	"0x48": "HiSilicon",
+	"0x4e": "NVIDIA",
+	"0x50": "APM",
+	"0x51": "Qualcomm",
+	"0x61": "Apple",
+	"0xc0": "Ampere",
+}
+
+// armParts are the names of the cores designed by ARM, by their "CPU part"
+var armParts = map[string]string{
+	"0xd03": "Cortex-A53",
+	"0xd04": "Cortex-A35",
+	"0xd05": "Cortex-A55",
+	"0xd07": "Cortex-A57",
+	"0xd08": "Cortex-A72",
+	"0xd09": "Cortex-A73",
+	"0xd0a": "Cortex-A75",
+	"0xd0b": "Cortex-A76",
+	"0xd0c": "Neoverse-N1",
+	"0xd0d": "Cortex-A77",
+	"0xd40": "Neoverse-V1",
+	"0xd41": "Cortex-A78",
+	"0xd49": "Neoverse-N2",
+}
+
+// cpuModel returns the model of a cpu of /proc/cpuinfo from its fields. The
+// arm64 kernels don't report a model name, the model is named after the
+// implementer and the part of the cpu
+func cpuModel(fields map[string]string) string {
+	if model, ok := fields["model name"]; ok {
+		return model
+	}
+
+	implementer, part := fields["CPU implementer"], fields["CPU part"]
+	if len(implementer) == 0 || len(part) == 0 {
+		return ""
+	}
+
+	name, ok := armImplementers[implementer]
+	if !ok {
+		name = implementer
+	}
+
+	if core, ok := armParts[part]; ok && implementer == "0x41" {
+		return fmt.Sprintf("%s %s", name, core)
+	}
+
+	return fmt.Sprintf("%s %s", name, part)
+}
+
+// mmcDisks returns the eMMC and SD cards found under root, as smartctl
+// would report them. The boot and rpmb partitions of the eMMC are not
+// disks
+func mmcDisks(root string) ([]smartctl.Info, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(name, attribute string) string {
+		data, err := ioutil.ReadFile(filepath.Join(root, name, "device", attribute))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	var disks []smartctl.Info
+	for _, entry := range entries {
+		name := entry.Name()
+		if smartctl.Supported(name) || strings.Contains(name, "boot") || strings.Contains(name, "rpmb") {
+			continue
+		}
+
+		disks = append(disks, smartctl.Info{
+			Tool:        "0-OS mmc reader",
+			Environment: "/dev/" + name,
+			Information: map[string]string{
+				"Device Model":     read(name, "name"),
+				"Device type":      read(name, "type"),
+				"Serial Number":    read(name, "serial"),
+				"Firmware Version": read(name, "fwrev"),
+			},
+		})
+	}
+
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Environment < disks[j].Environment })
+	return disks, nil
+}
@@ -0,0 +1,81 @@
+package capacity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestParseCPUInfoARM(t *testing.T) {
+	// big.LITTLE rk3399, 4 Cortex-A53 and 2 Cortex-A72
+	var cpuinfo strings.Builder
+	for i, part := range []string{"0xd03", "0xd03", "0xd03", "0xd03", "0xd08", "0xd08"} {
+		fmt.Fprintf(&cpuinfo, `processor	: %d
+BogoMIPS	: 48.00
+Features	: fp asimd evtstrm aes pmull sha1 sha2 crc32 cpuid
+CPU implementer	: 0x41
+CPU architecture: 8
+CPU variant	: 0x0
+CPU part	: %s
+CPU revision	: 4
+
+`, i, part)
+	}
+
+	cpus, err := parseCPUInfo(strings.NewReader(cpuinfo.String()))
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.CPUInventory{
+		{Model: "ARM Cortex-A53", Threads: 4},
+		{Model: "ARM Cortex-A72", Threads: 2},
+	}, cpus)
+}
+
+func TestCPUModel(t *testing.T) {
+	assert.Equal(t, "AMD EPYC", cpuModel(map[string]string{"model name": "AMD EPYC"}))
+	assert.Equal(t, "ARM Neoverse-N1", cpuModel(map[string]string{"CPU implementer": "0x41", "CPU part": "0xd0c"}))
+	assert.Equal(t, "Cavium 0x0af", cpuModel(map[string]string{"CPU implementer": "0x43", "CPU part": "0x0af"}))
+	assert.Equal(t, "0x99 0x001", cpuModel(map[string]string{"CPU implementer": "0x99", "CPU part": "0x001"}))
+	assert.Equal(t, "", cpuModel(map[string]string{"Hardware": "BCM2835"}))
+}
+
+func TestMMCDisks(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysblock")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	device := func(name string, attributes map[string]string) {
+		dir := filepath.Join(root, name, "device")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for k, v := range attributes {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, k), []byte(v+"\n"), 0644))
+		}
+	}
+
+	device("sda", map[string]string{"model": "ST4000NM0035"})
+	device("mmcblk1", map[string]string{"name": "SC16G", "type": "SD", "serial": "0x1d2e3f4a", "fwrev": "0x0"})
+	device("mmcblk0", map[string]string{"name": "8GTF4R", "type": "MMC", "serial": "0x9a8b7c6d", "fwrev": "0x7"})
+	device("mmcblk0boot0", nil)
+	device("mmcblk0rpmb", nil)
+
+	disks, err := mmcDisks(root)
+	require.NoError(t, err)
+	require.Len(t, disks, 2)
+
+	assert.Equal(t, "/dev/mmcblk0", disks[0].Environment)
+	assert.Equal(t, "8GTF4R", disks[0].Information["Device Model"])
+	assert.Equal(t, "0x9a8b7c6d", disks[0].Information["Serial Number"])
+	assert.Equal(t, "MMC", disks[0].Information["Device type"])
+	assert.Equal(t, "/dev/mmcblk1", disks[1].Environment)
+
+	assert.Equal(t, []pkg.DiskInventory{
+		{Serial: "0x1d2e3f4a", Model: "SC16G", Firmware: "0x0"},
+		{Serial: "0x9a8b7c6d", Model: "8GTF4R", Firmware: "0x7"},
+	}, diskInventory(Disks{Devices: disks}))
+}
@@ -4,13 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 var reScan = regexp.MustCompile(`(?m)^([^\s]+)\s+-d\s+([^\s]+)\s+#`)
 var reHeader = regexp.MustCompile(`(?m)([^\[]+)\[([^\[]+)\]`)
 var reInfo = regexp.MustCompile(`(?m)([^:]+):\s+(.+)`)
+var reAttribute = regexp.MustCompile(`^\s*(\d+)\s+(\S+)\s+0x[0-9a-fA-F]+\s+(\d+)\s+(\d+)\s+(\d+)\s+\S+\s+\S+\s+(\S+)\s+(\d+)`)
 
 // ErrEmpty is return when smatctl doesn't find any device
 var ErrEmpty = errors.New("smartctl returned an empty response")
@@ -36,6 +39,12 @@ func ListDevices() ([]Device, error) {
 	return parseScan(output)
 }
 
+// Supported returns false for the devices that have no SMART support at all,
+// the eMMC and the SD cards of the single board computers
+func Supported(path string) bool {
+	return !strings.HasPrefix(filepath.Base(path), "mmcblk")
+}
+
 // Info contains information about a device as returned by "smartctl -i {path} -d {type}"
 type Info struct {
 	Tool        string
@@ -54,6 +63,36 @@ func DeviceInfo(d Device) (Info, error) {
 	return parseInfo(output)
 }
 
+// Attribute is a SMART attribute as returned by "smartctl -A {path} -d {type}"
+type Attribute struct {
+	ID        int
+	Name      string
+	Value     int
+	Worst     int
+	Threshold int
+	// Failed is true when the normalized value
+	// is or was below the threshold
+	Failed bool
+	Raw    uint64
+}
+
+// DeviceAttributes returns the SMART attributes of a specific device. Devices
+// without attributes table (NVMe, SCSI) return an empty list
+func DeviceAttributes(d Device) ([]Attribute, error) {
+	cmd := exec.Command("smartctl", "-A", d.Path, "-d", d.Type)
+	output, err := cmd.Output()
+	// smartctl exit code is a bit mask, the higher bits report the
+	// health of the disk and don't prevent reading the attributes
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode()&0x07 == 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAttributes(output), nil
+}
+
 func parseScan(b []byte) ([]Device, error) {
 	trimed := strings.TrimSpace(string(b))
 	lines := strings.Split(trimed, "\n")
@@ -104,3 +143,32 @@ func parseInfo(b []byte) (Info, error) {
 
 	return info, nil
 }
+
+func parseAttributes(b []byte) []Attribute {
+	var attributes []Attribute
+	for _, line := range strings.Split(string(b), "\n") {
+		match := reAttribute.FindStringSubmatch(line)
+		if len(match) != 8 {
+			continue
+		}
+
+		// the regexp makes sure all the numbers are valid
+		id, _ := strconv.Atoi(match[1])
+		value, _ := strconv.Atoi(match[3])
+		worst, _ := strconv.Atoi(match[4])
+		threshold, _ := strconv.Atoi(match[5])
+		raw, _ := strconv.ParseUint(match[7], 10, 64)
+
+		attributes = append(attributes, Attribute{
+			ID:        id,
+			Name:      match[2],
+			Value:     value,
+			Worst:     worst,
+			Threshold: threshold,
+			Failed:    match[6] != "-",
+			Raw:       raw,
+		})
+	}
+
+	return attributes
+}
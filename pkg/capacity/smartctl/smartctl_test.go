@@ -49,3 +49,25 @@ SMART support is:     Unavailable - device lacks SMART capability.`)
 	_, exists := info.Information["local Time is"]
 	assert.False(t, exists, "Local time should not be included in information")
 }
+
+func TestParseAttributes(t *testing.T) {
+	b := []byte(`smartctl 7.0 2018-12-30 r4883 [x86_64-linux-4.14.82-Zero-OS] (local build)
+Copyright (C) 2002-18, Bruce Allen, Christian Franke, www.smartmontools.org
+
+=== START OF READ SMART DATA SECTION ===
+SMART Attributes Data Structure revision number: 16
+Vendor Specific SMART Attributes with Thresholds:
+ID# ATTRIBUTE_NAME          FLAG     VALUE WORST THRESH TYPE      UPDATED  WHEN_FAILED RAW_VALUE
+  1 Raw_Read_Error_Rate     0x000f   117   099   006    Pre-fail  Always       -       148139872
+  5 Reallocated_Sector_Ct   0x0033   098   098   036    Pre-fail  Always       -       264
+  9 Power_On_Hours          0x0032   071   071   000    Old_age   Always       -       25889 (149 125 0)
+197 Current_Pending_Sector  0x0012   001   001   000    Old_age   Always   FAILING_NOW 8`)
+
+	attributes := parseAttributes(b)
+	require.Len(t, attributes, 4)
+
+	assert.Equal(t, Attribute{ID: 5, Name: "Reallocated_Sector_Ct", Value: 98, Worst: 98, Threshold: 36, Raw: 264}, attributes[1])
+	assert.Equal(t, uint64(25889), attributes[2].Raw)
+	assert.True(t, attributes[3].Failed)
+	assert.False(t, attributes[0].Failed)
+}
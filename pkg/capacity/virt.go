@@ -0,0 +1,88 @@
+package capacity
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Virtualization is the hardware virtualization support of the node
+type Virtualization struct {
+	// Extension is the virtualization extension of the CPU, vmx (intel)
+	// or svm (amd). Empty if the CPU has none
+	Extension string `json:"extension"`
+	// KVM is set if /dev/kvm is available, workloads can only use
+	// hardware virtualization if it is
+	KVM bool `json:"kvm"`
+	// Nested is set if the kvm module allows to run virtual
+	// machines inside virtual machines
+	Nested bool `json:"nested"`
+}
+
+// Virtualization detects the hardware virtualization support of the node
+func (r *ResourceOracle) Virtualization() (v Virtualization, err error) {
+	cpuinfo, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return v, err
+	}
+	defer cpuinfo.Close()
+
+	v.Extension, err = virtExtension(cpuinfo)
+	if err != nil {
+		return v, err
+	}
+
+	if _, err := os.Stat("/dev/kvm"); err == nil {
+		v.KVM = true
+	}
+
+	module := map[string]string{
+		"vmx": "kvm_intel",
+		"svm": "kvm_amd",
+	}[v.Extension]
+
+	if module != "" && v.KVM {
+		v.Nested = nestedEnabled("/sys/module/" + module + "/parameters/nested")
+	}
+
+	return v, nil
+}
+
+// virtExtension finds the virtualization extension in the cpu flags
+func virtExtension(cpuinfo io.Reader) (string, error) {
+	scanner := bufio.NewScanner(cpuinfo)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "flags" {
+			continue
+		}
+
+		for _, flag := range strings.Fields(parts[1]) {
+			if flag == "vmx" || flag == "svm" {
+				return flag, nil
+			}
+		}
+		// all the cpus have the same flags
+		return "", nil
+	}
+
+	return "", scanner.Err()
+}
+
+// nestedEnabled reads the nested parameter of a kvm module, it's
+// Y or N on intel and 1 or 0 on amd
+func nestedEnabled(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	switch strings.TrimSpace(string(data)) {
+	case "Y", "y", "1":
+		return true
+	}
+
+	return false
+}
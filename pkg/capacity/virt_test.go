@@ -0,0 +1,44 @@
+package capacity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtExtension(t *testing.T) {
+	const cpuinfo = `processor	: 0
+vendor_id	: GenuineIntel
+flags		: fpu vme de pse tsc msr vmx smx est
+`
+	ext, err := virtExtension(strings.NewReader(cpuinfo))
+	require.NoError(t, err)
+	assert.Equal(t, "vmx", ext)
+
+	ext, err = virtExtension(strings.NewReader("flags : fpu svm lm\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "svm", ext)
+
+	ext, err = virtExtension(strings.NewReader("flags : fpu vme de\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", ext)
+}
+
+func TestNestedEnabled(t *testing.T) {
+	root, err := ioutil.TempDir("", "nested")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "nested")
+	assert.False(t, nestedEnabled(path))
+
+	for value, expected := range map[string]bool{"Y\n": true, "1\n": true, "N\n": false, "0\n": false} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(value), 0644))
+		assert.Equal(t, expected, nestedEnabled(path), value)
+	}
+}
@@ -4,6 +4,12 @@ package pkg
 //go:generate zbusc -module container -version 0.0.1 -name container -package stubs github.com/threefoldtech/zos/pkg+ContainerModule stubs/container_stub.go
 
 import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/threefoldtech/zos/pkg/container/logger"
 	"github.com/threefoldtech/zos/pkg/container/stats"
 )
@@ -39,24 +45,303 @@ type Container struct {
 	Env []string
 	// WorkingDir of the entrypoint command
 	WorkingDir string
+	// UID and GID are the user and group the entrypoint and the init
+	// steps run as, root if not set
+	UID uint32
+	GID uint32
 	// Network network info for container
 	Network NetworkInfo
 	// Mounts extra mounts for container
 	Mounts []MountInfo
 	// Entrypoint the process to start inside the container
 	Entrypoint string
+	// Init are the steps run in order in the container environment
+	// before the entrypoint starts
+	Init []InitStep
 	// Interactivity enable Core X as PID 1 on the container
 	Interactive bool
 	// CPU count limit
 	CPU uint
 	// Memory limit in bytes
 	Memory uint64
+	// IO limit of the container on the IODevices
+	IO IOLimit
+	// IODevices are the disks backing the root filesystem
+	// and the mounts of the container
+	IODevices []string
+	// Devices are the names of the host devices the container needs
+	// access to, like tun, fuse or kvm. Each device is subject to the
+	// device policy of the node
+	Devices []string
+	// Liveness are the checks run on the container once started, when one of
+	// them keeps failing the container is restarted following the Restart policy
+	Liveness []LivenessCheck
+	// Restart is the restart policy applied when the container is not alive
+	Restart RestartPolicy
+	// RunOnce containers are not restarted once their entrypoint exits
+	RunOnce bool
+	// Checkpoints is the directory, on a storage volume, where the checkpoints
+	// of the container are kept. When it holds a checkpoint the container is
+	// restored from it instead of starting from scratch
+	Checkpoints string
+	// Security is the seccomp profile and the LSM labels of the container
+	Security SecurityProfile
 	// Logs backends
 	Logs []logger.Logs
 	// StatsAggregator container metrics backend
 	StatsAggregator []stats.Aggregator
 }
 
+// InitFailurePolicy is what to do when an init step fails
+type InitFailurePolicy string
+
+const (
+	// InitFailureAbort fails the start of the container, this is the default
+	InitFailureAbort InitFailurePolicy = "abort"
+	// InitFailureIgnore logs the failure and goes on with the next step
+	InitFailureIgnore InitFailurePolicy = "ignore"
+)
+
+// InitStep is a command run to completion before the entrypoint of a container
+// starts. It sees the same root filesystem, mounts, network and environment
+// as the entrypoint
+type InitStep struct {
+	// Command to run
+	Command string `json:"command"`
+	// Timeout in seconds of the step, defaults to 5 minutes
+	Timeout uint `json:"timeout,omitempty"`
+	// OnFailure is the failure policy of the step
+	OnFailure InitFailurePolicy `json:"on_failure,omitempty"`
+}
+
+// Valid checks that the init step is valid
+func (s InitStep) Valid() error {
+	if strings.TrimSpace(s.Command) == "" {
+		return fmt.Errorf("init step command is required")
+	}
+
+	switch s.OnFailure {
+	case "", InitFailureAbort, InitFailureIgnore:
+	default:
+		return fmt.Errorf("unknown init step failure policy '%s'", s.OnFailure)
+	}
+
+	return nil
+}
+
+// LivenessType is the type of a liveness check
+type LivenessType string
+
+const (
+	// LivenessExec runs a command in the container, it passes if it exits with 0
+	LivenessExec LivenessType = "exec"
+	// LivenessTCP passes if a tcp connection can be established with the port
+	LivenessTCP LivenessType = "tcp"
+	// LivenessHTTP passes if a GET request on the port returns a 2xx or 3xx status
+	LivenessHTTP LivenessType = "http"
+)
+
+// LivenessCheck is a check run periodically from inside the container
+// network namespace (or in the container itself for exec checks)
+type LivenessCheck struct {
+	Type LivenessType `json:"type"`
+	// Command to run, only used by exec checks
+	Command string `json:"command,omitempty"`
+	// Port to connect to on localhost, used by tcp and http checks
+	Port uint16 `json:"port,omitempty"`
+	// Path of the request, only used by http checks
+	Path string `json:"path,omitempty"`
+	// Interval in seconds between 2 checks, defaults to 10
+	Interval uint `json:"interval,omitempty"`
+	// Timeout in seconds of a check, defaults to 2
+	Timeout uint `json:"timeout,omitempty"`
+	// InitialDelay in seconds before the first check, to give the
+	// container time to start
+	InitialDelay uint `json:"initial_delay,omitempty"`
+	// FailureThreshold is the number of consecutive failures after
+	// which the container is restarted, defaults to 3
+	FailureThreshold uint `json:"failure_threshold,omitempty"`
+}
+
+// Valid checks that the liveness check is valid
+func (c LivenessCheck) Valid() error {
+	switch c.Type {
+	case LivenessExec:
+		if strings.TrimSpace(c.Command) == "" {
+			return fmt.Errorf("exec liveness check needs a command")
+		}
+	case LivenessTCP, LivenessHTTP:
+		if c.Port == 0 {
+			return fmt.Errorf("%s liveness check needs a port", c.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported liveness check type '%s'", c.Type)
+	}
+
+	return nil
+}
+
+// RestartPolicy defines how a container that is not alive is restarted
+type RestartPolicy struct {
+	// MaxRestarts is the number of restarts after which the container
+	// is left alone, 0 means no limit
+	MaxRestarts uint `json:"max_restarts,omitempty"`
+	// Backoff in seconds before the first restart, it doubles with each
+	// consecutive restart. Defaults to 1
+	Backoff uint `json:"backoff,omitempty"`
+	// MaxBackoff in seconds, defaults to 300
+	MaxBackoff uint `json:"max_backoff,omitempty"`
+}
+
+// forbiddenSyscalls can never be allowed in a container, they act
+// on the whole node
+var forbiddenSyscalls = map[string]struct{}{
+	"acct":              {},
+	"bpf":               {},
+	"clock_adjtime":     {},
+	"clock_settime":     {},
+	"create_module":     {},
+	"delete_module":     {},
+	"finit_module":      {},
+	"init_module":       {},
+	"kexec_file_load":   {},
+	"kexec_load":        {},
+	"open_by_handle_at": {},
+	"reboot":            {},
+	"settimeofday":      {},
+	"swapoff":           {},
+	"swapon":            {},
+	"syslog":            {},
+}
+
+var (
+	syscallRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+	// user:role:type:level, the level can have colons
+	selinuxRegex  = regexp.MustCompile(`^[a-zA-Z0-9_]+:[a-zA-Z0-9_]+:[a-zA-Z0-9_]+:[a-zA-Z0-9_.,:-]+$`)
+	apparmorRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+)
+
+// SecurityProfile confines the processes of a container. The container
+// always runs with the default seccomp profile of containerd, the profile
+// allows more syscalls on top of it
+type SecurityProfile struct {
+	// Syscalls are the syscalls allowed on top of the default seccomp profile
+	Syscalls []string `json:"syscalls,omitempty"`
+	// KillOnViolation kills the process calling a syscall denied by the
+	// seccomp profile instead of failing the call with EPERM. The kernel
+	// only logs the killed calls, so only those are reported as violations
+	KillOnViolation bool `json:"kill_on_violation,omitempty"`
+	// AppArmor is the AppArmor profile of the processes, it must be loaded on the node
+	AppArmor string `json:"apparmor,omitempty"`
+	// SELinux is the SELinux label (user:role:type:level) of the processes
+	SELinux string `json:"selinux,omitempty"`
+}
+
+// Valid checks that the security profile is valid, it doesn't check that
+// the node supports the LSM labels
+func (p SecurityProfile) Valid() error {
+	for _, syscall := range p.Syscalls {
+		if !syscallRegex.MatchString(syscall) {
+			return fmt.Errorf("invalid syscall name '%s'", syscall)
+		}
+		if _, ok := forbiddenSyscalls[syscall]; ok {
+			return fmt.Errorf("syscall '%s' can't be allowed", syscall)
+		}
+	}
+
+	if len(p.AppArmor) != 0 && !apparmorRegex.MatchString(p.AppArmor) {
+		return fmt.Errorf("invalid AppArmor profile name '%s'", p.AppArmor)
+	}
+
+	if len(p.SELinux) != 0 && !selinuxRegex.MatchString(p.SELinux) {
+		return fmt.Errorf("invalid SELinux label '%s'", p.SELinux)
+	}
+
+	if len(p.AppArmor) != 0 && len(p.SELinux) != 0 {
+		return fmt.Errorf("a container can't have both an AppArmor profile and a SELinux label")
+	}
+
+	return nil
+}
+
+// ContainerStatus is the state of the entrypoint of a container
+type ContainerStatus struct {
+	// Running is set while the entrypoint runs
+	Running bool `json:"running"`
+	// ExitStatus of the entrypoint once it exited
+	ExitStatus uint32 `json:"exit_status"`
+	// ExitedAt is the time the entrypoint exited
+	ExitedAt time.Time `json:"exited_at"`
+}
+
+// HealthEvent is sent each time the health of a container changes
+type HealthEvent struct {
+	// Namespace of the container
+	Namespace string `json:"namespace"`
+	// ID of the container
+	ID ContainerID `json:"id"`
+	// Healthy is true if all the liveness checks pass
+	Healthy bool `json:"healthy"`
+	// Restarts is the number of restarts since the container was started
+	Restarts uint `json:"restarts"`
+	// GaveUp is set once the container reached its max restarts
+	GaveUp bool `json:"gave_up,omitempty"`
+	// Error of the failing check if not healthy
+	Error string `json:"error,omitempty"`
+	// Time of the transition
+	Time time.Time `json:"time"`
+}
+
+// ViolationSource is the mechanism that denied an operation of a container
+type ViolationSource string
+
+const (
+	// ViolationSeccomp is a syscall denied by the seccomp profile
+	ViolationSeccomp ViolationSource = "seccomp"
+	// ViolationAppArmor is an operation denied by the AppArmor profile
+	ViolationAppArmor ViolationSource = "apparmor"
+	// ViolationSELinux is an operation denied by the SELinux policy
+	ViolationSELinux ViolationSource = "selinux"
+)
+
+// SecurityViolation is sent when a process of a container is denied an
+// operation by the security profile of the container
+type SecurityViolation struct {
+	// Namespace of the container
+	Namespace string `json:"namespace"`
+	// ID of the container
+	ID ContainerID `json:"id"`
+	// Source of the violation
+	Source ViolationSource `json:"source"`
+	// Pid of the process on the node
+	Pid int `json:"pid"`
+	// Command of the process
+	Command string `json:"command"`
+	// Operation is the syscall number for seccomp or the denied operation
+	// for the LSMs
+	Operation string `json:"operation"`
+	// Time the violation was reported
+	Time time.Time `json:"time"`
+}
+
+// CrashReport is recorded when the process of a container dies unexpectedly
+type CrashReport struct {
+	// ID of the report
+	ID string `json:"id"`
+	// Container that crashed
+	Container ContainerID `json:"container"`
+	// Time of the crash
+	Time time.Time `json:"time"`
+	// ExitStatus of the container process
+	ExitStatus uint32 `json:"exit_status"`
+	// Logs are the last lines of output of the container
+	Logs []string `json:"logs,omitempty"`
+	// CoreSize is the size of the core dump, 0 if no core was dumped
+	CoreSize int64 `json:"core_size,omitempty"`
+	// CoreTruncated is set if the core dump was bigger than the size cap
+	CoreTruncated bool `json:"core_truncated,omitempty"`
+}
+
 // ContainerModule defines rpc interface to containerd
 type ContainerModule interface {
 	// Run creates and starts a container on the node. It also auto
@@ -65,7 +350,30 @@ type ContainerModule interface {
 	// data: Container info
 	Run(ns string, data Container) (ContainerID, error)
 
+	// Update replaces the container data.Name, if it exists, with a new one
+	// created from data. It's up to the caller to keep the network namespace
+	// and the mounts of the container it replaces
+	Update(ns string, data Container) (ContainerID, error)
+
 	// Inspect, return information about the container, given its container id
 	Inspect(ns string, id ContainerID) (Container, error)
 	Delete(ns string, id ContainerID) error
+	// Status returns the state of the entrypoint of a container
+	Status(ns string, id ContainerID) (ContainerStatus, error)
+
+	// Health streams the health transitions of the containers with liveness checks
+	Health(ctx context.Context) <-chan HealthEvent
+	// Violations streams the operations of the containers denied by their security profile
+	Violations(ctx context.Context) <-chan SecurityViolation
+
+	// Crashes lists the crash reports of a container, most recent first
+	Crashes(ns string, id ContainerID) ([]CrashReport, error)
+	// CoreDump returns the core dump of a crash encrypted with the public
+	// key of owner, so only the owner of the reservation can read it
+	CoreDump(ns string, id ContainerID, crash string, owner string) ([]byte, error)
+	// Checkpoint saves the state of a running container in its checkpoints
+	// directory with CRIU. If stop is set the container exits once checkpointed,
+	// otherwise the checkpoint is discarded when contd restarts while the
+	// container is still running
+	Checkpoint(ns string, id ContainerID, stop bool) error
 }
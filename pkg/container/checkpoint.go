@@ -0,0 +1,289 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	"github.com/containerd/containerd/runtime/restart"
+	"github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	// checkpointsLabel is the container label with the checkpoints directory
+	checkpointsLabel = "zos.checkpoints"
+	// checkpointImage is the name of the CRIU image in the checkpoints directory
+	checkpointImage = "checkpoint"
+	// checkpointInventory is written by CRIU, an image without it is incomplete
+	checkpointInventory = "inventory.img"
+)
+
+// checkpointPath returns the path of the checkpoint in dir if there is one
+func checkpointPath(dir string) (string, bool) {
+	if len(dir) == 0 {
+		return "", false
+	}
+
+	image := filepath.Join(dir, checkpointImage)
+	if _, err := os.Stat(filepath.Join(image, checkpointInventory)); err != nil {
+		return "", false
+	}
+
+	return image, true
+}
+
+// withCheckpoints sets the checkpoints directory of the container
+func withCheckpoints(dir string) containerd.NewContainerOpts {
+	return func(_ context.Context, _ *containerd.Client, c *containers.Container) error {
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[checkpointsLabel] = dir
+		return nil
+	}
+}
+
+// forgetCheckpoint removes the checkpoint of a deleted container
+func forgetCheckpoint(ctx context.Context, container containerd.Container) error {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir, ok := labels[checkpointsLabel]
+	if !ok {
+		return nil
+	}
+
+	image := filepath.Join(dir, checkpointImage)
+	if err := os.RemoveAll(image + ".new"); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(image)
+}
+
+// withCheckpointOptions sets the CRIU options of a checkpoint. It must come
+// after containerd.WithCheckpointImagePath that sets the options type of the runtime
+func withCheckpointOptions(exit bool) containerd.CheckpointTaskOpts {
+	return func(info *containerd.CheckpointTaskInfo) error {
+		switch opts := info.Options.(type) {
+		case *runctypes.CheckpointOptions:
+			opts.Exit = exit
+			opts.OpenTcp = true
+			opts.ExternalUnixSockets = true
+			opts.FileLocks = true
+		case *options.CheckpointOptions:
+			opts.Exit = exit
+			opts.OpenTcp = true
+			opts.ExternalUnixSockets = true
+			opts.FileLocks = true
+		default:
+			return fmt.Errorf("unsupported checkpoint options %T", info.Options)
+		}
+
+		return nil
+	}
+}
+
+// checkpoint checkpoints the task of container in its checkpoints directory,
+// the previous checkpoint is only replaced once the new one is complete
+func checkpoint(ctx context.Context, container containerd.Container, exit bool) error {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir, ok := labels[checkpointsLabel]
+	if !ok {
+		return fmt.Errorf("container '%s' has no checkpoints directory", container.ID())
+	}
+
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "container is not running")
+	}
+
+	image := filepath.Join(dir, checkpointImage)
+	tmp := image + ".new"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return errors.Wrap(err, "failed to create checkpoint directory")
+	}
+
+	_, err = task.Checkpoint(ctx,
+		containerd.WithCheckpointImagePath(tmp),
+		withCheckpointOptions(exit),
+	)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return errors.Wrapf(err, "failed to checkpoint container '%s'", container.ID())
+	}
+
+	if err := os.RemoveAll(image); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, image)
+}
+
+// newTask creates the task of container, it is restored from the checkpoint
+// in dir if there is one. The container starts from scratch if it can't be
+// restored, the checkpoint is removed either way so the container is never
+// restored twice from the same state
+func (c *containerModule) newTask(ctx context.Context, container containerd.Container, ioCreate cio.Creator, dir string) (containerd.Task, error) {
+	image, ok := checkpointPath(dir)
+	if !ok {
+		return container.NewTask(ctx, ioCreate)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(image); err != nil {
+			log.Error().Err(err).Str("container", container.ID()).Msg("failed to remove checkpoint")
+		}
+	}()
+
+	task, err := container.NewTask(ctx, ioCreate, containerd.WithRestoreImagePath(image))
+	if err == nil {
+		log.Info().Str("container", container.ID()).Msg("container restored from checkpoint")
+		return task, nil
+	}
+
+	log.Error().Err(err).Str("container", container.ID()).Msg("failed to restore container from checkpoint, starting it from scratch")
+	return container.NewTask(ctx, ioCreate)
+}
+
+// Checkpoint implements pkg.ContainerModule
+func (c *containerModule) Checkpoint(ns string, id pkg.ContainerID, stop bool) error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+
+	container, err := client.LoadContainer(ctx, string(id))
+	if err != nil {
+		return err
+	}
+
+	if !stop {
+		return checkpoint(ctx, container, false)
+	}
+
+	// the container must not be restarted once it exits
+	if err := container.Update(ctx, restart.WithNoRestarts); err != nil {
+		return errors.Wrap(err, "failed to disable container restarts")
+	}
+
+	if err := c.health.unwatch(ns, string(id)); err != nil {
+		log.Error().Err(err).Str("container", string(id)).Msg("failed to stop liveness checks")
+	}
+
+	c.crashes.expect(ns, string(id))
+	if err := checkpoint(ctx, container, true); err != nil {
+		if err := container.Update(ctx, restart.WithStatus(containerd.Running)); err != nil {
+			log.Error().Err(err).Str("container", string(id)).Msg("failed to enable container restarts")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// discardCheckpoints removes the checkpoints of the containers that are still
+// running: they kept running past their checkpoint, restoring it after an
+// unclean reboot would bring back a memory older than their volumes
+func (c *containerModule) discardCheckpoints(ctx context.Context) error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	nss, err := client.NamespaceService().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range nss {
+		ctx := namespaces.WithNamespace(ctx, ns)
+		containers, err := client.Containers(ctx, fmt.Sprintf("labels.%q", checkpointsLabel))
+		if err != nil {
+			log.Error().Err(err).Str("namespace", ns).Msg("failed to list containers")
+			continue
+		}
+
+		for _, container := range containers {
+			task, err := container.Task(ctx, nil)
+			if err != nil {
+				// no task, the container is restored from its checkpoint when it's run again
+				continue
+			}
+
+			status, err := task.Status(ctx)
+			if err != nil || status.Status == containerd.Stopped {
+				continue
+			}
+
+			if err := forgetCheckpoint(ctx, container); err != nil {
+				log.Error().Err(err).Str("container", container.ID()).Msg("failed to discard checkpoint")
+				continue
+			}
+			log.Debug().Str("namespace", ns).Str("container", container.ID()).Msg("checkpoint of running container discarded")
+		}
+	}
+
+	return nil
+}
+
+// CheckpointAll checkpoints all the running containers that have a
+// checkpoints directory, it's done when the node shuts down
+func CheckpointAll(address string) error {
+	if len(address) == 0 {
+		address = containerdSock
+	}
+
+	client, err := containerd.New(address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	nss, err := client.NamespaceService().List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range nss {
+		ctx := namespaces.WithNamespace(context.Background(), ns)
+		containers, err := client.Containers(ctx, fmt.Sprintf("labels.%q", checkpointsLabel))
+		if err != nil {
+			log.Error().Err(err).Str("namespace", ns).Msg("failed to list containers")
+			continue
+		}
+
+		for _, container := range containers {
+			if err := checkpoint(ctx, container, false); err != nil {
+				log.Error().Err(err).Str("container", container.ID()).Msg("failed to checkpoint container")
+				continue
+			}
+			log.Info().Str("namespace", ns).Str("container", container.ID()).Msg("container checkpointed")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, ok := checkpointPath("")
+	assert.False(t, ok)
+
+	_, ok = checkpointPath(dir)
+	assert.False(t, ok)
+
+	// an interrupted checkpoint has no inventory
+	image := filepath.Join(dir, checkpointImage)
+	require.NoError(t, os.MkdirAll(image, 0700))
+	_, ok = checkpointPath(dir)
+	assert.False(t, ok)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(image, checkpointInventory), nil, 0600))
+	path, ok := checkpointPath(dir)
+	assert.True(t, ok)
+	assert.Equal(t, image, path)
+}
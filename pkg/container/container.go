@@ -20,13 +20,16 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
 	"github.com/containerd/containerd/runtime/restart"
 	"github.com/google/shlex"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/cancel"
 	"github.com/threefoldtech/zos/pkg/container/logger"
 	"github.com/threefoldtech/zos/pkg/container/stats"
+	"github.com/threefoldtech/zos/pkg/tracing"
 
 	"github.com/containerd/containerd/cio"
 )
@@ -40,6 +43,10 @@ const (
 	defaultCPU    = 1
 )
 
+// runTimeout bounds the creation of a container when the caller didn't set
+// a shorter deadline
+const runTimeout = 5 * time.Minute
+
 var (
 	ignoreMntTypes = map[string]struct{}{
 		"proc":   {},
@@ -58,22 +65,54 @@ var (
 type containerModule struct {
 	containerd string
 	root       string
+	broker     pkg.Broker
+	health     *healthMonitor
+	crashes    *crashCollector
+	violations *violationMonitor
 }
 
-// New return an new pkg.ContainerModule
-func New(root string, containerd string) pkg.ContainerModule {
+// New return an new pkg.ContainerModule, the liveness checks in the network
+// namespaces of the containers are run by broker
+func New(root string, containerd string, broker pkg.Broker) pkg.ContainerModule {
 	if len(containerd) == 0 {
 		containerd = containerdSock
 	}
 
-	return &containerModule{
+	c := &containerModule{
 		containerd: containerd,
 		root:       root,
+		broker:     broker,
+		crashes:    newCrashCollector(filepath.Join(root, "crashes")),
+		violations: newViolationMonitor(),
+	}
+
+	c.health = newHealthMonitor(filepath.Join(root, "health"), c.livenessCheck, c.restartTask)
+	if err := c.health.restore(); err != nil {
+		log.Error().Err(err).Msg("failed to restore containers liveness checks")
+	}
+
+	if err := c.discardCheckpoints(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to discard the checkpoints of the running containers")
 	}
+
+	go c.watchEvents(context.Background())
+	go c.watchViolations(context.Background())
+
+	return c
 }
 
 // Run creates and starts a container
 func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID, err error) {
+	ctx, span := tracing.Serve("Run", data.Name)
+	defer func() { span.Finish(err) }()
+
+	ctx, release := cancel.Serve(ctx, "Run", data.Name, runTimeout)
+	defer release()
+
+	return c.run(ctx, ns, data)
+}
+
+func (c *containerModule) run(ctx context.Context, ns string, data pkg.Container) (id pkg.ContainerID, err error) {
 	// create a new client connected to the default socket path for containerd
 	client, err := containerd.New(c.containerd)
 	if err != nil {
@@ -81,7 +120,7 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 	}
 	defer client.Close()
 
-	ctx := namespaces.WithNamespace(context.Background(), ns)
+	ctx = namespaces.WithNamespace(ctx, ns)
 
 	if err := c.ensureNamespace(ctx, client, ns); err != nil {
 		return id, err
@@ -107,6 +146,25 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 		return "", fmt.Errorf("cannot create container without network namespace")
 	}
 
+	for i, check := range data.Liveness {
+		if err := check.Valid(); err != nil {
+			return id, errors.Wrapf(err, "invalid liveness check %d", i)
+		}
+	}
+
+	devices, err := resolveDevices(data.Devices)
+	if err != nil {
+		return id, errors.Wrap(err, "container device request refused")
+	}
+
+	if err := data.Security.Valid(); err != nil {
+		return id, errors.Wrap(err, "invalid security profile")
+	}
+
+	if err := checkSecurity(data.Security); err != nil {
+		return id, errors.Wrap(err, "security profile not supported")
+	}
+
 	if err := applyStartup(&data, filepath.Join(data.RootFS, ".startup.toml")); err != nil {
 		errors.Wrap(err, "error updating environment variable from startup file")
 	}
@@ -121,12 +179,19 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 		withMounts(data.Mounts),
 		WithMemoryLimit(data.Memory),
 		WithCPUCount(data.CPU),
+		withIOLimit(data.IO, data.IODevices),
+		withDevices(devices),
+		withCoreDumps(),
 	}
 
 	if data.WorkingDir != "" {
 		opts = append(opts, oci.WithProcessCwd(data.WorkingDir))
 	}
 
+	if data.UID != 0 || data.GID != 0 {
+		opts = append(opts, oci.WithUIDGID(data.UID, data.GID))
+	}
+
 	if data.Interactive {
 		opts = append(opts, withCoreX())
 	} else {
@@ -138,19 +203,37 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 		opts = append(opts, oci.WithProcessArgs(args...))
 	}
 
+	// the init steps run with the same security profile
+	opts = append(opts, withSecurity(data.Security))
+
+	if err := tracing.Step(ctx, "init steps", func() error { return c.runInit(ctx, client, ns, data, opts) }); err != nil {
+		return id, err
+	}
+
 	log.Info().
 		Str("namespace", ns).
 		Str("data", fmt.Sprintf("%+v", data)).
 		Msgf("create new container")
 
-	container, err := client.NewContainer(
-		ctx,
-		data.Name,
+	containerOpts := []containerd.NewContainerOpts{
 		containerd.WithNewSpec(opts...),
+	}
+
+	if !data.RunOnce {
 		// this ensure that the container/task will be restarted automatically
 		// if it gets killed for whatever reason (mostly OOM killer)
-		restart.WithStatus(containerd.Running),
-	)
+		containerOpts = append(containerOpts, restart.WithStatus(containerd.Running))
+	}
+
+	if len(data.Checkpoints) != 0 {
+		containerOpts = append(containerOpts, withCheckpoints(data.Checkpoints))
+	}
+
+	var container containerd.Container
+	err = tracing.Step(ctx, "create container", func() (err error) {
+		container, err = client.NewContainer(ctx, data.Name, containerOpts...)
+		return err
+	})
 	if err != nil {
 		return id, err
 	}
@@ -173,9 +256,17 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 		// (preparing, creating, and starting a task)
 		if err != nil {
 			container.Delete(ctx, containerd.WithSnapshotCleanup)
+			_ = c.crashes.forget(ns, container.ID())
 		}
 	}()
 
+	// the output of the container is also kept on the node for the crash reports
+	output, err := c.crashes.track(ns, container.ID())
+	if err != nil {
+		return id, err
+	}
+	data.Logs = append(data.Logs, output)
+
 	// creating logs config directories
 	cfgs := path.Join(c.root, "config", ns)
 	if err = os.MkdirAll(cfgs, 0755); err != nil {
@@ -201,7 +292,7 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 
 	log.Info().Str("loguri", uri.String()).Msg("external logging process")
 
-	task, err := container.NewTask(ctx, cio.LogURI(uri))
+	task, err := c.newTask(ctx, container, cio.LogURI(uri), data.Checkpoints)
 	if err != nil {
 		log.Error().Err(err).Msg("logger new task")
 		return id, err
@@ -226,13 +317,43 @@ func (c *containerModule) Run(ns string, data pkg.Container) (id pkg.ContainerID
 	}
 
 	// call start on the task to execute the redis server
-	if err := task.Start(ctx); err != nil {
+	if err := tracing.Step(ctx, "start task", func() error { return task.Start(ctx) }); err != nil {
 		return id, err
 	}
 
+	if len(data.Liveness) > 0 {
+		spec := healthSpec{
+			Namespace: ns,
+			ID:        container.ID(),
+			NetNS:     data.Network.Namespace,
+			Liveness:  data.Liveness,
+			Restart:   data.Restart,
+		}
+		if err := c.health.watch(spec); err != nil {
+			log.Error().Err(err).Str("container", container.ID()).Msg("failed to start liveness checks")
+		}
+	}
+
 	return pkg.ContainerID(container.ID()), nil
 }
 
+// Update replaces a container with a new one with the same name
+func (c *containerModule) Update(ns string, data pkg.Container) (id pkg.ContainerID, err error) {
+	ctx, span := tracing.Serve("Update", data.Name)
+	defer func() { span.Finish(err) }()
+
+	ctx, release := cancel.Serve(ctx, "Update", data.Name, runTimeout)
+	defer release()
+
+	err = tracing.Step(ctx, "delete container", func() error { return c.Delete(ns, pkg.ContainerID(data.Name)) })
+	if err != nil && !errdefs.IsNotFound(err) {
+		return "", errors.Wrapf(err, "failed to stop container %s", data.Name)
+	}
+
+	log.Info().Str("namespace", ns).Str("container", data.Name).Msg("updating container")
+	return c.run(ctx, ns, data)
+}
+
 // Inspect returns the detail about a running container
 func (c *containerModule) Inspect(ns string, id pkg.ContainerID) (result pkg.Container, err error) {
 	client, err := containerd.New(c.containerd)
@@ -263,6 +384,8 @@ func (c *containerModule) Inspect(ns string, id pkg.ContainerID) (result pkg.Con
 	if process := spec.Process; process != nil {
 		result.Entrypoint = strings.Join(process.Args, " ")
 		result.Env = process.Env
+		result.Security.AppArmor = process.ApparmorProfile
+		result.Security.SELinux = process.SelinuxLabel
 	}
 
 	for _, mount := range spec.Mounts {
@@ -286,6 +409,43 @@ func (c *containerModule) Inspect(ns string, id pkg.ContainerID) (result pkg.Con
 	return
 }
 
+// Status returns the state of the entrypoint of a container
+func (c *containerModule) Status(ns string, id pkg.ContainerID) (result pkg.ContainerStatus, err error) {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return result, err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+
+	container, err := client.LoadContainer(ctx, string(id))
+	if err != nil {
+		return result, err
+	}
+
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		// the task is gone, the container is not running
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+
+	status, err := task.Status(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.Running = status.Status != containerd.Stopped
+	if !result.Running {
+		result.ExitStatus = status.ExitStatus
+		result.ExitedAt = status.ExitTime
+	}
+
+	return result, nil
+}
+
 // Deletes stops and remove a container
 func (c *containerModule) Delete(ns string, id pkg.ContainerID) error {
 	client, err := containerd.New(c.containerd)
@@ -296,6 +456,14 @@ func (c *containerModule) Delete(ns string, id pkg.ContainerID) error {
 
 	ctx := namespaces.WithNamespace(context.Background(), ns)
 
+	if err := c.health.unwatch(ns, string(id)); err != nil {
+		log.Error().Err(err).Str("container", string(id)).Msg("failed to stop liveness checks")
+	}
+
+	if err := c.crashes.forget(ns, string(id)); err != nil {
+		log.Error().Err(err).Str("container", string(id)).Msg("failed to delete crash reports")
+	}
+
 	container, err := client.LoadContainer(ctx, string(id))
 	if err != nil {
 		return err
@@ -305,6 +473,10 @@ func (c *containerModule) Delete(ns string, id pkg.ContainerID) error {
 		log.Warn().Err(err).Msg("failed to clear up restart task status, continuing anyways")
 	}
 
+	if err := forgetCheckpoint(ctx, container); err != nil {
+		log.Error().Err(err).Str("container", string(id)).Msg("failed to delete checkpoint")
+	}
+
 	task, err := container.Task(ctx, nil)
 	if err == nil {
 		// err == nil, there is a task running inside the container
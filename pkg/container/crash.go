@@ -0,0 +1,371 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/container/logger"
+)
+
+const (
+	// crashCoreSize caps the size of the core dumps kept on the node
+	crashCoreSize = 64 * 1024 * 1024 // 64MiB
+	// crashOutputSize caps the output of a container kept for the reports
+	crashOutputSize = 4 * 1024 * 1024 // 4MiB
+	// crashLogLines is the number of lines of output kept in a report
+	crashLogLines = 100
+	// crashKeep is the number of reports kept per container
+	crashKeep = 5
+
+	crashOutput      = "output.log"
+	crashPendingCore = "pending-"
+)
+
+var crashIDRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// crashCollector keeps the crash reports of the containers under root, one
+// directory per container. Only the containers created by contd are tracked,
+// the exits of the others (init steps for example) are ignored
+type crashCollector struct {
+	root string
+
+	mu       sync.Mutex
+	expected map[string]struct{}
+}
+
+func newCrashCollector(root string) *crashCollector {
+	return &crashCollector{
+		root:     root,
+		expected: make(map[string]struct{}),
+	}
+}
+
+func (c *crashCollector) dir(ns, id string) string {
+	return filepath.Join(c.root, ns, id)
+}
+
+// track starts recording the crashes of a container. It returns the log
+// backend that keeps the output of the container for the reports
+func (c *crashCollector) track(ns, id string) (logger.Logs, error) {
+	dir := c.dir(ns, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return logger.Logs{}, errors.Wrap(err, "failed to create crashes directory")
+	}
+
+	output := filepath.Join(dir, crashOutput)
+	return logger.Logs{
+		Type: logger.FileType,
+		Data: logger.LogsRedis{
+			Stdout: output,
+			Stderr: output,
+		},
+	}, nil
+}
+
+// forget stops recording the crashes of a container and deletes its reports
+func (c *crashCollector) forget(ns, id string) error {
+	c.mu.Lock()
+	delete(c.expected, filepath.Join(ns, id))
+	c.mu.Unlock()
+
+	return os.RemoveAll(c.dir(ns, id))
+}
+
+// expect marks the next exit of the container as expected, because
+// contd is the one stopping it
+func (c *crashCollector) expect(ns, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expected[filepath.Join(ns, id)] = struct{}{}
+}
+
+// exited is called each time the process of a container exits
+func (c *crashCollector) exited(ns, id string, status uint32, at time.Time) {
+	c.mu.Lock()
+	key := filepath.Join(ns, id)
+	_, expected := c.expected[key]
+	delete(c.expected, key)
+	c.mu.Unlock()
+
+	if expected || status == 0 {
+		return
+	}
+
+	if _, err := os.Stat(c.dir(ns, id)); err != nil {
+		return
+	}
+
+	report, err := c.record(ns, id, status, at)
+	if err != nil {
+		log.Error().Err(err).Str("namespace", ns).Str("container", id).Msg("failed to record container crash")
+		return
+	}
+
+	log.Warn().
+		Str("namespace", ns).
+		Str("container", id).
+		Uint32("status", status).
+		Int64("core", report.CoreSize).
+		Msg("container crashed")
+}
+
+// record writes the crash report of the container, with the last lines of
+// its output and the core dump if the crashing process dumped one
+func (c *crashCollector) record(ns, id string, status uint32, at time.Time) (pkg.CrashReport, error) {
+	dir := c.dir(ns, id)
+	report := pkg.CrashReport{
+		ID:         fmt.Sprintf("%019d", at.UnixNano()),
+		Container:  pkg.ContainerID(id),
+		Time:       at,
+		ExitStatus: status,
+	}
+
+	logs, err := tail(filepath.Join(dir, crashOutput), crashLogLines)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("container", id).Msg("failed to read container output")
+	}
+	report.Logs = logs
+
+	cores, err := filepath.Glob(filepath.Join(dir, crashPendingCore+"*"))
+	if err != nil {
+		return report, err
+	}
+	// the kernel hands the core dumps to contd before the process exits, if many
+	// processes of the container dumped a core only the last one is kept
+	sort.Slice(cores, func(i, j int) bool {
+		return modTime(cores[i]).Before(modTime(cores[j]))
+	})
+	for i, core := range cores {
+		if i < len(cores)-1 {
+			_ = os.Remove(core)
+			continue
+		}
+
+		target := filepath.Join(dir, report.ID+".core")
+		if err := os.Rename(core, target); err != nil {
+			return report, errors.Wrap(err, "failed to keep core dump")
+		}
+
+		if stat, err := os.Stat(target); err == nil {
+			report.CoreSize = stat.Size()
+			report.CoreTruncated = stat.Size() >= crashCoreSize
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return report, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, report.ID+".json"), data, 0600); err != nil {
+		return report, errors.Wrap(err, "failed to write crash report")
+	}
+
+	return report, c.prune(dir)
+}
+
+// prune deletes the oldest reports of a container above crashKeep
+func (c *crashCollector) prune(dir string) error {
+	reports, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(reports)
+	for len(reports) > crashKeep {
+		base := strings.TrimSuffix(reports[0], ".json")
+		for _, path := range []string{base + ".json", base + ".core"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		reports = reports[1:]
+	}
+
+	return nil
+}
+
+// trim empties the output files that grew above crashOutputSize. The
+// loggers append to the file, so they keep writing at its new end
+func (c *crashCollector) trim() {
+	outputs, err := filepath.Glob(filepath.Join(c.root, "*", "*", crashOutput))
+	if err != nil {
+		return
+	}
+
+	for _, output := range outputs {
+		stat, err := os.Stat(output)
+		if err != nil || stat.Size() < crashOutputSize {
+			continue
+		}
+
+		if err := os.Truncate(output, 0); err != nil {
+			log.Error().Err(err).Str("path", output).Msg("failed to trim container output")
+		}
+	}
+}
+
+// list returns the crash reports of a container, most recent first
+func (c *crashCollector) list(ns, id string) ([]pkg.CrashReport, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir(ns, id), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	reports := make([]pkg.CrashReport, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var report pkg.CrashReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, errors.Wrapf(err, "invalid crash report '%s'", path)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// core returns the path of the core dump of a crash
+func (c *crashCollector) core(ns, id, crash string) (string, error) {
+	if !crashIDRegex.MatchString(crash) {
+		return "", fmt.Errorf("invalid crash id '%s'", crash)
+	}
+
+	path := filepath.Join(c.dir(ns, id), crash+".core")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("crash '%s' has no core dump", crash)
+	} else if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// tail returns the last n lines of the file
+func tail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// lines are read from the last 64KiB only, which is plenty for n lines
+	const window = 64 * 1024
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := stat.Size() - window
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, window), window)
+	first := offset > 0
+	for scanner.Scan() {
+		if first {
+			// most probably a partial line
+			first = false
+			continue
+		}
+
+		lines = append(lines, string(bytes.TrimRight(scanner.Bytes(), "\r")))
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+func modTime(path string) time.Time {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// containerOf finds the container a process belongs to from its cgroup.
+// containerd places the processes of the containers in /<namespace>/<id>
+func containerOf(cgroup io.Reader) (ns, id string, err error) {
+	scanner := bufio.NewScanner(cgroup)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		path := strings.Split(strings.Trim(parts[2], "/"), "/")
+		if len(path) == 2 && path[0] != "" && path[1] != "" {
+			return path[0], path[1], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return "", "", fmt.Errorf("process is not in a container")
+}
+
+// CollectCoreDump stores the core dump of process pid read from r, if the
+// process belongs to a container tracked by contd. It's called by the kernel
+// (the broker configures it, see pkg.Broker) and runs in the host namespaces
+func CollectCoreDump(root string, pid int, r io.Reader) error {
+	cgroup, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+
+	ns, id, err := containerOf(cgroup)
+	if err != nil {
+		return err
+	}
+
+	crashes := newCrashCollector(filepath.Join(root, "crashes"))
+	dir := crashes.dir(ns, id)
+	if _, err := os.Stat(dir); err != nil {
+		return errors.Wrapf(err, "container %s/%s is not tracked", ns, id)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%s%d", crashPendingCore, pid)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(f, r, crashCoreSize); err != nil && err != io.EOF {
+		return errors.Wrap(err, "failed to write core dump")
+	}
+
+	return nil
+}
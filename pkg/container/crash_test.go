@@ -0,0 +1,107 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestContainerOf(t *testing.T) {
+	ns, id, err := containerOf(strings.NewReader("12:pids:/ns1/web\n11:memory:/ns1/web\n0::/\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "ns1", ns)
+	assert.Equal(t, "web", id)
+
+	_, _, err = containerOf(strings.NewReader("12:pids:/system.slice\n0::/\n"))
+	assert.Error(t, err)
+}
+
+func TestTail(t *testing.T) {
+	root, err := ioutil.TempDir("", "crash")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "output.log")
+	var buf strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "line %d\n", i)
+	}
+	require.NoError(t, ioutil.WriteFile(path, []byte(buf.String()), 0600))
+
+	lines, err := tail(path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 9997", "line 9998", "line 9999"}, lines)
+}
+
+func TestCrashCollector(t *testing.T) {
+	root, err := ioutil.TempDir("", "crash")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	crashes := newCrashCollector(root)
+
+	// not tracked
+	crashes.exited("ns", "web", 1, time.Now())
+	_, err = os.Stat(crashes.dir("ns", "web"))
+	assert.True(t, os.IsNotExist(err))
+
+	output, err := crashes.track("ns", "web")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(output.Data.Stdout, []byte("starting\npanic: boom\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(crashes.dir("ns", "web"), crashPendingCore+"42"), []byte("core"), 0600))
+
+	// clean exits and expected exits are not crashes
+	crashes.exited("ns", "web", 0, time.Now())
+	crashes.expect("ns", "web")
+	crashes.exited("ns", "web", 143, time.Now())
+
+	reports, err := crashes.list("ns", "web")
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+
+	crashes.exited("ns", "web", 2, time.Now())
+	reports, err = crashes.list("ns", "web")
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+
+	report := reports[0]
+	assert.Equal(t, pkg.ContainerID("web"), report.Container)
+	assert.Equal(t, uint32(2), report.ExitStatus)
+	assert.Equal(t, []string{"starting", "panic: boom"}, report.Logs)
+	assert.Equal(t, int64(4), report.CoreSize)
+
+	path, err := crashes.core("ns", "web", report.ID)
+	require.NoError(t, err)
+	core, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "core", string(core))
+
+	_, err = crashes.core("ns", "web", "../../etc/passwd")
+	assert.Error(t, err)
+
+	// only the last reports are kept
+	at := time.Now()
+	for i := 0; i < crashKeep+2; i++ {
+		crashes.exited("ns", "web", 1, at.Add(time.Duration(i+1)*time.Second))
+	}
+	reports, err = crashes.list("ns", "web")
+	require.NoError(t, err)
+	require.Len(t, reports, crashKeep)
+	assert.True(t, reports[0].Time.After(reports[1].Time))
+
+	_, err = crashes.core("ns", "web", report.ID)
+	assert.Error(t, err)
+
+	require.NoError(t, crashes.forget("ns", "web"))
+	reports, err = crashes.list("ns", "web")
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+}
@@ -0,0 +1,138 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// devicePolicy describes a host device a container can ask for
+type devicePolicy struct {
+	// Path of the device on the host and in the container
+	Path string
+	// Access is the cgroup access granted on the device
+	Access string
+	// Check returns an error if the device can't be given on this node
+	Check func() error
+}
+
+// devicePolicies are the devices containers can be given access to, by name.
+// Any other device is refused
+var devicePolicies = map[string]devicePolicy{
+	"tun": {
+		Path:   "/dev/net/tun",
+		Access: "rwm",
+	},
+	"fuse": {
+		Path:   "/dev/fuse",
+		Access: "rwm",
+	},
+	"kvm": {
+		Path:   "/dev/kvm",
+		Access: "rwm",
+		Check:  hasVirtualization,
+	},
+}
+
+// hasVirtualization checks that the node supports hardware virtualization
+func hasVirtualization() error {
+	if _, err := os.Stat("/dev/kvm"); err != nil {
+		return fmt.Errorf("node has no hardware virtualization support")
+	}
+	return nil
+}
+
+// linuxDevice is a device resolved on the host
+type linuxDevice struct {
+	specs.LinuxDevice
+	access string
+}
+
+// resolveDevices applies the policy of each requested device and
+// looks them up on the host
+func resolveDevices(names []string) ([]linuxDevice, error) {
+	devices := make([]linuxDevice, 0, len(names))
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		policy, ok := devicePolicies[name]
+		if !ok {
+			return nil, fmt.Errorf("device '%s' is not allowed in containers", name)
+		}
+
+		if policy.Check != nil {
+			if err := policy.Check(); err != nil {
+				return nil, errors.Wrapf(err, "device '%s' is not allowed", name)
+			}
+		}
+
+		var stat unix.Stat_t
+		if err := unix.Stat(policy.Path, &stat); err != nil {
+			return nil, errors.Wrapf(err, "failed to stat device %s", policy.Path)
+		}
+
+		var kind string
+		switch stat.Mode & unix.S_IFMT {
+		case unix.S_IFCHR:
+			kind = "c"
+		case unix.S_IFBLK:
+			kind = "b"
+		default:
+			return nil, fmt.Errorf("%s is not a device", policy.Path)
+		}
+
+		mode := os.FileMode(stat.Mode &^ unix.S_IFMT)
+		uid, gid := uint32(0), uint32(0)
+		devices = append(devices, linuxDevice{
+			LinuxDevice: specs.LinuxDevice{
+				Path:     policy.Path,
+				Type:     kind,
+				Major:    int64(unix.Major(uint64(stat.Rdev))),
+				Minor:    int64(unix.Minor(uint64(stat.Rdev))),
+				FileMode: &mode,
+				UID:      &uid,
+				GID:      &gid,
+			},
+			access: policy.Access,
+		})
+	}
+
+	return devices, nil
+}
+
+// withDevices creates the devices in the container and allows them in its device cgroup
+func withDevices(devices []linuxDevice) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if len(devices) == 0 {
+			return nil
+		}
+
+		if s.Linux.Resources == nil {
+			s.Linux.Resources = &specs.LinuxResources{}
+		}
+
+		for _, device := range devices {
+			major, minor := device.Major, device.Minor
+			s.Linux.Devices = append(s.Linux.Devices, device.LinuxDevice)
+			s.Linux.Resources.Devices = append(s.Linux.Resources.Devices, specs.LinuxDeviceCgroup{
+				Allow:  true,
+				Type:   device.Type,
+				Major:  &major,
+				Minor:  &minor,
+				Access: device.access,
+			})
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveDevicesPolicy(t *testing.T) {
+	_, err := resolveDevices([]string{"sda"})
+	assert.Error(t, err)
+
+	devicePolicies["test"] = devicePolicy{
+		Path:  "/dev/null",
+		Check: func() error { return fmt.Errorf("not on this node") },
+	}
+	defer delete(devicePolicies, "test")
+
+	_, err = resolveDevices([]string{"test"})
+	assert.Error(t, err)
+}
+
+func TestResolveDevices(t *testing.T) {
+	devicePolicies["null"] = devicePolicy{Path: "/dev/null", Access: "rw"}
+	defer delete(devicePolicies, "null")
+
+	devices, err := resolveDevices([]string{"null", "null"})
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+
+	device := devices[0]
+	assert.Equal(t, "/dev/null", device.Path)
+	assert.Equal(t, "c", device.Type)
+	assert.Equal(t, int64(1), device.Major)
+	assert.Equal(t, int64(3), device.Minor)
+
+	spec := oci.Spec{Linux: &specs.Linux{}}
+	require.NoError(t, withDevices(devices)(context.Background(), nil, nil, &spec))
+
+	require.Len(t, spec.Linux.Devices, 1)
+	require.Len(t, spec.Linux.Resources.Devices, 1)
+	rule := spec.Linux.Resources.Devices[0]
+	assert.True(t, rule.Allow)
+	assert.Equal(t, "rw", rule.Access)
+	assert.Equal(t, int64(1), *rule.Major)
+	assert.Equal(t, int64(3), *rule.Minor)
+}
@@ -0,0 +1,121 @@
+package container
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/typeurl"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/crypto"
+)
+
+// Crashes implements pkg.ContainerModule
+func (c *containerModule) Crashes(ns string, id pkg.ContainerID) ([]pkg.CrashReport, error) {
+	return c.crashes.list(ns, string(id))
+}
+
+// CoreDump implements pkg.ContainerModule
+func (c *containerModule) CoreDump(ns string, id pkg.ContainerID, crash string, owner string) ([]byte, error) {
+	key, err := crypto.KeyFromID(pkg.StrIdentifier(owner))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the public key of the owner")
+	}
+
+	path, err := c.crashes.core(ns, string(id), crash)
+	if err != nil {
+		return nil, err
+	}
+
+	core, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Encrypt(core, key)
+}
+
+// watchEvents follows the exits of the containers tasks to record their crashes
+func (c *containerModule) watchEvents(ctx context.Context) {
+	for {
+		err := c.events(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error().Err(err).Msg("lost containerd events stream, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *containerModule) events(ctx context.Context) error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	trim := time.NewTicker(time.Minute)
+	defer trim.Stop()
+
+	events, errs := client.Subscribe(ctx, `topic=="/tasks/exit"`)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case <-trim.C:
+			c.crashes.trim()
+		case envelope := <-events:
+			event, err := typeurl.UnmarshalAny(envelope.Event)
+			if err != nil {
+				log.Error().Err(err).Str("topic", envelope.Topic).Msg("failed to decode containerd event")
+				continue
+			}
+
+			exit, ok := event.(*apievents.TaskExit)
+			// the exit of exec processes, like the liveness checks, are not crashes
+			if !ok || exit.ID != exit.ContainerID {
+				continue
+			}
+
+			c.crashes.exited(envelope.Namespace, exit.ContainerID, exit.ExitStatus, exit.ExitedAt)
+		}
+	}
+}
+
+// withCoreDumps allows the processes of the container to dump their core
+func withCoreDumps() oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Process == nil {
+			s.Process = &specs.Process{}
+		}
+
+		for i, limit := range s.Process.Rlimits {
+			if limit.Type == "RLIMIT_CORE" {
+				s.Process.Rlimits = append(s.Process.Rlimits[:i], s.Process.Rlimits[i+1:]...)
+				break
+			}
+		}
+
+		s.Process.Rlimits = append(s.Process.Rlimits, specs.POSIXRlimit{
+			Type: "RLIMIT_CORE",
+			Hard: crashCoreSize,
+			Soft: crashCoreSize,
+		})
+
+		return nil
+	}
+}
@@ -0,0 +1,401 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	defaultLivenessInterval  = 10
+	defaultLivenessTimeout   = 2
+	defaultLivenessThreshold = 3
+	defaultRestartBackoff    = 1
+	defaultRestartMaxBackoff = 300
+)
+
+// healthSpec is what is needed to monitor the health of a container,
+// it is persisted so the monitoring survives a restart of contd
+type healthSpec struct {
+	Namespace string              `json:"namespace"`
+	ID        string              `json:"id"`
+	NetNS     string              `json:"netns"`
+	Liveness  []pkg.LivenessCheck `json:"liveness"`
+	Restart   pkg.RestartPolicy   `json:"restart"`
+}
+
+func (s *healthSpec) key() string {
+	return filepath.Join(s.Namespace, s.ID)
+}
+
+type checkState struct {
+	threshold uint
+	failures  uint
+	err       error
+}
+
+type healthWatch struct {
+	spec   healthSpec
+	cancel context.CancelFunc
+	checks []checkState
+
+	healthy    bool
+	restarting bool
+	gaveUp     bool
+	restarts   uint
+	// consecutive are the restarts since the container was last healthy,
+	// the backoff grows with them
+	consecutive uint
+}
+
+// livenessChecker runs a single liveness check of a container
+type livenessChecker func(ctx context.Context, spec healthSpec, check pkg.LivenessCheck) error
+
+// restarter restarts a container after waiting backoff
+type restarter func(ns, id string, backoff time.Duration) error
+
+// healthMonitor runs the liveness checks of the containers, and restarts
+// the ones that are not alive anymore following their restart policy
+type healthMonitor struct {
+	root    string
+	check   livenessChecker
+	restart restarter
+
+	mu          sync.Mutex
+	watches     map[string]*healthWatch
+	subscribers map[chan pkg.HealthEvent]struct{}
+}
+
+func newHealthMonitor(root string, check livenessChecker, restart restarter) *healthMonitor {
+	return &healthMonitor{
+		root:        root,
+		check:       check,
+		restart:     restart,
+		watches:     make(map[string]*healthWatch),
+		subscribers: make(map[chan pkg.HealthEvent]struct{}),
+	}
+}
+
+// restore starts watching the containers persisted by a previous run
+func (m *healthMonitor) restore() error {
+	paths, err := filepath.Glob(filepath.Join(m.root, "*", "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var spec healthSpec
+		if err := json.Unmarshal(data, &spec); err != nil {
+			log.Error().Err(err).Str("path", path).Msg("dropping invalid container health spec")
+			_ = os.Remove(path)
+			continue
+		}
+
+		m.start(spec)
+	}
+
+	return nil
+}
+
+// watch starts the liveness checks of a container and persists them
+func (m *healthMonitor) watch(spec healthSpec) error {
+	path := filepath.Join(m.root, spec.key()+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to persist container health spec")
+	}
+
+	m.start(spec)
+	return nil
+}
+
+func (m *healthMonitor) start(spec healthSpec) {
+	m.stop(spec.Namespace, spec.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &healthWatch{
+		spec:    spec,
+		cancel:  cancel,
+		checks:  make([]checkState, len(spec.Liveness)),
+		healthy: true,
+	}
+	for i, check := range spec.Liveness {
+		w.checks[i].threshold = check.FailureThreshold
+		if w.checks[i].threshold == 0 {
+			w.checks[i].threshold = defaultLivenessThreshold
+		}
+	}
+
+	m.mu.Lock()
+	m.watches[spec.key()] = w
+	m.mu.Unlock()
+
+	for i, check := range spec.Liveness {
+		go m.run(ctx, w, i, check)
+	}
+}
+
+func (m *healthMonitor) stop(ns, id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := filepath.Join(ns, id)
+	if w, ok := m.watches[key]; ok {
+		w.cancel()
+		delete(m.watches, key)
+	}
+}
+
+// unwatch stops the liveness checks of a container and forgets about it
+func (m *healthMonitor) unwatch(ns, id string) error {
+	m.stop(ns, id)
+
+	err := os.Remove(filepath.Join(m.root, ns, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (m *healthMonitor) run(ctx context.Context, w *healthWatch, index int, check pkg.LivenessCheck) {
+	interval := time.Duration(check.Interval) * time.Second
+	if interval == 0 {
+		interval = defaultLivenessInterval * time.Second
+	}
+
+	timeout := time.Duration(check.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultLivenessTimeout * time.Second
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(check.InitialDelay) * time.Second):
+	}
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := m.check(checkCtx, w.spec, check)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.update(w, index, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// update records the result of check index. The container is restarted
+// once a check failed more than its threshold
+func (m *healthMonitor) update(w *healthWatch, index int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watches[w.spec.key()] != w || w.restarting {
+		return
+	}
+
+	state := &w.checks[index]
+	if err == nil {
+		state.failures = 0
+		state.err = nil
+	} else {
+		state.failures++
+		state.err = err
+	}
+
+	passing, failing := true, false
+	var reason error
+	for _, state := range w.checks {
+		if state.failures > 0 {
+			passing = false
+		}
+		if state.failures >= state.threshold {
+			failing = true
+			reason = state.err
+			break
+		}
+	}
+
+	if passing {
+		if !w.healthy {
+			w.healthy = true
+			w.gaveUp = false
+			w.consecutive = 0
+			m.publish(w, nil)
+		}
+		return
+	}
+
+	if !failing {
+		// some checks failed but not enough times to make a decision yet
+		return
+	}
+
+	if w.healthy {
+		w.healthy = false
+		m.publish(w, reason)
+	}
+
+	if w.gaveUp {
+		return
+	}
+
+	policy := w.spec.Restart
+	if policy.MaxRestarts != 0 && w.restarts >= policy.MaxRestarts {
+		log.Error().Str("container", w.spec.ID).Uint("restarts", w.restarts).Msg("container is not alive and reached its max restarts, giving up")
+		w.gaveUp = true
+		m.publish(w, reason)
+		return
+	}
+
+	w.restarting = true
+	go m.restartContainer(w, backoff(policy, w.consecutive), reason)
+}
+
+func (m *healthMonitor) restartContainer(w *healthWatch, wait time.Duration, reason error) {
+	log.Warn().Err(reason).
+		Str("namespace", w.spec.Namespace).
+		Str("container", w.spec.ID).
+		Str("backoff", wait.String()).
+		Msg("container is not alive, restarting")
+
+	err := m.restart(w.spec.Namespace, w.spec.ID, wait)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Str("container", w.spec.ID).Msg("failed to restart container")
+	}
+
+	w.restarts++
+	w.consecutive++
+	w.restarting = false
+	for i := range w.checks {
+		w.checks[i].failures = 0
+		w.checks[i].err = nil
+	}
+
+	m.publish(w, err)
+}
+
+// backoff returns how long to wait before the next restart of a
+// container that was restarted consecutive times already
+func backoff(policy pkg.RestartPolicy, consecutive uint) time.Duration {
+	initial := policy.Backoff
+	if initial == 0 {
+		initial = defaultRestartBackoff
+	}
+
+	max := policy.MaxBackoff
+	if max == 0 {
+		max = defaultRestartMaxBackoff
+	}
+
+	wait := uint64(initial)
+	for i := uint(0); i < consecutive && wait < uint64(max); i++ {
+		wait *= 2
+	}
+
+	if wait > uint64(max) {
+		wait = uint64(max)
+	}
+
+	return time.Duration(wait) * time.Second
+}
+
+// publish sends the current health of the container to the subscribers,
+// must be called with the lock held
+func (m *healthMonitor) publish(w *healthWatch, reason error) {
+	event := pkg.HealthEvent{
+		Namespace: w.spec.Namespace,
+		ID:        pkg.ContainerID(w.spec.ID),
+		Healthy:   w.healthy,
+		Restarts:  w.restarts,
+		GaveUp:    w.gaveUp,
+		Time:      time.Now(),
+	}
+	if reason != nil {
+		event.Error = reason.Error()
+	}
+
+	for sub := range m.subscribers {
+		select {
+		case sub <- event:
+		default:
+			// slow subscribers lose events rather than blocking the checks
+		}
+	}
+}
+
+// subscribe streams the health events until ctx is canceled
+func (m *healthMonitor) subscribe(ctx context.Context) <-chan pkg.HealthEvent {
+	ch := make(chan pkg.HealthEvent, 16)
+
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// checkNetwork runs the tcp and http checks from inside the network namespace
+// of the container. Entering a namespace needs CAP_SYS_ADMIN which contd
+// doesn't have, the checks are run by the broker
+func checkNetwork(ctx context.Context, broker pkg.Broker, spec healthSpec, check pkg.LivenessCheck) error {
+	switch check.Type {
+	case pkg.LivenessTCP, pkg.LivenessHTTP:
+	default:
+		return fmt.Errorf("unsupported liveness check type '%s'", check.Type)
+	}
+
+	timeout := defaultLivenessTimeout * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(check.Port)))
+	return broker.Probe(spec.NetNS, string(check.Type), addr, check.Path, timeout)
+}
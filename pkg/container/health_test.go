@@ -0,0 +1,124 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := pkg.RestartPolicy{Backoff: 2, MaxBackoff: 10}
+
+	assert.Equal(t, 2*time.Second, backoff(policy, 0))
+	assert.Equal(t, 4*time.Second, backoff(policy, 1))
+	assert.Equal(t, 8*time.Second, backoff(policy, 2))
+	assert.Equal(t, 10*time.Second, backoff(policy, 3))
+	assert.Equal(t, 10*time.Second, backoff(policy, 100))
+
+	assert.Equal(t, time.Second, backoff(pkg.RestartPolicy{}, 0))
+	assert.Equal(t, defaultRestartMaxBackoff*time.Second, backoff(pkg.RestartPolicy{}, 100))
+}
+
+func TestHealthMonitorRestart(t *testing.T) {
+	root, err := ioutil.TempDir("", "health")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	restarted := make(chan time.Duration, 1)
+	monitor := newHealthMonitor(root,
+		func(context.Context, healthSpec, pkg.LivenessCheck) error { return nil },
+		func(ns, id string, backoff time.Duration) error {
+			restarted <- backoff
+			return nil
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := monitor.subscribe(ctx)
+
+	spec := healthSpec{
+		Namespace: "ns",
+		ID:        "web",
+		Liveness: []pkg.LivenessCheck{
+			{Type: pkg.LivenessTCP, Port: 80, FailureThreshold: 2, InitialDelay: 3600},
+		},
+		Restart: pkg.RestartPolicy{MaxRestarts: 1, Backoff: 5},
+	}
+	require.NoError(t, monitor.watch(spec))
+	defer monitor.unwatch("ns", "web")
+
+	monitor.mu.Lock()
+	w := monitor.watches[spec.key()]
+	monitor.mu.Unlock()
+	require.NotNil(t, w)
+
+	failure := fmt.Errorf("connection refused")
+
+	// below the threshold nothing happens
+	monitor.update(w, 0, failure)
+	select {
+	case <-events:
+		t.Fatal("container reported unhealthy before reaching the threshold")
+	default:
+	}
+
+	monitor.update(w, 0, failure)
+	event := <-events
+	assert.False(t, event.Healthy)
+	assert.Equal(t, "connection refused", event.Error)
+	assert.Equal(t, 5*time.Second, <-restarted)
+
+	event = <-events
+	assert.Equal(t, uint(1), event.Restarts)
+
+	// max restarts reached
+	monitor.update(w, 0, failure)
+	monitor.update(w, 0, failure)
+	event = <-events
+	assert.True(t, event.GaveUp)
+	select {
+	case <-restarted:
+		t.Fatal("container restarted after reaching its max restarts")
+	default:
+	}
+
+	monitor.update(w, 0, nil)
+	event = <-events
+	assert.True(t, event.Healthy)
+	assert.False(t, event.GaveUp)
+}
+
+func TestHealthMonitorRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "health")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	noop := func(context.Context, healthSpec, pkg.LivenessCheck) error { return nil }
+	monitor := newHealthMonitor(root, noop, nil)
+	spec := healthSpec{
+		Namespace: "ns",
+		ID:        "web",
+		Liveness:  []pkg.LivenessCheck{{Type: pkg.LivenessTCP, Port: 80, InitialDelay: 3600}},
+	}
+	require.NoError(t, monitor.watch(spec))
+	monitor.stop("ns", "web")
+
+	restored := newHealthMonitor(root, noop, nil)
+	require.NoError(t, restored.restore())
+	assert.Contains(t, restored.watches, spec.key())
+
+	require.NoError(t, restored.unwatch("ns", "web"))
+	assert.Empty(t, restored.watches)
+
+	restored = newHealthMonitor(root, noop, nil)
+	require.NoError(t, restored.restore())
+	assert.Empty(t, restored.watches)
+}
@@ -0,0 +1,132 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/oci"
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/container/logger"
+)
+
+const defaultInitTimeout = 5 * time.Minute
+
+// runInit runs the init steps of the container one after the other. Each
+// step runs in its own short lived container created with the same spec opts as
+// the container itself, so it sees the same root filesystem, mounts and network
+func (c *containerModule) runInit(ctx context.Context, client *containerd.Client, ns string, data pkg.Container, opts []oci.SpecOpts) error {
+	for i, step := range data.Init {
+		if err := step.Valid(); err != nil {
+			return errors.Wrapf(err, "init step %d", i)
+		}
+
+		name := fmt.Sprintf("%s-init-%d", data.Name, i)
+		slog := log.With().Str("namespace", ns).Str("container", data.Name).Int("step", i).Logger()
+
+		slog.Info().Str("command", step.Command).Msg("running init step")
+		err := c.runInitStep(ctx, client, ns, name, step, data.Logs, opts)
+		if err == nil {
+			continue
+		}
+
+		if step.OnFailure == pkg.InitFailureIgnore {
+			slog.Warn().Err(err).Msg("init step failed, ignoring")
+			continue
+		}
+
+		return errors.Wrapf(err, "init step %d (%s) failed", i, step.Command)
+	}
+
+	return nil
+}
+
+func (c *containerModule) runInitStep(ctx context.Context, client *containerd.Client, ns, name string, step pkg.InitStep, logs []logger.Logs, opts []oci.SpecOpts) error {
+	args, err := shlex.Split(step.Command)
+	if err != nil || len(args) == 0 {
+		return fmt.Errorf("invalid init command '%s'", step.Command)
+	}
+
+	// an init container left behind by a crash would prevent the step to run
+	if stale, err := client.LoadContainer(ctx, name); err == nil {
+		if err := stale.Delete(ctx); err != nil {
+			return errors.Wrap(err, "failed to delete previous init container")
+		}
+	}
+
+	opts = append(opts[:len(opts):len(opts)], oci.WithProcessArgs(args...))
+	container, err := client.NewContainer(ctx, name, containerd.WithNewSpec(opts...))
+	if err != nil {
+		return errors.Wrap(err, "failed to create init container")
+	}
+	defer func() {
+		if err := container.Delete(ctx); err != nil {
+			log.Error().Err(err).Str("container", name).Msg("failed to delete init container")
+		}
+	}()
+
+	// the output of the step goes to the logs of the container
+	cfgs := path.Join(c.root, "config", ns)
+	if err := os.MkdirAll(cfgs, 0755); err != nil {
+		return err
+	}
+	confpath := path.Join(cfgs, fmt.Sprintf("%s-logs.json", name))
+	if err := logger.Serialize(confpath, logs); err != nil {
+		return errors.Wrap(err, "failed to write init logs settings")
+	}
+	defer os.Remove(confpath)
+
+	uri, err := url.Parse("binary:///bin/shim-logs")
+	if err != nil {
+		return err
+	}
+
+	task, err := container.NewTask(ctx, cio.LogURI(uri))
+	if err != nil {
+		return errors.Wrap(err, "failed to create init task")
+	}
+	defer func() {
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+			log.Error().Err(err).Str("container", name).Msg("failed to delete init task")
+		}
+	}()
+
+	exitC, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := task.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to start init task")
+	}
+
+	timeout := defaultInitTimeout
+	if step.Timeout != 0 {
+		timeout = time.Duration(step.Timeout) * time.Second
+	}
+
+	select {
+	case status := <-exitC:
+		code, _, err := status.Result()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("exited with code %d", code)
+		}
+		return nil
+	case <-time.After(timeout):
+		_ = task.Kill(ctx, syscall.SIGKILL)
+		<-exitC
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
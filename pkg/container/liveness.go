@@ -0,0 +1,159 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime/restart"
+	"github.com/google/shlex"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// Health implements pkg.ContainerModule
+func (c *containerModule) Health(ctx context.Context) <-chan pkg.HealthEvent {
+	return c.health.subscribe(ctx)
+}
+
+// livenessCheck runs a liveness check of the container
+func (c *containerModule) livenessCheck(ctx context.Context, health healthSpec, check pkg.LivenessCheck) error {
+	if check.Type != pkg.LivenessExec {
+		return checkNetwork(ctx, c.broker, health, check)
+	}
+
+	args, err := shlex.Split(check.Command)
+	if err != nil || len(args) == 0 {
+		return fmt.Errorf("invalid liveness command '%s'", check.Command)
+	}
+
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx = namespaces.WithNamespace(ctx, health.Namespace)
+
+	container, err := client.LoadContainer(ctx, health.ID)
+	if err != nil {
+		return err
+	}
+
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "container is not running")
+	}
+
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return err
+	}
+
+	process := *spec.Process
+	process.Args = args
+	process.Terminal = false
+
+	execID := fmt.Sprintf("liveness-%d", time.Now().UnixNano())
+	exec, err := task.Exec(ctx, execID, &process, cio.NullIO)
+	if err != nil {
+		return errors.Wrap(err, "failed to exec liveness command")
+	}
+	// the check context may be expired already
+	defer exec.Delete(namespaces.WithNamespace(context.Background(), health.Namespace), containerd.WithProcessKill)
+
+	exitC, err := exec.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := exec.Start(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case status := <-exitC:
+		code, _, err := status.Result()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("liveness command exited with code %d", code)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("liveness command timed out")
+	}
+}
+
+// restartTask stops the task of the container, waits backoff, and starts it again
+func (c *containerModule) restartTask(ns, id string, backoff time.Duration) error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// containerd would start the task again right away, without any backoff
+	if err := container.Update(ctx, restart.WithNoRestarts); err != nil {
+		return errors.Wrap(err, "failed to pause containerd restarts")
+	}
+	defer func() {
+		if err := container.Update(ctx, restart.WithStatus(containerd.Running)); err != nil {
+			log.Error().Err(err).Str("container", id).Msg("failed to enable containerd restarts")
+		}
+	}()
+
+	if task, err := container.Task(ctx, nil); err == nil {
+		exitC, err := task.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		c.crashes.expect(ns, id)
+
+		_ = task.Kill(ctx, syscall.SIGTERM)
+		select {
+		case <-exitC:
+		case <-time.After(10 * time.Second):
+			_ = task.Kill(ctx, syscall.SIGKILL)
+			<-exitC
+		}
+
+		if _, err := task.Delete(ctx); err != nil {
+			return errors.Wrap(err, "failed to delete task")
+		}
+	}
+
+	time.Sleep(backoff)
+
+	uri, err := url.Parse("binary:///bin/shim-logs")
+	if err != nil {
+		return err
+	}
+
+	task, err := container.NewTask(ctx, cio.LogURI(uri))
+	if err != nil {
+		return errors.Wrap(err, "failed to create task")
+	}
+
+	if err := task.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to start task")
+	}
+
+	log.Info().Str("namespace", ns).Str("container", id).Msg("container restarted")
+	return nil
+}
@@ -19,7 +19,8 @@ type File struct {
 func NewFile(stdout string, stderr string) (io.Writer, io.Writer, error) {
 	log.Debug().Str("stdout", stdout).Str("stderr", stderr).Msg("initializing localfile logging")
 
-	fo, err := os.Create(stdout)
+	// files are opened in append mode so they can be truncated while in use
+	fo, err := os.OpenFile(stdout, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -28,7 +29,7 @@ func NewFile(stdout string, stderr string) (io.Writer, io.Writer, error) {
 	fe := fo
 
 	if stdout != stderr {
-		fe, err = os.Create(stderr)
+		fe, err = os.OpenFile(stderr, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			return nil, nil, err
 		}
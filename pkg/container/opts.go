@@ -8,8 +8,10 @@ import (
 	"path"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/threefoldtech/zos/pkg"
+	"golang.org/x/sys/unix"
 
 	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/oci"
@@ -124,6 +126,49 @@ func WithCPUCount(cru uint) oci.SpecOpts {
 	}
 }
 
+// withIOLimit throttles the IO of the container on the given disks. The
+// limit applies to each disk, the container can do the allowed IO
+// on all of them at the same time
+func withIOLimit(limit pkg.IOLimit, devices []string) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if limit.IsZero() {
+			return nil
+		}
+
+		if s.Linux.Resources == nil {
+			s.Linux.Resources = &specs.LinuxResources{}
+		}
+		if s.Linux.Resources.BlockIO == nil {
+			s.Linux.Resources.BlockIO = &specs.LinuxBlockIO{}
+		}
+		io := s.Linux.Resources.BlockIO
+
+		for _, device := range devices {
+			var stat unix.Stat_t
+			if err := unix.Stat(device, &stat); err != nil {
+				return errors.Wrapf(err, "failed to stat disk %s", device)
+			}
+
+			major, minor := int64(unix.Major(uint64(stat.Rdev))), int64(unix.Minor(uint64(stat.Rdev)))
+			throttle := func(rules *[]specs.LinuxThrottleDevice, rate uint64) {
+				if rate == 0 {
+					return
+				}
+				rule := specs.LinuxThrottleDevice{Rate: rate}
+				rule.Major, rule.Minor = major, minor
+				*rules = append(*rules, rule)
+			}
+
+			throttle(&io.ThrottleReadIOPSDevice, limit.ReadIOPS)
+			throttle(&io.ThrottleWriteIOPSDevice, limit.WriteIOPS)
+			throttle(&io.ThrottleReadBpsDevice, limit.ReadBandwidth)
+			throttle(&io.ThrottleWriteBpsDevice, limit.WriteBandwidth)
+		}
+
+		return nil
+	}
+}
+
 func cruToLimit(cru uint, totalCPU int) (quota int64, period uint64) {
 	var (
 		required = float64(cru)
@@ -1,6 +1,16 @@
 package container
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+
+	"github.com/containerd/containerd/oci"
+)
 
 func Test_cruToLimit(t *testing.T) {
 	type args struct {
@@ -62,3 +72,72 @@ func Test_cruToLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestWithIOLimit(t *testing.T) {
+	// the character devices are enough to get a major and a minor,
+	// /dev/null is 1:3 and /dev/zero is 1:5
+	device := func(minor int64, rate uint64) specs.LinuxThrottleDevice {
+		rule := specs.LinuxThrottleDevice{Rate: rate}
+		rule.Major, rule.Minor = 1, minor
+		return rule
+	}
+
+	cases := []struct {
+		name     string
+		limit    pkg.IOLimit
+		devices  []string
+		expected *specs.LinuxBlockIO
+	}{
+		{
+			name:     "no limit",
+			limit:    pkg.IOLimit{},
+			devices:  []string{"/dev/null"},
+			expected: nil,
+		},
+		{
+			// a zero field is not limited
+			name:    "reads only",
+			limit:   pkg.IOLimit{ReadIOPS: 100, ReadBandwidth: 1024},
+			devices: []string{"/dev/null"},
+			expected: &specs.LinuxBlockIO{
+				ThrottleReadIOPSDevice: []specs.LinuxThrottleDevice{device(3, 100)},
+				ThrottleReadBpsDevice:  []specs.LinuxThrottleDevice{device(3, 1024)},
+			},
+		},
+		{
+			name:    "per device",
+			limit:   pkg.IOLimit{ReadIOPS: 100, WriteIOPS: 50, ReadBandwidth: 1024, WriteBandwidth: 2048},
+			devices: []string{"/dev/null", "/dev/zero"},
+			expected: &specs.LinuxBlockIO{
+				ThrottleReadIOPSDevice:  []specs.LinuxThrottleDevice{device(3, 100), device(5, 100)},
+				ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{device(3, 50), device(5, 50)},
+				ThrottleReadBpsDevice:   []specs.LinuxThrottleDevice{device(3, 1024), device(5, 1024)},
+				ThrottleWriteBpsDevice:  []specs.LinuxThrottleDevice{device(3, 2048), device(5, 2048)},
+			},
+		},
+		{
+			name:     "no device",
+			limit:    pkg.IOLimit{ReadIOPS: 100},
+			expected: &specs.LinuxBlockIO{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spec := oci.Spec{Linux: &specs.Linux{}}
+			require.NoError(t, withIOLimit(c.limit, c.devices)(context.Background(), nil, nil, &spec))
+
+			if c.expected == nil {
+				assert.Nil(t, spec.Linux.Resources)
+				return
+			}
+
+			require.NotNil(t, spec.Linux.Resources)
+			assert.Equal(t, c.expected, spec.Linux.Resources.BlockIO)
+		})
+	}
+
+	spec := oci.Spec{Linux: &specs.Linux{}}
+	err := withIOLimit(pkg.IOLimit{ReadIOPS: 100}, []string{"/dev/missing"})(context.Background(), nil, nil, &spec)
+	assert.Error(t, err)
+}
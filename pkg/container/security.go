@@ -0,0 +1,296 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/contrib/seccomp"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	kmsgPath = "/dev/kmsg"
+
+	apparmorProfiles = "/sys/kernel/security/apparmor/profiles"
+	selinuxEnforce   = "/sys/fs/selinux/enforce"
+)
+
+// withSecurity sets the seccomp profile and the LSM labels of the container.
+// It must come after the options changing the capabilities of the container
+// since the default seccomp profile allows syscalls depending on them
+func withSecurity(profile pkg.SecurityProfile) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Linux == nil {
+			s.Linux = &specs.Linux{}
+		}
+
+		s.Linux.Seccomp = seccomp.DefaultProfile(s)
+		if profile.KillOnViolation {
+			s.Linux.Seccomp.DefaultAction = specs.ActKill
+		}
+
+		if len(profile.Syscalls) != 0 {
+			s.Linux.Seccomp.Syscalls = append(s.Linux.Seccomp.Syscalls, specs.LinuxSyscall{
+				Names:  profile.Syscalls,
+				Action: specs.ActAllow,
+			})
+		}
+
+		if s.Process == nil {
+			s.Process = &specs.Process{}
+		}
+		s.Process.ApparmorProfile = profile.AppArmor
+		s.Process.SelinuxLabel = profile.SELinux
+
+		return nil
+	}
+}
+
+// checkSecurity checks that the node supports the LSM labels of the profile
+func checkSecurity(profile pkg.SecurityProfile) error {
+	if len(profile.AppArmor) != 0 {
+		f, err := os.Open(apparmorProfiles)
+		if err != nil {
+			return errors.Wrap(err, "AppArmor is not enabled on this node")
+		}
+		defer f.Close()
+
+		loaded, err := apparmorLoaded(f, profile.AppArmor)
+		if err != nil {
+			return errors.Wrap(err, "failed to list the AppArmor profiles")
+		}
+		if !loaded {
+			return fmt.Errorf("AppArmor profile '%s' is not loaded on this node", profile.AppArmor)
+		}
+	}
+
+	if len(profile.SELinux) != 0 {
+		if _, err := os.Stat(selinuxEnforce); err != nil {
+			return fmt.Errorf("SELinux is not enabled on this node")
+		}
+	}
+
+	return nil
+}
+
+// apparmorLoaded checks if profile is in the list of the loaded profiles,
+// one per line followed by its mode: name (enforce)
+func apparmorLoaded(profiles io.Reader, profile string) (bool, error) {
+	scanner := bufio.NewScanner(profiles)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.LastIndex(line, " ("); i >= 0 {
+			line = line[:i]
+		}
+		if line == profile {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+var (
+	auditField    = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+	selinuxDenied = regexp.MustCompile(`avc:\s+denied\s+\{\s*([^}]*?)\s*\}`)
+)
+
+// parseViolation parses an audit message of the kernel log, it returns
+// false if the message is not a violation of a security profile
+func parseViolation(msg string) (v pkg.SecurityViolation, ok bool) {
+	fields := make(map[string]string)
+	for _, m := range auditField.FindAllStringSubmatch(msg, -1) {
+		if _, exists := fields[m[1]]; !exists {
+			fields[m[1]] = strings.Trim(m[2], `"`)
+		}
+	}
+
+	switch {
+	case strings.Contains(msg, "type=1326"):
+		v.Source = pkg.ViolationSeccomp
+		v.Operation = fields["syscall"]
+	case fields["apparmor"] == "DENIED":
+		v.Source = pkg.ViolationAppArmor
+		v.Operation = fields["operation"]
+		if name, ok := fields["name"]; ok {
+			v.Operation = fmt.Sprintf("%s %s", v.Operation, name)
+		}
+	default:
+		m := selinuxDenied.FindStringSubmatch(msg)
+		if m == nil {
+			return v, false
+		}
+		v.Source = pkg.ViolationSELinux
+		v.Operation = fmt.Sprintf("%s %s", fields["tclass"], m[1])
+	}
+
+	pid, err := strconv.Atoi(fields["pid"])
+	if err != nil {
+		return v, false
+	}
+
+	v.Pid = pid
+	v.Command = fields["comm"]
+	return v, true
+}
+
+// violationMonitor reports the violations of the security profiles
+// of the containers to its subscribers
+type violationMonitor struct {
+	mu          sync.Mutex
+	subscribers map[chan pkg.SecurityViolation]struct{}
+}
+
+func newViolationMonitor() *violationMonitor {
+	return &violationMonitor{
+		subscribers: make(map[chan pkg.SecurityViolation]struct{}),
+	}
+}
+
+func (m *violationMonitor) publish(v pkg.SecurityViolation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for sub := range m.subscribers {
+		select {
+		case sub <- v:
+		default:
+			// slow subscribers lose events rather than blocking the kernel log
+		}
+	}
+}
+
+// subscribe streams the violations until ctx is canceled
+func (m *violationMonitor) subscribe(ctx context.Context) <-chan pkg.SecurityViolation {
+	ch := make(chan pkg.SecurityViolation, 16)
+
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// Violations implements pkg.ContainerModule
+func (c *containerModule) Violations(ctx context.Context) <-chan pkg.SecurityViolation {
+	return c.violations.subscribe(ctx)
+}
+
+// watchViolations follows the kernel log for the violations of the security
+// profiles. A violation is only reported if its process still runs when
+// the message is read, since the container is found from its cgroup
+func (c *containerModule) watchViolations(ctx context.Context) {
+	for {
+		err := c.readKmsg(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error().Err(err).Msg("failed to read kernel log, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *containerModule) readKmsg(ctx context.Context) error {
+	kmsg, err := os.Open(kmsgPath)
+	if err != nil {
+		return err
+	}
+
+	// closing the file stops the blocked read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		kmsg.Close()
+	}()
+
+	// only the new messages are of interest
+	if _, err := kmsg.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	// every read returns a single record: prio,seq,time,flags;message
+	buf := make([]byte, 8192)
+	for {
+		n, err := kmsg.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// EPIPE means records were overwritten before being read
+			if errors.Is(err, syscall.EPIPE) {
+				continue
+			}
+			return err
+		}
+
+		record := string(buf[:n])
+		i := strings.Index(record, ";")
+		if i < 0 {
+			continue
+		}
+
+		v, ok := parseViolation(strings.TrimSpace(record[i+1:]))
+		if !ok {
+			continue
+		}
+
+		cgroup, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", v.Pid))
+		if err != nil {
+			log.Warn().Int("pid", v.Pid).Str("source", string(v.Source)).Msg("security violation of an exited process")
+			continue
+		}
+
+		ns, id, err := containerOf(bytes.NewReader(cgroup))
+		if err != nil {
+			continue
+		}
+
+		v.Namespace = ns
+		v.ID = pkg.ContainerID(id)
+		v.Time = time.Now()
+
+		log.Warn().
+			Str("namespace", ns).
+			Str("container", id).
+			Str("source", string(v.Source)).
+			Str("command", v.Command).
+			Str("operation", v.Operation).
+			Msg("security violation")
+
+		c.violations.publish(v)
+	}
+}
@@ -0,0 +1,81 @@
+package container
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestParseViolation(t *testing.T) {
+	cases := []struct {
+		msg      string
+		expected pkg.SecurityViolation
+	}{
+		{
+			`audit: type=1326 audit(1589209386.614:35): auid=4294967295 uid=0 gid=0 ses=4294967295 pid=4242 comm="unshare" exe="/usr/bin/unshare" sig=31 arch=c000003e syscall=272 compat=0 ip=0x7f code=0x0`,
+			pkg.SecurityViolation{Source: pkg.ViolationSeccomp, Pid: 4242, Command: "unshare", Operation: "272"},
+		},
+		{
+			`audit: type=1400 audit(1589209386.614:36): apparmor="DENIED" operation="open" profile="web" name="/etc/shadow" pid=4243 comm="cat" requested_mask="r" denied_mask="r" fsuid=0 ouid=0`,
+			pkg.SecurityViolation{Source: pkg.ViolationAppArmor, Pid: 4243, Command: "cat", Operation: "open /etc/shadow"},
+		},
+		{
+			`audit: type=1400 audit(1589209386.614:37): avc:  denied  { read } for  pid=4244 comm="cat" name="shadow" dev="sda1" ino=1234 scontext=system_u:system_r:container_t:s0 tcontext=system_u:object_r:shadow_t:s0 tclass=file permissive=0`,
+			pkg.SecurityViolation{Source: pkg.ViolationSELinux, Pid: 4244, Command: "cat", Operation: "file read"},
+		},
+	}
+
+	for _, c := range cases {
+		v, ok := parseViolation(c.msg)
+		require.True(t, ok, c.msg)
+		assert.Equal(t, c.expected, v)
+	}
+
+	_, ok := parseViolation(`audit: type=1400 audit(1589209386.614:38): apparmor="STATUS" operation="profile_load" profile="unconfined" name="web" pid=12 comm="apparmor_parser"`)
+	assert.False(t, ok)
+	_, ok = parseViolation(`eth0: link becomes ready`)
+	assert.False(t, ok)
+}
+
+func TestAppArmorLoaded(t *testing.T) {
+	profiles := "docker-default (enforce)\n/usr/bin/man (complain)\n"
+
+	loaded, err := apparmorLoaded(strings.NewReader(profiles), "docker-default")
+	require.NoError(t, err)
+	assert.True(t, loaded)
+
+	loaded, err = apparmorLoaded(strings.NewReader(profiles), "/usr/bin/man")
+	require.NoError(t, err)
+	assert.True(t, loaded)
+
+	loaded, err = apparmorLoaded(strings.NewReader(profiles), "docker")
+	require.NoError(t, err)
+	assert.False(t, loaded)
+}
+
+func TestWithSecurity(t *testing.T) {
+	var spec specs.Spec
+	err := withSecurity(pkg.SecurityProfile{
+		Syscalls:        []string{"personality"},
+		KillOnViolation: true,
+		AppArmor:        "web",
+	})(context.Background(), nil, nil, &spec)
+	require.NoError(t, err)
+
+	require.NotNil(t, spec.Linux.Seccomp)
+	assert.Equal(t, specs.ActKill, spec.Linux.Seccomp.DefaultAction)
+	last := spec.Linux.Seccomp.Syscalls[len(spec.Linux.Seccomp.Syscalls)-1]
+	assert.Equal(t, []string{"personality"}, last.Names)
+	assert.Equal(t, specs.ActAllow, last.Action)
+	assert.Equal(t, "web", spec.Process.ApparmorProfile)
+
+	spec = specs.Spec{}
+	err = withSecurity(pkg.SecurityProfile{})(context.Background(), nil, nil, &spec)
+	require.NoError(t, err)
+	assert.Equal(t, specs.ActErrno, spec.Linux.Seccomp.DefaultAction)
+}
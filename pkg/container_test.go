@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitStepValid(t *testing.T) {
+	assert.NoError(t, InitStep{Command: "/bin/migrate up"}.Valid())
+	assert.NoError(t, InitStep{Command: "render-config", Timeout: 10, OnFailure: InitFailureIgnore}.Valid())
+
+	assert.Error(t, InitStep{Command: "  "}.Valid())
+	assert.Error(t, InitStep{Command: "migrate", OnFailure: "retry"}.Valid())
+}
+
+func TestLivenessCheckValid(t *testing.T) {
+	assert.NoError(t, LivenessCheck{Type: LivenessExec, Command: "pg_isready"}.Valid())
+	assert.NoError(t, LivenessCheck{Type: LivenessTCP, Port: 5432}.Valid())
+	assert.NoError(t, LivenessCheck{Type: LivenessHTTP, Port: 80, Path: "/health"}.Valid())
+
+	assert.Error(t, LivenessCheck{Type: LivenessExec}.Valid())
+	assert.Error(t, LivenessCheck{Type: LivenessHTTP}.Valid())
+	assert.Error(t, LivenessCheck{Type: "grpc", Port: 80}.Valid())
+}
+
+func TestSecurityProfileValid(t *testing.T) {
+	assert.NoError(t, SecurityProfile{}.Valid())
+	assert.NoError(t, SecurityProfile{Syscalls: []string{"personality", "io_uring_setup"}, KillOnViolation: true}.Valid())
+	assert.NoError(t, SecurityProfile{AppArmor: "docker-default"}.Valid())
+	assert.NoError(t, SecurityProfile{SELinux: "system_u:system_r:container_t:s0:c1,c2"}.Valid())
+
+	assert.Error(t, SecurityProfile{Syscalls: []string{"kexec_load"}}.Valid())
+	assert.Error(t, SecurityProfile{Syscalls: []string{"read write"}}.Valid())
+	assert.Error(t, SecurityProfile{AppArmor: "../profile"}.Valid())
+	assert.Error(t, SecurityProfile{SELinux: "container_t"}.Valid())
+	assert.Error(t, SecurityProfile{AppArmor: "docker-default", SELinux: "system_u:system_r:container_t:s0"}.Valid())
+}
@@ -0,0 +1,128 @@
+// Package critical tracks the critical operations running in the modules,
+// like a workload being provisioned or a VM being migrated. The upgrade
+// defers the restart of a module while it runs a critical operation.
+//
+// Every running operation is a file under the root directory, so the
+// operations of all the modules are seen by the upgrade without a call to
+// each module. The files of a crashed module are ignored.
+package critical
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// DefaultRoot is the directory of the running operations, it's on a tmpfs
+// so nothing is left after a reboot
+const DefaultRoot = "/var/run/critical"
+
+// Tracker records the critical operations of a module
+type Tracker struct {
+	root   string
+	module string
+	seq    uint64
+}
+
+// New creates a tracker for module, module is the name of its service
+func New(root, module string) *Tracker {
+	return &Tracker{root: filepath.Join(root, module), module: module}
+}
+
+// Begin records the start of a critical operation, the returned function
+// must be called once it's done. A nil Tracker does nothing
+func (t *Tracker) Begin(operation string) (done func()) {
+	if t == nil {
+		return func() {}
+	}
+
+	op := pkg.CriticalOperation{
+		Module:    t.module,
+		Operation: operation,
+		Since:     time.Now(),
+		PID:       os.Getpid(),
+	}
+
+	path := filepath.Join(t.root, fmt.Sprintf("%d-%d.json", op.PID, atomic.AddUint64(&t.seq, 1)))
+	if err := write(path, &op); err != nil {
+		// failing to track the operation only exposes it to an upgrade
+		log.Error().Err(err).Str("operation", operation).Msg("failed to record critical operation")
+		return func() {}
+	}
+
+	return func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Str("operation", operation).Msg("failed to clear critical operation")
+		}
+	}
+}
+
+func write(path string, op *pkg.CriticalOperation) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	// the file is renamed in place so it's never read half written
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// alive checks if the process pid is running
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// List returns the critical operations running in all the modules, oldest
+// first. The operations of the processes that exited are removed
+func List(root string) ([]pkg.CriticalOperation, error) {
+	files, err := filepath.Glob(filepath.Join(root, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []pkg.CriticalOperation
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			// done meanwhile
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to read critical operation '%s'", file)
+		}
+
+		var op pkg.CriticalOperation
+		if err := json.Unmarshal(data, &op); err != nil || !alive(op.PID) {
+			log.Debug().Str("file", file).Msg("removing stale critical operation")
+			os.Remove(file)
+			continue
+		}
+
+		ops = append(ops, op)
+	}
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Since.Before(ops[j].Since)
+	})
+
+	return ops, nil
+}
@@ -0,0 +1,67 @@
+package critical
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	root, err := ioutil.TempDir("", "critical")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	ops, err := List(root)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	provisiond := New(root, "provisiond")
+	done1 := provisiond.Begin("provision 1-1")
+	done2 := provisiond.Begin("provision 2-1")
+	New(root, "vmd").Begin("migrate vm")()
+
+	ops, err = List(root)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, "provisiond", ops[0].Module)
+	assert.Equal(t, "provision 1-1", ops[0].Operation)
+	assert.Equal(t, os.Getpid(), ops[0].PID)
+	assert.Equal(t, "provision 2-1", ops[1].Operation)
+
+	done1()
+	done2()
+	ops, err = List(root)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	var nilTracker *Tracker
+	nilTracker.Begin("nothing")()
+}
+
+func TestListStale(t *testing.T) {
+	root, err := ioutil.TempDir("", "critical")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "provisiond")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	// pid of a process that exited
+	stale := filepath.Join(dir, "1-1.json")
+	require.NoError(t, ioutil.WriteFile(stale, []byte(`{"module": "provisiond", "operation": "provision", "pid": 2147483647}`), 0644))
+	broken := filepath.Join(dir, "1-2.json")
+	require.NoError(t, ioutil.WriteFile(broken, []byte(`{`), 0644))
+
+	ops, err := List(root)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(broken)
+	assert.True(t, os.IsNotExist(err))
+}
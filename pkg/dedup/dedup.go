@@ -0,0 +1,158 @@
+// Package dedup implements a small request deduplication cache that modules
+// use to make their mutating APIs idempotent.
+//
+// Each request is about a resource (a network, a volume) and is identified
+// by a key: the request ID given by the caller, or the hash of the request
+// arguments if the caller didn't give one. If a request with the same key is
+// already running on the resource, the caller waits for it and gets the same
+// result. If it completed successfully less than TTL ago, the original result
+// is returned without executing the request again. Failed requests are never
+// cached so they can be retried.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type call struct {
+	done   chan struct{}
+	value  interface{}
+	err    error
+	expire time.Time
+}
+
+// Cache is a request deduplication cache
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+	// calls of each resource, by request key
+	calls map[string]map[string]*call
+}
+
+// New creates a new deduplication cache that remembers
+// successful results for ttl
+func New(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:   ttl,
+		now:   time.Now,
+		calls: make(map[string]map[string]*call),
+	}
+}
+
+// RequestID computes a request ID from the request arguments. Two requests
+// with the same arguments have the same ID
+func RequestID(args ...interface{}) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, arg := range args {
+		if err := enc.Encode(arg); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Key returns the key of a request. It's the request ID given by the caller,
+// so two distinct requests with the same arguments are both executed. The
+// requests without ID fall back to the hash of their arguments
+func Key(requestID string, args ...interface{}) (string, error) {
+	if requestID != "" {
+		return "id:" + requestID, nil
+	}
+
+	hash, err := RequestID(args...)
+	if err != nil {
+		return "", err
+	}
+
+	return "args:" + hash, nil
+}
+
+// Do executes fn if no request with the same key is running or has recently
+// succeeded on resource. replayed is true if the returned value comes from
+// a previous execution of the request
+func (c *Cache) Do(resource, key string, fn func() (interface{}, error)) (value interface{}, replayed bool, err error) {
+	c.mu.Lock()
+	c.evict()
+
+	calls, ok := c.calls[resource]
+	if !ok {
+		calls = make(map[string]*call)
+		c.calls[resource] = calls
+	}
+
+	if cl, ok := calls[key]; ok {
+		c.mu.Unlock()
+		<-cl.done
+		return cl.value, true, cl.err
+	}
+
+	cl := &call{done: make(chan struct{})}
+	calls[key] = cl
+	c.mu.Unlock()
+
+	cl.value, cl.err = fn()
+
+	c.mu.Lock()
+	if cl.err != nil {
+		c.remove(resource, key, cl)
+	} else {
+		cl.expire = c.now().Add(c.ttl)
+	}
+	c.mu.Unlock()
+
+	close(cl.done)
+
+	return cl.value, false, cl.err
+}
+
+// Forget removes all the requests on resource from the cache. It is useful
+// when a resource is changed or deleted and all the requests that created
+// it must be executed again
+func (c *Cache) Forget(resource string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.calls, resource)
+}
+
+// remove removes the call of key on resource if it's still cl, the resource
+// can have been forgotten while the call was running
+func (c *Cache) remove(resource, key string, cl *call) {
+	calls, ok := c.calls[resource]
+	if !ok || calls[key] != cl {
+		return
+	}
+
+	delete(calls, key)
+	if len(calls) == 0 {
+		delete(c.calls, resource)
+	}
+}
+
+func (c *Cache) evict() {
+	now := c.now()
+	for resource, calls := range c.calls {
+		for key, cl := range calls {
+			if cl.expire.IsZero() {
+				// still running
+				continue
+			}
+
+			if now.After(cl.expire) {
+				delete(calls, key)
+			}
+		}
+
+		if len(calls) == 0 {
+			delete(c.calls, resource)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package dedup
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	a, err := RequestID("ns", 10)
+	require.NoError(t, err)
+	b, err := RequestID("ns", 10)
+	require.NoError(t, err)
+	c, err := RequestID("ns", 11)
+	require.NoError(t, err)
+
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+}
+
+func TestDoReplay(t *testing.T) {
+	c := New(time.Minute)
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	v, replayed, err := c.Do("res", "id", fn)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 1, v)
+
+	v, replayed, err = c.Do("res", "id", fn)
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 1, calls)
+
+	c.Forget("res")
+	v, _, err = c.Do("res", "id", fn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, v)
+}
+
+func TestDoErrorNotCached(t *testing.T) {
+	c := New(time.Minute)
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("failed")
+	}
+
+	_, _, err := c.Do("res", "id", fn)
+	require.Error(t, err)
+	_, replayed, err := c.Do("res", "id", fn)
+	require.Error(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 2, calls)
+}
+
+func TestDoExpire(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+
+	fn := func() (interface{}, error) { return "value", nil }
+	_, _, err := c.Do("res", "id", fn)
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, replayed, err := c.Do("res", "id", fn)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+}
+
+func TestDoConcurrent(t *testing.T) {
+	c := New(time.Minute)
+
+	var (
+		mu    sync.Mutex
+		calls int
+		wg    sync.WaitGroup
+	)
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return "value", nil
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := c.Do("res", "id", fn)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", v)
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestKey(t *testing.T) {
+	a, err := Key("", "ns", 10)
+	require.NoError(t, err)
+	b, err := Key("", "ns", 10)
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	// the requests with an ID are told apart by it, whatever their arguments
+	c, err := Key("request-1", "ns", 10)
+	require.NoError(t, err)
+	d, err := Key("request-2", "ns", 10)
+	require.NoError(t, err)
+	assert.NotEqual(t, c, d)
+	assert.NotEqual(t, a, c)
+
+	// a request ID can't collide with the hash of the arguments
+	hash, err := RequestID("ns", 10)
+	require.NoError(t, err)
+	e, err := Key(hash)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, e)
+}
+
+func TestDoDistinctRequests(t *testing.T) {
+	c := New(time.Minute)
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	first, err := Key("request-1", "ns", 10)
+	require.NoError(t, err)
+	second, err := Key("request-2", "ns", 10)
+	require.NoError(t, err)
+
+	v, replayed, err := c.Do("res", first, fn)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 1, v)
+
+	v, replayed, err = c.Do("res", second, fn)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 2, v)
+
+	v, replayed, err = c.Do("res", first, fn)
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, 1, v)
+}
+
+func TestForget(t *testing.T) {
+	c := New(time.Minute)
+
+	fn := func() (interface{}, error) { return "value", nil }
+	for _, r := range []struct{ resource, key string }{{"net1", "a"}, {"net1", "b"}, {"net10", "a"}} {
+		_, _, err := c.Do(r.resource, r.key, fn)
+		require.NoError(t, err)
+	}
+
+	// the requests of a resource are forgotten, not the ones of a
+	// resource with a longer name
+	c.Forget("net1")
+	assert.Len(t, c.calls, 1)
+	assert.Contains(t, c.calls, "net10")
+
+	_, replayed, err := c.Do("net1", "a", fn)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+}
@@ -0,0 +1,47 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module network -version 0.0.1 -name dns -package stubs github.com/threefoldtech/zos/pkg+DNSCache stubs/dns_cache_stub.go
+
+import (
+	"context"
+	"time"
+)
+
+// DNSStats are the statistics of the DNS cache of the node, the counters
+// are cumulative since the cache started
+type DNSStats struct {
+	Queries     uint64 `json:"queries"`
+	CacheHits   uint64 `json:"cache_hits"`
+	CacheMisses uint64 `json:"cache_misses"`
+	Prefetches  uint64 `json:"prefetches"`
+	// Secure is the number of answers validated with DNSSEC
+	Secure uint64 `json:"secure"`
+	// Bogus is the number of answers that failed the DNSSEC validation,
+	// they are answered with SERVFAIL
+	Bogus    uint64 `json:"bogus"`
+	ServFail uint64 `json:"servfail"`
+	// RecursionTime is the average time in seconds to answer a query that
+	// is not in the cache
+	RecursionTime float64 `json:"recursion_time"`
+	// Records is the number of records in the cache
+	Records uint64    `json:"records"`
+	Time    time.Time `json:"time"`
+	// Error is why the cache is not answering, the counters are not
+	// set while it's not answering
+	Error string `json:"error,omitempty"`
+	// Fallback is set while the node resolves through the resolvers
+	// received with DHCP, without validation
+	Fallback bool `json:"fallback"`
+}
+
+// DNSCache is the caching validating resolver of the node (provided by networkd)
+type DNSCache interface {
+	// Stats returns the statistics of the cache
+	Stats() (DNSStats, error)
+	// Flush removes zone and all the names under it from the cache, the
+	// whole cache is flushed if zone is empty
+	Flush(zone string) error
+	// Monitor streams the statistics of the cache
+	Monitor(ctx context.Context) <-chan DNSStats
+}
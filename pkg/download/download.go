@@ -0,0 +1,434 @@
+// Package download is the HTTP(S) download manager shared by the modules.
+// It downloads from the mirrors set by the farmer before the original
+// location, resumes the interrupted downloads with range requests, checks
+// the checksum of the downloaded files, caps the bandwidth used by the
+// downloads of the module and reports their progress.
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+// the kernel parameters configuring the downloads
+const (
+	// rateParam is the bandwidth cap of the downloads in KiB/s
+	rateParam = "download-rate"
+	// mirrorParam is a mirror of a location, as <location>,<mirror>
+	mirrorParam = "download-mirror"
+)
+
+// Config is the configuration of the downloads set by the farmer
+type Config struct {
+	// Rate is the max bandwidth of the downloads of a module in bytes
+	// per second, 0 means no limit
+	Rate int64
+	// Mirrors maps a location (the start of a url) to its mirrors, they
+	// are tried in order before the location itself
+	Mirrors map[string][]string
+}
+
+// ConfigFromParams reads the download configuration from the kernel
+// parameters. download-rate=<KiB/s> caps the bandwidth of the downloads,
+// download-mirror=<location>,<mirror> downloads the files under location
+// from mirror first, it can be set more than once
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{Mirrors: make(map[string][]string)}
+
+	if values, ok := params.Get(rateParam); ok && len(values) > 0 && values[0] != "" {
+		rate, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil || rate < 0 {
+			return config, fmt.Errorf("invalid download rate '%s', it must be in KiB/s", values[0])
+		}
+		config.Rate = rate * 1024
+	}
+
+	values, _ := params.Get(mirrorParam)
+	for _, value := range values {
+		parts := strings.Split(value, ",")
+		if len(parts) != 2 || !isHTTP(parts[0]) || !isHTTP(parts[1]) {
+			return config, fmt.Errorf("invalid download mirror '%s', it must be <location>,<mirror>", value)
+		}
+		config.Mirrors[parts[0]] = append(config.Mirrors[parts[0]], parts[1])
+	}
+
+	return config, nil
+}
+
+func isHTTP(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
+// Progress is the progress of a download
+type Progress struct {
+	URL string
+	// Source is the url the file is downloaded from, the url or one of
+	// its mirrors
+	Source     string
+	Downloaded int64
+	// Size is the size of the file, 0 if it's not known yet
+	Size int64
+}
+
+// Request is a file to download
+type Request struct {
+	URL string
+	// Checksum is the hex checksum of the file, it's not checked if empty
+	Checksum string
+	// Hash is the hash of the checksum, sha256 if nil
+	Hash func() hash.Hash
+	// Progress is called as the file is downloaded
+	Progress func(Progress)
+}
+
+// Manager downloads files, it's safe for concurrent use and the bandwidth
+// cap is shared by all its downloads
+type Manager struct {
+	client  *http.Client
+	config  Config
+	limiter *limiter
+}
+
+// New creates a download manager
+func New(config Config) *Manager {
+	var l *limiter
+	if config.Rate > 0 {
+		l = newLimiter(config.Rate)
+	}
+
+	return &Manager{
+		client:  &http.Client{},
+		config:  config,
+		limiter: l,
+	}
+}
+
+var (
+	defaultManager *Manager
+	defaultOnce    sync.Once
+)
+
+// Default returns the download manager of the module, configured from the
+// kernel parameters
+func Default() *Manager {
+	defaultOnce.Do(func() {
+		config, err := ConfigFromParams(kernel.GetParams())
+		if err != nil {
+			log.Error().Err(err).Msg("invalid download configuration, downloading without mirrors and limit")
+			config = Config{}
+		}
+		defaultManager = New(config)
+	})
+
+	return defaultManager
+}
+
+// sources returns the mirrors of u followed by u
+func (m *Manager) sources(u string) []string {
+	var locations []string
+	for location := range m.config.Mirrors {
+		if strings.HasPrefix(u, location) {
+			locations = append(locations, location)
+		}
+	}
+	sort.Strings(locations)
+
+	var sources []string
+	for _, location := range locations {
+		for _, mirror := range m.config.Mirrors[location] {
+			sources = append(sources, mirror+strings.TrimPrefix(u, location))
+		}
+	}
+
+	return append(sources, u)
+}
+
+func (m *Manager) body(r io.ReadCloser) io.ReadCloser {
+	if m.limiter == nil {
+		return r
+	}
+
+	return &limitedReader{ReadCloser: r, limiter: m.limiter}
+}
+
+// Get gets u from its mirrors or from u, the body of the response is
+// limited by the bandwidth cap. The response is returned only if its
+// status is 200, the caller must close its body
+func (m *Manager) Get(ctx context.Context, u string) (*http.Response, error) {
+	var errs []string
+	for _, source := range m.sources(u) {
+		request, err := http.NewRequest(http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := m.client.Do(request.WithContext(ctx))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			errs = append(errs, fmt.Sprintf("%s: %s", source, response.Status))
+			continue
+		}
+
+		response.Body = m.body(response.Body)
+		return response, nil
+	}
+
+	return nil, fmt.Errorf("failed to get '%s': %s", u, strings.Join(errs, ", "))
+}
+
+// Download downloads the file of the request to path. The content already
+// at path is kept and the rest of the file is requested, so a download
+// that failed is resumed by downloading to the same path again. The
+// download is retried from the next mirror when a source fails, path is
+// removed if the file doesn't match the checksum
+func (m *Manager) Download(ctx context.Context, path string, request Request) error {
+	var expected []byte
+	if len(request.Checksum) != 0 {
+		var err error
+		if expected, err = hex.DecodeString(request.Checksum); err != nil {
+			return fmt.Errorf("invalid checksum '%s'", request.Checksum)
+		}
+	}
+
+	sources := m.sources(request.URL)
+	attempt := 0
+	download := func() error {
+		source := sources[attempt%len(sources)]
+		attempt++
+
+		err := m.downloadPart(ctx, source, path, request)
+		if err == nil {
+			return nil
+		}
+
+		if status, ok := err.(statusError); ok && status < http.StatusInternalServerError && source == request.URL {
+			// the mirrors are tried before the url, so the file
+			// can't be found anywhere
+			return backoff.Permanent(err)
+		}
+
+		log.Error().Err(err).Str("url", request.URL).Str("source", source).Msg("download failed, resuming")
+		return err
+	}
+
+	bo := backoff.WithContext(backoff.NewExponentialBackOff(), ctx)
+	if err := backoff.Retry(download, bo); err != nil {
+		return errors.Wrapf(err, "failed to download '%s'", request.URL)
+	}
+
+	if expected == nil {
+		return nil
+	}
+
+	if err := verify(path, request.Hash, expected); err != nil {
+		// the file is corrupted, it must be downloaded from scratch
+		_ = os.Remove(path)
+		return errors.Wrapf(err, "failed to download '%s'", request.URL)
+	}
+
+	return nil
+}
+
+// downloadPart downloads the part of the file that is not yet at path.
+// The source must support range requests to resume the download,
+// otherwise it restarts from the beginning
+func (m *Manager) downloadPart(ctx context.Context, source, path string, request Request) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	response, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	progress := Progress{URL: request.URL, Source: source}
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		progress.Size = offset + response.ContentLength
+	case http.StatusOK:
+		// the range is ignored, start over
+		if err := file.Truncate(0); err != nil {
+			return backoff.Permanent(err)
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return backoff.Permanent(err)
+		}
+		progress.Size = response.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file is already complete
+		progress.Downloaded = offset
+		progress.Size = offset
+		request.report(progress)
+		return nil
+	default:
+		return statusError(response.StatusCode)
+	}
+
+	if progress.Size < offset {
+		// unknown length
+		progress.Size = 0
+	}
+
+	progress.Downloaded = offset
+	request.report(progress)
+
+	w := &progressWriter{w: file, request: request, progress: progress}
+	if _, err := io.Copy(w, m.body(response.Body)); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// statusError is an unexpected status of a response
+type statusError int
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %d %s", int(s), http.StatusText(int(s)))
+}
+
+func (r *Request) report(progress Progress) {
+	if r.Progress != nil {
+		r.Progress(progress)
+	}
+}
+
+// progressWriter reports the progress of a download as it's written
+type progressWriter struct {
+	w        io.Writer
+	request  Request
+	progress Progress
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.progress.Downloaded += int64(n)
+	p.request.report(p.progress)
+	return n, err
+}
+
+func verify(path string, h func() hash.Hash, expected []byte) error {
+	if h == nil {
+		h = sha256.New
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := h()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if sum := hash.Sum(nil); !bytes.Equal(sum, expected) {
+		return fmt.Errorf("checksum mismatch, expected %x got %x", expected, sum)
+	}
+
+	return nil
+}
+
+// limiter is a token bucket shared by the downloads of a manager
+type limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens int64
+	last   time.Time
+}
+
+func newLimiter(rate int64) *limiter {
+	return &limiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take waits for at least one token and takes up to n tokens, it returns
+// the number of tokens taken
+func (l *limiter) take(n int64) int64 {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if credit := int64(now.Sub(l.last).Seconds() * float64(l.rate)); credit > 0 {
+			l.tokens += credit
+			l.last = now
+		}
+		if l.tokens > l.rate {
+			// at most a second of burst
+			l.tokens = l.rate
+		}
+
+		if l.tokens > 0 {
+			if n > l.tokens {
+				n = l.tokens
+			}
+			l.tokens -= n
+			l.mu.Unlock()
+			return n
+		}
+
+		l.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// put gives back the tokens that were not used
+func (l *limiter) put(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += n
+}
+
+// limitedReader reads at the rate of the limiter
+type limitedReader struct {
+	io.ReadCloser
+	limiter *limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	taken := r.limiter.take(int64(len(p)))
+	n, err := r.ReadCloser.Read(p[:taken])
+	if int64(n) < taken {
+		r.limiter.put(taken - int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,168 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	require := require.New(t)
+
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(err)
+	require.EqualValues(0, config.Rate)
+	require.Empty(config.Mirrors)
+
+	config, err = ConfigFromParams(kernel.Params{
+		"download-rate": {"512"},
+		"download-mirror": {
+			"https://hub.grid.tf/,http://10.0.0.1/hub/",
+			"https://hub.grid.tf/,http://10.0.0.2/hub/",
+		},
+	})
+	require.NoError(err)
+	require.EqualValues(512*1024, config.Rate)
+	require.Equal([]string{"http://10.0.0.1/hub/", "http://10.0.0.2/hub/"}, config.Mirrors["https://hub.grid.tf/"])
+
+	_, err = ConfigFromParams(kernel.Params{"download-rate": {"1M"}})
+	require.Error(err)
+
+	_, err = ConfigFromParams(kernel.Params{"download-mirror": {"https://hub.grid.tf/"}})
+	require.Error(err)
+}
+
+func TestSources(t *testing.T) {
+	m := New(Config{Mirrors: map[string][]string{
+		"https://hub.grid.tf/": {"http://10.0.0.1/hub/"},
+		"https://example.com/": {"http://10.0.0.2/"},
+	}})
+
+	require.Equal(t, []string{
+		"http://10.0.0.1/hub/tf-zos/zos.flist",
+		"https://hub.grid.tf/tf-zos/zos.flist",
+	}, m.sources("https://hub.grid.tf/tf-zos/zos.flist"))
+}
+
+// server serves data with range requests, it fails the first fail requests
+// after sending half of the data
+func server(data []byte, fail int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail > 0 {
+			fail--
+			w.Header().Set("Content-Length", "1000000")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data[:len(data)/2])
+			return
+		}
+
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func TestDownload(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "download-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(data)
+
+	srv := server(data, 1)
+	defer srv.Close()
+
+	var last Progress
+	path := filepath.Join(root, "file")
+	err = New(Config{}).Download(context.Background(), path, Request{
+		URL:      srv.URL + "/file",
+		Checksum: hex.EncodeToString(sum[:]),
+		Progress: func(p Progress) { last = p },
+	})
+	require.NoError(err)
+
+	downloaded, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal(data, downloaded)
+	require.EqualValues(len(data), last.Downloaded)
+	require.EqualValues(len(data), last.Size)
+
+	// complete files are not downloaded again
+	err = New(Config{}).Download(context.Background(), path, Request{URL: srv.URL + "/file"})
+	require.NoError(err)
+
+	// corrupted files are removed
+	require.NoError(ioutil.WriteFile(path, []byte("corrupted"), 0644))
+	err = New(Config{}).Download(context.Background(), path, Request{
+		URL:      srv.URL + "/file",
+		Checksum: hex.EncodeToString(sum[:]),
+	})
+	require.Error(err)
+	require.NoFileExists(path)
+}
+
+func TestDownloadMirror(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "download-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	data := []byte("flist")
+	origin := httptest.NewServer(http.NotFoundHandler())
+	defer origin.Close()
+
+	mirror := server(data, 0)
+	defer mirror.Close()
+
+	m := New(Config{Mirrors: map[string][]string{origin.URL: {mirror.URL}}})
+
+	path := filepath.Join(root, "file")
+	var source string
+	err = m.Download(context.Background(), path, Request{
+		URL:      origin.URL + "/file",
+		Progress: func(p Progress) { source = p.Source },
+	})
+	require.NoError(err)
+	require.Equal(mirror.URL+"/file", source)
+
+	response, err := m.Get(context.Background(), origin.URL+"/file")
+	require.NoError(err)
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(err)
+	require.Equal(data, body)
+
+	// not found anywhere
+	m = New(Config{})
+	err = m.Download(context.Background(), filepath.Join(root, "missing"), Request{URL: origin.URL + "/file"})
+	require.Error(err)
+}
+
+func TestLimiter(t *testing.T) {
+	require := require.New(t)
+
+	l := newLimiter(1000)
+	start := time.Now()
+
+	var total int64
+	for total < 1500 {
+		total += l.take(500)
+	}
+
+	// the first second is the burst
+	require.True(time.Since(start) >= 400*time.Millisecond)
+}
@@ -0,0 +1,32 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module identityd -version 0.0.1 -name features -package stubs github.com/threefoldtech/zos/pkg+FeatureFlags stubs/feature_flags_stub.go
+
+// Feature is the state of a feature flag on the node
+type Feature struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// Source tells why the feature is enabled or disabled: node if it's set
+	// on the node, kernel if it's set on the kernel command line, farm if
+	// the node is part of the rollout of the feature in its farm, channel
+	// for the default of the upgrade channel of the node
+	Source string `json:"source"`
+}
+
+// FeatureFlags gives the state of the feature flags of the node, the new
+// behaviours of the modules are enabled gradually with them (provided by
+// identityd)
+type FeatureFlags interface {
+	// Enabled returns true if the feature is enabled on the node
+	Enabled(name string) (bool, error)
+	// Features returns the state of all the feature flags of the node
+	Features() ([]Feature, error)
+	// Set enables or disables the feature on this node, it overrides all
+	// the other sources of the feature. The modules read most features
+	// when they start, they must be restarted to apply the change
+	Set(name string, enabled bool) error
+	// Reset removes the state of the feature set on this node
+	Reset(name string) error
+}
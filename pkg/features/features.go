@@ -0,0 +1,317 @@
+// Package features implements the feature flags of the node. The risky new
+// behaviours of the modules are behind a flag, so they can be rolled out
+// gradually to the nodes and turned off without a new release.
+//
+// The state of a flag is read from, in order: the node, where it's set with
+// zoscli; the kernel command line; the rollout of the flag in the farm; and
+// the upgrade channel of the node, a flag is enabled by default on the
+// channels at least as early as the channel of the flag.
+package features
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+// the feature flags of the node
+const (
+	// DNSCache resolves the names of the node and of its workloads with
+	// the validating dns cache of the node
+	DNSCache = "dns-cache"
+	// PublicProtection limits the connections of a single source to the
+	// public namespace with nftables
+	PublicProtection = "public-protection"
+)
+
+// Flag is a feature flag
+type Flag struct {
+	Description string
+	// Channel is the most stable channel the feature is enabled on by
+	// default, it's enabled on the earlier channels too. The feature is
+	// disabled by default if it's empty
+	Channel string
+}
+
+// Flags are the feature flags known by the node. A new flag starts on the
+// canary channel and moves to testing, then to production, with the releases
+var Flags = map[string]Flag{
+	DNSCache: {
+		Description: "resolve through the validating dns cache of the node",
+		Channel:     "production",
+	},
+	PublicProtection: {
+		Description: "rate limit the connections to the public namespace",
+		Channel:     "production",
+	},
+}
+
+// channels are the upgrade channels, from the most to the least stable
+var channels = []string{"production", "testing", "canary"}
+
+// the sources of the state of a feature
+const (
+	sourceNode    = "node"
+	sourceKernel  = "kernel"
+	sourceFarm    = "farm"
+	sourceChannel = "channel"
+)
+
+// the kernel parameters configuring the features
+const (
+	featureParam = "feature"
+	rolloutParam = "feature-rollout"
+)
+
+// Config is the features configuration of the kernel command line
+type Config struct {
+	// Features are the features enabled or disabled on the command line
+	Features map[string]bool
+	// Rollouts are the percentage of the nodes of the farm the features
+	// are enabled on
+	Rollouts map[string]int
+}
+
+// ConfigFromParams reads the features configuration from the kernel
+// parameters. feature=<name> enables a feature and feature=-<name> disables
+// it, feature-rollout=<name>:<percent> enables a feature on a percentage of
+// the nodes of the farm. Both can be set more than once. The unknown features
+// are kept, they're known by a newer release
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{
+		Features: make(map[string]bool),
+		Rollouts: make(map[string]int),
+	}
+
+	values, _ := params.Get(featureParam)
+	for _, value := range values {
+		name := strings.TrimPrefix(value, "-")
+		if len(name) == 0 {
+			return config, fmt.Errorf("invalid feature '%s'", value)
+		}
+		config.Features[name] = name == value
+	}
+
+	values, _ = params.Get(rolloutParam)
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return config, fmt.Errorf("invalid feature rollout '%s', expected <name>:<percent>", value)
+		}
+
+		percent, err := strconv.Atoi(parts[1])
+		if err != nil || percent < 0 || percent > 100 {
+			return config, fmt.Errorf("invalid feature rollout percentage '%s'", parts[1])
+		}
+		config.Rollouts[parts[0]] = percent
+	}
+
+	return config, nil
+}
+
+// bucket places the node in [0, 100) for a feature. The feature is part of
+// the bucket, so the same nodes don't get all the new features first
+func bucket(nodeID, name string) int {
+	sum := sha256.Sum256([]byte(nodeID + ":" + name))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// enabledOn returns true if the feature is enabled by default on channel
+func (f Flag) enabledOn(channel string) bool {
+	if len(f.Channel) == 0 {
+		return false
+	}
+
+	for _, c := range channels {
+		if c == f.Channel {
+			return true
+		}
+		if c == channel {
+			return false
+		}
+	}
+
+	return false
+}
+
+// Manager implements pkg.FeatureFlags. The features set on the node are
+// stored in a file
+type Manager struct {
+	path    string
+	nodeID  string
+	config  Config
+	channel func() (string, error)
+
+	mu sync.Mutex
+}
+
+var _ pkg.FeatureFlags = (*Manager)(nil)
+
+// NewManager creates the feature flags of the node, the features set on the
+// node are stored at path. channel returns the upgrade channel of the node
+func NewManager(path, nodeID string, config Config, channel func() (string, error)) *Manager {
+	return &Manager{
+		path:    path,
+		nodeID:  nodeID,
+		config:  config,
+		channel: channel,
+	}
+}
+
+func (m *Manager) node() (map[string]bool, error) {
+	features := make(map[string]bool)
+
+	data, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return features, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read node features")
+	}
+
+	if err := json.Unmarshal(data, &features); err != nil {
+		return nil, errors.Wrap(err, "invalid node features")
+	}
+
+	return features, nil
+}
+
+func (m *Manager) feature(name string, node map[string]bool, channel string) (pkg.Feature, error) {
+	flag, ok := Flags[name]
+	if !ok {
+		return pkg.Feature{}, fmt.Errorf("unknown feature '%s'", name)
+	}
+
+	feature := pkg.Feature{Name: name, Description: flag.Description}
+
+	if enabled, ok := node[name]; ok {
+		feature.Enabled, feature.Source = enabled, sourceNode
+	} else if enabled, ok := m.config.Features[name]; ok {
+		feature.Enabled, feature.Source = enabled, sourceKernel
+	} else if percent, ok := m.config.Rollouts[name]; ok && bucket(m.nodeID, name) < percent {
+		feature.Enabled, feature.Source = true, sourceFarm
+	} else {
+		feature.Enabled, feature.Source = flag.enabledOn(channel), sourceChannel
+	}
+
+	return feature, nil
+}
+
+// state returns the features set on the node and the channel of the node
+func (m *Manager) state() (map[string]bool, string, error) {
+	node, err := m.node()
+	if err != nil {
+		return nil, "", err
+	}
+
+	channel, err := m.channel()
+	if err != nil {
+		// the defaults of the production channel are the safest
+		log.Error().Err(err).Msg("failed to get upgrade channel of the node")
+		channel = channels[0]
+	}
+
+	return node, channel, nil
+}
+
+// Enabled implements pkg.FeatureFlags
+func (m *Manager) Enabled(name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, channel, err := m.state()
+	if err != nil {
+		return false, err
+	}
+
+	feature, err := m.feature(name, node, channel)
+	return feature.Enabled, err
+}
+
+// Features implements pkg.FeatureFlags
+func (m *Manager) Features() ([]pkg.Feature, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, channel, err := m.state()
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]pkg.Feature, 0, len(Flags))
+	for name := range Flags {
+		feature, err := m.feature(name, node, channel)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, feature)
+	}
+
+	sort.Slice(features, func(i, j int) bool { return features[i].Name < features[j].Name })
+	return features, nil
+}
+
+func (m *Manager) update(fn func(node map[string]bool)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.node()
+	if err != nil {
+		return err
+	}
+
+	fn(node)
+
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(m.path, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to store node features")
+	}
+
+	return nil
+}
+
+// Set implements pkg.FeatureFlags
+func (m *Manager) Set(name string, enabled bool) error {
+	if _, ok := Flags[name]; !ok {
+		return fmt.Errorf("unknown feature '%s'", name)
+	}
+
+	return m.update(func(node map[string]bool) {
+		node[name] = enabled
+	})
+}
+
+// Reset implements pkg.FeatureFlags
+func (m *Manager) Reset(name string) error {
+	return m.update(func(node map[string]bool) {
+		delete(node, name)
+	})
+}
+
+// Check returns true if the feature is enabled on the node. The default of
+// the production channel is used if the flags can't be read, the modules
+// don't fail because of a flag
+func Check(flags pkg.FeatureFlags, name string) bool {
+	enabled, err := flags.Enabled(name)
+	if err != nil {
+		enabled = Flags[name].enabledOn(channels[0])
+		log.Error().Err(err).Str("feature", name).Bool("enabled", enabled).Msg("failed to read feature flag")
+	}
+
+	return enabled
+}
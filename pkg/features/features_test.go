@@ -0,0 +1,146 @@
+package features
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{
+		"feature":         {"dns-cache", "-public-protection"},
+		"feature-rollout": {"new-allocator:20"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"dns-cache": true, "public-protection": false}, config.Features)
+	assert.Equal(t, map[string]int{"new-allocator": 20}, config.Rollouts)
+
+	_, err = ConfigFromParams(kernel.Params{"feature": {"-"}})
+	assert.Error(t, err)
+
+	_, err = ConfigFromParams(kernel.Params{"feature-rollout": {"dns-cache"}})
+	assert.Error(t, err)
+
+	_, err = ConfigFromParams(kernel.Params{"feature-rollout": {"dns-cache:101"}})
+	assert.Error(t, err)
+}
+
+func TestEnabledOn(t *testing.T) {
+	flag := Flag{Channel: "testing"}
+	assert.False(t, flag.enabledOn("production"))
+	assert.True(t, flag.enabledOn("testing"))
+	assert.True(t, flag.enabledOn("canary"))
+
+	assert.False(t, Flag{}.enabledOn("canary"))
+	assert.True(t, Flag{Channel: "production"}.enabledOn("production"))
+}
+
+func testFlags() func() {
+	flags := Flags
+	Flags = map[string]Flag{
+		"stable":  {Channel: "production"},
+		"testing": {Channel: "testing"},
+		"new":     {},
+	}
+
+	return func() { Flags = flags }
+}
+
+func TestManager(t *testing.T) {
+	defer testFlags()()
+
+	root, err := ioutil.TempDir("", "features")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	channel := "production"
+	manager := NewManager(filepath.Join(root, "features"), "node", Config{}, func() (string, error) {
+		return channel, nil
+	})
+
+	enabled, err := manager.Enabled("stable")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	enabled, err = manager.Enabled("testing")
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	_, err = manager.Enabled("unknown")
+	assert.Error(t, err)
+
+	channel = "canary"
+	enabled, err = manager.Enabled("testing")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	require.NoError(t, manager.Set("testing", false))
+	require.NoError(t, manager.Set("new", true))
+	assert.Error(t, manager.Set("unknown", true))
+
+	features, err := manager.Features()
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.Feature{
+		{Name: "new", Enabled: true, Source: sourceNode},
+		{Name: "stable", Enabled: true, Source: sourceChannel},
+		{Name: "testing", Enabled: false, Source: sourceNode},
+	}, features)
+
+	require.NoError(t, manager.Reset("testing"))
+	enabled, err = manager.Enabled("testing")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+}
+
+func TestManagerSources(t *testing.T) {
+	defer testFlags()()
+
+	root, err := ioutil.TempDir("", "features")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	config := Config{
+		Features: map[string]bool{"stable": false},
+		Rollouts: map[string]int{"new": 50},
+	}
+
+	production := func() (string, error) { return "production", nil }
+
+	// the kernel command line overrides the channel
+	manager := NewManager(filepath.Join(root, "node"), "node", config, production)
+	features, err := manager.Features()
+	require.NoError(t, err)
+	assert.Equal(t, pkg.Feature{Name: "stable", Enabled: false, Source: sourceKernel}, features[1])
+
+	// about half the nodes of the farm are part of the rollout
+	rollout := 0
+	for i := 0; i < 100; i++ {
+		manager := NewManager(filepath.Join(root, "node"), fmt.Sprintf("node-%d", i), config, production)
+		enabled, err := manager.Enabled("new")
+		require.NoError(t, err)
+		if enabled {
+			rollout++
+		}
+	}
+	assert.InDelta(t, 50, rollout, 20)
+
+	// the node is always in the same bucket
+	assert.Equal(t, bucket("node", "new"), bucket("node", "new"))
+}
+
+func TestCheck(t *testing.T) {
+	defer testFlags()()
+
+	// the features of the node can't be read
+	failing := NewManager("/proc/self", "node", Config{}, nil)
+
+	assert.True(t, Check(failing, "stable"))
+	assert.False(t, Check(failing, "testing"))
+}
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path"
@@ -21,6 +20,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/cancel"
+	"github.com/threefoldtech/zos/pkg/download"
 )
 
 const (
@@ -30,6 +31,10 @@ const (
 
 const mib = 1024 * 1024
 
+// mountTimeout bounds a mount, the download of the flist included, when the
+// caller didn't set a shorter deadline
+const mountTimeout = 10 * time.Minute
+
 type commander interface {
 	Command(name string, arg ...string) *exec.Cmd
 }
@@ -115,16 +120,22 @@ func New(root string, storage pkg.VolumeAllocater) pkg.Flister {
 
 // NamedMount implements the Flister.NamedMount interface
 func (f *flistModule) NamedMount(name, url, storage string, opts pkg.MountOptions) (string, error) {
-	return f.mount(name, url, storage, opts)
+	ctx, release := cancel.Serve(context.Background(), "NamedMount", name, mountTimeout)
+	defer release()
+
+	return f.mount(ctx, name, url, storage, opts)
 }
 
 // Mount implements the Flister.Mount interface
 func (f *flistModule) Mount(url, storage string, opts pkg.MountOptions) (string, error) {
+	ctx, release := cancel.Serve(context.Background(), "Mount", url, mountTimeout)
+	defer release()
+
 	rnd, err := random()
 	if err != nil {
 		return "", errors.Wrap(err, "failed to generate random id for the mount")
 	}
-	return f.mount(rnd, url, storage, opts)
+	return f.mount(ctx, rnd, url, storage, opts)
 }
 
 func (f *flistModule) mountpath(name string) (string, error) {
@@ -156,7 +167,7 @@ func (f *flistModule) valid(path string) error {
 	return nil
 }
 
-func (f *flistModule) mount(name, url, storage string, opts pkg.MountOptions) (string, error) {
+func (f *flistModule) mount(ctx context.Context, name, url, storage string, opts pkg.MountOptions) (string, error) {
 	sublog := log.With().Str("url", url).Str("storage", storage).Logger()
 	sublog.Info().Msg("request to mount flist")
 
@@ -169,7 +180,7 @@ func (f *flistModule) mount(name, url, storage string, opts pkg.MountOptions) (s
 		storage = defaultStorage
 	}
 
-	flistPath, err := f.downloadFlist(url)
+	flistPath, err := f.downloadFlist(ctx, url)
 	if err != nil {
 		sublog.Err(err).Msg("fail to download flist")
 		return "", err
@@ -188,7 +199,7 @@ func (f *flistModule) mount(name, url, storage string, opts pkg.MountOptions) (s
 				return "", fmt.Errorf("invalid mount option, missing disk type and/or size")
 			}
 
-			path, err = f.storage.CreateFilesystem(name, opts.Limit*mib, opts.Type)
+			path, err = f.storage.CreateVolume(name, opts.Limit*mib, opts.Type, pkg.VolumeKindRootFSRW)
 			if err != nil {
 				return "", errors.Wrap(err, "failed to create read-write subvolume for 0-fs")
 			}
@@ -328,45 +339,62 @@ func (f *flistModule) Umount(path string) error {
 // downloadFlist downloads an flits from a URL
 // if the flist location also provide and md5 hash of the flist
 // this function will use it to avoid downloading an flist that is
-// already present locally
-func (f *flistModule) downloadFlist(url string) (string, error) {
+// already present locally, and to verify the downloaded flist
+func (f *flistModule) downloadFlist(ctx context.Context, url string) (string, error) {
+	downloader := download.Default()
+
 	// first check if the md5 of the flist is available
-	md5URL := url + ".md5"
-	resp, err := http.Get(md5URL)
+	resp, err := downloader.Get(ctx, url+".md5")
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return "", errors.Wrap(ctx.Err(), "flist download aborted")
+		}
+		log.Debug().Err(err).Str("url", url).Msg("flist md5 not available")
+		return f.downloadUnknown(ctx, downloader, url)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		hash, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
 
-		flistPath := filepath.Join(f.flist, strings.TrimSpace(string(hash)))
-		_, err = os.Stat(flistPath)
-		if err != nil && !os.IsNotExist(err) {
-			return "", err
-		}
-		if err == nil {
-			log.Info().Str("url", url).Msg("flist already in cache")
-			// flist is already present locally, just return its path
-			return flistPath, nil
-		}
+	hash := strings.TrimSpace(string(data))
+	flistPath := filepath.Join(f.flist, hash)
+	_, err = os.Stat(flistPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		log.Info().Str("url", url).Msg("flist already in cache")
+		// flist is already present locally, just return its path
+		return flistPath, nil
 	}
 
 	log.Info().Str("url", url).Msg("flist not in cache, downloading")
-	// we don't have the flist locally yet, let's download it
-	resp, err = http.Get(url)
+	// the partial flist is kept, so a failed download is resumed
+	partial := flistPath + ".part"
+	err = downloader.Download(ctx, partial, download.Request{
+		URL:      url,
+		Checksum: hash,
+		Hash:     md5.New,
+	})
 	if err != nil {
-		return "", err
+		return "", errors.Wrap(err, "fail to download flist")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("fail to download flist: %v", resp.Status)
+	return flistPath, os.Rename(partial, flistPath)
+}
+
+// downloadUnknown downloads an flist which md5 is not known, the flist is
+// hashed as it's saved
+func (f *flistModule) downloadUnknown(ctx context.Context, downloader *download.Manager, url string) (string, error) {
+	log.Info().Str("url", url).Msg("flist not in cache, downloading")
+	resp, err := downloader.Get(ctx, url)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to download flist")
 	}
+	defer resp.Body.Close()
 
 	return f.saveFlist(resp.Body)
 }
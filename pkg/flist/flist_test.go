@@ -36,6 +36,18 @@ func (s *StorageMock) CreateFilesystem(name string, size uint64, poolType pkg.De
 	return args.String(0), args.Error(1)
 }
 
+// CreateVolume create volume mock
+func (s *StorageMock) CreateVolume(name string, size uint64, poolType pkg.DeviceType, kind pkg.VolumeKind) (string, error) {
+	args := s.Called(name, size, poolType, kind)
+	return args.String(0), args.Error(1)
+}
+
+// CreateVolumeRequest create volume mock
+func (s *StorageMock) CreateVolumeRequest(requestID, name string, size uint64, poolType pkg.DeviceType, kind pkg.VolumeKind) (string, error) {
+	args := s.Called(requestID, name, size, poolType, kind)
+	return args.String(0), args.Error(1)
+}
+
 // ReleaseFilesystem releases filesystem mock
 func (s *StorageMock) ReleaseFilesystem(name string) error {
 	args := s.Called(name)
@@ -124,7 +136,7 @@ func TestMountUnmount(t *testing.T) {
 
 	flister := newFlister(root, strg, cmder)
 
-	strg.On("CreateFilesystem", mock.Anything, uint64(256*mib), pkg.SSDDevice).
+	strg.On("CreateVolume", mock.Anything, uint64(256*mib), pkg.SSDDevice, pkg.VolumeKindRootFSRW).
 		Return("/my/backend", nil)
 
 	mnt, err := flister.Mount("https://hub.grid.tf/thabet/redis.flist", "", pkg.DefaultMountOptions)
@@ -152,7 +164,7 @@ func TestIsolation(t *testing.T) {
 
 	flister := newFlister(root, strg, cmder)
 
-	strg.On("CreateFilesystem", mock.Anything, uint64(256*mib), pkg.SSDDevice).
+	strg.On("CreateVolume", mock.Anything, uint64(256*mib), pkg.SSDDevice, pkg.VolumeKindRootFSRW).
 		Return("/my/backend", nil)
 
 	path1, err := flister.Mount("https://hub.grid.tf/thabet/redis.flist", "", pkg.DefaultMountOptions)
@@ -192,13 +204,13 @@ func TestDownloadFlist(t *testing.T) {
 
 	f, ok := x.(*flistModule)
 	require.True(ok)
-	path1, err := f.downloadFlist("https://hub.grid.tf/thabet/redis.flist")
+	path1, err := f.downloadFlist(context.Background(), "https://hub.grid.tf/thabet/redis.flist")
 	require.NoError(err)
 
 	info1, err := os.Stat(path1)
 	require.NoError(err)
 
-	path2, err := f.downloadFlist("https://hub.grid.tf/thabet/redis.flist")
+	path2, err := f.downloadFlist(context.Background(), "https://hub.grid.tf/thabet/redis.flist")
 	require.NoError(err)
 
 	assert.Equal(path1, path2)
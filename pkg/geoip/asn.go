@@ -0,0 +1,135 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDBPath is where the offline IP to ASN database is shipped on the node
+const DefaultDBPath = "/usr/share/geoip/ip2asn-combined.tsv.gz"
+
+// ASN holds the autonomous system and country an IP belongs to
+type ASN struct {
+	Number  uint32 `json:"number"`
+	Org     string `json:"org"`
+	Country string `json:"country"`
+}
+
+type asnRange struct {
+	start net.IP
+	end   net.IP
+	asn   ASN
+}
+
+// DB is an offline IP to ASN database
+type DB struct {
+	ranges []asnRange
+}
+
+// OpenDB loads the database at path. The file can be gzip compressed
+func OpenDB(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open compressed database %s", path)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return LoadDB(r)
+}
+
+// LoadDB loads a database in the ip2asn tsv format, one range per line:
+// range start, range end, AS number, country code and AS description.
+// Ranges not announced by any AS (AS number 0) are skipped
+func LoadDB(r io.Reader) (*DB, error) {
+	var db DB
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: expected 5 fields, got %d", line, len(fields))
+		}
+
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid ip range", line)
+		}
+
+		number, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: invalid AS number", line)
+		}
+		if number == 0 {
+			continue
+		}
+
+		db.ranges = append(db.ranges, asnRange{
+			start: start.To16(),
+			end:   end.To16(),
+			asn: ASN{
+				Number:  uint32(number),
+				Country: fields[3],
+				Org:     fields[4],
+			},
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read database")
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+
+	return &db, nil
+}
+
+// Lookup returns the AS ip belongs to
+func (db *DB) Lookup(ip net.IP) (ASN, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return ASN{}, false
+	}
+
+	// first range starting after ip, the candidate is the one before
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	})
+	if i == 0 {
+		return ASN{}, false
+	}
+
+	r := db.ranges[i-1]
+	if bytes.Compare(ip, r.end) > 0 {
+		return ASN{}, false
+	}
+
+	return r.asn, true
+}
@@ -0,0 +1,46 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDB = `1.0.0.0	1.0.0.255	13335	US	CLOUDFLARENET
+1.0.1.0	1.0.3.255	0	None	Not routed
+37.187.0.0	37.187.255.255	16276	FR	OVH
+2001:db8::	2001:db8:ffff:ffff:ffff:ffff:ffff:ffff	64496	BE	DOCUMENTATION
+`
+
+func TestLookup(t *testing.T) {
+	db, err := LoadDB(strings.NewReader(testDB))
+	require.NoError(t, err)
+
+	asn, ok := db.Lookup(net.ParseIP("37.187.124.71"))
+	require.True(t, ok)
+	assert.Equal(t, ASN{Number: 16276, Org: "OVH", Country: "FR"}, asn)
+
+	asn, ok = db.Lookup(net.ParseIP("1.0.0.1"))
+	require.True(t, ok)
+	assert.Equal(t, uint32(13335), asn.Number)
+
+	asn, ok = db.Lookup(net.ParseIP("2001:db8::1"))
+	require.True(t, ok)
+	assert.Equal(t, "BE", asn.Country)
+
+	for _, ip := range []string{"1.0.2.1", "0.0.0.1", "8.8.8.8", "2001:db9::1"} {
+		_, ok := db.Lookup(net.ParseIP(ip))
+		assert.False(t, ok, ip)
+	}
+}
+
+func TestLoadDBInvalid(t *testing.T) {
+	_, err := LoadDB(strings.NewReader("1.0.0.0\t1.0.0.255\t13335\n"))
+	assert.Error(t, err)
+
+	_, err = LoadDB(strings.NewReader("1.0.0.0\tnot-an-ip\t13335\tUS\tX\n"))
+	assert.Error(t, err)
+}
@@ -21,7 +21,7 @@ func (s StrIdentifier) Identity() string {
 // IdentityManager interface.
 type IdentityManager interface {
 	// NodeID returns the node id (public key)
-	NodeID() StrIdentifier
+	NodeID() (StrIdentifier, error)
 
 	// FarmID return the farm id this node is part of. this is usually a configuration
 	// that the node is booted with. An error is returned if the farmer id is not configured
@@ -0,0 +1,239 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/versioned"
+)
+
+// the kernel parameters configuring the seed backup
+const (
+	backupKeyParam       = "backup-key"
+	backupThresholdParam = "backup-threshold"
+	restoreParam         = "restore"
+)
+
+// BackupConfig is the seed backup configuration set by the farmer
+type BackupConfig struct {
+	// Keys are the hex encoded ed25519 public keys of the farmer
+	// devices the shares are encrypted to
+	Keys []string
+	// Threshold is the number of shares needed to restore the seed
+	Threshold uint
+	// Restore is the node identity restored on this node, the node then
+	// waits for the shares instead of generating a new seed
+	Restore string
+}
+
+// BackupConfigFromParams reads the backup configuration from the kernel
+// parameters. backup-key=<hex key> is set once per farmer key and
+// backup-threshold=<n> sets the number of shares needed, it defaults to a
+// majority of the keys. restore=<node id> restores the seed of a node on
+// replacement hardware
+func BackupConfigFromParams(params kernel.Params) (BackupConfig, error) {
+	var config BackupConfig
+
+	if values, ok := params.Get(restoreParam); ok && len(values) > 0 {
+		config.Restore = values[0]
+	}
+
+	keys, _ := params.Get(backupKeyParam)
+	for _, key := range keys {
+		if _, err := crypto.KeyFromHex(key); err != nil {
+			return config, errors.Wrapf(err, "invalid backup key '%s'", key)
+		}
+		config.Keys = append(config.Keys, key)
+	}
+
+	config.Threshold = uint(len(config.Keys)/2 + 1)
+	if values, ok := params.Get(backupThresholdParam); ok && len(values) > 0 {
+		threshold, err := strconv.ParseUint(values[0], 10, 8)
+		if err != nil {
+			return config, fmt.Errorf("invalid backup threshold '%s'", values[0])
+		}
+		config.Threshold = uint(threshold)
+	}
+
+	if len(config.Keys) > 0 && (config.Threshold < 2 || config.Threshold > uint(len(config.Keys))) {
+		return config, fmt.Errorf("backup threshold must be between 2 and the number of backup keys")
+	}
+
+	return config, nil
+}
+
+// ErrNoBackup is returned when sealing a seed that has no verified backup,
+// the seed would be lost if it couldn't be unsealed anymore
+var ErrNoBackup = fmt.Errorf("seed has no verified backup, it's only sealed once backed up")
+
+// backupRecord is written next to the seed once its backup is taken and
+// verified
+type backupRecord struct {
+	NodeID    string    `json:"node_id"`
+	Keys      []string  `json:"keys"`
+	Threshold uint      `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+func backupRecordPath(path string) string {
+	return path + ".backup"
+}
+
+func readBackupRecord(path string) (backupRecord, error) {
+	var record backupRecord
+	data, err := ioutil.ReadFile(backupRecordPath(path))
+	if err != nil {
+		return record, err
+	}
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, errors.Wrap(err, "invalid backup record")
+	}
+
+	return record, nil
+}
+
+// backedUp checks if the seed at path of the identity nodeID has a
+// verified backup
+func backedUp(path, nodeID string) bool {
+	record, err := readBackupRecord(path)
+	return err == nil && record.NodeID == nodeID
+}
+
+// SeedBackup implements pkg.IdentityBackup on the seed file at path
+type SeedBackup struct {
+	path   string
+	config BackupConfig
+
+	once     sync.Once
+	restored chan struct{}
+}
+
+var _ pkg.IdentityBackup = (*SeedBackup)(nil)
+
+// NewSeedBackup creates the backup of the seed file at path
+func NewSeedBackup(path string, config BackupConfig) *SeedBackup {
+	return &SeedBackup{
+		path:     path,
+		config:   config,
+		restored: make(chan struct{}),
+	}
+}
+
+// Backup implements pkg.IdentityBackup
+func (b *SeedBackup) Backup() (pkg.SeedBackup, error) {
+	var backup pkg.SeedBackup
+	if len(b.config.Keys) == 0 {
+		return backup, fmt.Errorf("no backup key configured")
+	}
+
+	pair, err := LoadKeyPair(b.path)
+	if err != nil {
+		return backup, errors.Wrap(err, "failed to load seed")
+	}
+
+	seed := pair.PrivateKey.Seed()
+	shares, err := Split(seed, len(b.config.Keys), int(b.config.Threshold))
+	if err != nil {
+		return backup, err
+	}
+
+	// the seed is sealed once backed up, the shares must give it back
+	combined, err := Combine(shares[:b.config.Threshold])
+	if err != nil || !bytes.Equal(combined, seed) {
+		return backup, fmt.Errorf("seed shares don't give back the seed")
+	}
+
+	backup.NodeID = pair.Identity()
+	backup.Threshold = b.config.Threshold
+	for i, key := range b.config.Keys {
+		pk, err := crypto.KeyFromHex(key)
+		if err != nil {
+			return backup, err
+		}
+
+		encrypted, err := crypto.Encrypt(shares[i], pk)
+		if err != nil {
+			return backup, errors.Wrap(err, "failed to encrypt share")
+		}
+
+		backup.Shares = append(backup.Shares, pkg.SeedShare{
+			Key:   key,
+			Share: hex.EncodeToString(encrypted),
+		})
+	}
+
+	record, err := json.Marshal(backupRecord{
+		NodeID:    backup.NodeID,
+		Keys:      b.config.Keys,
+		Threshold: b.config.Threshold,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		return backup, err
+	}
+
+	if err := ioutil.WriteFile(backupRecordPath(b.path), record, 0600); err != nil {
+		return backup, errors.Wrap(err, "failed to record seed backup")
+	}
+
+	return backup, nil
+}
+
+// Restore implements pkg.IdentityBackup
+func (b *SeedBackup) Restore(shares []string) error {
+	if len(b.config.Restore) == 0 {
+		return fmt.Errorf("node is not waiting for a restore")
+	}
+
+	if _, err := os.Stat(b.path); err == nil {
+		return fmt.Errorf("node already has a seed")
+	}
+
+	decoded := make([][]byte, 0, len(shares))
+	for _, share := range shares {
+		data, err := hex.DecodeString(share)
+		if err != nil {
+			return errors.Wrap(err, "invalid share")
+		}
+		decoded = append(decoded, data)
+	}
+
+	seed, err := Combine(decoded)
+	if err != nil {
+		return err
+	}
+
+	pair, err := FromSeed(seed)
+	if err != nil {
+		return err
+	}
+
+	// less shares than the threshold give a random seed
+	if pair.Identity() != b.config.Restore {
+		return fmt.Errorf("shares don't give back the identity %s, not enough or invalid shares", b.config.Restore)
+	}
+
+	if err := versioned.WriteFile(b.path, SeedVersion1, seed, 0400); err != nil {
+		return errors.Wrap(err, "failed to write seed")
+	}
+
+	b.once.Do(func() { close(b.restored) })
+	return nil
+}
+
+// Restored is closed once the seed is restored
+func (b *SeedBackup) Restored() <-chan struct{} {
+	return b.restored
+}
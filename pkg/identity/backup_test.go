@@ -0,0 +1,147 @@
+package identity
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestBackupConfigFromParams(t *testing.T) {
+	keys := make([]string, 3)
+	for i := range keys {
+		pair, err := GenerateKeyPair()
+		require.NoError(t, err)
+		keys[i] = hex.EncodeToString(pair.PublicKey)
+	}
+
+	config, err := BackupConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Empty(t, config.Keys)
+	assert.Empty(t, config.Restore)
+
+	config, err = BackupConfigFromParams(kernel.Params{"backup-key": keys})
+	require.NoError(t, err)
+	assert.Equal(t, keys, config.Keys)
+	assert.Equal(t, uint(2), config.Threshold)
+
+	config, err = BackupConfigFromParams(kernel.Params{"backup-key": keys, "backup-threshold": {"3"}, "restore": {"node"}})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), config.Threshold)
+	assert.Equal(t, "node", config.Restore)
+
+	_, err = BackupConfigFromParams(kernel.Params{"backup-key": keys, "backup-threshold": {"4"}})
+	assert.Error(t, err)
+	_, err = BackupConfigFromParams(kernel.Params{"backup-key": {"abcd"}})
+	assert.Error(t, err)
+}
+
+func TestBackupRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	node, err := GenerateKeyPair()
+	require.NoError(t, err)
+	seedPath := filepath.Join(root, "seed.txt")
+	require.NoError(t, node.Save(seedPath))
+
+	devices := make([]KeyPair, 3)
+	var config BackupConfig
+	for i := range devices {
+		devices[i], err = GenerateKeyPair()
+		require.NoError(t, err)
+		config.Keys = append(config.Keys, hex.EncodeToString(devices[i].PublicKey))
+	}
+	config.Threshold = 2
+
+	backup, err := NewSeedBackup(seedPath, config).Backup()
+	require.NoError(t, err)
+	assert.Equal(t, node.Identity(), backup.NodeID)
+	require.Len(t, backup.Shares, 3)
+
+	// the farmer devices decrypt their shares
+	shares := make([]string, 3)
+	for i, share := range backup.Shares {
+		assert.Equal(t, config.Keys[i], share.Key)
+		encrypted, err := hex.DecodeString(share.Share)
+		require.NoError(t, err)
+		decrypted, err := crypto.Decrypt(encrypted, devices[i].PrivateKey)
+		require.NoError(t, err)
+		shares[i] = hex.EncodeToString(decrypted)
+	}
+
+	// replacement hardware
+	restorePath := filepath.Join(root, "restored", "seed.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(restorePath), 0755))
+	restore := NewSeedBackup(restorePath, BackupConfig{Restore: node.Identity()})
+
+	assert.Error(t, restore.Restore(shares[:1]))
+	// a share of another node
+	other, err := Split([]byte("helloworldhelloworldhelloworld12"), 3, 2)
+	require.NoError(t, err)
+	assert.Error(t, restore.Restore([]string{shares[0], hex.EncodeToString(other[1])}))
+
+	require.NoError(t, restore.Restore([]string{shares[2], shares[0]}))
+	select {
+	case <-restore.Restored():
+	default:
+		t.Fatal("restore not signaled")
+	}
+
+	restored, err := LoadKeyPair(restorePath)
+	require.NoError(t, err)
+	assert.Equal(t, node.PrivateKey, restored.PrivateKey)
+
+	assert.Error(t, restore.Restore(shares), "seed exists")
+	assert.Error(t, NewSeedBackup(seedPath, BackupConfig{}).Restore(shares), "not restoring")
+}
+
+func TestSealRequiresBackup(t *testing.T) {
+	root, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	node, err := GenerateKeyPair()
+	require.NoError(t, err)
+	seedPath := filepath.Join(root, "seed.txt")
+	require.NoError(t, node.Save(seedPath))
+
+	// the seed is left in the file, it's the only copy
+	assert.Equal(t, ErrNoBackup, SealSeed(seedPath, "sha256:0,7"))
+	loaded, err := LoadKeyPair(seedPath)
+	require.NoError(t, err)
+	assert.Equal(t, node.PrivateKey, loaded.PrivateKey)
+	assert.False(t, backedUp(seedPath, node.Identity()))
+
+	device, err := GenerateKeyPair()
+	require.NoError(t, err)
+	other, err := GenerateKeyPair()
+	require.NoError(t, err)
+	config := BackupConfig{
+		Keys:      []string{hex.EncodeToString(device.PublicKey), hex.EncodeToString(other.PublicKey)},
+		Threshold: 2,
+	}
+
+	_, err = NewSeedBackup(seedPath, config).Backup()
+	require.NoError(t, err)
+	assert.True(t, backedUp(seedPath, node.Identity()))
+	assert.False(t, backedUp(seedPath, other.Identity()))
+
+	// the seed can't be unsealed, it's moved aside for the restore
+	restore, err := SetAside(seedPath)
+	require.NoError(t, err)
+	assert.Equal(t, node.Identity(), restore)
+	_, err = os.Stat(seedPath)
+	assert.True(t, os.IsNotExist(err))
+
+	aside, err := filepath.Glob(seedPath + ".unsealable.*")
+	require.NoError(t, err)
+	assert.Len(t, aside, 1)
+}
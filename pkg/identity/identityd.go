@@ -41,8 +41,8 @@ func NewManager(path string) (pkg.IdentityManager, error) {
 }
 
 // NodeID returns the node identity
-func (d *identityManager) NodeID() pkg.StrIdentifier {
-	return pkg.StrIdentifier(d.key.Identity())
+func (d *identityManager) NodeID() (pkg.StrIdentifier, error) {
+	return pkg.StrIdentifier(d.key.Identity()), nil
 }
 
 // FarmID returns the farm ID of the node or an error if no farm ID is configured
@@ -1,9 +1,16 @@
 package identity
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/jbenet/go-base58"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/tpm"
 	"github.com/threefoldtech/zos/pkg/versioned"
 
 	"golang.org/x/crypto/ed25519"
@@ -12,12 +19,15 @@ import (
 // Version History:
 //   1.0.0: seed binary directly encoded
 //   1.1.0: json with key mnemonic and threebot id
+//   2.0.0: binary seed sealed to the TPM
 
 var (
 	// SeedVersion1 (binary seed)
 	SeedVersion1 = versioned.MustParse("1.0.0")
 	// SeedVersion11 (json mnemonic)
 	SeedVersion11 = versioned.MustParse("1.1.0")
+	// SeedVersionSealed (seed sealed to the TPM)
+	SeedVersionSealed = versioned.MustParse("2.0.0")
 	// SeedVersionLatest link to latest seed version
 	SeedVersionLatest = SeedVersion11
 )
@@ -65,6 +75,10 @@ func LoadSeed(path string) ([]byte, error) {
 		return nil, err
 	}
 
+	if version.EQ(SeedVersionSealed) {
+		return unsealSeed(seed)
+	}
+
 	if version.NE(SeedVersion1) {
 		return nil, fmt.Errorf("unknown seed version")
 	}
@@ -72,6 +86,105 @@ func LoadSeed(path string) ([]byte, error) {
 	return seed, nil
 }
 
+// ErrUnseal is the cause of the errors of a sealed seed that can't be
+// unsealed anymore, because the boot state of the node changed or the disk
+// was moved to another node
+var ErrUnseal = fmt.Errorf("sealed seed can't be unsealed")
+
+// IsUnsealError checks if err is caused by a sealed seed that can't be
+// unsealed, the seed then needs to be restored from its backup
+func IsUnsealError(err error) bool {
+	return errors.Cause(err) == ErrUnseal
+}
+
+func unsealSeed(data []byte) ([]byte, error) {
+	var sealed tpm.Sealed
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, errors.Wrap(err, "invalid sealed seed")
+	}
+
+	seed, err := tpm.Unseal(sealed)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnseal, err.Error())
+	}
+
+	return seed, nil
+}
+
+// SealSeed seals the seed file at path to the TPM, the file only holds
+// the sealed seed afterward. It does nothing if the seed is already sealed.
+// The seed is only sealed once it's backed up, so the identity can still be
+// restored when the seed can't be unsealed anymore
+func SealSeed(path string, pcrs string) error {
+	version, _, err := versioned.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if version.EQ(SeedVersionSealed) {
+		return nil
+	}
+
+	seed, err := LoadSeed(path)
+	if err != nil {
+		return err
+	}
+
+	pair, err := FromSeed(seed)
+	if err != nil {
+		return err
+	}
+
+	if !backedUp(path, pair.Identity()) {
+		return ErrNoBackup
+	}
+
+	sealed, err := tpm.Seal(seed, pcrs)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+
+	// the plain seed is only replaced once we know it can be unsealed
+	unsealed, err := unsealSeed(data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(seed, unsealed) {
+		return fmt.Errorf("unsealed seed doesn't match")
+	}
+
+	tmp := path + ".sealed"
+	if err := versioned.WriteFile(tmp, SeedVersionSealed, data, 0400); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// SetAside moves the sealed seed at path that can't be unsealed out of the
+// way, so the seed can be restored from its backup. The file is kept, the
+// seed can still be unsealed if the boot state of the node goes back. It
+// returns the identity to restore
+func SetAside(path string) (string, error) {
+	record, err := readBackupRecord(path)
+	if err != nil {
+		return "", errors.Wrap(err, "sealed seed has no backup")
+	}
+
+	aside := fmt.Sprintf("%s.unsealable.%d", path, time.Now().Unix())
+	if err := os.Rename(path, aside); err != nil {
+		return "", err
+	}
+
+	log.Warn().Str("path", aside).Msg("sealed seed moved aside")
+	return record.NodeID, nil
+}
+
 // LoadKeyPair reads a seed from a file located at path and re-create a
 // KeyPair using the seed
 func LoadKeyPair(path string) (k KeyPair, err error) {
@@ -0,0 +1,132 @@
+package identity
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(2^8). Every byte of the secret is the
+// constant term of a random polynomial of degree threshold-1, a share is
+// the evaluation of all the polynomials at the same non zero x, which is
+// appended as the last byte of the share
+
+// gfMul multiplies a and b in GF(2^8) with the AES polynomial
+func gfMul(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a, a^254 since a^255 = 1
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+
+	return result
+}
+
+// evaluate the polynomial with coefficients (lowest degree first) at x
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+
+	return result
+}
+
+// Split splits secret in n shares, any threshold of them give back the secret
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+	if threshold < 2 || threshold > n {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of shares")
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("too many shares, max is 255")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for i, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for _, share := range shares {
+			share[i] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine gives back the secret from threshold shares or more. With less
+// shares than the threshold the result is a random value, the caller must
+// check it against something known
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are needed")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("invalid share size")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]struct{})
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("shares have different sizes")
+		}
+		x := share[size-1]
+		if x == 0 {
+			return nil, fmt.Errorf("invalid share %d", i)
+		}
+		if _, ok := seen[x]; ok {
+			return nil, fmt.Errorf("duplicate share %d", i)
+		}
+		seen[x] = struct{}{}
+		xs[i] = x
+	}
+
+	// lagrange interpolation at x = 0, subtraction is xor in GF(2^8)
+	secret := make([]byte, size-1)
+	for i := range secret {
+		var value byte
+		for j, share := range shares {
+			basis := byte(1)
+			for m, x := range xs {
+				if m == j {
+					continue
+				}
+				basis = gfMul(basis, gfMul(x, gfInv(x^xs[j])))
+			}
+			value ^= gfMul(share[i], basis)
+		}
+		secret[i] = value
+	}
+
+	return secret, nil
+}
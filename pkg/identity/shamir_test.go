@@ -0,0 +1,57 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), gfMul(byte(a), gfInv(byte(a))), "inverse of %d", a)
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("helloworldhelloworldhelloworld12")
+
+	shares, err := Split(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var selected [][]byte
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+
+		combined, err := Combine(selected)
+		require.NoError(t, err)
+		assert.Equal(t, secret, combined, "shares %v", subset)
+	}
+
+	// less shares than the threshold don't give the secret
+	combined, err := Combine(shares[:2])
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+}
+
+func TestSplitCombineInvalid(t *testing.T) {
+	_, err := Split([]byte("secret"), 3, 1)
+	assert.Error(t, err)
+	_, err = Split([]byte("secret"), 3, 4)
+	assert.Error(t, err)
+	_, err = Split(nil, 3, 2)
+	assert.Error(t, err)
+
+	shares, err := Split([]byte("secret"), 3, 2)
+	require.NoError(t, err)
+
+	_, err = Combine(shares[:1])
+	assert.Error(t, err)
+	_, err = Combine([][]byte{shares[0], shares[0]})
+	assert.Error(t, err)
+	_, err = Combine([][]byte{shares[0], shares[1][1:]})
+	assert.Error(t, err)
+}
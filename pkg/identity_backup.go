@@ -0,0 +1,32 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module identityd -version 0.0.1 -name backup -package stubs github.com/threefoldtech/zos/pkg+IdentityBackup stubs/identity_backup_stub.go
+
+// SeedShare is a share of the node seed encrypted to a farmer key
+type SeedShare struct {
+	// Key is the hex encoded ed25519 public key the share is encrypted to
+	Key string `json:"key"`
+	// Share is the hex encoded share, sealed with the curve25519 equivalent of Key
+	Share string `json:"share"`
+}
+
+// SeedBackup is a backup of the node seed split in shares, any Threshold
+// of the shares gives back the seed
+type SeedBackup struct {
+	NodeID    string      `json:"node_id"`
+	Threshold uint        `json:"threshold"`
+	Shares    []SeedShare `json:"shares"`
+}
+
+// IdentityBackup backs up and restores the node seed with Shamir secret sharing
+type IdentityBackup interface {
+	// Backup splits the seed in one share per backup key of the farmer,
+	// encrypted to that key. The keys are set by the farmer in the kernel
+	// parameters, so the seed can't be backed up to any other key
+	Backup() (SeedBackup, error)
+	// Restore writes the seed combined from the hex encoded decrypted
+	// shares. It's only possible on a node waiting for its seed to be
+	// restored, and fails if the shares don't give back the expected node ID
+	Restore(shares []string) error
+}
@@ -0,0 +1,75 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module monitor -version 0.0.1 -name inventory -package stubs github.com/threefoldtech/zos/pkg+InventoryManager stubs/inventory_manager_stub.go
+
+import "time"
+
+// CPUInventory is a processor model of the node
+type CPUInventory struct {
+	// Model name of the processor
+	Model string `json:"model"`
+	// Threads is the number of logical CPUs of this model
+	Threads int `json:"threads"`
+}
+
+// DiskInventory is a physical disk of the node
+type DiskInventory struct {
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+}
+
+// UplinkInventory is the switch port a physical interface is cabled to
+type UplinkInventory struct {
+	Interface string `json:"interface"`
+	// Switch is the name of the switch, or its chassis id if it
+	// doesn't advertise its name
+	Switch string `json:"switch"`
+	Port   string `json:"port"`
+}
+
+// Inventory is the canonical description of the hardware and software of
+// the node. The lists are sorted so the same node always gives the same
+// inventory, whatever the order the kernel found the devices in
+type Inventory struct {
+	CPUs  []CPUInventory  `json:"cpus"`
+	Disks []DiskInventory `json:"disks"`
+	// NICs are the MAC addresses of the physical interfaces
+	NICs []string `json:"nics"`
+	// Uplinks are the switch ports the physical interfaces are cabled
+	// to, as advertised with LLDP
+	Uplinks []UplinkInventory `json:"uplinks,omitempty"`
+	// Firmware are the vendor, product, version and serial of the
+	// bios, system and baseboard as reported by DMI
+	Firmware map[string]string `json:"firmware"`
+	// Modules are the versions of the 0-OS modules by zbus module name
+	Modules map[string]string `json:"modules"`
+}
+
+// Attestation is a statement signed by the node identity of the inventory
+// of the node at a given time
+type Attestation struct {
+	NodeID string `json:"node_id"`
+	// Nonce is the hex encoded nonce given by the verifier, it proves
+	// the attestation is fresh
+	Nonce string    `json:"nonce"`
+	Time  time.Time `json:"time"`
+	// Fingerprint is the fingerprint of the whole inventory
+	Fingerprint string `json:"fingerprint"`
+	// HardwareFingerprint is the fingerprint of the inventory without the
+	// modules versions, it only changes when the hardware changes
+	HardwareFingerprint string `json:"hardware_fingerprint"`
+	// Signature is the hex encoded signature of the attestation by the node
+	Signature string `json:"signature"`
+}
+
+// InventoryManager gives access to the inventory of the node
+type InventoryManager interface {
+	// Inventory returns the current inventory of the node
+	Inventory() (Inventory, error)
+	// Fingerprint returns the hex encoded sha256 of the inventory
+	Fingerprint() (string, error)
+	// Attest returns a signed attestation of the inventory for nonce
+	Attest(nonce []byte) (Attestation, error)
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"github.com/threefoldtech/zbus"
+)
+
+// Levels requests the log levels of module
+func Levels(cl zbus.Client, module string) (map[string]string, error) {
+	result, err := cl.Request(module, ObjectID, "Levels")
+	if err != nil {
+		return nil, err
+	}
+
+	var levels map[string]string
+	if err := result.Unmarshal(0, &levels); err != nil {
+		return nil, err
+	}
+
+	return levels, remoteError(result.Unmarshal, 1)
+}
+
+// SetLevel sets the log level of a component of module, or of the whole
+// module if component is empty
+func SetLevel(cl zbus.Client, module, component, level string) error {
+	result, err := cl.Request(module, ObjectID, "SetLevel", component, level)
+	if err != nil {
+		return err
+	}
+
+	return remoteError(result.Unmarshal, 0)
+}
+
+// Recent requests the last n lines logged by module
+func Recent(cl zbus.Client, module string, n int) ([]string, error) {
+	result, err := cl.Request(module, ObjectID, "Recent", n)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	if err := result.Unmarshal(0, &lines); err != nil {
+		return nil, err
+	}
+
+	return lines, remoteError(result.Unmarshal, 1)
+}
+
+// remoteError returns the error at index i of the result of a request
+func remoteError(unmarshal func(int, interface{}) error, i int) error {
+	remote := new(zbus.RemoteError)
+	if err := unmarshal(i, &remote); err != nil {
+		return err
+	}
+	if remote == nil {
+		return nil
+	}
+	return remote
+}
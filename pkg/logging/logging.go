@@ -0,0 +1,212 @@
+// Package logging controls the log levels of a module at runtime. The level
+// is set for the whole module and can be raised or lowered for one of its
+// components (wireguard, the storage allocator, ...), the loggers of a
+// component are created with Component.
+//
+// The last debug lines of the module are kept in memory whatever the level,
+// so the context of an error can be read after the fact without restarting
+// the module in debug.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+)
+
+// ObjectID is the zbus object every module registers to control its logs
+var ObjectID = zbus.ObjectID{Name: "logging", Version: "0.0.1"}
+
+// DefaultCapacity is the number of lines kept in memory
+const DefaultCapacity = 1000
+
+// componentField is the field of the lines logged by a component
+const componentField = "component"
+
+// Component returns a logger of the component name of the module, its
+// level is controlled separately from the rest of the module. The logger
+// must be created after the logs are installed, not in a package variable
+func Component(name string) zerolog.Logger {
+	return log.With().Str(componentField, name).Logger()
+}
+
+// Writer filters the lines of a module by their level and component before
+// writing them to the output, and keeps the last lines in memory
+type Writer struct {
+	out io.Writer
+
+	mu       sync.RWMutex
+	level    zerolog.Level
+	levels   map[string]zerolog.Level
+	lines    [][]byte
+	next     int
+	capacity int
+}
+
+// NewWriter creates a writer to out keeping the last capacity lines. The
+// lines of level lower than level are not written
+func NewWriter(out io.Writer, level zerolog.Level, capacity int) *Writer {
+	return &Writer{
+		out:      out,
+		level:    level,
+		levels:   make(map[string]zerolog.Level),
+		capacity: capacity,
+	}
+}
+
+// Write implements io.Writer, the lines without level are always written
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	w.keep(p)
+
+	if level != zerolog.NoLevel && level < w.threshold(component(p)) {
+		return len(p), nil
+	}
+
+	return w.out.Write(p)
+}
+
+func (w *Writer) keep(p []byte) {
+	if w.capacity <= 0 {
+		return
+	}
+
+	line := make([]byte, len(p))
+	copy(line, p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.lines) < w.capacity {
+		w.lines = append(w.lines, line)
+		return
+	}
+
+	w.lines[w.next] = line
+	w.next = (w.next + 1) % w.capacity
+}
+
+func (w *Writer) threshold(name string) zerolog.Level {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if level, ok := w.levels[name]; ok && name != "" {
+		return level
+	}
+
+	return w.level
+}
+
+// component extracts the component of a json line
+func component(p []byte) string {
+	prefix := []byte(`"` + componentField + `":"`)
+	start := bytes.Index(p, prefix)
+	if start < 0 {
+		return ""
+	}
+
+	value := p[start+len(prefix):]
+	end := bytes.IndexByte(value, '"')
+	if end < 0 {
+		return ""
+	}
+
+	return string(value[:end])
+}
+
+// Levels returns the level of the module, under the empty name, and the
+// levels of the components set apart
+func (w *Writer) Levels() (map[string]string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	levels := map[string]string{"": w.level.String()}
+	for name, level := range w.levels {
+		levels[name] = level.String()
+	}
+
+	return levels, nil
+}
+
+// SetLevel sets the level of the component name, or of the whole module if
+// name is empty. A component set to the empty level follows the module
+// again
+func (w *Writer) SetLevel(name, level string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if level == "" {
+		if name == "" {
+			return fmt.Errorf("level of the module is required")
+		}
+		delete(w.levels, name)
+		return nil
+	}
+
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid level '%s'", level)
+	}
+
+	if name == "" {
+		w.level = parsed
+	} else {
+		w.levels[name] = parsed
+	}
+
+	return nil
+}
+
+// Recent returns the last n lines of the module, of any level, from the
+// oldest to the newest
+func (w *Writer) Recent(n int) ([]string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if n <= 0 || n > len(w.lines) {
+		n = len(w.lines)
+	}
+
+	lines := make([]string, 0, n)
+	for i := len(w.lines) - n; i < len(w.lines); i++ {
+		line := w.lines[(w.next+i)%len(w.lines)]
+		lines = append(lines, string(bytes.TrimRight(line, "\n")))
+	}
+
+	return lines, nil
+}
+
+var (
+	global   = NewWriter(ioutil.Discard, zerolog.DebugLevel, DefaultCapacity)
+	globalMu sync.Mutex
+)
+
+// Install makes the global logger write through a Writer to out and returns
+// it, so it can be registered on zbus under ObjectID. The debug lines are
+// kept in memory even when the level of the module is higher
+func Install(out io.Writer, level zerolog.Level) *Writer {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	global = NewWriter(out, level, DefaultCapacity)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	log.Logger = log.Output(global)
+	return global
+}
+
+// Control returns the writer of the global logger
+func Control() *Writer {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return global
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterLevels(t *testing.T) {
+	require := require.New(t)
+
+	var out bytes.Buffer
+	w := NewWriter(&out, zerolog.InfoLevel, 10)
+	logger := zerolog.New(w)
+	wg := logger.With().Str(componentField, "wireguard").Logger()
+
+	logger.Debug().Msg("module debug")
+	wg.Debug().Msg("wireguard debug")
+	logger.Info().Msg("module info")
+	require.Equal(1, strings.Count(out.String(), "\n"))
+	require.Contains(out.String(), "module info")
+
+	require.NoError(w.SetLevel("wireguard", "debug"))
+	out.Reset()
+	logger.Debug().Msg("module debug")
+	wg.Debug().Msg("wireguard debug")
+	require.Equal(1, strings.Count(out.String(), "\n"))
+	require.Contains(out.String(), "wireguard debug")
+
+	require.NoError(w.SetLevel("", "error"))
+	levels, err := w.Levels()
+	require.NoError(err)
+	require.Equal(map[string]string{"": "error", "wireguard": "debug"}, levels)
+
+	require.NoError(w.SetLevel("wireguard", ""))
+	out.Reset()
+	wg.Info().Msg("wireguard info")
+	require.Empty(out.String())
+
+	require.Error(w.SetLevel("", ""))
+	require.Error(w.SetLevel("allocator", "verbose"))
+}
+
+func TestWriterRecent(t *testing.T) {
+	require := require.New(t)
+
+	var out bytes.Buffer
+	w := NewWriter(&out, zerolog.ErrorLevel, 3)
+	logger := zerolog.New(w)
+
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		logger.Debug().Msg(msg)
+	}
+	require.Empty(out.String())
+
+	lines, err := w.Recent(0)
+	require.NoError(err)
+	require.Len(lines, 3)
+	require.Contains(lines[0], "two")
+	require.Contains(lines[2], "four")
+
+	lines, err = w.Recent(1)
+	require.NoError(err)
+	require.Len(lines, 1)
+	require.Contains(lines[0], "four")
+}
@@ -5,6 +5,8 @@ package pkg
 //go:generate zbusc -module monitor -version 0.0.1 -name host -package stubs github.com/threefoldtech/zos/pkg+HostMonitor stubs/host_monitor_stub.go
 //go:generate zbusc -module identityd -version 0.0.1 -name monitor -package stubs github.com/threefoldtech/zos/pkg+VersionMonitor stubs/version_monitor_stub.go
 //go:generate zbusc -module provision -version 0.0.1 -name provision -package stubs github.com/threefoldtech/zos/pkg+ProvisionMonitor stubs/provision_monitor_stub.go
+//go:generate zbusc -module provision -version 0.0.1 -name readiness -package stubs github.com/threefoldtech/zos/pkg+ReadinessMonitor stubs/readiness_monitor_stub.go
+//go:generate zbusc -module provision -version 0.0.1 -name jobs -package stubs github.com/threefoldtech/zos/pkg+JobMonitor stubs/job_monitor_stub.go
 
 import (
 	"context"
@@ -77,15 +79,52 @@ type PoolStats struct {
 	Counters map[string]disk.IOCountersStat `json:"counters"`
 }
 
+// SwapStat is the swap usage of the node
+type SwapStat struct {
+	mem.SwapMemoryStat
+	// Original is the size of the pages held by the zram swap and
+	// Compressed the memory they use, both are 0 without zram
+	Original   uint64
+	Compressed uint64
+	// RateIn and RateOut are the bytes swapped in and out per second
+	RateIn  uint64
+	RateOut uint64
+	// Pressure is the percentage of time some tasks were stalled
+	// waiting for memory over the last 10 seconds
+	Pressure float64
+	Time     time.Time
+}
+
+// SensorStat is a temperature sensor of the node
+type SensorStat struct {
+	// Name of the chip or of the thermal zone of the sensor
+	Name string
+	// Label of the sensor on its chip
+	Label string
+	// Temperature in degrees Celsius
+	Temperature float64
+}
+
+// SensorsStat alias for []SensorStat
+type SensorsStat []SensorStat
+
 // PoolsStats alias for map[string]PoolStats
 type PoolsStats map[string]PoolStats
 
+// CallFailuresStat are the zbus calls that failed on transport or decoding,
+// by calling module and then by called module, object and method, since
+// the calling modules started
+type CallFailuresStat map[string]map[string]uint64
+
 //SystemMonitor interface (provided by monitord)
 type SystemMonitor interface {
 	Memory(ctx context.Context) <-chan VirtualMemoryStat
 	CPU(ctx context.Context) <-chan CPUTimesStat
 	Disks(ctx context.Context) <-chan DisksIOCountersStat
 	Nics(ctx context.Context) <-chan NicsIOCounterStat
+	Swap(ctx context.Context) <-chan SwapStat
+	Sensors(ctx context.Context) <-chan SensorsStat
+	CallFailures(ctx context.Context) <-chan CallFailuresStat
 }
 
 // HostMonitor interface (provided by monitord)
@@ -112,3 +151,47 @@ type ProvisionCounters struct {
 type ProvisionMonitor interface {
 	Counters(ctx context.Context) <-chan ProvisionCounters
 }
+
+// ReadinessEvent is sent each time the readiness of a workload changes
+type ReadinessEvent struct {
+	// ID of the workload
+	ID string `json:"id"`
+	// Ready is true if all the probes of the workload are passing
+	Ready bool `json:"ready"`
+	// Time of the transition
+	Time time.Time `json:"time"`
+	// Error of the failing probe if not ready
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessMonitor interface (provided by provisiond)
+type ReadinessMonitor interface {
+	// Ready returns the current readiness of a workload
+	Ready(id string) (bool, error)
+	// Readiness streams the readiness transitions of all workloads
+	Readiness(ctx context.Context) <-chan ReadinessEvent
+}
+
+// JobRun is a run of a scheduled job
+type JobRun struct {
+	// ID of the run, it's also the ID of the container of the run
+	ID string `json:"id"`
+	// Scheduled is the time the run was due
+	Scheduled time.Time `json:"scheduled"`
+	// Started is the time the container of the run started
+	Started time.Time `json:"started"`
+	// Finished is the time the run exited
+	Finished time.Time `json:"finished"`
+	// Running is set until the run exits
+	Running bool `json:"running"`
+	// ExitStatus of the entrypoint of the run
+	ExitStatus uint32 `json:"exit_status"`
+	// Error is set if the run was skipped, failed to start, timed out or was replaced
+	Error string `json:"error,omitempty"`
+}
+
+// JobMonitor interface (provided by provisiond)
+type JobMonitor interface {
+	// JobRuns returns the last runs of a scheduled job, most recent first
+	JobRuns(id string) ([]JobRun, error)
+}
@@ -0,0 +1,67 @@
+package monitord
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/startup"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// callFailuresInterval is the interval between two collections of the
+// failed calls, every module is queried
+const callFailuresInterval = 30 * time.Second
+
+// CallFailures starts the failed zbus calls monitor stream
+func (m *systemMonitor) CallFailures(ctx context.Context) <-chan pkg.CallFailuresStat {
+	ch := make(chan pkg.CallFailuresStat)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(callFailuresInterval):
+				result := m.callFailures(ctx)
+
+				select {
+				case ch <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// callFailures collects the failed calls of all the modules, a module that
+// doesn't answer is skipped
+func (m *systemMonitor) callFailures(ctx context.Context) pkg.CallFailuresStat {
+	modules := make([]string, 0, len(startup.Modules))
+	for name := range startup.Modules {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+
+	result := pkg.CallFailuresStat{}
+	for _, module := range modules {
+		if ctx.Err() != nil {
+			break
+		}
+
+		failures, err := stubs.ModuleFailures(m.client, module)
+		if err != nil {
+			log.Debug().Err(err).Str("module", module).Msg("failed to collect module call failures")
+			continue
+		}
+		result[module] = failures
+	}
+
+	return result
+}
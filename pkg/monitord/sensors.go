@@ -0,0 +1,145 @@
+package monitord
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	hwmonPath   = "/sys/class/hwmon"
+	thermalPath = "/sys/class/thermal"
+)
+
+// Sensors starts temperature sensors monitor stream
+func (m *systemMonitor) Sensors(ctx context.Context) <-chan pkg.SensorsStat {
+	ch := make(chan pkg.SensorsStat)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.duration):
+				result, err := sensors(hwmonPath, thermalPath)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to read temperature sensors")
+					continue
+				}
+
+				select {
+				case ch <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// sensors reads the temperature sensors of the hwmon chips and of the
+// thermal zones. The chips of the x86 servers expose their sensors in the
+// hwmon directory, or in its device directory with the older drivers. The
+// arm64 SoCs often only have thermal zones, the zones also registered as a
+// hwmon chip are skipped
+func sensors(hwmon, thermal string) (pkg.SensorsStat, error) {
+	result := pkg.SensorsStat{}
+
+	chips, err := filepath.Glob(filepath.Join(hwmon, "hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for _, chip := range chips {
+		name := readAttribute(chip, "name")
+		if len(name) == 0 {
+			name = readAttribute(chip, "device/name")
+		}
+		names[name] = struct{}{}
+
+		inputs, _ := filepath.Glob(filepath.Join(chip, "temp*_input"))
+		if len(inputs) == 0 {
+			inputs, _ = filepath.Glob(filepath.Join(chip, "device", "temp*_input"))
+		}
+
+		for _, input := range inputs {
+			temperature, err := readTemperature(input)
+			if err != nil {
+				// the sensors of some chips are not always readable
+				continue
+			}
+
+			sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+			label := readAttribute(filepath.Dir(input), sensor+"_label")
+			if len(label) == 0 {
+				label = sensor
+			}
+
+			result = append(result, pkg.SensorStat{Name: name, Label: label, Temperature: temperature})
+		}
+	}
+
+	zones, err := filepath.Glob(filepath.Join(thermal, "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range zones {
+		name := readAttribute(zone, "type")
+		// the names of the hwmon chips can't contain a dash
+		if _, ok := names[strings.Replace(name, "-", "_", -1)]; ok {
+			continue
+		}
+
+		temperature, err := readTemperature(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+
+		result = append(result, pkg.SensorStat{Name: name, Label: filepath.Base(zone), Temperature: temperature})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Label < result[j].Label
+	})
+
+	return result, nil
+}
+
+func readAttribute(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// readTemperature reads a temperature in millidegrees Celsius
+func readTemperature(path string) (float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(value) / 1000, nil
+}
@@ -0,0 +1,66 @@
+package monitord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestSensors(t *testing.T) {
+	root, err := ioutil.TempDir("", "sensors")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	hwmon := filepath.Join(root, "hwmon")
+	thermal := filepath.Join(root, "thermal")
+
+	write := func(path, value string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(value+"\n"), 0644))
+	}
+
+	// x86 chip with labels
+	write(filepath.Join(hwmon, "hwmon0", "name"), "coretemp")
+	write(filepath.Join(hwmon, "hwmon0", "temp1_input"), "45000")
+	write(filepath.Join(hwmon, "hwmon0", "temp1_label"), "Package id 0")
+	write(filepath.Join(hwmon, "hwmon0", "temp2_input"), "43500")
+	write(filepath.Join(hwmon, "hwmon0", "temp2_label"), "Core 0")
+
+	// older driver, the sensors are in the device directory
+	write(filepath.Join(hwmon, "hwmon1", "device", "name"), "it8728")
+	write(filepath.Join(hwmon, "hwmon1", "device", "temp1_input"), "32000")
+
+	// arm64 SoC thermal zone registered as a hwmon chip
+	write(filepath.Join(hwmon, "hwmon2", "name"), "cpu_thermal")
+	write(filepath.Join(hwmon, "hwmon2", "temp1_input"), "51121")
+	write(filepath.Join(thermal, "thermal_zone0", "type"), "cpu-thermal")
+	write(filepath.Join(thermal, "thermal_zone0", "temp"), "51121")
+
+	// thermal zone only
+	write(filepath.Join(thermal, "thermal_zone1", "type"), "gpu-thermal")
+	write(filepath.Join(thermal, "thermal_zone1", "temp"), "48333")
+
+	// unreadable sensor
+	write(filepath.Join(thermal, "thermal_zone2", "type"), "battery")
+	write(filepath.Join(thermal, "thermal_zone2", "temp"), "")
+
+	result, err := sensors(hwmon, thermal)
+	require.NoError(t, err)
+	assert.Equal(t, pkg.SensorsStat{
+		{Name: "coretemp", Label: "Core 0", Temperature: 43.5},
+		{Name: "coretemp", Label: "Package id 0", Temperature: 45},
+		{Name: "cpu_thermal", Label: "temp1", Temperature: 51.121},
+		{Name: "gpu-thermal", Label: "thermal_zone1", Temperature: 48.333},
+		{Name: "it8728", Label: "temp1", Temperature: 32},
+	}, result)
+
+	// a node without sensors
+	result, err = sensors(filepath.Join(root, "none"), filepath.Join(root, "none"))
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
@@ -2,6 +2,10 @@ package monitord
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -9,6 +13,7 @@ import (
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
+	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg"
 )
 
@@ -19,15 +24,17 @@ var (
 // systemMonitor stream
 type systemMonitor struct {
 	duration time.Duration
+	client   zbus.Client
 }
 
-// NewSystemMonitor creates new system of system monitor
-func NewSystemMonitor(duration time.Duration) (pkg.SystemMonitor, error) {
+// NewSystemMonitor creates new system of system monitor, client is used to
+// collect the failed calls of the modules
+func NewSystemMonitor(duration time.Duration, client zbus.Client) (pkg.SystemMonitor, error) {
 	if duration == 0 {
 		duration = 2 * time.Second
 	}
 
-	return &systemMonitor{duration: duration}, nil
+	return &systemMonitor{duration: duration, client: client}, nil
 }
 
 // Memory starts memory monitor stream
@@ -196,3 +203,102 @@ func (m *systemMonitor) Nics(ctx context.Context) <-chan pkg.NicsIOCounterStat {
 
 	return ch
 }
+
+// Swap starts swap monitor stream
+func (m *systemMonitor) Swap(ctx context.Context) <-chan pkg.SwapStat {
+	ch := make(chan pkg.SwapStat)
+	go func() {
+		defer close(ch)
+		t := time.Now()
+		history := make(map[string]uint64)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-time.After(m.duration):
+				swap, err := mem.SwapMemoryWithContext(ctx)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to read swap status")
+					continue
+				}
+
+				result := pkg.SwapStat{
+					SwapMemoryStat: *swap,
+					RateIn:         m.rate(history, "in", swap.Sin, t, now),
+					RateOut:        m.rate(history, "out", swap.Sout, t, now),
+					Time:           now,
+				}
+
+				// the node may not swap to zram, and pressure
+				// stall information needs a recent kernel
+				result.Original, result.Compressed, _ = zramUsage()
+				result.Pressure, _ = memoryPressure()
+
+				t = now
+				select {
+				case ch <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// zramUsage returns the size of the pages held by the zram
+// swap and the memory they use
+func zramUsage() (original uint64, used uint64, err error) {
+	data, err := ioutil.ReadFile("/sys/block/zram0/mm_stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// orig_data_size compr_data_size mem_used_total mem_limit ...
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("invalid zram mm_stat '%s'", string(data))
+	}
+
+	if original, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+
+	if used, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return 0, 0, err
+	}
+
+	return original, used, nil
+}
+
+// memoryPressure returns the percentage of time some tasks were
+// stalled waiting for memory over the last 10 seconds
+func memoryPressure() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return 0, err
+	}
+
+	return parsePressure(string(data))
+}
+
+func parsePressure(data string) (float64, error) {
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+
+		value := strings.TrimPrefix(fields[1], "avg10=")
+		if value == fields[1] {
+			break
+		}
+
+		return strconv.ParseFloat(value, 64)
+	}
+
+	return 0, fmt.Errorf("invalid pressure stall information '%s'", data)
+}
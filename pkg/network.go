@@ -2,7 +2,10 @@ package pkg
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/threefoldtech/zos/pkg/network/types"
 	"github.com/threefoldtech/zos/pkg/versioned"
@@ -16,6 +19,9 @@ type Member struct {
 	Namespace string
 	IPv6      net.IP
 	IPv4      net.IP
+	// Env are the environment variables the workload needs to use the
+	// network, like the egress proxy configuration
+	Env []string
 }
 
 //Networker is the interface for the network module
@@ -26,9 +32,42 @@ type Networker interface {
 
 	// Create a new network resource
 	CreateNR(Network) (string, error)
+	// CreateNRRequest is CreateNR with the ID of the request given by the
+	// caller. A retry of the request with the same ID returns the result of
+	// the first one instead of applying the network again. Without an ID,
+	// as with CreateNR, a retry is recognized by its network object only
+	CreateNRRequest(requestID string, network Network) (string, error)
+	// SubmitNR queues the creation of the network resource and returns
+	// without waiting for it, the progress is polled with ApplyStatus.
+	// The operations on the same network are applied one after the other
+	SubmitNR(Network) (ApplyStatus, error)
+	// ApplyStatus returns the status of the last operation queued on the
+	// network resource of networkID
+	ApplyStatus(networkID NetID) (ApplyStatus, error)
+	// PlanNR returns the list of interfaces, addresses, wireguard peers and
+	// routes CreateNR would configure for the network, without touching the
+	// system. It can be used to review the changes before applying them
+	PlanNR(Network) (NetResourcePlan, error)
 	// Delete a network resource
 	DeleteNR(Network) error
 
+	// SetEgressPolicy sets the egress policy of the network resource of
+	// networkID on this node, nil removes the policy. The policy is applied
+	// without reconfiguring the rest of the network resource
+	SetEgressPolicy(networkID NetID, policy *EgressPolicy) error
+
+	// AddPeer adds a peer to the network resource of networkID on this node
+	// or updates the peer with the same subnet. Only the configuration of
+	// this peer is changed, the rest of the network resource is untouched
+	AddPeer(networkID NetID, peer Peer) error
+	// RemovePeer removes the peer with subnet prefix from the network
+	// resource of networkID on this node
+	RemovePeer(networkID NetID, prefix types.IPNet) error
+	// PeersStatus reports the connection state of the peers of the
+	// network resource of networkID on this node, to help debugging
+	// the network
+	PeersStatus(networkID NetID) ([]PeerStatus, error)
+
 	// Join a network (with network id) will create a new isolated namespace
 	// that is hooked to the network bridge with a veth pair, and assign it a
 	// new IP from the network resource range. The method return the new namespace
@@ -39,6 +78,33 @@ type Networker interface {
 	// Leave delete a container nameapce created by Join
 	Leave(networkdID NetID, containerID string) (err error)
 
+	// Neighbors returns the neighbor tables (ARP and NDP) of the network
+	// resource of networkID, or of the workload containerID of the network
+	// if not empty, to help debugging the network
+	Neighbors(networkID NetID, containerID string) ([]NeighborEntry, error)
+	// MoveIP moves the service ip to the workload containerID of the
+	// network networkID and removes it from the other workloads of the
+	// network. The move is announced with gratuitous ARP or unsolicited
+	// neighbor advertisements, so the other workloads and the network
+	// resource use the new workload right away. It's meant for the HA
+	// workloads that fail over a virtual ip
+	MoveIP(networkID NetID, containerID string, ip net.IP) error
+	// Offloads returns the state of the offload features (tx checksum,
+	// GSO, GRO) of the wireguard interface of the network resource of
+	// networkID, and of the veths of its workloads
+	Offloads(networkID NetID) ([]LinkOffloads, error)
+	// SocketStats returns a summary of the sockets (listening ports,
+	// connections per state, retransmissions) of the network resource of
+	// networkID, or of the workload containerID of the network if not
+	// empty, to tell a service that doesn't listen from a broken network
+	SocketStats(networkID NetID, containerID string) (SocketStats, error)
+	// VerifyIsolation probes the host and the network resources and
+	// workloads of the other networks of the node from the network
+	// resource of networkID, and reports every address it can reach, to
+	// check that the tenants are isolated from each other and from the
+	// node
+	VerifyIsolation(networkID NetID) (IsolationReport, error)
+
 	// ZDBPrepare creates a network namespace with a macvlan interface into it
 	// to allow the 0-db container to be publicly accessible
 	// it retusn the name of the network namespace created
@@ -46,8 +112,9 @@ type Networker interface {
 	ZDBPrepare(hw net.HardwareAddr) (string, error)
 
 	// SetupTap sets up a tap device in the network namespace for the networkID. It is hooked
-	// to the network bridge. The name of the tap interface is returned
-	SetupTap(networkID NetID) (string, error)
+	// to the network bridge and pinned to the MAC and the address ip of the VM.
+	// The name of the tap interface is returned
+	SetupTap(networkID NetID, mac net.HardwareAddr, ip net.IP) (string, error)
 
 	// RemoveTap removes the tap device from the network namespace
 	// of the networkID
@@ -71,10 +138,343 @@ type Networker interface {
 	DMZAddresses(ctx context.Context) <-chan NetlinkAddresses
 
 	PublicAddresses(ctx context.Context) <-chan NetlinkAddresses
+
+	// PublicAdvertisements monitoring streams for the configuration
+	// advertised by the routers of the public segment, it's sent every
+	// time it changes
+	PublicAdvertisements(ctx context.Context) <-chan RouterAdvertisement
+
+	// FloodCounters monitoring streams for the packets dropped by the
+	// DoS protection of the public namespace
+	FloodCounters(ctx context.Context) <-chan FloodCounters
+
+	// LLDPNeighbors returns the switch ports the physical interfaces of
+	// the node are connected to, as advertised by the switches with LLDP
+	LLDPNeighbors() ([]LLDPNeighbor, error)
+
+	// LLDPMonitor monitoring streams for the LLDP neighbors, the whole
+	// list is sent every time it changes
+	LLDPMonitor(ctx context.Context) <-chan []LLDPNeighbor
+
+	// LinkEvents monitoring streams for the changes of state of the
+	// physical interfaces, the bridges and the wireguard interfaces
+	LinkEvents(ctx context.Context) <-chan LinkEvent
+
+	// NamesAudit reports the names of the interfaces and namespaces derived
+	// from all the network resources stored on this node and flags the
+	// ones that collide. It only reads the stored state and doesn't
+	// touch the running system
+	NamesAudit() ([]InterfaceName, error)
+
+	// SysctlsAudit reports the sysctls set by the module in every
+	// namespace, with the value they had before the module set them
+	SysctlsAudit() ([]SysctlRecord, error)
+}
+
+// ApplyState is the state of an operation on a network resource
+type ApplyState string
+
+const (
+	// ApplyPending operations wait for the previous operations on the
+	// same network
+	ApplyPending ApplyState = "pending"
+	// ApplyApplying operations are being applied
+	ApplyApplying ApplyState = "applying"
+	// ApplyApplied operations succeeded
+	ApplyApplied ApplyState = "applied"
+	// ApplyFailed operations failed, Error holds the reason
+	ApplyFailed ApplyState = "failed"
+)
+
+// ApplyStatus is the status of the last operation queued on a network
+// resource
+type ApplyStatus struct {
+	NetID NetID      `json:"net_id"`
+	State ApplyState `json:"state"`
+	// Operation is the name of the operation (CreateNR, AddPeer, ...)
+	Operation string `json:"operation"`
+	// Pending is the number of operations of the network waiting
+	// to be applied
+	Pending int `json:"pending"`
+	// Namespace is the namespace of the network resource once a
+	// CreateNR is applied
+	Namespace string    `json:"namespace,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// PeerStatus is the connection state of a peer of a network resource
+type PeerStatus struct {
+	Subnet   types.IPNet `json:"subnet"`
+	ConnType ConnType    `json:"conn_type"`
+	// Endpoint is the endpoint currently used to reach the peer
+	Endpoint string `json:"endpoint"`
+	// LastHandshake is the time of the last wireguard handshake,
+	// zero if the peer never completed one
+	LastHandshake time.Time `json:"last_handshake"`
+	RxBytes       int64     `json:"rx_bytes"`
+	TxBytes       int64     `json:"tx_bytes"`
+	// Location of the endpoint, nil if unknown
+	Location *PeerLocation `json:"location,omitempty"`
+}
+
+// PeerLocation is where the endpoint of a peer lives
+type PeerLocation struct {
+	ASN     uint32 `json:"asn"`
+	Org     string `json:"org"`
+	Country string `json:"country"`
+}
+
+// NeighborEntry is an entry of a neighbor table (ARP or NDP)
+type NeighborEntry struct {
+	IP string `json:"ip"`
+	// MAC is empty while the neighbor is not resolved
+	MAC       string `json:"mac,omitempty"`
+	Interface string `json:"interface"`
+	// State is the state of the entry (reachable, stale, failed, ...)
+	State  string `json:"state"`
+	Router bool   `json:"router,omitempty"`
+}
+
+// IsolationReport is the result of the isolation checks of a network
+// resource
+type IsolationReport struct {
+	NetID NetID `json:"net_id"`
+	// Passed is true if no probe reached its target
+	Passed bool             `json:"passed"`
+	Probes []IsolationProbe `json:"probes"`
+}
+
+// IsolationProbe is a probe sent from the namespace of a network resource
+// to an address that must not be reachable
+type IsolationProbe struct {
+	// Target is host for the host namespace, or the id of the network
+	// of the target namespace
+	Target string `json:"target"`
+	// Namespace of the address, empty for the host namespace
+	Namespace string `json:"namespace"`
+	Address   string `json:"address"`
+	// Protocol is icmp or tcp
+	Protocol string `json:"protocol"`
+	Port     uint16 `json:"port,omitempty"`
+	// Reachable is true if the target answered, the isolation is broken
+	Reachable bool `json:"reachable"`
+	// Detail is the answer of the target, or why there was none
+	Detail string `json:"detail"`
+}
+
+// SocketStats is a summary of the sockets of a network namespace
+type SocketStats struct {
+	Namespace string `json:"namespace"`
+	// Listening are the listening TCP sockets and the bound UDP sockets
+	Listening []ListeningSocket `json:"listening"`
+	// TCPStates is the number of TCP sockets in every state (established,
+	// time_wait, ...)
+	TCPStates map[string]int `json:"tcp_states"`
+	// UDPConnected is the number of connected UDP sockets
+	UDPConnected int            `json:"udp_connected"`
+	Retransmits  TCPRetransmits `json:"retransmits"`
+}
+
+// ListeningSocket is a socket waiting for connections or datagrams
+type ListeningSocket struct {
+	// Protocol is tcp or udp
+	Protocol string `json:"protocol"`
+	// Address is the unspecified address if the socket listens on all
+	// the addresses
+	Address string `json:"address"`
+	Port    uint16 `json:"port"`
+}
+
+// TCPRetransmits are the retransmissions of the TCP sockets of a namespace
+type TCPRetransmits struct {
+	// OutSegments is the number of segments sent since the namespace
+	// was created
+	OutSegments uint64 `json:"out_segments"`
+	// RetransSegments is the number of segments retransmitted since the
+	// namespace was created
+	RetransSegments uint64 `json:"retrans_segments"`
+	// Sockets is the number of sockets that currently timed out waiting
+	// for an acknowledgement
+	Sockets int `json:"sockets"`
+}
+
+// LinkOffloads is the state of the offload features of an interface
+type LinkOffloads struct {
+	// Namespace of the interface, empty for the host namespace
+	Namespace string `json:"namespace"`
+	Link      string `json:"link"`
+	// Features are the offload features supported by the interface,
+	// named like the ethtool -K options (tx, gso, gro), and their state
+	Features map[string]bool `json:"features"`
+}
+
+// FloodCounters are the packets dropped by the DoS protection
+// of the public namespace
+type FloodCounters struct {
+	// SynDropped is the number of new TCP connections dropped
+	// because a source exceeded the SYN rate
+	SynDropped uint64 `json:"syn_dropped"`
+	// ConnLimited is the number of new connections dropped because
+	// a source exceeded the concurrent connections limit
+	ConnLimited uint64 `json:"conn_limited"`
+}
+
+// LinkKind is the kind of a monitored link
+type LinkKind string
+
+// the kinds of the monitored links
+const (
+	LinkNIC       LinkKind = "nic"
+	LinkBridge    LinkKind = "bridge"
+	LinkWireguard LinkKind = "wireguard"
+)
+
+// LinkEvent is a change of state of a link
+type LinkEvent struct {
+	// Namespace of the link, empty for the host namespace
+	Namespace string   `json:"namespace,omitempty"`
+	Interface string   `json:"interface"`
+	Kind      LinkKind `json:"kind"`
+	Up        bool     `json:"up"`
+	// Suppressed is true while the link flaps too much, its configuration
+	// is not re-applied when it recovers until it's stable again
+	Suppressed bool    `json:"suppressed"`
+	Penalty    float64 `json:"penalty"`
+	// Restored is the number of addresses and routes re-applied when
+	// the link recovered
+	Restored int       `json:"restored,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// LLDPNeighbor is the switch port a physical interface of the node is
+// connected to
+type LLDPNeighbor struct {
+	// Interface is the physical interface of the node
+	Interface string `json:"interface"`
+	// MAC is the address the switch advertises from
+	MAC               string `json:"mac"`
+	ChassisID         string `json:"chassis_id"`
+	PortID            string `json:"port_id"`
+	PortDescription   string `json:"port_description,omitempty"`
+	SystemName        string `json:"system_name,omitempty"`
+	SystemDescription string `json:"system_description,omitempty"`
+	// Expires is the time the neighbor is dropped if it's not
+	// advertised again
+	Expires time.Time `json:"expires"`
+}
+
+// AdvertisedPrefix is a prefix advertised by a router
+type AdvertisedPrefix struct {
+	Prefix types.IPNet `json:"prefix"`
+	// OnLink means the addresses of the prefix are on the segment
+	OnLink bool `json:"on_link"`
+	// Autonomous means the prefix is used to configure addresses (SLAAC)
+	Autonomous        bool          `json:"autonomous"`
+	ValidLifetime     time.Duration `json:"valid_lifetime"`
+	PreferredLifetime time.Duration `json:"preferred_lifetime"`
+}
+
+// RouterAdvertisement is the configuration advertised by a router. A
+// router with a zero lifetime and no prefix expired
+type RouterAdvertisement struct {
+	// Router is the link local address of the router
+	Router net.IP `json:"router"`
+	// Managed means the addresses are assigned with DHCPv6
+	Managed bool `json:"managed"`
+	// Other means the other settings are given with DHCPv6
+	Other bool `json:"other"`
+	// RouterLifetime is the time the router is a default router
+	RouterLifetime time.Duration      `json:"router_lifetime"`
+	Prefixes       []AdvertisedPrefix `json:"prefixes"`
+	// MTU of the segment, 0 if not advertised
+	MTU uint32 `json:"mtu"`
+}
+
+// NetResourcePlan is the list of objects configured on the node
+// for a network resource
+type NetResourcePlan struct {
+	NetID      NetID              `json:"net_id"`
+	Interfaces []PlannedInterface `json:"interfaces"`
+	Peers      []PlannedPeer      `json:"peers"`
+	Routes     []PlannedRoute     `json:"routes"`
+	// Collisions are the names of the plan already claimed
+	// by another network resource
+	Collisions []string `json:"collisions,omitempty"`
+}
+
+// PlannedInterface is an interface or namespace of a network resource plan
+type PlannedInterface struct {
+	Name string `json:"name"`
+	// Kind of object (namespace, bridge, macvlan, wireguard)
+	Kind string `json:"kind"`
+	// Namespace where the interface lives, empty for the host namespace
+	Namespace string        `json:"namespace,omitempty"`
+	Addresses []types.IPNet `json:"addresses,omitempty"`
+}
+
+// PlannedPeer is a wireguard peer of a network resource plan
+type PlannedPeer struct {
+	PublicKey  string        `json:"public_key"`
+	Endpoint   string        `json:"endpoint"`
+	AllowedIPs []types.IPNet `json:"allowed_ips"`
+	// PresharedKey is true if the peer uses a preshared key
+	PresharedKey bool `json:"preshared_key"`
+	// ConnType is the type of the connection to the peer
+	ConnType ConnType `json:"conn_type"`
+}
+
+// PlannedRoute is a route of a network resource plan
+type PlannedRoute struct {
+	// Iface is the name of the interface the route goes through
+	Iface   string      `json:"iface"`
+	Dst     types.IPNet `json:"dst"`
+	Gateway net.IP      `json:"gateway"`
+	// Table is the routing table of the route, 0 for the main table
+	Table int `json:"table"`
+	// Metric is the metric of the route, the lowest wins
+	Metric uint32 `json:"metric"`
+	// Type is blackhole or unreachable for the routes of the withdrawn
+	// subnets, these don't go through an interface
+	Type string `json:"type,omitempty"`
+}
+
+// InterfaceName is an entry of the names audit report
+type InterfaceName struct {
+	// Name of the interface or namespace
+	Name string `json:"name"`
+	// Kind of object (namespace, bridge, wireguard or tap)
+	Kind string `json:"kind"`
+	// NetID of the network resource the name is derived from
+	NetID NetID `json:"net_id"`
+	// Prefix is the subnet of the network resource on this node
+	Prefix types.IPNet `json:"prefix"`
+	// Owner is the network that currently holds the name in the
+	// names registry, empty if the name is not registered
+	Owner NetID `json:"owner"`
+	// Collision is true if more than one network resource
+	// maps to this name
+	Collision bool `json:"collision"`
+}
+
+// SysctlRecord is an entry of the sysctls audit report
+type SysctlRecord struct {
+	// Namespace of the sysctl, empty for the host namespace
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	// Default is the value of the sysctl before the module set it, it's
+	// restored when the module doesn't need it anymore
+	Default string `json:"default"`
 }
 
 // Network represent the description if a user private network
 type Network struct {
+	// SchemaVersion is the layout of the encoded network object. It is
+	// always set to NetworkSchemaVersion when the object is encoded, older
+	// layouts are upgraded when decoded (see UnmarshalJSON)
+	SchemaVersion int `json:"schema_version"`
+
 	Name string `json:"name"`
 	//unique id inside the reservation is an autoincrement (USE AS NET_ID)
 	NetID NetID `json:"net_id"`
@@ -95,6 +495,129 @@ type NetResource struct {
 	WGListenPort uint16 `json:"wg_listen_port"`
 
 	Peers []Peer `json:"peers"`
+
+	// Egress if set, the workloads of the network resource have no direct
+	// access to the outside world and must go through the proxy
+	Egress *EgressProxy `json:"egress,omitempty"`
+	// EgressPolicy if set, filters the destinations the workloads of the
+	// network resource can reach outside of the network
+	EgressPolicy *EgressPolicy `json:"egress_policy,omitempty"`
+
+	// Withdrawn is what happens to the traffic to the subnets no peer
+	// routes anymore, WithdrawBlackhole if empty
+	Withdrawn WithdrawAction `json:"withdrawn,omitempty"`
+
+	// MSSClamping is the clamping of the TCP MSS of the connections
+	// crossing the wireguard interface or leaving through the exit,
+	// MSSClampingAuto if empty
+	MSSClamping MSSClamping `json:"mss_clamping,omitempty"`
+}
+
+// MSSClamping is the clamping of the TCP MSS of the connections of the
+// workloads. The overlay packets are smaller than the frames of the
+// workloads, without clamping the connections rely on the path MTU
+// discovery, which fails if the ICMP errors are filtered on the way
+type MSSClamping string
+
+const (
+	// MSSClampingAuto clamps the MSS to the MTU of the route the
+	// connection takes
+	MSSClampingAuto MSSClamping = "auto"
+	// MSSClampingOff doesn't change the MSS
+	MSSClampingOff MSSClamping = "off"
+)
+
+// WithdrawAction is what happens to the traffic to a subnet once the
+// peer routing it is removed. Without withdrawal route, the traffic
+// follows the default route of the network resource, out of the exit
+type WithdrawAction string
+
+const (
+	// WithdrawBlackhole silently drops the traffic
+	WithdrawBlackhole WithdrawAction = "blackhole"
+	// WithdrawUnreachable rejects the traffic with an ICMP unreachable,
+	// the connections fail right away
+	WithdrawUnreachable WithdrawAction = "unreachable"
+	// WithdrawNone lets the traffic follow the default route
+	WithdrawNone WithdrawAction = "none"
+)
+
+// ProxyProtocol is the protocol of an egress proxy
+type ProxyProtocol string
+
+const (
+	// ProxySocks5 is a socks5 proxy
+	ProxySocks5 ProxyProtocol = "socks5"
+	// ProxyHTTP is an http proxy (with CONNECT support)
+	ProxyHTTP ProxyProtocol = "http"
+)
+
+// EgressProxy is the proxy used by the workloads of an egress restricted
+// network. The proxy itself is a workload of the network running on the
+// exit node, it's the only one allowed to reach the outside world
+type EgressProxy struct {
+	Protocol ProxyProtocol `json:"protocol"`
+	// Address of the proxy inside the network (ip:port)
+	Address string `json:"address"`
+}
+
+// IP returns the IP of the proxy
+func (e *EgressProxy) IP() net.IP {
+	host, _, err := net.SplitHostPort(e.Address)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// Env returns the environment variables configuring the proxy for the
+// usual tools. noProxy are the destinations reached without proxy
+// (usually the network IP range)
+func (e *EgressProxy) Env(noProxy ...string) []string {
+	url := fmt.Sprintf("%s://%s", e.Protocol, e.Address)
+
+	var env []string
+	switch e.Protocol {
+	case ProxyHTTP:
+		for _, name := range []string{"http_proxy", "https_proxy"} {
+			env = append(env, name+"="+url, strings.ToUpper(name)+"="+url)
+		}
+	case ProxySocks5:
+		env = append(env, "all_proxy="+url, "ALL_PROXY="+url)
+	}
+
+	noProxy = append([]string{"localhost", "127.0.0.1"}, noProxy...)
+	list := strings.Join(noProxy, ",")
+	env = append(env, "no_proxy="+list, "NO_PROXY="+list)
+
+	return env
+}
+
+// EgressPolicyMode is the mode of an egress policy
+type EgressPolicyMode string
+
+const (
+	// EgressAllow only allows the destinations matching the rules
+	EgressAllow EgressPolicyMode = "allow"
+	// EgressDeny denies the destinations matching the rules
+	EgressDeny EgressPolicyMode = "deny"
+)
+
+// EgressPolicy filters the traffic leaving a network resource
+// to the outside world
+type EgressPolicy struct {
+	Mode  EgressPolicyMode `json:"mode"`
+	Rules []EgressRule     `json:"rules"`
+}
+
+// EgressRule matches a destination of the egress traffic
+type EgressRule struct {
+	Dst types.IPNet `json:"dst"`
+	// Protocol tcp or udp, empty matches all protocols. It's
+	// required if ports are set
+	Protocol string `json:"protocol,omitempty"`
+	// Ports destination ports, empty matches all ports
+	Ports []uint16 `json:"ports,omitempty"`
 }
 
 // Peer is the description of a peer of a NetResource
@@ -105,6 +628,83 @@ type Peer struct {
 	WGPublicKey string        `json:"wg_public_key"`
 	AllowedIPs  []types.IPNet `json:"allowed_ips"`
 	Endpoint    string        `json:"endpoint"`
+	// Endpoints are alternative endpoints of the peer, usually on another
+	// address family (IPv6 if Endpoint is IPv4). The node moves the peer to
+	// the next endpoint when it didn't complete a handshake for a while
+	Endpoints []string `json:"endpoints,omitempty"`
+	// WGPresharedKey is the optional wireguard preshared key of the peer
+	// encrypted with the node public key and hex encoded, same as
+	// the network resource private key
+	WGPresharedKey string `json:"wg_preshared_key,omitempty"`
+
+	// Metric is the priority of the routes to the allowed subnets of the
+	// peer, see RouteMetric
+	Metric uint32 `json:"metric,omitempty"`
+
+	// ConnType is the type of the connection to the peer, wireguard if empty
+	ConnType ConnType `json:"conn_type,omitempty"`
+	// IPSec is the configuration of the connection if ConnType is ConnTypeIPSec
+	IPSec *IPSecConfig `json:"ipsec,omitempty"`
+}
+
+const (
+	// DefaultRouteMetric is the metric of the routes of a peer
+	// without metric
+	DefaultRouteMetric uint32 = 100
+	// ExitRouteMetric is the metric of the default routes (0.0.0.0/0 and
+	// ::/0) of a peer without metric, so a peer explicitly routing the
+	// default destination wins over the exit node
+	ExitRouteMetric uint32 = 1000
+)
+
+// RouteMetric returns the metric of the route of peer to dst.
+//
+// The traffic to a destination follows the longest prefix routed. When
+// several peers allow the same prefix, the peer with the lowest metric
+// routes it, and on equal metrics the peer with the lowest subnet. The
+// other peers don't get the traffic of this prefix until the winner
+// is removed
+func RouteMetric(peer *Peer, dst net.IPNet) uint32 {
+	if peer.Metric != 0 {
+		return peer.Metric
+	}
+
+	if ones, _ := dst.Mask.Size(); ones == 0 {
+		return ExitRouteMetric
+	}
+
+	return DefaultRouteMetric
+}
+
+// ConnType is the type of connection between two network resources
+type ConnType string
+
+const (
+	// ConnTypeWireguard connects the peers with wireguard
+	ConnTypeWireguard ConnType = "wireguard"
+	// ConnTypeIPSec connects the peers with an ESP tunnel programmed
+	// directly in the kernel, for underlays where wireguard is blocked
+	ConnTypeIPSec ConnType = "ipsec"
+)
+
+// IPSecConfig is the configuration of an IPSec connection with a peer.
+// The keys are encrypted with the node public key and hex encoded, same as
+// the wireguard private key. A decrypted key is 64 bytes: the AES-256 key
+// followed by the HMAC-SHA256 key
+type IPSecConfig struct {
+	// SPIIn is the security parameter index of the traffic from the peer
+	SPIIn uint32 `json:"spi_in"`
+	// SPIOut is the security parameter index of the traffic to the peer
+	SPIOut uint32 `json:"spi_out"`
+	// KeyIn is the key of the traffic from the peer
+	KeyIn string `json:"key_in"`
+	// KeyOut is the key of the traffic to the peer
+	KeyOut string `json:"key_out"`
+}
+
+// IsIPSec returns true if the peer is connected with IPSec
+func (p *Peer) IsIPSec() bool {
+	return p.ConnType == ConnTypeIPSec
 }
 
 // NetID is a type defining the ID of a network
@@ -0,0 +1,133 @@
+// Package announce tells the neighbors of a link that an address moved to
+// it, with gratuitous ARP for IPv4 and unsolicited neighbor advertisements
+// for IPv6. The neighbors update the MAC address of the address in their
+// caches, and the bridges learn the new port of the MAC address, without
+// waiting for the stale entries to expire.
+package announce
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Count is the number of announcements sent, in case some are lost
+	Count = 3
+	// Interval is the time between two announcements
+	Interval = 500 * time.Millisecond
+
+	typeNeighborAdvertisement = 136
+	optionTargetAddress       = 2
+	// flagOverride asks the neighbors to replace the address they cached
+	flagOverride = 0x20
+)
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arpPacket builds a gratuitous ARP request, the sender and the target
+// addresses are both ip
+func arpPacket(hw net.HardwareAddr, ip net.IP) []byte {
+	packet := []byte{
+		0x00, 0x01, // ethernet
+		0x08, 0x00, // ipv4
+		6, 4,
+		0x00, 0x01, // request
+	}
+	packet = append(packet, hw...)
+	packet = append(packet, ip.To4()...)
+	packet = append(packet, make([]byte, 6)...)
+	return append(packet, ip.To4()...)
+}
+
+// naPacket builds an unsolicited neighbor advertisement of ip, the checksum
+// is computed by the kernel
+func naPacket(hw net.HardwareAddr, ip net.IP) []byte {
+	packet := []byte{
+		typeNeighborAdvertisement, 0, 0, 0,
+		flagOverride, 0, 0, 0,
+	}
+	packet = append(packet, ip.To16()...)
+	packet = append(packet, optionTargetAddress, 1)
+	return append(packet, hw...)
+}
+
+// repeat sends the announcement Count times
+func repeat(send func() error) error {
+	for i := 0; i < Count; i++ {
+		if i != 0 {
+			time.Sleep(Interval)
+		}
+		if err := send(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendARP(iface *net.Interface, ip net.IP) error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return errors.Wrap(err, "failed to open packet socket")
+	}
+	defer unix.Close(fd)
+
+	// a datagram socket builds the ethernet header
+	addr := unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	packet := arpPacket(iface.HardwareAddr, ip)
+	return repeat(func() error {
+		return unix.Sendto(fd, packet, 0, &addr)
+	})
+}
+
+func sendNA(iface *net.Interface, ip net.IP) error {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return errors.Wrap(err, "failed to open icmpv6 socket")
+	}
+	defer unix.Close(fd)
+
+	if err := unix.BindToDevice(fd, iface.Name); err != nil {
+		return errors.Wrapf(err, "failed to bind to %s", iface.Name)
+	}
+
+	// the neighbor discovery messages must be sent with a hop limit of 255
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return err
+	}
+
+	// all the nodes of the link
+	addr := unix.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(addr.Addr[:], net.IPv6linklocalallnodes)
+
+	packet := naPacket(iface.HardwareAddr, ip)
+	return repeat(func() error {
+		return unix.Sendto(fd, packet, 0, &addr)
+	})
+}
+
+// Send announces that ip is now reachable through iface. It must be called
+// in the namespace of iface, and blocks until all the announcements are sent
+func Send(iface *net.Interface, ip net.IP) error {
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no ethernet address", iface.Name)
+	}
+
+	if ip.To4() != nil {
+		return errors.Wrap(sendARP(iface, ip), "failed to send gratuitous arp")
+	}
+
+	return errors.Wrap(sendNA(iface, ip), "failed to send unsolicited neighbor advertisement")
+}
@@ -0,0 +1,30 @@
+package announce
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var hw = net.HardwareAddr{0x36, 0x3c, 0x6a, 0x01, 0x02, 0x03}
+
+func TestARPPacket(t *testing.T) {
+	packet := arpPacket(hw, net.ParseIP("10.1.2.10"))
+
+	assert.Equal(t, []byte{
+		0x00, 0x01, 0x08, 0x00, 6, 4, 0x00, 0x01,
+		0x36, 0x3c, 0x6a, 0x01, 0x02, 0x03, 10, 1, 2, 10,
+		0, 0, 0, 0, 0, 0, 10, 1, 2, 10,
+	}, packet)
+}
+
+func TestNAPacket(t *testing.T) {
+	ip := net.ParseIP("fd12:3456:789a:2::10")
+	packet := naPacket(hw, ip)
+
+	assert.Len(t, packet, 32)
+	assert.Equal(t, []byte{typeNeighborAdvertisement, 0, 0, 0, flagOverride, 0, 0, 0}, packet[:8])
+	assert.Equal(t, []byte(ip.To16()), packet[8:24])
+	assert.Equal(t, []byte{optionTargetAddress, 1, 0x36, 0x3c, 0x6a, 0x01, 0x02, 0x03}, packet[24:])
+}
@@ -0,0 +1,221 @@
+// Package antispoof pins the ports of the workloads on the bridges of the
+// network resources to the MAC and the addresses of the workloads.
+//
+// The workloads of a network share the bridge of the network resource, so
+// a workload could answer the ARP requests or send the neighbor
+// advertisements of another workload, or send with its addresses, and
+// steal its traffic. The frames of a port are checked in the bridge family
+// of nftables before they are switched: the frames with another source MAC,
+// the ARP packets and the neighbor advertisements for another address, and
+// the packets with another source address are dropped.
+//
+// The ports are guarded against the rogue DHCP servers and routers too: the
+// DHCP and DHCPv6 replies, the router advertisements and the redirects sent
+// by a workload are dropped, and logged as violations a Monitor receives.
+// The taps of the VMs get the same chain as the veths of the containers.
+package antispoof
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/network/nft"
+)
+
+// Port is a port of a bridge and the addresses of the workload behind it
+type Port struct {
+	// Name of the port, the host end of the veth of a container or the
+	// tap of a VM
+	Name string
+	MAC  net.HardwareAddr
+	// IPs are the addresses the workload sends from, the link local
+	// address derived from MAC is always allowed
+	IPs []net.IP
+}
+
+// chain returns the name of the chain of the port name
+func chain(name string) string {
+	return "port-" + name
+}
+
+// LinkLocal returns the IPv6 link local address the kernel derives from mac
+// (EUI-64)
+func LinkLocal(mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	if len(mac) != 6 {
+		return ip
+	}
+
+	ip[8] = mac[0] ^ 0x02
+	ip[9], ip[10] = mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+
+	return ip
+}
+
+// guardData is the data of the rules of a port
+type guardData struct {
+	Name  string
+	Chain string
+	MAC   string
+	IPv4  []string
+	IPv6  []string
+	// the log of the violations
+	Prefix string
+	Group  int
+	Limit  string
+}
+
+func newGuardData(port Port) (guardData, error) {
+	if len(port.Name) == 0 {
+		return guardData{}, fmt.Errorf("port name is required")
+	}
+	if len(port.MAC) != 6 {
+		return guardData{}, fmt.Errorf("invalid MAC address '%s' of port %s", port.MAC, port.Name)
+	}
+
+	data := guardData{
+		Name:  port.Name,
+		Chain: chain(port.Name),
+		MAC:   port.MAC.String(),
+		IPv6:  []string{LinkLocal(port.MAC).String()},
+
+		Prefix: logPrefix,
+		Group:  LogGroup,
+		Limit:  logLimit,
+	}
+
+	for _, ip := range port.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			data.IPv4 = append(data.IPv4, ip4.String())
+		} else if ip.To16() != nil {
+			data.IPv6 = append(data.IPv6, ip.String())
+		}
+	}
+
+	return data, nil
+}
+
+var funcs = template.FuncMap{
+	"join": func(values []string) string {
+		return strings.Join(values, ", ")
+	},
+}
+
+// the violations of the guards are logged to the nflog group LogGroup, with
+// the prefix "<logPrefix> <kind> <port> ". The logs are rate limited per
+// port and kind, the frames over the limit are still dropped
+const logLimit = "10/minute"
+
+// the table and the chain the frames of the ports go through, a port is
+// only checked once it's in the ports map
+const skeleton = `
+table bridge antispoof {
+  map ports {
+    type ifname : verdict;
+  }
+
+  chain prerouting {
+    type filter hook prerouting priority -200; policy accept;
+  }
+}
+flush chain bridge antispoof prerouting
+add rule bridge antispoof prerouting iifname vmap @ports
+`
+
+var guardTmpl = template.Must(template.New("guard").Funcs(funcs).Parse(skeleton + `
+add chain bridge antispoof {{.Chain}}
+flush chain bridge antispoof {{.Chain}}
+table bridge antispoof {
+  chain {{.Chain}} {
+    # only the network resource answers DHCP and advertises routes
+    udp sport 67 limit rate {{.Limit}} log prefix "{{.Prefix}} dhcp {{.Name}} " group {{.Group}}
+    udp sport 67 counter drop
+    udp sport 547 limit rate {{.Limit}} log prefix "{{.Prefix}} dhcp6 {{.Name}} " group {{.Group}}
+    udp sport 547 counter drop
+    icmpv6 type { nd-router-advert, nd-redirect } limit rate {{.Limit}} log prefix "{{.Prefix}} ra {{.Name}} " group {{.Group}}
+    icmpv6 type { nd-router-advert, nd-redirect } counter drop
+
+    ether saddr != {{.MAC}} counter drop
+    ether type != { ip, ip6, arp } counter drop
+
+    arp saddr ether != {{.MAC}} counter drop
+    {{- if .IPv4}}
+    # 0.0.0.0 is the sender of the ARP probes
+    arp saddr ip != { 0.0.0.0, {{join .IPv4}} } counter drop
+    ip saddr != { {{join .IPv4}} } counter drop
+    {{- else}}
+    arp saddr ip != 0.0.0.0 counter drop
+    ether type ip counter drop
+    {{- end}}
+
+    # :: is the source of the duplicate address detection and of the
+    # multicast listener reports of a tentative address
+    ip6 saddr :: meta l4proto != ipv6-icmp counter drop
+    ip6 saddr :: icmpv6 type != { nd-neighbor-solicit, mld-listener-report, mld2-listener-report } counter drop
+    ip6 saddr != { ::, {{join .IPv6}} } counter drop
+    icmpv6 type nd-neighbor-advert icmpv6 taddr != { {{join .IPv6}} } counter drop
+  }
+}
+add element bridge antispoof ports { "{{.Name}}" : jump {{.Chain}} }
+`))
+
+// the chain and the map element are added first, so the port can be
+// released even if it's not guarded
+var releaseTmpl = template.Must(template.New("release").Parse(skeleton + `
+add chain bridge antispoof {{.Chain}}
+add element bridge antispoof ports { "{{.Name}}" : jump {{.Chain}} }
+delete element bridge antispoof ports { "{{.Name}}" }
+flush chain bridge antispoof {{.Chain}}
+delete chain bridge antispoof {{.Chain}}
+`))
+
+func renderGuard(w io.Writer, port Port) error {
+	data, err := newGuardData(port)
+	if err != nil {
+		return err
+	}
+
+	return guardTmpl.Execute(w, data)
+}
+
+func renderRelease(w io.Writer, name string) error {
+	return releaseTmpl.Execute(w, guardData{Name: name, Chain: chain(name)})
+}
+
+// Guard pins port to its MAC and its addresses, the rules of a port that
+// is already guarded are replaced
+func Guard(port Port) error {
+	var buf bytes.Buffer
+	if err := renderGuard(&buf, port); err != nil {
+		return err
+	}
+
+	if err := nft.Apply(&buf, ""); err != nil {
+		return errors.Wrapf(err, "failed to guard port %s", port.Name)
+	}
+
+	return nil
+}
+
+// Release removes the rules of the port name, it's a no-op if the port is
+// not guarded
+func Release(name string) error {
+	var buf bytes.Buffer
+	if err := renderRelease(&buf, name); err != nil {
+		return err
+	}
+
+	if err := nft.Apply(&buf, ""); err != nil {
+		return errors.Wrapf(err, "failed to release port %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package antispoof
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkLocal(t *testing.T) {
+	mac, err := net.ParseMAC("52:54:00:12:34:56")
+	require.NoError(t, err)
+
+	assert.Equal(t, "fe80::5054:ff:fe12:3456", LinkLocal(mac).String())
+}
+
+func TestRenderGuard(t *testing.T) {
+	mac, err := net.ParseMAC("52:54:00:12:34:56")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = renderGuard(&buf, Port{
+		Name: "veth1a2b3c4d",
+		MAC:  mac,
+		IPs:  []net.IP{net.ParseIP("10.1.1.5"), net.ParseIP("fd1c:a4f3:b78a:a::5"), net.ParseIP("10.1.1.50")},
+	})
+	require.NoError(t, err)
+	rules := buf.String()
+
+	assert.Contains(t, rules, "add rule bridge antispoof prerouting iifname vmap @ports")
+	assert.Contains(t, rules, "flush chain bridge antispoof port-veth1a2b3c4d")
+	assert.Contains(t, rules, "ether saddr != 52:54:00:12:34:56 counter drop")
+	assert.Contains(t, rules, "arp saddr ether != 52:54:00:12:34:56 counter drop")
+	assert.Contains(t, rules, "arp saddr ip != { 0.0.0.0, 10.1.1.5, 10.1.1.50 } counter drop")
+	assert.Contains(t, rules, "ip saddr != { 10.1.1.5, 10.1.1.50 } counter drop")
+	assert.Contains(t, rules, "ip6 saddr != { ::, fe80::5054:ff:fe12:3456, fd1c:a4f3:b78a:a::5 } counter drop")
+	assert.Contains(t, rules, "icmpv6 type nd-neighbor-advert icmpv6 taddr != { fe80::5054:ff:fe12:3456, fd1c:a4f3:b78a:a::5 } counter drop")
+	assert.Contains(t, rules, `add element bridge antispoof ports { "veth1a2b3c4d" : jump port-veth1a2b3c4d }`)
+	assert.NotContains(t, rules, "ether type ip counter drop")
+
+	// the rogue DHCP servers and routers
+	assert.Contains(t, rules, `udp sport 67 limit rate 10/minute log prefix "zos-guard dhcp veth1a2b3c4d " group 7`)
+	assert.Contains(t, rules, "udp sport 67 counter drop")
+	assert.Contains(t, rules, `udp sport 547 limit rate 10/minute log prefix "zos-guard dhcp6 veth1a2b3c4d " group 7`)
+	assert.Contains(t, rules, "udp sport 547 counter drop")
+	assert.Contains(t, rules, `icmpv6 type { nd-router-advert, nd-redirect } limit rate 10/minute log prefix "zos-guard ra veth1a2b3c4d " group 7`)
+	assert.Contains(t, rules, "icmpv6 type { nd-router-advert, nd-redirect } counter drop")
+
+	// no IPv4 address
+	buf.Reset()
+	require.NoError(t, renderGuard(&buf, Port{Name: "veth1a2b3c4d", MAC: mac}))
+	rules = buf.String()
+	assert.Contains(t, rules, "arp saddr ip != 0.0.0.0 counter drop")
+	assert.Contains(t, rules, "ether type ip counter drop")
+	assert.Contains(t, rules, "ip6 saddr != { ::, fe80::5054:ff:fe12:3456 } counter drop")
+
+	assert.Error(t, renderGuard(&buf, Port{Name: "veth1a2b3c4d"}))
+	assert.Error(t, renderGuard(&buf, Port{MAC: mac}))
+}
+
+func TestRenderGuardTap(t *testing.T) {
+	mac, err := net.ParseMAC("06:a1:b2:c3:d4:e5")
+	require.NoError(t, err)
+
+	// the tap of a VM shares the bridge with the containers, it gets the
+	// same chain so the VM can't act as a DHCP server or a router either
+	var buf bytes.Buffer
+	err = renderGuard(&buf, Port{
+		Name: "t-a1b2c3d4e5f6",
+		MAC:  mac,
+		IPs:  []net.IP{net.ParseIP("10.1.1.20")},
+	})
+	require.NoError(t, err)
+	rules := buf.String()
+
+	assert.Contains(t, rules, `add element bridge antispoof ports { "t-a1b2c3d4e5f6" : jump port-t-a1b2c3d4e5f6 }`)
+	assert.Contains(t, rules, `udp sport 67 limit rate 10/minute log prefix "zos-guard dhcp t-a1b2c3d4e5f6 " group 7`)
+	assert.Contains(t, rules, "udp sport 67 counter drop")
+	assert.Contains(t, rules, `udp sport 547 limit rate 10/minute log prefix "zos-guard dhcp6 t-a1b2c3d4e5f6 " group 7`)
+	assert.Contains(t, rules, "udp sport 547 counter drop")
+	assert.Contains(t, rules, `icmpv6 type { nd-router-advert, nd-redirect } limit rate 10/minute log prefix "zos-guard ra t-a1b2c3d4e5f6 " group 7`)
+	assert.Contains(t, rules, "icmpv6 type { nd-router-advert, nd-redirect } counter drop")
+	assert.Contains(t, rules, "ether saddr != 06:a1:b2:c3:d4:e5 counter drop")
+	assert.Contains(t, rules, "ip saddr != { 10.1.1.20 } counter drop")
+}
+
+func TestRenderRelease(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, renderRelease(&buf, "veth1a2b3c4d"))
+	rules := buf.String()
+
+	assert.Contains(t, rules, `delete element bridge antispoof ports { "veth1a2b3c4d" }`)
+	assert.Contains(t, rules, "delete chain bridge antispoof port-veth1a2b3c4d")
+}
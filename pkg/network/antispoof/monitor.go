@@ -0,0 +1,261 @@
+package antispoof
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/network/nlmsg"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// LogGroup is the nflog group the violations are logged to
+	LogGroup = 7
+	// logPrefix starts the prefix of the logged violations
+	logPrefix = "zos-guard"
+)
+
+// the kinds of violations
+const (
+	// KindDHCP is a DHCP reply sent by a workload
+	KindDHCP = "dhcp"
+	// KindDHCPv6 is a DHCPv6 reply sent by a workload
+	KindDHCPv6 = "dhcp6"
+	// KindRouterAdvert is a router advertisement or a redirect sent by a
+	// workload
+	KindRouterAdvert = "ra"
+)
+
+// the nflog netlink messages and attributes, from
+// linux/netfilter/nfnetlink_log.h
+const (
+	nfnlSubsysULog  = 4
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaHWAddr  = 8
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	nfulaCfgCmd      = 1
+	nfulaCfgMode     = 2
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+
+	// copyRange is the length of the packets copied to the monitor, the
+	// headers are enough to tell the source
+	copyRange = 128
+
+	nfgenmsgLen = 4
+
+	// readTimeout is the max time Read waits for a violation
+	readTimeout = time.Second
+)
+
+// Violation is a frame sent by a workload and dropped by the guard of its
+// port
+type Violation struct {
+	Kind string `json:"kind"`
+	// Port is the port of the workload on the bridge
+	Port string `json:"port"`
+	// MAC and Source are the source MAC and the source address of the
+	// frame, they are empty if the frame was not copied
+	MAC    string    `json:"mac,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func (v Violation) String() string {
+	var what string
+	switch v.Kind {
+	case KindDHCP:
+		what = "rogue DHCP reply"
+	case KindDHCPv6:
+		what = "rogue DHCPv6 reply"
+	case KindRouterAdvert:
+		what = "rogue router advertisement"
+	default:
+		what = fmt.Sprintf("%s violation", v.Kind)
+	}
+
+	return fmt.Sprintf("%s from port %s (mac: %s, source: %s)", what, v.Port, v.MAC, v.Source)
+}
+
+// source returns the source address of the IPv4 or IPv6 packet
+func source(packet []byte) net.IP {
+	if len(packet) == 0 {
+		return nil
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) >= 20 {
+			return net.IP(append([]byte(nil), packet[12:16]...))
+		}
+	case 6:
+		if len(packet) >= 40 {
+			return net.IP(append([]byte(nil), packet[8:24]...))
+		}
+	}
+
+	return nil
+}
+
+// parseViolation parses the attributes of a nflog packet message, after
+// its nfgenmsg header. ok is false if the packet was not logged by a guard
+func parseViolation(data []byte) (v Violation, ok bool, err error) {
+	err = nlmsg.Attrs(data, func(typ uint16, value []byte) error {
+		switch typ {
+		case nfulaPrefix:
+			fields := strings.Fields(strings.TrimRight(string(value), "\x00"))
+			if len(fields) == 3 && fields[0] == logPrefix {
+				v.Kind, v.Port = fields[1], fields[2]
+				ok = true
+			}
+		case nfulaHWAddr:
+			// struct nfulnl_msg_packet_hw: length, padding, address
+			if len(value) < 4 {
+				return nil
+			}
+			length := int(binary.BigEndian.Uint16(value[0:2]))
+			if length > 0 && 4+length <= len(value) {
+				v.MAC = net.HardwareAddr(value[4 : 4+length]).String()
+			}
+		case nfulaPayload:
+			if ip := source(value); ip != nil {
+				v.Source = ip.String()
+			}
+		}
+		return nil
+	})
+
+	return v, ok, err
+}
+
+// parseViolations parses the violations of a netlink datagram, the other
+// messages are skipped
+func parseViolations(data []byte, received time.Time) ([]Violation, error) {
+	var violations []Violation
+	err := nlmsg.Messages(data, func(typ uint16, payload []byte) error {
+		if typ != nfnlSubsysULog<<8|nfulnlMsgPacket || len(payload) < nfgenmsgLen {
+			return nil
+		}
+
+		v, ok, err := parseViolation(payload[nfgenmsgLen:])
+		if err != nil {
+			return err
+		}
+		if ok {
+			v.Time = received
+			violations = append(violations, v)
+		}
+		return nil
+	})
+
+	return violations, err
+}
+
+// configMessage builds a nflog config message of the group with the
+// attribute typ
+func configMessage(seq uint32, group uint16, typ uint16, value []byte) []byte {
+	// nfgenmsg: family, version, and the group in network order
+	payload := make([]byte, nfgenmsgLen)
+	payload[0] = unix.AF_UNSPEC
+	binary.BigEndian.PutUint16(payload[2:], group)
+	payload = append(payload, nlmsg.Attr(typ, value)...)
+
+	return nlmsg.Message(nfnlSubsysULog<<8|nfulnlMsgConfig, unix.NLM_F_REQUEST|unix.NLM_F_ACK, seq, payload)
+}
+
+// Monitor receives the violations logged by the guards of the ports
+type Monitor struct {
+	fd  int
+	buf []byte
+}
+
+// Listen starts receiving the violations of the guards, from the namespace
+// of the calling thread. A single monitor can listen at a time
+func Listen() (*Monitor, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Monitor{fd: fd, buf: make([]byte, 64<<10)}
+	if err := m.setup(); err != nil {
+		m.Close()
+		return nil, errors.Wrapf(err, "failed to bind nflog group %d", LogGroup)
+	}
+
+	return m, nil
+}
+
+func (m *Monitor) setup() error {
+	if err := unix.Bind(m.fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	// struct nfulnl_msg_config_mode: copy range, copy mode, padding
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:], copyRange)
+	mode[4] = nfulnlCopyPacket
+
+	requests := [][]byte{
+		configMessage(1, LogGroup, nfulaCfgCmd, []byte{nfulnlCfgCmdBind}),
+		configMessage(2, LogGroup, nfulaCfgMode, mode),
+	}
+	for _, request := range requests {
+		if err := m.request(request); err != nil {
+			return err
+		}
+	}
+
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	return unix.SetsockoptTimeval(m.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+}
+
+// request sends the message msg and waits for its ack
+func (m *Monitor) request(msg []byte) error {
+	if err := unix.Sendto(m.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	n, _, err := unix.Recvfrom(m.fd, m.buf, 0)
+	if err != nil {
+		return err
+	}
+
+	if n < unix.SizeofNlMsghdr {
+		return fmt.Errorf("unexpected answer to nflog config")
+	}
+
+	return nlmsg.Messages(m.buf[:n], func(typ uint16, payload []byte) error {
+		if typ != unix.NLMSG_ERROR {
+			return fmt.Errorf("unexpected answer to nflog config")
+		}
+		return nlmsg.Error(payload)
+	})
+}
+
+// Read returns the violations logged since the last read, it returns no
+// violation if none was logged within readTimeout, and unix.ENOBUFS if some
+// were lost
+func (m *Monitor) Read() ([]Violation, error) {
+	n, _, err := unix.Recvfrom(m.fd, m.buf, 0)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return parseViolations(m.buf[:n], time.Now())
+}
+
+// Close stops receiving the violations
+func (m *Monitor) Close() error {
+	return unix.Close(m.fd)
+}
@@ -0,0 +1,88 @@
+package antispoof
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/network/nlmsg"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func attr(typ uint16, value []byte) []byte {
+	return nlmsg.Attr(typ, value)
+}
+
+func message(typ uint16, attrs ...[]byte) []byte {
+	// nfgenmsg: family, version, group
+	payload := []byte{unix.AF_BRIDGE, 0, 0, LogGroup}
+	for _, a := range attrs {
+		payload = append(payload, a...)
+	}
+
+	return nlmsg.Message(typ, 0, 0, payload)
+}
+
+func TestParseViolations(t *testing.T) {
+	received := time.Unix(1590000000, 0)
+
+	hw := []byte{0, 6, 0, 0, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0, 0}
+	// the IPv6 header of a router advertisement from fe80::1
+	ra := make([]byte, 40)
+	ra[0] = 0x60
+	ra[8], ra[9], ra[23] = 0xfe, 0x80, 0x01
+	// the IPv4 header of a DHCP reply from 10.1.1.5
+	dhcp := make([]byte, 20)
+	dhcp[0] = 0x45
+	copy(dhcp[12:], []byte{10, 1, 1, 5})
+
+	data := message(nfnlSubsysULog<<8|nfulnlMsgPacket,
+		attr(nfulaPrefix, []byte("zos-guard ra veth1a2b3c4d \x00")),
+		attr(nfulaHWAddr, hw),
+		attr(nfulaPayload, ra),
+	)
+	data = append(data, message(nfnlSubsysULog<<8|nfulnlMsgPacket,
+		attr(nfulaPrefix, []byte("zos-guard dhcp veth5e6f7a8b \x00")),
+		attr(nfulaPayload, dhcp),
+	)...)
+	// logged by someone else
+	data = append(data, message(nfnlSubsysULog<<8|nfulnlMsgPacket,
+		attr(nfulaPrefix, []byte("dropped \x00")),
+		attr(nfulaPayload, dhcp),
+	)...)
+
+	violations, err := parseViolations(data, received)
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+
+	assert.Equal(t, Violation{
+		Kind:   KindRouterAdvert,
+		Port:   "veth1a2b3c4d",
+		MAC:    "52:54:00:12:34:56",
+		Source: "fe80::1",
+		Time:   received,
+	}, violations[0])
+	assert.Equal(t, Violation{
+		Kind:   KindDHCP,
+		Port:   "veth5e6f7a8b",
+		Source: "10.1.1.5",
+		Time:   received,
+	}, violations[1])
+
+	_, err = parseViolations(data[:len(data)-3], received)
+	assert.Error(t, err)
+}
+
+func TestConfigMessage(t *testing.T) {
+	msg := configMessage(1, LogGroup, nfulaCfgCmd, []byte{nfulnlCfgCmdBind})
+
+	require.Len(t, msg, unix.SizeofNlMsghdr+nfgenmsgLen+8)
+	assert.Equal(t, uint32(len(msg)), nl.NativeEndian().Uint32(msg[0:]))
+	assert.Equal(t, uint16(nfnlSubsysULog<<8|nfulnlMsgConfig), nl.NativeEndian().Uint16(msg[4:]))
+	assert.Equal(t, uint16(LogGroup), binary.BigEndian.Uint16(msg[unix.SizeofNlMsghdr+2:]))
+	// the attribute length is not padded
+	assert.Equal(t, uint16(5), nl.NativeEndian().Uint16(msg[unix.SizeofNlMsghdr+nfgenmsgLen:]))
+}
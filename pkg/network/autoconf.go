@@ -0,0 +1,276 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/ra"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/zinit"
+)
+
+const (
+	// dhcp6Service is the zinit service of the DHCPv6 client of the
+	// public interface
+	dhcp6Service = "dhcp6-public"
+
+	// advertisementsCheck is the time between two checks of the lifetimes
+	// of the routers
+	advertisementsCheck = 10 * time.Second
+	// listenTimeout is the time without advertisement after which the
+	// routers are solicited again, the listener is lost if the namespace
+	// is created again
+	listenTimeout = 30 * time.Minute
+	// dhcp6Timeout is the max time the DHCPv6 client takes to stop
+	dhcp6Timeout = 10 * time.Second
+)
+
+// the modes of the DHCPv6 client
+const (
+	dhcp6None = ""
+	// dhcp6Stateful requests an address and a prefix
+	dhcp6Stateful = "stateful"
+	// dhcp6Stateless only requests the other settings
+	dhcp6Stateless = "stateless"
+)
+
+// lifetime returns the time the configuration advertised by adv is valid
+func lifetime(adv *ra.Advertisement) time.Duration {
+	valid := adv.RouterLifetime
+	for _, prefix := range adv.Prefixes {
+		if prefix.ValidLifetime > valid {
+			valid = prefix.ValidLifetime
+		}
+	}
+
+	return valid
+}
+
+// watchAdvertisements streams the configuration advertised by each router
+// of the interface iface of the namespace netns every time it changes. A
+// router that stopped advertising once its configuration expired is sent
+// with no prefix and a zero lifetime
+func watchAdvertisements(ctx context.Context, netns, iface string) <-chan ra.Advertisement {
+	ch := make(chan ra.Advertisement)
+
+	go func() {
+		defer close(ch)
+
+		routers := make(map[string]ra.Advertisement)
+		seen := make(map[string]time.Time)
+
+		send := func(adv ra.Advertisement) bool {
+			select {
+			case ch <- adv:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for ctx.Err() == nil {
+			listenCtx, cancel := context.WithCancel(ctx)
+			advertisements, err := ra.Listen(listenCtx, netns, iface)
+			if err != nil {
+				cancel()
+				log.Debug().Err(err).Str("namespace", netns).Msg("failed to listen to router advertisements")
+				select {
+				case <-ctx.Done():
+				case <-time.After(advertisementsCheck):
+				}
+				continue
+			}
+
+			received := time.Now()
+		listen:
+			for {
+				select {
+				case <-ctx.Done():
+					break listen
+				case adv, ok := <-advertisements:
+					if !ok {
+						break listen
+					}
+
+					key := adv.Router.String()
+					received = time.Now()
+					seen[key] = received
+					if last, ok := routers[key]; ok && last.Equal(&adv) {
+						continue
+					}
+
+					routers[key] = adv
+					if !send(adv) {
+						break listen
+					}
+				case <-time.After(advertisementsCheck):
+					for key, adv := range routers {
+						if time.Since(seen[key]) <= lifetime(&adv) {
+							continue
+						}
+
+						delete(routers, key)
+						delete(seen, key)
+						if !send(ra.Advertisement{Router: adv.Router}) {
+							break listen
+						}
+					}
+
+					if time.Since(received) > listenTimeout {
+						break listen
+					}
+				}
+			}
+
+			cancel()
+		}
+	}()
+
+	return ch
+}
+
+// routerAdvertisement converts an advertisement to its zbus type
+func routerAdvertisement(adv *ra.Advertisement) pkg.RouterAdvertisement {
+	result := pkg.RouterAdvertisement{
+		Router:         adv.Router,
+		Managed:        adv.Managed,
+		Other:          adv.Other,
+		RouterLifetime: adv.RouterLifetime,
+		MTU:            adv.MTU,
+	}
+
+	for _, prefix := range adv.Prefixes {
+		result.Prefixes = append(result.Prefixes, pkg.AdvertisedPrefix{
+			Prefix:            types.NewIPNet(&prefix.Prefix),
+			OnLink:            prefix.OnLink,
+			Autonomous:        prefix.Autonomous,
+			ValidLifetime:     prefix.ValidLifetime,
+			PreferredLifetime: prefix.PreferredLifetime,
+		})
+	}
+
+	return result
+}
+
+// PublicAdvertisements implements pkg.Networker interface
+func (n *networker) PublicAdvertisements(ctx context.Context) <-chan pkg.RouterAdvertisement {
+	ch := make(chan pkg.RouterAdvertisement)
+	go func() {
+		defer close(ch)
+		for adv := range watchAdvertisements(ctx, types.PublicNamespace, types.PublicIface) {
+			select {
+			case ch <- routerAdvertisement(&adv):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// dhcp6Mode returns the mode of the DHCPv6 client required by the routers
+func dhcp6Mode(routers map[string]ra.Advertisement) string {
+	mode := dhcp6None
+	for _, adv := range routers {
+		if adv.Managed {
+			return dhcp6Stateful
+		}
+		if adv.Other {
+			mode = dhcp6Stateless
+		}
+	}
+
+	return mode
+}
+
+// runDHCP6 (re)starts the DHCPv6 client of the public interface in mode,
+// it's stopped if mode is dhcp6None. The client renews its lease itself
+func runDHCP6(z *zinit.Client, mode string) error {
+	services, err := z.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list zinit services")
+	}
+
+	if _, ok := services[dhcp6Service]; ok {
+		if err := z.StopWait(dhcp6Timeout, dhcp6Service); err != nil {
+			return errors.Wrap(err, "failed to stop DHCPv6 client")
+		}
+		if err := z.Forget(dhcp6Service); err != nil {
+			return errors.Wrap(err, "failed to forget DHCPv6 client")
+		}
+	}
+
+	if mode == dhcp6None {
+		return zinit.RemoveService(dhcp6Service)
+	}
+
+	// -d also requests a prefix, it's ignored by the servers that don't
+	// delegate any
+	flags := "-d"
+	if mode == dhcp6Stateless {
+		// only requests the other settings
+		flags = "-l"
+	}
+
+	err = zinit.AddService(dhcp6Service, zinit.InitService{
+		Exec: fmt.Sprintf("ip netns exec %s udhcpc6 -f %s -i %s -s /usr/share/udhcp/simple.script",
+			types.PublicNamespace, flags, types.PublicIface),
+		Log: zinit.StdoutLogType,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to add zinit service")
+	}
+
+	return z.Monitor(dhcp6Service)
+}
+
+// WatchPublicAutoconf follows the router advertisements of the public
+// segment until ctx is canceled. The changes are logged, and the DHCPv6
+// client of the public interface is run if the routers assign the addresses
+// or the other settings with DHCPv6
+func WatchPublicAutoconf(ctx context.Context, z *zinit.Client) {
+	routers := make(map[string]ra.Advertisement)
+	mode := dhcp6None
+
+	// the client of a previous run is started again once a router asks
+	// for it
+	if err := runDHCP6(z, dhcp6None); err != nil {
+		log.Error().Err(err).Msg("failed to stop DHCPv6 client of public interface")
+	}
+
+	for adv := range watchAdvertisements(ctx, types.PublicNamespace, types.PublicIface) {
+		logger := log.With().Str("router", adv.Router.String()).Logger()
+		if lifetime(&adv) == 0 {
+			logger.Warn().Msg("public router expired")
+			delete(routers, adv.Router.String())
+		} else {
+			event := logger.Info().
+				Bool("managed", adv.Managed).
+				Bool("other", adv.Other).
+				Dur("router-lifetime", adv.RouterLifetime).
+				Uint32("mtu", adv.MTU)
+			for _, prefix := range adv.Prefixes {
+				event = event.Str(prefix.Prefix.String(), fmt.Sprintf("autonomous=%t valid=%s", prefix.Autonomous, prefix.ValidLifetime))
+			}
+			event.Msg("public router advertisement changed")
+			routers[adv.Router.String()] = adv
+		}
+
+		required := dhcp6Mode(routers)
+		if required == mode {
+			continue
+		}
+
+		log.Info().Str("mode", required).Msg("configure DHCPv6 on public interface")
+		if err := runDHCP6(z, required); err != nil {
+			log.Error().Err(err).Msg("failed to configure DHCPv6 on public interface")
+			continue
+		}
+		mode = required
+	}
+}
@@ -0,0 +1,358 @@
+// Package bgp runs the BGP speaker of the exit node. The speaker is gobgpd,
+// it runs in the public namespace and announces the public IPv6 prefixes of
+// the node to the upstream routers of the farm, so the farmer doesn't have to
+// configure static routes to the exit node on the edge of the farm.
+//
+// The speaker is optional, it only runs if the farmer sets the routers it
+// peers with on the boot media of the farm.
+package bgp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/network/prefix"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/zinit"
+)
+
+// the kernel parameters configuring the speaker
+const (
+	asnParam     = "bgp-asn"
+	peerParam    = "bgp-peer"
+	peerASNParam = "bgp-peer-asn"
+	prefixParam  = "bgp-prefix"
+)
+
+const (
+	// DefaultASN is the AS number of the node if the farmer doesn't set
+	// one, the first private 4 bytes AS number
+	DefaultASN uint32 = 4200000000
+
+	// service is the name of the zinit service of the speaker
+	service = "gobgpd"
+	// api is the address of the API of the speaker, it's only reachable
+	// from the public namespace
+	api = "127.0.0.1:50051"
+
+	// controlTimeout is the max time of a gobgp command
+	controlTimeout = 10 * time.Second
+	// readyTimeout is the max time the speaker takes to serve its API
+	readyTimeout = 30 * time.Second
+)
+
+// Config is the configuration of the speaker set by the farmer
+type Config struct {
+	// ASN is the AS number of the node
+	ASN uint32
+	// PeerASN is the AS number of the upstream routers
+	PeerASN uint32
+	// Peers are the addresses of the upstream routers
+	Peers []net.IP
+	// Prefixes are the IPv6 prefixes routed to the node, announced on top
+	// of the prefix of the public interface
+	Prefixes []net.IPNet
+}
+
+// Enabled returns true if the speaker must run
+func (c Config) Enabled() bool {
+	return len(c.Peers) != 0
+}
+
+// ConfigFromParams reads the speaker configuration from the kernel
+// parameters. bgp-peer=<ip> is the address of an upstream router, it can be
+// set more than once and enables the speaker. bgp-peer-asn=<asn> is the AS
+// number of the routers, bgp-asn=<asn> the one of the node. bgp-prefix=<cidr>
+// announces an IPv6 prefix routed to the node, it can be set more than once
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{ASN: DefaultASN}
+
+	values, _ := params.Get(peerParam)
+	for _, value := range values {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return config, fmt.Errorf("invalid bgp peer '%s'", value)
+		}
+		config.Peers = append(config.Peers, ip)
+	}
+
+	if !config.Enabled() {
+		return config, nil
+	}
+
+	var err error
+	if values, ok := params.Get(asnParam); ok && len(values) != 0 {
+		if config.ASN, err = parseASN(values[0]); err != nil {
+			return config, err
+		}
+	}
+
+	values, ok := params.Get(peerASNParam)
+	if !ok || len(values) == 0 {
+		return config, fmt.Errorf("%s is required to peer with '%s'", peerASNParam, config.Peers[0])
+	}
+	if config.PeerASN, err = parseASN(values[0]); err != nil {
+		return config, err
+	}
+
+	values, _ = params.Get(prefixParam)
+	for _, value := range values {
+		_, ipnet, err := net.ParseCIDR(value)
+		if err != nil || ipnet.IP.To4() != nil {
+			return config, fmt.Errorf("invalid bgp prefix '%s', must be an IPv6 prefix", value)
+		}
+		config.Prefixes = append(config.Prefixes, *ipnet)
+	}
+
+	return config, nil
+}
+
+func parseASN(value string) (uint32, error) {
+	asn, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || asn == 0 {
+		return 0, fmt.Errorf("invalid AS number '%s'", value)
+	}
+
+	return uint32(asn), nil
+}
+
+// speaker is the configuration file of gobgpd
+type speaker struct {
+	ASN      uint32
+	PeerASN  uint32
+	RouterID string
+	Peers    []string
+}
+
+var gobgpdConf = template.Must(template.New("gobgpd").Parse(`# generated by networkd, don't edit
+[global.config]
+  as = {{.ASN}}
+  router-id = "{{.RouterID}}"
+  # the node opens the sessions, nothing listens on the public namespace
+  port = -1
+{{- range .Peers}}
+
+[[neighbors]]
+  [neighbors.config]
+    neighbor-address = "{{.}}"
+    peer-as = {{$.PeerASN}}
+  [[neighbors.afi-safis]]
+    [neighbors.afi-safis.config]
+      afi-safi-name = "ipv6-unicast"
+{{- end}}
+`))
+
+// Speaker announces the public prefixes of the exit node
+type Speaker struct {
+	root   string
+	config Config
+	zinit  *zinit.Client
+
+	mu sync.Mutex
+	// announced are the prefixes in the rib of the running speaker
+	announced []net.IPNet
+}
+
+// NewSpeaker creates the speaker, its configuration is kept under root
+func NewSpeaker(root string, config Config, z *zinit.Client) (*Speaker, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create bgp directory")
+	}
+
+	return &Speaker{
+		root:   root,
+		config: config,
+		zinit:  z,
+	}, nil
+}
+
+func (s *Speaker) path() string {
+	return filepath.Join(s.root, service+".toml")
+}
+
+// routerID returns the router id of the node, its public IPv4 address or
+// the last 4 bytes of its public IPv6 address
+func routerID(iface *types.PubIface) net.IP {
+	if !iface.IPv4.Nil() {
+		if ip := iface.IPv4.IP.To4(); ip != nil {
+			return ip
+		}
+	}
+
+	ip := iface.IPv6.IP.To16()
+	return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+}
+
+// prefixes returns the prefixes announced for iface
+func (s *Speaker) prefixes(iface *types.PubIface) []net.IPNet {
+	public := net.IPNet{
+		IP:   iface.IPv6.IP.Mask(iface.IPv6.Mask),
+		Mask: iface.IPv6.Mask,
+	}
+
+	return prefix.Aggregate(append([]net.IPNet{public}, s.config.Prefixes...))
+}
+
+// Announce announces the public prefixes of the exit interface iface, the
+// prefixes announced for the previous configuration are withdrawn
+func (s *Speaker) Announce(iface *types.PubIface) error {
+	if !s.config.Enabled() {
+		return nil
+	}
+
+	if iface.IPv6.Nil() {
+		return fmt.Errorf("public interface has no IPv6 address to announce")
+	}
+
+	if _, err := exec.LookPath("gobgpd"); err != nil {
+		return errors.Wrap(err, "gobgpd is not installed")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conf := speaker{
+		ASN:      s.config.ASN,
+		PeerASN:  s.config.PeerASN,
+		RouterID: routerID(iface).String(),
+	}
+	for _, peer := range s.config.Peers {
+		conf.Peers = append(conf.Peers, peer.String())
+	}
+
+	if err := s.start(&conf); err != nil {
+		return errors.Wrap(err, "failed to start bgp speaker")
+	}
+
+	prefixes := s.prefixes(iface)
+	for _, stale := range s.announced {
+		if contains(prefixes, stale) {
+			continue
+		}
+
+		if err := s.control("global", "rib", "del", "-a", "ipv6", stale.String()); err != nil {
+			return errors.Wrapf(err, "failed to withdraw prefix %s", stale.String())
+		}
+		log.Info().Str("prefix", stale.String()).Msg("prefix withdrawn")
+	}
+
+	s.announced = nil
+	for _, p := range prefixes {
+		// adding a prefix again replaces its path, it's safe on a prefix
+		// that is already announced
+		err := s.control("global", "rib", "add", "-a", "ipv6", p.String(), "nexthop", iface.IPv6.IP.String())
+		if err != nil {
+			return errors.Wrapf(err, "failed to announce prefix %s", p.String())
+		}
+		s.announced = append(s.announced, p)
+		log.Info().Str("prefix", p.String()).Msg("prefix announced")
+	}
+
+	return nil
+}
+
+// start writes the configuration of the speaker and (re)starts it if the
+// configuration changed, the rib of a restarted speaker is empty
+func (s *Speaker) start(conf *speaker) error {
+	var buf bytes.Buffer
+	if err := gobgpdConf.Execute(&buf, conf); err != nil {
+		return err
+	}
+
+	current, err := ioutil.ReadFile(s.path())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	services, err := s.zinit.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list zinit services")
+	}
+
+	state, ok := services[service]
+	if ok && state.Is(zinit.ServiceStateRunning) && bytes.Equal(current, buf.Bytes()) {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(s.path(), buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	s.announced = nil
+	if ok {
+		if err := s.zinit.StopWait(controlTimeout, service); err != nil {
+			return err
+		}
+		if err := s.zinit.Start(service); err != nil {
+			return err
+		}
+	} else {
+		err = zinit.AddService(service, zinit.InitService{
+			Exec: fmt.Sprintf("ip netns exec %s gobgpd -t toml -f %s --api-hosts %s", types.PublicNamespace, s.path(), api),
+			Log:  zinit.StdoutLogType,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to add zinit service")
+		}
+
+		if err := s.zinit.Monitor(service); err != nil {
+			return err
+		}
+	}
+
+	return s.ready()
+}
+
+// ready waits for the speaker to serve its API
+func (s *Speaker) ready() error {
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		err := s.control("global")
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Wrap(err, "bgp speaker is not answering")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *Speaker) control(args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+
+	host, port, _ := net.SplitHostPort(api)
+	args = append([]string{"netns", "exec", types.PublicNamespace, "gobgp", "-u", host, "-p", port}, args...)
+	output, err := exec.CommandContext(ctx, "ip", args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "gobgp failed: %s", strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func contains(prefixes []net.IPNet, p net.IPNet) bool {
+	for _, candidate := range prefixes {
+		if candidate.String() == p.String() {
+			return true
+		}
+	}
+
+	return false
+}
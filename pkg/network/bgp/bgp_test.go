@@ -0,0 +1,98 @@
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.False(t, config.Enabled())
+
+	config, err = ConfigFromParams(kernel.Params{
+		"bgp-peer":     {"2a02:1807:1100::1", "185.69.167.129"},
+		"bgp-peer-asn": {"65001"},
+		"bgp-prefix":   {"2a02:1807:1200::/48"},
+	})
+	require.NoError(t, err)
+	assert.True(t, config.Enabled())
+	assert.Equal(t, DefaultASN, config.ASN)
+	assert.Equal(t, uint32(65001), config.PeerASN)
+	require.Len(t, config.Peers, 2)
+	assert.Equal(t, "185.69.167.129", config.Peers[1].String())
+	require.Len(t, config.Prefixes, 1)
+	assert.Equal(t, "2a02:1807:1200::/48", config.Prefixes[0].String())
+
+	config, err = ConfigFromParams(kernel.Params{
+		"bgp-peer":     {"2a02:1807:1100::1"},
+		"bgp-peer-asn": {"65001"},
+		"bgp-asn":      {"65010"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(65010), config.ASN)
+
+	for _, params := range []kernel.Params{
+		{"bgp-peer": {"router"}},
+		{"bgp-peer": {"2a02:1807:1100::1"}},
+		{"bgp-peer": {"2a02:1807:1100::1"}, "bgp-peer-asn": {"0"}},
+		{"bgp-peer": {"2a02:1807:1100::1"}, "bgp-peer-asn": {"65001"}, "bgp-asn": {"4294967296"}},
+		{"bgp-peer": {"2a02:1807:1100::1"}, "bgp-peer-asn": {"65001"}, "bgp-prefix": {"185.69.167.0/24"}},
+	} {
+		_, err := ConfigFromParams(params)
+		assert.Error(t, err, "%v", params)
+	}
+}
+
+func TestGobgpdConf(t *testing.T) {
+	conf := speaker{
+		ASN:      DefaultASN,
+		PeerASN:  65001,
+		RouterID: "185.69.167.130",
+		Peers:    []string{"2a02:1807:1100::1", "185.69.167.129"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gobgpdConf.Execute(&buf, &conf))
+	output := buf.String()
+
+	assert.Contains(t, output, "  as = 4200000000\n  router-id = \"185.69.167.130\"\n")
+	assert.Contains(t, output, "  port = -1\n")
+	assert.Contains(t, output, "    neighbor-address = \"2a02:1807:1100::1\"\n    peer-as = 65001\n")
+	assert.Contains(t, output, "    neighbor-address = \"185.69.167.129\"\n    peer-as = 65001\n")
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("afi-safi-name = \"ipv6-unicast\"")))
+}
+
+func TestPrefixes(t *testing.T) {
+	ip, ipnet, err := net.ParseCIDR("2a02:1807:1100::10/64")
+	require.NoError(t, err)
+
+	iface := types.PubIface{
+		IPv6: types.NewIPNet(&net.IPNet{IP: ip, Mask: ipnet.Mask}),
+	}
+
+	_, routed, err := net.ParseCIDR("2a02:1807:1200::/48")
+	require.NoError(t, err)
+	_, covered, err := net.ParseCIDR("2a02:1807:1200:1::/64")
+	require.NoError(t, err)
+
+	s := Speaker{config: Config{Prefixes: []net.IPNet{*routed, *covered}}}
+	var prefixes []string
+	for _, p := range s.prefixes(&iface) {
+		prefixes = append(prefixes, p.String())
+	}
+	assert.Equal(t, []string{"2a02:1807:1100::/64", "2a02:1807:1200::/48"}, prefixes)
+
+	assert.Equal(t, "0.0.0.16", routerID(&iface).String())
+
+	ip4, ipnet4, err := net.ParseCIDR("185.69.167.130/26")
+	require.NoError(t, err)
+	iface.IPv4 = types.NewIPNet(&net.IPNet{IP: ip4, Mask: ipnet4.Mask})
+	assert.Equal(t, "185.69.167.130", routerID(&iface).String())
+}
@@ -5,10 +5,10 @@ import (
 
 	"github.com/threefoldtech/zos/pkg/network/types"
 
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg/network/bridge"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -35,7 +35,7 @@ func CreateDefaultBridge(name string) (*netlink.Bridge, error) {
 		return nil, err
 	}
 
-	if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "0"); err != nil {
+	if err := sysctl.Set(sysctl.Host, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "0"); err != nil {
 		return nil, errors.Wrapf(err, "failed to disable ip6 on bridge %s", name)
 	}
 	return br, nil
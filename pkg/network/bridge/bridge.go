@@ -5,14 +5,15 @@ import (
 	"os"
 
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 	"github.com/vishvananda/netlink"
 )
 
-// New creates a bridge and set it up
+// New creates a bridge with the MTU of the farm network and set it up
 func New(name string) (*netlink.Bridge, error) {
 	attrs := netlink.NewLinkAttrs()
 	attrs.Name = name
-	attrs.MTU = 1500
+	attrs.MTU = mtu.Farm()
 	bridge := &netlink.Bridge{LinkAttrs: attrs}
 
 	if err := netlink.LinkAdd(bridge); err != nil && !os.IsExist(err) {
@@ -34,6 +35,13 @@ func New(name string) (*netlink.Bridge, error) {
 		return nil, fmt.Errorf("%q already exists but is not a bridge", name)
 	}
 
+	// the bridge may exist from before the farm network used jumbo frames
+	if newBr.Attrs().MTU != attrs.MTU {
+		if err := netlink.LinkSetMTU(newBr, attrs.MTU); err != nil {
+			return nil, errors.Wrapf(err, "failed to set mtu of %s", name)
+		}
+	}
+
 	return newBr, nil
 }
 
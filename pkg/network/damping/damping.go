@@ -0,0 +1,90 @@
+// Package damping implements the flap damping of the links.
+//
+// Every flap of a link adds a penalty that decays exponentially with time.
+// The link is suppressed once its penalty goes above the suppress threshold,
+// and released once it decays below the reuse threshold. A link that flaps
+// once in a while is never suppressed, one that keeps flapping is ignored
+// until it's stable again.
+package damping
+
+import (
+	"math"
+	"time"
+)
+
+// Config is the configuration of the damping
+type Config struct {
+	// Penalty is added on every flap
+	Penalty float64
+	// Suppress is the penalty above which the link is suppressed
+	Suppress float64
+	// Reuse is the penalty below which a suppressed link is released
+	Reuse float64
+	// Max caps the penalty, so a link is never suppressed longer than it
+	// takes Max to decay to Reuse
+	Max float64
+	// HalfLife is the time the penalty takes to decay by half
+	HalfLife time.Duration
+}
+
+// DefaultConfig suppresses a link after 3 quick flaps, a link suppressed
+// after a long flapping is released after 1 hour at most
+var DefaultConfig = Config{
+	Penalty:  1000,
+	Suppress: 2500,
+	Reuse:    750,
+	Max:      12000,
+	HalfLife: 15 * time.Minute,
+}
+
+// Damper is the damping state of a link, it's not safe to use from several
+// goroutines
+type Damper struct {
+	config     Config
+	penalty    float64
+	updated    time.Time
+	suppressed bool
+}
+
+// New creates the damping state of a link that never flapped
+func New(config Config) *Damper {
+	return &Damper{config: config}
+}
+
+// decay brings the penalty up to now
+func (d *Damper) decay(now time.Time) {
+	if !d.updated.IsZero() && now.After(d.updated) {
+		halves := float64(now.Sub(d.updated)) / float64(d.config.HalfLife)
+		d.penalty *= math.Pow(0.5, halves)
+	}
+	d.updated = now
+
+	if d.suppressed && d.penalty < d.config.Reuse {
+		d.suppressed = false
+	}
+}
+
+// Flap records a flap of the link at now, it returns true if the link is
+// suppressed
+func (d *Damper) Flap(now time.Time) bool {
+	d.decay(now)
+
+	d.penalty = math.Min(d.penalty+d.config.Penalty, d.config.Max)
+	if d.penalty > d.config.Suppress {
+		d.suppressed = true
+	}
+
+	return d.suppressed
+}
+
+// Suppressed returns true if the link is suppressed at now
+func (d *Damper) Suppressed(now time.Time) bool {
+	d.decay(now)
+	return d.suppressed
+}
+
+// Penalty returns the penalty of the link at now
+func (d *Damper) Penalty(now time.Time) float64 {
+	d.decay(now)
+	return d.penalty
+}
@@ -0,0 +1,56 @@
+package damping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlapping(t *testing.T) {
+	d := New(DefaultConfig)
+	now := time.Now()
+
+	assert.False(t, d.Flap(now))
+	assert.False(t, d.Flap(now.Add(time.Second)))
+	// the third quick flap suppresses the link
+	now = now.Add(2 * time.Second)
+	assert.True(t, d.Flap(now))
+	assert.True(t, d.Suppressed(now))
+
+	// still above reuse after one half life
+	now = now.Add(DefaultConfig.HalfLife)
+	assert.True(t, d.Suppressed(now))
+	assert.InDelta(t, 1500, d.Penalty(now), 5)
+
+	// released after two
+	now = now.Add(DefaultConfig.HalfLife)
+	assert.False(t, d.Suppressed(now))
+
+	// released links are not suppressed again by a single flap
+	assert.False(t, d.Flap(now))
+}
+
+func TestSlowFlapping(t *testing.T) {
+	d := New(DefaultConfig)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		assert.False(t, d.Flap(now))
+		now = now.Add(DefaultConfig.HalfLife)
+	}
+}
+
+func TestMaxPenalty(t *testing.T) {
+	d := New(DefaultConfig)
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		d.Flap(now)
+	}
+	assert.Equal(t, DefaultConfig.Max, d.Penalty(now))
+
+	// max decays to reuse in 4 half lives
+	assert.True(t, d.Suppressed(now.Add(3*DefaultConfig.HalfLife)))
+	assert.False(t, d.Suppressed(now.Add(4*DefaultConfig.HalfLife+time.Minute)))
+}
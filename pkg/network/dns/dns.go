@@ -0,0 +1,671 @@
+// Package dns runs the DNS cache of the node. The cache is unbound, a
+// validating resolver: it resolves the names from the root servers (or from
+// the forwarders set by the farmer) and checks the DNSSEC signatures of the
+// answers, so a tampered answer for the hub or the explorer is rejected
+// instead of being served to the modules.
+//
+// The node resolves through the cache once it's running. If the cache stops
+// answering the node can't resolve names until it's back, unless the farmer
+// allowed the fallback to the resolvers received with DHCP.
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/zinit"
+)
+
+// the kernel parameters configuring the cache
+const (
+	disableParam   = "nodnscache"
+	forwardParam   = "dns-forward"
+	workloadsParam = "dns-workloads"
+	fallbackParam  = "dns-fallback"
+
+	// forwardDHCP forwards to the resolvers received with DHCP
+	forwardDHCP = "dhcp"
+)
+
+const (
+	// ResolvConf is the resolver configuration of the node
+	ResolvConf = "/etc/resolv.conf"
+	// WorkloadsAddress is the address of the cache of the workloads, it's
+	// the gateway of the network resources in the ndmz namespace
+	WorkloadsAddress = "100.127.0.1"
+
+	// checkInterval is the time between two checks of the cache
+	checkInterval = 30 * time.Second
+	// controlTimeout is the max time of an unbound-control command
+	controlTimeout = 10 * time.Second
+	// startTimeout is the time the cache has to answer once started
+	startTimeout = 10 * time.Second
+)
+
+// Config is the configuration of the cache set by the farmer
+type Config struct {
+	// Disabled keeps the node on the resolvers received with DHCP
+	Disabled bool
+	// Forwarders are the resolvers the queries are sent to, the names are
+	// resolved from the root servers if empty. They must pass the DNSSEC
+	// records for the answers to be validated
+	Forwarders []string
+	// ForwardDHCP forwards the queries to the resolvers received with DHCP
+	ForwardDHCP bool
+	// Workloads also serves the workloads, on WorkloadsAddress
+	Workloads bool
+	// Fallback makes the node resolve through the resolvers received with
+	// DHCP, without validation, while the cache is not answering
+	Fallback bool
+}
+
+// ConfigFromParams reads the cache configuration from the kernel parameters.
+// nodnscache disables the cache, dns-forward=<ip> forwards the queries to a
+// resolver instead of resolving them from the root servers, it can be set
+// more than once or to dhcp to use the resolvers received with DHCP.
+// dns-workloads makes the cache available to the workloads and dns-fallback
+// allows the resolvers received with DHCP while the cache is not answering
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{
+		Disabled:  params.Exists(disableParam),
+		Workloads: params.Exists(workloadsParam),
+		Fallback:  params.Exists(fallbackParam),
+	}
+
+	values, _ := params.Get(forwardParam)
+	for _, value := range values {
+		if value == forwardDHCP {
+			config.ForwardDHCP = true
+			continue
+		}
+
+		if net.ParseIP(value) == nil {
+			return config, fmt.Errorf("invalid dns forwarder '%s'", value)
+		}
+		config.Forwarders = append(config.Forwarders, value)
+	}
+
+	return config, nil
+}
+
+// instance is a process of the cache
+type instance struct {
+	// Name is the name of the zinit service
+	Name string
+	// Namespace the instance runs in, empty for the host namespace
+	Namespace string
+	// Listen is the address the instance answers on
+	Listen string
+	// Allow is the range of the clients of the instance
+	Allow string
+
+	Root       string
+	Forwarders []string
+}
+
+func (i *instance) config() string {
+	return filepath.Join(i.Root, i.Name+".conf")
+}
+
+func (i *instance) anchor() string {
+	return filepath.Join(i.Root, i.Name+".key")
+}
+
+var unboundConf = template.Must(template.New("unbound").Parse(`# generated by networkd, don't edit
+server:
+	interface: {{.Listen}}
+	access-control: {{.Allow}} allow
+	do-daemonize: no
+	username: ""
+	chroot: ""
+	directory: "{{.Root}}"
+	pidfile: ""
+	use-syslog: no
+	logfile: ""
+	verbosity: 1
+	val-log-level: 1
+	auto-trust-anchor-file: "{{.Root}}/{{.Name}}.key"
+	harden-dnssec-stripped: yes
+	harden-glue: yes
+	qname-minimisation: yes
+	prefetch: yes
+	msg-cache-size: 16m
+	rrset-cache-size: 32m
+	cache-max-ttl: 86400
+	extended-statistics: yes
+	statistics-cumulative: yes
+remote-control:
+	control-enable: yes
+	control-interface: "/var/run/{{.Name}}.ctl"
+{{- if .Forwarders}}
+forward-zone:
+	name: "."
+{{- range .Forwarders}}
+	forward-addr: {{.}}
+{{- end}}
+{{- end}}
+`))
+
+// Cache implements pkg.DNSCache
+type Cache struct {
+	root      string
+	config    Config
+	zinit     *zinit.Client
+	resolv    string
+	namespace string
+
+	mu        sync.Mutex
+	instances []*instance
+	// err is why the cache is not answering
+	err error
+	// fallback is set while the node resolves through the
+	// resolvers received with DHCP
+	fallback bool
+}
+
+var _ pkg.DNSCache = (*Cache)(nil)
+
+// NewCache creates the DNS cache, its configuration is kept under root.
+// namespace is the namespace the cache of the workloads runs in
+func NewCache(root string, config Config, z *zinit.Client, namespace string) (*Cache, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create dns cache directory")
+	}
+
+	return &Cache{
+		root:      root,
+		config:    config,
+		zinit:     z,
+		resolv:    ResolvConf,
+		namespace: namespace,
+	}, nil
+}
+
+// upstream is the copy of the resolver configuration received with DHCP
+func (c *Cache) upstream() string {
+	return filepath.Join(c.root, "resolv.upstream")
+}
+
+// nameservers returns the nameservers of a resolv.conf
+func nameservers(data []byte) []string {
+	var servers []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+
+	return servers
+}
+
+// resolvConf is the resolver configuration of the node once the cache runs
+const resolvConf = "# generated by networkd, the resolvers received with DHCP are in %s\nnameserver 127.0.0.1\n"
+
+func (c *Cache) cached() []byte {
+	return []byte(fmt.Sprintf(resolvConf, c.upstream()))
+}
+
+func (c *Cache) forwarders() []string {
+	if !c.config.ForwardDHCP {
+		return c.config.Forwarders
+	}
+
+	data, err := ioutil.ReadFile(c.upstream())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read the resolvers received with DHCP")
+	}
+
+	return append(nameservers(data), c.config.Forwarders...)
+}
+
+// start writes the configuration of the instances and starts them
+func (c *Cache) start() error {
+	if _, err := exec.LookPath("unbound"); err != nil {
+		return errors.Wrap(err, "unbound is not installed")
+	}
+
+	instances := []*instance{
+		{Name: "unbound", Listen: "127.0.0.1", Allow: "127.0.0.0/8"},
+	}
+	if c.config.Workloads {
+		// the traffic of the workloads is natted to the address of their
+		// network resource in the ndmz
+		instances = append(instances, &instance{
+			Name:      "unbound-workloads",
+			Namespace: c.namespace,
+			Listen:    WorkloadsAddress,
+			Allow:     "100.127.0.0/16",
+		})
+	}
+
+	forwarders := c.forwarders()
+	for _, inst := range instances {
+		inst.Root = c.root
+		inst.Forwarders = forwarders
+
+		if err := c.startInstance(inst); err != nil {
+			return errors.Wrapf(err, "failed to start '%s'", inst.Name)
+		}
+	}
+
+	c.instances = instances
+	return nil
+}
+
+func writeConfig(inst *instance) error {
+	var buf bytes.Buffer
+	if err := unboundConf.Execute(&buf, inst); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(inst.config(), buf.Bytes(), 0644)
+}
+
+func (c *Cache) startInstance(inst *instance) error {
+	if err := writeConfig(inst); err != nil {
+		return err
+	}
+
+	// the anchor of the root zone is updated by unbound once it's running,
+	// unbound-anchor creates it from its builtin key if it can't be fetched
+	output, err := exec.Command("unbound-anchor", "-a", inst.anchor()).CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); err != nil && (!ok || exit.ExitCode() != 1) {
+		// 1 means the anchor was updated
+		log.Warn().Err(err).Str("output", string(output)).Msg("failed to update DNSSEC root anchor")
+	}
+
+	exe := fmt.Sprintf("unbound -d -c %s", inst.config())
+	if len(inst.Namespace) != 0 {
+		exe = fmt.Sprintf("ip netns exec %s %s", inst.Namespace, exe)
+	}
+
+	services, err := c.zinit.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list zinit services")
+	}
+
+	if _, ok := services[inst.Name]; ok {
+		// started before networkd restarted, it's restarted to load
+		// the new configuration
+		if err := c.zinit.StopWait(controlTimeout, inst.Name); err != nil {
+			return err
+		}
+		return c.zinit.Start(inst.Name)
+	}
+
+	err = zinit.AddService(inst.Name, zinit.InitService{
+		Exec: exe,
+		Log:  zinit.StdoutLogType,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to add zinit service")
+	}
+
+	return c.zinit.Monitor(inst.Name)
+}
+
+func (c *Cache) control(ctx context.Context, inst *instance, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, controlTimeout)
+	defer cancel()
+
+	args = append([]string{"-c", inst.config()}, args...)
+	output, err := exec.CommandContext(ctx, "unbound-control", args...).CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unbound-control failed: %s", strings.TrimSpace(string(output)))
+	}
+
+	return output, nil
+}
+
+// status checks that the cache answers, it's retried for wait
+func (c *Cache) status(ctx context.Context, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		_, err := c.control(ctx, c.instances[0], "status")
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// refresh sends the queries to the resolvers of a new DHCP lease, if the
+// cache forwards to them
+func (c *Cache) refresh(ctx context.Context) error {
+	forwarders := c.forwarders()
+	for _, inst := range c.instances {
+		if equal(inst.Forwarders, forwarders) {
+			continue
+		}
+
+		// the configuration is written for the next start of the instance,
+		// and the running instance is changed without losing its cache
+		update := *inst
+		update.Forwarders = forwarders
+		if err := writeConfig(&update); err != nil {
+			return err
+		}
+
+		args := []string{"forward", "off"}
+		if len(forwarders) != 0 {
+			args = append([]string{"forward"}, forwarders...)
+		}
+		if _, err := c.control(ctx, inst, args...); err != nil {
+			return errors.Wrapf(err, "failed to update the forwarders of '%s'", inst.Name)
+		}
+
+		log.Info().Str("instance", inst.Name).Strs("forwarders", forwarders).Msg("dns forwarders updated")
+		inst.Forwarders = forwarders
+	}
+
+	return nil
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ensure points the node to the cache. While the cache is not answering
+// the node can't resolve names, or it resolves through the resolvers
+// received with DHCP if the fallback is allowed. The state of the cache is
+// reported by Stats
+func (c *Cache) ensure(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, err := ioutil.ReadFile(c.resolv)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	leased := len(current) != 0 && !bytes.Equal(current, c.cached())
+	if leased {
+		// written by the DHCP client, on a new lease
+		if err := ioutil.WriteFile(c.upstream(), current, 0644); err != nil {
+			return errors.Wrap(err, "failed to keep the resolvers received with DHCP")
+		}
+	}
+
+	// a cache that just started is given some time to answer
+	var wait time.Duration
+	if len(c.instances) == 0 {
+		if err := c.start(); err != nil {
+			c.err = err
+			return err
+		}
+		wait = startTimeout
+	} else if leased {
+		if err := c.refresh(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to update the dns forwarders")
+		}
+	}
+
+	c.err = c.status(ctx, wait)
+
+	target := c.cached()
+	switch {
+	case c.err == nil:
+		if c.fallback || !bytes.Equal(current, target) {
+			log.Info().Msg("resolving through the dns cache")
+		}
+		c.fallback = false
+	case c.config.Fallback:
+		log.Error().Err(c.err).Msg("dns cache is not answering, resolving through the resolvers received with DHCP without validation")
+		upstream, err := ioutil.ReadFile(c.upstream())
+		if err != nil {
+			return err
+		}
+		target = upstream
+		c.fallback = true
+	default:
+		log.Error().Err(c.err).Msg("dns cache is not answering, names can't be resolved until it's back")
+	}
+
+	if bytes.Equal(current, target) {
+		return nil
+	}
+
+	return ioutil.WriteFile(c.resolv, target, 0644)
+}
+
+// Run starts the cache and keeps the node resolving through it until ctx
+// is canceled
+func (c *Cache) Run(ctx context.Context) {
+	if c.config.Disabled {
+		log.Info().Msg("dns cache is disabled")
+		return
+	}
+
+	for {
+		if err := c.ensure(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to run dns cache")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+func (c *Cache) running() ([]*instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.instances) == 0 {
+		return nil, fmt.Errorf("dns cache is not running")
+	}
+
+	return c.instances, nil
+}
+
+// RestartWorkloads restarts the cache of the workloads, it must be restarted
+// when the ndmz namespace is created again
+func (c *Cache) RestartWorkloads() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, inst := range c.instances {
+		if len(inst.Namespace) == 0 {
+			continue
+		}
+
+		if err := c.zinit.StopWait(controlTimeout, inst.Name); err != nil {
+			return errors.Wrapf(err, "failed to stop '%s'", inst.Name)
+		}
+		if err := c.zinit.Start(inst.Name); err != nil {
+			return errors.Wrapf(err, "failed to start '%s'", inst.Name)
+		}
+	}
+
+	return nil
+}
+
+// parseStats parses the output of unbound-control stats_noreset
+func parseStats(data []byte, stats *pkg.DNSStats) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key, value := parts[0], parts[1]
+		if key == "total.recursion.time.avg" {
+			avg, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value of '%s'", key)
+			}
+			stats.RecursionTime = avg
+			continue
+		}
+
+		var counter *uint64
+		switch key {
+		case "total.num.queries":
+			counter = &stats.Queries
+		case "total.num.cachehits":
+			counter = &stats.CacheHits
+		case "total.num.cachemiss":
+			counter = &stats.CacheMisses
+		case "total.num.prefetch":
+			counter = &stats.Prefetches
+		case "num.answer.secure":
+			counter = &stats.Secure
+		case "num.answer.bogus":
+			counter = &stats.Bogus
+		case "num.answer.rcode.SERVFAIL":
+			counter = &stats.ServFail
+		case "msg.cache.count", "rrset.cache.count":
+			counter = &stats.Records
+		default:
+			continue
+		}
+
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value of '%s'", key)
+		}
+		*counter += n
+	}
+
+	return scanner.Err()
+}
+
+// Stats implements pkg.DNSCache
+func (c *Cache) Stats() (pkg.DNSStats, error) {
+	stats := pkg.DNSStats{Time: time.Now()}
+
+	instances, err := c.running()
+	if err != nil {
+		return stats, err
+	}
+
+	c.mu.Lock()
+	stats.Fallback = c.fallback
+	if c.err != nil {
+		stats.Error = c.err.Error()
+	}
+	c.mu.Unlock()
+
+	if len(stats.Error) != 0 {
+		// the counters can't be read
+		return stats, nil
+	}
+
+	var recursion float64
+	for _, inst := range instances {
+		output, err := c.control(context.Background(), inst, "stats_noreset")
+		if err != nil {
+			return stats, err
+		}
+
+		var s pkg.DNSStats
+		if err := parseStats(output, &s); err != nil {
+			return stats, err
+		}
+
+		stats.Queries += s.Queries
+		stats.CacheHits += s.CacheHits
+		stats.CacheMisses += s.CacheMisses
+		stats.Prefetches += s.Prefetches
+		stats.Secure += s.Secure
+		stats.Bogus += s.Bogus
+		stats.ServFail += s.ServFail
+		stats.Records += s.Records
+		recursion += s.RecursionTime * float64(s.CacheMisses)
+	}
+
+	if stats.CacheMisses > 0 {
+		stats.RecursionTime = recursion / float64(stats.CacheMisses)
+	}
+
+	return stats, nil
+}
+
+// Flush implements pkg.DNSCache
+func (c *Cache) Flush(zone string) error {
+	if len(zone) == 0 {
+		zone = "."
+	}
+
+	instances, err := c.running()
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range instances {
+		if _, err := c.control(context.Background(), inst, "flush_zone", zone); err != nil {
+			return err
+		}
+		// the answers that failed validation are kept apart
+		if _, err := c.control(context.Background(), inst, "flush_bogus"); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("zone", zone).Msg("dns cache flushed")
+	return nil
+}
+
+// Monitor implements pkg.DNSCache
+func (c *Cache) Monitor(ctx context.Context) <-chan pkg.DNSStats {
+	ch := make(chan pkg.DNSStats)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+
+			stats, err := c.Stats()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to get dns cache stats")
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- stats:
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, Config{}, config)
+
+	config, err = ConfigFromParams(kernel.Params{"dns-forward": {"dhcp", "1.1.1.1"}, "dns-workloads": {}})
+	require.NoError(t, err)
+	assert.Equal(t, Config{Forwarders: []string{"1.1.1.1"}, ForwardDHCP: true, Workloads: true}, config)
+
+	config, err = ConfigFromParams(kernel.Params{"nodnscache": {}})
+	require.NoError(t, err)
+	assert.True(t, config.Disabled)
+
+	config, err = ConfigFromParams(kernel.Params{"dns-fallback": {}})
+	require.NoError(t, err)
+	assert.True(t, config.Fallback)
+
+	_, err = ConfigFromParams(kernel.Params{"dns-forward": {"resolver"}})
+	assert.Error(t, err)
+}
+
+func TestNameservers(t *testing.T) {
+	conf := []byte("# received with dhcp\nsearch lan\nnameserver 192.168.1.1\nnameserver  fd00::1 \n")
+	assert.Equal(t, []string{"192.168.1.1", "fd00::1"}, nameservers(conf))
+	assert.Empty(t, nameservers(nil))
+}
+
+func TestUnboundConf(t *testing.T) {
+	inst := instance{
+		Name:       "unbound",
+		Listen:     "127.0.0.1",
+		Allow:      "127.0.0.0/8",
+		Root:       "/var/cache/modules/networkd/dns",
+		Forwarders: []string{"1.1.1.1", "9.9.9.9"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, unboundConf.Execute(&buf, &inst))
+	conf := buf.String()
+
+	assert.Contains(t, conf, "\tinterface: 127.0.0.1\n")
+	assert.Contains(t, conf, "\tauto-trust-anchor-file: \"/var/cache/modules/networkd/dns/unbound.key\"\n")
+	assert.Contains(t, conf, "\tcontrol-interface: \"/var/run/unbound.ctl\"\n")
+	assert.Contains(t, conf, "forward-zone:\n\tname: \".\"\n\tforward-addr: 1.1.1.1\n\tforward-addr: 9.9.9.9\n")
+
+	buf.Reset()
+	inst.Forwarders = nil
+	require.NoError(t, unboundConf.Execute(&buf, &inst))
+	assert.NotContains(t, buf.String(), "forward-zone")
+}
+
+func TestParseStats(t *testing.T) {
+	output := []byte(`thread0.num.queries=10
+total.num.queries=120
+total.num.cachehits=100
+total.num.cachemiss=20
+total.num.prefetch=3
+total.recursion.time.avg=0.052000
+num.answer.rcode.NOERROR=110
+num.answer.rcode.SERVFAIL=4
+num.answer.secure=60
+num.answer.bogus=2
+msg.cache.count=30
+rrset.cache.count=70
+`)
+
+	var stats pkg.DNSStats
+	require.NoError(t, parseStats(output, &stats))
+	assert.Equal(t, pkg.DNSStats{
+		Queries:       120,
+		CacheHits:     100,
+		CacheMisses:   20,
+		Prefetches:    3,
+		Secure:        60,
+		Bogus:         2,
+		ServFail:      4,
+		RecursionTime: 0.052,
+		Records:       100,
+	}, stats)
+
+	assert.Error(t, parseStats([]byte("total.num.queries=many\n"), &stats))
+}
+
+// testCache returns a cache with an instance that never answers, there
+// is no unbound running in the tests
+func testCache(t *testing.T, config Config) (*Cache, string) {
+	root, err := ioutil.TempDir("", "dns")
+	require.NoError(t, err)
+
+	c, err := NewCache(root, config, nil, "")
+	require.NoError(t, err)
+	c.resolv = filepath.Join(root, "resolv.conf")
+	c.instances = []*instance{{Name: "unbound", Root: root, Forwarders: c.forwarders()}}
+
+	return c, root
+}
+
+func TestEnsureFailClosed(t *testing.T) {
+	c, root := testCache(t, Config{})
+	defer os.RemoveAll(root)
+
+	dhcp := []byte("nameserver 192.168.1.1\n")
+	require.NoError(t, ioutil.WriteFile(c.resolv, dhcp, 0644))
+
+	require.NoError(t, c.ensure(context.Background()))
+
+	// the node doesn't resolve through the DHCP resolvers, they are kept
+	current, err := ioutil.ReadFile(c.resolv)
+	require.NoError(t, err)
+	assert.Equal(t, c.cached(), current)
+	upstream, err := ioutil.ReadFile(c.upstream())
+	require.NoError(t, err)
+	assert.Equal(t, dhcp, upstream)
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	assert.NotEmpty(t, stats.Error)
+	assert.False(t, stats.Fallback)
+}
+
+func TestEnsureFallback(t *testing.T) {
+	c, root := testCache(t, Config{Fallback: true})
+	defer os.RemoveAll(root)
+
+	dhcp := []byte("nameserver 192.168.1.1\n")
+	require.NoError(t, ioutil.WriteFile(c.resolv, c.cached(), 0644))
+	require.NoError(t, ioutil.WriteFile(c.upstream(), dhcp, 0644))
+
+	require.NoError(t, c.ensure(context.Background()))
+
+	current, err := ioutil.ReadFile(c.resolv)
+	require.NoError(t, err)
+	assert.Equal(t, dhcp, current)
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	assert.NotEmpty(t, stats.Error)
+	assert.True(t, stats.Fallback)
+}
+
+func TestEnsureLease(t *testing.T) {
+	c, root := testCache(t, Config{ForwardDHCP: true})
+	defer os.RemoveAll(root)
+
+	require.NoError(t, ioutil.WriteFile(c.resolv, []byte("nameserver 192.168.1.1\n"), 0644))
+	require.NoError(t, c.ensure(context.Background()))
+
+	// a new lease with other resolvers
+	require.NoError(t, ioutil.WriteFile(c.resolv, []byte("nameserver 192.168.2.1\n"), 0644))
+	require.NoError(t, c.ensure(context.Background()))
+
+	conf, err := ioutil.ReadFile(c.instances[0].config())
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "forward-addr: 192.168.2.1\n")
+	assert.NotContains(t, string(conf), "192.168.1.1")
+}
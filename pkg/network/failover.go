@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/nr"
+)
+
+const (
+	// failoverInterval is how often the peers handshakes are checked
+	failoverInterval = time.Minute
+	// handshakeMaxAge is the age of the last handshake with a peer after
+	// which we consider the current endpoint of the peer unreachable.
+	// With the persistent keepalive, a handshake happens every 2 minutes
+	// at most on a working link
+	handshakeMaxAge = 3 * time.Minute
+)
+
+// WatchEndpoints moves the peers with alternative endpoints to their next
+// endpoint when the current one stops working, until ctx is canceled.
+// nw must be created with NewNetworker
+func WatchEndpoints(ctx context.Context, nw pkg.Networker) {
+	n, ok := nw.(*networker)
+	if !ok {
+		log.Error().Msg("endpoint failover not supported by this networker")
+		return
+	}
+
+	ticker := time.NewTicker(failoverInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.endpointsFailover()
+	}
+}
+
+func (n *networker) endpointsFailover() {
+	infos, err := ioutil.ReadDir(n.networkDir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list stored networks")
+		return
+	}
+
+	nodeID := n.nodeID
+	for _, info := range infos {
+		network, err := n.networkOf(info.Name())
+		if err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("failed to load network object")
+			continue
+		}
+
+		netNR, err := ResourceByNodeID(nodeID, network.NetResources)
+		if err != nil {
+			continue
+		}
+
+		netr, err := nr.New(network.NetID, netNR, &network.IPRange.IPNet)
+		if err != nil {
+			continue
+		}
+
+		if !netr.HasAlternateEndpoints() {
+			continue
+		}
+
+		if err := netr.EndpointFailover(handshakeMaxAge); err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("endpoint failover failed")
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package flow
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/nlmsg"
+	"golang.org/x/sys/unix"
+)
+
+// the conntrack netlink messages and attributes, from
+// linux/netfilter/nfnetlink_conntrack.h
+const (
+	nfnlSubsysCTNetlink = 1
+	ipctnlMsgCTDelete   = 2
+	// nfnlGroupCTDestroy is the multicast group of the ended connections
+	nfnlGroupCTDestroy = 3
+
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaTimestamp     = 20
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaCountersPackets = 1
+	ctaCountersBytes   = 2
+
+	ctaTimestampStart = 1
+	ctaTimestampStop  = 2
+
+	// receiveBuffer is the size of the socket buffer, the ended
+	// connections are lost once it's full
+	receiveBuffer = 4 << 20
+	// readTimeout is the max time Read waits for an event
+	readTimeout = time.Second
+)
+
+// counters are the packets and bytes of a connection in one direction
+type counters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// event is an ended connection, in the direction of the packet that opened
+// it
+type event struct {
+	Protocol uint8
+	Src      net.IP
+	Dst      net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	Orig     counters
+	Reply    counters
+	// Start and Stop are zero if the connections are not timestamped
+	Start time.Time
+	Stop  time.Time
+	// Received is the time the event was read
+	Received time.Time
+}
+
+func parseTuple(data []byte, ev *event) error {
+	return nlmsg.Attrs(data, func(typ uint16, value []byte) error {
+		switch typ {
+		case ctaTupleIP:
+			return nlmsg.Attrs(value, func(typ uint16, value []byte) error {
+				switch typ {
+				case ctaIPv4Src, ctaIPv6Src:
+					ev.Src = net.IP(append([]byte(nil), value...))
+				case ctaIPv4Dst, ctaIPv6Dst:
+					ev.Dst = net.IP(append([]byte(nil), value...))
+				}
+				return nil
+			})
+		case ctaTupleProto:
+			return nlmsg.Attrs(value, func(typ uint16, value []byte) error {
+				switch {
+				case typ == ctaProtoNum && len(value) >= 1:
+					ev.Protocol = value[0]
+				case typ == ctaProtoSrcPort && len(value) >= 2:
+					ev.SrcPort = binary.BigEndian.Uint16(value)
+				case typ == ctaProtoDstPort && len(value) >= 2:
+					ev.DstPort = binary.BigEndian.Uint16(value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func parseCounters(data []byte, c *counters) error {
+	return nlmsg.Attrs(data, func(typ uint16, value []byte) error {
+		if len(value) < 8 {
+			return nil
+		}
+
+		switch typ {
+		case ctaCountersPackets:
+			c.Packets = binary.BigEndian.Uint64(value)
+		case ctaCountersBytes:
+			c.Bytes = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+}
+
+func parseTimestamp(data []byte, ev *event) error {
+	return nlmsg.Attrs(data, func(typ uint16, value []byte) error {
+		if len(value) < 8 {
+			return nil
+		}
+
+		ts := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		switch typ {
+		case ctaTimestampStart:
+			ev.Start = ts
+		case ctaTimestampStop:
+			ev.Stop = ts
+		}
+		return nil
+	})
+}
+
+// parseEvent parses the attributes of a conntrack message, after its
+// nfgenmsg header
+func parseEvent(data []byte) (event, error) {
+	var ev event
+	err := nlmsg.Attrs(data, func(typ uint16, value []byte) error {
+		switch typ {
+		case ctaTupleOrig:
+			return parseTuple(value, &ev)
+		case ctaCountersOrig:
+			return parseCounters(value, &ev.Orig)
+		case ctaCountersReply:
+			return parseCounters(value, &ev.Reply)
+		case ctaTimestamp:
+			return parseTimestamp(value, &ev)
+		}
+		return nil
+	})
+
+	return ev, err
+}
+
+// parseEvents parses the ended connections of a netlink datagram, the
+// other messages are skipped
+func parseEvents(data []byte, received time.Time) ([]event, error) {
+	const nfgenmsgLen = 4
+
+	var events []event
+	err := nlmsg.Messages(data, func(typ uint16, payload []byte) error {
+		if typ != nfnlSubsysCTNetlink<<8|ipctnlMsgCTDelete || len(payload) < nfgenmsgLen {
+			return nil
+		}
+
+		ev, err := parseEvent(payload[nfgenmsgLen:])
+		if err != nil {
+			return err
+		}
+		ev.Received = received
+		events = append(events, ev)
+		return nil
+	})
+
+	return events, err
+}
+
+// conntrack receives the ended connections of a namespace
+type conntrack struct {
+	fd  int
+	buf []byte
+}
+
+// subscribe subscribes to the ended connections of the namespace name, the
+// socket keeps receiving the connections of the namespace it was created
+// in
+func subscribe(name string) (*conntrack, error) {
+	netNS, err := namespace.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	defer netNS.Close()
+
+	var fd int
+	err = netNS.Do(func(_ ns.NetNS) error {
+		var err error
+		fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &conntrack{fd: fd, buf: make([]byte, 64<<10)}
+	if err := ct.setup(); err != nil {
+		ct.Close()
+		return nil, err
+	}
+
+	return ct, nil
+}
+
+func (c *conntrack) setup() error {
+	// SO_RCVBUFFORCE ignores the rmem_max limit of the namespace
+	if err := unix.SetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, receiveBuffer); err != nil {
+		if err := unix.SetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_RCVBUF, receiveBuffer); err != nil {
+			return err
+		}
+	}
+
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+
+	return unix.Bind(c.fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: 1 << (nfnlGroupCTDestroy - 1),
+	})
+}
+
+// Read returns the connections that ended since the last read, it returns
+// no connection if none ended within readTimeout, and unix.ENOBUFS if some
+// were lost
+func (c *conntrack) Read() ([]event, error) {
+	n, _, err := unix.Recvfrom(c.fd, c.buf, 0)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return parseEvents(c.buf[:n], time.Now())
+}
+
+// Close closes the subscription
+func (c *conntrack) Close() error {
+	return unix.Close(c.fd)
+}
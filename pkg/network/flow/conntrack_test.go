@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/network/nlmsg"
+	"golang.org/x/sys/unix"
+)
+
+func attr(typ uint16, value []byte) []byte {
+	return nlmsg.Attr(typ, value)
+}
+
+func nested(typ uint16, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	return attr(typ|unix.NLA_F_NESTED, value)
+}
+
+func be16(v uint16) []byte {
+	return appendUint16(nil, v)
+}
+
+func be64(v uint64) []byte {
+	return appendUint64(nil, v)
+}
+
+func message(typ uint16, attrs ...[]byte) []byte {
+	// nfgenmsg: family, version, resource id
+	payload := []byte{unix.AF_INET, 0, 0, 0}
+	for _, a := range attrs {
+		payload = append(payload, a...)
+	}
+
+	return nlmsg.Message(typ, 0, 0, payload)
+}
+
+func TestParseEvents(t *testing.T) {
+	start := time.Unix(1590000000, 500)
+	stop := start.Add(time.Minute)
+
+	destroy := message(nfnlSubsysCTNetlink<<8|ipctnlMsgCTDelete,
+		nested(ctaTupleOrig,
+			nested(ctaTupleIP,
+				attr(ctaIPv4Src, net.ParseIP("100.127.0.3").To4()),
+				attr(ctaIPv4Dst, net.ParseIP("185.69.166.245").To4()),
+			),
+			nested(ctaTupleProto,
+				attr(ctaProtoNum, []byte{17}),
+				attr(ctaProtoSrcPort|unix.NLA_F_NET_BYTEORDER, be16(41000)),
+				attr(ctaProtoDstPort|unix.NLA_F_NET_BYTEORDER, be16(53)),
+			),
+		),
+		nested(ctaTupleReply,
+			nested(ctaTupleIP,
+				attr(ctaIPv4Src, net.ParseIP("185.69.166.245").To4()),
+				attr(ctaIPv4Dst, net.ParseIP("10.20.0.5").To4()),
+			),
+		),
+		nested(ctaCountersOrig,
+			attr(ctaCountersPackets, be64(1)),
+			attr(ctaCountersBytes, be64(60)),
+		),
+		nested(ctaCountersReply,
+			attr(ctaCountersPackets, be64(1)),
+			attr(ctaCountersBytes, be64(120)),
+		),
+		nested(ctaTimestamp,
+			attr(ctaTimestampStart, be64(uint64(start.UnixNano()))),
+			attr(ctaTimestampStop, be64(uint64(stop.UnixNano()))),
+		),
+	)
+	// a new connection, not an ended one
+	create := message(nfnlSubsysCTNetlink<<8|0,
+		nested(ctaTupleOrig,
+			nested(ctaTupleIP, attr(ctaIPv4Src, net.ParseIP("100.127.0.4").To4())),
+		),
+	)
+
+	received := time.Now()
+	events, err := parseEvents(append(create, destroy...), received)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	ev := events[0]
+	assert.Equal(t, uint8(17), ev.Protocol)
+	assert.Equal(t, "100.127.0.3", ev.Src.String())
+	assert.Equal(t, "185.69.166.245", ev.Dst.String())
+	assert.Equal(t, uint16(41000), ev.SrcPort)
+	assert.Equal(t, uint16(53), ev.DstPort)
+	assert.Equal(t, counters{Packets: 1, Bytes: 60}, ev.Orig)
+	assert.Equal(t, counters{Packets: 1, Bytes: 120}, ev.Reply)
+	assert.True(t, start.Equal(ev.Start))
+	assert.True(t, stop.Equal(ev.Stop))
+	assert.Equal(t, received, ev.Received)
+
+	// truncated message
+	_, err = parseEvents(destroy[:len(destroy)-8], received)
+	assert.Error(t, err)
+}
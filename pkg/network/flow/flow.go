@@ -0,0 +1,337 @@
+// Package flow exports the flows of the overlay leaving an exit node to an
+// IPFIX collector of the farmer.
+//
+// The flows are the connections tracked in the ndmz namespace that come
+// from a network resource and go to the internet. A flow is exported once
+// its connection ends, with the packets and the bytes sent in each
+// direction, so the farmer can plan the capacity of the exit nodes and
+// find the network resource behind an abuse report. The remote addresses
+// can be anonymized before they leave the node.
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
+	"golang.org/x/sys/unix"
+)
+
+// the kernel parameters configuring the export
+const (
+	collectorParam = "flow-collector"
+	anonymizeParam = "flow-anonymize"
+)
+
+const (
+	// DefaultPort is the IPFIX port of the collector if the farmer doesn't
+	// set one
+	DefaultPort = 4739
+
+	// Anonymize4 is the length of the prefix kept from the anonymized IPv4
+	// addresses
+	Anonymize4 = 24
+	// Anonymize6 is the length of the prefix kept from the anonymized IPv6
+	// addresses
+	Anonymize6 = 48
+
+	// flushInterval is the max time a flow waits to be exported
+	flushInterval = 10 * time.Second
+	// maxPending is the number of flows exported at once
+	maxPending = 256
+	// retryInterval is the time between two subscriptions to the
+	// connections of the namespace
+	retryInterval = 10 * time.Second
+)
+
+// Config is the configuration of the export set by the farmer
+type Config struct {
+	// Collector is the host:port of the collector the flows are sent to
+	// over UDP
+	Collector string
+	// Anonymize truncates the remote addresses of the flows to their
+	// Anonymize4 or Anonymize6 prefix
+	Anonymize bool
+}
+
+// Enabled returns true if the flows must be exported
+func (c Config) Enabled() bool {
+	return len(c.Collector) != 0
+}
+
+// ConfigFromParams reads the export configuration from the kernel
+// parameters. flow-collector=<host>[:<port>] is the collector the flows are
+// sent to and enables the export, the port is DefaultPort if not set.
+// flow-anonymize truncates the remote addresses of the exported flows
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{
+		Anonymize: params.Exists(anonymizeParam),
+	}
+
+	values, ok := params.Get(collectorParam)
+	if !ok || len(values) == 0 {
+		return config, nil
+	}
+
+	collector := values[0]
+	host, port, err := net.SplitHostPort(collector)
+	if err != nil {
+		// no port, or an IPv6 address without brackets
+		host, port = collector, strconv.Itoa(DefaultPort)
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 || len(host) == 0 {
+		return config, fmt.Errorf("invalid flow collector '%s'", collector)
+	}
+
+	config.Collector = net.JoinHostPort(host, port)
+	return config, nil
+}
+
+// Record is a flow in one direction
+type Record struct {
+	Start    time.Time
+	End      time.Time
+	Protocol uint8
+	Src      net.IP
+	Dst      net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	Packets  uint64
+	Bytes    uint64
+}
+
+// anonymize truncates ip to its Anonymize4 or Anonymize6 prefix
+func anonymize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(Anonymize4, 32))
+	}
+
+	return ip.Mask(net.CIDRMask(Anonymize6, 128))
+}
+
+func contains(subnets []net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// records returns the flows of the connection ev in both directions, if it
+// goes from one of the sources to the internet
+func records(ev event, sources []net.IPNet, anonymized bool) []Record {
+	if !contains(sources, ev.Src) || contains(sources, ev.Dst) {
+		return nil
+	}
+
+	remote := ev.Dst
+	if anonymized {
+		remote = anonymize(remote)
+	}
+
+	end := ev.Stop
+	if end.IsZero() {
+		end = ev.Received
+	}
+	start := ev.Start
+	if start.IsZero() {
+		start = end
+	}
+
+	flows := []Record{{
+		Start:    start,
+		End:      end,
+		Protocol: ev.Protocol,
+		Src:      ev.Src,
+		Dst:      remote,
+		SrcPort:  ev.SrcPort,
+		DstPort:  ev.DstPort,
+		Packets:  ev.Orig.Packets,
+		Bytes:    ev.Orig.Bytes,
+	}}
+
+	if ev.Reply.Packets != 0 {
+		flows = append(flows, Record{
+			Start:    start,
+			End:      end,
+			Protocol: ev.Protocol,
+			Src:      remote,
+			Dst:      ev.Src,
+			SrcPort:  ev.DstPort,
+			DstPort:  ev.SrcPort,
+			Packets:  ev.Reply.Packets,
+			Bytes:    ev.Reply.Bytes,
+		})
+	}
+
+	return flows
+}
+
+// Exporter exports the flows of the connections of a namespace
+type Exporter struct {
+	config    Config
+	namespace string
+	sources   []net.IPNet
+
+	once  sync.Once
+	reset chan struct{}
+
+	encoder encoder
+	conn    net.Conn
+}
+
+// NewExporter creates the exporter of the flows of namespace coming from
+// the sources subnets
+func NewExporter(config Config, namespace string, sources []net.IPNet) *Exporter {
+	return &Exporter{
+		config:    config,
+		namespace: namespace,
+		sources:   sources,
+		reset:     make(chan struct{}, 1),
+	}
+}
+
+// Start starts the export if the farmer set a collector. Once started, the
+// export follows the connections of the namespace until ctx is done, a
+// second call makes it follow the connections of the namespace again, after
+// it was created again
+func (e *Exporter) Start(ctx context.Context) {
+	if !e.config.Enabled() {
+		return
+	}
+
+	started := false
+	e.once.Do(func() {
+		started = true
+		go e.run(ctx)
+	})
+	if started {
+		return
+	}
+
+	select {
+	case e.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) run(ctx context.Context) {
+	log.Info().
+		Str("collector", e.config.Collector).
+		Bool("anonymize", e.config.Anonymize).
+		Msg("start flow export")
+
+	defer func() {
+		if e.conn != nil {
+			e.conn.Close()
+		}
+	}()
+
+	for {
+		err := e.export(ctx)
+		if ctx.Err() != nil {
+			return
+		} else if err == nil {
+			// reset, the namespace was created again
+			continue
+		}
+
+		log.Error().Err(err).Str("namespace", e.namespace).Msg("flow export failed")
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.reset:
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// export follows the connections of the namespace until ctx is done or the
+// exporter is reset
+func (e *Exporter) export(ctx context.Context) error {
+	// the connections are only counted and timestamped once enabled
+	for _, key := range []string{"net.netfilter.nf_conntrack_acct", "net.netfilter.nf_conntrack_timestamp"} {
+		if err := sysctl.Set(e.namespace, key, "1"); err != nil {
+			return err
+		}
+	}
+
+	ct, err := subscribe(e.namespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to follow the connections of namespace %s", e.namespace)
+	}
+	defer ct.Close()
+
+	var pending []Record
+	flushed := time.Now()
+	defer func() {
+		e.flush(pending)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-e.reset:
+			return nil
+		default:
+		}
+
+		events, err := ct.Read()
+		if err == unix.ENOBUFS {
+			// the events came faster than they were read
+			log.Warn().Str("namespace", e.namespace).Msg("flows lost, the collector will miss some traffic")
+		} else if err != nil {
+			return err
+		}
+
+		for _, ev := range events {
+			pending = append(pending, records(ev, e.sources, e.config.Anonymize)...)
+		}
+
+		if len(pending) >= maxPending || time.Since(flushed) >= flushInterval {
+			e.flush(pending)
+			pending = nil
+			flushed = time.Now()
+		}
+	}
+}
+
+// flush sends the flows to the collector, the flows are dropped if the
+// collector can't be reached
+func (e *Exporter) flush(flows []Record) {
+	if len(flows) == 0 {
+		return
+	}
+
+	if e.conn == nil {
+		conn, err := net.Dial("udp", e.config.Collector)
+		if err != nil {
+			log.Error().Err(err).Str("collector", e.config.Collector).Int("flows", len(flows)).Msg("failed to reach flow collector")
+			return
+		}
+		e.conn = conn
+	}
+
+	for _, msg := range e.encoder.encode(time.Now(), flows) {
+		if _, err := e.conn.Write(msg); err != nil {
+			log.Error().Err(err).Str("collector", e.config.Collector).Msg("failed to send flows")
+			// resolve the collector again on the next flush
+			e.conn.Close()
+			e.conn = nil
+			return
+		}
+	}
+}
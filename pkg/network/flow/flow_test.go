@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.False(t, config.Enabled())
+
+	for value, collector := range map[string]string{
+		"10.0.0.1":              "10.0.0.1:4739",
+		"10.0.0.1:2055":         "10.0.0.1:2055",
+		"collector.farm":        "collector.farm:4739",
+		"2001:db8::1":           "[2001:db8::1]:4739",
+		"[2001:db8::1]:2055":    "[2001:db8::1]:2055",
+		"[2001:db8::1]":         "[2001:db8::1]:4739",
+		"collector.farm:ipfix0": "",
+		":2055":                 "",
+	} {
+		config, err := ConfigFromParams(kernel.Params{"flow-collector": {value}})
+		if collector == "" {
+			assert.Error(t, err, value)
+			continue
+		}
+		require.NoError(t, err, value)
+		assert.True(t, config.Enabled())
+		assert.Equal(t, collector, config.Collector, value)
+		assert.False(t, config.Anonymize)
+	}
+
+	config, err = ConfigFromParams(kernel.Params{"flow-collector": {"10.0.0.1"}, "flow-anonymize": {}})
+	require.NoError(t, err)
+	assert.True(t, config.Anonymize)
+}
+
+func TestAnonymize(t *testing.T) {
+	assert.Equal(t, "185.69.166.0", anonymize(net.ParseIP("185.69.166.245")).String())
+	assert.Equal(t, "2a02:1802:5e::", anonymize(net.ParseIP("2a02:1802:5e:0:8478:51ff:fee2:80c9")).String())
+}
+
+func TestRecords(t *testing.T) {
+	sources := []net.IPNet{
+		{IP: net.ParseIP("100.127.0.0"), Mask: net.CIDRMask(16, 32)},
+		{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	start := time.Unix(1590000000, 0)
+	ev := event{
+		Protocol: 6,
+		Src:      net.ParseIP("100.127.0.3").To4(),
+		Dst:      net.ParseIP("185.69.166.245").To4(),
+		SrcPort:  41000,
+		DstPort:  443,
+		Orig:     counters{Packets: 10, Bytes: 1200},
+		Reply:    counters{Packets: 8, Bytes: 9000},
+		Start:    start,
+		Stop:     start.Add(time.Minute),
+		Received: start.Add(time.Minute + time.Second),
+	}
+
+	flows := records(ev, sources, false)
+	require.Len(t, flows, 2)
+	assert.Equal(t, Record{
+		Start:    start,
+		End:      start.Add(time.Minute),
+		Protocol: 6,
+		Src:      ev.Src,
+		Dst:      ev.Dst,
+		SrcPort:  41000,
+		DstPort:  443,
+		Packets:  10,
+		Bytes:    1200,
+	}, flows[0])
+	assert.Equal(t, Record{
+		Start:    start,
+		End:      start.Add(time.Minute),
+		Protocol: 6,
+		Src:      ev.Dst,
+		Dst:      ev.Src,
+		SrcPort:  443,
+		DstPort:  41000,
+		Packets:  8,
+		Bytes:    9000,
+	}, flows[1])
+
+	flows = records(ev, sources, true)
+	require.Len(t, flows, 2)
+	assert.Equal(t, "185.69.166.0", flows[0].Dst.String())
+	assert.Equal(t, "185.69.166.0", flows[1].Src.String())
+	assert.Equal(t, "100.127.0.3", flows[0].Src.String())
+
+	// no answer, no timestamps
+	unanswered := ev
+	unanswered.Reply = counters{}
+	unanswered.Start, unanswered.Stop = time.Time{}, time.Time{}
+	flows = records(unanswered, sources, false)
+	require.Len(t, flows, 1)
+	assert.Equal(t, ev.Received, flows[0].Start)
+	assert.Equal(t, ev.Received, flows[0].End)
+
+	// the dns cache of the ndmz
+	internal := ev
+	internal.Dst = net.ParseIP("100.127.0.1").To4()
+	assert.Empty(t, records(internal, sources, false))
+
+	// not from a network resource
+	host := ev
+	host.Src = net.ParseIP("10.20.0.5").To4()
+	assert.Empty(t, records(host, sources, false))
+
+	v6 := ev
+	v6.Src = net.ParseIP("fd00::4")
+	v6.Dst = net.ParseIP("2a02:1802:5e:0:8478:51ff:fee2:80c9")
+	flows = records(v6, sources, true)
+	require.Len(t, flows, 2)
+	assert.Equal(t, "2a02:1802:5e::", flows[0].Dst.String())
+}
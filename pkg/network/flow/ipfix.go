@@ -0,0 +1,229 @@
+package flow
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// the IPFIX messages, from RFC 7011, and the information elements of the
+// flows, from the IANA IPFIX registry
+const (
+	ipfixVersion = 10
+	// headerLen is the length of the message header
+	headerLen = 16
+	// setHeaderLen is the length of the header of a set
+	setHeaderLen = 4
+
+	templateSetID = 2
+	// template4ID and template6ID are the templates of the IPv4 and the
+	// IPv6 flows, the data sets use the id of their template
+	template4ID = 256
+	template6ID = 257
+
+	// maxMessage is the max length of a message, it fits in a single
+	// datagram on the internet
+	maxMessage = 1400
+	// templateInterval is the time between two sends of the templates, the
+	// collector learns them again after a restart
+	templateInterval = 5 * time.Minute
+)
+
+// field is an information element of a template
+type field struct {
+	id     uint16
+	length uint16
+}
+
+var (
+	fieldStart   = field{id: 152, length: 8} // flowStartMilliseconds
+	fieldEnd     = field{id: 153, length: 8} // flowEndMilliseconds
+	fieldSrc4    = field{id: 8, length: 4}   // sourceIPv4Address
+	fieldDst4    = field{id: 12, length: 4}  // destinationIPv4Address
+	fieldSrc6    = field{id: 27, length: 16} // sourceIPv6Address
+	fieldDst6    = field{id: 28, length: 16} // destinationIPv6Address
+	fieldSrcPort = field{id: 7, length: 2}   // sourceTransportPort
+	fieldDstPort = field{id: 11, length: 2}  // destinationTransportPort
+	fieldProto   = field{id: 4, length: 1}   // protocolIdentifier
+	fieldPackets = field{id: 2, length: 8}   // packetDeltaCount
+	fieldBytes   = field{id: 1, length: 8}   // octetDeltaCount
+)
+
+// template is the layout of the flows of an address family
+type template struct {
+	id     uint16
+	fields []field
+}
+
+var (
+	template4 = template{
+		id:     template4ID,
+		fields: []field{fieldStart, fieldEnd, fieldSrc4, fieldDst4, fieldSrcPort, fieldDstPort, fieldProto, fieldPackets, fieldBytes},
+	}
+	template6 = template{
+		id:     template6ID,
+		fields: []field{fieldStart, fieldEnd, fieldSrc6, fieldDst6, fieldSrcPort, fieldDstPort, fieldProto, fieldPackets, fieldBytes},
+	}
+)
+
+// recordLen returns the length of a flow encoded with t
+func (t template) recordLen() int {
+	n := 0
+	for _, f := range t.fields {
+		n += int(f.length)
+	}
+	return n
+}
+
+// templateFor returns the template of the flow r
+func templateFor(r Record) template {
+	if r.Src.To4() != nil && r.Dst.To4() != nil {
+		return template4
+	}
+	return template6
+}
+
+// appendRecord encodes r with the fields of template t, which is the
+// template of r
+func appendRecord(b []byte, t template, r Record) []byte {
+	ip := func(ip net.IP) net.IP {
+		if t.id == template4ID {
+			return ip.To4()
+		}
+		return ip.To16()
+	}
+
+	b = appendUint64(b, uint64(r.Start.UnixNano()/int64(time.Millisecond)))
+	b = appendUint64(b, uint64(r.End.UnixNano()/int64(time.Millisecond)))
+	b = append(b, ip(r.Src)...)
+	b = append(b, ip(r.Dst)...)
+	b = appendUint16(b, r.SrcPort)
+	b = appendUint16(b, r.DstPort)
+	b = append(b, r.Protocol)
+	b = appendUint64(b, r.Packets)
+	b = appendUint64(b, r.Bytes)
+
+	return b
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendTemplates encodes the template set of the IPv4 and IPv6 templates
+func appendTemplates(b []byte) []byte {
+	start := len(b)
+	b = appendUint16(b, templateSetID)
+	b = appendUint16(b, 0)
+
+	for _, t := range []template{template4, template6} {
+		b = appendUint16(b, t.id)
+		b = appendUint16(b, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			b = appendUint16(b, f.id)
+			b = appendUint16(b, f.length)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+// encoder encodes the flows in IPFIX messages
+type encoder struct {
+	// sequence is the number of flows sent before the next message
+	sequence uint32
+	// templates is the last time the templates were sent
+	templates time.Time
+}
+
+// encode encodes the flows in messages of at most maxMessage bytes, the
+// first message starts with the templates if they are due
+func (e *encoder) encode(now time.Time, flows []Record) [][]byte {
+	var (
+		messages [][]byte
+		msg      []byte
+		records  uint32
+		// set is the offset of the header of the current data set, 0 if
+		// there is none
+		set int
+		// current is the template of the current data set
+		current uint16
+	)
+
+	closeSet := func() {
+		if set != 0 {
+			binary.BigEndian.PutUint16(msg[set+2:], uint16(len(msg)-set))
+			set = 0
+		}
+	}
+
+	closeMessage := func() {
+		if msg == nil {
+			return
+		}
+		closeSet()
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		messages = append(messages, msg)
+		e.sequence += records
+		msg, records = nil, 0
+	}
+
+	openMessage := func() {
+		msg = make([]byte, 0, maxMessage)
+		msg = appendUint16(msg, ipfixVersion)
+		msg = appendUint16(msg, 0)
+		msg = appendUint32(msg, uint32(now.Unix()))
+		msg = appendUint32(msg, e.sequence)
+		// the observation domain, the collector tells the nodes apart
+		// by their address
+		msg = appendUint32(msg, 0)
+
+		if now.Sub(e.templates) >= templateInterval {
+			msg = appendTemplates(msg)
+			e.templates = now
+		}
+	}
+
+	for _, r := range flows {
+		t := templateFor(r)
+
+		size := t.recordLen()
+		if set == 0 || current != t.id {
+			size += setHeaderLen
+		}
+		if msg != nil && len(msg)+size > maxMessage {
+			closeMessage()
+		}
+		if msg == nil {
+			openMessage()
+		}
+
+		if set == 0 || current != t.id {
+			closeSet()
+			set, current = len(msg), t.id
+			msg = appendUint16(msg, t.id)
+			msg = appendUint16(msg, 0)
+		}
+
+		msg = appendRecord(msg, t, r)
+		records++
+	}
+	closeMessage()
+
+	return messages
+}
@@ -0,0 +1,117 @@
+package flow
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// set is a set of an IPFIX message
+type set struct {
+	id   uint16
+	data []byte
+}
+
+func parseMessage(t *testing.T, msg []byte) (uint32, []set) {
+	require.True(t, len(msg) >= headerLen)
+	require.Equal(t, uint16(ipfixVersion), binary.BigEndian.Uint16(msg[0:]))
+	require.Equal(t, len(msg), int(binary.BigEndian.Uint16(msg[2:])))
+	sequence := binary.BigEndian.Uint32(msg[8:])
+
+	var sets []set
+	for data := msg[headerLen:]; len(data) != 0; {
+		require.True(t, len(data) >= setHeaderLen)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		require.True(t, length >= setHeaderLen && length <= len(data))
+		sets = append(sets, set{id: binary.BigEndian.Uint16(data), data: data[setHeaderLen:length]})
+		data = data[length:]
+	}
+
+	return sequence, sets
+}
+
+func TestEncode(t *testing.T) {
+	now := time.Unix(1590000000, 0)
+	flow4 := Record{
+		Start:    now.Add(-time.Minute),
+		End:      now.Add(-time.Second),
+		Protocol: 6,
+		Src:      net.ParseIP("100.127.0.3"),
+		Dst:      net.ParseIP("185.69.166.245"),
+		SrcPort:  41000,
+		DstPort:  443,
+		Packets:  10,
+		Bytes:    1200,
+	}
+	flow6 := flow4
+	flow6.Src = net.ParseIP("fd00::4")
+	flow6.Dst = net.ParseIP("2a02:1802:5e::1")
+
+	var e encoder
+	messages := e.encode(now, []Record{flow4, flow4, flow6})
+	require.Len(t, messages, 1)
+
+	sequence, sets := parseMessage(t, messages[0])
+	assert.Equal(t, uint32(0), sequence)
+	require.Len(t, sets, 3)
+
+	assert.Equal(t, uint16(templateSetID), sets[0].id)
+	// 2 templates of 9 fields
+	assert.Len(t, sets[0].data, 2*(4+9*4))
+	assert.Equal(t, uint16(template4ID), binary.BigEndian.Uint16(sets[0].data))
+
+	assert.Equal(t, uint16(template4ID), sets[1].id)
+	require.Len(t, sets[1].data, 2*template4.recordLen())
+	record := sets[1].data[:template4.recordLen()]
+	assert.Equal(t, uint64(flow4.Start.Unix()*1000), binary.BigEndian.Uint64(record[0:]))
+	assert.Equal(t, uint64(flow4.End.Unix()*1000), binary.BigEndian.Uint64(record[8:]))
+	assert.Equal(t, net.IP(record[16:20]).String(), "100.127.0.3")
+	assert.Equal(t, net.IP(record[20:24]).String(), "185.69.166.245")
+	assert.Equal(t, uint16(41000), binary.BigEndian.Uint16(record[24:]))
+	assert.Equal(t, uint16(443), binary.BigEndian.Uint16(record[26:]))
+	assert.Equal(t, uint8(6), record[28])
+	assert.Equal(t, uint64(10), binary.BigEndian.Uint64(record[29:]))
+	assert.Equal(t, uint64(1200), binary.BigEndian.Uint64(record[37:]))
+
+	assert.Equal(t, uint16(template6ID), sets[2].id)
+	require.Len(t, sets[2].data, template6.recordLen())
+	assert.Equal(t, net.IP(sets[2].data[16:32]).String(), "fd00::4")
+
+	// the templates are not due, the sequence follows the flows sent
+	messages = e.encode(now.Add(time.Minute), []Record{flow6})
+	require.Len(t, messages, 1)
+	sequence, sets = parseMessage(t, messages[0])
+	assert.Equal(t, uint32(3), sequence)
+	require.Len(t, sets, 1)
+	assert.Equal(t, uint16(template6ID), sets[0].id)
+
+	// the flows are split in messages that fit in a datagram
+	flows := make([]Record, 100)
+	for i := range flows {
+		flows[i] = flow4
+	}
+	messages = e.encode(now.Add(templateInterval), flows)
+	require.True(t, len(messages) > 1)
+
+	expected, total := uint32(4), 0
+	for i, msg := range messages {
+		assert.True(t, len(msg) <= maxMessage)
+
+		sequence, sets := parseMessage(t, msg)
+		assert.Equal(t, expected, sequence)
+		if i == 0 {
+			require.Equal(t, uint16(templateSetID), sets[0].id)
+			sets = sets[1:]
+		}
+		require.Len(t, sets, 1)
+
+		count := len(sets[0].data) / template4.recordLen()
+		expected += uint32(count)
+		total += count
+	}
+	assert.Equal(t, len(flows), total)
+}
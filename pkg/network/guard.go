@@ -0,0 +1,126 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/network/antispoof"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// guardRetry is the time before listening again to the violations once the
+// listener failed
+const guardRetry = 10 * time.Second
+
+// portOwner returns the namespace of the workload behind the bridge port
+// name, empty if it's not found
+func portOwner(name string) string {
+	port, err := netlink.LinkByName(name)
+	if err != nil {
+		return ""
+	}
+
+	names, err := namespace.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list namespaces")
+		return ""
+	}
+
+	for _, candidate := range names {
+		netNS, err := namespace.GetByName(candidate)
+		if err != nil {
+			continue
+		}
+
+		owner := false
+		_ = netNS.Do(func(_ ns.NetNS) error {
+			link, err := netlink.LinkByName("eth0")
+			if err != nil {
+				return err
+			}
+			owner = link.Attrs().ParentIndex == port.Attrs().Index
+			return nil
+		})
+		netNS.Close()
+
+		if owner {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// recordViolation records the violation v as a security event of the
+// workload that sent it
+func (n *networker) recordViolation(v antispoof.Violation) {
+	owner := portOwner(v.Port)
+
+	log.Warn().
+		Str("security", v.Kind).
+		Str("container", owner).
+		Str("port", v.Port).
+		Str("mac", v.MAC).
+		Str("source", v.Source).
+		Msg("workload frame dropped by bridge guard")
+
+	object := owner
+	if len(object) == 0 {
+		object = v.Port
+	}
+	n.audit.Record("Guard", audit.System, object, v, fmt.Errorf("%s", v))
+}
+
+// WatchGuards records the frames dropped by the guards of the workload
+// ports (the DHCP replies and the router advertisements sent by the
+// workloads) as security events in the audit log, until ctx is canceled
+func WatchGuards(ctx context.Context, nw pkg.Networker) {
+	n, ok := nw.(*networker)
+	if !ok {
+		log.Error().Msg("bridge guards not supported by this networker")
+		return
+	}
+
+	for {
+		if err := n.watchGuards(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to listen to bridge guard violations")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(guardRetry):
+		}
+	}
+}
+
+func (n *networker) watchGuards(ctx context.Context) error {
+	monitor, err := antispoof.Listen()
+	if err != nil {
+		return err
+	}
+	defer monitor.Close()
+
+	for ctx.Err() == nil {
+		violations, err := monitor.Read()
+		if err == unix.ENOBUFS {
+			log.Warn().Msg("bridge guard violations lost, the listener is too slow")
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, v := range violations {
+			n.recordViolation(v)
+		}
+	}
+
+	return nil
+}
@@ -195,6 +195,27 @@ func GetMAC(name string, netNS ns.NetNS) (net.HardwareAddr, error) {
 	return link.Attrs().HardwareAddr, nil
 }
 
+// GetIPv4 gets the first IPv4 address of the interface
+func GetIPv4(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
 // SetMAC Sets the mac addr of an interface
 // if netNS is not nil switch in the network namespace
 // before setting
@@ -0,0 +1,163 @@
+package ifaceutil
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// DADTimeout is the maximum time to wait for the duplicate address
+// detection of an address. A link without carrier keeps its addresses
+// tentative until it gets one
+const DADTimeout = 5 * time.Second
+
+// IPv6Policy controls how the IPv6 addresses of an interface are generated
+// and checked
+type IPv6Policy struct {
+	// TempAddr enables the privacy extensions (RFC 4941): temporary
+	// addresses are generated and preferred for the outgoing connections
+	TempAddr bool
+	// TempValid is the lifetime of the temporary addresses, the kernel
+	// default if zero
+	TempValid time.Duration
+	// TempPreferred is the time a temporary address is preferred before a
+	// new one replaces it, the kernel default if zero
+	TempPreferred time.Duration
+	// DAD enables the duplicate address detection of the new addresses
+	DAD bool
+	// DADTransmits is the number of neighbor solicitations sent by the
+	// duplicate address detection
+	DADTransmits int
+}
+
+// DefaultIPv6Policy is the policy of the interfaces created by the network
+// module: the workloads and the services are reached on stable addresses so
+// no temporary address is generated, and the duplicates are detected with
+// a single probe
+var DefaultIPv6Policy = IPv6Policy{
+	DAD:          true,
+	DADTransmits: 1,
+}
+
+// sysctls returns the values of the sysctls of the policy
+func (p IPv6Policy) sysctls() map[string]string {
+	values := map[string]string{
+		"use_tempaddr":  "0",
+		"accept_dad":    "0",
+		"dad_transmits": strconv.Itoa(p.DADTransmits),
+	}
+	if p.TempAddr {
+		values["use_tempaddr"] = "2"
+		if p.TempValid != 0 {
+			values["temp_valid_lft"] = strconv.Itoa(int(p.TempValid / time.Second))
+		}
+		if p.TempPreferred != 0 {
+			values["temp_prefered_lft"] = strconv.Itoa(int(p.TempPreferred / time.Second))
+		}
+	}
+	if p.DAD {
+		values["accept_dad"] = "1"
+	}
+
+	return values
+}
+
+// SetIPv6Policy applies the policy on the interface name of the namespace
+// netns, before its addresses are added
+func SetIPv6Policy(netns, name string, policy IPv6Policy) error {
+	for key, value := range policy.sysctls() {
+		if err := sysctl.Set(netns, fmt.Sprintf("net.ipv6.conf.%s.%s", name, key), value); err != nil {
+			return errors.Wrapf(err, "failed to set %s on %s", key, name)
+		}
+	}
+
+	return nil
+}
+
+// DADError is returned when the duplicate address detection finds the
+// address on another host of the link
+type DADError struct {
+	IP   net.IP
+	Link string
+}
+
+func (e *DADError) Error() string {
+	return fmt.Sprintf("address %s of %s is already used on the link", e.IP, e.Link)
+}
+
+// IsDADError returns true if err is a failure of the duplicate address
+// detection
+func IsDADError(err error) bool {
+	_, ok := errors.Cause(err).(*DADError)
+	return ok
+}
+
+// AddIPv6 sets the static address addr on link, then waits for the
+// duplicate address detection to complete. The address never expires, an
+// existing address learned from the router advertisements becomes static.
+// If the address is already used on the link, it's removed and a *DADError
+// is returned, instead of leaving an unusable address behind. It must be
+// called in the namespace of the link, once the link is up
+func AddIPv6(link netlink.Link, addr *net.IPNet) error {
+	// without lifetimes the kernel sets the address forever, replacing
+	// the lifetimes of an existing address
+	if err := netlink.AddrReplace(link, &netlink.Addr{IPNet: addr}); err != nil {
+		return errors.Wrapf(err, "failed to set address %s on %s", addr.String(), link.Attrs().Name)
+	}
+
+	err := WaitDAD(link, addr.IP, DADTimeout)
+	if IsDADError(err) {
+		if err := netlink.AddrDel(link, &netlink.Addr{IPNet: addr}); err != nil {
+			log.Error().Err(err).Str("addr", addr.String()).Msg("failed to remove duplicate address")
+		}
+	}
+
+	return err
+}
+
+// WaitDAD waits until the duplicate address detection of the address ip of
+// link completes. A *DADError is returned if the address is a duplicate,
+// a link that is still detecting duplicates after timeout is logged and
+// considered valid: the detection is only done once the link has a carrier
+func WaitDAD(link netlink.Link, ip net.IP, timeout time.Duration) error {
+	name := link.Attrs().Name
+	deadline := time.Now().Add(timeout)
+
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list addresses of %s", name)
+		}
+
+		var found *netlink.Addr
+		for i := range addrs {
+			if addrs[i].IP.Equal(ip) {
+				found = &addrs[i]
+				break
+			}
+		}
+
+		switch {
+		case found == nil:
+			return fmt.Errorf("address %s is not set on %s", ip, name)
+		case found.Flags&unix.IFA_F_DADFAILED != 0:
+			return &DADError{IP: ip, Link: name}
+		case found.Flags&unix.IFA_F_TENTATIVE == 0:
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			log.Warn().Str("addr", ip.String()).Str("link", name).Msg("duplicate address detection not completed")
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,47 @@
+package ifaceutil
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPv6PolicySysctls(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"use_tempaddr":  "0",
+		"accept_dad":    "1",
+		"dad_transmits": "1",
+	}, DefaultIPv6Policy.sysctls())
+
+	assert.Equal(t, map[string]string{
+		"use_tempaddr":  "2",
+		"accept_dad":    "0",
+		"dad_transmits": "0",
+	}, IPv6Policy{TempAddr: true}.sysctls())
+
+	assert.Equal(t, map[string]string{
+		"use_tempaddr":      "2",
+		"temp_valid_lft":    "86400",
+		"temp_prefered_lft": "3600",
+		"accept_dad":        "1",
+		"dad_transmits":     "2",
+	}, IPv6Policy{
+		TempAddr:      true,
+		TempValid:     24 * time.Hour,
+		TempPreferred: time.Hour,
+		DAD:           true,
+		DADTransmits:  2,
+	}.sysctls())
+}
+
+func TestIsDADError(t *testing.T) {
+	err := &DADError{IP: net.ParseIP("fd00::10"), Link: "eth0"}
+
+	assert.True(t, IsDADError(err))
+	assert.True(t, IsDADError(errors.Wrap(err, "failed to join")))
+	assert.False(t, IsDADError(errors.New("failed")))
+	assert.Equal(t, "address fd00::10 of eth0 is already used on the link", err.Error())
+}
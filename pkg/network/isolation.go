@@ -0,0 +1,276 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/nr"
+	"github.com/threefoldtech/zos/pkg/network/probe"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// isolationTimeout is the max time a probe waits for an answer
+	isolationTimeout = time.Second
+	// isolationWorkers is the number of probes sent at once
+	isolationWorkers = 16
+	// isolationHost is the target of the addresses of the host namespace
+	isolationHost = "host"
+)
+
+// isolationPorts are the TCP ports probed on every address, a refused
+// connection is enough to tell the address is reachable
+var isolationPorts = []uint16{22, 80, 443, 6379}
+
+// isolationTarget is a namespace a network resource must not reach
+type isolationTarget struct {
+	target    string
+	namespace string
+	addrs     []net.IP
+}
+
+// namespaceAddrs returns the global addresses of the namespace name, or of
+// the host namespace if empty
+func namespaceAddrs(name string) ([]net.IP, error) {
+	var ips []net.IP
+	f := func(_ ns.NetNS) error {
+		addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+		if err != nil {
+			return errors.Wrap(err, "failed to list addresses")
+		}
+
+		for _, addr := range addrs {
+			if addr.IP.IsGlobalUnicast() {
+				ips = append(ips, addr.IP)
+			}
+		}
+		return nil
+	}
+
+	if len(name) == 0 {
+		err := f(nil)
+		return ips, err
+	}
+
+	netNS, err := namespace.GetByName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get network namespace %s", name)
+	}
+	defer netNS.Close()
+
+	err = netNS.Do(f)
+	return ips, err
+}
+
+// isolationTargets returns the host namespace, and the namespaces of the
+// network resources and of the workloads of the other networks of the node
+func (n *networker) isolationTargets(networkID pkg.NetID) ([]isolationTarget, error) {
+	hostAddrs, err := namespaceAddrs("")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get addresses of host namespace")
+	}
+	targets := []isolationTarget{{target: isolationHost, addrs: hostAddrs}}
+
+	infos, err := ioutil.ReadDir(n.networkDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list stored networks")
+	}
+
+	for _, info := range infos {
+		if info.Name() == string(networkID) {
+			continue
+		}
+
+		network, err := n.networkOf(info.Name())
+		if err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("failed to load network object")
+			continue
+		}
+
+		netNR, err := ResourceByNodeID(n.nodeID, network.NetResources)
+		if err != nil {
+			continue
+		}
+
+		netr, err := nr.New(network.NetID, netNR, &network.IPRange.IPNet)
+		if err != nil {
+			return nil, err
+		}
+
+		nsName, err := netr.Namespace()
+		if err != nil {
+			return nil, err
+		}
+		if !namespace.Exists(nsName) {
+			continue
+		}
+
+		members, err := netr.Members()
+		if err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("failed to list workloads of network")
+		}
+
+		for _, name := range append([]string{nsName}, members...) {
+			addrs, err := namespaceAddrs(name)
+			if err != nil {
+				log.Error().Err(err).Str("network", info.Name()).Str("namespace", name).Msg("failed to get addresses of namespace")
+				continue
+			}
+
+			targets = append(targets, isolationTarget{
+				target:    string(network.NetID),
+				namespace: name,
+				addrs:     addrs,
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// isolationProbes returns the probes of the addresses of targets, but the
+// addresses in skip
+func isolationProbes(targets []isolationTarget, skip []net.IP) []pkg.IsolationProbe {
+	skipped := make(map[string]struct{})
+	for _, ip := range skip {
+		skipped[ip.String()] = struct{}{}
+	}
+
+	var probes []pkg.IsolationProbe
+	for _, target := range targets {
+		for _, ip := range target.addrs {
+			address := ip.String()
+			if _, ok := skipped[address]; ok {
+				continue
+			}
+			// an address shared by several namespaces is probed once
+			skipped[address] = struct{}{}
+
+			probes = append(probes, pkg.IsolationProbe{
+				Target:    target.target,
+				Namespace: target.namespace,
+				Address:   address,
+				Protocol:  "icmp",
+			})
+			for _, port := range isolationPorts {
+				probes = append(probes, pkg.IsolationProbe{
+					Target:    target.target,
+					Namespace: target.namespace,
+					Address:   address,
+					Protocol:  "tcp",
+					Port:      port,
+				})
+			}
+		}
+	}
+
+	return probes
+}
+
+// sendProbe sends p from the namespace netNS and records its result
+func sendProbe(netNS ns.NetNS, p *pkg.IsolationProbe) error {
+	ip := net.ParseIP(p.Address)
+
+	return netNS.Do(func(_ ns.NetNS) error {
+		var result probe.Result
+		switch p.Protocol {
+		case "icmp":
+			var err error
+			if result, err = probe.ICMP(ip, isolationTimeout); err != nil {
+				return err
+			}
+		case "tcp":
+			result = probe.TCP(ip, p.Port, isolationTimeout)
+		default:
+			return fmt.Errorf("unknown probe protocol '%s'", p.Protocol)
+		}
+
+		p.Reachable = result.Reachable
+		p.Detail = result.Detail
+		return nil
+	})
+}
+
+// VerifyIsolation implements pkg.Networker interface
+func (n *networker) VerifyIsolation(networkID pkg.NetID) (pkg.IsolationReport, error) {
+	report := pkg.IsolationReport{NetID: networkID}
+
+	if err := n.throttle("VerifyIsolation", string(networkID)); err != nil {
+		return report, err
+	}
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return report, err
+	}
+
+	nsName, err := netr.Namespace()
+	if err != nil {
+		return report, err
+	}
+
+	netNS, err := namespace.GetByName(nsName)
+	if err != nil {
+		return report, errors.Wrapf(err, "failed to get network namespace %s", nsName)
+	}
+	defer netNS.Close()
+
+	targets, err := n.isolationTargets(networkID)
+	if err != nil {
+		return report, err
+	}
+
+	var addrs []net.IP
+	for _, target := range targets {
+		addrs = append(addrs, target.addrs...)
+	}
+
+	// the addresses of the other networks in the ranges of this one are
+	// the workloads and the peers of this network
+	inside, err := netr.Inside(addrs)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to get routes of network resource")
+	}
+
+	report.Probes = isolationProbes(targets, inside)
+
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+		errs = make([]error, len(report.Probes))
+	)
+	for i := 0; i < isolationWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				errs[job] = sendProbe(netNS, &report.Probes[job])
+			}
+		}()
+	}
+	for i := range report.Probes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	report.Passed = true
+	for i, p := range report.Probes {
+		if errs[i] != nil {
+			return report, errors.Wrapf(errs[i], "failed to probe %s from network resource", p.Address)
+		}
+		if p.Reachable {
+			report.Passed = false
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolationProbes(t *testing.T) {
+	targets := []isolationTarget{
+		{target: isolationHost, addrs: []net.IP{net.ParseIP("10.20.0.5")}},
+		{target: "other", namespace: "n-other", addrs: []net.IP{net.ParseIP("100.127.0.4"), net.ParseIP("10.1.1.1")}},
+		// the same range as the other network
+		{target: "third", namespace: "n-third", addrs: []net.IP{net.ParseIP("10.1.1.1"), net.ParseIP("fd00::5")}},
+	}
+
+	probes := isolationProbes(targets, []net.IP{net.ParseIP("fd00::5")})
+	require.Len(t, probes, 3*(1+len(isolationPorts)))
+
+	assert.Equal(t, isolationHost, probes[0].Target)
+	assert.Equal(t, "", probes[0].Namespace)
+	assert.Equal(t, "10.20.0.5", probes[0].Address)
+	assert.Equal(t, "icmp", probes[0].Protocol)
+	assert.Equal(t, uint16(0), probes[0].Port)
+
+	assert.Equal(t, "tcp", probes[1].Protocol)
+	assert.Equal(t, isolationPorts[0], probes[1].Port)
+
+	addresses := make(map[string]string)
+	for _, p := range probes {
+		addresses[p.Address] = p.Namespace
+		assert.False(t, p.Reachable)
+	}
+	assert.Equal(t, map[string]string{
+		"10.20.0.5":   "",
+		"100.127.0.4": "n-other",
+		"10.1.1.1":    "n-other",
+	}, addresses)
+}
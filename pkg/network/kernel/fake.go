@@ -0,0 +1,488 @@
+package kernel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Fake is an in memory implementation of Kernel to be used in tests.
+// It only keeps track of the objects it was asked to create, it
+// doesn't validate them like the kernel would
+type Fake struct {
+	mu       sync.Mutex
+	links    map[string]netlink.Link
+	up       map[string]bool
+	addrs    map[string][]netlink.Addr
+	routes   []netlink.Route
+	rules    []netlink.Rule
+	states   []netlink.XfrmState
+	policies []netlink.XfrmPolicy
+	devices  map[string]*wgtypes.Device
+}
+
+var _ Kernel = (*Fake)(nil)
+
+// NewFake creates a new fake kernel
+func NewFake() *Fake {
+	return &Fake{
+		links:   make(map[string]netlink.Link),
+		up:      make(map[string]bool),
+		addrs:   make(map[string][]netlink.Addr),
+		devices: make(map[string]*wgtypes.Device),
+	}
+}
+
+// AddLink adds a link of type typ to the fake kernel and returns it
+func (f *Fake) AddLink(name, typ string) netlink.Link {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = name
+	attrs.Index = len(f.links) + 1
+
+	link := &netlink.GenericLink{LinkAttrs: attrs, LinkType: typ}
+	f.links[name] = link
+	return link
+}
+
+// IsUp returns true if the link was set up
+func (f *Fake) IsUp(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.up[name]
+}
+
+// Routes returns all the routes added to the fake kernel
+func (f *Fake) Routes() []netlink.Route {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]netlink.Route(nil), f.routes...)
+}
+
+func (f *Fake) link(link netlink.Link) (string, error) {
+	name := link.Attrs().Name
+	if _, ok := f.links[name]; !ok {
+		return "", fmt.Errorf("Link not found")
+	}
+	return name, nil
+}
+
+// LinkByName implements LinkManager
+func (f *Fake) LinkByName(name string) (netlink.Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	link, ok := f.links[name]
+	if !ok {
+		return nil, fmt.Errorf("Link not found")
+	}
+	return link, nil
+}
+
+// LinkSetUp implements LinkManager
+func (f *Fake) LinkSetUp(link netlink.Link) error {
+	return f.setUp(link, true)
+}
+
+// LinkSetDown implements LinkManager
+func (f *Fake) LinkSetDown(link netlink.Link) error {
+	return f.setUp(link, false)
+}
+
+func (f *Fake) setUp(link netlink.Link, up bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, err := f.link(link)
+	if err != nil {
+		return err
+	}
+	f.up[name] = up
+	return nil
+}
+
+// AddrList implements LinkManager
+func (f *Fake) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, err := f.link(link)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netlink.Addr
+	for _, addr := range f.addrs[name] {
+		if matchFamily(addr.IP, family) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// AddrAdd implements LinkManager
+func (f *Fake) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, err := f.link(link)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range f.addrs[name] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			return syscall.EEXIST
+		}
+	}
+	f.addrs[name] = append(f.addrs[name], *addr)
+	return nil
+}
+
+// AddrDel implements LinkManager
+func (f *Fake) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, err := f.link(link)
+	if err != nil {
+		return err
+	}
+
+	addrs := f.addrs[name]
+	for i, a := range addrs {
+		if a.IPNet.String() == addr.IPNet.String() {
+			f.addrs[name] = append(addrs[:i], addrs[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.EADDRNOTAVAIL
+}
+
+// routeTable returns the table of the route, like the kernel
+// a route without table goes to the main table
+func routeTable(r *netlink.Route) int {
+	if r.Table == 0 {
+		return syscall.RT_TABLE_MAIN
+	}
+	return r.Table
+}
+
+func sameRoute(a, b *netlink.Route) bool {
+	return a.LinkIndex == b.LinkIndex && routeTable(a) == routeTable(b) && a.Dst.String() == b.Dst.String()
+}
+
+// RouteList implements RouteManager
+func (f *Fake) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var routes []netlink.Route
+	for _, route := range f.routes {
+		if link != nil && route.LinkIndex != link.Attrs().Index {
+			continue
+		}
+		if route.Dst != nil && !matchFamily(route.Dst.IP, family) {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// RouteAdd implements RouteManager
+func (f *Fake) RouteAdd(route *netlink.Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.routes {
+		if sameRoute(&f.routes[i], route) {
+			return syscall.EEXIST
+		}
+	}
+	f.routes = append(f.routes, *route)
+	return nil
+}
+
+// RouteDel implements RouteManager
+func (f *Fake) RouteDel(route *netlink.Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.routes {
+		if sameRoute(&f.routes[i], route) {
+			f.routes = append(f.routes[:i], f.routes[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ESRCH
+}
+
+// Rules returns all the rules added to the fake kernel
+func (f *Fake) Rules() []netlink.Rule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]netlink.Rule(nil), f.rules...)
+}
+
+func sameRule(a, b *netlink.Rule) bool {
+	return a.Priority == b.Priority && a.Table == b.Table && a.IifName == b.IifName
+}
+
+// RuleList implements RuleManager, the fake only knows IPv4 rules
+func (f *Fake) RuleList(family int) ([]netlink.Rule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if family == netlink.FAMILY_V6 {
+		return nil, nil
+	}
+	return append([]netlink.Rule(nil), f.rules...), nil
+}
+
+// RuleAdd implements RuleManager
+func (f *Fake) RuleAdd(rule *netlink.Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.rules {
+		if sameRule(&f.rules[i], rule) {
+			return syscall.EEXIST
+		}
+	}
+	f.rules = append(f.rules, *rule)
+	return nil
+}
+
+// RuleDel implements RuleManager
+func (f *Fake) RuleDel(rule *netlink.Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.rules {
+		if sameRule(&f.rules[i], rule) {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ENOENT
+}
+
+// XfrmStates returns all the IPSec states added to the fake kernel
+func (f *Fake) XfrmStates() []netlink.XfrmState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]netlink.XfrmState(nil), f.states...)
+}
+
+// XfrmPolicies returns all the IPSec policies added to the fake kernel
+func (f *Fake) XfrmPolicies() []netlink.XfrmPolicy {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]netlink.XfrmPolicy(nil), f.policies...)
+}
+
+func sameState(a, b *netlink.XfrmState) bool {
+	return a.Dst.Equal(b.Dst) && a.Spi == b.Spi && a.Proto == b.Proto
+}
+
+func samePolicy(a, b *netlink.XfrmPolicy) bool {
+	return a.Src.String() == b.Src.String() && a.Dst.String() == b.Dst.String() && a.Dir == b.Dir
+}
+
+func (f *Fake) stateIndex(state *netlink.XfrmState) int {
+	for i := range f.states {
+		if sameState(&f.states[i], state) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *Fake) policyIndex(policy *netlink.XfrmPolicy) int {
+	for i := range f.policies {
+		if samePolicy(&f.policies[i], policy) {
+			return i
+		}
+	}
+	return -1
+}
+
+// XfrmStateAdd implements XfrmManager
+func (f *Fake) XfrmStateAdd(state *netlink.XfrmState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stateIndex(state) >= 0 {
+		return syscall.EEXIST
+	}
+	f.states = append(f.states, *state)
+	return nil
+}
+
+// XfrmStateUpdate implements XfrmManager
+func (f *Fake) XfrmStateUpdate(state *netlink.XfrmState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := f.stateIndex(state)
+	if index < 0 {
+		return syscall.ESRCH
+	}
+	f.states[index] = *state
+	return nil
+}
+
+// XfrmStateDel implements XfrmManager
+func (f *Fake) XfrmStateDel(state *netlink.XfrmState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := f.stateIndex(state)
+	if index < 0 {
+		return syscall.ESRCH
+	}
+	f.states = append(f.states[:index], f.states[index+1:]...)
+	return nil
+}
+
+// XfrmPolicyAdd implements XfrmManager
+func (f *Fake) XfrmPolicyAdd(policy *netlink.XfrmPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.policyIndex(policy) >= 0 {
+		return syscall.EEXIST
+	}
+	f.policies = append(f.policies, *policy)
+	return nil
+}
+
+// XfrmPolicyUpdate implements XfrmManager
+func (f *Fake) XfrmPolicyUpdate(policy *netlink.XfrmPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := f.policyIndex(policy)
+	if index < 0 {
+		return syscall.ENOENT
+	}
+	f.policies[index] = *policy
+	return nil
+}
+
+// XfrmPolicyDel implements XfrmManager
+func (f *Fake) XfrmPolicyDel(policy *netlink.XfrmPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := f.policyIndex(policy)
+	if index < 0 {
+		return syscall.ENOENT
+	}
+	f.policies = append(f.policies[:index], f.policies[index+1:]...)
+	return nil
+}
+
+// Device implements WGManager
+func (f *Fake) Device(name string) (*wgtypes.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	device, ok := f.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("wireguard device %s not found", name)
+	}
+	return device, nil
+}
+
+// ConfigureDevice implements WGManager
+func (f *Fake) ConfigureDevice(name string, config wgtypes.Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	link, ok := f.links[name]
+	if !ok || link.Type() != "wireguard" {
+		return fmt.Errorf("wireguard device %s not found", name)
+	}
+
+	device, ok := f.devices[name]
+	if !ok {
+		device = &wgtypes.Device{Name: name}
+		f.devices[name] = device
+	}
+
+	if config.PrivateKey != nil {
+		device.PrivateKey = *config.PrivateKey
+		device.PublicKey = config.PrivateKey.PublicKey()
+	}
+	if config.ListenPort != nil {
+		device.ListenPort = *config.ListenPort
+	}
+	if config.ReplacePeers {
+		device.Peers = nil
+	}
+
+	for _, pc := range config.Peers {
+		index := -1
+		for i := range device.Peers {
+			if device.Peers[i].PublicKey == pc.PublicKey {
+				index = i
+				break
+			}
+		}
+
+		if pc.Remove {
+			if index >= 0 {
+				device.Peers = append(device.Peers[:index], device.Peers[index+1:]...)
+			}
+			continue
+		}
+
+		if index < 0 {
+			if pc.UpdateOnly {
+				continue
+			}
+			device.Peers = append(device.Peers, wgtypes.Peer{PublicKey: pc.PublicKey})
+			index = len(device.Peers) - 1
+		}
+
+		peer := &device.Peers[index]
+		if pc.PresharedKey != nil {
+			peer.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			peer.Endpoint = pc.Endpoint
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			peer.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			peer.AllowedIPs = nil
+		}
+		peer.AllowedIPs = append(peer.AllowedIPs, pc.AllowedIPs...)
+	}
+
+	return nil
+}
+
+func matchFamily(ip net.IP, family int) bool {
+	switch family {
+	case netlink.FAMILY_V4:
+		return ip.To4() != nil
+	case netlink.FAMILY_V6:
+		return ip.To4() == nil
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package kernel
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestFakeAddrs(t *testing.T) {
+	k := NewFake()
+	link := k.AddLink("eth0", "dummy")
+
+	addr, err := netlink.ParseAddr("10.1.0.1/24")
+	require.NoError(t, err)
+	require.NoError(t, k.AddrAdd(link, addr))
+
+	err = k.AddrAdd(link, addr)
+	assert.True(t, os.IsExist(err))
+
+	addr6, err := netlink.ParseAddr("fd00::1/64")
+	require.NoError(t, err)
+	require.NoError(t, k.AddrAdd(link, addr6))
+
+	addrs, err := k.AddrList(link, netlink.FAMILY_V4)
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	assert.Equal(t, "10.1.0.1/24", addrs[0].IPNet.String())
+
+	require.NoError(t, k.AddrDel(link, addr))
+	addrs, err = k.AddrList(link, netlink.FAMILY_ALL)
+	require.NoError(t, err)
+	assert.Len(t, addrs, 1)
+}
+
+func TestFakeRoutes(t *testing.T) {
+	k := NewFake()
+	link := k.AddLink("eth0", "dummy")
+
+	_, dst, err := net.ParseCIDR("10.2.0.0/16")
+	require.NoError(t, err)
+
+	route := netlink.Route{Dst: dst, LinkIndex: link.Attrs().Index}
+	require.NoError(t, k.RouteAdd(&route))
+	assert.True(t, os.IsExist(k.RouteAdd(&route)))
+
+	routes, err := k.RouteList(link, netlink.FAMILY_V4)
+	require.NoError(t, err)
+	assert.Len(t, routes, 1)
+
+	require.NoError(t, k.RouteDel(&route))
+	assert.Empty(t, k.Routes())
+}
+
+func TestFakeWireguard(t *testing.T) {
+	k := NewFake()
+	link := k.AddLink("wg0", "wireguard")
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peerKey := key.PublicKey()
+
+	_, allowed, err := net.ParseCIDR("172.21.0.0/24")
+	require.NoError(t, err)
+
+	port := 1600
+	err = k.ConfigureDevice(link.Attrs().Name, wgtypes.Config{
+		PrivateKey:   &key,
+		ListenPort:   &port,
+		ReplacePeers: true,
+		Peers: []wgtypes.PeerConfig{
+			{PublicKey: peerKey, AllowedIPs: []net.IPNet{*allowed}},
+		},
+	})
+	require.NoError(t, err)
+
+	device, err := k.Device("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, 1600, device.ListenPort)
+	assert.Equal(t, key.PublicKey(), device.PublicKey)
+	require.Len(t, device.Peers, 1)
+	assert.Equal(t, peerKey, device.Peers[0].PublicKey)
+
+	err = k.ConfigureDevice("wg0", wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: peerKey, Remove: true}},
+	})
+	require.NoError(t, err)
+	device, err = k.Device("wg0")
+	require.NoError(t, err)
+	assert.Empty(t, device.Peers)
+
+	assert.Error(t, k.ConfigureDevice("eth0", wgtypes.Config{}))
+}
@@ -0,0 +1,176 @@
+// Package kernel abstracts the calls networkd makes to the kernel
+// (netlink and wireguard), so the code configuring the network
+// can be tested without root privileges.
+package kernel
+
+import (
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// LinkManager manages the links and their addresses
+type LinkManager interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkSetUp(link netlink.Link) error
+	LinkSetDown(link netlink.Link) error
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+}
+
+// RouteManager manages the routes
+type RouteManager interface {
+	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+	RouteAdd(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+}
+
+// RuleManager manages the routing policy rules
+type RuleManager interface {
+	RuleList(family int) ([]netlink.Rule, error)
+	RuleAdd(rule *netlink.Rule) error
+	RuleDel(rule *netlink.Rule) error
+}
+
+// XfrmManager manages the IPSec security associations and policies
+type XfrmManager interface {
+	XfrmStateAdd(state *netlink.XfrmState) error
+	XfrmStateUpdate(state *netlink.XfrmState) error
+	XfrmStateDel(state *netlink.XfrmState) error
+	XfrmPolicyAdd(policy *netlink.XfrmPolicy) error
+	XfrmPolicyUpdate(policy *netlink.XfrmPolicy) error
+	XfrmPolicyDel(policy *netlink.XfrmPolicy) error
+}
+
+// WGManager manages the configuration of the wireguard devices
+type WGManager interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, config wgtypes.Config) error
+}
+
+// Kernel is the interface to all the kernel networking APIs
+type Kernel interface {
+	LinkManager
+	RouteManager
+	RuleManager
+	XfrmManager
+	WGManager
+}
+
+// Netlink implements Kernel using the real netlink and wgctrl APIs
+type Netlink struct{}
+
+var _ Kernel = Netlink{}
+
+// LinkByName implements LinkManager
+func (Netlink) LinkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+// LinkSetUp implements LinkManager
+func (Netlink) LinkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+
+// LinkSetDown implements LinkManager
+func (Netlink) LinkSetDown(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+
+// AddrList implements LinkManager
+func (Netlink) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return netlink.AddrList(link, family)
+}
+
+// AddrAdd implements LinkManager
+func (Netlink) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrAdd(link, addr)
+}
+
+// AddrDel implements LinkManager
+func (Netlink) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrDel(link, addr)
+}
+
+// RouteList implements RouteManager
+func (Netlink) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	return netlink.RouteList(link, family)
+}
+
+// RouteAdd implements RouteManager
+func (Netlink) RouteAdd(route *netlink.Route) error {
+	return netlink.RouteAdd(route)
+}
+
+// RouteDel implements RouteManager
+func (Netlink) RouteDel(route *netlink.Route) error {
+	return netlink.RouteDel(route)
+}
+
+// RuleList implements RuleManager
+func (Netlink) RuleList(family int) ([]netlink.Rule, error) {
+	return netlink.RuleList(family)
+}
+
+// RuleAdd implements RuleManager
+func (Netlink) RuleAdd(rule *netlink.Rule) error {
+	return netlink.RuleAdd(rule)
+}
+
+// RuleDel implements RuleManager
+func (Netlink) RuleDel(rule *netlink.Rule) error {
+	return netlink.RuleDel(rule)
+}
+
+// XfrmStateAdd implements XfrmManager
+func (Netlink) XfrmStateAdd(state *netlink.XfrmState) error {
+	return netlink.XfrmStateAdd(state)
+}
+
+// XfrmStateUpdate implements XfrmManager
+func (Netlink) XfrmStateUpdate(state *netlink.XfrmState) error {
+	return netlink.XfrmStateUpdate(state)
+}
+
+// XfrmStateDel implements XfrmManager
+func (Netlink) XfrmStateDel(state *netlink.XfrmState) error {
+	return netlink.XfrmStateDel(state)
+}
+
+// XfrmPolicyAdd implements XfrmManager
+func (Netlink) XfrmPolicyAdd(policy *netlink.XfrmPolicy) error {
+	return netlink.XfrmPolicyAdd(policy)
+}
+
+// XfrmPolicyUpdate implements XfrmManager
+func (Netlink) XfrmPolicyUpdate(policy *netlink.XfrmPolicy) error {
+	return netlink.XfrmPolicyUpdate(policy)
+}
+
+// XfrmPolicyDel implements XfrmManager
+func (Netlink) XfrmPolicyDel(policy *netlink.XfrmPolicy) error {
+	return netlink.XfrmPolicyDel(policy)
+}
+
+// Device implements WGManager
+func (Netlink) Device(name string) (*wgtypes.Device, error) {
+	wc, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer wc.Close()
+
+	return wc.Device(name)
+}
+
+// ConfigureDevice implements WGManager
+func (Netlink) ConfigureDevice(name string, config wgtypes.Config) error {
+	wc, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer wc.Close()
+
+	return wc.ConfigureDevice(name, config)
+}
@@ -0,0 +1,519 @@
+package network
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/damping"
+	"github.com/threefoldtech/zos/pkg/network/nr"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// linksInterval is the time between two scans of the monitored links. The
+// configuration of the links that are up is recorded on every scan
+const linksInterval = 30 * time.Second
+
+// linkState is the state of a monitored link
+type linkState struct {
+	namespace string
+	name      string
+	kind      pkg.LinkKind
+	up        bool
+	damper    *damping.Damper
+	// suppressed is the damping state sent with the last event
+	suppressed bool
+
+	// addrs and routes are the configuration of the link recorded while
+	// it was up, they are re-applied when it recovers
+	addrs  []netlink.Addr
+	routes []netlink.Route
+}
+
+// linkUpdate is a link update received from a namespace
+type linkUpdate struct {
+	namespace string
+	update    netlink.LinkUpdate
+}
+
+// linkWatcher follows the state of the physical interfaces, the bridges
+// and the wireguard interfaces of the network resources
+type linkWatcher struct {
+	mu     sync.Mutex
+	links  map[string]*linkState
+	events map[chan pkg.LinkEvent]struct{}
+}
+
+func newLinkWatcher() *linkWatcher {
+	return &linkWatcher{
+		links:  make(map[string]*linkState),
+		events: make(map[chan pkg.LinkEvent]struct{}),
+	}
+}
+
+func linkKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// isUp returns true if the link is up and has a carrier, the wireguard
+// interfaces don't report their carrier
+func isUp(attrs *netlink.LinkAttrs) bool {
+	return attrs.Flags&net.FlagUp != 0 &&
+		(attrs.OperState == netlink.OperUp || attrs.OperState == netlink.OperUnknown)
+}
+
+// handle returns a netlink handle in namespace, the host namespace if empty
+func handle(namespace string) (*netlink.Handle, func(), error) {
+	if len(namespace) == 0 {
+		return &netlink.Handle{}, func() {}, nil
+	}
+
+	ns, err := netns.GetFromName(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, nil, err
+	}
+
+	return h, func() {
+		h.Delete()
+		ns.Close()
+	}, nil
+}
+
+// configuration returns the addresses and the routes of link that are
+// re-applied when it recovers. The configuration created by the kernel or
+// from the router advertisements is left to the kernel
+func configuration(h *netlink.Handle, link netlink.Link) ([]netlink.Addr, []netlink.Route, error) {
+	all, err := h.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list addresses")
+	}
+
+	var addrs []netlink.Addr
+	for _, addr := range all {
+		if addr.Flags&unix.IFA_F_PERMANENT == 0 || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	filter := netlink.Route{LinkIndex: link.Attrs().Index, Table: unix.RT_TABLE_UNSPEC}
+	found, err := h.RouteListFiltered(netlink.FAMILY_ALL, &filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list routes")
+	}
+
+	var routes []netlink.Route
+	for _, route := range found {
+		if route.Protocol == unix.RTPROT_KERNEL || route.Protocol == unix.RTPROT_RA {
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	return addrs, routes, nil
+}
+
+// restore re-applies the recorded configuration of the link, it returns
+// the number of addresses and routes that were missing
+func (s *linkState) restore() (int, error) {
+	h, release, err := handle(s.namespace)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	link, err := h.LinkByName(s.name)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, addr := range s.addrs {
+		addr := addr
+		addr.Label = ""
+		if err := h.AddrAdd(link, &addr); os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return restored, errors.Wrapf(err, "failed to restore address %s", addr.IPNet.String())
+		}
+		restored++
+	}
+
+	// the addresses are restored first, the routes may use them as source
+	for _, route := range s.routes {
+		route := route
+		route.LinkIndex = link.Attrs().Index
+		if err := h.RouteAdd(&route); os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return restored, errors.Wrapf(err, "failed to restore route %s", route.String())
+		}
+		restored++
+	}
+
+	return restored, nil
+}
+
+// record records the configuration of the link if it's up and stable
+func (s *linkState) record() {
+	if !s.up || s.damper.Suppressed(time.Now()) {
+		return
+	}
+
+	h, release, err := handle(s.namespace)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	link, err := h.LinkByName(s.name)
+	if err != nil {
+		return
+	}
+
+	addrs, routes, err := configuration(h, link)
+	if err != nil {
+		log.Error().Err(err).Str("namespace", s.namespace).Str("link", s.name).Msg("failed to record link configuration")
+		return
+	}
+
+	s.addrs, s.routes = addrs, routes
+}
+
+// emit sends the event to the subscribers, the events are dropped for the
+// subscribers that don't keep up. It must be called with the lock held
+func (w *linkWatcher) emit(event pkg.LinkEvent) {
+	for ch := range w.events {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// event builds the event of the state of s at now
+func (s *linkState) event(now time.Time) pkg.LinkEvent {
+	s.suppressed = s.damper.Suppressed(now)
+	return pkg.LinkEvent{
+		Namespace:  s.namespace,
+		Interface:  s.name,
+		Kind:       s.kind,
+		Up:         s.up,
+		Suppressed: s.suppressed,
+		Penalty:    s.damper.Penalty(now),
+		Time:       now,
+	}
+}
+
+// recover re-applies the configuration of a link that is up again and
+// builds its event
+func (s *linkState) recover(now time.Time) pkg.LinkEvent {
+	event := s.event(now)
+
+	restored, err := s.restore()
+	event.Restored = restored
+	logger := log.With().Str("namespace", s.namespace).Str("link", s.name).Logger()
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to restore link configuration")
+	} else if restored != 0 {
+		logger.Info().Int("restored", restored).Msg("link configuration restored")
+	}
+
+	return event
+}
+
+// update handles an update of a link
+func (w *linkWatcher) update(u linkUpdate) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	attrs := u.update.Link.Attrs()
+	state, ok := w.links[linkKey(u.namespace, attrs.Name)]
+	if !ok {
+		// not monitored, or not found yet by a scan
+		return
+	}
+
+	up := isUp(attrs)
+	if up == state.up {
+		return
+	}
+
+	now := time.Now()
+	state.up = up
+
+	var event pkg.LinkEvent
+	switch {
+	case !up:
+		// the link flaps when it goes down
+		state.damper.Flap(now)
+		event = state.event(now)
+	case state.damper.Suppressed(now):
+		// the configuration is restored once the link is stable again
+		event = state.event(now)
+	default:
+		event = state.recover(now)
+	}
+
+	log.Info().
+		Str("namespace", event.Namespace).
+		Str("link", event.Interface).
+		Bool("up", event.Up).
+		Bool("suppressed", event.Suppressed).
+		Float64("penalty", event.Penalty).
+		Msg("link state changed")
+	w.emit(event)
+}
+
+// monitoredLinks returns the links to monitor by key
+func (n *networker) monitoredLinks() map[string]*linkState {
+	links := make(map[string]*linkState)
+	add := func(namespace string, link netlink.Link, kind pkg.LinkKind) {
+		attrs := link.Attrs()
+		links[linkKey(namespace, attrs.Name)] = &linkState{
+			namespace: namespace,
+			name:      attrs.Name,
+			kind:      kind,
+			up:        isUp(attrs),
+		}
+	}
+
+	hostLinks, err := netlink.LinkList()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list links")
+	}
+	for _, link := range hostLinks {
+		switch {
+		case link.Type() == "bridge":
+			add("", link, pkg.LinkBridge)
+		case link.Type() == "device" && link.Attrs().Flags&net.FlagLoopback == 0:
+			if _, err := os.Stat("/sys/class/net/" + link.Attrs().Name + "/device"); err == nil {
+				add("", link, pkg.LinkNIC)
+			}
+		}
+	}
+
+	infos, err := ioutil.ReadDir(n.networkDir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list stored networks")
+		return links
+	}
+
+	for _, info := range infos {
+		network, err := n.networkOf(info.Name())
+		if err != nil {
+			continue
+		}
+
+		netNR, err := ResourceByNodeID(n.nodeID, network.NetResources)
+		if err != nil {
+			continue
+		}
+
+		netr, err := nr.New(network.NetID, netNR, &network.IPRange.IPNet)
+		if err != nil {
+			continue
+		}
+
+		namespace, _ := netr.Namespace()
+		wgName, _ := netr.WGName()
+
+		h, release, err := handle(namespace)
+		if err != nil {
+			continue
+		}
+		if link, err := h.LinkByName(wgName); err == nil {
+			add(namespace, link, pkg.LinkWireguard)
+		}
+		release()
+	}
+
+	return links
+}
+
+// scan updates the monitored links and records their configuration. It
+// returns the namespaces of the links
+func (w *linkWatcher) scan(n *networker) map[string]bool {
+	found := n.monitoredLinks()
+	namespaces := make(map[string]bool)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for key, state := range found {
+		namespaces[state.namespace] = true
+
+		current, ok := w.links[key]
+		if !ok {
+			state.damper = damping.New(damping.DefaultConfig)
+			w.links[key] = state
+			state.record()
+			continue
+		}
+
+		// a suppressed link that is stable again gets its configuration
+		// back, it missed its recovery
+		if current.suppressed && !current.damper.Suppressed(now) {
+			event := current.event(now)
+			if current.up {
+				event = current.recover(now)
+			}
+			log.Info().Str("namespace", event.Namespace).Str("link", event.Interface).Msg("link released from flap damping")
+			w.emit(event)
+		}
+
+		current.record()
+	}
+
+	for key := range w.links {
+		if _, ok := found[key]; !ok {
+			delete(w.links, key)
+		}
+	}
+
+	return namespaces
+}
+
+// subscribe forwards the link updates of namespace to updates until done
+// is closed
+func subscribe(namespace string, updates chan<- linkUpdate, done <-chan struct{}) error {
+	ch := make(chan netlink.LinkUpdate)
+	if len(namespace) == 0 {
+		if err := netlink.LinkSubscribe(ch, done); err != nil {
+			return err
+		}
+	} else {
+		ns, err := netns.GetFromName(namespace)
+		if err != nil {
+			return err
+		}
+		defer ns.Close()
+
+		if err := netlink.LinkSubscribeAt(ns, ch, done); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		// ch is drained until netlink closes it, the updates received
+		// after done are dropped
+		for update := range ch {
+			select {
+			case updates <- linkUpdate{namespace: namespace, update: update}:
+			case <-done:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// WatchLinks monitors the state of the physical interfaces, the bridges and
+// the wireguard interfaces of the network resources until ctx is canceled.
+// A link that goes down and up too often is damped: its recoveries don't
+// re-apply its configuration until it's stable again
+func WatchLinks(ctx context.Context, nw pkg.Networker) {
+	n, ok := nw.(*networker)
+	if !ok {
+		log.Error().Msg("link monitoring not supported by this networker")
+		return
+	}
+
+	updates := make(chan linkUpdate)
+	subscriptions := make(map[string]chan struct{})
+	defer func() {
+		for _, done := range subscriptions {
+			close(done)
+		}
+	}()
+
+	ticker := time.NewTicker(linksInterval)
+	defer ticker.Stop()
+
+	for {
+		namespaces := n.links.scan(n)
+		// the host namespace is always monitored
+		namespaces[""] = true
+
+		for namespace := range namespaces {
+			if _, ok := subscriptions[namespace]; ok {
+				continue
+			}
+
+			done := make(chan struct{})
+			if err := subscribe(namespace, updates, done); err != nil {
+				log.Error().Err(err).Str("namespace", namespace).Msg("failed to monitor links")
+				continue
+			}
+			subscriptions[namespace] = done
+		}
+
+		for namespace, done := range subscriptions {
+			if !namespaces[namespace] {
+				close(done)
+				delete(subscriptions, namespace)
+			}
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-updates:
+				n.links.update(u)
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+// LinkEvents implements pkg.Networker interface
+func (n *networker) LinkEvents(ctx context.Context) <-chan pkg.LinkEvent {
+	events := make(chan pkg.LinkEvent, 16)
+
+	n.links.mu.Lock()
+	n.links.events[events] = struct{}{}
+	n.links.mu.Unlock()
+
+	ch := make(chan pkg.LinkEvent)
+	go func() {
+		defer func() {
+			n.links.mu.Lock()
+			delete(n.links.events, events)
+			n.links.mu.Unlock()
+			close(ch)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
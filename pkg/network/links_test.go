@@ -0,0 +1,76 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/damping"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIsUp(t *testing.T) {
+	assert.True(t, isUp(&netlink.LinkAttrs{Flags: net.FlagUp, OperState: netlink.OperUp}))
+	// wireguard interfaces don't report their carrier
+	assert.True(t, isUp(&netlink.LinkAttrs{Flags: net.FlagUp, OperState: netlink.OperUnknown}))
+	// no carrier
+	assert.False(t, isUp(&netlink.LinkAttrs{Flags: net.FlagUp, OperState: netlink.OperDown}))
+	assert.False(t, isUp(&netlink.LinkAttrs{OperState: netlink.OperUp}))
+}
+
+func TestLinkWatcherDamping(t *testing.T) {
+	w := newLinkWatcher()
+	w.links[linkKey("", "zos-test0")] = &linkState{
+		name:   "zos-test0",
+		kind:   pkg.LinkNIC,
+		up:     true,
+		damper: damping.New(damping.DefaultConfig),
+	}
+
+	events := make(chan pkg.LinkEvent, 16)
+	w.events[events] = struct{}{}
+
+	set := func(up bool) pkg.LinkEvent {
+		attrs := netlink.LinkAttrs{Name: "zos-test0", OperState: netlink.OperDown}
+		if up {
+			attrs.Flags, attrs.OperState = net.FlagUp, netlink.OperUp
+		}
+		w.update(linkUpdate{update: netlink.LinkUpdate{Link: &netlink.Dummy{LinkAttrs: attrs}}})
+
+		require.Len(t, events, 1)
+		return <-events
+	}
+
+	event := set(false)
+	assert.False(t, event.Up)
+	assert.False(t, event.Suppressed)
+	assert.Equal(t, pkg.LinkNIC, event.Kind)
+
+	event = set(true)
+	assert.True(t, event.Up)
+	assert.False(t, event.Suppressed)
+
+	set(false)
+	set(true)
+	// the third flap suppresses the link
+	event = set(false)
+	assert.True(t, event.Suppressed)
+	event = set(true)
+	assert.True(t, event.Up)
+	assert.True(t, event.Suppressed)
+	assert.Equal(t, 0, event.Restored)
+
+	// no event when the state doesn't change
+	w.update(linkUpdate{update: netlink.LinkUpdate{Link: &netlink.Dummy{
+		LinkAttrs: netlink.LinkAttrs{Name: "zos-test0", Flags: net.FlagUp, OperState: netlink.OperUp},
+	}}})
+	assert.Len(t, events, 0)
+
+	// links that are not monitored are ignored
+	w.update(linkUpdate{namespace: "ns", update: netlink.LinkUpdate{Link: &netlink.Dummy{
+		LinkAttrs: netlink.LinkAttrs{Name: "zos-test0"},
+	}}})
+	assert.Len(t, events, 0)
+}
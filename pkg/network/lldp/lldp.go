@@ -0,0 +1,218 @@
+// Package lldp listens to the LLDP advertisements of the switches the
+// physical interfaces of the node are connected to.
+//
+// The node only listens, it never advertises itself: the advertisements tell
+// the farmer which switch port every uplink is cabled to, from the node side.
+package lldp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// etherType is the ethernet type of the LLDP frames
+	etherType = 0x88cc
+
+	tlvEnd               = 0
+	tlvChassisID         = 1
+	tlvPortID            = 2
+	tlvTTL               = 3
+	tlvPortDescription   = 4
+	tlvSystemName        = 5
+	tlvSystemDescription = 6
+
+	// the subtypes of the chassis and port ids that are not text
+	chassisMAC     = 4
+	chassisAddress = 5
+	portMAC        = 3
+	portAddress    = 4
+
+	// readTimeout is the time a read waits for a frame before checking if
+	// the listener is canceled
+	readTimeout = time.Second
+)
+
+// nearestBridge is the multicast address the switches send the LLDP frames to
+var nearestBridge = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// Neighbor is the switch port advertised on an interface
+type Neighbor struct {
+	// MAC is the source address of the advertisement
+	MAC               net.HardwareAddr
+	ChassisID         string
+	PortID            string
+	PortDescription   string
+	SystemName        string
+	SystemDescription string
+	// TTL is the time the advertisement is valid, a zero TTL means the
+	// port is shutting down
+	TTL time.Duration
+}
+
+// id formats a chassis or a port id, the addresses are formatted and the
+// other subtypes are text
+func id(value []byte, mac, address byte) string {
+	if len(value) < 2 {
+		return ""
+	}
+
+	subtype, value := value[0], value[1:]
+	switch {
+	case subtype == mac && len(value) == 6:
+		return net.HardwareAddr(value).String()
+	case subtype == address && len(value) > 1:
+		// the first byte is the IANA family of the address
+		if ip := net.IP(value[1:]); len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+			return ip.String()
+		}
+	}
+
+	return text(value)
+}
+
+func text(value []byte) string {
+	return strings.TrimRight(strings.TrimSpace(string(value)), "\x00")
+}
+
+// Parse decodes the LLDP data unit pdu, the payload of a frame
+func Parse(pdu []byte) (Neighbor, error) {
+	var neighbor Neighbor
+	var ttl bool
+
+	for len(pdu) != 0 {
+		if len(pdu) < 2 {
+			return neighbor, fmt.Errorf("truncated tlv")
+		}
+
+		header := binary.BigEndian.Uint16(pdu[:2])
+		typ, length := header>>9, int(header&0x1ff)
+		if len(pdu) < 2+length {
+			return neighbor, fmt.Errorf("invalid tlv length %d", length)
+		}
+		value := pdu[2 : 2+length]
+		pdu = pdu[2+length:]
+
+		switch typ {
+		case tlvEnd:
+			pdu = nil
+		case tlvChassisID:
+			neighbor.ChassisID = id(value, chassisMAC, chassisAddress)
+		case tlvPortID:
+			neighbor.PortID = id(value, portMAC, portAddress)
+		case tlvTTL:
+			if length != 2 {
+				return neighbor, fmt.Errorf("invalid ttl")
+			}
+			neighbor.TTL = time.Duration(binary.BigEndian.Uint16(value)) * time.Second
+			ttl = true
+		case tlvPortDescription:
+			neighbor.PortDescription = text(value)
+		case tlvSystemName:
+			neighbor.SystemName = text(value)
+		case tlvSystemDescription:
+			neighbor.SystemDescription = text(value)
+		}
+	}
+
+	// the 3 first tlvs are mandatory
+	if len(neighbor.ChassisID) == 0 || len(neighbor.PortID) == 0 || !ttl {
+		return neighbor, fmt.Errorf("missing mandatory tlv")
+	}
+
+	return neighbor, nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// socket opens a packet socket on iface that receives the LLDP frames
+func socket(iface *net.Interface) (int, error) {
+	// a datagram socket strips the ethernet header
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(etherType)))
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to open packet socket")
+	}
+
+	addr := unix.SockaddrLinklayer{Protocol: htons(etherType), Ifindex: iface.Index}
+	if err := unix.Bind(fd, &addr); err != nil {
+		unix.Close(fd)
+		return -1, errors.Wrapf(err, "failed to bind to %s", iface.Name)
+	}
+
+	// the frames are sent to a multicast address the interface doesn't
+	// receive by default
+	membership := unix.PacketMreq{
+		Ifindex: int32(iface.Index),
+		Type:    unix.PACKET_MR_MULTICAST,
+		Alen:    uint16(len(nearestBridge)),
+	}
+	copy(membership.Address[:], nearestBridge)
+	if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &membership); err != nil {
+		unix.Close(fd)
+		return -1, errors.Wrap(err, "failed to join LLDP multicast group")
+	}
+
+	timeout := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// Listen streams the neighbors advertised on the interface iface until ctx
+// is canceled, or the interface is removed
+func Listen(ctx context.Context, iface string) (<-chan Neighbor, error) {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := socket(link)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Neighbor)
+	go func() {
+		defer close(ch)
+		defer unix.Close(fd)
+
+		buf := make([]byte, 1500)
+		for ctx.Err() == nil {
+			n, from, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			} else if err != nil {
+				return
+			}
+
+			neighbor, err := Parse(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			if from, ok := from.(*unix.SockaddrLinklayer); ok && from.Halen == 6 {
+				neighbor.MAC = make(net.HardwareAddr, 6)
+				copy(neighbor.MAC, from.Addr[:6])
+			}
+
+			select {
+			case ch <- neighbor:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,74 @@
+package lldp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tlv(typ uint16, value ...byte) []byte {
+	header := typ<<9 | uint16(len(value))
+	return append([]byte{byte(header >> 8), byte(header)}, value...)
+}
+
+func pdu(tlvs ...[]byte) []byte {
+	var result []byte
+	for _, t := range tlvs {
+		result = append(result, t...)
+	}
+
+	return result
+}
+
+func TestParse(t *testing.T) {
+	neighbor, err := Parse(pdu(
+		tlv(tlvChassisID, chassisMAC, 0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcc),
+		tlv(tlvPortID, 5, 'E', 't', 'h', '1', '/', '4', '8'),
+		tlv(tlvTTL, 0, 120),
+		tlv(tlvPortDescription, []byte("node-03 uplink")...),
+		tlv(tlvSystemName, []byte("rack1-tor.farm")...),
+		tlv(tlvSystemDescription, []byte("Arista Networks EOS\x00")...),
+		// unknown tlvs are skipped
+		tlv(127, 0x00, 0x80, 0xc2, 0x01, 0x00, 0x01),
+		tlv(tlvEnd),
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, "00:1b:21:aa:bb:cc", neighbor.ChassisID)
+	assert.Equal(t, "Eth1/48", neighbor.PortID)
+	assert.Equal(t, 120*time.Second, neighbor.TTL)
+	assert.Equal(t, "node-03 uplink", neighbor.PortDescription)
+	assert.Equal(t, "rack1-tor.farm", neighbor.SystemName)
+	assert.Equal(t, "Arista Networks EOS", neighbor.SystemDescription)
+}
+
+func TestParseAddresses(t *testing.T) {
+	neighbor, err := Parse(pdu(
+		tlv(tlvChassisID, chassisAddress, 1, 10, 0, 0, 1),
+		tlv(tlvPortID, portMAC, 0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcd),
+		tlv(tlvTTL, 0, 0),
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.0.0.1", neighbor.ChassisID)
+	assert.Equal(t, "00:1b:21:aa:bb:cd", neighbor.PortID)
+	assert.Equal(t, time.Duration(0), neighbor.TTL)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0x02},
+		// length past the end
+		{0x02, 0x10, chassisMAC},
+		// missing ttl
+		pdu(tlv(tlvChassisID, 7, 'a'), tlv(tlvPortID, 7, 'b')),
+		// invalid ttl
+		pdu(tlv(tlvChassisID, 7, 'a'), tlv(tlvPortID, 7, 'b'), tlv(tlvTTL, 120)),
+	} {
+		_, err := Parse(data)
+		assert.Error(t, err, "%v", data)
+	}
+}
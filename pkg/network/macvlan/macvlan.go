@@ -9,7 +9,8 @@ import (
 
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -20,6 +21,10 @@ const ipv4InterfaceArpProxySysctlTemplate = "net.ipv4.conf.%s.proxy_arp"
 // master is the name of the device used as master for the macvlan interface
 // netns is network namespace where to create the macvlan
 func Create(name string, master string, netns ns.NetNS) (*netlink.Macvlan, error) {
+	nsName, err := namespace.Name(netns)
+	if err != nil {
+		return nil, err
+	}
 
 	m, err := netlink.LinkByName(master)
 	if err != nil {
@@ -33,9 +38,11 @@ func Create(name string, master string, netns ns.NetNS) (*netlink.Macvlan, error
 		return nil, err
 	}
 
+	// the macvlan has the MTU of its master, a jumbo frames capable master
+	// gives a jumbo frames capable macvlan
 	mv := &netlink.Macvlan{
 		LinkAttrs: netlink.LinkAttrs{
-			MTU:         1500,
+			MTU:         m.Attrs().MTU,
 			Name:        tmpName,
 			ParentIndex: m.Attrs().Index,
 			Namespace:   netlink.NsFd(int(netns.Fd())),
@@ -48,18 +55,6 @@ func Create(name string, master string, netns ns.NetNS) (*netlink.Macvlan, error
 	}
 
 	err = netns.Do(func(_ ns.NetNS) error {
-		// TODO: duplicate following lines for ipv6 support, when it will be added in other places
-
-		// containernetworking sets it up in some ref code somewhere, we copied it. we were stoopit
-		// disable proxy_arp, as it is ony useful in some very distinct cases, and otherwise can wreak
-		// havoc in networks -> 0!
-		ipv4SysctlValueName := fmt.Sprintf(ipv4InterfaceArpProxySysctlTemplate, tmpName)
-		if _, err := sysctl.Sysctl(ipv4SysctlValueName, "0"); err != nil {
-			// remove the newly added link and ignore errors, because we already are in a failed state
-			_ = netlink.LinkDel(mv)
-			return fmt.Errorf("failed to set proxy_arp on newly added interface %q: %v", tmpName, err)
-		}
-
 		err := ip.RenameLink(tmpName, name)
 		if err != nil {
 			_ = netlink.LinkDel(mv)
@@ -77,6 +72,19 @@ func Create(name string, master string, netns ns.NetNS) (*netlink.Macvlan, error
 			return fmt.Errorf("link %s should be of type macvlan", name)
 		}
 
+		// TODO: duplicate following lines for ipv6 support, when it will be added in other places
+
+		// containernetworking sets it up in some ref code somewhere, we copied it. we were stoopit
+		// disable proxy_arp, as it is ony useful in some very distinct cases, and otherwise can wreak
+		// havoc in networks -> 0!
+		// it's set once the link has its final name, so the sysctl is recorded under that name
+		ipv4SysctlValueName := fmt.Sprintf(ipv4InterfaceArpProxySysctlTemplate, name)
+		if err := sysctl.Set(nsName, ipv4SysctlValueName, "0"); err != nil {
+			// remove the newly added link and ignore errors, because we already are in a failed state
+			_ = netlink.LinkDel(link)
+			return fmt.Errorf("failed to set proxy_arp on newly added interface %q: %v", name, err)
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -0,0 +1,83 @@
+// Package mtu computes the MTU of the interfaces of the node from the MTU of
+// the farm network.
+//
+// When the switches of the farm support jumbo frames, the farmer sets the MTU
+// of the farm network with the farm-mtu kernel parameter. The bridges, the
+// veths and the taps of the workloads use it, the wireguard interfaces use it
+// minus the wireguard encapsulation. The internet is still reached with the
+// default MTU, so the TCP MSS of the connections leaving the node toward the
+// internet is clamped.
+package mtu
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+const (
+	// Default is the MTU of a network without jumbo frames, and of the
+	// internet
+	Default = 1500
+	// Max is the largest MTU of the farm network
+	Max = 9000
+	// WireguardOverhead is the size of the headers added by wireguard over
+	// IPv6: 40 for IPv6, 8 for UDP and 32 for wireguard
+	WireguardOverhead = 80
+
+	// MSS4 is the TCP MSS of the IPv4 connections to the internet
+	MSS4 = Default - 40
+	// MSS6 is the TCP MSS of the IPv6 connections to the internet
+	MSS6 = Default - 60
+
+	param = "farm-mtu"
+)
+
+// FromParams reads the MTU of the farm network from the kernel parameters.
+// farm-mtu=<mtu> is set when the farm network supports jumbo frames, Default
+// is returned if it's not set
+func FromParams(params kernel.Params) (int, error) {
+	values, ok := params.Get(param)
+	if !ok || len(values) == 0 {
+		return Default, nil
+	}
+
+	mtu, err := strconv.Atoi(values[0])
+	if err != nil || mtu < Default || mtu > Max {
+		return Default, fmt.Errorf("invalid %s '%s', must be between %d and %d", param, values[0], Default, Max)
+	}
+
+	return mtu, nil
+}
+
+var (
+	farm     int
+	farmOnce sync.Once
+)
+
+// Farm returns the MTU of the farm network, it's read once from the kernel
+// parameters. An invalid MTU is logged and the farm network uses Default
+func Farm() int {
+	farmOnce.Do(func() {
+		var err error
+		if farm, err = FromParams(kernel.GetParams()); err != nil {
+			log.Error().Err(err).Msg("invalid farm network mtu, jumbo frames are disabled")
+		}
+	})
+
+	return farm
+}
+
+// Jumbo returns true if the farm network uses jumbo frames
+func Jumbo() bool {
+	return Farm() > Default
+}
+
+// Wireguard returns the MTU of the wireguard interfaces, the encapsulated
+// packets fit in the MTU of the farm network
+func Wireguard() int {
+	return Farm() - WireguardOverhead
+}
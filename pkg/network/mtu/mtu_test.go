@@ -0,0 +1,25 @@
+package mtu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestFromParams(t *testing.T) {
+	mtu, err := FromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, Default, mtu)
+
+	mtu, err = FromParams(kernel.Params{"farm-mtu": {"9000"}})
+	require.NoError(t, err)
+	assert.Equal(t, 9000, mtu)
+
+	for _, value := range []string{"jumbo", "1280", "9216"} {
+		mtu, err := FromParams(kernel.Params{"farm-mtu": {value}})
+		assert.Error(t, err, value)
+		assert.Equal(t, Default, mtu)
+	}
+}
@@ -0,0 +1,160 @@
+// Package names keeps track of the interface and namespace names the network
+// module allocates on the node, so two network resources can never end up
+// sharing the same name.
+package names
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCollision is returned when a name is claimed by an owner
+// while it is already owned by another one
+type ErrCollision struct {
+	Name  string
+	Owner string
+	Claim string
+}
+
+func (e ErrCollision) Error() string {
+	return fmt.Sprintf("name '%s' requested by '%s' is already used by '%s'", e.Name, e.Claim, e.Owner)
+}
+
+// IsCollision checks if err is an ErrCollision
+func IsCollision(err error) bool {
+	_, ok := errors.Cause(err).(ErrCollision)
+	return ok
+}
+
+// Registry is a persisted registry of names. Each name is stored
+// as a file in the registry directory that contains its owner
+type Registry struct {
+	sync.RWMutex
+	root string
+}
+
+// NewRegistry creates a new registry
+// the directory pointed by path must exists already
+func NewRegistry(path string) *Registry {
+	return &Registry{
+		root: path,
+	}
+}
+
+func (r *Registry) path(name string) string {
+	return filepath.Join(r.root, name)
+}
+
+func (r *Registry) owner(name string) (string, error) {
+	data, err := ioutil.ReadFile(r.path(name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Claim reserves all names for owner. Claiming a name already owned by
+// the same owner is a no-op. If any of the names is owned by someone else
+// an ErrCollision is returned and none of the names is reserved
+func (r *Registry) Claim(owner string, names ...string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	var created []string
+	rollback := func() {
+		for _, name := range created {
+			_ = os.Remove(r.path(name))
+		}
+	}
+
+	for _, name := range names {
+		f, err := os.OpenFile(r.path(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if os.IsExist(err) {
+			current, err := r.owner(name)
+			if err != nil {
+				rollback()
+				return errors.Wrapf(err, "failed to read owner of name '%s'", name)
+			}
+
+			if current != owner {
+				rollback()
+				return ErrCollision{Name: name, Owner: current, Claim: owner}
+			}
+
+			continue
+		} else if err != nil {
+			rollback()
+			return err
+		}
+
+		created = append(created, name)
+		_, err = f.WriteString(owner)
+		f.Close()
+		if err != nil {
+			rollback()
+			return errors.Wrapf(err, "failed to write owner of name '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// Release removes all the names owned by owner
+func (r *Registry) Release(owner string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	all, err := r.list()
+	if err != nil {
+		return err
+	}
+
+	for name, o := range all {
+		if o != owner {
+			continue
+		}
+
+		if err := os.Remove(r.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns all the names in the registry mapped to their owner
+func (r *Registry) List() (map[string]string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.list()
+}
+
+func (r *Registry) list() (map[string]string, error) {
+	infos, err := ioutil.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]string, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+
+		owner, err := r.owner(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		all[info.Name()] = owner
+	}
+
+	return all, nil
+}
@@ -0,0 +1,45 @@
+package names
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry(dir)
+
+	err = r.Claim("net1", "n-net1", "b-net1")
+	require.NoError(t, err)
+
+	// claiming again is a no-op
+	err = r.Claim("net1", "n-net1", "b-net1")
+	require.NoError(t, err)
+
+	err = r.Claim("net2", "n-net2", "b-net1")
+	require.Error(t, err)
+	assert.True(t, IsCollision(err))
+	assert.Equal(t, ErrCollision{Name: "b-net1", Owner: "net1", Claim: "net2"}, err)
+
+	all, err := r.List()
+	require.NoError(t, err)
+	// the names of the failed claim are rolled back
+	assert.Equal(t, map[string]string{"n-net1": "net1", "b-net1": "net1"}, all)
+
+	err = r.Release("net1")
+	require.NoError(t, err)
+
+	err = r.Claim("net2", "n-net2", "b-net1")
+	require.NoError(t, err)
+
+	all, err = r.List()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"n-net2": "net2", "b-net1": "net2"}, all)
+}
@@ -2,6 +2,7 @@ package namespace
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -149,6 +150,34 @@ func Exists(name string) bool {
 	return err == nil
 }
 
+// List returns the names of the persistent network namespaces
+func List() ([]string, error) {
+	infos, err := ioutil.ReadDir(netNSPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+
+	return names, nil
+}
+
+// Name returns the name of the persistent namespace netNS, as opened by
+// Create or GetByName
+func Name(netNS ns.NetNS) (string, error) {
+	path := netNS.Path()
+	if filepath.Dir(path) != netNSPath {
+		return "", fmt.Errorf("namespace %s is not persistent", path)
+	}
+
+	return filepath.Base(path), nil
+}
+
 // GetByName return a namespace by its name
 func GetByName(name string) (ns.NetNS, error) {
 	nsPath := filepath.Join(netNSPath, name)
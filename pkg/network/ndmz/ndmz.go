@@ -24,10 +24,10 @@ import (
 	"github.com/vishvananda/netlink"
 
 	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/zos/pkg/network/namespace"
 	"github.com/threefoldtech/zos/pkg/network/nft"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 )
 
 const (
@@ -48,6 +48,15 @@ const (
 	nrPubIface = "public"
 )
 
+// NRSubnets returns the subnets of the public interfaces of the network
+// resources, the traffic of the workloads enters the ndmz from them
+func NRSubnets() []net.IPNet {
+	return []net.IPNet{
+		{IP: net.ParseIP("100.127.0.0").To4(), Mask: net.CIDRMask(16, 32)},
+		{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)},
+	}
+}
+
 //Create create the NDMZ network namespace and configure its default routes and addresses
 func Create(nodeID pkg.Identifier) error {
 	netNS, err := namespace.GetByName(NetNSNDMZ)
@@ -81,20 +90,24 @@ func Create(nodeID pkg.Identifier) error {
 			return errors.Wrapf(err, "ndmz: couldn't bring lo up in ndmz namespace")
 		}
 		// first, disable forwarding, so we can get an IPv6 deft route on public from an RA
-		if _, err := sysctl.Sysctl("net.ipv6.conf.all.forwarding", "0"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, "net.ipv6.conf.all.forwarding", "0"); err != nil {
 			return errors.Wrapf(err, "ndmz: failed to disable ipv6 forwarding in ndmz namespace")
 		}
+		// the address of public comes from SLAAC, it must be stable
+		if err := ifaceutil.SetIPv6Policy(NetNSNDMZ, DMZPub6, ifaceutil.DefaultIPv6Policy); err != nil {
+			return errors.Wrapf(err, "ndmz: failed to set IPv6 policy of %s", DMZPub6)
+		}
 		// also, set kernel parameter that public always accepts an ra even when forwarding
-		if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.accept_ra", DMZPub6), "2"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, fmt.Sprintf("net.ipv6.conf.%s.accept_ra", DMZPub6), "2"); err != nil {
 			return errors.Wrapf(err, "ndmz: failed to accept_ra=2 in ndmz namespace")
 		}
 		// the more, also accept defaultrouter (if isp doesn't have fe80::1 on his deft gw)
-		if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.accept_ra_defrtr", DMZPub6), "1"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, fmt.Sprintf("net.ipv6.conf.%s.accept_ra_defrtr", DMZPub6), "1"); err != nil {
 			return errors.Wrapf(err, "ndmz: failed to enable enable_defrtr=1 in ndmz namespace")
 		}
 		// ipv4InterfaceArpProxySysctlTemple sets proxy_arp by default, not sure if that's a good idea
 		// but we disable only here because the rest works.
-		if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv4.conf.%s.proxy_arp", DMZPub6), "0"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, fmt.Sprintf("net.ipv4.conf.%s.proxy_arp", DMZPub6), "0"); err != nil {
 			return errors.Wrapf(err, "ndmz: couldn't disable proxy-arp on %s in ndmz namespace", DMZPub6)
 		}
 		// run DHCP to interface public in ndmz
@@ -148,7 +161,7 @@ func Create(nodeID pkg.Identifier) error {
 		}
 
 		if len(routes) == 1 {
-			if _, err := sysctl.Sysctl("net.ipv6.conf.all.forwarding", "1"); err != nil {
+			if err := sysctl.Set(NetNSNDMZ, "net.ipv6.conf.all.forwarding", "1"); err != nil {
 				return errors.Wrapf(err, "ndmz: failed to enable ipv6 forwarding in ndmz namespace")
 			}
 		}
@@ -242,7 +255,7 @@ func createPubIface4(name string, netNS ns.NetNS, nodeID pkg.Identifier) error {
 	}
 
 	return netNS.Do(func(_ ns.NetNS) error {
-		if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
 			return errors.Wrapf(err, "failed to disable ip6 on %s", name)
 		}
 		// set mac address to something static to make sure we receive the same IP from a DHCP server
@@ -271,7 +284,7 @@ func createRoutingBridge(name string, netNS ns.NetNS) error {
 		}
 	}
 
-	if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
+	if err := sysctl.Set(sysctl.Host, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
 		return errors.Wrapf(err, "failed to disable ip6 on bridge %s", name)
 	}
 
@@ -281,7 +294,7 @@ func createRoutingBridge(name string, netNS ns.NetNS) error {
 		if err != nil {
 			return err
 		}
-		if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", tonrsIface), "0"); err != nil {
+		if err := sysctl.Set(NetNSNDMZ, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", tonrsIface), "0"); err != nil {
 			return errors.Wrapf(err, "failed to enable ip6 on interface %s", tonrsIface)
 		}
 
@@ -320,7 +333,7 @@ func createRoutingBridge(name string, netNS ns.NetNS) error {
 func applyFirewall() error {
 	buf := bytes.Buffer{}
 
-	if err := fwTmpl.Execute(&buf, nil); err != nil {
+	if err := fwTmpl.Execute(&buf, firewallData()); err != nil {
 		return errors.Wrap(err, "failed to build nft rule set")
 	}
 
@@ -1,6 +1,7 @@
 package ndmz
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -94,3 +95,19 @@ func TestIPv4AllocateConcurent(t *testing.T) {
 	wg.Wait()
 	close(c)
 }
+
+func TestFirewallClampMSS(t *testing.T) {
+	render := func(data fwData) string {
+		var buf bytes.Buffer
+		require.NoError(t, fwTmpl.Execute(&buf, data))
+		return buf.String()
+	}
+
+	data := fwData{MSS4: 1460, MSS6: 1440}
+	assert.NotContains(t, render(data), "maxseg")
+
+	data.ClampMSS = true
+	rules := render(data)
+	assert.Contains(t, rules, `oifname "npub4" tcp flags & (syn | rst) == syn tcp option maxseg size > 1460 tcp option maxseg size set 1460`)
+	assert.Contains(t, rules, `oifname "npub6" tcp flags & (syn | rst) == syn tcp option maxseg size > 1440 tcp option maxseg size set 1440`)
+}
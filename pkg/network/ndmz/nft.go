@@ -2,10 +2,30 @@ package ndmz
 
 import (
 	"text/template"
+
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 )
 
 var fwTmpl *template.Template
 
+// fwData is the data of the firewall of the ndmz
+type fwData struct {
+	// ClampMSS is true if the TCP MSS of the connections to the internet
+	// must be clamped: the workloads use the jumbo frames of the farm
+	// network, the internet doesn't
+	ClampMSS bool
+	MSS4     int
+	MSS6     int
+}
+
+func firewallData() fwData {
+	return fwData{
+		ClampMSS: mtu.Jumbo(),
+		MSS4:     mtu.MSS4,
+		MSS6:     mtu.MSS6,
+	}
+}
+
 func init() {
 	fwTmpl = template.Must(template.New("").Parse(_nft))
 }
@@ -52,6 +72,11 @@ table inet filter {
 
   chain forward {
     type filter hook forward priority 0; policy accept;
+    {{- if .ClampMSS }}
+    # the farm network uses jumbo frames, the internet doesn't
+    oifname "npub4" tcp flags & (syn | rst) == syn tcp option maxseg size > {{ .MSS4 }} tcp option maxseg size set {{ .MSS4 }}
+    oifname "npub6" tcp flags & (syn | rst) == syn tcp option maxseg size > {{ .MSS6 }} tcp option maxseg size set {{ .MSS6 }}
+    {{- end }}
     # is there already an existing stream? (outgoing)
     jump base_checks
     # if not, verify if it's new and coming in from the br4-gw network
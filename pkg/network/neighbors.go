@@ -0,0 +1,236 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/lldp"
+	"github.com/vishvananda/netlink"
+)
+
+// neighborsInterval is the time between two scans of the physical
+// interfaces, and two checks of the expiration of the neighbors
+const neighborsInterval = 30 * time.Second
+
+// neighborTable keeps the LLDP neighbors of the physical interfaces
+type neighborTable struct {
+	mu        sync.Mutex
+	neighbors map[string]pkg.LLDPNeighbor
+	// changed is closed, and replaced, when the neighbors change
+	changed chan struct{}
+}
+
+func newNeighborTable() *neighborTable {
+	return &neighborTable{
+		neighbors: make(map[string]pkg.LLDPNeighbor),
+		changed:   make(chan struct{}),
+	}
+}
+
+// notify must be called with the lock held
+func (t *neighborTable) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// set records the neighbor of iface, a neighbor with a zero TTL is removed
+func (t *neighborTable) set(iface string, neighbor *lldp.Neighbor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if neighbor.TTL == 0 {
+		t.remove(iface)
+		return
+	}
+
+	entry := pkg.LLDPNeighbor{
+		Interface:         iface,
+		MAC:               neighbor.MAC.String(),
+		ChassisID:         neighbor.ChassisID,
+		PortID:            neighbor.PortID,
+		PortDescription:   neighbor.PortDescription,
+		SystemName:        neighbor.SystemName,
+		SystemDescription: neighbor.SystemDescription,
+		Expires:           time.Now().Add(neighbor.TTL),
+	}
+
+	previous, ok := t.neighbors[iface]
+	t.neighbors[iface] = entry
+
+	previous.Expires = entry.Expires
+	if ok && previous == entry {
+		// refreshed
+		return
+	}
+
+	log.Info().
+		Str("interface", iface).
+		Str("switch", entry.SystemName).
+		Str("chassis", entry.ChassisID).
+		Str("port", entry.PortID).
+		Msg("lldp neighbor changed")
+	t.notify()
+}
+
+// remove must be called with the lock held
+func (t *neighborTable) remove(iface string) {
+	if _, ok := t.neighbors[iface]; !ok {
+		return
+	}
+
+	log.Info().Str("interface", iface).Msg("lldp neighbor removed")
+	delete(t.neighbors, iface)
+	t.notify()
+}
+
+// expire removes the neighbors that were not advertised again in time
+func (t *neighborTable) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for iface, neighbor := range t.neighbors {
+		if now.After(neighbor.Expires) {
+			t.remove(iface)
+		}
+	}
+}
+
+// list returns the neighbors sorted by interface and a channel closed when
+// they change
+func (t *neighborTable) list() ([]pkg.LLDPNeighbor, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	neighbors := make([]pkg.LLDPNeighbor, 0, len(t.neighbors))
+	for _, neighbor := range t.neighbors {
+		neighbors = append(neighbors, neighbor)
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].Interface < neighbors[j].Interface
+	})
+
+	return neighbors, t.changed
+}
+
+// physicalLinks returns the names of the interfaces backed by a device
+func physicalLinks() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "device" || link.Attrs().Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/device", name)); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// WatchNeighbors listens to the LLDP advertisements received on the
+// physical interfaces of the node until ctx is canceled. The interfaces are
+// scanned periodically, so the NICs brought up later are listened to as well
+func WatchNeighbors(ctx context.Context, nw pkg.Networker) {
+	n, ok := nw.(*networker)
+	if !ok {
+		log.Error().Msg("lldp neighbors not supported by this networker")
+		return
+	}
+
+	var mu sync.Mutex
+	listening := make(map[string]bool)
+
+	listen := func(iface string) {
+		neighbors, err := lldp.Listen(ctx, iface)
+		if err != nil {
+			log.Error().Err(err).Str("interface", iface).Msg("failed to listen to lldp advertisements")
+			mu.Lock()
+			delete(listening, iface)
+			mu.Unlock()
+			return
+		}
+
+		for neighbor := range neighbors {
+			n.neighbors.set(iface, &neighbor)
+		}
+
+		// the interface is gone
+		mu.Lock()
+		delete(listening, iface)
+		mu.Unlock()
+	}
+
+	ticker := time.NewTicker(neighborsInterval)
+	defer ticker.Stop()
+
+	for {
+		links, err := physicalLinks()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list physical interfaces")
+		}
+
+		mu.Lock()
+		for _, iface := range links {
+			if listening[iface] {
+				continue
+			}
+			listening[iface] = true
+			go listen(iface)
+		}
+		mu.Unlock()
+
+		n.neighbors.expire()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LLDPNeighbors implements pkg.Networker interface
+func (n *networker) LLDPNeighbors() ([]pkg.LLDPNeighbor, error) {
+	neighbors, _ := n.neighbors.list()
+	return neighbors, nil
+}
+
+// LLDPMonitor implements pkg.Networker interface
+func (n *networker) LLDPMonitor(ctx context.Context) <-chan []pkg.LLDPNeighbor {
+	ch := make(chan []pkg.LLDPNeighbor)
+	go func() {
+		defer close(ch)
+		for {
+			neighbors, changed := n.neighbors.list()
+			select {
+			case ch <- neighbors:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/lldp"
+)
+
+func TestNeighborTable(t *testing.T) {
+	table := newNeighborTable()
+
+	neighbor := lldp.Neighbor{
+		MAC:        net.HardwareAddr{0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcc},
+		ChassisID:  "00:1b:21:aa:bb:cc",
+		PortID:     "Eth1/1",
+		SystemName: "tor1",
+		TTL:        2 * time.Minute,
+	}
+
+	neighbors, changed := table.list()
+	assert.Empty(t, neighbors)
+
+	table.set("eth1", &neighbor)
+	table.set("eth0", &neighbor)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("new neighbors were not notified")
+	}
+
+	neighbors, changed = table.list()
+	require.Len(t, neighbors, 2)
+	assert.Equal(t, "eth0", neighbors[0].Interface)
+	assert.Equal(t, "tor1", neighbors[0].SystemName)
+	assert.Equal(t, "00:1b:21:aa:bb:cc", neighbors[0].MAC)
+
+	// a refreshed neighbor is not a change
+	table.set("eth0", &neighbor)
+	select {
+	case <-changed:
+		t.Fatal("refreshed neighbor was notified")
+	default:
+	}
+
+	// the switch port shuts down
+	neighbor.TTL = 0
+	table.set("eth1", &neighbor)
+	<-changed
+
+	neighbors, _ = table.list()
+	require.Len(t, neighbors, 1)
+	assert.Equal(t, "eth0", neighbors[0].Interface)
+
+	table.neighbors["eth0"] = pkg.LLDPNeighbor{Interface: "eth0", Expires: time.Now().Add(-time.Second)}
+	table.expire()
+	neighbors, _ = table.list()
+	assert.Empty(t, neighbors)
+}
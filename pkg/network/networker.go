@@ -9,12 +9,16 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/termie/go-shutil"
 
 	"github.com/threefoldtech/tfexplorer/client"
+	"github.com/threefoldtech/zos/pkg/audit"
 	"github.com/threefoldtech/zos/pkg/cache"
+	"github.com/threefoldtech/zos/pkg/dedup"
+	"github.com/threefoldtech/zos/pkg/geoip"
 	"github.com/threefoldtech/zos/pkg/network/ndmz"
 	"github.com/threefoldtech/zos/pkg/network/tuntap"
 
@@ -23,16 +27,26 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/threefoldtech/zos/pkg/network/antispoof"
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
 
 	"github.com/threefoldtech/zos/pkg/network/macvlan"
 	"github.com/threefoldtech/zos/pkg/network/nr"
+	"github.com/threefoldtech/zos/pkg/network/offload"
+	"github.com/threefoldtech/zos/pkg/network/oplog"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/ratelimit"
+	"github.com/threefoldtech/zos/pkg/serial"
 	"github.com/threefoldtech/zos/pkg/set"
+	"github.com/threefoldtech/zos/pkg/tracing"
+	"github.com/threefoldtech/zos/pkg/utils"
 	"github.com/threefoldtech/zos/pkg/versioned"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/threefoldtech/zos/pkg/network/names"
 	"github.com/threefoldtech/zos/pkg/network/namespace"
 
 	"github.com/threefoldtech/zos/pkg"
@@ -44,23 +58,49 @@ const (
 	wgPortDir    = "wireguard_ports"
 	networkDir   = "networks"
 	ipamLeaseDir = "ndmz-lease"
+	namesDir     = "names"
+	oplogDir     = "oplog"
+	sysctlsFile  = "sysctls.json"
 	ipamPath     = "/var/cache/modules/networkd/lease"
 )
 
 const (
 	mib = 1024 * 1024
+
+	// floodCountersInterval is how often the public
+	// namespace protection counters are reported
+	floodCountersInterval = 10 * time.Second
 )
 
 type networker struct {
 	identity     pkg.IdentityManager
+	nodeID       string
 	networkDir   string
 	ipamLeaseDir string
 	tnodb        client.Directory
 	portSet      *set.UintSet
+	names        *names.Registry
+	inflight     *utils.InFlight
+	limiter      *ratelimit.Limiter
+	diagnostics  *ratelimit.Limiter
+	requests     *dedup.Cache
+	applies      *serial.Queue
+	oplog        *oplog.Log
+	audit        *audit.Logger
+	routeTable   int
+	offloads     offload.Config
+	neighbors    *neighborTable
+	links        *linkWatcher
+	// asn is the offline ASN database, nil if not available
+	asn *geoip.DB
 }
 
 // NewNetworker create a new pkg.Networker that can be used over zbus
-func NewNetworker(identity pkg.IdentityManager, tnodb client.Directory, storageDir string) (pkg.Networker, error) {
+// inflight is used to track the mutating operations so networkd can drain
+// them before exiting. routeTable is the routing table of the overlay routes
+// of the network resources, 0 to use the main table. offloads is the offload
+// policy of the wireguard interfaces and of the veths of the workloads
+func NewNetworker(identity pkg.IdentityManager, tnodb client.Directory, storageDir string, inflight *utils.InFlight, routeTable int, offloads offload.Config) (pkg.Networker, error) {
 
 	vd, err := cache.VolatileDir("networkd", 50*mib)
 	if err != nil && !os.IsExist(err) {
@@ -72,6 +112,11 @@ func NewNetworker(identity pkg.IdentityManager, tnodb client.Directory, storageD
 		return nil, fmt.Errorf("failed to create wireguard port cache directory: %w", err)
 	}
 
+	namesPath := filepath.Join(vd, namesDir)
+	if err := os.MkdirAll(namesPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create names registry directory: %w", err)
+	}
+
 	nwDir := filepath.Join(vd, networkDir)
 	ipamLease := filepath.Join(vd, ipamLeaseDir)
 
@@ -91,13 +136,60 @@ func NewNetworker(identity pkg.IdentityManager, tnodb client.Directory, storageD
 		}
 	}
 
+	// the defaults of the sysctls set before a restart of networkd must
+	// not be lost, they are the values restored on teardown
+	if err := sysctl.Persist(filepath.Join(vd, sysctlsFile)); err != nil {
+		log.Error().Err(err).Msg("failed to load sysctl records")
+	}
+
+	opLog, err := oplog.Open(filepath.Join(vd, oplogDir))
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog, err := audit.New(audit.DefaultRoot, "network", identity)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open audit log, operations won't be audited")
+	}
+
+	// the node id is read once, not on each request
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get node identity")
+	}
+
 	nw := &networker{
 		identity:     identity,
+		nodeID:       nodeID.Identity(),
 		tnodb:        tnodb,
 		networkDir:   nwDir,
 		ipamLeaseDir: ipamLease,
 		portSet:      set.NewUint(wgDir),
-	}
+		names:        names.NewRegistry(namesPath),
+		inflight:     inflight,
+		// a network resource is rarely updated, this is plenty for
+		// the node and protect us from being hammered. The caller handed
+		// over is not authenticated, so all the calls share the bucket
+		// of their method
+		limiter: ratelimit.New(1, 20),
+		// the diagnostics enter the namespaces and dump the kernel tables
+		diagnostics: ratelimit.New(1, 20),
+		requests:    dedup.New(10 * time.Minute),
+		applies:     serial.New(),
+		oplog:       opLog,
+		audit:       auditLog,
+		routeTable:  routeTable,
+		offloads:    offloads,
+		neighbors:   newNeighborTable(),
+		links:       newLinkWatcher(),
+	}
+
+	// the ASN database is optional, it only enriches the peers diagnostics
+	if nw.asn, err = geoip.OpenDB(geoip.DefaultDBPath); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Msg("failed to load ASN database")
+	}
+
+	nw.replay()
 
 	return nw, nil
 }
@@ -192,12 +284,45 @@ func validateNR(nr pkg.NetResource) error {
 		}
 	}
 
+	switch nr.Withdrawn {
+	case "", pkg.WithdrawBlackhole, pkg.WithdrawUnreachable, pkg.WithdrawNone:
+	default:
+		return fmt.Errorf("unsupported withdrawn action '%s'", nr.Withdrawn)
+	}
+
+	switch nr.MSSClamping {
+	case "", pkg.MSSClampingAuto, pkg.MSSClampingOff:
+	default:
+		return fmt.Errorf("unsupported mss clamping '%s'", nr.MSSClamping)
+	}
+
+	if nr.Egress != nil {
+		if err := validateEgress(nr.Egress); err != nil {
+			return err
+		}
+	}
+
+	if nr.EgressPolicy != nil {
+		if err := validateEgressPolicy(nr.EgressPolicy); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func validatePeer(p pkg.Peer) error {
-	if p.WGPublicKey == "" {
-		return fmt.Errorf("peer wireguard public key cannot empty")
+	switch p.ConnType {
+	case "", pkg.ConnTypeWireguard:
+		if p.WGPublicKey == "" {
+			return fmt.Errorf("peer wireguard public key cannot empty")
+		}
+	case pkg.ConnTypeIPSec:
+		if err := validateIPSec(p); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown peer connection type '%s'", p.ConnType)
 	}
 
 	if p.Subnet.Nil() {
@@ -207,6 +332,83 @@ func validatePeer(p pkg.Peer) error {
 	if len(p.AllowedIPs) <= 0 {
 		return fmt.Errorf("peer wireguard allowedIPs cannot empty")
 	}
+
+	for _, endpoint := range p.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("peer endpoint '%s' is invalid: %w", endpoint, err)
+		}
+	}
+
+	if p.WGPresharedKey != "" {
+		if _, err := hex.DecodeString(p.WGPresharedKey); err != nil {
+			return fmt.Errorf("peer wireguard preshared key must be hex encoded")
+		}
+	}
+	return nil
+}
+
+func validateEgress(e *pkg.EgressProxy) error {
+	switch e.Protocol {
+	case pkg.ProxySocks5, pkg.ProxyHTTP:
+	default:
+		return fmt.Errorf("unknown egress proxy protocol '%s'", e.Protocol)
+	}
+
+	if e.IP() == nil {
+		return fmt.Errorf("egress proxy address must be in the ip:port format")
+	}
+
+	return nil
+}
+
+func validateEgressPolicy(p *pkg.EgressPolicy) error {
+	if p.Mode != pkg.EgressAllow && p.Mode != pkg.EgressDeny {
+		return fmt.Errorf("unknown egress policy mode '%s'", p.Mode)
+	}
+
+	for _, rule := range p.Rules {
+		if rule.Dst.Nil() {
+			return fmt.Errorf("egress rule destination cannot be empty")
+		}
+
+		switch rule.Protocol {
+		case "tcp", "udp":
+		case "":
+			if len(rule.Ports) > 0 {
+				return fmt.Errorf("egress rule with ports needs a protocol")
+			}
+		default:
+			return fmt.Errorf("unknown egress rule protocol '%s'", rule.Protocol)
+		}
+	}
+
+	return nil
+}
+
+func validateIPSec(p pkg.Peer) error {
+	if p.IPSec == nil {
+		return fmt.Errorf("ipsec peer needs an ipsec configuration")
+	}
+
+	if p.Endpoint == "" {
+		return fmt.Errorf("ipsec peer needs an endpoint")
+	}
+
+	// SPIs 1 to 255 are reserved
+	if p.IPSec.SPIIn < 256 || p.IPSec.SPIOut < 256 {
+		return fmt.Errorf("ipsec peer SPIs must be greater than 255")
+	}
+
+	if p.IPSec.SPIIn == p.IPSec.SPIOut {
+		return fmt.Errorf("ipsec peer inbound and outbound SPIs must be different")
+	}
+
+	for _, key := range []string{p.IPSec.KeyIn, p.IPSec.KeyOut} {
+		if _, err := hex.DecodeString(key); err != nil || key == "" {
+			return fmt.Errorf("ipsec peer keys must be hex encoded")
+		}
+	}
+
 	return nil
 }
 
@@ -222,6 +424,19 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, addrs []strin
 	// 4- Assign IP to the veth endpoint inside the namespace.
 	// 5- return the namespace name
 
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return join, err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("Join", containerID)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("Join", audit.Caller(ctx), containerID, []interface{}{networkdID, addrs, publicIP6}, err)
+	}()
+
 	log.Info().Str("network-id", string(networkdID)).Msg("joining network")
 
 	network, err := n.networkOf(string(networkdID))
@@ -229,7 +444,7 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, addrs []strin
 		return join, errors.Wrapf(err, "couldn't load network with id (%s)", networkdID)
 	}
 
-	nodeID := n.identity.NodeID().Identity()
+	nodeID := n.nodeID
 	localNR, err := ResourceByNodeID(nodeID, network.NetResources)
 	if err != nil {
 		return join, err
@@ -239,6 +454,11 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, addrs []strin
 	if err != nil {
 		return join, errors.Wrap(err, "failed to load network resource")
 	}
+	netRes.SetOffloads(n.offloads)
+
+	if publicIP6 && localNR.Egress != nil {
+		return join, fmt.Errorf("network %s is egress restricted, public IPv6 is not allowed", networkdID)
+	}
 
 	ips := make([]net.IP, len(addrs))
 	for i, addr := range addrs {
@@ -249,6 +469,9 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, addrs []strin
 	if err != nil {
 		return join, errors.Wrap(err, "failed to load network resource")
 	}
+	if localNR.Egress != nil {
+		join.Env = localNR.Egress.Env(network.IPRange.String())
+	}
 
 	if publicIP6 {
 		netNs, err := namespace.GetByName(join.Namespace)
@@ -267,7 +490,20 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, addrs []strin
 	return join, nil
 }
 
-func (n *networker) Leave(networkdID pkg.NetID, containerID string) error {
+func (n *networker) Leave(networkdID pkg.NetID, containerID string) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("Leave", containerID)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("Leave", audit.Caller(ctx), containerID, networkdID, err)
+	}()
+
 	log.Info().Str("network-id", string(networkdID)).Msg("leaving network")
 
 	network, err := n.networkOf(string(networkdID))
@@ -275,7 +511,7 @@ func (n *networker) Leave(networkdID pkg.NetID, containerID string) error {
 		return errors.Wrapf(err, "couldn't load network with id (%s)", networkdID)
 	}
 
-	nodeID := n.identity.NodeID().Identity()
+	nodeID := n.nodeID
 	localNR, err := ResourceByNodeID(nodeID, network.NetResources)
 	if err != nil {
 		return err
@@ -292,6 +528,12 @@ func (n *networker) Leave(networkdID pkg.NetID, containerID string) error {
 // ZDBPrepare sends a macvlan interface into the
 // network namespace of a ZDB container
 func (n networker) ZDBPrepare(hw net.HardwareAddr) (string, error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
 	netNSName, err := ifaceutil.RandomName("zdb-ns-")
 	if err != nil {
 		return "", err
@@ -330,6 +572,15 @@ func createMacVlan(iface string, hw net.HardwareAddr, netNs ns.NetNS) error {
 		return errors.Wrap(err, "failed to create public mac vlan interface")
 	}
 
+	// the public address of the workload comes from SLAAC, it must be stable
+	nsName, err := namespace.Name(netNs)
+	if err != nil {
+		return err
+	}
+	if err := ifaceutil.SetIPv6Policy(nsName, macVlan.Name, ifaceutil.DefaultIPv6Policy); err != nil {
+		return err
+	}
+
 	log.Debug().Str("HW", hw.String()).Str("macvlan", macVlan.Name).Msg("setting hw address on link")
 	// we don't set any route or ip
 	if err := macvlan.Install(macVlan, hw, []*net.IPNet{}, []*netlink.Route{}, netNs); err != nil {
@@ -340,8 +591,15 @@ func createMacVlan(iface string, hw net.HardwareAddr, netNs ns.NetNS) error {
 }
 
 // SetupTap interface in the network resource. We only allow 1 tap interface to be
-// set up per NR currently
-func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
+// set up per NR currently. The tap is pinned to the MAC and the address of the
+// VM like the ports of the containers
+func (n *networker) SetupTap(networkID pkg.NetID, mac net.HardwareAddr, ip net.IP) (string, error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
 	log.Info().Str("network-id", string(networkID)).Msg("Setting up tap interface")
 
 	network, err := n.networkOf(string(networkID))
@@ -349,7 +607,7 @@ func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
 		return "", errors.Wrapf(err, "couldn't load network with id (%s)", networkID)
 	}
 
-	nodeID := n.identity.NodeID().Identity()
+	nodeID := n.nodeID
 	localNR, err := ResourceByNodeID(nodeID, network.NetResources)
 	if err != nil {
 		return "", err
@@ -370,13 +628,27 @@ func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
 		return "", errors.Wrap(err, "could not get network namespace tap device name")
 	}
 
-	_, err = tuntap.CreateTap(tapIface, bridgeName)
+	if _, err = tuntap.CreateTap(tapIface, bridgeName); err != nil {
+		return "", err
+	}
+
+	port := antispoof.Port{Name: tapIface, MAC: mac, IPs: []net.IP{ip}}
+	if err := antispoof.Guard(port); err != nil {
+		_ = ifaceutil.Delete(tapIface, nil)
+		return "", err
+	}
 
-	return tapIface, err
+	return tapIface, nil
 }
 
 // RemoveTap in the network resource.
 func (n *networker) RemoveTap(networkID pkg.NetID) error {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	log.Info().Str("network-id", string(networkID)).Msg("Removing tap interface")
 
 	tapIface, err := tapName(networkID)
@@ -384,6 +656,10 @@ func (n *networker) RemoveTap(networkID pkg.NetID) error {
 		return errors.Wrap(err, "could not get network namespace tap device name")
 	}
 
+	if err := antispoof.Release(tapIface); err != nil {
+		log.Error().Err(err).Str("tap", tapIface).Msg("failed to release tap port")
+	}
+
 	return ifaceutil.Delete(tapIface, nil)
 }
 
@@ -394,7 +670,7 @@ func (n networker) GetSubnet(networkID pkg.NetID) (net.IPNet, error) {
 		return net.IPNet{}, errors.Wrapf(err, "couldn't load network with id (%s)", networkID)
 	}
 
-	nodeID := n.identity.NodeID().Identity()
+	nodeID := n.nodeID
 	localNR, err := ResourceByNodeID(nodeID, network.NetResources)
 	if err != nil {
 		return net.IPNet{}, err
@@ -411,7 +687,7 @@ func (n networker) GetDefaultGwIP(networkID pkg.NetID) (net.IP, error) {
 		return nil, errors.Wrapf(err, "couldn't load network with id (%s)", networkID)
 	}
 
-	nodeID := n.identity.NodeID().Identity()
+	nodeID := n.nodeID
 	localNR, err := ResourceByNodeID(nodeID, network.NetResources)
 	if err != nil {
 		return nil, err
@@ -471,15 +747,227 @@ func (n networker) Addrs(iface string, netns string) ([]net.IP, error) {
 }
 
 // CreateNR implements pkg.Networker interface
+// A CreateNR call with exactly the same network object as a recent successful
+// call is not applied again, the original result is returned instead
 func (n *networker) CreateNR(network pkg.Network) (string, error) {
+	return n.CreateNRRequest("", network)
+}
+
+// CreateNRRequest implements pkg.Networker interface
+func (n *networker) CreateNRRequest(requestID string, network pkg.Network) (string, error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	apply := n.logged(network.NetID, "CreateNR", network, func() (interface{}, error) {
+		return n.applyNR(requestID, network)
+	})
+
+	nsName, err := n.applies.Do(string(network.NetID), "CreateNR", apply)
+	if err != nil {
+		return "", err
+	}
+
+	return nsName.(string), nil
+}
+
+// SubmitNR implements pkg.Networker interface
+func (n *networker) SubmitNR(network pkg.Network) (pkg.ApplyStatus, error) {
+	// an invalid network object is refused right away instead of
+	// being reported as a failed apply
+	if err := validateNetwork(&network); err != nil {
+		return pkg.ApplyStatus{}, err
+	}
+
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return pkg.ApplyStatus{}, err
+	}
+
+	apply := n.logged(network.NetID, "CreateNR", network, func() (interface{}, error) {
+		return n.applyNR("", network)
+	})
+
+	status := n.applies.Submit(string(network.NetID), "CreateNR", func() (interface{}, error) {
+		defer done()
+		return apply()
+	})
+
+	return applyStatus(network.NetID, status), nil
+}
+
+// ApplyStatus implements pkg.Networker interface
+func (n *networker) ApplyStatus(networkID pkg.NetID) (pkg.ApplyStatus, error) {
+	status, ok := n.applies.Status(string(networkID))
+	if !ok {
+		return pkg.ApplyStatus{}, fmt.Errorf("no operation on network %s", networkID)
+	}
+
+	return applyStatus(networkID, status), nil
+}
+
+func applyStatus(networkID pkg.NetID, status serial.Status) pkg.ApplyStatus {
+	result := pkg.ApplyStatus{
+		NetID:     networkID,
+		State:     pkg.ApplyState(status.State),
+		Operation: status.Operation,
+		Pending:   status.Pending,
+		Updated:   status.Updated,
+	}
+
+	if nsName, ok := status.Value.(string); ok {
+		result.Namespace = nsName
+	}
+	if status.Err != nil {
+		result.Error = status.Err.Error()
+	}
+
+	return result
+}
+
+// serialize applies the operation on networkID once the previous
+// operations on the same network are done
+func (n *networker) serialize(networkID pkg.NetID, operation string, args interface{}, fn func() error) error {
+	apply := n.logged(networkID, operation, args, func() (interface{}, error) {
+		return nil, fn()
+	})
+
+	_, err := n.applies.Do(string(networkID), operation, apply)
+	return err
+}
+
+// logged records the operation in the op-log, with the network stored
+// before it, for as long as fn runs. The operations interrupted by a crash
+// are replayed when networkd starts again
+func (n *networker) logged(networkID pkg.NetID, operation string, args interface{}, fn func() (interface{}, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		previous, err := n.networkOf(string(networkID))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to load network %s", networkID)
+		}
+
+		done, err := n.oplog.Begin(operation, networkID, args, previous)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+
+		return fn()
+	}
+}
+
+// applyNR creates the network resource, it must only be called through
+// the apply queue of the network. The request is identified by requestID,
+// or by the network object if it's empty
+func (n *networker) applyNR(requestID string, network pkg.Network) (string, error) {
+	key, err := dedup.Key(requestID, network)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to compute request key")
+	}
+
+	ctx, span := tracing.Serve("CreateNR", string(network.NetID))
+	nsName, replayed, err := n.requests.Do(string(network.NetID), key, func() (interface{}, error) {
+		// the replays of the op-log are not throttled
+		if err := n.limiter.Allow("CreateNR"); err != nil {
+			return "", err
+		}
+
+		return n.createNR(ctx, network)
+	})
+	span.Finish(err)
+
+	if !replayed {
+		n.audit.Record("CreateNR", audit.Caller(ctx), string(network.NetID), network, err)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if replayed {
+		log.Info().Str("network-id", string(network.NetID)).Msg("network resource already applied, replaying result")
+	}
+
+	return nsName.(string), nil
+}
+
+// throttle limits the calls to the diagnostics method about the object
+// identified by key, all the callers share the bucket of the method
+func (n *networker) throttle(method, key string) error {
+	_, span := tracing.Serve(method, key)
+	err := n.diagnostics.Allow(method)
+	span.Finish(err)
+
+	return err
+}
+
+// PlanNR implements pkg.Networker interface
+func (n *networker) PlanNR(network pkg.Network) (pkg.NetResourcePlan, error) {
+	var result pkg.NetResourcePlan
+	if err := validateNetwork(&network); err != nil {
+		return result, err
+	}
+
+	netNR, err := ResourceByNodeID(n.nodeID, network.NetResources)
+	if err != nil {
+		return result, err
+	}
+
+	netr, err := nr.New(network.NetID, netNR, &network.IPRange.IPNet)
+	if err != nil {
+		return result, err
+	}
+	netr.SetRouteTable(n.routeTable)
+	netr.SetOffloads(n.offloads)
+
+	// the deployed network resource, its removed peers get withdrawn
+	storedNet, err := n.networkOf(string(network.NetID))
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+
+	var storedNR *pkg.NetResource
+	if err == nil {
+		storedNR, err = ResourceByNodeID(n.nodeID, storedNet.NetResources)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result, err = netr.Plan(storedNR)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to plan network resource")
+	}
+
+	ifaces, err := interfaceNames(netr)
+	if err != nil {
+		return result, err
+	}
+
+	registered, err := n.names.List()
+	if err != nil {
+		return result, errors.Wrap(err, "failed to list names registry")
+	}
+
+	for _, name := range ifaceNamesList(ifaces) {
+		if owner, ok := registered[name]; ok && owner != string(network.NetID) {
+			result.Collisions = append(result.Collisions, name)
+		}
+	}
+
+	return result, nil
+}
+
+func (n *networker) createNR(ctx context.Context, network pkg.Network) (string, error) {
 	defer func() {
 		if err := n.publishWGPorts(); err != nil {
 			log.Warn().Err(err).Msg("failed to publish wireguard port to BCDB")
 		}
 	}()
 
-	var err error
-	var nodeID = n.identity.NodeID().Identity()
+	var nodeID = n.nodeID
 
 	if err := validateNetwork(&network); err != nil {
 		log.Error().Err(err).Msg("network object format invalid")
@@ -511,8 +999,9 @@ func (n *networker) CreateNR(network pkg.Network) (string, error) {
 		return "", err
 	}
 
+	var storedNR *pkg.NetResource
 	if err == nil {
-		storedNR, err := ResourceByNodeID(nodeID, storedNet.NetResources)
+		storedNR, err = ResourceByNodeID(nodeID, storedNet.NetResources)
 		if err != nil {
 			return "", err
 		}
@@ -529,6 +1018,23 @@ func (n *networker) CreateNR(network pkg.Network) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	netr.SetUnsealer(n.extractPrivateKey)
+	netr.SetRouteTable(n.routeTable)
+	netr.SetOffloads(n.offloads)
+
+	ifaces, err := interfaceNames(netr)
+	if err != nil {
+		return "", err
+	}
+
+	// refuse to apply a network resource that would reuse the interfaces
+	// of another network resource
+	if err := n.names.Claim(string(network.NetID), ifaceNamesList(ifaces)...); err != nil {
+		if err := n.releasePort(netNR.WGListenPort); err != nil {
+			log.Error().Err(err).Msg("release wireguard port failed")
+		}
+		return "", errors.Wrap(err, "network resource names collision")
+	}
 
 	cleanup := func() {
 		log.Error().Msg("clean up network resource")
@@ -538,47 +1044,77 @@ func (n *networker) CreateNR(network pkg.Network) (string, error) {
 		if err := n.releasePort(netNR.WGListenPort); err != nil {
 			log.Error().Err(err).Msg("release wireguard port failed")
 		}
+		if err := n.names.Release(string(network.NetID)); err != nil {
+			log.Error().Err(err).Msg("release network resource names failed")
+		}
 	}
 
 	// this is ok if pubNS is nil, nr.Create handles it
 	pubNS, _ := namespace.GetByName(types.PublicNamespace)
 
 	log.Info().Msg("create network resource namespace")
-	if err := netr.Create(pubNS); err != nil {
+	if err := tracing.Step(ctx, "create namespace", func() error { return netr.Create(pubNS) }); err != nil {
 		cleanup()
 		return "", errors.Wrap(err, "failed to create network resource")
 	}
 
-	if err := ndmz.AttachNR(string(network.NetID), netr, n.ipamLeaseDir); err != nil {
+	if err := tracing.Step(ctx, "attach ndmz", func() error {
+		return ndmz.AttachNR(string(network.NetID), netr, n.ipamLeaseDir)
+	}); err != nil {
 		return "", errors.Wrapf(err, "failed to attach network resource to DMZ bridge")
 	}
 
-	if err := netr.ConfigureWG(privateKey); err != nil {
+	if err := tracing.Step(ctx, "configure wireguard", func() error { return netr.ConfigureWG(privateKey) }); err != nil {
 		cleanup()
 		return "", errors.Wrap(err, "failed to configure network resource")
 	}
 
-	// map the network ID to the network namespace
+	if storedNR != nil {
+		// the peers removed by the update don't get the traffic
+		// of their subnets anymore
+		if err := netr.Withdraw(storedNR); err != nil {
+			log.Error().Err(err).Msg("failed to withdraw the routes of the removed peers")
+		}
+	}
+
+	// the workloads that joined before their ports were pinned
+	if err := netr.GuardMembers(); err != nil {
+		log.Error().Err(err).Msg("failed to pin the ports of the workloads")
+	}
+
+	if err := n.storeNetwork(&network); err != nil {
+		cleanup()
+		return "", err
+	}
+
+	return netr.Namespace()
+}
+
+// storeNetwork maps the network ID to the network object
+func (n *networker) storeNetwork(network *pkg.Network) error {
 	path := filepath.Join(n.networkDir, string(network.NetID))
 	file, err := os.Create(path)
 	if err != nil {
-		cleanup()
-		return "", err
+		return err
 	}
 	defer file.Close()
 	writer, err := versioned.NewWriter(file, pkg.NetworkSchemaLatestVersion)
 	if err != nil {
-		cleanup()
-		return "", err
+		return err
 	}
 
 	enc := json.NewEncoder(writer)
-	if err := enc.Encode(&network); err != nil {
-		cleanup()
-		return "", errors.Wrap(err, "failed to store network object")
+	if err := enc.Encode(network); err != nil {
+		return errors.Wrap(err, "failed to store network object")
 	}
 
-	return netr.Namespace()
+	// make sure the network object hits the disk before we report success
+	// so a shutdown right after this call doesn't lose it
+	if err := file.Sync(); err != nil {
+		return errors.Wrap(err, "failed to flush network object")
+	}
+
+	return nil
 }
 
 func (n *networker) networkOf(id string) (*pkg.Network, error) {
@@ -616,15 +1152,266 @@ func (n *networker) networkOf(id string) (*pkg.Network, error) {
 	return &net, nil
 }
 
+// AddPeer implements pkg.Networker interface
+func (n *networker) AddPeer(networkID pkg.NetID, peer pkg.Peer) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("AddPeer", string(networkID))
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("AddPeer", audit.Caller(ctx), string(networkID), peer, err)
+	}()
+
+	if err := validatePeer(peer); err != nil {
+		return err
+	}
+
+	return n.serialize(networkID, "AddPeer", peer, func() error {
+		return n.updateNR(networkID, func(netr *nr.NetResource) error {
+			return netr.AddPeer(peer)
+		})
+	})
+}
+
+// SetEgressPolicy implements pkg.Networker interface
+func (n *networker) SetEgressPolicy(networkID pkg.NetID, policy *pkg.EgressPolicy) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("SetEgressPolicy", string(networkID))
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("SetEgressPolicy", audit.Caller(ctx), string(networkID), policy, err)
+	}()
+
+	if policy != nil {
+		if err := validateEgressPolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	return n.serialize(networkID, "SetEgressPolicy", policy, func() error {
+		return n.updateNR(networkID, func(netr *nr.NetResource) error {
+			return netr.SetEgressPolicy(policy)
+		})
+	})
+}
+
+// RemovePeer implements pkg.Networker interface
+func (n *networker) RemovePeer(networkID pkg.NetID, prefix types.IPNet) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("RemovePeer", string(networkID))
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("RemovePeer", audit.Caller(ctx), string(networkID), prefix, err)
+	}()
+
+	return n.serialize(networkID, "RemovePeer", prefix, func() error {
+		return n.updateNR(networkID, func(netr *nr.NetResource) error {
+			return netr.RemovePeer(prefix)
+		})
+	})
+}
+
+// localNR loads the stored network networkID and its network resource on this node
+func (n *networker) localNR(networkID pkg.NetID) (*pkg.Network, *nr.NetResource, error) {
+	network, err := n.networkOf(string(networkID))
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("network %s is not deployed on this node", networkID)
+	} else if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to load network %s", networkID)
+	}
+
+	nodeID := n.nodeID
+	index := -1
+	for i := range network.NetResources {
+		if network.NetResources[i].NodeID == nodeID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("not network resource for this node: %s", nodeID)
+	}
+
+	netr, err := nr.New(network.NetID, &network.NetResources[index], &network.IPRange.IPNet)
+	if err != nil {
+		return nil, nil, err
+	}
+	netr.SetUnsealer(n.extractPrivateKey)
+	netr.SetRouteTable(n.routeTable)
+	netr.SetOffloads(n.offloads)
+
+	return network, netr, nil
+}
+
+// PeersStatus implements pkg.Networker interface
+func (n *networker) PeersStatus(networkID pkg.NetID) ([]pkg.PeerStatus, error) {
+	if err := n.throttle("PeersStatus", string(networkID)); err != nil {
+		return nil, err
+	}
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := netr.PeersStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	if n.asn == nil {
+		return status, nil
+	}
+
+	for i := range status {
+		host, _, err := net.SplitHostPort(status[i].Endpoint)
+		if err != nil {
+			continue
+		}
+		if asn, ok := n.asn.Lookup(net.ParseIP(host)); ok {
+			status[i].Location = &pkg.PeerLocation{
+				ASN:     asn.Number,
+				Org:     asn.Org,
+				Country: asn.Country,
+			}
+		}
+	}
+
+	return status, nil
+}
+
+// Neighbors implements pkg.Networker interface
+func (n *networker) Neighbors(networkID pkg.NetID, containerID string) ([]pkg.NeighborEntry, error) {
+	if err := n.throttle("Neighbors", string(networkID)); err != nil {
+		return nil, err
+	}
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return netr.Neighbors(containerID)
+}
+
+// SocketStats implements pkg.Networker interface
+func (n *networker) SocketStats(networkID pkg.NetID, containerID string) (pkg.SocketStats, error) {
+	if err := n.throttle("SocketStats", string(networkID)); err != nil {
+		return pkg.SocketStats{}, err
+	}
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return pkg.SocketStats{}, err
+	}
+
+	return netr.SocketStats(containerID)
+}
+
+// Offloads implements pkg.Networker interface
+func (n *networker) Offloads(networkID pkg.NetID) ([]pkg.LinkOffloads, error) {
+	if err := n.throttle("Offloads", string(networkID)); err != nil {
+		return nil, err
+	}
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return netr.Offloads()
+}
+
+// MoveIP implements pkg.Networker interface
+func (n *networker) MoveIP(networkID pkg.NetID, containerID string, ip net.IP) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("MoveIP", containerID)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("MoveIP", audit.Caller(ctx), containerID, []interface{}{networkID, ip}, err)
+	}()
+
+	_, netr, err := n.localNR(networkID)
+	if err != nil {
+		return err
+	}
+
+	return netr.MoveIP(containerID, ip)
+}
+
+// updateNR applies update on the network resource of networkID
+// then stores the updated network object
+func (n *networker) updateNR(networkID pkg.NetID, update func(netr *nr.NetResource) error) error {
+	network, netr, err := n.localNR(networkID)
+	if err != nil {
+		return err
+	}
+
+	// the stored network changes, so a CreateNR with the previous
+	// network object must be applied again
+	n.requests.Forget(string(networkID))
+
+	if err := update(netr); err != nil {
+		return err
+	}
+
+	return n.storeNetwork(network)
+}
+
 // DeleteNR implements pkg.Networker interface
-func (n *networker) DeleteNR(network pkg.Network) error {
+func (n *networker) DeleteNR(network pkg.Network) (err error) {
+	done, err := n.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("DeleteNR", string(network.NetID))
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		n.audit.Record("DeleteNR", audit.Caller(ctx), string(network.NetID), network, err)
+	}()
+
+	return n.serialize(network.NetID, "DeleteNR", network, func() error {
+		return n.deleteNR(network)
+	})
+}
+
+func (n *networker) deleteNR(network pkg.Network) error {
 	defer func() {
 		if err := n.publishWGPorts(); err != nil {
 			log.Warn().Msg("failed to publish wireguard port to BCDB")
 		}
 	}()
 
-	netNR, err := ResourceByNodeID(n.identity.NodeID().Identity(), network.NetResources)
+	// the network resource is gone, so any future create must be applied again
+	n.requests.Forget(string(network.NetID))
+
+	netNR, err := ResourceByNodeID(n.nodeID, network.NetResources)
 	if err != nil {
 		return err
 	}
@@ -643,6 +1430,10 @@ func (n *networker) DeleteNR(network pkg.Network) error {
 		// TODO: should we return the error ?
 	}
 
+	if err := n.names.Release(string(network.NetID)); err != nil {
+		log.Error().Err(err).Msg("release network resource names failed")
+	}
+
 	// map the network ID to the network namespace
 	path := filepath.Join(n.networkDir, string(network.NetID))
 	if err := os.Remove(path); err != nil {
@@ -652,6 +1443,139 @@ func (n *networker) DeleteNR(network pkg.Network) error {
 	return nil
 }
 
+// interfaceNames returns the names of the interfaces and namespace
+// created for a network resource, keyed by kind
+func interfaceNames(netr *nr.NetResource) (map[string]string, error) {
+	nsName, err := netr.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	brName, err := netr.BridgeName()
+	if err != nil {
+		return nil, err
+	}
+	wgName, err := netr.WGName()
+	if err != nil {
+		return nil, err
+	}
+	tap, err := tapName(pkg.NetID(netr.ID()))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"namespace": nsName,
+		"bridge":    brName,
+		"wireguard": wgName,
+		"tap":       tap,
+	}, nil
+}
+
+func ifaceNamesList(ifaces map[string]string) []string {
+	list := make([]string, 0, len(ifaces))
+	for _, name := range ifaces {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NamesAudit implements pkg.Networker interface
+func (n *networker) NamesAudit() ([]pkg.InterfaceName, error) {
+	if err := n.throttle("NamesAudit", ""); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(n.networkDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list stored networks")
+	}
+
+	registered, err := n.names.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list names registry")
+	}
+
+	nodeID := n.nodeID
+
+	var report []pkg.InterfaceName
+	users := make(map[string]map[pkg.NetID]struct{})
+	for _, info := range infos {
+		network, err := n.networkOf(info.Name())
+		if err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("failed to load network object")
+			continue
+		}
+
+		netNR, err := ResourceByNodeID(nodeID, network.NetResources)
+		if err != nil {
+			continue
+		}
+
+		netr, err := nr.New(network.NetID, netNR, &network.IPRange.IPNet)
+		if err != nil {
+			return nil, err
+		}
+
+		ifaces, err := interfaceNames(netr)
+		if err != nil {
+			log.Error().Err(err).Str("network", info.Name()).Msg("failed to compute network resource names")
+			continue
+		}
+
+		for kind, name := range ifaces {
+			report = append(report, pkg.InterfaceName{
+				Name:   name,
+				Kind:   kind,
+				NetID:  network.NetID,
+				Prefix: netNR.Subnet,
+				Owner:  pkg.NetID(registered[name]),
+			})
+
+			if users[name] == nil {
+				users[name] = make(map[pkg.NetID]struct{})
+			}
+			users[name][network.NetID] = struct{}{}
+		}
+	}
+
+	for i := range report {
+		report[i].Collision = len(users[report[i].Name]) > 1
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].NetID == report[j].NetID {
+			return report[i].Kind < report[j].Kind
+		}
+		return report[i].NetID < report[j].NetID
+	})
+
+	return report, nil
+}
+
+// SysctlsAudit implements pkg.Networker interface
+func (n *networker) SysctlsAudit() ([]pkg.SysctlRecord, error) {
+	if err := n.throttle("SysctlsAudit", ""); err != nil {
+		return nil, err
+	}
+
+	records := sysctl.Records()
+
+	report := make([]pkg.SysctlRecord, 0, len(records))
+	for _, record := range records {
+		report = append(report, pkg.SysctlRecord{
+			Namespace: record.Namespace,
+			Key:       record.Key,
+			Value:     record.Value,
+			Default:   record.Default,
+		})
+	}
+
+	return report, nil
+}
+
+// extractPrivateKey decrypts a hex encoded secret sealed with the node
+// identity, it's used for the private and preshared wireguard keys
 func (n *networker) extractPrivateKey(hexKey string) (string, error) {
 	//FIXME zaibon: I would like to move this into the nr package,
 	// but this method requires the identity module which is only available
@@ -685,12 +1609,17 @@ func (n *networker) releasePort(port uint16) error {
 }
 
 func (n *networker) publishWGPorts() error {
+	if n.tnodb == nil {
+		// offline node, there is no explorer to publish to
+		return nil
+	}
+
 	ports, err := n.portSet.List()
 	if err != nil {
 		return err
 	}
 
-	if err := n.tnodb.NodeSetPorts(n.identity.NodeID().Identity(), ports); err != nil {
+	if err := n.tnodb.NodeSetPorts(n.nodeID, ports); err != nil {
 		// maybe retry a couple of times ?
 		// having bdb and the node out of sync is pretty bad
 		return errors.Wrap(err, "fail to publish wireguard port to bcdb")
@@ -789,6 +1718,39 @@ func (n *networker) PublicAddresses(ctx context.Context) <-chan pkg.NetlinkAddre
 	return n.monitorNS(ctx, types.PublicNamespace, types.PublicIface)
 }
 
+// FloodCounters implements pkg.Networker interface
+func (n *networker) FloodCounters(ctx context.Context) <-chan pkg.FloodCounters {
+	ch := make(chan pkg.FloodCounters)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(floodCountersInterval):
+			}
+
+			if !namespace.Exists(types.PublicNamespace) {
+				continue
+			}
+
+			counters, err := publicFloodCounters()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to read public namespace protection counters")
+				continue
+			}
+
+			select {
+			case ch <- counters:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
 func (n *networker) ZOSAddresses(ctx context.Context) <-chan pkg.NetlinkAddresses {
 	// we don't use monitorNS because
 	// 1- this is the host namespace
@@ -902,9 +1864,5 @@ func ResourceByNodeID(nodeID string, resources []pkg.NetResource) (*pkg.NetResou
 
 // tapName returns the name of the tap device for a network namespace
 func tapName(netID pkg.NetID) (string, error) {
-	name := fmt.Sprintf("t-%s", netID)
-	if len(name) > 15 {
-		return "", errors.Errorf("tap name too long %s", name)
-	}
-	return name, nil
+	return plan.TapName(netID)
 }
@@ -0,0 +1,108 @@
+// Package nlmsg parses and builds the raw netlink messages of the netfilter
+// subsystems the netlink package doesn't support (conntrack events, nflog).
+//
+// The netlink headers are in the byte order of the host, the values of the
+// attributes are in the order their subsystem defines, usually the network
+// order.
+package nlmsg
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// typeMask strips the nested and byte order flags of an attribute type
+const typeMask = ^uint16(unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+
+func align(length, to int) int {
+	return (length + to - 1) &^ (to - 1)
+}
+
+// Messages calls fn with the type and the payload of every message of the
+// netlink datagram data
+func Messages(data []byte, fn func(typ uint16, payload []byte) error) error {
+	for len(data) >= unix.SizeofNlMsghdr {
+		length := int(nl.NativeEndian().Uint32(data[0:4]))
+		typ := nl.NativeEndian().Uint16(data[4:6])
+		if length < unix.SizeofNlMsghdr || length > len(data) {
+			return fmt.Errorf("invalid netlink message length %d", length)
+		}
+
+		if err := fn(typ, data[unix.SizeofNlMsghdr:length]); err != nil {
+			return err
+		}
+
+		aligned := align(length, unix.NLMSG_ALIGNTO)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+
+	return nil
+}
+
+// Attrs calls fn with the type, without its flags, and the value of every
+// netlink attribute of data
+func Attrs(data []byte, fn func(typ uint16, value []byte) error) error {
+	for len(data) >= unix.SizeofNlAttr {
+		length := int(nl.NativeEndian().Uint16(data[0:2]))
+		typ := nl.NativeEndian().Uint16(data[2:4]) & typeMask
+		if length < unix.SizeofNlAttr || length > len(data) {
+			return fmt.Errorf("invalid netlink attribute length %d", length)
+		}
+
+		if err := fn(typ, data[unix.SizeofNlAttr:length]); err != nil {
+			return err
+		}
+
+		aligned := align(length, unix.NLA_ALIGNTO)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+
+	return nil
+}
+
+// Message builds a netlink message with its header
+func Message(typ, flags uint16, seq uint32, payload []byte) []byte {
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(payload))
+	nl.NativeEndian().PutUint32(msg[0:], uint32(unix.SizeofNlMsghdr+len(payload)))
+	nl.NativeEndian().PutUint16(msg[4:], typ)
+	nl.NativeEndian().PutUint16(msg[6:], flags)
+	nl.NativeEndian().PutUint32(msg[8:], seq)
+
+	return append(msg, payload...)
+}
+
+// Attr builds a netlink attribute, padded to the alignment of the
+// attributes
+func Attr(typ uint16, value []byte) []byte {
+	length := unix.SizeofNlAttr + len(value)
+
+	attr := make([]byte, align(length, unix.NLA_ALIGNTO))
+	nl.NativeEndian().PutUint16(attr[0:], uint16(length))
+	nl.NativeEndian().PutUint16(attr[2:], typ)
+	copy(attr[unix.SizeofNlAttr:], value)
+
+	return attr
+}
+
+// Error returns the error of a NLMSG_ERROR message payload, the ack of a
+// request is an error message with no error
+func Error(payload []byte) error {
+	// struct nlmsgerr: the error, then the header of the request
+	if len(payload) < 4 {
+		return fmt.Errorf("invalid netlink error message")
+	}
+
+	if errno := int32(nl.NativeEndian().Uint32(payload)); errno != 0 {
+		return unix.Errno(-errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package nlmsg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestAttrs(t *testing.T) {
+	require := require.New(t)
+
+	data := append(Attr(1, []byte{1, 2, 3}), Attr(2|unix.NLA_F_NESTED, Attr(3|unix.NLA_F_NET_BYTEORDER, []byte{4, 5, 6, 7}))...)
+	// the attributes are padded
+	require.Len(data, 8+12)
+
+	var types []uint16
+	var values [][]byte
+	err := Attrs(data, func(typ uint16, value []byte) error {
+		types = append(types, typ)
+		values = append(values, value)
+		return nil
+	})
+	require.NoError(err)
+	require.Equal([]uint16{1, 2}, types)
+	require.Equal([]byte{1, 2, 3}, values[0])
+
+	// the flags are stripped of the nested attributes too
+	err = Attrs(values[1], func(typ uint16, value []byte) error {
+		assert.Equal(t, uint16(3), typ)
+		assert.Equal(t, []byte{4, 5, 6, 7}, value)
+		return nil
+	})
+	require.NoError(err)
+
+	// a truncated attribute is an error
+	require.Error(Attrs(data[:6], func(uint16, []byte) error { return nil }))
+}
+
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+
+	msg := Message(0x0100, unix.NLM_F_REQUEST, 42, []byte{1, 2, 3})
+	require.Equal(uint32(unix.SizeofNlMsghdr+3), nl.NativeEndian().Uint32(msg[0:]))
+	require.Equal(uint16(unix.NLM_F_REQUEST), nl.NativeEndian().Uint16(msg[6:]))
+	require.Equal(uint32(42), nl.NativeEndian().Uint32(msg[8:]))
+
+	// the messages of a datagram are aligned
+	data := append(msg, 0)
+	data = append(data, Message(0x0200, 0, 43, nil)...)
+
+	var types []uint16
+	var payloads [][]byte
+	err := Messages(data, func(typ uint16, payload []byte) error {
+		types = append(types, typ)
+		payloads = append(payloads, payload)
+		return nil
+	})
+	require.NoError(err)
+	require.Equal([]uint16{0x0100, 0x0200}, types)
+	require.Equal([]byte{1, 2, 3}, payloads[0])
+	require.Empty(payloads[1])
+
+	require.Error(Messages(msg[:len(msg)-1], func(uint16, []byte) error { return nil }))
+}
+
+func TestError(t *testing.T) {
+	payload := make([]byte, 4)
+	require.NoError(t, Error(payload))
+
+	errno := -int32(unix.EPERM)
+	nl.NativeEndian().PutUint32(payload, uint32(errno))
+	require.Equal(t, unix.EPERM, Error(payload))
+
+	require.Error(t, Error(payload[:2]))
+}
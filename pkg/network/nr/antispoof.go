@@ -0,0 +1,92 @@
+package nr
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/network/antispoof"
+	"github.com/vishvananda/netlink"
+)
+
+// memberPort returns the port of the workload containerID on the bridge of
+// the network resource, with the MAC and the addresses of its interface
+func memberPort(containerID string) (antispoof.Port, error) {
+	var (
+		port antispoof.Port
+		peer int
+	)
+	err := withMemberLink(containerID, func(link netlink.Link) error {
+		port.MAC = link.Attrs().HardwareAddr
+		peer = link.Attrs().ParentIndex
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			if addr.IP.IsGlobalUnicast() || addr.IP.IsLinkLocalUnicast() {
+				port.IPs = append(port.IPs, addr.IP)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return port, errors.Wrapf(err, "failed to get interface of %s", containerID)
+	}
+
+	hostLink, err := netlink.LinkByIndex(peer)
+	if err != nil {
+		return port, errors.Wrapf(err, "failed to get host end of the interface of %s", containerID)
+	}
+	port.Name = hostLink.Attrs().Name
+
+	return port, nil
+}
+
+// guard pins the port of the workload containerID to the MAC and the
+// current addresses of its interface
+func (nr *NetResource) guard(containerID string) error {
+	port, err := memberPort(containerID)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().
+		Str("network", string(nr.id)).
+		Str("container", containerID).
+		Str("port", port.Name).
+		Str("mac", port.MAC.String()).
+		Msg("pin workload port")
+
+	return antispoof.Guard(port)
+}
+
+// GuardMembers pins the ports of all the workloads of the network resource,
+// the workloads that joined before the ports were pinned are covered too
+func (nr *NetResource) GuardMembers() error {
+	members, err := nr.Members()
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if err := nr.guard(member); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release removes the rules of the port of the workload containerID, it
+// must be called before its namespace is deleted
+func (nr *NetResource) release(containerID string) error {
+	port, err := memberPort(containerID)
+	if _, ok := errors.Cause(err).(netlink.LinkNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return antispoof.Release(port.Name)
+}
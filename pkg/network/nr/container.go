@@ -7,13 +7,16 @@ import (
 
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ns"
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/network/bridge"
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/offload"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -55,7 +58,7 @@ func (nr *NetResource) Join(containerID string, addrs []net.IP, publicIP6 bool)
 		slog.Info().
 			Str("veth", "eth0").
 			Msg("Create veth pair in net namespace")
-		hostVeth, containerVeth, err := ip.SetupVeth("eth0", 1500, host)
+		hostVeth, containerVeth, err := ip.SetupVeth("eth0", mtu.Farm(), host)
 		if err != nil {
 			return errors.Wrapf(err, "failed to create veth pair in namespace (%s)", join.Namespace)
 		}
@@ -67,6 +70,14 @@ func (nr *NetResource) Join(containerID string, addrs []net.IP, publicIP6 bool)
 			return err
 		}
 
+		if err := ifaceutil.SetIPv6Policy(containerID, eth0.Attrs().Name, ifaceutil.DefaultIPv6Policy); err != nil {
+			return err
+		}
+
+		if err := offload.Apply(eth0.Attrs().Name, nr.offloads.Veth); err != nil {
+			return err
+		}
+
 		for _, addr := range addrs {
 			slog.Info().
 				Str("ip", addr.String()).
@@ -82,18 +93,8 @@ func (nr *NetResource) Join(containerID string, addrs []net.IP, publicIP6 bool)
 		}
 
 		if !publicIP6 {
-			ipv6 := convert4to6(nr.ID(), addrs[0])
-			slog.Info().
-				Str("ip", ipv6.String()).
-				Msgf("set ip to container")
-
-			if err := netlink.AddrAdd(eth0, &netlink.Addr{IPNet: &net.IPNet{
-				IP:   ipv6,
-				Mask: net.CIDRMask(64, 128),
-			}}); err != nil && !os.IsExist(err) {
-				return err
-			}
-			join.IPv6 = ipv6
+			// the address is set once the veth is attached to the bridge
+			join.IPv6 = plan.IPv6(nr.id, addrs[0])
 		}
 
 		ipnet := nr.resource.Subnet
@@ -116,7 +117,7 @@ func (nr *NetResource) Join(containerID string, addrs []net.IP, publicIP6 bool)
 						IP:   net.ParseIP("::"),
 						Mask: net.CIDRMask(0, 128),
 					},
-					Gw:        net.ParseIP("fe80::1"),
+					Gw:        plan.LinkLocalGateway.IP,
 					LinkIndex: eth0.Attrs().Index,
 				})
 		}
@@ -143,11 +144,46 @@ func (nr *NetResource) Join(containerID string, addrs []net.IP, publicIP6 bool)
 		return join, err
 	}
 
-	if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", hostVeth.Attrs().Name), "1"); err != nil {
+	if err := sysctl.Set(sysctl.Host, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", hostVeth.Attrs().Name), "1"); err != nil {
 		return join, errors.Wrapf(err, "failed to disable ip6 on bridge %s", hostVeth.Attrs().Name)
 	}
 
-	return join, bridge.AttachNic(hostVeth, br)
+	if err := offload.Apply(hostVeth.Attrs().Name, nr.offloads.Veth); err != nil {
+		return join, err
+	}
+
+	if err = bridge.AttachNic(hostVeth, br); err != nil {
+		return join, err
+	}
+
+	if join.IPv6 != nil {
+		// the duplicate address detection probes the network through the
+		// bridge, a duplicate fails the join
+		slog.Info().
+			Str("ip", join.IPv6.String()).
+			Msgf("set ip to container")
+		err = netspace.Do(func(_ ns.NetNS) error {
+			eth0, err := netlink.LinkByName("eth0")
+			if err != nil {
+				return err
+			}
+
+			return ifaceutil.AddIPv6(eth0, &net.IPNet{
+				IP:   join.IPv6,
+				Mask: net.CIDRMask(64, 128),
+			})
+		})
+		if err != nil {
+			return join, err
+		}
+	}
+
+	// the workload can only send from its MAC and its addresses
+	if err = nr.guard(containerID); err != nil {
+		return join, errors.Wrap(err, "failed to pin workload port")
+	}
+
+	return join, nil
 }
 
 // Leave delete a container network namespace
@@ -167,6 +203,11 @@ func (nr *NetResource) Leave(containerID string) error {
 	}
 	defer ns.Close()
 
+	// the host end of the veth is deleted with the namespace
+	if err := nr.release(containerID); err != nil {
+		log.Error().Err(err).Str("container", containerID).Msg("failed to release workload port")
+	}
+
 	err = namespace.Delete(ns)
 	if err != nil {
 		return err
@@ -0,0 +1,116 @@
+package nr
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/nft"
+)
+
+// ApplyEgressPolicy applies the egress policy of the network resource,
+// replacing the previous one. The rest of the firewall is untouched
+func (nr *NetResource) ApplyEgressPolicy() error {
+	nsName, err := nr.Namespace()
+	if err != nil {
+		return err
+	}
+
+	data, err := egressRules(nr.resource.EgressPolicy)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := egressTmpl.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "failed to build egress rule set")
+	}
+
+	if err := nft.Apply(&buf, nsName); err != nil {
+		return errors.Wrap(err, "failed to apply egress rule set")
+	}
+
+	return nil
+}
+
+func egressRules(policy *pkg.EgressPolicy) (*egressData, error) {
+	if policy == nil {
+		return nil, nil
+	}
+
+	data := &egressData{}
+	switch policy.Mode {
+	case pkg.EgressAllow:
+		data.Allow = true
+		data.Verdict = "accept"
+	case pkg.EgressDeny:
+		data.Verdict = "counter reject"
+	default:
+		return nil, fmt.Errorf("unknown egress policy mode '%s'", policy.Mode)
+	}
+
+	for _, rule := range policy.Rules {
+		match, err := egressMatch(rule)
+		if err != nil {
+			return nil, err
+		}
+		data.Matches = append(data.Matches, match)
+	}
+
+	return data, nil
+}
+
+// egressMatch returns the nft match expression of rule
+func egressMatch(rule pkg.EgressRule) (string, error) {
+	if rule.Dst.IP == nil {
+		return "", fmt.Errorf("egress rule destination cannot be empty")
+	}
+
+	family := "ip6"
+	ip := rule.Dst.IP.Mask(rule.Dst.Mask)
+	if ip4 := ip.To4(); ip4 != nil {
+		family = "ip"
+		ip = ip4
+	}
+
+	dst := net.IPNet{IP: ip, Mask: rule.Dst.Mask}
+	match := fmt.Sprintf("%s daddr %s", family, dst.String())
+
+	switch rule.Protocol {
+	case "":
+		if len(rule.Ports) > 0 {
+			return "", fmt.Errorf("egress rule with ports needs a protocol")
+		}
+		return match, nil
+	case "tcp", "udp":
+	default:
+		return "", fmt.Errorf("unknown egress rule protocol '%s'", rule.Protocol)
+	}
+
+	if len(rule.Ports) == 0 {
+		return fmt.Sprintf("%s meta l4proto %s", match, rule.Protocol), nil
+	}
+
+	ports := make([]string, 0, len(rule.Ports))
+	for _, port := range rule.Ports {
+		ports = append(ports, fmt.Sprint(port))
+	}
+
+	return fmt.Sprintf("%s %s dport { %s }", match, rule.Protocol, strings.Join(ports, ", ")), nil
+}
+
+// SetEgressPolicy replaces the egress policy of the network
+// resource and applies it, nil removes the policy
+func (nr *NetResource) SetEgressPolicy(policy *pkg.EgressPolicy) error {
+	old := nr.resource.EgressPolicy
+	nr.resource.EgressPolicy = policy
+	if err := nr.ApplyEgressPolicy(); err != nil {
+		nr.resource.EgressPolicy = old
+		return err
+	}
+
+	return nil
+}
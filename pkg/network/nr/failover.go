@@ -0,0 +1,131 @@
+package nr
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// HasAlternateEndpoints returns true if at least one peer of the
+// network resource has alternative endpoints
+func (nr *NetResource) HasAlternateEndpoints() bool {
+	for _, peer := range nr.resource.Peers {
+		if len(peer.Endpoints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointFailover moves the peers that have alternative endpoints and
+// didn't complete a handshake since maxAge to their next endpoint.
+// A wireguard interface can only have one entry per peer, so the peer
+// endpoint is migrated instead of balancing the traffic over both
+// underlays
+func (nr *NetResource) EndpointFailover(maxAge time.Duration) error {
+	return nr.inNamespace(func() error {
+		return nr.failover(time.Now(), maxAge)
+	})
+}
+
+// peerEndpoints returns all the endpoints of the peer, in order of preference
+func peerEndpoints(peer *pkg.Peer) []string {
+	var endpoints []string
+	seen := make(map[string]struct{})
+	for _, endpoint := range append([]string{peer.Endpoint}, peer.Endpoints...) {
+		if endpoint == "" {
+			continue
+		}
+		if _, ok := seen[endpoint]; ok {
+			continue
+		}
+		seen[endpoint] = struct{}{}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+func (nr *NetResource) failover(now time.Time, maxAge time.Duration) error {
+	wgName, _, err := nr.wgLink()
+	if err != nil {
+		return err
+	}
+
+	device, err := nr.kernel.Device(wgName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get wireguard device %s", wgName)
+	}
+
+	current := make(map[wgtypes.Key]*wgtypes.Peer)
+	for i := range device.Peers {
+		current[device.Peers[i].PublicKey] = &device.Peers[i]
+	}
+
+	for _, peer := range nr.resource.Peers {
+		endpoints := peerEndpoints(&peer)
+		if len(endpoints) < 2 {
+			continue
+		}
+
+		key, err := wgtypes.ParseKey(peer.WGPublicKey)
+		if err != nil {
+			return errors.Wrapf(err, "invalid public key of peer %s", peer.Subnet.String())
+		}
+
+		state, ok := current[key]
+		if !ok {
+			continue
+		}
+
+		if !state.LastHandshakeTime.IsZero() && now.Sub(state.LastHandshakeTime) < maxAge {
+			continue
+		}
+
+		next, err := nextEndpoint(endpoints, state)
+		if err != nil {
+			return errors.Wrapf(err, "invalid endpoint of peer %s", peer.Subnet.String())
+		}
+
+		log.Info().
+			Str("peer", peer.Subnet.String()).
+			Str("endpoint", next.String()).
+			Time("last-handshake", state.LastHandshakeTime).
+			Msg("no recent handshake with peer, switching endpoint")
+
+		config := wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:  key,
+				UpdateOnly: true,
+				Endpoint:   next,
+			}},
+		}
+		if err := nr.kernel.ConfigureDevice(wgName, config); err != nil {
+			return errors.Wrapf(err, "failed to update endpoint of peer %s", peer.Subnet.String())
+		}
+	}
+
+	return nil
+}
+
+// nextEndpoint returns the endpoint following the one currently used
+// by the peer, or the first endpoint if the current one is unknown
+func nextEndpoint(endpoints []string, state *wgtypes.Peer) (*net.UDPAddr, error) {
+	index := -1
+	for i, endpoint := range endpoints {
+		addr, err := wireguard.ParseEndpoint(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if state.Endpoint != nil && addr.String() == state.Endpoint.String() {
+			index = i
+			break
+		}
+	}
+
+	return wireguard.ParseEndpoint(endpoints[(index+1)%len(endpoints)])
+}
@@ -0,0 +1,231 @@
+package nr
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/prefix"
+	"github.com/threefoldtech/zos/pkg/network/wireguard"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// pubIface is the name of the interface of the network resource
+	// connected to the ndmz (created by ndmz.AttachNR). The IPSec
+	// tunnels use its address as local endpoint, the ndmz NATs it
+	pubIface = "public"
+
+	ipsecKeySize = 64
+)
+
+// ipsecTunnel is the resolved configuration of an IPSec tunnel with a peer
+type ipsecTunnel struct {
+	local  net.IP
+	remote net.IP
+	reqid  int
+
+	spiIn, spiOut int
+	keyIn, keyOut []byte
+
+	// local and remote subnets of the tunnel
+	localNet   *net.IPNet
+	remoteNets []net.IPNet
+}
+
+func (nr *NetResource) ipsecTunnel(peer *pkg.Peer) (*ipsecTunnel, error) {
+	if peer.IPSec == nil {
+		return nil, fmt.Errorf("peer %s has no ipsec configuration", peer.Subnet.String())
+	}
+
+	if nr.unseal == nil {
+		return nil, fmt.Errorf("cannot decrypt ipsec keys of peer %s", peer.Subnet.String())
+	}
+
+	remote, err := wireguard.ParseEndpoint(peer.Endpoint)
+	if err != nil || remote.IP.To4() == nil {
+		return nil, fmt.Errorf("ipsec peer %s needs an IPv4 endpoint", peer.Subnet.String())
+	}
+
+	local, err := nr.ipsecLocalIP()
+	if err != nil {
+		return nil, err
+	}
+
+	unseal := func(sealed string) ([]byte, error) {
+		key, err := nr.unseal(sealed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt ipsec key of peer %s", peer.Subnet.String())
+		}
+		if len(key) != ipsecKeySize {
+			return nil, fmt.Errorf("ipsec key of peer %s must be %d bytes", peer.Subnet.String(), ipsecKeySize)
+		}
+		return []byte(key), nil
+	}
+
+	keyIn, err := unseal(peer.IPSec.KeyIn)
+	if err != nil {
+		return nil, err
+	}
+	keyOut, err := unseal(peer.IPSec.KeyOut)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []net.IPNet
+	for _, ip := range peer.AllowedIPs {
+		allowed = append(allowed, ip.IPNet)
+	}
+
+	return &ipsecTunnel{
+		local:      local,
+		remote:     remote.IP.To4(),
+		reqid:      int(peer.IPSec.SPIOut),
+		spiIn:      int(peer.IPSec.SPIIn),
+		spiOut:     int(peer.IPSec.SPIOut),
+		keyIn:      keyIn,
+		keyOut:     keyOut,
+		localNet:   &nr.resource.Subnet.IPNet,
+		remoteNets: prefix.Aggregate(allowed),
+	}, nil
+}
+
+// ipsecLocalIP returns the IPv4 address of the public interface of the network resource
+func (nr *NetResource) ipsecLocalIP() (net.IP, error) {
+	link, err := nr.kernel.LinkByName(pubIface)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get interface %s", pubIface)
+	}
+
+	addrs, err := nr.kernel.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("interface %s has no IPv4 address", pubIface)
+	}
+
+	return addrs[0].IP.To4(), nil
+}
+
+func (t *ipsecTunnel) states() []netlink.XfrmState {
+	state := func(src, dst net.IP, spi int, key []byte) netlink.XfrmState {
+		return netlink.XfrmState{
+			Src:          src,
+			Dst:          dst,
+			Proto:        netlink.XFRM_PROTO_ESP,
+			Mode:         netlink.XFRM_MODE_TUNNEL,
+			Spi:          spi,
+			Reqid:        t.reqid,
+			ReplayWindow: 32,
+			Crypt: &netlink.XfrmStateAlgo{
+				Name: "cbc(aes)",
+				Key:  key[:32],
+			},
+			Auth: &netlink.XfrmStateAlgo{
+				Name:        "hmac(sha256)",
+				Key:         key[32:],
+				TruncateLen: 128,
+			},
+		}
+	}
+
+	return []netlink.XfrmState{
+		state(t.local, t.remote, t.spiOut, t.keyOut),
+		state(t.remote, t.local, t.spiIn, t.keyIn),
+	}
+}
+
+func (t *ipsecTunnel) policies() []netlink.XfrmPolicy {
+	var policies []netlink.XfrmPolicy
+	for i := range t.remoteNets {
+		remote := &t.remoteNets[i]
+		policies = append(policies,
+			netlink.XfrmPolicy{
+				Src: t.localNet,
+				Dst: remote,
+				Dir: netlink.XFRM_DIR_OUT,
+				Tmpls: []netlink.XfrmPolicyTmpl{
+					{Src: t.local, Dst: t.remote, Proto: netlink.XFRM_PROTO_ESP, Mode: netlink.XFRM_MODE_TUNNEL, Reqid: t.reqid},
+				},
+			},
+		)
+
+		// the traffic from the peer is either for the namespace itself or
+		// forwarded to the network resource bridge
+		for _, dir := range []netlink.Dir{netlink.XFRM_DIR_IN, netlink.XFRM_DIR_FWD} {
+			policies = append(policies, netlink.XfrmPolicy{
+				Src: remote,
+				Dst: t.localNet,
+				Dir: dir,
+				Tmpls: []netlink.XfrmPolicyTmpl{
+					{Src: t.remote, Dst: t.local, Proto: netlink.XFRM_PROTO_ESP, Mode: netlink.XFRM_MODE_TUNNEL, Reqid: t.reqid},
+				},
+			})
+		}
+	}
+
+	return policies
+}
+
+// addIPSecPeer programs the security associations and policies of the
+// tunnel with peer. It must be executed inside the network resource namespace
+func (nr *NetResource) addIPSecPeer(peer *pkg.Peer) error {
+	tunnel, err := nr.ipsecTunnel(peer)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range tunnel.states() {
+		state := state
+		err := nr.kernel.XfrmStateAdd(&state)
+		if os.IsExist(err) {
+			err = nr.kernel.XfrmStateUpdate(&state)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to set ipsec state spi %d", state.Spi)
+		}
+	}
+
+	for _, policy := range tunnel.policies() {
+		policy := policy
+		err := nr.kernel.XfrmPolicyAdd(&policy)
+		if os.IsExist(err) {
+			err = nr.kernel.XfrmPolicyUpdate(&policy)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to set ipsec policy %s", policy.String())
+		}
+	}
+
+	log.Info().Str("peer", peer.Subnet.String()).Msg("ipsec tunnel configured")
+	return nil
+}
+
+// removeIPSecPeer removes the security associations and policies of the
+// tunnel with peer. It must be executed inside the network resource namespace
+func (nr *NetResource) removeIPSecPeer(peer *pkg.Peer) error {
+	tunnel, err := nr.ipsecTunnel(peer)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range tunnel.policies() {
+		policy := policy
+		if err := nr.kernel.XfrmPolicyDel(&policy); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "failed to delete ipsec policy %s", policy.String())
+		}
+	}
+
+	for _, state := range tunnel.states() {
+		state := state
+		if err := nr.kernel.XfrmStateDel(&state); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "failed to delete ipsec state spi %d", state.Spi)
+		}
+	}
+
+	return nil
+}
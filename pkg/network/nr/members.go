@@ -0,0 +1,368 @@
+package nr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/announce"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/sockstat"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// memberLink is the interface of the workloads hooked to the bridge of
+// the network resource
+const memberLink = "eth0"
+
+// IsMember returns true if the namespace of containerID joined the network
+// resource: its interface is the peer of a veth attached to the bridge
+func (nr *NetResource) IsMember(containerID string) (bool, error) {
+	name, err := nr.BridgeName()
+	if err != nil {
+		return false, err
+	}
+
+	br, err := netlink.LinkByName(name)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get bridge %s", name)
+	}
+
+	if !namespace.Exists(containerID) {
+		return false, nil
+	}
+
+	netNS, err := namespace.GetByName(containerID)
+	if err != nil {
+		return false, err
+	}
+	defer netNS.Close()
+
+	peer := 0
+	err = netNS.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(memberLink)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, ok := link.(*netlink.Veth); ok {
+			peer = link.Attrs().ParentIndex
+		}
+		return nil
+	})
+	if err != nil || peer == 0 {
+		return false, err
+	}
+
+	hostLink, err := netlink.LinkByIndex(peer)
+	if err != nil {
+		// the peer is not in the host namespace
+		return false, nil
+	}
+
+	return hostLink.Attrs().MasterIndex == br.Attrs().Index, nil
+}
+
+// Members returns the namespaces of the workloads that joined the
+// network resource
+func (nr *NetResource) Members() ([]string, error) {
+	names, err := namespace.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list namespaces")
+	}
+
+	var members []string
+	for _, name := range names {
+		member, err := nr.IsMember(name)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			members = append(members, name)
+		}
+	}
+
+	return members, nil
+}
+
+// serviceAddress returns the address to set on the workloads for the
+// service ip. The ip must be in the subnet of the network resource, and not
+// one of the addresses of the network resource itself
+func (nr *NetResource) serviceAddress(ip net.IP) (*net.IPNet, error) {
+	subnet := nr.resource.Subnet.IPNet
+	gateway := plan.Gateway(subnet)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if !subnet.Contains(ip4) {
+			return nil, fmt.Errorf("ip %s is not in the subnet %s of the network resource", ip, subnet.String())
+		}
+
+		last := ip4[len(ip4)-1]
+		if ip4.Equal(gateway.To4()) || last == 0 || last == 0xff {
+			return nil, fmt.Errorf("ip %s is reserved", ip)
+		}
+
+		// same mask as the addresses set by Join
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(24, 32)}, nil
+	}
+
+	prefix := net.IPNet{
+		IP:   plan.IPv6(nr.id, gateway),
+		Mask: net.CIDRMask(64, 128),
+	}
+	if !prefix.Contains(ip) {
+		return nil, fmt.Errorf("ip %s is not in the prefix %s of the network resource", ip, prefix.String())
+	}
+	if ip.Equal(prefix.IP) {
+		return nil, fmt.Errorf("ip %s is reserved", ip)
+	}
+
+	return &net.IPNet{IP: ip, Mask: prefix.Mask}, nil
+}
+
+// MoveIP sets the service ip on the workload containerID and removes it
+// from the other workloads of the network resource. The neighbors are told
+// about the move with gratuitous ARP or unsolicited neighbor advertisements,
+// so the traffic follows the ip right away
+func (nr *NetResource) MoveIP(containerID string, ip net.IP) error {
+	addr, err := nr.serviceAddress(ip)
+	if err != nil {
+		return err
+	}
+
+	member, err := nr.IsMember(containerID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return fmt.Errorf("%s is not a member of network %s", containerID, nr.id)
+	}
+
+	members, err := nr.Members()
+	if err != nil {
+		return err
+	}
+
+	slog := log.With().Str("network", string(nr.id)).Str("ip", ip.String()).Logger()
+
+	// the ip is removed first, it must never answer on two workloads
+	for _, other := range members {
+		if other == containerID {
+			continue
+		}
+
+		removed := false
+		err := withMemberLink(other, func(link netlink.Link) error {
+			err := netlink.AddrDel(link, &netlink.Addr{IPNet: addr})
+			if err == unix.EADDRNOTAVAIL {
+				return nil
+			}
+			removed = err == nil
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove %s from %s", ip, other)
+		}
+		if removed {
+			slog.Info().Str("container", other).Msg("service ip removed")
+			if err := nr.guard(other); err != nil {
+				return errors.Wrapf(err, "failed to unpin %s from %s", ip, other)
+			}
+		}
+	}
+
+	err = withMemberLink(containerID, func(link netlink.Link) error {
+		address := netlink.Addr{IPNet: addr}
+		if addr.IP.To4() == nil {
+			// the ip was just removed from another workload, the duplicate
+			// address detection would only delay it
+			address.Flags = unix.IFA_F_NODAD
+		}
+		if err := netlink.AddrAdd(link, &address); err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to set %s on %s", ip, containerID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the announcements are sent from the ip, the port must allow it first
+	if err := nr.guard(containerID); err != nil {
+		return errors.Wrapf(err, "failed to pin %s to %s", ip, containerID)
+	}
+	slog.Info().Str("container", containerID).Msg("service ip moved")
+
+	return withMemberLink(containerID, func(link netlink.Link) error {
+		iface, err := net.InterfaceByIndex(link.Attrs().Index)
+		if err != nil {
+			return err
+		}
+
+		return announce.Send(iface, ip)
+	})
+}
+
+// withMemberLink runs fn inside the namespace of the workload containerID
+// with its interface
+func withMemberLink(containerID string, fn func(link netlink.Link) error) error {
+	netNS, err := namespace.GetByName(containerID)
+	if err != nil {
+		return err
+	}
+	defer netNS.Close()
+
+	return netNS.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(memberLink)
+		if err != nil {
+			return err
+		}
+
+		return fn(link)
+	})
+}
+
+// neighborStates are the names of the states of the neighbor entries
+var neighborStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "incomplete"},
+	{netlink.NUD_REACHABLE, "reachable"},
+	{netlink.NUD_STALE, "stale"},
+	{netlink.NUD_DELAY, "delay"},
+	{netlink.NUD_PROBE, "probe"},
+	{netlink.NUD_FAILED, "failed"},
+	{netlink.NUD_NOARP, "noarp"},
+	{netlink.NUD_PERMANENT, "permanent"},
+}
+
+func neighborState(state int) string {
+	for _, s := range neighborStates {
+		if state&s.state != 0 {
+			return s.name
+		}
+	}
+
+	return "none"
+}
+
+// debugNamespace returns the namespace of the network resource, or of the
+// workload containerID if not empty
+func (nr *NetResource) debugNamespace(containerID string) (string, error) {
+	if len(containerID) == 0 {
+		return nr.Namespace()
+	}
+
+	member, err := nr.IsMember(containerID)
+	if err != nil {
+		return "", err
+	}
+	if !member {
+		return "", fmt.Errorf("%s is not a member of network %s", containerID, nr.id)
+	}
+
+	return containerID, nil
+}
+
+// Neighbors returns the neighbor tables (ARP and NDP) of the namespace of
+// the network resource, or of the workload containerID if not empty
+func (nr *NetResource) Neighbors(containerID string) ([]pkg.NeighborEntry, error) {
+	name, err := nr.debugNamespace(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	netNS, err := namespace.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	defer netNS.Close()
+
+	var entries []pkg.NeighborEntry
+	err = netNS.Do(func(_ ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+
+		names := make(map[int]string)
+		for _, link := range links {
+			names[link.Attrs().Index] = link.Attrs().Name
+		}
+
+		neighbors, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+
+		for _, neighbor := range neighbors {
+			// the multicast and the local entries are not learned
+			if neighbor.State&netlink.NUD_NOARP != 0 || neighbor.IP.IsMulticast() {
+				continue
+			}
+
+			entry := pkg.NeighborEntry{
+				IP:        neighbor.IP.String(),
+				Interface: names[neighbor.LinkIndex],
+				State:     neighborState(neighbor.State),
+				Router:    neighbor.Flags&netlink.NTF_ROUTER != 0,
+			}
+			if len(neighbor.HardwareAddr) != 0 {
+				entry.MAC = neighbor.HardwareAddr.String()
+			}
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the neighbors of %s", name)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Interface != entries[j].Interface {
+			return entries[i].Interface < entries[j].Interface
+		}
+		return entries[i].IP < entries[j].IP
+	})
+
+	return entries, nil
+}
+
+// SocketStats returns the summary of the sockets of the namespace of the
+// network resource, or of the workload containerID if not empty
+func (nr *NetResource) SocketStats(containerID string) (pkg.SocketStats, error) {
+	name, err := nr.debugNamespace(containerID)
+	if err != nil {
+		return pkg.SocketStats{}, err
+	}
+
+	netNS, err := namespace.GetByName(name)
+	if err != nil {
+		return pkg.SocketStats{}, err
+	}
+	defer netNS.Close()
+
+	var stats pkg.SocketStats
+	err = netNS.Do(func(_ ns.NetNS) error {
+		var err error
+		stats, err = sockstat.Read()
+		return err
+	})
+	if err != nil {
+		return stats, errors.Wrapf(err, "failed to read sockets of namespace %s", name)
+	}
+
+	stats.Namespace = name
+	return stats, nil
+}
@@ -0,0 +1,51 @@
+package nr
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestServiceAddress(t *testing.T) {
+	nr, err := New("net1", &pkg.NetResource{
+		NodeID: "node1",
+		Subnet: types.MustParseIPNet("10.1.1.0/24"),
+	}, nil)
+	require.NoError(t, err)
+
+	addr, err := nr.serviceAddress(net.ParseIP("10.1.1.10"))
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.1.10/24", addr.String())
+
+	ipv6 := plan.IPv6("net1", net.ParseIP("10.1.1.10"))
+	addr, err = nr.serviceAddress(ipv6)
+	require.NoError(t, err)
+	assert.Equal(t, ipv6.String()+"/64", addr.String())
+
+	for _, ip := range []string{
+		// other subnets
+		"10.1.2.10",
+		plan.IPv6("net1", net.ParseIP("10.1.2.10")).String(),
+		plan.IPv6("net2", net.ParseIP("10.1.1.10")).String(),
+		// the addresses of the network resource
+		"10.1.1.1",
+		"10.1.1.0",
+		"10.1.1.255",
+		plan.IPv6("net1", net.ParseIP("10.1.1.1")).String(),
+	} {
+		_, err := nr.serviceAddress(net.ParseIP(ip))
+		assert.Error(t, err, ip)
+	}
+}
+
+func TestNeighborState(t *testing.T) {
+	assert.Equal(t, "reachable", neighborState(netlink.NUD_REACHABLE))
+	assert.Equal(t, "stale", neighborState(netlink.NUD_STALE))
+	assert.Equal(t, "none", neighborState(netlink.NUD_NONE))
+}
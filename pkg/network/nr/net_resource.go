@@ -2,11 +2,11 @@ package nr
 
 import (
 	"bytes"
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"syscall"
 
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
 	"github.com/threefoldtech/zos/pkg/network/macvlan"
@@ -15,27 +15,51 @@ import (
 
 	mapset "github.com/deckarep/golang-set"
 
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/pkg/errors"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/network/bridge"
+	"github.com/threefoldtech/zos/pkg/network/kernel"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 	"github.com/threefoldtech/zos/pkg/network/namespace"
 	"github.com/threefoldtech/zos/pkg/network/nft"
+	"github.com/threefoldtech/zos/pkg/network/offload"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/prefix"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/threefoldtech/zos/pkg/network/wireguard"
 	"github.com/vishvananda/netlink"
 )
 
+const (
+	// DefaultRouteTable is the routing table where the overlay routes
+	// of a network resource are installed
+	DefaultRouteTable = 100
+
+	// rulePriority is the priority of the rules that send the traffic of
+	// the network resource namespace to the overlay routing table
+	rulePriority = 1000
+)
+
 // NetResource holds the logic to configure an network resource
 type NetResource struct {
 	id pkg.NetID
 	// local network resources
 	resource *pkg.NetResource
 	ipRange  *net.IPNet
+
+	kernel   kernel.Kernel
+	unseal   Unsealer
+	table    int
+	offloads offload.Config
 }
 
+// Unsealer decrypts a secret of the network resource sealed
+// with the node identity
+type Unsealer func(sealed string) (string, error)
+
 // New creates a new NetResource object
 func New(networkID pkg.NetID, netResource *pkg.NetResource, ipRange *net.IPNet) (*NetResource, error) {
 
@@ -43,6 +67,8 @@ func New(networkID pkg.NetID, netResource *pkg.NetResource, ipRange *net.IPNet)
 		id:       networkID,
 		resource: netResource,
 		ipRange:  ipRange,
+		kernel:   kernel.Netlink{},
+		table:    DefaultRouteTable,
 	}
 
 	return nr, nil
@@ -61,41 +87,42 @@ func (nr *NetResource) ID() string {
 	return string(nr.id)
 }
 
+// SetUnsealer sets the function used to decrypt the
+// preshared keys of the peers
+func (nr *NetResource) SetUnsealer(unseal Unsealer) {
+	nr.unseal = unseal
+}
+
+// SetRouteTable sets the routing table where the overlay routes are
+// installed. 0 means the main table, without any routing rule
+func (nr *NetResource) SetRouteTable(table int) {
+	nr.table = table
+}
+
+// isolated returns true if the overlay routes live in their own table
+func (nr *NetResource) isolated() bool {
+	return nr.table != 0 && nr.table != syscall.RT_TABLE_MAIN
+}
+
 // BridgeName returns the name of the bridge to create for the network
 // resource in the host network namespace
 func (nr *NetResource) BridgeName() (string, error) {
-	name := fmt.Sprintf("b-%s", nr.id)
-	if len(name) > 15 {
-		return "", errors.Errorf("bridge namespace too long %s", name)
-	}
-	return name, nil
+	return plan.BridgeName(nr.id)
 }
 
 // Namespace returns the name of the network namespace to create for the network resource
 func (nr *NetResource) Namespace() (string, error) {
-	name := fmt.Sprintf("n-%s", nr.id)
-	if len(name) > 15 {
-		return "", errors.Errorf("network namespace too long %s", name)
-	}
-	return name, nil
+	return plan.NamespaceName(nr.id)
 }
 
 // NRIface returns name of netresource local interface
 func (nr *NetResource) NRIface() (string, error) {
-	name := fmt.Sprintf("n-%s", nr.id)
-	if len(name) > 15 {
-		return "", errors.Errorf("NR interface name too long %s", name)
-	}
-	return name, nil
+	return plan.NamespaceName(nr.id)
 }
 
 // WGName returns the name of the wireguard interface to create for the network resource
 func (nr *NetResource) WGName() (string, error) {
-	wgName := fmt.Sprintf("w-%s", nr.id)
-	if len(wgName) > 15 {
-		return "", errors.Errorf("network namespace too long %s", wgName)
-	}
-	return wgName, nil
+	return plan.WGName(nr.id)
 }
 
 // Create setup the basic components of the network resource
@@ -115,24 +142,20 @@ func (nr *NetResource) Create(pubNS ns.NetNS) error {
 	if err := nr.createWireguard(pubNS); err != nil {
 		return err
 	}
+	if err := nr.applyWGOffloads(); err != nil {
+		return err
+	}
 	if err := nr.applyFirewall(); err != nil {
 		return err
 	}
+	// applyFirewall flushes the whole rule set
+	if err := nr.ApplyEgressPolicy(); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func wgIP(subnet *net.IPNet) *net.IPNet {
-	// example: 10.3.1.0 -> 10.255.3.1
-	a := subnet.IP[len(subnet.IP)-3]
-	b := subnet.IP[len(subnet.IP)-2]
-
-	return &net.IPNet{
-		IP:   net.IPv4(0x64, 0x40, a, b),
-		Mask: net.CIDRMask(16, 32),
-	}
-}
-
 // ConfigureWG sets the routes and IP addresses on the
 // wireguard interface of the network resources
 func (nr *NetResource) ConfigureWG(privateKey string) error {
@@ -155,72 +178,165 @@ func (nr *NetResource) ConfigureWG(privateKey string) error {
 		return fmt.Errorf("network namespace %s does not exits", nsName)
 	}
 
-	handler := func(_ ns.NetNS) error {
+	defer netNS.Close()
 
-		wgName, err := nr.WGName()
+	return netNS.Do(func(_ ns.NetNS) error {
+		return nr.configureWG(privateKey, wgPeers, routes)
+	})
+}
+
+// configureWG applies the wireguard configuration, the addresses and the routes
+// on the wireguard interface. It must be executed inside the network resource namespace
+func (nr *NetResource) configureWG(privateKey string, wgPeers []*wireguard.Peer, routes []netlink.Route) error {
+	wgName, err := nr.WGName()
+	if err != nil {
+		return err
+	}
+
+	wg, err := nr.kernel.LinkByName(wgName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get wireguard interface %s", wgName)
+	}
+	if wg.Type() != "wireguard" {
+		return fmt.Errorf("link %s is not of type wireguard", wgName)
+	}
+
+	config, err := wireguard.Config(privateKey, int(nr.resource.WGListenPort), wgPeers)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure wireguard interface")
+	}
+
+	if err := nr.kernel.LinkSetDown(wg); err != nil {
+		return err
+	}
+
+	if err := nr.kernel.ConfigureDevice(wgName, config); err != nil {
+		return errors.Wrap(err, "failed to configure wireguard interface")
+	}
+
+	if err := nr.kernel.LinkSetUp(wg); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "failed to bring wireguard interface %s up", wgName)
+	}
+
+	addrs, err := nr.kernel.AddrList(wg, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	curAddrs := mapset.NewSet()
+	for _, addr := range addrs {
+		curAddrs.Add(addr.IPNet.String())
+	}
+
+	newAddrs := mapset.NewSet()
+	newAddrs.Add(plan.WireGuardIP(&nr.resource.Subnet.IPNet).String())
+
+	toRemove := curAddrs.Difference(newAddrs)
+	toAdd := newAddrs.Difference(curAddrs)
+
+	log.Info().Msgf("current %s", curAddrs.String())
+	log.Info().Msgf("to add %s", toAdd.String())
+	log.Info().Msgf("to remove %s", toRemove.String())
+
+	for addr := range toAdd.Iter() {
+		addr, _ := addr.(string)
+		log.Debug().Str("ip", addr).Msg("set ip on wireguard interface")
+		nlAddr, err := netlink.ParseAddr(addr)
 		if err != nil {
 			return err
 		}
-
-		wg, err := wireguard.GetByName(wgName)
-		if err != nil {
-			return errors.Wrapf(err, "failed to get wireguard interface %s", wgName)
+		if err := nr.kernel.AddrAdd(wg, nlAddr); err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to set address %s on wireguard interface %s", addr, wgName)
 		}
+	}
 
-		if err = wg.Configure(privateKey, int(nr.resource.WGListenPort), wgPeers); err != nil {
-			return errors.Wrap(err, "failed to configure wireguard interface")
+	for addr := range toRemove.Iter() {
+		addr, _ := addr.(string)
+		log.Debug().Str("ip", addr).Msg("unset ip on wireguard interface")
+		// TODO: zaibon
+		// if err := wg.UsetAddr(addr); err != nil {
+		// 	return errors.Wrapf(err, "failed to set address %s on wireguard interface %s", addr, wg.Attrs().Name)
+		// }
+	}
+
+	for _, route := range routes {
+		route.LinkIndex = wg.Attrs().Index
+		route.Table = nr.table
+		if err := nr.kernel.RouteAdd(&route); err != nil && !os.IsExist(err) {
+			log.Error().
+				Err(err).
+				Str("route", route.String()).
+				Msg("fail to set route")
+			return errors.Wrapf(err, "failed to add route %s", route.String())
 		}
+	}
 
-		addrs, err := netlink.AddrList(wg, netlink.FAMILY_ALL)
-		if err != nil {
-			return err
+	for i := range nr.resource.Peers {
+		if !nr.resource.Peers[i].IsIPSec() {
+			continue
 		}
-		curAddrs := mapset.NewSet()
-		for _, addr := range addrs {
-			curAddrs.Add(addr.IPNet.String())
+		if err := nr.addIPSecPeer(&nr.resource.Peers[i]); err != nil {
+			return errors.Wrapf(err, "failed to configure ipsec peer %s", nr.resource.Peers[i].Subnet.String())
 		}
+	}
 
-		newAddrs := mapset.NewSet()
-		newAddrs.Add(wgIP(&nr.resource.Subnet.IPNet).String())
+	if !nr.isolated() {
+		return nil
+	}
 
-		toRemove := curAddrs.Difference(newAddrs)
-		toAdd := newAddrs.Difference(curAddrs)
+	// network resources configured before the routes were moved to their
+	// own table still have them in the main table
+	for _, route := range routes {
+		route.LinkIndex = wg.Attrs().Index
+		route.Table = syscall.RT_TABLE_MAIN
+		if err := nr.kernel.RouteDel(&route); err != nil && !isNotFound(err) {
+			log.Warn().Err(err).Str("route", route.String()).Msg("failed to delete route from main table")
+		}
+	}
 
-		log.Info().Msgf("current %s", curAddrs.String())
-		log.Info().Msgf("to add %s", toAdd.String())
-		log.Info().Msgf("to remove %s", toRemove.String())
+	return nr.ensureRules()
+}
 
-		for addr := range toAdd.Iter() {
-			addr, _ := addr.(string)
-			log.Debug().Str("ip", addr).Msg("set ip on wireguard interface")
-			if err := wg.SetAddr(addr); err != nil {
-				return errors.Wrapf(err, "failed to set address %s on wireguard interface %s", addr, wg.Attrs().Name)
-			}
-		}
+// ensureRules makes sure the traffic entering or generated inside the
+// network resource namespace looks up the overlay routing table first.
+// Destinations not found there fall back to the main table
+func (nr *NetResource) ensureRules() error {
+	nrIface, err := nr.NRIface()
+	if err != nil {
+		return err
+	}
+	wgName, err := nr.WGName()
+	if err != nil {
+		return err
+	}
 
-		for addr := range toRemove.Iter() {
-			addr, _ := addr.(string)
-			log.Debug().Str("ip", addr).Msg("unset ip on wireguard interface")
-			// TODO: zaibon
-			// if err := wg.UsetAddr(addr); err != nil {
-			// 	return errors.Wrapf(err, "failed to set address %s on wireguard interface %s", addr, wg.Attrs().Name)
-			// }
-		}
+	existing, err := nr.kernel.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		return errors.Wrap(err, "failed to list routing rules")
+	}
 
-		for _, route := range routes {
-			route.LinkIndex = wg.Attrs().Index
-			if err := netlink.RouteAdd(&route); err != nil && !os.IsExist(err) {
-				log.Error().
-					Err(err).
-					Str("route", route.String()).
-					Msg("fail to set route")
-				return errors.Wrapf(err, "failed to add route %s", route.String())
+	for _, iface := range []string{nrIface, wgName, "lo"} {
+		rule := netlink.NewRule()
+		rule.IifName = iface
+		rule.Table = nr.table
+		rule.Priority = rulePriority
+
+		found := false
+		for _, r := range existing {
+			if r.IifName == rule.IifName && r.Table == rule.Table && r.Priority == rule.Priority {
+				found = true
+				break
 			}
 		}
+		if found {
+			continue
+		}
 
-		return nil
+		if err := nr.kernel.RuleAdd(rule); err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to add routing rule for %s", iface)
+		}
 	}
-	return netNS.Do(handler)
+
+	return nil
 }
 
 // Delete removes all the interfaces and namespaces created by the Create method
@@ -261,53 +377,121 @@ func (nr *NetResource) Delete() error {
 	return nil
 }
 
-func isSubnet(n types.IPNet) bool {
-	ones, bits := n.IPNet.Mask.Size()
+func isSubnet(n net.IPNet) bool {
+	ones, bits := n.Mask.Size()
 	return ones < bits
 }
 
 func (nr *NetResource) routes() ([]netlink.Route, error) {
 	routes := make([]netlink.Route, 0)
 
+	owners := nr.owners()
 	peers := nr.resource.Peers
 	for i := range peers {
-		wgip := wgIP(&peers[i].Subnet.IPNet)
-		for j := range peers[i].AllowedIPs {
-			if !isSubnet(peers[i].AllowedIPs[j]) {
-				continue
-			}
-			routes = append(routes, netlink.Route{
-				Dst: &peers[i].AllowedIPs[j].IPNet,
-				Gw:  wgip.IP,
-			})
+		if peers[i].IsIPSec() {
+			// the ipsec policies select the traffic of the tunnel
+			continue
 		}
+		routes = append(routes, peerRoutes(&peers[i], nr.allowedIPs(i, owners))...)
 	}
 
 	return routes, nil
 }
 
-func (nr *NetResource) wgPeers() ([]*wireguard.Peer, error) {
+// peerRoutes returns the routes to the subnets of allowed through peer.
+// Contiguous subnets are summarized to keep the routing table small
+func peerRoutes(peer *pkg.Peer, allowed []net.IPNet) []netlink.Route {
+	var subnets []net.IPNet
+	for _, ip := range allowed {
+		if !isSubnet(ip) {
+			continue
+		}
+		subnets = append(subnets, ip)
+	}
 
-	wgPeers := make([]*wireguard.Peer, 0, len(nr.resource.Peers)+1)
+	wgip := plan.WireGuardIP(&peer.Subnet.IPNet)
 
-	for _, peer := range nr.resource.Peers {
+	var routes []netlink.Route
+	for _, subnet := range prefix.Aggregate(subnets) {
+		subnet := subnet
+		routes = append(routes, netlink.Route{
+			Dst:      &subnet,
+			Gw:       wgip.IP,
+			Priority: int(pkg.RouteMetric(peer, subnet)),
+		})
+	}
 
-		allowedIPs := make([]string, 0, len(peer.AllowedIPs))
-		for _, ip := range peer.AllowedIPs {
-			allowedIPs = append(allowedIPs, ip.String())
+	return routes
+}
+
+// forWGPeers calls fn with every wireguard peer of the network resource and
+// the prefixes routed through it. The ipsec peers are not part of the
+// wireguard configuration
+func (nr *NetResource) forWGPeers(fn func(peer *pkg.Peer, allowed []net.IPNet) error) error {
+	owners := nr.owners()
+	for i := range nr.resource.Peers {
+		peer := &nr.resource.Peers[i]
+		if peer.IsIPSec() {
+			continue
 		}
 
-		wgPeer := &wireguard.Peer{
-			PublicKey:  string(peer.WGPublicKey),
-			AllowedIPs: allowedIPs,
-			Endpoint:   peer.Endpoint,
+		if err := fn(peer, nr.allowedIPs(i, owners)); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+func (nr *NetResource) wgPeers() ([]*wireguard.Peer, error) {
+	wgPeers := make([]*wireguard.Peer, 0, len(nr.resource.Peers)+1)
 
+	err := nr.forWGPeers(func(peer *pkg.Peer, allowed []net.IPNet) error {
 		log.Info().Str("peer prefix", peer.Subnet.String()).Msg("generate wireguard configuration for peer")
+		wgPeer, err := nr.wgPeer(peer, allowed)
+		if err != nil {
+			return err
+		}
 		wgPeers = append(wgPeers, wgPeer)
+		return nil
+	})
+
+	return wgPeers, err
+}
+
+// newWGPeer returns the wireguard configuration of peer without its
+// preshared key, allowed are the prefixes routed through the peer
+func newWGPeer(peer *pkg.Peer, allowed []net.IPNet) *wireguard.Peer {
+	var allowedIPs []string
+	for _, ip := range prefix.Aggregate(allowed) {
+		allowedIPs = append(allowedIPs, ip.String())
 	}
 
-	return wgPeers, nil
+	return &wireguard.Peer{
+		PublicKey:  string(peer.WGPublicKey),
+		AllowedIPs: allowedIPs,
+		Endpoint:   peer.Endpoint,
+	}
+}
+
+// wgPeer returns the wireguard configuration of peer, allowed are the
+// prefixes routed through the peer
+func (nr *NetResource) wgPeer(peer *pkg.Peer, allowed []net.IPNet) (*wireguard.Peer, error) {
+	wgPeer := newWGPeer(peer, allowed)
+
+	if peer.WGPresharedKey != "" {
+		if nr.unseal == nil {
+			return nil, fmt.Errorf("cannot decrypt preshared key of peer %s", peer.Subnet.String())
+		}
+
+		psk, err := nr.unseal(peer.WGPresharedKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt preshared key of peer %s", peer.Subnet.String())
+		}
+		wgPeer.PresharedKey = psk
+	}
+
+	return wgPeer, nil
 }
 
 func (nr *NetResource) createNetNS() error {
@@ -327,16 +511,15 @@ func (nr *NetResource) createNetNS() error {
 		return err
 	}
 	defer netNS.Close()
-	err = netNS.Do(func(_ ns.NetNS) error {
-		if _, err := sysctl.Sysctl("net.ipv6.conf.all.forwarding", "1"); err != nil {
-			return err
-		}
-		if err := ifaceutil.SetLoUp(); err != nil {
-			return err
-		}
-		return nil
+
+	// the network resource routes between its workloads and the peers
+	if err := sysctl.Set(name, "net.ipv6.conf.all.forwarding", "1"); err != nil {
+		return err
+	}
+
+	return netNS.Do(func(_ ns.NetNS) error {
+		return ifaceutil.SetLoUp()
 	})
-	return err
 }
 
 // attachToNRBridge creates a macvlan interface in the NR namespace, and attaches
@@ -376,6 +559,10 @@ func (nr *NetResource) attachToNRBridge() error {
 			return err
 		}
 
+		if err := ifaceutil.SetIPv6Policy(nsName, nrIfaceName, ifaceutil.DefaultIPv6Policy); err != nil {
+			return err
+		}
+
 		ipnet := nr.resource.Subnet
 		ipnet.IP[len(ipnet.IP)-1] = 0x01
 		log.Info().Str("addr", ipnet.String()).Msg("set address on macvlan interface")
@@ -385,24 +572,20 @@ func (nr *NetResource) attachToNRBridge() error {
 			return err
 		}
 
-		ipv6 := convert4to6(nr.ID(), ipnet.IP)
-		addr = &netlink.Addr{IPNet: &net.IPNet{
-			IP:   ipv6,
-			Mask: net.CIDRMask(64, 128),
-		}}
+		addr = &netlink.Addr{IPNet: &plan.LinkLocalGateway}
 		if err = netlink.AddrAdd(link, addr); err != nil && !os.IsExist(err) {
 			return err
 		}
 
-		addr = &netlink.Addr{IPNet: &net.IPNet{
-			IP:   net.ParseIP("fe80::1"),
-			Mask: net.CIDRMask(64, 128),
-		}}
-		if err = netlink.AddrAdd(link, addr); err != nil && !os.IsExist(err) {
+		if err := netlink.LinkSetUp(link); err != nil {
 			return err
 		}
 
-		return netlink.LinkSetUp(link)
+		// a duplicate of the gateway on the bridge fails the network resource
+		return ifaceutil.AddIPv6(link, &net.IPNet{
+			IP:   plan.IPv6(nr.id, ipnet.IP),
+			Mask: net.CIDRMask(64, 128),
+		})
 	}
 	return netNS.Do(handler)
 }
@@ -426,7 +609,7 @@ func (nr *NetResource) createBridge() error {
 		return err
 	}
 
-	if _, err := sysctl.Sysctl(fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
+	if err := sysctl.Set(sysctl.Host, fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", name), "1"); err != nil {
 		return errors.Wrapf(err, "failed to disable ip6 on bridge %s", name)
 	}
 	return nil
@@ -453,13 +636,22 @@ func (nr *NetResource) createWireguard(pubNetNS ns.NetNS) error {
 	defer nrNetNS.Close()
 
 	exists := false
-	nrNetNS.Do(func(hostNS ns.NetNS) error {
-		_, err := netlink.LinkByName(wgName)
-		if err == nil {
-			exists = true
+	if err := nrNetNS.Do(func(hostNS ns.NetNS) error {
+		wg, err := netlink.LinkByName(wgName)
+		if err != nil {
+			return nil
+		}
+		exists = true
+
+		// the interface may exist from before the farm network used
+		// jumbo frames
+		if wg.Attrs().MTU != mtu.Wireguard() {
+			return netlink.LinkSetMTU(wg, mtu.Wireguard())
 		}
 		return nil
-	})
+	}); err != nil {
+		return errors.Wrapf(err, "failed to set mtu of %s", wgName)
+	}
 
 	// wireguard already exist, early exit
 	if exists {
@@ -503,14 +695,45 @@ func (nr *NetResource) createWireguard(pubNetNS ns.NetNS) error {
 	return netlink.LinkSetNsFd(wg, int(nrNetNS.Fd()))
 }
 
+func (nr *NetResource) firewallData() (fwData, error) {
+	var data fwData
+
+	if nr.resource.MSSClamping != pkg.MSSClampingOff {
+		wgName, err := nr.WGName()
+		if err != nil {
+			return data, err
+		}
+
+		data.ClampMSS = true
+		data.WGName = wgName
+	}
+
+	egress := nr.resource.Egress
+	if egress == nil {
+		return data, nil
+	}
+
+	data.Restricted = true
+	if ip := egress.IP(); ip != nil && nr.resource.Subnet.Contains(ip) {
+		data.ProxyIP = ip.String()
+	}
+
+	return data, nil
+}
+
 func (nr *NetResource) applyFirewall() error {
 	nsName, err := nr.Namespace()
 	if err != nil {
 		return err
 	}
 
+	data, err := nr.firewallData()
+	if err != nil {
+		return err
+	}
+
 	buf := bytes.Buffer{}
-	if err := fwTmpl.Execute(&buf, nil); err != nil {
+	if err := fwTmpl.Execute(&buf, data); err != nil {
 		return errors.Wrap(err, "failed to build nft rule set")
 	}
 
@@ -520,13 +743,3 @@ func (nr *NetResource) applyFirewall() error {
 
 	return nil
 }
-
-func convert4to6(netID string, ip net.IP) net.IP {
-	h := md5.New()
-	md5NetID := h.Sum([]byte(netID))
-
-	ipv6 := fmt.Sprintf("fd%x:%x%x:%x%x", md5NetID[0], md5NetID[1], md5NetID[2], md5NetID[3], md5NetID[4])
-	ipv6 = fmt.Sprintf("%s:%x::%x", ipv6, ip[14], ip[15])
-
-	return net.ParseIP(ipv6)
-}
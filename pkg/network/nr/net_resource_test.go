@@ -2,8 +2,6 @@ package nr
 
 import (
 	"fmt"
-	"net"
-	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,8 +18,8 @@ type testIdentityManager struct {
 var _ pkg.IdentityManager = (*testIdentityManager)(nil)
 
 // NodeID returns the node id (public key)
-func (t *testIdentityManager) NodeID() pkg.StrIdentifier {
-	return pkg.StrIdentifier(t.id)
+func (t *testIdentityManager) NodeID() (pkg.StrIdentifier, error) {
+	return pkg.StrIdentifier(t.id), nil
 }
 
 // FarmID return the farm id this node is part of. this is usually a configuration
@@ -110,72 +108,3 @@ func TestCreateBridge(t *testing.T) {
 	// cleanup
 	netlink.LinkDel(l)
 }
-
-func Test_wgIP(t *testing.T) {
-	type args struct {
-		subnet *net.IPNet
-	}
-	tests := []struct {
-		name string
-		args args
-		want *net.IPNet
-	}{
-		{
-			name: "default",
-			args: args{
-				subnet: &net.IPNet{
-					IP:   net.ParseIP("10.3.1.0"),
-					Mask: net.CIDRMask(16, 32),
-				},
-			},
-			want: &net.IPNet{
-				IP:   net.ParseIP("100.64.3.1"),
-				Mask: net.CIDRMask(16, 32),
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := wgIP(tt.args.subnet); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("wgIP() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_convert4to6(t *testing.T) {
-	type args struct {
-		netID string
-		ip    net.IP
-	}
-	tests := []struct {
-		name string
-		args args
-		want net.IP
-	}{
-		{
-			name: "valid",
-			args: args{
-				netID: "networkdID",
-				ip:    net.ParseIP("100.127.0.2"),
-			},
-			want: net.ParseIP("fd6e:6574:776f:0000::2"),
-		},
-		{
-			name: "valid",
-			args: args{
-				netID: "networkdID",
-				ip:    net.ParseIP("100.127.2.16"),
-			},
-			want: net.ParseIP("fd6e:6574:776f:0002::0010"),
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			require.NotNil(t, tt.want)
-			got := convert4to6(tt.args.netID, tt.args.ip)
-			require.NotNil(t, got)
-			assert.EqualValues(t, tt.want, got)
-		})
-	}
-}
@@ -4,10 +4,27 @@ import (
 	"text/template"
 )
 
-var fwTmpl *template.Template
+var (
+	fwTmpl     *template.Template
+	egressTmpl *template.Template
+)
+
+// fwData is the data of the firewall template
+type fwData struct {
+	// Restricted is true if the egress traffic must go through a proxy
+	Restricted bool
+	// ProxyIP is the IP of the proxy if it runs in this network resource
+	ProxyIP string
+	// ClampMSS is true if the MSS of the connections crossing the
+	// wireguard interface or leaving through the exit is clamped
+	ClampMSS bool
+	// WGName is the name of the wireguard interface
+	WGName string
+}
 
 func init() {
 	fwTmpl = template.Must(template.New("nrfw").Parse(_nft))
+	egressTmpl = template.Must(template.New("nregress").Parse(_egressNft))
 }
 
 var _nft = `
@@ -48,11 +65,21 @@ table inet filter {
 
   chain forward {
     type filter hook forward priority 0; policy accept;
+{{- if .ClampMSS}}
+        # the overlay packets are smaller than the frames of the workloads,
+        # clamp before the replies are accepted as established
+        oifname { "{{.WGName}}", "public" } tcp flags & (syn | rst) == syn tcp option maxseg size set rt mtu
+{{- end}}
         # is there already an existing stream? (outgoing)
         jump base_checks
         # if not, verify if it's new and coming in from the br4-gw network
         # if it is, drop it
         iifname "public" counter drop
+{{- if .Restricted}}
+        # egress restricted, only the network proxy can reach the outside world
+        oifname "public" meta nfproto ipv6 counter reject
+        oifname "public" {{if .ProxyIP}}ip saddr != {{.ProxyIP}} {{end}}counter reject
+{{- end}}
   }
 
   chain output {
@@ -60,3 +87,36 @@ table inet filter {
   }
 }
 `
+
+// egressData is the data of the egress policy template
+type egressData struct {
+	// Matches are the nft match expressions of the policy rules
+	Matches []string
+	// Verdict applied to the traffic matching a rule
+	Verdict string
+	// Allow is true if the traffic that doesn't match a rule is rejected
+	Allow bool
+}
+
+// the egress table is deleted and created again in the same transaction
+// so the policy can be updated atomically without touching the rest
+// of the ruleset. With no policy, the table is only deleted
+var _egressNft = `
+table inet egress {}
+delete table inet egress
+{{- if .}}
+
+table inet egress {
+  chain forward {
+    type filter hook forward priority 10; policy accept;
+    oifname != "public" accept
+{{- range .Matches}}
+    oifname "public" {{.}} {{$.Verdict}}
+{{- end}}
+{{- if .Allow}}
+    oifname "public" counter reject
+{{- end}}
+  }
+}
+{{- end}}
+`
@@ -0,0 +1,124 @@
+package nr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+func TestFirewallEgress(t *testing.T) {
+	resource := &pkg.NetResource{
+		Subnet: types.MustParseIPNet("10.1.1.0/24"),
+	}
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	render := func() string {
+		data, err := nr.firewallData()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, fwTmpl.Execute(&buf, data))
+		return buf.String()
+	}
+
+	assert.NotContains(t, render(), "reject")
+
+	// proxy running in another network resource
+	resource.Egress = &pkg.EgressProxy{Protocol: pkg.ProxySocks5, Address: "10.1.2.10:1080"}
+	rules := render()
+	assert.Contains(t, rules, "oifname \"public\" meta nfproto ipv6 counter reject")
+	assert.Contains(t, rules, "oifname \"public\" counter reject")
+	assert.NotContains(t, rules, "saddr")
+
+	// proxy running in this network resource
+	resource.Egress.Address = "10.1.1.10:1080"
+	assert.Contains(t, render(), "oifname \"public\" ip saddr != 10.1.1.10 counter reject")
+}
+
+func TestFirewallClampMSS(t *testing.T) {
+	resource := &pkg.NetResource{
+		Subnet: types.MustParseIPNet("10.1.1.0/24"),
+	}
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+
+	render := func() string {
+		data, err := nr.firewallData()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, fwTmpl.Execute(&buf, data))
+		return buf.String()
+	}
+
+	rule := `oifname { "` + wgName + `", "public" } tcp flags & (syn | rst) == syn tcp option maxseg size set rt mtu`
+	assert.Contains(t, render(), rule)
+
+	resource.MSSClamping = pkg.MSSClampingAuto
+	assert.Contains(t, render(), rule)
+
+	resource.MSSClamping = pkg.MSSClampingOff
+	assert.NotContains(t, render(), "maxseg")
+}
+
+func TestEgressPolicy(t *testing.T) {
+	render := func(policy *pkg.EgressPolicy) string {
+		data, err := egressRules(policy)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, egressTmpl.Execute(&buf, data))
+		return buf.String()
+	}
+
+	rules := render(nil)
+	assert.Contains(t, rules, "delete table inet egress")
+	assert.NotContains(t, rules, "chain forward")
+
+	rules = render(&pkg.EgressPolicy{
+		Mode: pkg.EgressAllow,
+		Rules: []pkg.EgressRule{
+			{Dst: types.MustParseIPNet("1.1.1.1/32"), Protocol: "udp", Ports: []uint16{53}},
+			{Dst: types.MustParseIPNet("10.20.30.40/16"), Protocol: "tcp", Ports: []uint16{80, 443}},
+			{Dst: types.MustParseIPNet("2001:db8::/32")},
+		},
+	})
+	assert.Contains(t, rules, `oifname "public" ip daddr 1.1.1.1/32 udp dport { 53 } accept`)
+	assert.Contains(t, rules, `oifname "public" ip daddr 10.20.0.0/16 tcp dport { 80, 443 } accept`)
+	assert.Contains(t, rules, `oifname "public" ip6 daddr 2001:db8::/32 accept`)
+	assert.Contains(t, rules, `oifname "public" counter reject`)
+
+	rules = render(&pkg.EgressPolicy{
+		Mode: pkg.EgressDeny,
+		Rules: []pkg.EgressRule{
+			{Dst: types.MustParseIPNet("192.168.0.0/16"), Protocol: "tcp"},
+		},
+	})
+	assert.Contains(t, rules, `oifname "public" ip daddr 192.168.0.0/16 meta l4proto tcp counter reject`)
+	assert.NotContains(t, rules, `oifname "public" counter reject`)
+}
+
+func TestEgressPolicyInvalid(t *testing.T) {
+	_, err := egressRules(&pkg.EgressPolicy{Mode: "block"})
+	assert.Error(t, err)
+
+	_, err = egressRules(&pkg.EgressPolicy{
+		Mode:  pkg.EgressDeny,
+		Rules: []pkg.EgressRule{{Dst: types.MustParseIPNet("10.0.0.0/8"), Ports: []uint16{22}}},
+	})
+	assert.Error(t, err)
+
+	_, err = egressRules(&pkg.EgressPolicy{
+		Mode:  pkg.EgressDeny,
+		Rules: []pkg.EgressRule{{Dst: types.MustParseIPNet("10.0.0.0/8"), Protocol: "icmp"}},
+	})
+	assert.Error(t, err)
+}
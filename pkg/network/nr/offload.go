@@ -0,0 +1,110 @@
+package nr
+
+import (
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/offload"
+	"github.com/vishvananda/netlink"
+)
+
+// SetOffloads sets the offload policy of the wireguard interface and of
+// the veths of the workloads, the interfaces keep the kernel defaults
+// if it's not set
+func (nr *NetResource) SetOffloads(config offload.Config) {
+	nr.offloads = config
+}
+
+// applyWGOffloads applies the offload policy on the wireguard interface,
+// an existing interface is updated
+func (nr *NetResource) applyWGOffloads() error {
+	wgName, err := nr.WGName()
+	if err != nil {
+		return err
+	}
+
+	return nr.inNamespace(func() error {
+		return offload.Apply(wgName, nr.offloads.Wireguard)
+	})
+}
+
+func linkOffloads(namespace, name string) (pkg.LinkOffloads, error) {
+	policy, err := offload.Get(name)
+	if err != nil {
+		return pkg.LinkOffloads{}, err
+	}
+
+	features := make(map[string]bool, len(policy))
+	for feature, on := range policy {
+		features[string(feature)] = on
+	}
+
+	return pkg.LinkOffloads{
+		Namespace: namespace,
+		Link:      name,
+		Features:  features,
+	}, nil
+}
+
+// Offloads returns the state of the offload features of the wireguard
+// interface of the network resource, and of both ends of the veths of its
+// workloads
+func (nr *NetResource) Offloads() ([]pkg.LinkOffloads, error) {
+	nsName, err := nr.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	wgName, err := nr.WGName()
+	if err != nil {
+		return nil, err
+	}
+
+	var report []pkg.LinkOffloads
+	err = nr.inNamespace(func() error {
+		wg, err := linkOffloads(nsName, wgName)
+		if err != nil {
+			return err
+		}
+		report = append(report, wg)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get offloads of wireguard interface")
+	}
+
+	members, err := nr.Members()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		var peer int
+		err := withMemberLink(member, func(link netlink.Link) error {
+			peer = link.Attrs().ParentIndex
+
+			offloads, err := linkOffloads(member, link.Attrs().Name)
+			if err != nil {
+				return err
+			}
+			report = append(report, offloads)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get offloads of workload %s", member)
+		}
+
+		// the host end of the veth
+		hostLink, err := netlink.LinkByIndex(peer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get host veth of workload %s", member)
+		}
+
+		offloads, err := linkOffloads("", hostLink.Attrs().Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get offloads of host veth of workload %s", member)
+		}
+		report = append(report, offloads)
+	}
+
+	return report, nil
+}
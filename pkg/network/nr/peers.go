@@ -0,0 +1,288 @@
+package nr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/network/wireguard"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// AddPeer adds a peer to the network resource, or updates it if a peer
+// with the same subnet already exists. Only the wireguard configuration
+// and the routes of this peer are changed, the other peers are untouched
+func (nr *NetResource) AddPeer(peer pkg.Peer) error {
+	return nr.inNamespace(func() error {
+		return nr.addPeer(peer)
+	})
+}
+
+// RemovePeer removes the peer with subnet prefix from the network
+// resource, together with its routes
+func (nr *NetResource) RemovePeer(prefix types.IPNet) error {
+	return nr.inNamespace(func() error {
+		return nr.removePeer(prefix)
+	})
+}
+
+func (nr *NetResource) inNamespace(fn func() error) error {
+	nsName, err := nr.Namespace()
+	if err != nil {
+		return err
+	}
+	netNS, err := namespace.GetByName(nsName)
+	if err != nil {
+		return fmt.Errorf("network namespace %s does not exits", nsName)
+	}
+	defer netNS.Close()
+
+	return netNS.Do(func(_ ns.NetNS) error {
+		return fn()
+	})
+}
+
+func (nr *NetResource) peerIndex(prefix types.IPNet) int {
+	for i, peer := range nr.resource.Peers {
+		if peer.Subnet.String() == prefix.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (nr *NetResource) wgLink() (string, netlink.Link, error) {
+	wgName, err := nr.WGName()
+	if err != nil {
+		return "", nil, err
+	}
+
+	link, err := nr.kernel.LinkByName(wgName)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to get wireguard interface %s", wgName)
+	}
+	if link.Type() != "wireguard" {
+		return "", nil, fmt.Errorf("link %s is not of type wireguard", wgName)
+	}
+
+	return wgName, link, nil
+}
+
+func (nr *NetResource) addPeer(peer pkg.Peer) error {
+	index := nr.peerIndex(peer.Subnet)
+	if index >= 0 && (peer.IsIPSec() || nr.resource.Peers[index].IsIPSec()) {
+		// tear down the previous connection first, the tunnel
+		// or the connection type changed
+		if err := nr.removePeer(peer.Subnet); err != nil {
+			return err
+		}
+	}
+
+	if peer.IsIPSec() {
+		if err := nr.addIPSecPeer(&peer); err != nil {
+			return err
+		}
+		nr.setPeer(peer)
+		return nil
+	}
+
+	var removed []wgtypes.PeerConfig
+	if index := nr.peerIndex(peer.Subnet); index >= 0 {
+		old := nr.resource.Peers[index]
+		if old.WGPublicKey != peer.WGPublicKey {
+			key, err := wgtypes.ParseKey(old.WGPublicKey)
+			if err != nil {
+				return errors.Wrap(err, "invalid public key of existing peer")
+			}
+			removed = append(removed, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+		}
+	}
+
+	err := nr.updatePeers(removed, func() {
+		nr.setPeer(peer)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to configure peer %s", peer.Subnet.String())
+	}
+
+	log.Info().Str("peer", peer.Subnet.String()).Msg("peer configured")
+	return nil
+}
+
+// updatePeers applies change on the peers of the network resource, then
+// configures the wireguard peers and the routes changed by it. A peer
+// other than the one changed can gain or lose the prefixes it shares with
+// it. removed are the wireguard peers to remove first. The peers are left
+// untouched if the wireguard interface can't be configured
+func (nr *NetResource) updatePeers(removed []wgtypes.PeerConfig, change func()) error {
+	wgName, link, err := nr.wgLink()
+	if err != nil {
+		return err
+	}
+
+	saved := append([]pkg.Peer(nil), nr.resource.Peers...)
+	before, err := nr.routes()
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string]pkg.Peer)
+	allowedBefore := make(map[string][]net.IPNet)
+	owners := nr.owners()
+	for i, peer := range saved {
+		previous[peer.Subnet.String()] = peer
+		allowedBefore[peer.Subnet.String()] = nr.allowedIPs(i, owners)
+	}
+
+	change()
+
+	after, err := nr.routes()
+	if err != nil {
+		nr.resource.Peers = saved
+		return err
+	}
+
+	peers := removed
+	owners = nr.owners()
+	for i := range nr.resource.Peers {
+		peer := &nr.resource.Peers[i]
+		if peer.IsIPSec() {
+			continue
+		}
+
+		allowed := nr.allowedIPs(i, owners)
+		old, ok := previous[peer.Subnet.String()]
+		if ok && reflect.DeepEqual(old, *peer) && samePrefixes(allowedBefore[peer.Subnet.String()], allowed) {
+			continue
+		}
+
+		wgPeer, err := nr.wgPeer(peer, allowed)
+		if err != nil {
+			nr.resource.Peers = saved
+			return err
+		}
+
+		config, err := wireguard.PeerConfig(wgPeer)
+		if err != nil {
+			nr.resource.Peers = saved
+			return errors.Wrap(err, "invalid peer configuration")
+		}
+
+		if ok && old.WGPresharedKey != "" && peer.WGPresharedKey == "" {
+			// a zero key removes the preshared key of the peer
+			config.PresharedKey = &wgtypes.Key{}
+		}
+
+		peers = append(peers, config)
+	}
+
+	if err := nr.kernel.ConfigureDevice(wgName, wgtypes.Config{Peers: peers}); err != nil {
+		nr.resource.Peers = saved
+		return err
+	}
+
+	for _, route := range routesDiff(before, after) {
+		route.LinkIndex = link.Attrs().Index
+		route.Table = nr.table
+		if err := nr.kernel.RouteDel(&route); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "failed to delete route %s", route.String())
+		}
+	}
+
+	for _, route := range routesDiff(after, before) {
+		route.LinkIndex = link.Attrs().Index
+		route.Table = nr.table
+		if err := nr.kernel.RouteAdd(&route); err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to add route %s", route.String())
+		}
+	}
+
+	return nr.withdraw(withdrawn(before, after))
+}
+
+func samePrefixes(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if prefixKey(a[i]) != prefixKey(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setPeer adds peer to the network resource or replaces
+// the peer with the same subnet
+func (nr *NetResource) setPeer(peer pkg.Peer) {
+	if index := nr.peerIndex(peer.Subnet); index >= 0 {
+		nr.resource.Peers[index] = peer
+	} else {
+		nr.resource.Peers = append(nr.resource.Peers, peer)
+	}
+}
+
+func (nr *NetResource) removePeer(prefix types.IPNet) error {
+	index := nr.peerIndex(prefix)
+	if index < 0 {
+		return fmt.Errorf("no peer with subnet %s", prefix.String())
+	}
+	peer := nr.resource.Peers[index]
+
+	if peer.IsIPSec() {
+		if err := nr.removeIPSecPeer(&peer); err != nil {
+			return err
+		}
+		nr.resource.Peers = append(nr.resource.Peers[:index], nr.resource.Peers[index+1:]...)
+		return nil
+	}
+
+	key, err := wgtypes.ParseKey(peer.WGPublicKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid peer public key")
+	}
+
+	removed := []wgtypes.PeerConfig{{PublicKey: key, Remove: true}}
+	err = nr.updatePeers(removed, func() {
+		nr.resource.Peers = append(nr.resource.Peers[:index], nr.resource.Peers[index+1:]...)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove peer %s", prefix.String())
+	}
+
+	log.Info().Str("peer", prefix.String()).Msg("peer removed")
+	return nil
+}
+
+// routesDiff returns the routes of a that are not in b
+func routesDiff(a, b []netlink.Route) []netlink.Route {
+	var diff []netlink.Route
+	for _, ra := range a {
+		found := false
+		for _, rb := range b {
+			if ra.Dst.String() == rb.Dst.String() && ra.Gw.Equal(rb.Gw) && ra.Priority == rb.Priority {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, ra)
+		}
+	}
+	return diff
+}
+
+func isNotFound(err error) bool {
+	return os.IsNotExist(err) || err == syscall.ESRCH
+}
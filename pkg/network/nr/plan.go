@@ -0,0 +1,138 @@
+package nr
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/plan"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+// Plan returns the interfaces, addresses, wireguard peers and routes
+// that Create, ConfigureWG and Withdraw configure for the network resource.
+// previous is the deployed network resource the update replaces, nil if
+// there is none. It doesn't touch the system
+func (nr *NetResource) Plan(previous *pkg.NetResource) (pkg.NetResourcePlan, error) {
+	result := pkg.NetResourcePlan{NetID: nr.id}
+
+	nsName, err := nr.Namespace()
+	if err != nil {
+		return result, err
+	}
+	brName, err := nr.BridgeName()
+	if err != nil {
+		return result, err
+	}
+	nrIface, err := nr.NRIface()
+	if err != nil {
+		return result, err
+	}
+	wgName, err := nr.WGName()
+	if err != nil {
+		return result, err
+	}
+
+	// same addresses as the one set by attachToNRBridge
+	gw := plan.Gateway(nr.resource.Subnet.IPNet)
+
+	result.Interfaces = []pkg.PlannedInterface{
+		{Name: nsName, Kind: "namespace"},
+		{Name: brName, Kind: "bridge"},
+		{
+			Name:      nrIface,
+			Kind:      "macvlan",
+			Namespace: nsName,
+			Addresses: []types.IPNet{
+				types.NewIPNet(&net.IPNet{IP: gw, Mask: nr.resource.Subnet.Mask}),
+				types.NewIPNet(&net.IPNet{IP: plan.IPv6(nr.id, gw), Mask: net.CIDRMask(64, 128)}),
+				types.NewIPNet(&plan.LinkLocalGateway),
+			},
+		},
+		{
+			Name:      wgName,
+			Kind:      "wireguard",
+			Namespace: nsName,
+			Addresses: []types.IPNet{
+				types.NewIPNet(plan.WireGuardIP(&nr.resource.Subnet.IPNet)),
+			},
+		},
+	}
+
+	err = nr.forWGPeers(func(peer *pkg.Peer, allowed []net.IPNet) error {
+		// same allowed ips as the ones ConfigureWG sets
+		wgPeer := newWGPeer(peer, allowed)
+
+		planned := pkg.PlannedPeer{
+			PublicKey:    peer.WGPublicKey,
+			Endpoint:     peer.Endpoint,
+			PresharedKey: peer.WGPresharedKey != "",
+			ConnType:     peer.ConnType,
+		}
+		for _, ip := range wgPeer.AllowedIPs {
+			allowedIP, err := types.ParseIPNet(ip)
+			if err != nil {
+				return err
+			}
+			planned.AllowedIPs = append(planned.AllowedIPs, allowedIP)
+		}
+
+		result.Peers = append(result.Peers, planned)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	routes, err := nr.routes()
+	if err != nil {
+		return result, err
+	}
+
+	for _, route := range routes {
+		result.Routes = append(result.Routes, pkg.PlannedRoute{
+			Iface:   wgName,
+			Dst:     types.NewIPNet(route.Dst),
+			Gateway: route.Gw,
+			Table:   nr.table,
+			Metric:  uint32(route.Priority),
+		})
+	}
+
+	if previous == nil {
+		return result, nil
+	}
+
+	// same routes as the ones Withdraw installs
+	typ := nr.withdrawType()
+	if typ == syscall.RTN_UNSPEC {
+		return result, nil
+	}
+
+	old := &NetResource{resource: previous, table: nr.table}
+	before, err := old.routes()
+	if err != nil {
+		return result, err
+	}
+
+	for _, dst := range withdrawn(before, routes) {
+		dst := dst
+		result.Routes = append(result.Routes, pkg.PlannedRoute{
+			Dst:    types.NewIPNet(&dst),
+			Table:  nr.table,
+			Metric: WithdrawnRouteMetric,
+			Type:   routeType(typ),
+		})
+	}
+
+	return result, nil
+}
+
+// routeType is the name of the type of a withdrawal route
+func routeType(typ int) string {
+	if typ == syscall.RTN_UNREACHABLE {
+		return "unreachable"
+	}
+
+	return "blackhole"
+}
@@ -0,0 +1,90 @@
+package nr
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/vishvananda/netlink"
+)
+
+// owners returns the index of the peer routing each prefix allowed for the
+// wireguard peers of the network resource. A prefix allowed for several
+// peers is routed by one of them only, following pkg.RouteMetric, since
+// wireguard sends the traffic of a prefix to a single peer anyway
+func (nr *NetResource) owners() map[string]int {
+	peers := nr.resource.Peers
+	owners := make(map[string]int)
+
+	for i := range peers {
+		if peers[i].IsIPSec() {
+			continue
+		}
+
+		for _, allowed := range peers[i].AllowedIPs {
+			key := prefixKey(allowed.IPNet)
+			owner, ok := owners[key]
+			if !ok || precedes(&peers[i], &peers[owner], allowed.IPNet) {
+				owners[key] = i
+			}
+		}
+	}
+
+	return owners
+}
+
+// allowedIPs returns the prefixes of the peer at index i routed through it
+func (nr *NetResource) allowedIPs(i int, owners map[string]int) []net.IPNet {
+	var allowed []net.IPNet
+	for _, ip := range nr.resource.Peers[i].AllowedIPs {
+		if owner, ok := owners[prefixKey(ip.IPNet)]; ok && owner == i {
+			allowed = append(allowed, ip.IPNet)
+		}
+	}
+
+	return allowed
+}
+
+// precedes returns true if the route of a to dst wins over the one of b
+func precedes(a, b *pkg.Peer, dst net.IPNet) bool {
+	if ma, mb := pkg.RouteMetric(a, dst), pkg.RouteMetric(b, dst); ma != mb {
+		return ma < mb
+	}
+
+	return bytes.Compare(a.Subnet.IP.To16(), b.Subnet.IP.To16()) < 0
+}
+
+// prefixKey identifies a prefix whatever the host bits of its address
+func prefixKey(n net.IPNet) string {
+	masked := net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}
+	return masked.String()
+}
+
+// Inside returns the addresses of ips the network resource routes inside
+// the network (to itself, its workloads or its peers) instead of out of its
+// public interface. Different networks can use the same ranges, such an
+// address of another network is never reached from this one
+func (nr *NetResource) Inside(ips []net.IP) ([]net.IP, error) {
+	var inside []net.IP
+	err := nr.inNamespace(func() error {
+		public, err := netlink.LinkByName(pubIface)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get interface %s", pubIface)
+		}
+
+		for _, ip := range ips {
+			routes, err := netlink.RouteGet(ip)
+			if err != nil || len(routes) == 0 {
+				// not routed at all
+				continue
+			}
+			if routes[0].LinkIndex != public.Attrs().Index {
+				inside = append(inside, ip)
+			}
+		}
+		return nil
+	})
+
+	return inside, err
+}
@@ -0,0 +1,70 @@
+package nr
+
+import (
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeersStatus reports the connection state of the peers of the network
+// resource. IPSec peers only have their endpoint reported
+func (nr *NetResource) PeersStatus() ([]pkg.PeerStatus, error) {
+	var status []pkg.PeerStatus
+	err := nr.inNamespace(func() error {
+		var err error
+		status, err = nr.peersStatus()
+		return err
+	})
+
+	return status, err
+}
+
+func (nr *NetResource) peersStatus() ([]pkg.PeerStatus, error) {
+	wgName, _, err := nr.wgLink()
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := nr.kernel.Device(wgName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get wireguard device %s", wgName)
+	}
+
+	current := make(map[wgtypes.Key]*wgtypes.Peer)
+	for i := range device.Peers {
+		current[device.Peers[i].PublicKey] = &device.Peers[i]
+	}
+
+	status := make([]pkg.PeerStatus, 0, len(nr.resource.Peers))
+	for _, peer := range nr.resource.Peers {
+		s := pkg.PeerStatus{
+			Subnet:   peer.Subnet,
+			ConnType: pkg.ConnTypeWireguard,
+			Endpoint: peer.Endpoint,
+		}
+
+		if peer.IsIPSec() {
+			s.ConnType = pkg.ConnTypeIPSec
+			status = append(status, s)
+			continue
+		}
+
+		key, err := wgtypes.ParseKey(peer.WGPublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid public key of peer %s", peer.Subnet.String())
+		}
+
+		if state, ok := current[key]; ok {
+			if state.Endpoint != nil {
+				s.Endpoint = state.Endpoint.String()
+			}
+			s.LastHandshake = state.LastHandshakeTime
+			s.RxBytes = state.ReceiveBytes
+			s.TxBytes = state.TransmitBytes
+		}
+
+		status = append(status, s)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,868 @@
+package nr
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/kernel"
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func testResource(t *testing.T) (*pkg.NetResource, wgtypes.Key, wgtypes.Key) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	return &pkg.NetResource{
+		NodeID:       "node1",
+		Subnet:       types.MustParseIPNet("10.1.1.0/24"),
+		WGPrivateKey: key.String(),
+		WGPublicKey:  key.PublicKey().String(),
+		WGListenPort: 6000,
+		Peers: []pkg.Peer{
+			{
+				Subnet:      types.MustParseIPNet("10.1.2.0/24"),
+				WGPublicKey: peerKey.PublicKey().String(),
+				AllowedIPs: []types.IPNet{
+					types.MustParseIPNet("10.1.2.0/24"),
+					types.MustParseIPNet("100.64.1.2/32"),
+				},
+				Endpoint: "37.187.124.71:6000",
+			},
+		},
+	}, key, peerKey
+}
+
+func TestRoutes(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	routes, err := nr.routes()
+	require.NoError(t, err)
+
+	// host allowed IPs are reachable through the wireguard
+	// interface directly, no route is needed for them
+	require.Len(t, routes, 1)
+	assert.Equal(t, "10.1.2.0/24", routes[0].Dst.String())
+	assert.Equal(t, "100.64.1.2", routes[0].Gw.String())
+}
+
+func TestRoutesPrecedence(t *testing.T) {
+	resource, _, _ := testResource(t)
+	exitKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// both peers route 10.1.9.0/24, the exit peer also routes
+	// the default destination
+	resource.Peers[0].AllowedIPs = append(resource.Peers[0].AllowedIPs, types.MustParseIPNet("10.1.9.0/24"))
+	resource.Peers = append(resource.Peers, pkg.Peer{
+		Subnet:      types.MustParseIPNet("10.1.0.0/24"),
+		WGPublicKey: exitKey.PublicKey().String(),
+		AllowedIPs: []types.IPNet{
+			types.MustParseIPNet("10.1.0.0/24"),
+			types.MustParseIPNet("10.1.9.0/24"),
+			types.MustParseIPNet("0.0.0.0/0"),
+		},
+	})
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	routesOf := func() map[string]string {
+		routes, err := nr.routes()
+		require.NoError(t, err)
+
+		gws := make(map[string]string)
+		for _, route := range routes {
+			_, exists := gws[route.Dst.String()]
+			require.False(t, exists, "duplicated route to %s", route.Dst.String())
+			gws[route.Dst.String()] = fmt.Sprintf("%s %d", route.Gw, route.Priority)
+		}
+		return gws
+	}
+
+	// same metric, the lowest subnet wins. The routes of the exit
+	// peer are summarized by its default route
+	assert.Equal(t, map[string]string{
+		"10.1.2.0/24": "100.64.1.2 100",
+		"0.0.0.0/0":   "100.64.1.0 1000",
+	}, routesOf())
+
+	// the lowest metric wins
+	resource.Peers[0].Metric = 50
+	assert.Equal(t, map[string]string{
+		"10.1.2.0/24": "100.64.1.2 50",
+		"10.1.9.0/24": "100.64.1.2 50",
+		"0.0.0.0/0":   "100.64.1.0 1000",
+	}, routesOf())
+
+	// the prefixes are only allowed on the peer routing them
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.Len(t, peers, 2)
+	assert.ElementsMatch(t, []string{"10.1.2.0/24", "10.1.9.0/24", "100.64.1.2/32"}, peers[0].AllowedIPs)
+	assert.ElementsMatch(t, []string{"0.0.0.0/0"}, peers[1].AllowedIPs)
+}
+
+func TestAddPeerPrecedence(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	newKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// the new peer takes over the subnet of the existing one
+	peer := pkg.Peer{
+		Subnet:      types.MustParseIPNet("10.1.3.0/24"),
+		WGPublicKey: newKey.PublicKey().String(),
+		Metric:      10,
+		AllowedIPs: []types.IPNet{
+			types.MustParseIPNet("10.1.2.0/24"),
+			types.MustParseIPNet("100.64.1.3/32"),
+		},
+	}
+	require.NoError(t, nr.addPeer(peer))
+
+	routes = k.Routes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "10.1.2.0/24", routes[0].Dst.String())
+	assert.Equal(t, "100.64.1.3", routes[0].Gw.String())
+	assert.Equal(t, 10, routes[0].Priority)
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 2)
+	assert.Len(t, device.Peers[0].AllowedIPs, 1)
+	assert.Len(t, device.Peers[1].AllowedIPs, 2)
+
+	// the existing peer routes its subnet again once the new one is gone
+	require.NoError(t, nr.removePeer(peer.Subnet))
+
+	routes = k.Routes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "100.64.1.2", routes[0].Gw.String())
+	assert.Equal(t, 100, routes[0].Priority)
+
+	device, err = k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 1)
+	assert.Len(t, device.Peers[0].AllowedIPs, 2)
+}
+
+func TestWGPeers(t *testing.T) {
+	resource, _, peerKey := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+
+	assert.Equal(t, peerKey.PublicKey().String(), peers[0].PublicKey)
+	assert.Equal(t, "37.187.124.71:6000", peers[0].Endpoint)
+	assert.Equal(t, []string{"10.1.2.0/24", "100.64.1.2/32"}, peers[0].AllowedIPs)
+}
+
+func TestConfigureWG(t *testing.T) {
+	resource, key, peerKey := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	link := k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+	assert.True(t, k.IsUp(wgName))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	assert.Equal(t, key, device.PrivateKey)
+	assert.Equal(t, 6000, device.ListenPort)
+	require.Len(t, device.Peers, 1)
+	assert.Equal(t, peerKey.PublicKey(), device.Peers[0].PublicKey)
+	assert.Equal(t, "37.187.124.71:6000", device.Peers[0].Endpoint.String())
+	assert.Len(t, device.Peers[0].AllowedIPs, 2)
+
+	addrs, err := k.AddrList(link, netlink.FAMILY_ALL)
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	assert.Equal(t, "100.64.1.1/16", addrs[0].IPNet.String())
+
+	kRoutes := k.Routes()
+	require.Len(t, kRoutes, 1)
+	assert.Equal(t, link.Attrs().Index, kRoutes[0].LinkIndex)
+	assert.Equal(t, "10.1.2.0/24", kRoutes[0].Dst.String())
+
+	// applying the same configuration again is a no-op
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+	addrs, err = k.AddrList(link, netlink.FAMILY_ALL)
+	require.NoError(t, err)
+	assert.Len(t, addrs, 1)
+	assert.Len(t, k.Routes(), 1)
+}
+
+func TestConfigureWGErrors(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	// wireguard interface doesn't exist
+	err = nr.configureWG(resource.WGPrivateKey, nil, nil)
+	assert.Error(t, err)
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+
+	// link is not a wireguard interface
+	k.AddLink(wgName, "dummy")
+	err = nr.configureWG(resource.WGPrivateKey, nil, nil)
+	assert.Error(t, err)
+
+	k = kernel.NewFake()
+	nr.kernel = k
+	k.AddLink(wgName, "wireguard")
+
+	// invalid private key
+	err = nr.configureWG("invalid", nil, nil)
+	assert.Error(t, err)
+	assert.False(t, k.IsUp(wgName))
+}
+
+func TestPlan(t *testing.T) {
+	resource, _, peerKey := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	result, err := nr.Plan(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, pkg.NetID("net1"), result.NetID)
+	require.Len(t, result.Interfaces, 4)
+
+	names := make(map[string]pkg.PlannedInterface)
+	for _, iface := range result.Interfaces {
+		names[iface.Kind] = iface
+	}
+
+	assert.Equal(t, "net-net1", names["namespace"].Name)
+	assert.Equal(t, "br-net1", names["bridge"].Name)
+
+	macvlan := names["macvlan"]
+	assert.Equal(t, "net-net1", macvlan.Namespace)
+	require.Len(t, macvlan.Addresses, 3)
+	assert.Equal(t, "10.1.1.1/24", macvlan.Addresses[0].String())
+	assert.Equal(t, "fe80::1/64", macvlan.Addresses[2].String())
+
+	wg := names["wireguard"]
+	assert.Equal(t, "wg-net1", wg.Name)
+	require.Len(t, wg.Addresses, 1)
+	assert.Equal(t, "100.64.1.1/16", wg.Addresses[0].String())
+
+	require.Len(t, result.Peers, 1)
+	assert.Equal(t, peerKey.PublicKey().String(), result.Peers[0].PublicKey)
+	require.Len(t, result.Peers[0].AllowedIPs, 2)
+
+	require.Len(t, result.Routes, 1)
+	assert.Equal(t, "wg-net1", result.Routes[0].Iface)
+	assert.Equal(t, "10.1.2.0/24", result.Routes[0].Dst.String())
+	assert.Equal(t, "100.64.1.2", result.Routes[0].Gateway.String())
+
+	// planning doesn't modify the resource
+	assert.Equal(t, "10.1.1.0/24", resource.Subnet.String())
+}
+
+// plannedRoute formats a route of a plan or of the kernel the same way
+func plannedRoute(dst, gw string, table int, metric uint32, typ string) string {
+	return fmt.Sprintf("%s via %s table %d metric %d %s", dst, gw, table, metric, typ)
+}
+
+func TestPlanApplied(t *testing.T) {
+	resource, _, _ := testResource(t)
+	resource.Peers[0].AllowedIPs = append(resource.Peers[0].AllowedIPs,
+		types.MustParseIPNet("10.1.3.0/24"),
+		types.MustParseIPNet("10.1.6.0/24"),
+	)
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	resource.Peers = append(resource.Peers,
+		pkg.Peer{
+			// routes 10.1.6.0/24 too, the first peer wins
+			Subnet:      types.MustParseIPNet("10.1.7.0/24"),
+			WGPublicKey: key.PublicKey().String(),
+			AllowedIPs: []types.IPNet{
+				types.MustParseIPNet("10.1.7.0/24"),
+				types.MustParseIPNet("100.64.1.7/32"),
+				types.MustParseIPNet("10.1.6.0/24"),
+			},
+			Endpoint: "37.187.124.72:6000",
+		},
+		pkg.Peer{
+			Subnet:   types.MustParseIPNet("10.1.5.0/24"),
+			Endpoint: "185.69.166.10:0",
+			AllowedIPs: []types.IPNet{
+				types.MustParseIPNet("10.1.5.0/24"),
+			},
+			ConnType: pkg.ConnTypeIPSec,
+			IPSec: &pkg.IPSecConfig{
+				SPIIn:  0x1001,
+				SPIOut: 0x1002,
+				KeyIn:  "sealed-in",
+				KeyOut: "sealed-out",
+			},
+		},
+	)
+
+	// the deployed network resource has a peer the update removes
+	removedKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	previous := *resource
+	previous.Peers = append(append([]pkg.Peer(nil), resource.Peers...), pkg.Peer{
+		Subnet:      types.MustParseIPNet("10.1.4.0/24"),
+		WGPublicKey: removedKey.PublicKey().String(),
+		AllowedIPs: []types.IPNet{
+			types.MustParseIPNet("10.1.4.0/24"),
+			types.MustParseIPNet("100.64.1.4/32"),
+		},
+		Endpoint: "37.187.124.73:6000",
+	})
+
+	k := kernel.NewFake()
+	apply := func(resource *pkg.NetResource) *NetResource {
+		nr, err := New("net1", resource, nil)
+		require.NoError(t, err)
+		nr.kernel = k
+		nr.SetRouteTable(100)
+		nr.SetUnsealer(func(sealed string) (string, error) {
+			return strings.Repeat("k", ipsecKeySize), nil
+		})
+
+		peers, err := nr.wgPeers()
+		require.NoError(t, err)
+		routes, err := nr.routes()
+		require.NoError(t, err)
+		require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+		return nr
+	}
+
+	wgName := "wg-net1"
+	k.AddLink(wgName, "wireguard")
+	pub := k.AddLink(pubIface, "macvlan")
+	addr, err := netlink.ParseAddr("100.127.0.5/16")
+	require.NoError(t, err)
+	require.NoError(t, k.AddrAdd(pub, addr))
+
+	apply(&previous)
+	nr := apply(resource)
+	require.NoError(t, nr.withdrawFrom(&previous))
+
+	result, err := nr.Plan(&previous)
+	require.NoError(t, err)
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+
+	applied := make(map[string][]string)
+	for _, peer := range device.Peers {
+		var allowed []string
+		for _, ip := range peer.AllowedIPs {
+			allowed = append(allowed, ip.String())
+		}
+		applied[peer.PublicKey.String()] = allowed
+	}
+
+	planned := make(map[string][]string)
+	for _, peer := range result.Peers {
+		var allowed []string
+		for _, ip := range peer.AllowedIPs {
+			allowed = append(allowed, ip.String())
+		}
+		planned[peer.PublicKey] = allowed
+	}
+
+	// the ipsec peer isn't a wireguard peer, the first peer doesn't
+	// route the prefix of the second one
+	require.Len(t, planned, 2)
+	assert.Equal(t, []string{"10.1.2.0/23", "10.1.6.0/24", "100.64.1.2/32"}, planned[resource.Peers[0].WGPublicKey])
+	assert.Equal(t, applied, planned)
+
+	var appliedRoutes []string
+	for _, route := range k.Routes() {
+		typ := ""
+		if route.Type != syscall.RTN_UNSPEC {
+			typ = routeType(route.Type)
+		}
+		appliedRoutes = append(appliedRoutes, plannedRoute(route.Dst.String(), route.Gw.String(), route.Table, uint32(route.Priority), typ))
+	}
+
+	var plannedRoutes []string
+	for _, route := range result.Routes {
+		plannedRoutes = append(plannedRoutes, plannedRoute(route.Dst.String(), route.Gateway.String(), route.Table, route.Metric, route.Type))
+	}
+
+	assert.Contains(t, plannedRoutes, plannedRoute("10.1.4.0/24", "<nil>", 100, WithdrawnRouteMetric, "blackhole"))
+	assert.ElementsMatch(t, appliedRoutes, plannedRoutes)
+}
+
+func TestAddPeer(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	newKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	peer := pkg.Peer{
+		Subnet:      types.MustParseIPNet("10.1.3.0/24"),
+		WGPublicKey: newKey.PublicKey().String(),
+		AllowedIPs: []types.IPNet{
+			types.MustParseIPNet("10.1.3.0/24"),
+			types.MustParseIPNet("100.64.1.3/32"),
+		},
+	}
+
+	require.NoError(t, nr.addPeer(peer))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	// the existing peer is kept
+	assert.Len(t, device.Peers, 2)
+	assert.Len(t, k.Routes(), 2)
+	assert.Len(t, resource.Peers, 2)
+
+	// update the peer with a new key and allowed IPs
+	updatedKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer.WGPublicKey = updatedKey.PublicKey().String()
+	peer.AllowedIPs = []types.IPNet{
+		types.MustParseIPNet("10.1.4.0/24"),
+		types.MustParseIPNet("100.64.1.3/32"),
+	}
+
+	require.NoError(t, nr.addPeer(peer))
+
+	device, err = k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 2)
+	assert.Equal(t, updatedKey.PublicKey(), device.Peers[1].PublicKey)
+
+	var dsts, blackholes []string
+	for _, route := range k.Routes() {
+		if route.Type == syscall.RTN_BLACKHOLE {
+			blackholes = append(blackholes, route.Dst.String())
+			continue
+		}
+		dsts = append(dsts, route.Dst.String())
+	}
+	assert.ElementsMatch(t, []string{"10.1.2.0/24", "10.1.4.0/24"}, dsts)
+	// the subnet the peer doesn't allow anymore is withdrawn
+	assert.Equal(t, []string{"10.1.3.0/24"}, blackholes)
+	assert.Len(t, resource.Peers, 2)
+}
+
+func TestRemovePeer(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	err = nr.removePeer(types.MustParseIPNet("10.1.9.0/24"))
+	assert.Error(t, err)
+
+	require.NoError(t, nr.removePeer(types.MustParseIPNet("10.1.2.0/24")))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	assert.Empty(t, device.Peers)
+	assert.Empty(t, resource.Peers)
+
+	// the subnet of the peer is blackholed instead of
+	// following the default route
+	routes = k.Routes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "10.1.2.0/24", routes[0].Dst.String())
+	assert.Equal(t, syscall.RTN_BLACKHOLE, routes[0].Type)
+	assert.Equal(t, WithdrawnRouteMetric, routes[0].Priority)
+	assert.Zero(t, routes[0].LinkIndex)
+}
+
+func TestWithdraw(t *testing.T) {
+	for _, action := range []pkg.WithdrawAction{pkg.WithdrawUnreachable, pkg.WithdrawNone} {
+		resource, _, _ := testResource(t)
+		resource.Withdrawn = action
+		nr, err := New("net1", resource, nil)
+		require.NoError(t, err)
+
+		k := kernel.NewFake()
+		nr.kernel = k
+
+		wgName, err := nr.WGName()
+		require.NoError(t, err)
+		k.AddLink(wgName, "wireguard")
+
+		peers, err := nr.wgPeers()
+		require.NoError(t, err)
+		routes, err := nr.routes()
+		require.NoError(t, err)
+		require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+		require.NoError(t, nr.removePeer(types.MustParseIPNet("10.1.2.0/24")))
+
+		routes = k.Routes()
+		if action == pkg.WithdrawNone {
+			assert.Empty(t, routes)
+			continue
+		}
+
+		require.Len(t, routes, 1)
+		assert.Equal(t, syscall.RTN_UNREACHABLE, routes[0].Type)
+	}
+}
+
+func TestConfigureWGPresharedKey(t *testing.T) {
+	resource, _, _ := testResource(t)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	resource.Peers[0].WGPresharedKey = "sealed"
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	// preshared key can't be used without a way to decrypt it
+	_, err = nr.wgPeers()
+	require.Error(t, err)
+
+	nr.SetUnsealer(func(sealed string) (string, error) {
+		assert.Equal(t, "sealed", sealed)
+		return psk.String(), nil
+	})
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, nil))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 1)
+	assert.Equal(t, psk, device.Peers[0].PresharedKey)
+
+	// updating the peer without preshared key clears it
+	peer := resource.Peers[0]
+	peer.WGPresharedKey = ""
+	require.NoError(t, nr.addPeer(peer))
+
+	device, err = k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 1)
+	assert.Equal(t, wgtypes.Key{}, device.Peers[0].PresharedKey)
+}
+
+func TestRoutesAggregation(t *testing.T) {
+	resource, _, _ := testResource(t)
+	resource.Peers[0].AllowedIPs = []types.IPNet{
+		types.MustParseIPNet("10.1.2.0/25"),
+		types.MustParseIPNet("10.1.2.128/25"),
+		types.MustParseIPNet("10.1.3.0/24"),
+		types.MustParseIPNet("100.64.1.2/32"),
+		types.MustParseIPNet("100.64.1.3/32"),
+	}
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	routes, err := nr.routes()
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, "10.1.2.0/23", routes[0].Dst.String())
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	assert.Equal(t, []string{"10.1.2.0/23", "100.64.1.2/31"}, peers[0].AllowedIPs)
+}
+
+func TestConfigureWGRouteTable(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	link := k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+
+	// route installed in the main table by a previous version
+	legacy := routes[0]
+	legacy.LinkIndex = link.Attrs().Index
+	require.NoError(t, k.RouteAdd(&legacy))
+
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	kRoutes := k.Routes()
+	require.Len(t, kRoutes, 1)
+	assert.Equal(t, DefaultRouteTable, kRoutes[0].Table)
+
+	rules := k.Rules()
+	require.Len(t, rules, 3)
+	var ifaces []string
+	for _, rule := range rules {
+		assert.Equal(t, DefaultRouteTable, rule.Table)
+		ifaces = append(ifaces, rule.IifName)
+	}
+	assert.ElementsMatch(t, []string{"net-net1", "wg-net1", "lo"}, ifaces)
+
+	// rules are not duplicated
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+	assert.Len(t, k.Rules(), 3)
+}
+
+func TestConfigureWGMainTable(t *testing.T) {
+	resource, _, _ := testResource(t)
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+	nr.SetRouteTable(0)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	kRoutes := k.Routes()
+	require.Len(t, kRoutes, 1)
+	assert.Equal(t, 0, kRoutes[0].Table)
+	assert.Empty(t, k.Rules())
+}
+
+func TestEndpointFailover(t *testing.T) {
+	resource, _, peerKey := testResource(t)
+	resource.Peers[0].Endpoints = []string{"[2001:db8::1]:6000"}
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+	assert.True(t, nr.HasAlternateEndpoints())
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, nil))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 1)
+	assert.Equal(t, "37.187.124.71:6000", device.Peers[0].Endpoint.String())
+
+	now := time.Now()
+
+	// recent handshake, nothing changes
+	device.Peers[0].LastHandshakeTime = now.Add(-time.Minute)
+	require.NoError(t, nr.failover(now, 3*time.Minute))
+	assert.Equal(t, "37.187.124.71:6000", device.Peers[0].Endpoint.String())
+
+	// handshake too old, move to the next endpoint
+	device.Peers[0].LastHandshakeTime = now.Add(-5 * time.Minute)
+	require.NoError(t, nr.failover(now, 3*time.Minute))
+	assert.Equal(t, "[2001:db8::1]:6000", device.Peers[0].Endpoint.String())
+	assert.Equal(t, peerKey.PublicKey(), device.Peers[0].PublicKey)
+
+	// still no handshake, back to the first endpoint
+	require.NoError(t, nr.failover(now, 3*time.Minute))
+	assert.Equal(t, "37.187.124.71:6000", device.Peers[0].Endpoint.String())
+}
+
+func TestIPSecPeer(t *testing.T) {
+	resource, _, _ := testResource(t)
+	resource.Peers = append(resource.Peers, pkg.Peer{
+		Subnet:   types.MustParseIPNet("10.1.5.0/24"),
+		Endpoint: "185.69.166.10:0",
+		AllowedIPs: []types.IPNet{
+			types.MustParseIPNet("10.1.5.0/24"),
+		},
+		ConnType: pkg.ConnTypeIPSec,
+		IPSec: &pkg.IPSecConfig{
+			SPIIn:  0x1001,
+			SPIOut: 0x1002,
+			KeyIn:  "sealed-in",
+			KeyOut: "sealed-out",
+		},
+	})
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+	nr.SetUnsealer(func(sealed string) (string, error) {
+		return strings.Repeat("k", ipsecKeySize), nil
+	})
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+	pub := k.AddLink(pubIface, "macvlan")
+	addr, err := netlink.ParseAddr("100.127.0.5/16")
+	require.NoError(t, err)
+	require.NoError(t, k.AddrAdd(pub, addr))
+
+	// ipsec peers are not part of the wireguard configuration
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	routes, err := nr.routes()
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+
+	states := k.XfrmStates()
+	require.Len(t, states, 2)
+	assert.Equal(t, "100.127.0.5", states[0].Src.String())
+	assert.Equal(t, "185.69.166.10", states[0].Dst.String())
+	assert.Equal(t, 0x1002, states[0].Spi)
+	assert.Equal(t, 0x1001, states[1].Spi)
+
+	policies := k.XfrmPolicies()
+	require.Len(t, policies, 3)
+	assert.Equal(t, netlink.XFRM_DIR_OUT, policies[0].Dir)
+	assert.Equal(t, "10.1.1.0/24", policies[0].Src.String())
+	assert.Equal(t, "10.1.5.0/24", policies[0].Dst.String())
+
+	// configuring again updates the existing states and policies
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, routes))
+	assert.Len(t, k.XfrmStates(), 2)
+	assert.Len(t, k.XfrmPolicies(), 3)
+
+	require.NoError(t, nr.removePeer(types.MustParseIPNet("10.1.5.0/24")))
+	assert.Empty(t, k.XfrmStates())
+	assert.Empty(t, k.XfrmPolicies())
+	assert.Len(t, resource.Peers, 1)
+}
+
+func TestPeersStatus(t *testing.T) {
+	resource, _, peerKey := testResource(t)
+
+	nr, err := New("net1", resource, nil)
+	require.NoError(t, err)
+
+	k := kernel.NewFake()
+	nr.kernel = k
+
+	wgName, err := nr.WGName()
+	require.NoError(t, err)
+	k.AddLink(wgName, "wireguard")
+
+	peers, err := nr.wgPeers()
+	require.NoError(t, err)
+	require.NoError(t, nr.configureWG(resource.WGPrivateKey, peers, nil))
+
+	device, err := k.Device(wgName)
+	require.NoError(t, err)
+	require.Len(t, device.Peers, 1)
+
+	handshake := time.Now().Add(-time.Minute)
+	device.Peers[0].LastHandshakeTime = handshake
+	device.Peers[0].ReceiveBytes = 1024
+	device.Peers[0].TransmitBytes = 2048
+
+	status, err := nr.peersStatus()
+	require.NoError(t, err)
+	require.Len(t, status, 1)
+	assert.Equal(t, pkg.PeerStatus{
+		Subnet:        resource.Peers[0].Subnet,
+		ConnType:      pkg.ConnTypeWireguard,
+		Endpoint:      "37.187.124.71:6000",
+		LastHandshake: handshake,
+		RxBytes:       1024,
+		TxBytes:       2048,
+	}, status[0])
+	assert.Equal(t, peerKey.PublicKey(), device.Peers[0].PublicKey)
+}
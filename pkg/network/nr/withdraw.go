@@ -0,0 +1,114 @@
+package nr
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/vishvananda/netlink"
+)
+
+// WithdrawnRouteMetric is the metric of the routes of the withdrawn subnets.
+// It's higher than the metric of any peer route, so a peer routing the
+// subnet again takes over without removing the withdrawal route
+const WithdrawnRouteMetric = 0xffff
+
+// withdrawType returns the type of the routes of the withdrawn subnets,
+// RTN_UNSPEC if none must be installed
+func (nr *NetResource) withdrawType() int {
+	switch nr.resource.Withdrawn {
+	case pkg.WithdrawNone:
+		return syscall.RTN_UNSPEC
+	case pkg.WithdrawUnreachable:
+		return syscall.RTN_UNREACHABLE
+	default:
+		return syscall.RTN_BLACKHOLE
+	}
+}
+
+// Withdraw removes the routes of previous, the network resource before the
+// update, to the subnets no peer routes anymore and installs their
+// withdrawal routes instead
+func (nr *NetResource) Withdraw(previous *pkg.NetResource) error {
+	return nr.inNamespace(func() error {
+		return nr.withdrawFrom(previous)
+	})
+}
+
+// withdrawFrom is Withdraw in the namespace of the network resource
+func (nr *NetResource) withdrawFrom(previous *pkg.NetResource) error {
+	old := &NetResource{resource: previous, table: nr.table}
+	before, err := old.routes()
+	if err != nil {
+		return err
+	}
+
+	after, err := nr.routes()
+	if err != nil {
+		return err
+	}
+
+	_, link, err := nr.wgLink()
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routesDiff(before, after) {
+		route.LinkIndex = link.Attrs().Index
+		route.Table = nr.table
+		if err := nr.kernel.RouteDel(&route); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "failed to delete route %s", route.String())
+		}
+	}
+
+	return nr.withdraw(withdrawn(before, after))
+}
+
+// withdraw installs the withdrawal routes of dsts
+func (nr *NetResource) withdraw(dsts []net.IPNet) error {
+	typ := nr.withdrawType()
+	if typ == syscall.RTN_UNSPEC {
+		return nil
+	}
+
+	for _, dst := range dsts {
+		dst := dst
+		route := netlink.Route{
+			Dst:      &dst,
+			Table:    nr.table,
+			Type:     typ,
+			Priority: WithdrawnRouteMetric,
+		}
+
+		if err := nr.kernel.RouteAdd(&route); err != nil && !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to withdraw route to %s", dst.String())
+		}
+
+		log.Info().Str("subnet", dst.String()).Int("type", typ).Msg("subnet withdrawn")
+	}
+
+	return nil
+}
+
+// withdrawn returns the destinations of the routes of before that are
+// not routed by after
+func withdrawn(before, after []netlink.Route) []net.IPNet {
+	routed := make(map[string]bool)
+	for _, route := range after {
+		routed[route.Dst.String()] = true
+	}
+
+	var dsts []net.IPNet
+	for _, route := range before {
+		if routed[route.Dst.String()] {
+			continue
+		}
+		routed[route.Dst.String()] = true
+		dsts = append(dsts, *route.Dst)
+	}
+
+	return dsts
+}
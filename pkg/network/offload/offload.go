@@ -0,0 +1,224 @@
+// Package offload tunes the offload features of the interfaces of the
+// overlay.
+//
+// The defaults of the veths and of the wireguard interfaces depend on the
+// kernel and on the NIC underneath, and some combinations (checksums
+// offloaded to a NIC that doesn't see the encapsulated packet, segments
+// aggregated by GRO then split again by wireguard) cut the throughput of
+// the overlay by an order of magnitude. The features are changed with the
+// ethtool ioctl, like `ethtool -K`.
+package offload
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"golang.org/x/sys/unix"
+)
+
+// Feature is an offload feature of an interface, named like the ethtool
+// -K option
+type Feature string
+
+const (
+	// TxChecksum computes the checksums of the sent packets on the device
+	TxChecksum Feature = "tx"
+	// GSO segments the large sent packets as late as possible
+	GSO Feature = "gso"
+	// GRO aggregates the received packets of a flow
+	GRO Feature = "gro"
+)
+
+// Features are the features that can be tuned
+var Features = []Feature{TxChecksum, GSO, GRO}
+
+// ethtool get and set commands of the features
+var commands = map[Feature]struct{ get, set uint32 }{
+	TxChecksum: {get: 0x00000016, set: 0x00000017},
+	GSO:        {get: 0x00000023, set: 0x00000024},
+	GRO:        {get: 0x0000002b, set: 0x0000002c},
+}
+
+const (
+	vethParam      = "offload-veth"
+	wireguardParam = "offload-wg"
+)
+
+// Policy is the state of the features of an interface, the features not
+// in the policy keep the kernel default
+type Policy map[Feature]bool
+
+// String returns the policy in the format of ParsePolicy
+func (p Policy) String() string {
+	settings := make([]string, 0, len(p))
+	for feature, on := range p {
+		state := "off"
+		if on {
+			state = "on"
+		}
+		settings = append(settings, fmt.Sprintf("%s:%s", feature, state))
+	}
+	sort.Strings(settings)
+
+	return strings.Join(settings, ",")
+}
+
+// ParsePolicy parses a policy in the format feature:on|off[,feature:on|off]
+// like tx:off,gso:on
+func ParsePolicy(value string) (Policy, error) {
+	policy := make(Policy)
+	if len(value) == 0 {
+		return policy, nil
+	}
+
+	for _, setting := range strings.Split(value, ",") {
+		parts := strings.SplitN(setting, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid offload setting '%s', expecting feature:on|off", setting)
+		}
+
+		feature := Feature(parts[0])
+		if _, ok := commands[feature]; !ok {
+			return nil, fmt.Errorf("unknown offload feature '%s'", feature)
+		}
+
+		switch parts[1] {
+		case "on":
+			policy[feature] = true
+		case "off":
+			policy[feature] = false
+		default:
+			return nil, fmt.Errorf("invalid state '%s' of offload feature '%s', expecting on or off", parts[1], feature)
+		}
+	}
+
+	return policy, nil
+}
+
+// Config is the offload policy of the interfaces of the overlay set by the
+// farmer
+type Config struct {
+	// Veth is the policy of both ends of the veths of the workloads
+	Veth Policy
+	// Wireguard is the policy of the wireguard interfaces of the network
+	// resources
+	Wireguard Policy
+}
+
+// ConfigFromParams reads the offload configuration from the kernel
+// parameters. offload-veth=<policy> is the policy of the veths and
+// offload-wg=<policy> the one of the wireguard interfaces, see ParsePolicy.
+// Without them the interfaces keep the kernel defaults
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	var (
+		config Config
+		err    error
+	)
+
+	if values, ok := params.Get(vethParam); ok && len(values) != 0 {
+		if config.Veth, err = ParsePolicy(values[0]); err != nil {
+			return Config{}, errors.Wrapf(err, "invalid %s", vethParam)
+		}
+	}
+
+	if values, ok := params.Get(wireguardParam); ok && len(values) != 0 {
+		if config.Wireguard, err = ParsePolicy(values[0]); err != nil {
+			return Config{}, errors.Wrapf(err, "invalid %s", wireguardParam)
+		}
+	}
+
+	return config, nil
+}
+
+// ifreq is the request of the ethtool ioctl
+type ifreq struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [16]byte
+}
+
+// value is the argument of the ethtool commands of the features
+type value struct {
+	cmd  uint32
+	data uint32
+}
+
+func ethtool(name string, cmd uint32, data uint32) (uint32, error) {
+	if len(name) >= unix.IFNAMSIZ {
+		return 0, fmt.Errorf("invalid interface name '%s'", name)
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open ethtool socket")
+	}
+	defer unix.Close(fd)
+
+	arg := value{cmd: cmd, data: data}
+	req := ifreq{data: unsafe.Pointer(&arg)}
+	copy(req.name[:], name)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return 0, errno
+	}
+
+	return arg.data, nil
+}
+
+// Get returns the state of the features of the interface name. It must be
+// called in the namespace of the interface
+func Get(name string) (Policy, error) {
+	policy := make(Policy)
+	for _, feature := range Features {
+		on, err := ethtool(name, commands[feature].get, 0)
+		if err == unix.EOPNOTSUPP {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s of %s", feature, name)
+		}
+
+		policy[feature] = on != 0
+	}
+
+	return policy, nil
+}
+
+// Apply sets the features of the interface name to the policy, the
+// features already in the state of the policy are not changed. It must be
+// called in the namespace of the interface
+func Apply(name string, policy Policy) error {
+	if len(policy) == 0 {
+		return nil
+	}
+
+	current, err := Get(name)
+	if err != nil {
+		return err
+	}
+
+	for _, feature := range Features {
+		on, ok := policy[feature]
+		if !ok {
+			continue
+		}
+
+		if state, ok := current[feature]; ok && state == on {
+			continue
+		}
+
+		var data uint32
+		if on {
+			data = 1
+		}
+
+		if _, err := ethtool(name, commands[feature].set, data); err != nil {
+			return errors.Wrapf(err, "failed to set %s of %s", feature, name)
+		}
+	}
+
+	return nil
+}
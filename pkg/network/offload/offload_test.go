@@ -0,0 +1,54 @@
+package offload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("tx:off,gso:on,gro:off")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{TxChecksum: false, GSO: true, GRO: false}, policy)
+	assert.Equal(t, "gro:off,gso:on,tx:off", policy.String())
+
+	policy, err = ParsePolicy("")
+	require.NoError(t, err)
+	assert.Empty(t, policy)
+
+	for _, value := range []string{"tx", "tx:disabled", "tso:off", "tx:off,"} {
+		_, err := ParsePolicy(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Empty(t, config.Veth)
+	assert.Empty(t, config.Wireguard)
+
+	config, err = ConfigFromParams(kernel.Params{
+		"offload-veth": {"tx:off"},
+		"offload-wg":   {"gro:on,gso:on"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Policy{TxChecksum: false}, config.Veth)
+	assert.Equal(t, Policy{GRO: true, GSO: true}, config.Wireguard)
+
+	_, err = ConfigFromParams(kernel.Params{"offload-wg": {"gro"}})
+	assert.Error(t, err)
+}
+
+func TestGet(t *testing.T) {
+	policy, err := Get("lo")
+	require.NoError(t, err)
+	for feature := range policy {
+		assert.Contains(t, Features, feature)
+	}
+
+	_, err = Get("a-very-long-interface-name")
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+// Package oplog implements the operation log of networkd.
+//
+// Every operation that changes a network resource is recorded before it
+// touches the system, and removed once it returned. The entries left in the
+// log when networkd starts are the operations interrupted by a crash, they
+// are applied again (or rolled back) before networkd serves new requests, so
+// the state of the kernel never silently diverges from the stored networks.
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const ext = ".json"
+
+// Entry is an operation on a network resource
+type Entry struct {
+	ID        string          `json:"id"`
+	Operation string          `json:"operation"`
+	NetID     pkg.NetID       `json:"net_id"`
+	Args      json.RawMessage `json:"args"`
+	// Previous is the stored network before the operation started, nil if
+	// the network was not deployed on the node
+	Previous *pkg.Network `json:"previous,omitempty"`
+	Started  time.Time    `json:"started"`
+}
+
+// Log is a persistent log of the operations in progress
+type Log struct {
+	dir string
+	seq uint64
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// Open opens the log stored in dir
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create operation log directory")
+	}
+
+	return &Log{dir: dir, now: time.Now}, nil
+}
+
+// Begin records the operation on the network networkID with its arguments
+// and the network stored before it. The returned function removes the
+// entry and must be called once the operation returned, failed or not
+func (l *Log) Begin(operation string, networkID pkg.NetID, args interface{}, previous *pkg.Network) (func(), error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode operation arguments")
+	}
+
+	now := l.now()
+	entry := Entry{
+		// the id sorts the entries in the order they were recorded
+		ID:        fmt.Sprintf("%020d-%06d", now.UnixNano(), atomic.AddUint64(&l.seq, 1)),
+		Operation: operation,
+		NetID:     networkID,
+		Args:      data,
+		Previous:  previous,
+		Started:   now,
+	}
+
+	if err := l.write(&entry); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// an entry that can't be removed is replayed on the next start,
+		// the operations are safe to apply again
+		_ = l.Remove(entry.ID)
+	}, nil
+}
+
+// write stores the entry atomically, a crash never leaves a partial entry
+func (l *Log) write(entry *Entry) error {
+	path := filepath.Join(l.dir, entry.ID+ext)
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "failed to create operation log entry")
+	}
+
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write operation log entry")
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to flush operation log entry")
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Remove removes the entry id from the log
+func (l *Log) Remove(id string) error {
+	err := os.Remove(filepath.Join(l.dir, id+ext))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Pending returns the entries of the log, oldest first. The entries that
+// can't be decoded are dropped
+func (l *Log) Pending() ([]Entry, error) {
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list operation log")
+	}
+
+	var entries []Entry
+	for _, info := range infos {
+		name := info.Name()
+		path := filepath.Join(l.dir, name)
+
+		if !strings.HasSuffix(name, ext) {
+			// left over of an entry that was never completely written
+			_ = os.Remove(path)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read operation log entry %s", name)
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			_ = os.Remove(path)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,86 @@
+package oplog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestBeginDone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	previous := &pkg.Network{NetID: "net1", Name: "previous"}
+	done, err := l.Begin("CreateNR", "net1", pkg.Network{NetID: "net1", Name: "next"}, previous)
+	require.NoError(t, err)
+
+	entries, err := l.Pending()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	entry := entries[0]
+	assert.Equal(t, "CreateNR", entry.Operation)
+	assert.Equal(t, pkg.NetID("net1"), entry.NetID)
+	require.NotNil(t, entry.Previous)
+	assert.Equal(t, "previous", entry.Previous.Name)
+
+	var args pkg.Network
+	require.NoError(t, json.Unmarshal(entry.Args, &args))
+	assert.Equal(t, "next", args.Name)
+
+	done()
+
+	entries, err = l.Pending()
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestPendingOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i, op := range []string{"CreateNR", "AddPeer", "DeleteNR"} {
+		l.now = func() time.Time { return now.Add(time.Duration(i) * time.Second) }
+		_, err := l.Begin(op, "net1", nil, nil)
+		require.NoError(t, err)
+	}
+
+	// an interrupted write and a corrupted entry are dropped
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "partial.json.tmp"), []byte("{"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "corrupted.json"), []byte("{"), 0600))
+
+	entries, err := l.Pending()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "CreateNR", entries[0].Operation)
+	assert.Equal(t, "AddPeer", entries[1].Operation)
+	assert.Equal(t, "DeleteNR", entries[2].Operation)
+	assert.Nil(t, entries[0].Previous)
+
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+
+	require.NoError(t, l.Remove(entries[1].ID))
+	require.NoError(t, l.Remove(entries[1].ID))
+
+	entries, err = l.Pending()
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
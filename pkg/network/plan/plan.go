@@ -0,0 +1,204 @@
+// Package plan implements the addressing plan of the network resources.
+//
+// All the names and addresses networkd gives to a network resource are
+// derived from the network ID and the IPv4 subnet of the resource on the node.
+// The IPv4 subnet itself can be derived from the IPv6 /64 of the resource in
+// the allocation of its farm, see FromAllocation.
+// The functions of this package don't need access to a node, so provisioning
+// clients can use them offline to predict what a network resource will get
+// before it's deployed.
+package plan
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/zosip"
+)
+
+// maxIfaceName is the max length of a linux interface name
+const maxIfaceName = 15
+
+// LinkLocalGateway is the IPv6 link local address of the network
+// resource gateway, used as the IPv6 default gateway of the containers
+var LinkLocalGateway = net.IPNet{
+	IP:   net.ParseIP("fe80::1"),
+	Mask: net.CIDRMask(64, 128),
+}
+
+// Plan is the addressing plan of a network resource on a node
+type Plan struct {
+	// NetID is the ID of the network
+	NetID pkg.NetID
+	// Namespace is the name of the network namespace of the network resource. The
+	// interface of the network resource in the namespace has the same name
+	Namespace string
+	// Bridge is the name of the bridge of the network resource
+	Bridge string
+	// WireGuard is the name of the wireguard interface
+	WireGuard string
+	// Tap is the name of the tap device used by the VMs
+	Tap string
+	// Subnet is the IPv4 subnet of the network resource
+	Subnet net.IPNet
+	// Gateway is the IPv4 of the network resource gateway
+	Gateway net.IPNet
+	// GatewayIPv6 is the IPv6 of the network resource gateway
+	GatewayIPv6 net.IPNet
+	// WireGuardIP is the address of the wireguard interface
+	WireGuardIP net.IPNet
+	// LinkLocal is the link local address of the network resource gateway
+	LinkLocal net.IPNet
+	// Allocation is the IPv6 /64 of the network resource in the allocation
+	// of its farm, nil if the plan is not derived from an allocation
+	Allocation *net.IPNet
+	// Nibble is the hex encoding of the allocation of the network resource
+	// inside its farm, empty if the plan is not derived from an allocation
+	Nibble string
+}
+
+// New computes the plan of the network resource of network netID with
+// the IPv4 subnet
+func New(netID pkg.NetID, subnet net.IPNet) (*Plan, error) {
+	ip := subnet.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("subnet %s is not an IPv4 subnet", subnet.String())
+	}
+
+	subnet.IP = ip
+	p := &Plan{
+		NetID:     netID,
+		Subnet:    subnet,
+		Gateway:   net.IPNet{IP: Gateway(subnet), Mask: subnet.Mask},
+		LinkLocal: LinkLocalGateway,
+	}
+
+	var err error
+	if p.Namespace, err = NamespaceName(netID); err != nil {
+		return nil, err
+	}
+	if p.Bridge, err = BridgeName(netID); err != nil {
+		return nil, err
+	}
+	if p.WireGuard, err = WGName(netID); err != nil {
+		return nil, err
+	}
+	if p.Tap, err = TapName(netID); err != nil {
+		return nil, err
+	}
+
+	p.GatewayIPv6 = net.IPNet{
+		IP:   IPv6(netID, p.Gateway.IP),
+		Mask: net.CIDRMask(64, 128),
+	}
+	p.WireGuardIP = *WireGuardIP(&subnet)
+
+	return p, nil
+}
+
+// FromAllocation computes the plan of the network resource of network netID
+// with the IPv6 /64 allocation, taken from a farm prefix of farmSize bits
+// (/40, /48, /56...). The IPv4 subnet of the resource is mapped from the
+// allocation with mapping, zosip.DefaultMapping if nil. A farm bigger than
+// /48 must give its mapping
+func FromAllocation(netID pkg.NetID, allocation net.IPNet, farmSize int, mapping zosip.Mapping) (*Plan, error) {
+	nibble, err := zosip.NewNibble(&allocation, farmSize, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	subnet, err := nibble.ToV4()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map allocation %s", allocation.String())
+	}
+
+	p, err := New(netID, subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Allocation = &net.IPNet{IP: allocation.IP.Mask(allocation.Mask), Mask: allocation.Mask}
+	p.Nibble = nibble.Hex()
+
+	return p, nil
+}
+
+// ContainerIPv6 returns the IPv6 a container with IPv4 ip
+// gets in the network resource
+func (p *Plan) ContainerIPv6(ip net.IP) net.IP {
+	return IPv6(p.NetID, ip)
+}
+
+// BridgeName returns the name of the bridge of the network resource
+// in the host network namespace
+func BridgeName(netID pkg.NetID) (string, error) {
+	name := fmt.Sprintf("b-%s", netID)
+	if len(name) > maxIfaceName {
+		return "", errors.Errorf("bridge namespace too long %s", name)
+	}
+	return name, nil
+}
+
+// NamespaceName returns the name of the network namespace of the network resource
+func NamespaceName(netID pkg.NetID) (string, error) {
+	name := fmt.Sprintf("n-%s", netID)
+	if len(name) > maxIfaceName {
+		return "", errors.Errorf("network namespace too long %s", name)
+	}
+	return name, nil
+}
+
+// WGName returns the name of the wireguard interface of the network resource
+func WGName(netID pkg.NetID) (string, error) {
+	name := fmt.Sprintf("w-%s", netID)
+	if len(name) > maxIfaceName {
+		return "", errors.Errorf("network namespace too long %s", name)
+	}
+	return name, nil
+}
+
+// TapName returns the name of the tap device of the network resource
+func TapName(netID pkg.NetID) (string, error) {
+	name := fmt.Sprintf("t-%s", netID)
+	if len(name) > maxIfaceName {
+		return "", errors.Errorf("tap name too long %s", name)
+	}
+	return name, nil
+}
+
+// Gateway returns the IPv4 of the gateway of the subnet, which
+// is always the first address of the subnet
+func Gateway(subnet net.IPNet) net.IP {
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+	ip[len(ip)-1] = 0x01
+	return ip
+}
+
+// WireGuardIP returns the address of the wireguard interface for a network
+// resource subnet
+// example: 10.3.1.0 -> 100.64.3.1
+func WireGuardIP(subnet *net.IPNet) *net.IPNet {
+	a := subnet.IP[len(subnet.IP)-3]
+	b := subnet.IP[len(subnet.IP)-2]
+
+	return &net.IPNet{
+		IP:   net.IPv4(0x64, 0x40, a, b),
+		Mask: net.CIDRMask(16, 32),
+	}
+}
+
+// IPv6 returns the IPv6 mapped to the IPv4 ip inside network netID
+func IPv6(netID pkg.NetID, ip net.IP) net.IP {
+	h := md5.New()
+	md5NetID := h.Sum([]byte(netID))
+
+	ip = ip.To16()
+	ipv6 := fmt.Sprintf("fd%x:%x%x:%x%x", md5NetID[0], md5NetID[1], md5NetID[2], md5NetID[3], md5NetID[4])
+	ipv6 = fmt.Sprintf("%s:%x::%x", ipv6, ip[14], ip[15])
+
+	return net.ParseIP(ipv6)
+}
@@ -0,0 +1,87 @@
+package plan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/network/zosip"
+)
+
+func TestPlan(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.3.1.0/24")
+	require.NoError(t, err)
+
+	p, err := New("networkdID", *subnet)
+	require.NoError(t, err)
+
+	assert.Equal(t, "n-networkdID", p.Namespace)
+	assert.Equal(t, "b-networkdID", p.Bridge)
+	assert.Equal(t, "w-networkdID", p.WireGuard)
+	assert.Equal(t, "t-networkdID", p.Tap)
+	assert.Equal(t, "10.3.1.1/24", p.Gateway.String())
+	assert.Equal(t, "fd6e:6574:776f:1::1/64", p.GatewayIPv6.String())
+	assert.Equal(t, "100.64.3.1/16", p.WireGuardIP.String())
+	assert.Equal(t, "fe80::1/64", p.LinkLocal.String())
+
+	// the subnet must not be modified
+	assert.Equal(t, "10.3.1.0/24", subnet.String())
+
+	assert.Equal(t, net.ParseIP("fd6e:6574:776f:1::10"), p.ContainerIPv6(net.ParseIP("10.3.1.16")))
+}
+
+func TestFromAllocation(t *testing.T) {
+	_, allocation, err := net.ParseCIDR("2a02:1802:5e:ab12::/64")
+	require.NoError(t, err)
+
+	p, err := FromAllocation("networkdID", *allocation, 48, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "n-networkdID", p.Namespace)
+	assert.Equal(t, "10.171.18.0/24", p.Subnet.String())
+	assert.Equal(t, "10.171.18.1/24", p.Gateway.String())
+	assert.Equal(t, "100.64.171.18/16", p.WireGuardIP.String())
+	assert.Equal(t, "2a02:1802:5e:ab12::/64", p.Allocation.String())
+	assert.Equal(t, "ab12", p.Nibble)
+
+	// a smaller farm has less allocation bits
+	p, err = FromAllocation("networkdID", *allocation, 56, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.18.0/24", p.Subnet.String())
+	assert.Equal(t, "12", p.Nibble)
+
+	// a /40 farm doesn't fit in the direct mapping
+	_, err = FromAllocation("networkdID", *allocation, 40, zosip.DirectMapping{})
+	assert.Error(t, err)
+	_, err = FromAllocation("networkdID", *allocation, 40, nil)
+	assert.Error(t, err)
+
+	_, err = FromAllocation("networkdID", *allocation, 50, nil)
+	assert.Error(t, err)
+}
+
+func TestPlanNameTooLong(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.3.1.0/24")
+	require.NoError(t, err)
+
+	_, err = New("averyverylongnetwork", *subnet)
+	assert.Error(t, err)
+}
+
+func TestIPv6(t *testing.T) {
+	assert.Equal(t, net.ParseIP("fd6e:6574:776f:0000::2"), IPv6("networkdID", net.ParseIP("100.127.0.2")))
+	assert.Equal(t, net.ParseIP("fd6e:6574:776f:0002::0010"), IPv6("networkdID", net.ParseIP("100.127.2.16")))
+}
+
+func TestWireGuardIP(t *testing.T) {
+	got := WireGuardIP(&net.IPNet{
+		IP:   net.ParseIP("10.3.1.0"),
+		Mask: net.CIDRMask(16, 32),
+	})
+
+	assert.Equal(t, &net.IPNet{
+		IP:   net.ParseIP("100.64.3.1"),
+		Mask: net.CIDRMask(16, 32),
+	}, got)
+}
@@ -0,0 +1,123 @@
+// Package prefix implements helpers to manipulate lists of IP prefixes
+package prefix
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// Aggregate returns the smallest list of prefixes that covers exactly the
+// same addresses as prefixes. Prefixes contained in another prefix are
+// dropped and sibling prefixes (two halves of the same block) are merged
+// into their parent. The host bits of the prefixes are cleared.
+// The result is sorted, IPv4 prefixes first
+func Aggregate(prefixes []net.IPNet) []net.IPNet {
+	var v4, v6 []net.IPNet
+	for _, p := range prefixes {
+		n := normalize(p)
+		if n == nil {
+			continue
+		}
+		if len(n.IP) == net.IPv4len {
+			v4 = append(v4, *n)
+		} else {
+			v6 = append(v6, *n)
+		}
+	}
+
+	return append(aggregate(v4), aggregate(v6)...)
+}
+
+// normalize returns the prefix with its host bits cleared and a 4 bytes
+// IP for IPv4 prefixes. It returns nil for an invalid prefix
+func normalize(p net.IPNet) *net.IPNet {
+	ones, bits := p.Mask.Size()
+	if bits == 0 {
+		return nil
+	}
+
+	ip := p.IP
+	if bits == 8*net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	if ip == nil {
+		return nil
+	}
+
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+func less(a, b net.IPNet) bool {
+	if c := bytes.Compare(a.IP, b.IP); c != 0 {
+		return c < 0
+	}
+	ao, _ := a.Mask.Size()
+	bo, _ := b.Mask.Size()
+	return ao < bo
+}
+
+func aggregate(prefixes []net.IPNet) []net.IPNet {
+	for {
+		sort.Slice(prefixes, func(i, j int) bool {
+			return less(prefixes[i], prefixes[j])
+		})
+
+		// drop the prefixes contained in the previous kept prefix, the
+		// sort order guarantees a container comes before its content
+		var kept []net.IPNet
+		for _, p := range prefixes {
+			if len(kept) > 0 && contains(kept[len(kept)-1], p) {
+				continue
+			}
+			kept = append(kept, p)
+		}
+
+		merged := false
+		var result []net.IPNet
+		for i := 0; i < len(kept); i++ {
+			if i+1 < len(kept) {
+				if parent, ok := siblings(kept[i], kept[i+1]); ok {
+					result = append(result, parent)
+					merged = true
+					i++
+					continue
+				}
+			}
+			result = append(result, kept[i])
+		}
+
+		prefixes = result
+		if !merged {
+			return prefixes
+		}
+	}
+}
+
+// contains returns true if prefix b is contained in prefix a
+func contains(a, b net.IPNet) bool {
+	ao, _ := a.Mask.Size()
+	bo, _ := b.Mask.Size()
+	return ao <= bo && a.Contains(b.IP)
+}
+
+// siblings returns the parent of a and b if they are the two
+// halves of the same prefix
+func siblings(a, b net.IPNet) (net.IPNet, bool) {
+	ao, bits := a.Mask.Size()
+	bo, _ := b.Mask.Size()
+	if ao != bo || ao == 0 {
+		return net.IPNet{}, false
+	}
+
+	mask := net.CIDRMask(ao-1, bits)
+	parent := net.IPNet{IP: a.IP.Mask(mask), Mask: mask}
+	if !parent.IP.Equal(a.IP) || !parent.Contains(b.IP) {
+		return net.IPNet{}, false
+	}
+
+	return parent, true
+}
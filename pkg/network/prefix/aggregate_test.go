@@ -0,0 +1,89 @@
+package prefix
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, cidrs ...string) []net.IPNet {
+	var result []net.IPNet
+	for _, cidr := range cidrs {
+		ip, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = ip
+		result = append(result, *n)
+	}
+	return result
+}
+
+func strs(prefixes []net.IPNet) []string {
+	var result []string
+	for _, p := range prefixes {
+		result = append(result, p.String())
+	}
+	return result
+}
+
+func TestAggregate(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  []string
+		output []string
+	}{
+		{
+			name:   "empty",
+			input:  nil,
+			output: nil,
+		},
+		{
+			name:   "siblings",
+			input:  []string{"172.16.0.1/32", "172.16.0.0/32", "172.16.0.2/32", "172.16.0.3/32"},
+			output: []string{"172.16.0.0/30"},
+		},
+		{
+			name:   "not aligned",
+			input:  []string{"172.16.0.1/32", "172.16.0.2/32"},
+			output: []string{"172.16.0.1/32", "172.16.0.2/32"},
+		},
+		{
+			name:   "contained",
+			input:  []string{"10.1.0.0/16", "10.1.2.0/24", "10.1.3.4/32"},
+			output: []string{"10.1.0.0/16"},
+		},
+		{
+			name:   "duplicates",
+			input:  []string{"10.1.2.0/24", "10.1.2.0/24"},
+			output: []string{"10.1.2.0/24"},
+		},
+		{
+			name:   "host bits",
+			input:  []string{"10.1.2.1/24", "10.1.3.1/24"},
+			output: []string{"10.1.2.0/23"},
+		},
+		{
+			name:   "ipv6",
+			input:  []string{"2001:db8:0:1::/64", "2001:db8::/64", "2001:db8:0:2::/64", "2001:db8:0:3::/64", "2001:db8:0:5::/64"},
+			output: []string{"2001:db8::/62", "2001:db8:0:5::/64"},
+		},
+		{
+			name:   "mixed",
+			input:  []string{"fd00::/64", "10.0.0.0/25", "10.0.0.128/25"},
+			output: []string{"10.0.0.0/24", "fd00::/64"},
+		},
+		{
+			name:   "cascade",
+			input:  []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24"},
+			output: []string{"10.0.0.0/23"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.output, strs(Aggregate(parse(t, c.input...))))
+		})
+	}
+}
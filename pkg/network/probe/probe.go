@@ -0,0 +1,141 @@
+// Package probe checks if an address can be reached, like ping and a TCP
+// SYN scan do.
+//
+// The probes are sent from the network namespace of the calling thread, a
+// namespace is probed from by entering it. A probe tells if a packet went
+// through to the target, not if a service answers: a refused connection
+// reached the target.
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Result is the result of a probe
+type Result struct {
+	// Reachable is true if the target answered the probe
+	Reachable bool
+	// Detail is the answer of the target, or why there was none
+	Detail string
+}
+
+// the ICMP echo messages
+const (
+	icmp4Echo      = 8
+	icmp4EchoReply = 0
+	icmp6Echo      = 128
+	icmp6EchoReply = 129
+)
+
+var (
+	// echoID tells the echo replies of the probes apart from the ones of
+	// the other processes of the namespace
+	echoID = uint16(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1 << 16))
+	// echoSeq tells the probes apart
+	echoSeq uint32
+)
+
+// checksum is the internet checksum of data
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+// echo builds an ICMP echo request, the kernel computes the checksum of the
+// ICMPv6 messages
+func echo(typ uint8, seq uint16) []byte {
+	msg := make([]byte, 8, 8+len("zos probe"))
+	msg[0] = typ
+	binary.BigEndian.PutUint16(msg[4:], echoID)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	msg = append(msg, "zos probe"...)
+
+	if typ == icmp4Echo {
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+
+	return msg
+}
+
+// ICMP sends an ICMP echo request to ip and waits for its reply
+func ICMP(ip net.IP, timeout time.Duration) (Result, error) {
+	network, request, reply := "ip4:icmp", uint8(icmp4Echo), uint8(icmp4EchoReply)
+	if ip.To4() == nil {
+		network, request, reply = "ip6:ipv6-icmp", icmp6Echo, icmp6EchoReply
+	}
+
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return Result{}, errors.Wrap(err, "failed to open icmp socket")
+	}
+	defer conn.Close()
+
+	seq := uint16(atomic.AddUint32(&echoSeq, 1))
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return Result{}, err
+	}
+
+	if _, err := conn.WriteTo(echo(request, seq), &net.IPAddr{IP: ip}); err != nil {
+		// no route, or rejected by the firewall of the namespace
+		return Result{Detail: err.Error()}, nil
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return Result{Detail: "no echo reply"}, nil
+		} else if err != nil {
+			return Result{}, err
+		}
+
+		// the socket receives all the icmp messages of the namespace
+		if n < 8 || buf[0] != reply {
+			continue
+		}
+		if binary.BigEndian.Uint16(buf[4:]) != echoID || binary.BigEndian.Uint16(buf[6:]) != seq {
+			continue
+		}
+
+		return Result{Reachable: true, Detail: fmt.Sprintf("echo reply from %s", peer)}, nil
+	}
+}
+
+// TCP opens a TCP connection to port of ip, a connection refused by the
+// target reached it
+func TCP(ip net.IP, port uint16, timeout time.Duration) Result {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), timeout)
+	if err == nil {
+		conn.Close()
+		return Result{Reachable: true, Detail: "connected"}
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return Result{Reachable: true, Detail: "connection refused by the target"}
+	}
+
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return Result{Detail: "no answer"}
+	}
+
+	return Result{Detail: err.Error()}
+}
@@ -0,0 +1,44 @@
+package probe
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	msg := echo(icmp4Echo, 1)
+	// the checksum of a message with its checksum is 0
+	assert.Equal(t, uint16(0), checksum(msg))
+	assert.Equal(t, uint16(0), checksum(append(msg, 0)))
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+
+	result := TCP(net.ParseIP("127.0.0.1"), port, time.Second)
+	assert.True(t, result.Reachable)
+	assert.Equal(t, "connected", result.Detail)
+
+	// nothing listens anymore, the connection is refused
+	require.NoError(t, l.Close())
+	result = TCP(net.ParseIP("127.0.0.1"), port, time.Second)
+	assert.True(t, result.Reachable)
+	assert.Equal(t, "connection refused by the target", result.Detail)
+}
+
+func TestICMP(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	result, err := ICMP(net.ParseIP("127.0.0.1"), time.Second)
+	require.NoError(t, err)
+	assert.True(t, result.Reachable, result.Detail)
+}
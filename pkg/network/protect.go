@@ -0,0 +1,168 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/nft"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+const (
+	protectTable = "protect"
+
+	counterSynFlood  = "syn_flood"
+	counterConnLimit = "conn_limit"
+)
+
+// PublicProtection is the DoS protection configuration
+// of the public namespace
+type PublicProtection struct {
+	// ConntrackMax is the maximum number of tracked connections
+	// of the node, 0 keeps the kernel default
+	ConntrackMax int
+	// SynRate is the number of new TCP connections per second
+	// accepted from a single source, 0 disables the limit
+	SynRate uint
+	// SynBurst is the number of new TCP connections a source
+	// can open above SynRate before being limited
+	SynBurst uint
+	// ConnLimit is the maximum number of concurrent connections
+	// of a single source, 0 disables the limit
+	ConnLimit uint
+}
+
+// DefaultPublicProtection is the protection applied if
+// nothing else is configured
+var DefaultPublicProtection = PublicProtection{
+	ConntrackMax: 262144,
+	SynRate:      100,
+	SynBurst:     200,
+	ConnLimit:    1000,
+}
+
+var protectTmpl = template.Must(template.New("").Parse(`
+table inet protect {}
+delete table inet protect
+
+table inet protect {
+  counter syn_flood {}
+  counter conn_limit {}
+{{- if .SynRate}}
+
+  set syn4 { type ipv4_addr; flags dynamic, timeout; timeout 1m; }
+  set syn6 { type ipv6_addr; flags dynamic, timeout; timeout 1m; }
+{{- end}}
+{{- if .ConnLimit}}
+
+  set conn4 { type ipv4_addr; flags dynamic; }
+  set conn6 { type ipv6_addr; flags dynamic; }
+{{- end}}
+
+  chain protect {
+    ct state {established, related} accept
+{{- if .SynRate}}
+    meta nfproto ipv4 tcp flags & (fin|syn|rst|ack) == syn update @syn4 { ip saddr limit rate over {{.SynRate}}/second burst {{.SynBurst}} packets } counter name "syn_flood" drop
+    meta nfproto ipv6 tcp flags & (fin|syn|rst|ack) == syn update @syn6 { ip6 saddr limit rate over {{.SynRate}}/second burst {{.SynBurst}} packets } counter name "syn_flood" drop
+{{- end}}
+{{- if .ConnLimit}}
+    meta nfproto ipv4 ct state new add @conn4 { ip saddr ct count over {{.ConnLimit}} } counter name "conn_limit" drop
+    meta nfproto ipv6 ct state new add @conn6 { ip6 saddr ct count over {{.ConnLimit}} } counter name "conn_limit" drop
+{{- end}}
+  }
+
+  chain input {
+    type filter hook input priority -10; policy accept;
+    iifname "public" jump protect
+  }
+
+  chain forward {
+    type filter hook forward priority -10; policy accept;
+    iifname "public" jump protect
+  }
+}
+`))
+
+// ProtectPublicNS installs the connection limits and SYN flood protection
+// rules in the public namespace. The rules cover the traffic to the
+// gateway services running in the namespace and the forwarded traffic
+func ProtectPublicNS(p PublicProtection) error {
+	// the conntrack table is shared by all the namespaces
+	const conntrackMax = "net.netfilter.nf_conntrack_max"
+	if p.ConntrackMax > 0 {
+		if err := sysctl.Set(sysctl.Host, conntrackMax, fmt.Sprint(p.ConntrackMax)); err != nil {
+			return errors.Wrap(err, "failed to set conntrack max")
+		}
+	} else if err := sysctl.Restore(sysctl.Host, conntrackMax); err != nil {
+		return errors.Wrap(err, "failed to restore conntrack max")
+	}
+
+	if p.SynBurst < p.SynRate {
+		p.SynBurst = p.SynRate
+	}
+
+	if err := sysctl.Set(types.PublicNamespace, "net.ipv4.tcp_syncookies", "1"); err != nil {
+		return errors.Wrap(err, "failed to enable syn cookies")
+	}
+
+	var buf bytes.Buffer
+	if err := protectTmpl.Execute(&buf, p); err != nil {
+		return errors.Wrap(err, "failed to build protection rule set")
+	}
+
+	if err := nft.Apply(&buf, types.PublicNamespace); err != nil {
+		return errors.Wrap(err, "failed to apply protection rule set")
+	}
+
+	return nil
+}
+
+// publicFloodCounters reads the protection counters of the public namespace
+func publicFloodCounters() (pkg.FloodCounters, error) {
+	cmd := exec.Command("ip", "netns", "exec", types.PublicNamespace, "nft", "-j", "list", "counters", "table", "inet", protectTable)
+	out, err := cmd.Output()
+	if err != nil {
+		return pkg.FloodCounters{}, errors.Wrap(err, "failed to list protection counters")
+	}
+
+	return parseFloodCounters(out)
+}
+
+func parseFloodCounters(data []byte) (pkg.FloodCounters, error) {
+	var list struct {
+		Nftables []struct {
+			Counter *struct {
+				Name    string `json:"name"`
+				Table   string `json:"table"`
+				Packets uint64 `json:"packets"`
+				Bytes   uint64 `json:"bytes"`
+			} `json:"counter"`
+		} `json:"nftables"`
+	}
+
+	var counters pkg.FloodCounters
+	if err := json.Unmarshal(data, &list); err != nil {
+		return counters, errors.Wrap(err, "failed to decode nft counters")
+	}
+
+	for _, obj := range list.Nftables {
+		if obj.Counter == nil || obj.Counter.Table != protectTable {
+			continue
+		}
+
+		switch obj.Counter.Name {
+		case counterSynFlood:
+			counters.SynDropped = obj.Counter.Packets
+		case counterConnLimit:
+			counters.ConnLimited = obj.Counter.Packets
+		}
+	}
+
+	return counters, nil
+}
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestProtectTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, protectTmpl.Execute(&buf, DefaultPublicProtection))
+	rules := buf.String()
+
+	assert.Contains(t, rules, "update @syn4 { ip saddr limit rate over 100/second burst 200 packets }")
+	assert.Contains(t, rules, "add @conn6 { ip6 saddr ct count over 1000 }")
+	assert.Contains(t, rules, `iifname "public" jump protect`)
+
+	buf.Reset()
+	require.NoError(t, protectTmpl.Execute(&buf, PublicProtection{}))
+	rules = buf.String()
+
+	assert.NotContains(t, rules, "@syn4")
+	assert.NotContains(t, rules, "@conn4")
+	assert.Contains(t, rules, "counter syn_flood {}")
+}
+
+func TestParseFloodCounters(t *testing.T) {
+	data := []byte(`{"nftables": [
+		{"metainfo": {"version": "0.9.3", "json_schema_version": 1}},
+		{"counter": {"family": "inet", "name": "syn_flood", "table": "protect", "handle": 1, "packets": 12, "bytes": 720}},
+		{"counter": {"family": "inet", "name": "conn_limit", "table": "protect", "handle": 2, "packets": 3, "bytes": 180}},
+		{"counter": {"family": "inet", "name": "syn_flood", "table": "other", "handle": 1, "packets": 99, "bytes": 0}}
+	]}`)
+
+	counters, err := parseFloodCounters(data)
+	require.NoError(t, err)
+	assert.Equal(t, pkg.FloodCounters{SynDropped: 12, ConnLimited: 3}, counters)
+}
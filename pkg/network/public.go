@@ -10,7 +10,9 @@ import (
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
 	"github.com/threefoldtech/zos/pkg/network/macvlan"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
 	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/threefoldtech/zos/pkg/network/types"
 	"github.com/vishvananda/netlink"
 )
@@ -38,6 +40,11 @@ func CreatePublicNS(iface *types.PubIface, nodeID pkg.Identifier) error {
 		}
 		defer pubNS.Close()
 
+		// the public interface gets the MTU of its master
+		if err := setMasterMTU(iface.Master); err != nil {
+			return err
+		}
+
 		switch iface.Type {
 		case types.MacVlanIface:
 			pubIface, err = macvlan.Create(types.PublicIface, iface.Master, pubNS)
@@ -96,16 +103,39 @@ func CreatePublicNS(iface *types.PubIface, nodeID pkg.Identifier) error {
 		ips = append(ips, &iface.IPv4.IPNet)
 	}
 
-	if len(ips) <= 0 || len(routes) <= 0 {
+	autoconf := iface.IPv6.Nil()
+	if (len(ips) <= 0 || len(routes) <= 0) && !autoconf {
 		err := fmt.Errorf("missing some information in the exit iface object")
 		log.Error().Err(err).Msg("failed to configure public interface")
 		return err
 	}
 
+	// the public address must be stable, whether it's static or from SLAAC
+	if err := ifaceutil.SetIPv6Policy(types.PublicNamespace, types.PublicIface, ifaceutil.DefaultIPv6Policy); err != nil {
+		return errors.Wrap(err, "failed to set IPv6 policy of public interface")
+	}
+
+	if autoconf {
+		log.Info().Msg("no static IPv6 address, configure public interface with SLAAC")
+		if err := configureSLAAC(types.PublicNamespace, types.PublicIface); err != nil {
+			return errors.Wrap(err, "failed to configure SLAAC on public interface")
+		}
+	}
+
 	if err := macvlan.Install(pubIface, mac, ips, routes, pubNS); err != nil {
 		return err
 	}
 
+	if !autoconf {
+		// a static address used by another host of the segment is an error,
+		// not a silently unusable address
+		if err := pubNS.Do(func(_ ns.NetNS) error {
+			return ifaceutil.WaitDAD(pubIface, iface.IPv6.IP, ifaceutil.DADTimeout)
+		}); err != nil {
+			return errors.Wrap(err, "public IPv6 address is not usable")
+		}
+	}
+
 	master, err := netlink.LinkByName(iface.Master)
 	if err != nil {
 		return err
@@ -116,3 +146,46 @@ func CreatePublicNS(iface *types.PubIface, nodeID pkg.Identifier) error {
 
 	return nil
 }
+
+// setMasterMTU sets the MTU of the farm network on the master of the public
+// interface if the farm network uses jumbo frames
+func setMasterMTU(name string) error {
+	if !mtu.Jumbo() {
+		return nil
+	}
+
+	master, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	if master.Attrs().MTU == mtu.Farm() {
+		return nil
+	}
+
+	if err := netlink.LinkSetMTU(master, mtu.Farm()); err != nil {
+		return errors.Wrapf(err, "failed to set mtu of %s", name)
+	}
+
+	return nil
+}
+
+// configureSLAAC makes the interface name of the namespace netns configure
+// its IPv6 address and default route from the router advertisements, the
+// public interface is configured this way when no static IPv6 address is set
+func configureSLAAC(netns, name string) error {
+	// accept_ra is 2 so the advertisements are still used if forwarding
+	// is enabled in the namespace
+	for key, value := range map[string]string{
+		"accept_ra":        "2",
+		"accept_ra_defrtr": "1",
+		"accept_ra_pinfo":  "1",
+		"autoconf":         "1",
+	} {
+		if err := sysctl.Set(netns, fmt.Sprintf("net.ipv6.conf.%s.%s", name, key), value); err != nil {
+			return errors.Wrapf(err, "failed to set %s", key)
+		}
+	}
+
+	return nil
+}
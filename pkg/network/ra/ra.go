@@ -0,0 +1,270 @@
+// Package ra listens to the IPv6 router advertisements received on an
+// interface.
+//
+// The kernel configures the addresses (SLAAC) and the default route from the
+// advertisements on its own, the advertisements are only read to know how the
+// segment is configured: if the addresses or the other settings are assigned
+// with DHCPv6, and when the prefixes or the routers change.
+package ra
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	typeRouterSolicitation  = 133
+	typeRouterAdvertisement = 134
+
+	optionPrefixInformation = 3
+	optionMTU               = 5
+
+	flagManaged    = 0x80
+	flagOther      = 0x40
+	flagOnLink     = 0x80
+	flagAutonomous = 0x40
+
+	// headerLen is the length of an advertisement without its options
+	headerLen = 16
+	// readTimeout is the time a read waits for an advertisement before
+	// checking if the listener is canceled
+	readTimeout = time.Second
+)
+
+// Prefix is a prefix advertised by a router
+type Prefix struct {
+	Prefix net.IPNet
+	// OnLink means the addresses of the prefix are on the segment
+	OnLink bool
+	// Autonomous means the prefix is used to configure addresses (SLAAC)
+	Autonomous        bool
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// Advertisement is a router advertisement
+type Advertisement struct {
+	// Router is the link local address of the router
+	Router net.IP
+	// Managed means the addresses are assigned with DHCPv6
+	Managed bool
+	// Other means the other settings (resolvers) are given with DHCPv6
+	Other bool
+	// RouterLifetime is the time the router is a default router, 0 if it
+	// is not a default router
+	RouterLifetime time.Duration
+	Prefixes       []Prefix
+	// MTU of the segment, 0 if the router doesn't advertise it
+	MTU uint32
+}
+
+// Equal returns true if a and b advertise the same configuration. The
+// lifetimes are not compared, they are refreshed by every advertisement
+func (a *Advertisement) Equal(b *Advertisement) bool {
+	if !a.Router.Equal(b.Router) || a.Managed != b.Managed || a.Other != b.Other ||
+		(a.RouterLifetime == 0) != (b.RouterLifetime == 0) || a.MTU != b.MTU ||
+		len(a.Prefixes) != len(b.Prefixes) {
+		return false
+	}
+
+	for i := range a.Prefixes {
+		pa, pb := a.Prefixes[i], b.Prefixes[i]
+		if pa.Prefix.String() != pb.Prefix.String() || pa.OnLink != pb.OnLink ||
+			pa.Autonomous != pb.Autonomous || (pa.ValidLifetime == 0) != (pb.ValidLifetime == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func seconds(s uint32) time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+// Parse decodes the ICMPv6 message msg received from router
+func Parse(router net.IP, msg []byte) (Advertisement, error) {
+	ra := Advertisement{Router: router}
+	if len(msg) < headerLen || msg[0] != typeRouterAdvertisement || msg[1] != 0 {
+		return ra, fmt.Errorf("not a router advertisement")
+	}
+
+	ra.Managed = msg[5]&flagManaged != 0
+	ra.Other = msg[5]&flagOther != 0
+	ra.RouterLifetime = seconds(uint32(binary.BigEndian.Uint16(msg[6:8])))
+
+	options := msg[headerLen:]
+	for len(options) != 0 {
+		if len(options) < 2 {
+			return ra, fmt.Errorf("truncated option")
+		}
+
+		// the length of an option is in units of 8 bytes
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			return ra, fmt.Errorf("invalid option length %d", length)
+		}
+		option := options[:length]
+		options = options[length:]
+
+		switch option[0] {
+		case optionPrefixInformation:
+			if length != 32 || option[2] > 128 {
+				return ra, fmt.Errorf("invalid prefix information option")
+			}
+
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, option[16:32])
+			mask := net.CIDRMask(int(option[2]), 128)
+			ra.Prefixes = append(ra.Prefixes, Prefix{
+				Prefix:            net.IPNet{IP: ip.Mask(mask), Mask: mask},
+				OnLink:            option[3]&flagOnLink != 0,
+				Autonomous:        option[3]&flagAutonomous != 0,
+				ValidLifetime:     seconds(binary.BigEndian.Uint32(option[4:8])),
+				PreferredLifetime: seconds(binary.BigEndian.Uint32(option[8:12])),
+			})
+		case optionMTU:
+			if length != 8 {
+				return ra, fmt.Errorf("invalid mtu option")
+			}
+			ra.MTU = binary.BigEndian.Uint32(option[4:8])
+		}
+	}
+
+	return ra, nil
+}
+
+// socket opens an ICMPv6 socket on iface that only receives router
+// advertisements
+func socket(iface *net.Interface) (net.PacketConn, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open icmpv6 socket")
+	}
+
+	file := os.NewFile(uintptr(fd), "ra")
+	defer file.Close()
+
+	if err := unix.BindToDevice(fd, iface.Name); err != nil {
+		return nil, errors.Wrapf(err, "failed to bind to %s", iface.Name)
+	}
+
+	var filter unix.ICMPv6Filter
+	for i := range filter.Data {
+		// a bit set blocks the type
+		filter.Data[i] = 0xffffffff
+	}
+	filter.Data[typeRouterAdvertisement>>5] &^= 1 << (typeRouterAdvertisement & 31)
+	if err := unix.SetsockoptICMPv6Filter(fd, unix.SOL_ICMPV6, unix.ICMPV6_FILTER, &filter); err != nil {
+		return nil, errors.Wrap(err, "failed to filter router advertisements")
+	}
+
+	// the solicitations must be sent with a hop limit of 255
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return nil, err
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, iface.Index); err != nil {
+		return nil, err
+	}
+
+	// the connection uses a copy of the file descriptor
+	return net.FilePacketConn(file)
+}
+
+// solicit asks the routers of the segment to advertise now
+func solicit(conn net.PacketConn, iface *net.Interface) error {
+	// the kernel computes the checksum of the icmpv6 messages
+	msg := []byte{typeRouterSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	// the zone is the index of the interface, its name would be looked up
+	// in the namespace of the caller
+	_, err := conn.WriteTo(msg, &net.IPAddr{IP: net.ParseIP("ff02::2"), Zone: strconv.Itoa(iface.Index)})
+	return err
+}
+
+// Listen streams the router advertisements received on the interface iface
+// of the namespace netns (the host namespace if empty) until ctx is canceled.
+// The routers are solicited first, so the configuration of the segment is
+// known without waiting for the next periodic advertisement.
+func Listen(ctx context.Context, netns, iface string) (<-chan Advertisement, error) {
+	var (
+		conn net.PacketConn
+		link *net.Interface
+	)
+
+	open := func(_ ns.NetNS) (err error) {
+		link, err = net.InterfaceByName(iface)
+		if err != nil {
+			return err
+		}
+		// the socket stays in the namespace it's created in
+		conn, err = socket(link)
+		return err
+	}
+
+	if len(netns) == 0 {
+		if err := open(nil); err != nil {
+			return nil, err
+		}
+	} else {
+		netNS, err := namespace.GetByName(netns)
+		if err != nil {
+			return nil, err
+		}
+		defer netNS.Close()
+
+		if err := netNS.Do(open); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := solicit(conn, link); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to solicit routers")
+	}
+
+	ch := make(chan Advertisement)
+	go func() {
+		defer close(ch)
+		defer conn.Close()
+
+		buf := make([]byte, 1500)
+		for ctx.Err() == nil {
+			_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+			n, addr, err := conn.ReadFrom(buf)
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				continue
+			} else if err != nil {
+				return
+			}
+
+			// the advertisements are only sent from link local addresses,
+			// which are never routed to the segment
+			router := addr.(*net.IPAddr).IP
+			if !router.IsLinkLocalUnicast() {
+				continue
+			}
+
+			ra, err := Parse(router, buf[:n])
+			if err != nil {
+				continue
+			}
+
+			select {
+			case ch <- ra:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
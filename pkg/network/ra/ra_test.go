@@ -0,0 +1,95 @@
+package ra
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func advertisement(flags byte, options ...[]byte) []byte {
+	msg := []byte{
+		typeRouterAdvertisement, 0, 0, 0, // type, code, checksum
+		64, flags, 0x07, 0x08, // hop limit, flags, router lifetime (1800s)
+		0, 0, 0, 0, // reachable time
+		0, 0, 0, 0, // retrans timer
+	}
+	for _, option := range options {
+		msg = append(msg, option...)
+	}
+
+	return msg
+}
+
+func prefixOption(prefix string, length, flags byte, valid, preferred byte) []byte {
+	option := []byte{
+		optionPrefixInformation, 4, length, flags,
+		0, 0, 0, valid,
+		0, 0, 0, preferred,
+		0, 0, 0, 0,
+	}
+
+	return append(option, net.ParseIP(prefix).To16()...)
+}
+
+func TestParse(t *testing.T) {
+	router := net.ParseIP("fe80::1")
+	msg := advertisement(flagManaged,
+		prefixOption("2a02:1807:1100::1", 64, flagOnLink|flagAutonomous, 120, 60),
+		[]byte{optionMTU, 1, 0, 0, 0, 0, 0x05, 0xdc},
+		// unknown options are skipped
+		[]byte{25, 1, 0, 0, 0, 0, 0, 0},
+	)
+
+	ra, err := Parse(router, msg)
+	require.NoError(t, err)
+	assert.True(t, ra.Router.Equal(router))
+	assert.True(t, ra.Managed)
+	assert.False(t, ra.Other)
+	assert.Equal(t, 1800*time.Second, ra.RouterLifetime)
+	assert.Equal(t, uint32(1500), ra.MTU)
+
+	require.Len(t, ra.Prefixes, 1)
+	prefix := ra.Prefixes[0]
+	assert.Equal(t, "2a02:1807:1100::/64", prefix.Prefix.String())
+	assert.True(t, prefix.OnLink)
+	assert.True(t, prefix.Autonomous)
+	assert.Equal(t, 120*time.Second, prefix.ValidLifetime)
+	assert.Equal(t, 60*time.Second, prefix.PreferredLifetime)
+}
+
+func TestParseInvalid(t *testing.T) {
+	router := net.ParseIP("fe80::1")
+	for _, msg := range [][]byte{
+		nil,
+		{typeRouterSolicitation, 0, 0, 0, 0, 0, 0, 0},
+		advertisement(0, []byte{optionMTU, 0}),
+		advertisement(0, []byte{optionMTU, 2, 0, 0, 0, 0, 0, 0}),
+		advertisement(0, prefixOption("2a02:1807:1100::", 129, 0, 0, 0)),
+	} {
+		_, err := Parse(router, msg)
+		assert.Error(t, err, "%v", msg)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	router := net.ParseIP("fe80::1")
+	a, err := Parse(router, advertisement(0, prefixOption("2a02:1807:1100::", 64, flagAutonomous, 120, 60)))
+	require.NoError(t, err)
+
+	// refreshed lifetimes don't change the configuration
+	b, err := Parse(router, advertisement(0, prefixOption("2a02:1807:1100::", 64, flagAutonomous, 100, 40)))
+	require.NoError(t, err)
+	assert.True(t, a.Equal(&b))
+
+	// a deprecated prefix does
+	b, err = Parse(router, advertisement(0, prefixOption("2a02:1807:1100::", 64, flagAutonomous, 0, 0)))
+	require.NoError(t, err)
+	assert.False(t, a.Equal(&b))
+
+	b, err = Parse(router, advertisement(flagOther, prefixOption("2a02:1807:1100::", 64, flagAutonomous, 120, 60)))
+	require.NoError(t, err)
+	assert.False(t, a.Equal(&b))
+}
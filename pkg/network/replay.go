@@ -0,0 +1,119 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/network/nr"
+	"github.com/threefoldtech/zos/pkg/network/oplog"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+// replay applies again the operations interrupted by a crash of networkd.
+// An operation that can't be applied again is rolled back to the network
+// stored before it started. It runs before networkd serves any request
+func (n *networker) replay() {
+	entries, err := n.oplog.Pending()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read operation log, interrupted operations are not replayed")
+		return
+	}
+
+	for _, entry := range entries {
+		logger := log.With().
+			Str("operation", entry.Operation).
+			Str("network-id", string(entry.NetID)).
+			Time("started", entry.Started).
+			Logger()
+
+		logger.Warn().Msg("replaying interrupted operation")
+		err := n.redo(entry)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to replay operation, rolling back")
+			err = n.rollback(entry)
+		}
+
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to roll back operation, network may be inconsistent")
+		}
+
+		n.audit.Record("Replay"+entry.Operation, audit.System, string(entry.NetID), entry.Args, err)
+
+		if err := n.oplog.Remove(entry.ID); err != nil {
+			logger.Error().Err(err).Msg("failed to remove operation from the log")
+		}
+	}
+}
+
+// redo applies the operation of entry again, all the operations are safe
+// to apply more than once
+func (n *networker) redo(entry oplog.Entry) error {
+	switch entry.Operation {
+	case "CreateNR":
+		var network pkg.Network
+		if err := json.Unmarshal(entry.Args, &network); err != nil {
+			return errors.Wrap(err, "failed to decode network")
+		}
+		_, err := n.createNR(context.Background(), network)
+		return err
+	case "DeleteNR":
+		var network pkg.Network
+		if err := json.Unmarshal(entry.Args, &network); err != nil {
+			return errors.Wrap(err, "failed to decode network")
+		}
+		return n.deleteNR(network)
+	case "AddPeer":
+		var peer pkg.Peer
+		if err := json.Unmarshal(entry.Args, &peer); err != nil {
+			return errors.Wrap(err, "failed to decode peer")
+		}
+		return n.updateNR(entry.NetID, func(netr *nr.NetResource) error {
+			return netr.AddPeer(peer)
+		})
+	case "RemovePeer":
+		var prefix types.IPNet
+		if err := json.Unmarshal(entry.Args, &prefix); err != nil {
+			return errors.Wrap(err, "failed to decode peer prefix")
+		}
+		return n.updateNR(entry.NetID, func(netr *nr.NetResource) error {
+			return netr.RemovePeer(prefix)
+		})
+	case "SetEgressPolicy":
+		var policy *pkg.EgressPolicy
+		if err := json.Unmarshal(entry.Args, &policy); err != nil {
+			return errors.Wrap(err, "failed to decode egress policy")
+		}
+		return n.updateNR(entry.NetID, func(netr *nr.NetResource) error {
+			return netr.SetEgressPolicy(policy)
+		})
+	}
+
+	return fmt.Errorf("unknown operation '%s'", entry.Operation)
+}
+
+// rollback brings the network of entry back to the network stored before
+// the operation started
+func (n *networker) rollback(entry oplog.Entry) error {
+	if entry.Previous != nil {
+		_, err := n.createNR(context.Background(), *entry.Previous)
+		return err
+	}
+
+	// the network was not deployed before the operation, only a
+	// CreateNR can have left something behind
+	if entry.Operation != "CreateNR" {
+		return nil
+	}
+
+	var network pkg.Network
+	if err := json.Unmarshal(entry.Args, &network); err != nil {
+		return errors.Wrap(err, "failed to decode network")
+	}
+
+	return n.deleteNR(network)
+}
@@ -0,0 +1,268 @@
+// Package sockstat summarizes the sockets of a network namespace, like ss
+// does.
+//
+// The sockets are read from the proc files of the network namespace of the
+// calling thread, so the summary of a namespace is read by entering it.
+package sockstat
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// root is the proc directory of the network namespace of the calling
+// thread, /proc/net is the one of the process
+const root = "/proc/thread-self/net"
+
+// tcpStates are the names of the TCP states, by their value in the proc
+// files
+var tcpStates = map[uint64]string{
+	0x01: "established",
+	0x02: "syn_sent",
+	0x03: "syn_recv",
+	0x04: "fin_wait1",
+	0x05: "fin_wait2",
+	0x06: "time_wait",
+	0x07: "close",
+	0x08: "close_wait",
+	0x09: "last_ack",
+	0x0a: "listen",
+	0x0b: "closing",
+	0x0c: "new_syn_recv",
+}
+
+const (
+	stateEstablished = 0x01
+	// stateClose is the state of the UDP sockets that are not connected
+	stateClose  = 0x07
+	stateListen = 0x0a
+)
+
+// socket is an entry of a proc socket table
+type socket struct {
+	local net.IP
+	port  uint16
+	state uint64
+	// retransmits is the number of unrecovered retransmission timeouts
+	retransmits uint64
+}
+
+// parseAddr parses an address of a proc socket table. The address is
+// written as 32 bits words in host order, which is little endian on all the
+// platforms the node runs on
+func parseAddr(value string) (net.IP, uint16, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid address '%s'", value)
+	}
+
+	data, err := hex.DecodeString(parts[0])
+	if err != nil || (len(data) != net.IPv4len && len(data) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address '%s'", value)
+	}
+
+	for i := 0; i < len(data); i += 4 {
+		data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in address '%s'", value)
+	}
+
+	return net.IP(data), uint16(port), nil
+}
+
+// parseSockets parses a proc socket table (tcp, tcp6, udp, udp6)
+func parseSockets(r io.Reader) ([]socket, error) {
+	var sockets []socket
+
+	scanner := bufio.NewScanner(r)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		var (
+			s   socket
+			err error
+		)
+		if s.local, s.port, err = parseAddr(fields[1]); err != nil {
+			return nil, err
+		}
+		if _, _, err = parseAddr(fields[2]); err != nil {
+			return nil, err
+		}
+		if s.state, err = strconv.ParseUint(fields[3], 16, 8); err != nil {
+			return nil, fmt.Errorf("invalid socket state '%s'", fields[3])
+		}
+		if s.retransmits, err = strconv.ParseUint(fields[6], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid socket retransmits '%s'", fields[6])
+		}
+
+		sockets = append(sockets, s)
+	}
+
+	return sockets, scanner.Err()
+}
+
+// parseSNMP parses the counters of the protocol proto in the snmp proc
+// file. The file has two lines per protocol, the names of the counters
+// then their values
+func parseSNMP(r io.Reader, proto string) (map[string]uint64, error) {
+	prefix := proto + ":"
+
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != prefix {
+			continue
+		}
+
+		if names == nil {
+			names = fields[1:]
+			continue
+		}
+
+		if len(fields[1:]) != len(names) {
+			return nil, fmt.Errorf("invalid %s counters", proto)
+		}
+
+		counters := make(map[string]uint64, len(names))
+		for i, name := range names {
+			// some counters like MaxConn can be negative
+			value, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s counter %s", proto, name)
+			}
+			if value > 0 {
+				counters[name] = uint64(value)
+			}
+		}
+		return counters, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("%s counters not found", proto)
+}
+
+func readSockets(dir, name string) ([]socket, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		// IPv6 is disabled
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sockets, err := parseSockets(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s sockets", name)
+	}
+
+	return sockets, nil
+}
+
+func read(dir string) (pkg.SocketStats, error) {
+	stats := pkg.SocketStats{
+		TCPStates: make(map[string]int),
+	}
+
+	for _, proto := range []string{"tcp", "tcp6"} {
+		sockets, err := readSockets(dir, proto)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, s := range sockets {
+			state, ok := tcpStates[s.state]
+			if !ok {
+				state = fmt.Sprintf("unknown_%d", s.state)
+			}
+			stats.TCPStates[state]++
+
+			if s.state == stateListen {
+				stats.Listening = append(stats.Listening, pkg.ListeningSocket{
+					Protocol: "tcp",
+					Address:  s.local.String(),
+					Port:     s.port,
+				})
+			}
+			if s.retransmits > 0 {
+				stats.Retransmits.Sockets++
+			}
+		}
+	}
+
+	for _, proto := range []string{"udp", "udp6"} {
+		sockets, err := readSockets(dir, proto)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, s := range sockets {
+			switch {
+			case s.state == stateClose && s.port != 0:
+				stats.Listening = append(stats.Listening, pkg.ListeningSocket{
+					Protocol: "udp",
+					Address:  s.local.String(),
+					Port:     s.port,
+				})
+			case s.state == stateEstablished:
+				stats.UDPConnected++
+			}
+		}
+	}
+
+	sort.Slice(stats.Listening, func(i, j int) bool {
+		a, b := stats.Listening[i], stats.Listening[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Address < b.Address
+	})
+
+	f, err := os.Open(filepath.Join(dir, "snmp"))
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	counters, err := parseSNMP(f, "Tcp")
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Retransmits.OutSegments = counters["OutSegs"]
+	stats.Retransmits.RetransSegments = counters["RetransSegs"]
+
+	return stats, nil
+}
+
+// Read returns the summary of the sockets of the network namespace of the
+// calling thread, it must be locked to its thread
+func Read() (pkg.SocketStats, error) {
+	return read(root)
+}
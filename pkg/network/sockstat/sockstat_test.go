@@ -0,0 +1,93 @@
+package sockstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	tcp = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 662 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 663 1 0000000000000000 100 0 0 10 0
+   2: 0A01010A:0016 0A01020A:D431 01 00000000:00000000 02:00000010 00000000     0        0 664 1 0000000000000000 20 4 30 10 -1
+   3: 0A01010A:0016 0A01020B:D432 01 00000024:00000000 01:00000100 00000003     0        0 665 2 0000000000000000 20 4 30 10 -1
+   4: 0A01010A:0016 0A01020C:D433 06 00000000:00000000 03:00000100 00000000     0        0 0 3 0000000000000000
+`
+	tcp6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 700 1 0000000000000000 100 0 0 10 0
+`
+	udp = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 800 2 0000000000000000 0
+  101: 0A01010A:A2F1 0A01010B:0035 01 00000000:00000000 00:00000000 00000000     0        0 801 2 0000000000000000 0
+`
+	snmp = `Ip: Forwarding DefaultTTL InReceives
+Ip: 1 64 100
+Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens PassiveOpens AttemptFails EstabResets CurrEstab InSegs OutSegs RetransSegs InErrs OutRsts InCsumErrors
+Tcp: 1 200 120000 -1 14 8 0 6 2 18995 19141 12 0 2 0
+Udp: InDatagrams NoPorts InErrors OutDatagrams
+Udp: 10 0 0 10
+`
+)
+
+func TestParseAddr(t *testing.T) {
+	ip, port, err := parseAddr("0100007F:1F90")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", ip.String())
+	assert.Equal(t, uint16(8080), port)
+
+	ip, port, err = parseAddr("B80D0120000000000000000001000000:0050")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", ip.String())
+	assert.Equal(t, uint16(80), port)
+
+	for _, value := range []string{"0100007F", "0100007F:port", "01007F:0050"} {
+		_, _, err := parseAddr(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseSNMP(t *testing.T) {
+	counters, err := parseSNMP(strings.NewReader(snmp), "Tcp")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(19141), counters["OutSegs"])
+	assert.Equal(t, uint64(12), counters["RetransSegs"])
+	assert.NotContains(t, counters, "MaxConn")
+
+	_, err = parseSNMP(strings.NewReader(snmp), "Icmp")
+	assert.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sockstat")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// tcp6 is missing when IPv6 is disabled in the namespace
+	for name, content := range map[string]string{"tcp": tcp, "udp": udp, "snmp": snmp} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	stats, err := read(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.ListeningSocket{
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 22},
+		{Protocol: "tcp", Address: "127.0.0.1", Port: 8080},
+		{Protocol: "udp", Address: "0.0.0.0", Port: 53},
+	}, stats.Listening)
+	assert.Equal(t, map[string]int{"listen": 2, "established": 2, "time_wait": 1}, stats.TCPStates)
+	assert.Equal(t, 1, stats.UDPConnected)
+	assert.Equal(t, pkg.TCPRetransmits{OutSegments: 19141, RetransSegments: 12, Sockets: 1}, stats.Retransmits)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tcp6"), []byte(tcp6), 0644))
+	stats, err = read(dir)
+	require.NoError(t, err)
+	assert.Contains(t, stats.Listening, pkg.ListeningSocket{Protocol: "tcp", Address: "::", Port: 80})
+	assert.Equal(t, 3, stats.TCPStates["listen"])
+}
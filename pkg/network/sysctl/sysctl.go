@@ -0,0 +1,357 @@
+// Package sysctl sets the sysctls of the network module.
+//
+// The network sysctls belong to a network namespace, so the namespace of a
+// sysctl is always explicit: Set enters the namespace before writing,
+// instead of relying on the namespace of the calling thread, where a global
+// key like net.ipv6.conf.all.forwarding silently changes the host if the
+// thread is not where the caller thinks it is.
+//
+// Every sysctl set is recorded with the value it had before the module set
+// it the first time, so the host defaults can be restored when the module
+// stops using it. The records of a namespace go away with the namespace.
+package sysctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+	"golang.org/x/sys/unix"
+)
+
+// Host is the namespace networkd runs in
+const Host = ""
+
+// Record is a sysctl set by the module
+type Record struct {
+	// Namespace of the sysctl, Host for the host namespace
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	// Default is the value of the sysctl before the module set it
+	Default string `json:"default"`
+	// Inode identifies the namespace, a namespace deleted and created
+	// again with the same name has new defaults
+	Inode uint64 `json:"inode"`
+}
+
+// Manager sets and records the sysctls
+type Manager struct {
+	mu      sync.Mutex
+	records map[string]Record
+	// path is where the records are saved, they are only kept in memory
+	// if empty
+	path string
+
+	// root, inode and enter are overridden in tests
+	root  string
+	inode func(namespace string) (uint64, error)
+	enter func(namespace string, fn func() error) error
+}
+
+// host is the namespace networkd started in
+var host ns.NetNS
+
+func init() {
+	var err error
+	if host, err = ns.GetCurrentNS(); err != nil {
+		log.Error().Err(err).Msg("failed to open host namespace")
+	}
+}
+
+func nsPath(name string) string {
+	if name == Host {
+		return "/proc/self/ns/net"
+	}
+	return filepath.Join("/var/run/netns", name)
+}
+
+// inode returns the inode of the namespace name
+func inode(name string) (uint64, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(nsPath(name), &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Ino, nil
+}
+
+// enter runs fn in the namespace name
+func enter(name string, fn func() error) error {
+	netNS := host
+	if name != Host {
+		var err error
+		if netNS, err = namespace.GetByName(name); err != nil {
+			return err
+		}
+		defer netNS.Close()
+	}
+
+	if netNS == nil {
+		return fmt.Errorf("host namespace is not available")
+	}
+
+	return netNS.Do(func(_ ns.NetNS) error {
+		return fn()
+	})
+}
+
+// New creates a manager of the sysctls of the kernel
+func New() *Manager {
+	return &Manager{
+		records: make(map[string]Record),
+		root:    "/proc/sys",
+		inode:   inode,
+		enter:   enter,
+	}
+}
+
+func recordKey(namespace, key string) string {
+	return namespace + "/" + key
+}
+
+// file returns the file of the sysctl key
+func (m *Manager) file(key string) string {
+	return filepath.Join(m.root, strings.Replace(key, ".", "/", -1))
+}
+
+func (m *Manager) read(key string) (string, error) {
+	data, err := ioutil.ReadFile(m.file(key))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (m *Manager) write(key, value string) error {
+	return ioutil.WriteFile(m.file(key), []byte(value), 0644)
+}
+
+// save writes the records to the state file. It must be called with the
+// lock held
+func (m *Manager) save() error {
+	if len(m.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(m.list())
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to save sysctl records")
+	}
+
+	return os.Rename(tmp, m.path)
+}
+
+// list returns the records sorted by namespace and key. It must be called
+// with the lock held
+func (m *Manager) list() []Record {
+	records := make([]Record, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Namespace != records[j].Namespace {
+			return records[i].Namespace < records[j].Namespace
+		}
+		return records[i].Key < records[j].Key
+	})
+
+	return records
+}
+
+// prune drops the records of the namespaces that were deleted, or deleted
+// and created again. It must be called with the lock held
+func (m *Manager) prune() {
+	inodes := make(map[string]uint64)
+	for key, record := range m.records {
+		current, ok := inodes[record.Namespace]
+		if !ok {
+			current, _ = m.inode(record.Namespace)
+			inodes[record.Namespace] = current
+		}
+
+		if current != record.Inode {
+			delete(m.records, key)
+		}
+	}
+}
+
+// Persist saves the records in the file path from now on. The records
+// already in the file were made by a previous run of the module, their
+// defaults are kept
+func (m *Manager) Persist(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read sysctl records")
+	}
+
+	if len(data) != 0 {
+		var previous []Record
+		if err := json.Unmarshal(data, &previous); err != nil {
+			log.Error().Err(err).Msg("invalid sysctl records, the defaults are lost")
+		}
+
+		for _, record := range previous {
+			key := recordKey(record.Namespace, record.Key)
+			if current, ok := m.records[key]; ok && current.Inode == record.Inode {
+				current.Default = record.Default
+				m.records[key] = current
+			} else if !ok {
+				m.records[key] = record
+			}
+		}
+	}
+
+	m.path = path
+	m.prune()
+	return m.save()
+}
+
+// Set sets the sysctl key to value in namespace, Host for the host
+// namespace. Nothing is written if the sysctl already has the value
+func (m *Manager) Set(namespace, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inode, err := m.inode(namespace)
+	if err != nil {
+		return errors.Wrapf(err, "namespace '%s' not found", namespace)
+	}
+
+	rkey := recordKey(namespace, key)
+	record, ok := m.records[rkey]
+	if ok && record.Inode != inode {
+		// the namespace was created again
+		ok = false
+	}
+
+	err = m.enter(namespace, func() error {
+		current, err := m.read(key)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			record = Record{Namespace: namespace, Key: key, Default: current, Inode: inode}
+		}
+
+		if current == value {
+			return nil
+		}
+
+		return m.write(key, value)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to set %s to %s in namespace '%s'", key, value, namespace)
+	}
+
+	record.Value = value
+	m.records[rkey] = record
+
+	return m.save()
+}
+
+// Restore sets the sysctl key of namespace back to its value before the
+// module set it, and forgets it. A sysctl that is gone, with its interface,
+// is only forgotten
+func (m *Manager) Restore(namespace, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+
+	rkey := recordKey(namespace, key)
+	record, ok := m.records[rkey]
+	if !ok {
+		return nil
+	}
+
+	err := m.enter(namespace, func() error {
+		if err := m.write(key, record.Default); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore %s in namespace '%s'", key, namespace)
+	}
+
+	delete(m.records, rkey)
+	return m.save()
+}
+
+// Records returns the sysctls set by the module. The sysctls that are gone,
+// with their namespace or their interface, are forgotten
+func (m *Manager) Records() []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+
+	namespaces := make(map[string][]string)
+	for key, record := range m.records {
+		namespaces[record.Namespace] = append(namespaces[record.Namespace], key)
+	}
+
+	for namespace, keys := range namespaces {
+		err := m.enter(namespace, func() error {
+			for _, key := range keys {
+				if _, err := os.Stat(m.file(m.records[key].Key)); os.IsNotExist(err) {
+					delete(m.records, key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Str("namespace", namespace).Msg("failed to check sysctls")
+		}
+	}
+
+	if err := m.save(); err != nil {
+		log.Error().Err(err).Msg("failed to save sysctl records")
+	}
+
+	return m.list()
+}
+
+var std = New()
+
+// Persist saves the records of the sysctls set by the module in path
+func Persist(path string) error {
+	return std.Persist(path)
+}
+
+// Set sets the sysctl key to value in namespace, Host for the host namespace
+func Set(namespace, key, value string) error {
+	return std.Set(namespace, key, value)
+}
+
+// Restore sets the sysctl key of namespace back to its value before the
+// module set it
+func Restore(namespace, key string) error {
+	return std.Restore(namespace, key)
+}
+
+// Records returns the sysctls set by the module
+func Records() []Record {
+	return std.Records()
+}
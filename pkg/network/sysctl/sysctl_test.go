@@ -0,0 +1,163 @@
+package sysctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testManager is a manager of the sysctls stored in the directories of a
+// temporary root, one per namespace
+func testManager(t *testing.T) (*Manager, map[string]uint64, func()) {
+	root, err := ioutil.TempDir("", "sysctl")
+	require.NoError(t, err)
+
+	inodes := map[string]uint64{Host: 1}
+	m := New()
+	m.inode = func(namespace string) (uint64, error) {
+		inode, ok := inodes[namespace]
+		if !ok {
+			return 0, os.ErrNotExist
+		}
+		return inode, nil
+	}
+	m.enter = func(namespace string, fn func() error) error {
+		m.root = filepath.Join(root, fmt.Sprint(inodes[namespace]))
+		return fn()
+	}
+
+	return m, inodes, func() { os.RemoveAll(root) }
+}
+
+func setValue(t *testing.T, m *Manager, namespace, key, value string) {
+	err := m.enter(namespace, func() error {
+		if err := os.MkdirAll(filepath.Dir(m.file(key)), 0755); err != nil {
+			return err
+		}
+		return m.write(key, value+"\n")
+	})
+	require.NoError(t, err)
+}
+
+func value(t *testing.T, m *Manager, namespace, key string) string {
+	var value string
+	err := m.enter(namespace, func() error {
+		var err error
+		value, err = m.read(key)
+		return err
+	})
+	require.NoError(t, err)
+	return value
+}
+
+func TestSet(t *testing.T) {
+	m, inodes, clean := testManager(t)
+	defer clean()
+
+	inodes["n1"] = 2
+	const forwarding = "net.ipv6.conf.all.forwarding"
+	setValue(t, m, Host, forwarding, "0")
+	setValue(t, m, "n1", forwarding, "0")
+
+	require.NoError(t, m.Set("n1", forwarding, "1"))
+	assert.Equal(t, "1", value(t, m, "n1", forwarding))
+	// the host is untouched
+	assert.Equal(t, "0", value(t, m, Host, forwarding))
+
+	// the default is the value before the first set
+	require.NoError(t, m.Set("n1", forwarding, "1"))
+	records := m.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, Record{Namespace: "n1", Key: forwarding, Value: "1", Default: "0", Inode: 2}, records[0])
+
+	assert.Error(t, m.Set("missing", forwarding, "1"))
+}
+
+func TestRestore(t *testing.T) {
+	m, _, clean := testManager(t)
+	defer clean()
+
+	const conntrack = "net.netfilter.nf_conntrack_max"
+	setValue(t, m, Host, conntrack, "65536")
+
+	require.NoError(t, m.Set(Host, conntrack, "262144"))
+	require.NoError(t, m.Set(Host, conntrack, "524288"))
+	assert.Equal(t, "524288", value(t, m, Host, conntrack))
+
+	require.NoError(t, m.Restore(Host, conntrack))
+	assert.Equal(t, "65536", value(t, m, Host, conntrack))
+	assert.Empty(t, m.Records())
+
+	// nothing to restore
+	require.NoError(t, m.Restore(Host, conntrack))
+}
+
+func TestPrune(t *testing.T) {
+	m, inodes, clean := testManager(t)
+	defer clean()
+
+	inodes["n1"] = 2
+	const (
+		forwarding = "net.ipv6.conf.all.forwarding"
+		disable    = "net.ipv6.conf.br0.disable_ipv6"
+	)
+	setValue(t, m, "n1", forwarding, "0")
+	setValue(t, m, Host, disable, "0")
+
+	require.NoError(t, m.Set("n1", forwarding, "1"))
+	require.NoError(t, m.Set(Host, disable, "1"))
+	assert.Len(t, m.Records(), 2)
+
+	// the namespace is created again
+	inodes["n1"] = 3
+	setValue(t, m, "n1", forwarding, "0")
+	// the interface is deleted
+	require.NoError(t, m.enter(Host, func() error {
+		return os.Remove(m.file(disable))
+	}))
+	assert.Empty(t, m.Records())
+
+	require.NoError(t, m.Set("n1", forwarding, "1"))
+	records := m.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, uint64(3), records[0].Inode)
+	assert.Equal(t, "0", records[0].Default)
+}
+
+func TestPersist(t *testing.T) {
+	m, _, clean := testManager(t)
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "sysctl-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sysctls.json")
+
+	const conntrack = "net.netfilter.nf_conntrack_max"
+	setValue(t, m, Host, conntrack, "65536")
+
+	require.NoError(t, m.Persist(path))
+	require.NoError(t, m.Set(Host, conntrack, "262144"))
+
+	// the module restarts, the value it set is not the default
+	restarted := New()
+	restarted.inode = m.inode
+	restarted.enter = func(namespace string, fn func() error) error {
+		return m.enter(namespace, func() error {
+			restarted.root = m.root
+			return fn()
+		})
+	}
+	require.NoError(t, restarted.Set(Host, conntrack, "524288"))
+	require.NoError(t, restarted.Persist(path))
+
+	records := restarted.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "65536", records[0].Default)
+	assert.Equal(t, "524288", records[0].Value)
+}
@@ -3,8 +3,9 @@ package tuntap
 import (
 	"fmt"
 
-	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
+	"github.com/threefoldtech/zos/pkg/network/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -19,7 +20,7 @@ func CreateTap(name string, master string) (*netlink.Tuntap, error) {
 
 	tap := &netlink.Tuntap{
 		LinkAttrs: netlink.LinkAttrs{
-			MTU:         1500,
+			MTU:         mtu.Farm(),
 			Name:        name,
 			ParentIndex: masterIface.Attrs().Index,
 		},
@@ -42,7 +43,7 @@ func CreateTap(name string, master string) (*netlink.Tuntap, error) {
 	}
 
 	disableIPv6Cmd := fmt.Sprintf(disableIPv6Template, name)
-	if _, err := sysctl.Sysctl(disableIPv6Cmd, "1"); err != nil {
+	if err := sysctl.Set(sysctl.Host, disableIPv6Cmd, "1"); err != nil {
 		return nil, errors.Wrap(err, "failed to disable ipv6 on interface host side")
 	}
 
@@ -10,7 +10,10 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/network/mtu"
+	"github.com/threefoldtech/zos/pkg/tpm"
 
 	"github.com/vishvananda/netlink"
 	"golang.zx2c4.com/wireguard/wgctrl"
@@ -22,11 +25,12 @@ type Wireguard struct {
 	attrs *netlink.LinkAttrs
 }
 
-// New create a new wireguard interface
+// New create a new wireguard interface, its encapsulated packets fit in the
+// MTU of the farm network
 func New(name string) (*Wireguard, error) {
 	attrs := netlink.NewLinkAttrs()
 	attrs.Name = name
-	attrs.MTU = 1420
+	attrs.MTU = mtu.Wireguard()
 
 	wg := &Wireguard{attrs: &attrs}
 	if err := netlink.LinkAdd(wg); err != nil && !os.IsExist(err) {
@@ -91,46 +95,55 @@ type Peer struct {
 	PublicKey  string
 	Endpoint   string
 	AllowedIPs []string
+	// PresharedKey is the optional base64 encoded preshared key
+	PresharedKey string
 }
 
-// Configure configures the wiregard configuration
-func (w *Wireguard) Configure(privateKey string, listentPort int, peers []*Peer) error {
-
-	if err := netlink.LinkSetDown(w); err != nil {
-		return err
-	}
-
-	wc, err := wgctrl.New()
-	if err != nil {
-		return err
-	}
-	defer wc.Close()
-
+// Config builds the wireguard device configuration from the private key,
+// listen port and list of peers. The configuration replaces all the peers
+// of the device
+func Config(privateKey string, listenPort int, peers []*Peer) (wgtypes.Config, error) {
 	peersConfig := make([]wgtypes.PeerConfig, len(peers))
 	for i, peer := range peers {
-		p, err := newPeer(peer.PublicKey, peer.Endpoint, peer.AllowedIPs)
+		p, err := PeerConfig(peer)
 		if err != nil {
-			return err
+			return wgtypes.Config{}, err
 		}
 		peersConfig[i] = p
 	}
 
 	key, err := wgtypes.ParseKey(privateKey)
 	if err != nil {
-		return err
+		return wgtypes.Config{}, err
 	}
 
-	for _, peer := range peersConfig {
-		fmt.Printf("%+v\n", peer)
-	}
-
-	config := wgtypes.Config{
+	return wgtypes.Config{
 		PrivateKey:   &key,
 		Peers:        peersConfig,
-		ListenPort:   &listentPort,
+		ListenPort:   &listenPort,
 		ReplacePeers: true,
+	}, nil
+}
+
+// Configure configures the wiregard configuration
+func (w *Wireguard) Configure(privateKey string, listentPort int, peers []*Peer) error {
+
+	config, err := Config(privateKey, listentPort, peers)
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetDown(w); err != nil {
+		return err
+	}
+
+	wc, err := wgctrl.New()
+	if err != nil {
+		return err
 	}
-	log.Info().Msg("configure wg device")
+	defer wc.Close()
+
+	logging.Component("wireguard").Info().Str("device", w.attrs.Name).Msg("configure wg device")
 
 	if err := wc.ConfigureDevice(w.attrs.Name, config); err != nil {
 		return errors.Wrap(err, "failed to configure wireguard interface")
@@ -142,6 +155,24 @@ func (w *Wireguard) Configure(privateKey string, listentPort int, peers []*Peer)
 	return nil
 }
 
+// PeerConfig builds the wireguard configuration of a single peer
+func PeerConfig(peer *Peer) (wgtypes.PeerConfig, error) {
+	config, err := newPeer(peer.PublicKey, peer.Endpoint, peer.AllowedIPs)
+	if err != nil {
+		return config, err
+	}
+
+	if peer.PresharedKey != "" {
+		psk, err := wgtypes.ParseKey(peer.PresharedKey)
+		if err != nil {
+			return config, errors.Wrap(err, "invalid preshared key")
+		}
+		config.PresharedKey = &psk
+	}
+
+	return config, nil
+}
+
 func newPeer(pubkey, endpoint string, allowedIPs []string) (wgtypes.PeerConfig, error) {
 	peer := wgtypes.PeerConfig{
 		ReplaceAllowedIPs: true,
@@ -157,20 +188,10 @@ func newPeer(pubkey, endpoint string, allowedIPs []string) (wgtypes.PeerConfig,
 	}
 
 	if endpoint != "" {
-		host, p, err := net.SplitHostPort(endpoint)
-		if err != nil {
-			return peer, err
-		}
-
-		port, err := strconv.Atoi(p)
+		peer.Endpoint, err = ParseEndpoint(endpoint)
 		if err != nil {
 			return peer, err
 		}
-
-		peer.Endpoint = &net.UDPAddr{
-			IP:   net.ParseIP(host),
-			Port: port,
-		}
 	}
 
 	for _, allowedIP := range allowedIPs {
@@ -185,39 +206,68 @@ func newPeer(pubkey, endpoint string, allowedIPs []string) (wgtypes.PeerConfig,
 	return peer, nil
 }
 
-// GenerateKey generates a new private key. If key already exists
-// in that location, that key is returned instead.
-func GenerateKey(dir string) (wgtypes.Key, error) {
-	path := filepath.Join(dir, "key.priv")
-	data, err := ioutil.ReadFile(path)
-	if err == nil {
-		//key already exists
-		return wgtypes.ParseKey(string(data))
-	} else if !os.IsNotExist(err) {
-		//another error than not exist
-		return wgtypes.Key{}, err
+// ParseEndpoint parses a wireguard endpoint in the host:port format
+func ParseEndpoint(endpoint string) (*net.UDPAddr, error) {
+	host, p, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
 	}
 
-	key, err := wgtypes.GeneratePrivateKey()
+	port, err := strconv.Atoi(p)
 	if err != nil {
-		return wgtypes.Key{}, err
+		return nil, err
 	}
+
+	return &net.UDPAddr{
+		IP:   net.ParseIP(host),
+		Port: port,
+	}, nil
+}
+
+// keyFile is the file of the private key in a key directory
+const keyFile = "key.priv"
+
+// GenerateKey generates a new private key. If key already exists
+// in that location, that key is returned instead. The key is sealed to
+// the TPM of the node if it has one
+func GenerateKey(dir string) (wgtypes.Key, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return wgtypes.Key{}, err
 	}
 
-	if err := ioutil.WriteFile(path, []byte(key.String()), 0400); err != nil {
-		return wgtypes.Key{}, err
-	}
-	return key, nil
+	return readKey(filepath.Join(dir, keyFile))
 }
 
 // LoadKey tries to read a private key from disk
 func LoadKey(dir string) (wgtypes.Key, error) {
-	path := filepath.Join(dir, "key.priv")
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
+	path := filepath.Join(dir, keyFile)
+	if _, err := os.Stat(path); err != nil {
 		return wgtypes.Key{}, err
 	}
-	return wgtypes.ParseKey(string(b))
+
+	return readKey(path)
+}
+
+// readKey reads the key at path with tpm.Key, the key is created if it
+// doesn't exist. The keys written before the TPM support are base64
+// encoded, they are converted first
+func readKey(path string) (wgtypes.Key, error) {
+	if data, err := ioutil.ReadFile(path); err == nil && len(data) != wgtypes.KeyLen {
+		if key, err := wgtypes.ParseKey(string(data)); err == nil {
+			tmp := path + ".tmp"
+			if err := ioutil.WriteFile(tmp, key[:], 0400); err != nil {
+				return wgtypes.Key{}, err
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				return wgtypes.Key{}, err
+			}
+		}
+	}
+
+	data, err := tpm.Key(path, wgtypes.KeyLen, tpm.Enabled(kernel.GetParams()))
+	if err != nil {
+		return wgtypes.Key{}, errors.Wrapf(err, "failed to read wireguard key '%s'", path)
+	}
+
+	return wgtypes.NewKey(data)
 }
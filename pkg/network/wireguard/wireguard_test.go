@@ -25,6 +25,27 @@ func TestNewPeer(t *testing.T) {
 	require.Equal(t, allowedIps, tmp)
 }
 
+func TestPeerConfigPresharedKey(t *testing.T) {
+	peer := &Peer{
+		PublicKey:  "mR5fBXohKe2MZ6v+GLwlKwrvkFxo1VvV3bPNHDBhOAI=",
+		AllowedIPs: []string{"172.21.0.0/24"},
+	}
+
+	config, err := PeerConfig(peer)
+	require.NoError(t, err)
+	assert.Nil(t, config.PresharedKey)
+
+	peer.PresharedKey = "4DwTbGRWECH8oqcTXdoWXGOaWWC952QKbFE1fMzBNmA="
+	config, err = PeerConfig(peer)
+	require.NoError(t, err)
+	require.NotNil(t, config.PresharedKey)
+	assert.Equal(t, peer.PresharedKey, config.PresharedKey.String())
+
+	peer.PresharedKey = "invalid"
+	_, err = PeerConfig(peer)
+	assert.Error(t, err)
+}
+
 func TestConfigure(t *testing.T) {
 	wg, err := New("test")
 	require.NoError(t, err)
@@ -0,0 +1,140 @@
+// Package zosip implements the nibble addressing scheme used to derive the
+// names and IPv4 subnets of a network resource from its IPv6 allocation.
+//
+// A farm gets an IPv6 allocation (a /40, /48 or /56 for example) and each
+// network resource gets a /64 out of it. The nibbles between the farm prefix
+// and the /64 identify the network resource inside the farm. They are used
+// as-is for the hex representation, and are mapped to an IPv4 /24 by a
+// Mapping strategy.
+package zosip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// subnetSize is the size of the prefix of a network resource
+	subnetSize = 64
+	// minFarmSize is the biggest farm allocation supported
+	minFarmSize = 16
+)
+
+// Mapping is the strategy used to map the allocation bits of a
+// network resource to its IPv4 /24 subnet
+type Mapping interface {
+	// ToV4 maps value, which is made of bits bits, to an IPv4 subnet
+	ToV4(value uint64, bits int) (net.IPNet, error)
+}
+
+// DirectMapping maps the allocation bits directly into the 10.0.0.0/8
+// range, it only supports up to 16 bits of allocation (/48 and smaller farms)
+type DirectMapping struct{}
+
+// ToV4 implements Mapping
+func (DirectMapping) ToV4(value uint64, bits int) (net.IPNet, error) {
+	if bits > 16 {
+		return net.IPNet{}, errors.Errorf("direct mapping supports up to 16 bits, got %d", bits)
+	}
+
+	return v4Subnet(uint16(value)), nil
+}
+
+// FoldMapping folds the allocation bits into 16 bits with xor so any
+// farm size can be mapped. Different allocations can end up with the same
+// IPv4 subnet, so it's never picked by default, the caller must make sure
+// the allocations of the farm don't collide to use it
+type FoldMapping struct{}
+
+// ToV4 implements Mapping
+func (FoldMapping) ToV4(value uint64, bits int) (net.IPNet, error) {
+	var folded uint16
+	for ; bits > 0; bits -= 16 {
+		folded ^= uint16(value)
+		value >>= 16
+	}
+
+	return v4Subnet(folded), nil
+}
+
+// DefaultMapping returns the mapping used for an allocation of bits bits.
+// Only the DirectMapping is collision free, so an allocation of more than
+// 16 bits (farms bigger than /48) needs an explicit mapping
+func DefaultMapping(bits int) (Mapping, error) {
+	if bits > 16 {
+		return nil, errors.Errorf("no default mapping for %d allocation bits, a mapping must be given", bits)
+	}
+
+	return DirectMapping{}, nil
+}
+
+func v4Subnet(v uint16) net.IPNet {
+	return net.IPNet{
+		IP:   net.IPv4(10, byte(v>>8), byte(v), 0).To4(),
+		Mask: net.CIDRMask(24, 32),
+	}
+}
+
+// Nibble holds the allocation part of the /64 of a network resource
+type Nibble struct {
+	value   uint64
+	bits    int
+	mapping Mapping
+}
+
+// NewNibble creates the nibble of the network resource subnet, allocated
+// from a farm prefix of farmSize bits. farmSize must be nibble aligned and
+// between /16 and /60. If mapping is nil, the DefaultMapping is used, so
+// farms bigger than /48 must pass a mapping
+func NewNibble(subnet *net.IPNet, farmSize int, mapping Mapping) (*Nibble, error) {
+	ones, size := subnet.Mask.Size()
+	if size != net.IPv6len*8 || ones != subnetSize {
+		return nil, errors.Errorf("subnet %s is not an IPv6 /%d", subnet, subnetSize)
+	}
+
+	if farmSize%4 != 0 || farmSize < minFarmSize || farmSize >= subnetSize {
+		return nil, errors.Errorf("invalid farm prefix size /%d", farmSize)
+	}
+
+	ip := subnet.IP.To16()
+	if ip == nil {
+		return nil, errors.Errorf("invalid subnet address %s", subnet.IP)
+	}
+
+	var prefix uint64
+	for _, b := range ip[:subnetSize/8] {
+		prefix = prefix<<8 | uint64(b)
+	}
+
+	bits := subnetSize - farmSize
+	if mapping == nil {
+		var err error
+		if mapping, err = DefaultMapping(bits); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Nibble{
+		value:   prefix & (1<<uint(bits) - 1),
+		bits:    bits,
+		mapping: mapping,
+	}, nil
+}
+
+// Bits returns the number of bits of the allocation
+func (n *Nibble) Bits() int {
+	return n.bits
+}
+
+// Hex returns the hex encoding of the allocation, it has one
+// digit per nibble. A /48 farm gives 4 digits
+func (n *Nibble) Hex() string {
+	return fmt.Sprintf("%0*x", n.bits/4, n.value)
+}
+
+// ToV4 returns the IPv4 /24 subnet of the network resource
+func (n *Nibble) ToV4() (net.IPNet, error) {
+	return n.mapping.ToV4(n.value, n.bits)
+}
@@ -0,0 +1,63 @@
+package zosip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	return ipnet
+}
+
+func TestNibble(t *testing.T) {
+	cases := []struct {
+		Subnet   string
+		FarmSize int
+		Mapping  Mapping
+		Hex      string
+		V4       string
+	}{
+		{"2a02:1802:5e:ab12::/64", 48, nil, "ab12", "10.171.18.0/24"},
+		{"2a02:1802:5e:ab12::/64", 56, nil, "12", "10.0.18.0/24"},
+		{"2a02:1802:5e:ab12::/64", 40, FoldMapping{}, "5eab12", "10.171.76.0/24"},
+		{"2a02:1802:5e:0001::/64", 48, nil, "0001", "10.0.1.0/24"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Subnet, func(t *testing.T) {
+			n, err := NewNibble(mustParse(t, c.Subnet), c.FarmSize, c.Mapping)
+			require.NoError(t, err)
+
+			assert.Equal(t, c.Hex, n.Hex())
+
+			v4, err := n.ToV4()
+			require.NoError(t, err)
+			assert.Equal(t, c.V4, v4.String())
+		})
+	}
+}
+
+func TestNibbleInvalid(t *testing.T) {
+	_, err := NewNibble(mustParse(t, "2a02:1802:5e::/48"), 40, nil)
+	assert.Error(t, err)
+
+	_, err = NewNibble(mustParse(t, "2a02:1802:5e:ab12::/64"), 50, nil)
+	assert.Error(t, err)
+
+	_, err = NewNibble(mustParse(t, "10.1.0.0/24"), 48, nil)
+	assert.Error(t, err)
+
+	// the fold mapping can collide, it must be picked by the caller
+	_, err = NewNibble(mustParse(t, "2a02:1802:5e:ab12::/64"), 40, nil)
+	assert.Error(t, err)
+
+	n, err := NewNibble(mustParse(t, "2a02:1802:5e:ab12::/64"), 40, DirectMapping{})
+	require.NoError(t, err)
+	_, err = n.ToV4()
+	assert.Error(t, err)
+}
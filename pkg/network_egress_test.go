@@ -0,0 +1,27 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEgressProxyEnv(t *testing.T) {
+	proxy := EgressProxy{Protocol: ProxySocks5, Address: "10.1.2.10:1080"}
+	assert.Equal(t, "10.1.2.10", proxy.IP().String())
+	assert.Equal(t, []string{
+		"all_proxy=socks5://10.1.2.10:1080",
+		"ALL_PROXY=socks5://10.1.2.10:1080",
+		"no_proxy=localhost,127.0.0.1,10.1.0.0/16",
+		"NO_PROXY=localhost,127.0.0.1,10.1.0.0/16",
+	}, proxy.Env("10.1.0.0/16"))
+
+	proxy = EgressProxy{Protocol: ProxyHTTP, Address: "10.1.2.10:3128"}
+	env := proxy.Env()
+	assert.Contains(t, env, "http_proxy=http://10.1.2.10:3128")
+	assert.Contains(t, env, "HTTPS_PROXY=http://10.1.2.10:3128")
+	assert.Contains(t, env, "no_proxy=localhost,127.0.0.1")
+
+	proxy.Address = "invalid"
+	assert.Nil(t, proxy.IP())
+}
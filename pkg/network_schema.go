@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+const (
+	// NetworkSchemaLegacy is the layout of the network objects sent by the
+	// first provisioning tools, which used the explorer field names
+	NetworkSchemaLegacy = 0
+	// NetworkSchemaNetResources is the layout of the network objects
+	// before the schema version was added to the object
+	NetworkSchemaNetResources = 1
+	// NetworkSchemaVersion is the current layout of the network objects
+	NetworkSchemaVersion = 2
+)
+
+// MarshalJSON implements json.Marshaler. The object is always
+// encoded with the current schema version
+func (n Network) MarshalJSON() ([]byte, error) {
+	type network Network
+	n.SchemaVersion = NetworkSchemaVersion
+	return json.Marshal(network(n))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Objects of an older layout
+// are upgraded to the current one. Objects without a schema version are
+// recognized by their field names
+func (n *Network) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		SchemaVersion    *int            `json:"schema_version"`
+		NetResources     json.RawMessage `json:"net_resources"`
+		NetworkResources json.RawMessage `json:"network_resources"`
+	}
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	version := NetworkSchemaVersion
+	if probe.SchemaVersion != nil {
+		version = *probe.SchemaVersion
+	} else if probe.NetworkResources != nil && probe.NetResources == nil {
+		version = NetworkSchemaLegacy
+	}
+
+	switch version {
+	case NetworkSchemaLegacy:
+		var legacy legacyNetwork
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		*n = legacy.upgrade()
+	case NetworkSchemaNetResources, NetworkSchemaVersion:
+		// version 2 only makes the version explicit
+		type network Network
+		var current network
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		*n = Network(current)
+	default:
+		return fmt.Errorf("unsupported network schema version %d, latest supported is %d", version, NetworkSchemaVersion)
+	}
+
+	n.SchemaVersion = NetworkSchemaVersion
+	return nil
+}
+
+// legacyNetwork is the network object layout of NetworkSchemaLegacy
+type legacyNetwork struct {
+	Name             string              `json:"name"`
+	NetID            NetID               `json:"net_id"`
+	IPRange          types.IPNet         `json:"iprange"`
+	NetworkResources []legacyNetResource `json:"network_resources"`
+}
+
+type legacyNetResource struct {
+	NodeID                       string       `json:"node_id"`
+	IPRange                      types.IPNet  `json:"iprange"`
+	WireguardPrivateKeyEncrypted string       `json:"wireguard_private_key_encrypted"`
+	WireguardPublicKey           string       `json:"wireguard_public_key"`
+	WireguardListenPort          uint16       `json:"wireguard_listen_port"`
+	Peers                        []legacyPeer `json:"peers"`
+}
+
+type legacyPeer struct {
+	PublicKey      string        `json:"public_key"`
+	Endpoint       string        `json:"endpoint"`
+	IPRange        types.IPNet   `json:"iprange"`
+	AllowedIPRange []types.IPNet `json:"allowed_iprange"`
+}
+
+func (l *legacyNetwork) upgrade() Network {
+	network := Network{
+		Name:         l.Name,
+		NetID:        l.NetID,
+		IPRange:      l.IPRange,
+		NetResources: make([]NetResource, 0, len(l.NetworkResources)),
+	}
+
+	for _, r := range l.NetworkResources {
+		nr := NetResource{
+			NodeID:       r.NodeID,
+			Subnet:       r.IPRange,
+			WGPrivateKey: r.WireguardPrivateKeyEncrypted,
+			WGPublicKey:  r.WireguardPublicKey,
+			WGListenPort: r.WireguardListenPort,
+			Peers:        make([]Peer, 0, len(r.Peers)),
+		}
+
+		for _, p := range r.Peers {
+			nr.Peers = append(nr.Peers, Peer{
+				Subnet:      p.IPRange,
+				WGPublicKey: p.PublicKey,
+				Endpoint:    p.Endpoint,
+				AllowedIPs:  p.AllowedIPRange,
+			})
+		}
+
+		network.NetResources = append(network.NetResources, nr)
+	}
+
+	return network
+}
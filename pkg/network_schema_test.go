@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkSchemaLegacy(t *testing.T) {
+	input := `{
+		"name": "legacy",
+		"net_id": "net1",
+		"iprange": "10.0.0.0/16",
+		"network_resources": [
+			{
+				"node_id": "node1",
+				"iprange": "10.0.1.0/24",
+				"wireguard_private_key_encrypted": "sealed",
+				"wireguard_public_key": "L+V9o0fNYkMVKNqsX7spBzD/9oSvxM/C7ZCZX1jLO3Q=",
+				"wireguard_listen_port": 6380,
+				"peers": [
+					{
+						"public_key": "pubkey",
+						"endpoint": "172.20.0.90:6380",
+						"iprange": "10.0.2.0/24",
+						"allowed_iprange": ["10.0.2.0/24", "100.64.0.2/32"]
+					}
+				]
+			}
+		]
+	}`
+
+	var network Network
+	require.NoError(t, json.Unmarshal([]byte(input), &network))
+
+	assert.Equal(t, NetworkSchemaVersion, network.SchemaVersion)
+	assert.Equal(t, NetID("net1"), network.NetID)
+	assert.Equal(t, "10.0.0.0/16", network.IPRange.String())
+	require.Len(t, network.NetResources, 1)
+
+	nr := network.NetResources[0]
+	assert.Equal(t, "node1", nr.NodeID)
+	assert.Equal(t, "10.0.1.0/24", nr.Subnet.String())
+	assert.Equal(t, "sealed", nr.WGPrivateKey)
+	assert.Equal(t, uint16(6380), nr.WGListenPort)
+	require.Len(t, nr.Peers, 1)
+	assert.Equal(t, "10.0.2.0/24", nr.Peers[0].Subnet.String())
+	assert.Equal(t, "pubkey", nr.Peers[0].WGPublicKey)
+	assert.Len(t, nr.Peers[0].AllowedIPs, 2)
+}
+
+func TestNetworkSchemaRoundTrip(t *testing.T) {
+	// objects without version but with the current field names
+	input := `{"name": "net", "net_id": "net1", "ip_range": "10.0.0.0/16", "net_resources": []}`
+
+	var network Network
+	require.NoError(t, json.Unmarshal([]byte(input), &network))
+	assert.Equal(t, NetworkSchemaVersion, network.SchemaVersion)
+	assert.Equal(t, "10.0.0.0/16", network.IPRange.String())
+
+	network.SchemaVersion = 0
+	data, err := json.Marshal(network)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"schema_version":2`)
+
+	var decoded Network
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, NetworkSchemaVersion, decoded.SchemaVersion)
+	assert.Equal(t, network.IPRange.String(), decoded.IPRange.String())
+}
+
+func TestNetworkSchemaUnsupported(t *testing.T) {
+	var network Network
+	err := json.Unmarshal([]byte(`{"schema_version": 3, "net_resources": []}`), &network)
+	assert.Error(t, err)
+}
@@ -0,0 +1,460 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack"
+	"github.com/vmihailenco/msgpack/codes"
+
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+// The networks are the largest objects sent over zbus, a network with
+// hundreds of peers is mostly subnets and keys. msgpack encodes them as
+// maps, repeating the name of every field of every peer. A network can be
+// sent in the protobuf wire format instead (docs/network/zbus.md has the
+// schema), wrapped in a msgpack bin, but only to a networkd that serves
+// NetworkWireVersion: the modules not upgraded yet only decode the maps.
+// The receiver looks at each payload and decodes both forms.
+
+// NetworkWireVersion is the version of the network zbus object that
+// accepts the networks in the wire format. networkd serves the same object
+// under the version 0.0.1 for the clients that send msgpack maps
+const NetworkWireVersion = "0.0.2"
+
+// WireNetwork is a Network encoded in the wire format over zbus, a
+// Network is encoded as a msgpack map
+type WireNetwork Network
+
+// EncodeMsgpack implements msgpack.CustomEncoder
+func (n WireNetwork) EncodeMsgpack(enc *msgpack.Encoder) error {
+	nw := Network(n)
+	return enc.EncodeBytes(nw.marshalWire())
+}
+
+// DecodeMsgpack implements msgpack.CustomDecoder
+func (n *Network) DecodeMsgpack(dec *msgpack.Decoder) error {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+
+	if code != codes.Bin8 && code != codes.Bin16 && code != codes.Bin32 {
+		type network Network
+		return dec.Decode((*network)(n))
+	}
+
+	data, err := dec.DecodeBytes()
+	if err != nil {
+		return err
+	}
+
+	return n.unmarshalWire(data)
+}
+
+// the types of the protobuf wire format used by the networks
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type wireWriter struct {
+	buf []byte
+}
+
+func (w *wireWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+func (w *wireWriter) tag(field, typ int) {
+	w.varint(uint64(field<<3 | typ))
+}
+
+// uint writes a varint field, 0 is the default and is not written
+func (w *wireWriter) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, wireVarint)
+	w.varint(v)
+}
+
+// bytes writes a bytes field, empty is the default and is not written
+func (w *wireWriter) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	w.raw(field, b)
+}
+
+func (w *wireWriter) string(field int, s string) {
+	w.bytes(field, []byte(s))
+}
+
+// raw writes a bytes field even if it's empty, for the repeated fields
+// and the messages which must be present
+func (w *wireWriter) raw(field int, b []byte) {
+	w.tag(field, wireBytes)
+	w.varint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// message writes the embedded message encoded by fn
+func (w *wireWriter) message(field int, fn func(w *wireWriter)) {
+	var inner wireWriter
+	fn(&inner)
+	w.raw(field, inner.buf)
+}
+
+func (w *wireWriter) ipNet(field int, n types.IPNet) {
+	w.message(field, func(w *wireWriter) {
+		w.bytes(1, n.IP)
+		w.bytes(2, n.Mask)
+	})
+}
+
+type wireReader struct {
+	buf []byte
+}
+
+func (r *wireReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint")
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+func (r *wireReader) bytes() ([]byte, error) {
+	size, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.buf)) {
+		return nil, fmt.Errorf("truncated field")
+	}
+
+	b := r.buf[:size:size]
+	r.buf = r.buf[size:]
+	return b, nil
+}
+
+// field is a field being decoded
+type wireField struct {
+	r   *wireReader
+	typ int
+}
+
+func (f wireField) uint() (uint64, error) {
+	if f.typ != wireVarint {
+		return 0, fmt.Errorf("expected varint field, got type %d", f.typ)
+	}
+	return f.r.varint()
+}
+
+func (f wireField) bytes() ([]byte, error) {
+	if f.typ != wireBytes {
+		return nil, fmt.Errorf("expected bytes field, got type %d", f.typ)
+	}
+	return f.r.bytes()
+}
+
+func (f wireField) string() (string, error) {
+	b, err := f.bytes()
+	return string(b), err
+}
+
+// skip drops a field this version doesn't know
+func (f wireField) skip() error {
+	var err error
+	switch f.typ {
+	case wireVarint:
+		_, err = f.r.varint()
+	case wireBytes:
+		_, err = f.r.bytes()
+	default:
+		err = fmt.Errorf("unsupported field type %d", f.typ)
+	}
+	return err
+}
+
+func (f wireField) message(fn func(field int, f wireField) error) error {
+	data, err := f.bytes()
+	if err != nil {
+		return err
+	}
+	return decodeWire(data, fn)
+}
+
+func (f wireField) ipNet() (types.IPNet, error) {
+	var n types.IPNet
+	err := f.message(func(field int, f wireField) (err error) {
+		switch field {
+		case 1:
+			n.IP, err = f.bytes()
+			n.IP = append(net.IP(nil), n.IP...)
+		case 2:
+			n.Mask, err = f.bytes()
+			n.Mask = append(net.IPMask(nil), n.Mask...)
+		default:
+			err = f.skip()
+		}
+		return err
+	})
+	return n, err
+}
+
+// decodeWire calls fn for each field of the message in data
+func decodeWire(data []byte, fn func(field int, f wireField) error) error {
+	r := &wireReader{buf: data}
+	for len(r.buf) > 0 {
+		key, err := r.varint()
+		if err != nil {
+			return err
+		}
+
+		field := int(key >> 3)
+		if err := fn(field, wireField{r: r, typ: int(key & 0x7)}); err != nil {
+			return errors.Wrapf(err, "field %d", field)
+		}
+	}
+
+	return nil
+}
+
+func (n *Network) marshalWire() []byte {
+	var w wireWriter
+	w.uint(1, uint64(n.SchemaVersion))
+	w.string(2, n.Name)
+	w.string(3, string(n.NetID))
+	w.ipNet(4, n.IPRange)
+	for i := range n.NetResources {
+		w.message(5, n.NetResources[i].marshalWire)
+	}
+	return w.buf
+}
+
+func (n *Network) unmarshalWire(data []byte) error {
+	*n = Network{}
+	return decodeWire(data, func(field int, f wireField) (err error) {
+		switch field {
+		case 1:
+			var v uint64
+			v, err = f.uint()
+			n.SchemaVersion = int(v)
+		case 2:
+			n.Name, err = f.string()
+		case 3:
+			var v string
+			v, err = f.string()
+			n.NetID = NetID(v)
+		case 4:
+			n.IPRange, err = f.ipNet()
+		case 5:
+			var nr NetResource
+			err = f.message(nr.unmarshalWire)
+			n.NetResources = append(n.NetResources, nr)
+		default:
+			err = f.skip()
+		}
+		return err
+	})
+}
+
+func (nr *NetResource) marshalWire(w *wireWriter) {
+	w.string(1, nr.NodeID)
+	w.ipNet(2, nr.Subnet)
+	w.string(3, nr.WGPrivateKey)
+	w.string(4, nr.WGPublicKey)
+	w.uint(5, uint64(nr.WGListenPort))
+	for i := range nr.Peers {
+		w.message(6, nr.Peers[i].marshalWire)
+	}
+	if nr.Egress != nil {
+		w.message(7, func(w *wireWriter) {
+			w.string(1, string(nr.Egress.Protocol))
+			w.string(2, nr.Egress.Address)
+		})
+	}
+	if nr.EgressPolicy != nil {
+		w.message(8, nr.EgressPolicy.marshalWire)
+	}
+	w.string(9, string(nr.Withdrawn))
+	w.string(10, string(nr.MSSClamping))
+}
+
+func (nr *NetResource) unmarshalWire(field int, f wireField) (err error) {
+	switch field {
+	case 1:
+		nr.NodeID, err = f.string()
+	case 2:
+		nr.Subnet, err = f.ipNet()
+	case 3:
+		nr.WGPrivateKey, err = f.string()
+	case 4:
+		nr.WGPublicKey, err = f.string()
+	case 5:
+		var v uint64
+		v, err = f.uint()
+		nr.WGListenPort = uint16(v)
+	case 6:
+		var peer Peer
+		err = f.message(peer.unmarshalWire)
+		nr.Peers = append(nr.Peers, peer)
+	case 7:
+		nr.Egress = &EgressProxy{}
+		err = f.message(func(field int, f wireField) (err error) {
+			switch field {
+			case 1:
+				var v string
+				v, err = f.string()
+				nr.Egress.Protocol = ProxyProtocol(v)
+			case 2:
+				nr.Egress.Address, err = f.string()
+			default:
+				err = f.skip()
+			}
+			return err
+		})
+	case 8:
+		nr.EgressPolicy = &EgressPolicy{}
+		err = f.message(nr.EgressPolicy.unmarshalWire)
+	case 9:
+		var v string
+		v, err = f.string()
+		nr.Withdrawn = WithdrawAction(v)
+	case 10:
+		var v string
+		v, err = f.string()
+		nr.MSSClamping = MSSClamping(v)
+	default:
+		err = f.skip()
+	}
+	return err
+}
+
+func (p *EgressPolicy) marshalWire(w *wireWriter) {
+	w.string(1, string(p.Mode))
+	for _, rule := range p.Rules {
+		w.message(2, func(w *wireWriter) {
+			w.ipNet(1, rule.Dst)
+			w.string(2, rule.Protocol)
+			for _, port := range rule.Ports {
+				// a repeated field, 0 is written too
+				w.tag(3, wireVarint)
+				w.varint(uint64(port))
+			}
+		})
+	}
+}
+
+func (p *EgressPolicy) unmarshalWire(field int, f wireField) (err error) {
+	switch field {
+	case 1:
+		var v string
+		v, err = f.string()
+		p.Mode = EgressPolicyMode(v)
+	case 2:
+		var rule EgressRule
+		err = f.message(func(field int, f wireField) (err error) {
+			switch field {
+			case 1:
+				rule.Dst, err = f.ipNet()
+			case 2:
+				rule.Protocol, err = f.string()
+			case 3:
+				var v uint64
+				v, err = f.uint()
+				rule.Ports = append(rule.Ports, uint16(v))
+			default:
+				err = f.skip()
+			}
+			return err
+		})
+		p.Rules = append(p.Rules, rule)
+	default:
+		err = f.skip()
+	}
+	return err
+}
+
+func (p *Peer) marshalWire(w *wireWriter) {
+	w.ipNet(1, p.Subnet)
+	w.string(2, p.WGPublicKey)
+	for _, allowed := range p.AllowedIPs {
+		w.ipNet(3, allowed)
+	}
+	w.string(4, p.Endpoint)
+	for _, endpoint := range p.Endpoints {
+		w.raw(5, []byte(endpoint))
+	}
+	w.string(6, p.WGPresharedKey)
+	w.uint(7, uint64(p.Metric))
+	w.string(8, string(p.ConnType))
+	if p.IPSec != nil {
+		w.message(9, func(w *wireWriter) {
+			w.uint(1, uint64(p.IPSec.SPIIn))
+			w.uint(2, uint64(p.IPSec.SPIOut))
+			w.string(3, p.IPSec.KeyIn)
+			w.string(4, p.IPSec.KeyOut)
+		})
+	}
+}
+
+func (p *Peer) unmarshalWire(field int, f wireField) (err error) {
+	switch field {
+	case 1:
+		p.Subnet, err = f.ipNet()
+	case 2:
+		p.WGPublicKey, err = f.string()
+	case 3:
+		var allowed types.IPNet
+		allowed, err = f.ipNet()
+		p.AllowedIPs = append(p.AllowedIPs, allowed)
+	case 4:
+		p.Endpoint, err = f.string()
+	case 5:
+		var endpoint string
+		endpoint, err = f.string()
+		p.Endpoints = append(p.Endpoints, endpoint)
+	case 6:
+		p.WGPresharedKey, err = f.string()
+	case 7:
+		var v uint64
+		v, err = f.uint()
+		p.Metric = uint32(v)
+	case 8:
+		var v string
+		v, err = f.string()
+		p.ConnType = ConnType(v)
+	case 9:
+		p.IPSec = &IPSecConfig{}
+		err = f.message(func(field int, f wireField) (err error) {
+			var v uint64
+			switch field {
+			case 1:
+				v, err = f.uint()
+				p.IPSec.SPIIn = uint32(v)
+			case 2:
+				v, err = f.uint()
+				p.IPSec.SPIOut = uint32(v)
+			case 3:
+				p.IPSec.KeyIn, err = f.string()
+			case 4:
+				p.IPSec.KeyOut, err = f.string()
+			default:
+				err = f.skip()
+			}
+			return err
+		})
+	default:
+		err = f.skip()
+	}
+	return err
+}
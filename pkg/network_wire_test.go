@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/threefoldtech/zos/pkg/network/types"
+	"github.com/threefoldtech/zos/pkg/stubs/stubtest"
+)
+
+func TestNetworkWire(t *testing.T) {
+	require := require.New(t)
+
+	// every field is set by the generator, a field the codec
+	// forgets doesn't survive the round trip
+	g := stubtest.NewGenerator(1)
+	for i := 0; i < 50; i++ {
+		var network Network
+		if i > 0 {
+			g.Fill(reflect.ValueOf(&network).Elem())
+		}
+
+		data, err := msgpack.Marshal(WireNetwork(network))
+		require.NoError(err)
+
+		var decoded Network
+		require.NoError(msgpack.Unmarshal(data, &decoded))
+		require.True(reflect.DeepEqual(network, decoded), "network %d doesn't survive the wire", i)
+	}
+}
+
+func TestNetworkWireArgs(t *testing.T) {
+	require := require.New(t)
+
+	// zbus sends the arguments of a call as a list of values
+	network := Network{
+		Name:    "net",
+		NetID:   "net",
+		IPRange: types.MustParseIPNet("10.0.0.0/16"),
+	}
+	wire := WireNetwork(network)
+
+	for _, arg := range []interface{}{wire, &wire} {
+		data, err := msgpack.Marshal([]interface{}{arg})
+		require.NoError(err)
+
+		var decoded []Network
+		require.NoError(msgpack.Unmarshal(data, &decoded))
+		require.Len(decoded, 1)
+		require.Equal(network, decoded[0])
+	}
+}
+
+func TestNetworkWireMap(t *testing.T) {
+	require := require.New(t)
+
+	// the form sent to the modules that are not upgraded yet,
+	// and by them
+	network := Network{
+		Name:    "net",
+		NetID:   "net",
+		IPRange: types.MustParseIPNet("10.0.0.0/16"),
+		NetResources: []NetResource{{
+			NodeID:       "node",
+			Subnet:       types.MustParseIPNet("10.0.1.0/24"),
+			WGListenPort: 6380,
+			Peers: []Peer{{
+				Subnet:     types.MustParseIPNet("10.0.2.0/24"),
+				AllowedIPs: []types.IPNet{types.MustParseIPNet("10.0.2.0/24")},
+				Endpoint:   "1.1.1.1:6380",
+			}},
+		}},
+	}
+
+	data, err := msgpack.Marshal(network)
+	require.NoError(err)
+
+	// a network is only in the wire format when asked for
+	type legacy Network
+	expected, err := msgpack.Marshal(legacy(network))
+	require.NoError(err)
+	require.Equal(expected, data)
+
+	var decoded Network
+	require.NoError(msgpack.Unmarshal(data, &decoded))
+	require.Equal(network, decoded)
+}
+
+func TestNetworkWireUnknownFields(t *testing.T) {
+	require := require.New(t)
+
+	network := Network{Name: "net", NetID: "net"}
+	data := network.marshalWire()
+
+	// fields added by a newer version are skipped
+	var w wireWriter
+	w.uint(100, 42)
+	w.string(101, "new")
+	data = append(data, w.buf...)
+
+	var decoded Network
+	require.NoError(decoded.unmarshalWire(data))
+	require.Equal(network, decoded)
+
+	// and a truncated payload is an error
+	require.Error(decoded.unmarshalWire(data[:len(data)-1]))
+}
@@ -0,0 +1,205 @@
+// Package offline runs a node that can't reach the grid. The reservations
+// and the upgrades of the node are read from a bundle, on a usb device
+// plugged in the node or on an http server of the farm network, instead of
+// the explorer and the hub.
+//
+// The bundle is not trusted: the reservations must be signed by their user
+// and the releases by the release key, the same keys the node checks when
+// it's connected to the grid.
+package offline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexflint/go-filemutex"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/download"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// offlineParam enables the offline mode, as offline for a usb device
+	// labeled DefaultLabel, offline=label:<label> for another label or
+	// offline=<url> for an http server
+	offlineParam = "offline"
+	labelPrefix  = "label:"
+
+	// DefaultLabel is the label of the filesystem of the usb device of the
+	// bundle
+	DefaultLabel = "ZOSBUNDLE"
+
+	// ReservationsFile is the file of the reservations in the bundle
+	ReservationsFile = "reservations.json"
+	// ReleaseFile is the archive of the release in the bundle
+	ReleaseFile = "release.tar.gz"
+	// ResultsDir is the directory of the bundle the results of the
+	// reservations are written to
+	ResultsDir = "results"
+
+	// lockPath serializes the mounts of the usb device by the modules
+	lockPath = "/var/run/offline.lock"
+)
+
+// ErrNoBundle is returned when the bundle can't be found, the usb device is
+// not plugged
+var ErrNoBundle = fmt.Errorf("offline bundle not found")
+
+// ErrReadOnly is returned when writing to a bundle served over http
+var ErrReadOnly = fmt.Errorf("offline bundle is read only")
+
+// Config is the offline configuration set by the farmer
+type Config struct {
+	// Enabled runs the node offline
+	Enabled bool
+	// URL is the url of the bundle, the bundle is read from a usb device
+	// if empty
+	URL string
+	// Label is the label of the filesystem of the usb device
+	Label string
+}
+
+// ConfigFromParams reads the offline configuration from the kernel
+// parameters
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	values, ok := params.Get(offlineParam)
+	if !ok {
+		return Config{}, nil
+	}
+
+	config := Config{Enabled: true, Label: DefaultLabel}
+	if len(values) == 0 || values[0] == "" {
+		return config, nil
+	}
+
+	value := values[0]
+	switch {
+	case strings.HasPrefix(value, labelPrefix) && len(value) > len(labelPrefix):
+		config.Label = strings.TrimPrefix(value, labelPrefix)
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		config.URL = strings.TrimSuffix(value, "/") + "/"
+		config.Label = ""
+	default:
+		return config, fmt.Errorf("invalid offline bundle '%s', it must be label:<label> or an http url", value)
+	}
+
+	return config, nil
+}
+
+// Bundle reads the files of the offline bundle. The usb device is only
+// mounted while a file is read or written, so it can be unplugged to
+// update the bundle at any time
+type Bundle struct {
+	config  Config
+	devices filesystem.DeviceManager
+}
+
+// NewBundle creates the bundle of the configuration
+func NewBundle(config Config) *Bundle {
+	return &Bundle{
+		config:  config,
+		devices: filesystem.DefaultDeviceManager(context.Background()),
+	}
+}
+
+func (b *Bundle) String() string {
+	if len(b.config.URL) != 0 {
+		return b.config.URL
+	}
+
+	return labelPrefix + b.config.Label
+}
+
+// Read calls fn with the content of the file name of the bundle
+func (b *Bundle) Read(ctx context.Context, name string, fn func(r io.Reader) error) error {
+	if len(b.config.URL) != 0 {
+		response, err := download.Default().Get(ctx, b.config.URL+name)
+		if err != nil {
+			return errors.Wrap(ErrNoBundle, err.Error())
+		}
+		defer response.Body.Close()
+
+		return fn(response.Body)
+	}
+
+	return b.mounted(ctx, unix.MS_RDONLY, func(root string) error {
+		file, err := os.Open(filepath.Join(root, name))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return fn(file)
+	})
+}
+
+// Write writes the files of the bundle, by name. Only a bundle on a usb
+// device can be written
+func (b *Bundle) Write(ctx context.Context, files map[string][]byte) error {
+	if len(b.config.URL) != 0 {
+		return ErrReadOnly
+	}
+
+	return b.mounted(ctx, 0, func(root string) error {
+		for name, data := range files {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// mounted mounts the usb device, calls fn with its mountpoint and unmounts
+// it. The modules mount the device in turn
+func (b *Bundle) mounted(ctx context.Context, flags uintptr, fn func(root string) error) error {
+	devices, err := b.devices.Reset().ByLabel(ctx, b.config.Label)
+	if err != nil {
+		return errors.Wrap(err, "failed to list devices")
+	}
+
+	if len(devices) == 0 {
+		return ErrNoBundle
+	} else if len(devices) > 1 {
+		return fmt.Errorf("more than one device labeled '%s'", b.config.Label)
+	}
+	device := devices[0]
+
+	lock, err := filemutex.New(lockPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	root, err := ioutil.TempDir("/var/run", "offline-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(root)
+
+	flags |= unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV
+	if err := unix.Mount(device.Path, root, string(device.Filesystem), flags, ""); err != nil {
+		return errors.Wrapf(err, "failed to mount '%s'", device.Path)
+	}
+	defer unix.Unmount(root, 0)
+
+	return fn(root)
+}
@@ -0,0 +1,192 @@
+package offline
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/identity"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.False(t, config.Enabled)
+
+	config, err = ConfigFromParams(kernel.Params{"offline": {}})
+	require.NoError(t, err)
+	assert.Equal(t, Config{Enabled: true, Label: DefaultLabel}, config)
+
+	config, err = ConfigFromParams(kernel.Params{"offline": {"label:FARM"}})
+	require.NoError(t, err)
+	assert.Equal(t, Config{Enabled: true, Label: "FARM"}, config)
+
+	config, err = ConfigFromParams(kernel.Params{"offline": {"http://10.0.0.1/bundle"}})
+	require.NoError(t, err)
+	assert.Equal(t, Config{Enabled: true, URL: "http://10.0.0.1/bundle/"}, config)
+
+	_, err = ConfigFromParams(kernel.Params{"offline": {"label:"}})
+	assert.Error(t, err)
+
+	_, err = ConfigFromParams(kernel.Params{"offline": {"ftp://10.0.0.1"}})
+	assert.Error(t, err)
+}
+
+func TestReadReservations(t *testing.T) {
+	keys, err := identity.GenerateKeyPair()
+	require.NoError(t, err)
+
+	reservation := func(id, node string) *provision.Reservation {
+		r := &provision.Reservation{
+			ID:     id,
+			NodeID: node,
+			User:   keys.Identity(),
+			Type:   provision.ReservationType("container"),
+			Data:   json.RawMessage(`{}`),
+		}
+		require.NoError(t, r.Sign(keys.PrivateKey))
+		return r
+	}
+
+	forged := reservation("3-1", "node")
+	forged.Data = json.RawMessage(`{"flist": "forged"}`)
+
+	data, err := json.Marshal(Reservations{
+		Reservations: []*provision.Reservation{
+			reservation("1-1", "node"),
+			reservation("2-1", "other"),
+			forged,
+		},
+	})
+	require.NoError(t, err)
+
+	reservations, err := readReservations(bytes.NewReader(data), "node")
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	assert.Equal(t, "1-1", reservations[0].ID)
+
+	_, err = readReservations(bytes.NewBufferString("invalid"), "node")
+	assert.Error(t, err)
+}
+
+type entry struct {
+	name     string
+	typ      byte
+	content  string
+	linkname string
+}
+
+func archive(t *testing.T, entries ...entry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Mode:     0755,
+			Size:     int64(len(e.content)),
+			Linkname: e.linkname,
+		}
+		if e.typ != tar.TypeReg {
+			header.Size = 0
+		}
+		require.NoError(t, writer.WriteHeader(header))
+		if e.typ == tar.TypeReg {
+			_, err := writer.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	root, err := ioutil.TempDir("", "offline-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, ReleaseFile)
+	dir := filepath.Join(root, "release")
+
+	data := archive(t,
+		entry{name: "bin", typ: tar.TypeDir},
+		entry{name: "bin/identityd", typ: tar.TypeReg, content: "identityd"},
+		entry{name: "../../escaped", typ: tar.TypeReg, content: "escaped"},
+	)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	require.NoError(t, extract(path, dir))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "bin", "identityd"))
+	require.NoError(t, err)
+	assert.Equal(t, "identityd", string(content))
+
+	// the paths are kept under the release directory
+	content, err = ioutil.ReadFile(filepath.Join(dir, "escaped"))
+	require.NoError(t, err)
+	assert.Equal(t, "escaped", string(content))
+
+	data = archive(t, entry{name: "bin/link", typ: tar.TypeSymlink, linkname: "/etc/shadow"})
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	assert.Error(t, extract(path, filepath.Join(root, "symlink")))
+}
+
+func TestReleaseStage(t *testing.T) {
+	data := archive(t, entry{name: "bin/identityd", typ: tar.TypeReg, content: "identityd"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+ReleaseFile {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	root, err := ioutil.TempDir("", "offline-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	bundle := &Bundle{config: Config{Enabled: true, URL: server.URL + "/"}}
+	release, err := NewRelease(bundle, root)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	dir, hash, err := release.Stage(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, dir)
+	require.NotEmpty(t, hash)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "bin", "identityd"))
+	require.NoError(t, err)
+	assert.Equal(t, "identityd", string(content))
+
+	// an installed release is not staged again
+	require.NoError(t, release.Installed(hash))
+	dir, installed, err := release.Stage(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dir)
+	assert.Equal(t, hash, installed)
+
+	// the bundle has no release
+	bundle.config.URL = server.URL + "/none/"
+	dir, hash, err = release.Stage(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dir)
+	assert.Empty(t, hash)
+}
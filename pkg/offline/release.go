@@ -0,0 +1,172 @@
+package offline
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Release stages the release of the bundle on the node. The release is an
+// archive of the files of the release flist, it's verified against the
+// release key by the upgrade module before it's installed
+type Release struct {
+	bundle *Bundle
+	root   string
+}
+
+// NewRelease creates the release of the bundle, it's staged under root
+func NewRelease(bundle *Bundle, root string) (*Release, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create release directory")
+	}
+
+	return &Release{bundle: bundle, root: root}, nil
+}
+
+// installed is the file of the hashes of the releases already installed
+func (r *Release) installed() string {
+	return filepath.Join(r.root, "installed")
+}
+
+func (r *Release) isInstalled(hash string) (bool, error) {
+	file, err := os.Open(r.installed())
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == hash {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Installed records the release hash as installed, it's not staged again
+// so an older release left in the bundle can't roll the node back
+func (r *Release) Installed(hash string) error {
+	file, err := os.OpenFile(r.installed(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintln(file, hash)
+	return err
+}
+
+// Stage copies the release of the bundle to the node and extracts it. It
+// returns the directory of the release and the hash of the archive, the
+// directory is empty if the bundle has no release or if it was already
+// installed
+func (r *Release) Stage(ctx context.Context) (string, string, error) {
+	archive := filepath.Join(r.root, ReleaseFile)
+	hash := sha256.New()
+	err := r.bundle.Read(ctx, ReleaseFile, func(reader io.Reader) error {
+		file, err := os.Create(archive)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(io.MultiWriter(file, hash), reader); err != nil {
+			return err
+		}
+
+		return file.Sync()
+	})
+	if errors.Cause(err) == ErrNoBundle || os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", errors.Wrap(err, "failed to copy release")
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if installed, err := r.isInstalled(sum); err != nil || installed {
+		return "", sum, err
+	}
+
+	dir := filepath.Join(r.root, "release")
+	if err := os.RemoveAll(dir); err != nil {
+		return "", sum, err
+	}
+
+	if err := extract(archive, dir); err != nil {
+		return "", sum, errors.Wrap(err, "failed to extract release")
+	}
+
+	return dir, sum, nil
+}
+
+// extract extracts the regular files and the directories of the tar.gz
+// archive under dir
+func extract(archive, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean("/" + header.Name)
+		path := filepath.Join(dir, name)
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			if err := writeFile(path, reader, mode); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported file '%s' in release, only regular files and directories are allowed", header.Name)
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	return err
+}
@@ -0,0 +1,206 @@
+package offline
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// Reservations is the reservations file of the bundle
+type Reservations struct {
+	Reservations []*provision.Reservation `json:"reservations"`
+}
+
+// readReservations reads the reservations of the bundle, the reservations of
+// other nodes and the reservations not signed by their user are dropped
+func readReservations(r io.Reader, nodeID string) ([]*provision.Reservation, error) {
+	var content Reservations
+	if err := json.NewDecoder(r).Decode(&content); err != nil {
+		return nil, errors.Wrap(err, "invalid reservations file")
+	}
+
+	var reservations []*provision.Reservation
+	for _, reservation := range content.Reservations {
+		if reservation.NodeID != nodeID {
+			continue
+		}
+
+		if err := provision.Verify(reservation); err != nil {
+			log.Error().Err(err).Str("id", reservation.ID).Msg("invalid signature of offline reservation, skipping")
+			continue
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+type reservationSource struct {
+	bundle   *Bundle
+	nodeID   string
+	interval time.Duration
+}
+
+// ReservationSource reads the reservations of the node from the bundle, the
+// bundle is read again every 30 seconds to get the new reservations and
+// the deleted ones
+func ReservationSource(bundle *Bundle, nodeID pkg.Identifier) provision.ReservationSource {
+	return &reservationSource{
+		bundle:   bundle,
+		nodeID:   nodeID.Identity(),
+		interval: 30 * time.Second,
+	}
+}
+
+func (s *reservationSource) read(ctx context.Context) ([]*provision.Reservation, error) {
+	var reservations []*provision.Reservation
+	err := s.bundle.Read(ctx, ReservationsFile, func(r io.Reader) (err error) {
+		reservations, err = readReservations(r, s.nodeID)
+		return err
+	})
+
+	return reservations, err
+}
+
+func (s *reservationSource) Reservations(ctx context.Context) <-chan *provision.Reservation {
+	ch := make(chan *provision.Reservation)
+	go func() {
+		defer close(ch)
+
+		// like the explorer, all the reservations are sent once the node
+		// boots, then only the new and the deleted ones
+		sent := make(map[string]bool)
+		log.Info().Str("bundle", s.bundle.String()).Msg("started reading offline reservations")
+		for {
+			reservations, err := s.read(ctx)
+			if err != nil && errors.Cause(err) != ErrNoBundle && !os.IsNotExist(err) {
+				log.Error().Err(err).Str("bundle", s.bundle.String()).Msg("failed to read offline reservations")
+			}
+
+			for _, r := range reservations {
+				if deleted, ok := sent[r.ID]; ok && deleted == r.ToDelete {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- r:
+					sent[r.ID] = r.ToDelete
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.interval):
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Feedback keeps the results of the reservations on the node and copies
+// them to the results directory of the bundle, when it's on a usb device
+type Feedback struct {
+	bundle *Bundle
+	root   string
+}
+
+var _ provision.Feedbacker = (*Feedback)(nil)
+
+// NewFeedback creates the feedback of the bundle, the results are kept
+// under root
+func NewFeedback(bundle *Bundle, root string) (*Feedback, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create results directory")
+	}
+
+	return &Feedback{bundle: bundle, root: root}, nil
+}
+
+func (f *Feedback) save(name string, object interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(f.root, name), data, 0644)
+}
+
+// Feedback implements provision.Feedbacker
+func (f *Feedback) Feedback(nodeID string, r *provision.Result) error {
+	return f.save(r.ID+".json", r)
+}
+
+// Deleted implements provision.Feedbacker
+func (f *Feedback) Deleted(nodeID, id string) error {
+	return f.save(id+".json", provision.Result{
+		ID:      id,
+		Created: time.Now(),
+		State:   provision.StateDeleted,
+	})
+}
+
+// UpdateStats implements provision.Feedbacker
+func (f *Feedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return f.save("stats.json", struct {
+		Workloads directory.WorkloadAmount `json:"workloads"`
+		Reserved  directory.ResourceAmount `json:"reserved"`
+	}{w, u})
+}
+
+// sync copies the results to the bundle
+func (f *Feedback) sync(ctx context.Context, nodeID string) error {
+	infos, err := ioutil.ReadDir(f.root)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string][]byte)
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(f.root, info.Name()))
+		if err != nil {
+			return err
+		}
+		files[filepath.Join(ResultsDir, nodeID, info.Name())] = data
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	return f.bundle.Write(ctx, files)
+}
+
+// Run copies the results to the bundle at every interval until ctx is
+// canceled, so the results are on the usb device when it's unplugged
+func (f *Feedback) Run(ctx context.Context, nodeID pkg.Identifier, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		err := f.sync(ctx, nodeID.Identity())
+		if err == ErrReadOnly {
+			log.Info().Str("results", f.root).Msg("offline bundle is read only, results are only kept on the node")
+			return
+		} else if err != nil && err != ErrNoBundle {
+			log.Error().Err(err).Msg("failed to copy results to the offline bundle")
+		}
+	}
+}
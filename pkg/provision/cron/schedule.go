@@ -0,0 +1,204 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// when both the day of month and the day of week are restricted
+	// a day matches if any of them matches, like in cron
+	domStar, dowStar bool
+}
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes  = field{min: 0, max: 59}
+	hours    = field{min: 0, max: 23}
+	days     = field{min: 1, max: 31}
+	months   = field{min: 1, max: 12, names: names("jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec")}
+	weekdays = field{min: 0, max: 7, names: names("sun", "mon", "tue", "wed", "thu", "fri", "sat")}
+
+	descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+func names(values ...string) map[string]int {
+	m := make(map[string]int)
+	for i, name := range values {
+		m[name] = i
+	}
+	return m
+}
+
+// Parse parses a cron expression of 5 fields: minute, hour, day of month,
+// month and day of week. Fields accept *, values, ranges (1-5), steps
+// (*/15 or 0-30/10) and comma separated lists of those. Months and days of
+// week also accept their 3 letters english names. The @yearly, @monthly,
+// @weekly, @daily and @hourly descriptors are supported too
+func Parse(expr string) (s Schedule, err error) {
+	expr = strings.TrimSpace(strings.ToLower(expr))
+	if descriptor, ok := descriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return s, fmt.Errorf("invalid cron expression '%s' expected 5 fields", expr)
+	}
+
+	targets := []struct {
+		bits  *uint64
+		field field
+		name  string
+	}{
+		{&s.minute, minutes, "minute"},
+		{&s.hour, hours, "hour"},
+		{&s.dom, days, "day of month"},
+		{&s.month, months, "month"},
+		{&s.dow, weekdays, "day of week"},
+	}
+
+	for i, target := range targets {
+		*target.bits, err = parseField(fields[i], target.field)
+		if err != nil {
+			return s, fmt.Errorf("invalid %s: %s", target.name, err)
+		}
+	}
+
+	// 7 is sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	s.dowStar = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+
+	return s, nil
+}
+
+func parseField(expr string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		value := part
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			value = part[:i]
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case value == "*":
+		case strings.Contains(value, "-"):
+			bounds := strings.SplitN(value, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range '%s'", value)
+			}
+		default:
+			var err error
+			if low, err = f.value(value); err != nil {
+				return 0, err
+			}
+			high = low
+			if step != 1 {
+				// 5/10 means from 5 to the end every 10
+				high = f.max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[s]; ok {
+		return v + f.min, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Next returns the first time after t matching the schedule, it's the zero
+// time if the schedule never matches (like the 30th of february)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// the schedule repeats at most every 4 years, then it never matches
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,77 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *",
+		"*/15 0-6 1,15 jan-jun mon-fri",
+		"0 12 * * 7",
+		"5/10 * * * *",
+		"@daily",
+		"@Hourly",
+	} {
+		_, err := Parse(expr)
+		assert.NoError(t, err, expr)
+	}
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@reboot",
+	} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", s)
+		require.NoError(t, err)
+		return d
+	}
+
+	cases := []struct {
+		expr     string
+		from     string
+		expected string
+	}{
+		{"* * * * *", "2020-05-10 10:30", "2020-05-10 10:31"},
+		{"*/15 * * * *", "2020-05-10 10:30", "2020-05-10 10:45"},
+		{"0 0 * * *", "2020-05-10 10:30", "2020-05-11 00:00"},
+		{"@hourly", "2020-12-31 23:59", "2021-01-01 00:00"},
+		{"30 2 1 * *", "2020-01-31 10:00", "2020-02-01 02:30"},
+		// 2020-05-10 is a sunday
+		{"0 9 * * mon-fri", "2020-05-09 10:00", "2020-05-11 09:00"},
+		{"0 9 * * 7", "2020-05-04 10:00", "2020-05-10 09:00"},
+		// any of the day fields matches when both are restricted
+		{"0 0 20 * mon", "2020-05-10 10:00", "2020-05-11 00:00"},
+		{"0 0 29 feb *", "2020-03-01 00:00", "2024-02-29 00:00"},
+	}
+
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		require.NoError(t, err, c.expr)
+		assert.Equal(t, date(c.expected), s.Next(date(c.from)), c.expr)
+	}
+
+	s, err := Parse("0 0 30 feb *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(date("2020-01-01 00:00")).IsZero())
+}
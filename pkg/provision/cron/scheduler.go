@@ -0,0 +1,474 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// ConcurrencyPolicy is what to do when a job is due while a previous run is still running
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyForbid skips the new run, this is the default
+	ConcurrencyForbid ConcurrencyPolicy = "forbid"
+	// ConcurrencyReplace stops the running run before starting the new one
+	ConcurrencyReplace ConcurrencyPolicy = "replace"
+	// ConcurrencyAllow starts the new run next to the running ones, as long
+	// as the job has a free slot
+	ConcurrencyAllow ConcurrencyPolicy = "allow"
+)
+
+const (
+	// DefaultHistory is the number of finished runs kept by default
+	DefaultHistory = 10
+
+	tick = 10 * time.Second
+)
+
+// Job is a workload run on a schedule
+type Job struct {
+	// ID of the job
+	ID string `json:"id"`
+	// Schedule is the cron expression of the job
+	Schedule string `json:"schedule"`
+	// Concurrency is the policy applied when a run is due while the
+	// previous one is still running
+	Concurrency ConcurrencyPolicy `json:"concurrency"`
+	// History is the number of finished runs kept, defaults to DefaultHistory
+	History uint `json:"history"`
+	// Timeout stops the runs that take longer, 0 means no limit
+	Timeout time.Duration `json:"timeout"`
+	// Slots is the max number of concurrent runs with the allow policy
+	Slots int `json:"slots"`
+}
+
+// Valid checks that the job is valid
+func (j *Job) Valid() error {
+	if len(j.ID) == 0 || strings.ContainsAny(j.ID, "/\\") || j.ID == "." || j.ID == ".." {
+		return fmt.Errorf("invalid job id '%s'", j.ID)
+	}
+
+	if _, err := Parse(j.Schedule); err != nil {
+		return err
+	}
+
+	switch j.Concurrency {
+	case "", ConcurrencyForbid, ConcurrencyReplace:
+	case ConcurrencyAllow:
+		if j.Slots <= 0 {
+			return fmt.Errorf("jobs allowing concurrent runs need at least one slot")
+		}
+	default:
+		return fmt.Errorf("unknown concurrency policy '%s'", j.Concurrency)
+	}
+
+	return nil
+}
+
+func (j *Job) history() int {
+	if j.History == 0 {
+		return DefaultHistory
+	}
+	return int(j.History)
+}
+
+// Run is a run of a job
+type Run struct {
+	pkg.JobRun
+	// Slot of the run among the concurrent runs of the job
+	Slot int `json:"slot"`
+}
+
+// Runner starts and watches the runs of the jobs
+type Runner interface {
+	// StartRun starts a run of job
+	StartRun(job Job, run Run) error
+	// RunStatus returns the status of a run
+	RunStatus(job Job, run Run) (pkg.ContainerStatus, error)
+	// StopRun stops a run if it's still running and frees its resources,
+	// it's called for all the runs once they are over
+	StopRun(job Job, run Run) error
+}
+
+type entry struct {
+	Job Job `json:"job"`
+	// Runs are the runs of the job, most recent first
+	Runs []Run `json:"runs"`
+
+	schedule Schedule
+	next     time.Time
+}
+
+// running returns the runs still running
+func (e *entry) running() []Run {
+	var runs []Run
+	for _, run := range e.Runs {
+		if run.Running {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// trim drops the oldest finished runs beyond the history of the job
+func (e *entry) trim() {
+	runs := e.Runs[:0]
+	finished := 0
+	for _, run := range e.Runs {
+		if !run.Running {
+			if finished >= e.Job.history() {
+				continue
+			}
+			finished++
+		}
+		runs = append(runs, run)
+	}
+	e.Runs = runs
+}
+
+var _ pkg.JobMonitor = (*Scheduler)(nil)
+
+// Scheduler starts the runs of the jobs on their schedule and keeps
+// their history. The jobs and their history are kept on disk so they
+// survive a restart
+type Scheduler struct {
+	root string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+}
+
+// NewScheduler creates a scheduler that keeps its state in root, the jobs
+// found in root are scheduled again. The runs that were due while the
+// scheduler was not running are skipped
+func NewScheduler(root string) (*Scheduler, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create jobs directory")
+	}
+
+	s := &Scheduler{
+		root: root,
+		now:  func() time.Time { return time.Now().UTC() },
+		jobs: make(map[string]*entry),
+	}
+
+	files, err := filepath.Glob(filepath.Join(root, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Error().Err(err).Str("file", file).Msg("invalid job, skipping")
+			continue
+		}
+
+		if e.schedule, err = Parse(e.Job.Schedule); err != nil {
+			log.Error().Err(err).Str("job", e.Job.ID).Msg("invalid job schedule, skipping")
+			continue
+		}
+
+		e.next = e.schedule.Next(s.now())
+		s.jobs[e.Job.ID] = &e
+	}
+
+	return s, nil
+}
+
+func (s *Scheduler) path(id string) string {
+	return filepath.Join(s.root, fmt.Sprintf("%s.json", id))
+}
+
+func (s *Scheduler) save(e *entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(e.Job.ID)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Add schedules a job, adding a job that is already scheduled
+// updates it and keeps its history. It returns the next run time
+func (s *Scheduler) Add(job Job) (time.Time, error) {
+	if err := job.Valid(); err != nil {
+		return time.Time{}, err
+	}
+
+	schedule, err := Parse(job.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.jobs[job.ID]
+	if !ok {
+		e = &entry{}
+	}
+
+	e.Job = job
+	e.schedule = schedule
+	e.next = schedule.Next(s.now())
+	e.trim()
+
+	if err := s.save(e); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to save job")
+	}
+
+	s.jobs[job.ID] = e
+	return e.next, nil
+}
+
+// Remove unschedules a job and drops its history. It returns the runs
+// still running, it's up to the caller to stop them
+func (s *Scheduler) Remove(id string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	delete(s.jobs, id)
+	return e.running(), nil
+}
+
+// JobRuns implements pkg.JobMonitor
+func (s *Scheduler) JobRuns(id string) ([]pkg.JobRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job '%s' not found", id)
+	}
+
+	runs := make([]pkg.JobRun, 0, len(e.Runs))
+	for _, run := range e.Runs {
+		runs = append(runs, run.JobRun)
+	}
+
+	return runs, nil
+}
+
+// Run starts the runs of the jobs when they are due until ctx is canceled
+func (s *Scheduler) Run(ctx context.Context, runner Runner) {
+	for {
+		s.tick(runner)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tick):
+		}
+	}
+}
+
+func (s *Scheduler) tick(runner Runner) {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.jobs))
+	for id := range s.jobs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	sort.Strings(ids)
+	for _, id := range ids {
+		s.refresh(runner, id)
+		s.trigger(runner, id)
+	}
+}
+
+// update replaces the record of run and saves the job. It returns false if
+// the job is not scheduled anymore
+func (s *Scheduler) update(id string, run Run) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+
+	found := false
+	for i := range e.Runs {
+		if e.Runs[i].ID == run.ID {
+			e.Runs[i] = run
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		e.Runs = append([]Run{run}, e.Runs...)
+	}
+
+	e.trim()
+	if err := s.save(e); err != nil {
+		log.Error().Err(err).Str("job", id).Msg("failed to save job")
+	}
+
+	return true
+}
+
+// refresh checks the running runs of a job, the runs that exited or
+// timed out are stopped
+func (s *Scheduler) refresh(runner Runner, id string) {
+	s.mu.Lock()
+	e, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	job, running := e.Job, e.running()
+	s.mu.Unlock()
+
+	for _, run := range running {
+		now := s.now()
+		status, err := runner.RunStatus(job, run)
+		switch {
+		case err != nil:
+			run.Error = fmt.Sprintf("failed to get run status: %s", err)
+		case status.Running && job.Timeout > 0 && now.Sub(run.Started) > job.Timeout:
+			run.Error = "timed out"
+		case status.Running:
+			continue
+		default:
+			run.ExitStatus = status.ExitStatus
+			run.Finished = status.ExitedAt
+		}
+
+		if err := runner.StopRun(job, run); err != nil {
+			log.Error().Err(err).Str("job", id).Str("run", run.ID).Msg("failed to stop job run")
+		}
+
+		run.Running = false
+		if run.Finished.IsZero() {
+			run.Finished = now
+		}
+
+		log.Info().Str("job", id).Str("run", run.ID).Uint32("exit", run.ExitStatus).Str("error", run.Error).Msg("job run finished")
+		s.update(id, run)
+	}
+}
+
+// trigger starts a new run of the job if it's due
+func (s *Scheduler) trigger(runner Runner, id string) {
+	now := s.now()
+
+	s.mu.Lock()
+	e, ok := s.jobs[id]
+	if !ok || e.next.IsZero() || now.Before(e.next) {
+		s.mu.Unlock()
+		return
+	}
+
+	run := Run{
+		JobRun: pkg.JobRun{
+			ID:        fmt.Sprintf("%s-%d", id, e.next.Unix()),
+			Scheduled: e.next,
+		},
+	}
+	e.next = e.schedule.Next(now)
+
+	job, running := e.Job, e.running()
+	var replaced []Run
+	switch job.Concurrency {
+	case ConcurrencyReplace:
+		replaced = running
+	case ConcurrencyAllow:
+		run.Slot = freeSlot(running, job.Slots)
+		if run.Slot < 0 {
+			run.Error = "skipped, all the slots of the job are busy"
+		}
+	default:
+		if len(running) > 0 {
+			run.Error = "skipped, the previous run is still running"
+		}
+	}
+	s.mu.Unlock()
+
+	if len(run.Error) != 0 {
+		run.Started, run.Finished = now, now
+		log.Info().Str("job", id).Str("run", run.ID).Msg(run.Error)
+		s.update(id, run)
+		return
+	}
+
+	for _, old := range replaced {
+		if err := runner.StopRun(job, old); err != nil {
+			log.Error().Err(err).Str("job", id).Str("run", old.ID).Msg("failed to stop job run")
+		}
+
+		old.Running = false
+		old.Finished = s.now()
+		old.Error = fmt.Sprintf("replaced by %s", run.ID)
+		s.update(id, old)
+	}
+
+	run.Started = s.now()
+	if err := runner.StartRun(job, run); err != nil {
+		run.Error = fmt.Sprintf("failed to start: %s", err)
+		run.Finished = s.now()
+		log.Error().Err(err).Str("job", id).Str("run", run.ID).Msg("failed to start job run")
+	} else {
+		run.Running = true
+		log.Info().Str("job", id).Str("run", run.ID).Msg("job run started")
+	}
+
+	if !s.update(id, run) || !run.Running {
+		// the job was removed while the run was starting, or it
+		// failed to start, either way what's left must be freed
+		if err := runner.StopRun(job, run); err != nil {
+			log.Error().Err(err).Str("job", id).Str("run", run.ID).Msg("failed to stop job run")
+		}
+	}
+}
+
+// freeSlot returns the first slot not used by the running runs, -1 if all
+// the slots are busy
+func freeSlot(running []Run, slots int) int {
+	busy := make(map[int]bool)
+	for _, run := range running {
+		busy[run.Slot] = true
+	}
+
+	for slot := 0; slot < slots; slot++ {
+		if !busy[slot] {
+			return slot
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,208 @@
+package cron
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+type testRunner struct {
+	started []Run
+	stopped []string
+	running map[string]bool
+	failing bool
+}
+
+func newTestRunner() *testRunner {
+	return &testRunner{running: make(map[string]bool)}
+}
+
+func (r *testRunner) StartRun(job Job, run Run) error {
+	if r.failing {
+		return fmt.Errorf("no capacity")
+	}
+	r.started = append(r.started, run)
+	r.running[run.ID] = true
+	return nil
+}
+
+func (r *testRunner) RunStatus(job Job, run Run) (pkg.ContainerStatus, error) {
+	return pkg.ContainerStatus{Running: r.running[run.ID]}, nil
+}
+
+func (r *testRunner) StopRun(job Job, run Run) error {
+	r.stopped = append(r.stopped, run.ID)
+	delete(r.running, run.ID)
+	return nil
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func testScheduler(t *testing.T) (*Scheduler, *clock, func()) {
+	root, err := ioutil.TempDir("", "jobs")
+	require.NoError(t, err)
+
+	s, err := NewScheduler(root)
+	require.NoError(t, err)
+
+	c := &clock{now: time.Date(2020, 5, 10, 10, 0, 30, 0, time.UTC)}
+	s.now = c.Now
+
+	return s, c, func() { os.RemoveAll(root) }
+}
+
+func TestSchedulerForbid(t *testing.T) {
+	s, c, clean := testScheduler(t)
+	defer clean()
+
+	next, err := s.Add(Job{ID: "job", Schedule: "* * * * *"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 5, 10, 10, 1, 0, 0, time.UTC), next)
+
+	runner := newTestRunner()
+	s.tick(runner)
+	assert.Len(t, runner.started, 0)
+
+	c.now = c.now.Add(time.Minute)
+	s.tick(runner)
+	require.Len(t, runner.started, 1)
+	assert.Equal(t, fmt.Sprintf("job-%d", next.Unix()), runner.started[0].ID)
+
+	// the previous run is still running
+	c.now = c.now.Add(time.Minute)
+	s.tick(runner)
+	assert.Len(t, runner.started, 1)
+
+	runs, err := s.JobRuns("job")
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.False(t, runs[0].Running)
+	assert.Contains(t, runs[0].Error, "skipped")
+	assert.True(t, runs[1].Running)
+
+	// the run exits, it's stopped to free its resources
+	runner.running = map[string]bool{}
+	c.now = c.now.Add(time.Minute)
+	s.tick(runner)
+	assert.Equal(t, []string{runs[1].ID}, runner.stopped)
+	assert.Len(t, runner.started, 2)
+}
+
+func TestSchedulerReplace(t *testing.T) {
+	s, c, clean := testScheduler(t)
+	defer clean()
+
+	_, err := s.Add(Job{ID: "job", Schedule: "* * * * *", Concurrency: ConcurrencyReplace})
+	require.NoError(t, err)
+
+	runner := newTestRunner()
+	c.now = c.now.Add(time.Minute)
+	s.tick(runner)
+	c.now = c.now.Add(time.Minute)
+	s.tick(runner)
+
+	require.Len(t, runner.started, 2)
+	assert.Equal(t, []string{runner.started[0].ID}, runner.stopped)
+
+	runs, err := s.JobRuns("job")
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.True(t, runs[0].Running)
+	assert.Contains(t, runs[1].Error, "replaced")
+}
+
+func TestSchedulerAllow(t *testing.T) {
+	s, c, clean := testScheduler(t)
+	defer clean()
+
+	_, err := s.Add(Job{ID: "job", Schedule: "* * * * *", Concurrency: ConcurrencyAllow, Slots: 2})
+	require.NoError(t, err)
+
+	runner := newTestRunner()
+	for i := 0; i < 3; i++ {
+		c.now = c.now.Add(time.Minute)
+		s.tick(runner)
+	}
+
+	require.Len(t, runner.started, 2)
+	assert.Equal(t, 0, runner.started[0].Slot)
+	assert.Equal(t, 1, runner.started[1].Slot)
+
+	runs, err := s.JobRuns("job")
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	assert.Contains(t, runs[0].Error, "slots")
+
+	// removing the job returns the runs still running
+	running, err := s.Remove("job")
+	require.NoError(t, err)
+	assert.Len(t, running, 2)
+
+	_, err = s.JobRuns("job")
+	assert.Error(t, err)
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	s, c, clean := testScheduler(t)
+	defer clean()
+
+	_, err := s.Add(Job{ID: "job", Schedule: "0 * * * *", Timeout: 5 * time.Minute})
+	require.NoError(t, err)
+
+	runner := newTestRunner()
+	c.now = c.now.Add(time.Hour)
+	s.tick(runner)
+	require.Len(t, runner.started, 1)
+
+	c.now = c.now.Add(10 * time.Minute)
+	s.tick(runner)
+	assert.Equal(t, []string{runner.started[0].ID}, runner.stopped)
+
+	runs, err := s.JobRuns("job")
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.False(t, runs[0].Running)
+	assert.Equal(t, "timed out", runs[0].Error)
+}
+
+func TestSchedulerHistory(t *testing.T) {
+	s, c, clean := testScheduler(t)
+	defer clean()
+
+	_, err := s.Add(Job{ID: "job", Schedule: "* * * * *", History: 3})
+	require.NoError(t, err)
+
+	runner := newTestRunner()
+	runner.failing = true
+	for i := 0; i < 5; i++ {
+		c.now = c.now.Add(time.Minute)
+		s.tick(runner)
+	}
+
+	runs, err := s.JobRuns("job")
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	for _, run := range runs {
+		assert.Contains(t, run.Error, "failed to start")
+	}
+
+	// the jobs and their history are reloaded
+	loaded, err := NewScheduler(s.root)
+	require.NoError(t, err)
+	reloaded, err := loaded.JobRuns("job")
+	require.NoError(t, err)
+	assert.Len(t, reloaded, 3)
+	assert.Equal(t, runs[0].ID, reloaded[0].ID)
+}
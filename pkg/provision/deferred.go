@@ -0,0 +1,100 @@
+package provision
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxDeferred is the number of reservations a user can have waiting, the
+// new reservations of a user past that are rejected so a throttled user
+// can't grow the queue forever
+const maxDeferred = 100
+
+// errDeferredFull is returned when a user has too many deferred reservations
+var errDeferredFull = fmt.Errorf("too many reservations waiting to be provisioned, retry later")
+
+// deferredQueue holds the reservations of the throttled users. The
+// reservations of a user are kept in order, and are retried once the user
+// is allowed to provision again
+type deferredQueue struct {
+	users map[string]*deferredUser
+}
+
+type deferredUser struct {
+	// at is when the first reservation is retried
+	at           time.Time
+	reservations []*Reservation
+}
+
+// has checks if user has deferred reservations
+func (q *deferredQueue) has(user string) bool {
+	_, ok := q.users[user]
+	return ok
+}
+
+// push defers r after the other reservations of its user, it returns when
+// the first of them is retried. errDeferredFull is returned if the user
+// already has maxDeferred reservations waiting
+func (q *deferredQueue) push(r *Reservation) (time.Time, error) {
+	u, ok := q.users[r.User]
+	if !ok {
+		return q.retry(r, time.Now()), nil
+	}
+
+	if len(u.reservations) >= maxDeferred {
+		return u.at, errDeferredFull
+	}
+
+	u.reservations = append(u.reservations, r)
+	return u.at, nil
+}
+
+// retry defers r before the other reservations of its user, until at
+func (q *deferredQueue) retry(r *Reservation, at time.Time) time.Time {
+	if q.users == nil {
+		q.users = make(map[string]*deferredUser)
+	}
+
+	u, ok := q.users[r.User]
+	if !ok {
+		u = &deferredUser{}
+		q.users[r.User] = u
+	}
+
+	u.at = at
+	u.reservations = append([]*Reservation{r}, u.reservations...)
+	return at
+}
+
+// next returns when the next reservation is retried, ok is false if no
+// reservation is deferred
+func (q *deferredQueue) next() (at time.Time, ok bool) {
+	for _, u := range q.users {
+		if !ok || u.at.Before(at) {
+			at, ok = u.at, true
+		}
+	}
+
+	return at, ok
+}
+
+// pop removes the next reservation to retry, the queue must not be empty
+func (q *deferredQueue) pop() *Reservation {
+	var (
+		user string
+		next *deferredUser
+	)
+	for name, u := range q.users {
+		if next == nil || u.at.Before(next.at) {
+			user, next = name, u
+		}
+	}
+
+	r := next.reservations[0]
+	next.reservations = next.reservations[1:]
+	if len(next.reservations) == 0 {
+		delete(q.users, user)
+	}
+
+	return r
+}
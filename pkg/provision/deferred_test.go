@@ -0,0 +1,85 @@
+package provision
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferredQueue(t *testing.T) {
+	var q deferredQueue
+
+	_, ok := q.next()
+	assert.False(t, ok)
+
+	now := time.Now()
+	a1 := &Reservation{ID: "a1", User: "a"}
+	a2 := &Reservation{ID: "a2", User: "a"}
+	a3 := &Reservation{ID: "a3", User: "a"}
+	b1 := &Reservation{ID: "b1", User: "b"}
+
+	q.retry(a1, now.Add(2*time.Second))
+	assert.True(t, q.has("a"))
+	assert.False(t, q.has("b"))
+
+	// the next reservations of a wait after a1
+	at, err := q.push(a2)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Second), at)
+	q.retry(b1, now.Add(time.Second))
+
+	at, ok = q.next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Second), at)
+
+	assert.Equal(t, b1, q.pop())
+	assert.False(t, q.has("b"))
+
+	// a1 is still throttled, it stays first
+	assert.Equal(t, a1, q.pop())
+	q.retry(a1, now.Add(3*time.Second))
+	_, err = q.push(a3)
+	require.NoError(t, err)
+
+	for _, expected := range []*Reservation{a1, a2, a3} {
+		assert.Equal(t, expected, q.pop())
+	}
+
+	_, ok = q.next()
+	assert.False(t, ok)
+}
+
+func TestDeferredQueueFull(t *testing.T) {
+	var q deferredQueue
+
+	now := time.Now()
+	q.retry(&Reservation{ID: "a0", User: "a"}, now.Add(time.Second))
+	for i := 1; i < maxDeferred; i++ {
+		_, err := q.push(&Reservation{ID: fmt.Sprintf("a%d", i), User: "a"})
+		require.NoError(t, err)
+	}
+
+	// the user can't queue more reservations
+	_, err := q.push(&Reservation{ID: "full", User: "a"})
+	assert.Equal(t, errDeferredFull, err)
+
+	// the other users are not affected
+	q.retry(&Reservation{ID: "b0", User: "b"}, now.Add(2*time.Second))
+	_, err = q.push(&Reservation{ID: "b1", User: "b"})
+	require.NoError(t, err)
+
+	// a retried reservation goes back to its place
+	r := q.pop()
+	assert.Equal(t, "a0", r.ID)
+	q.retry(r, now.Add(time.Second))
+	_, err = q.push(&Reservation{ID: "full", User: "a"})
+	assert.Equal(t, errDeferredFull, err)
+
+	// and there is room again once a reservation is processed
+	q.pop()
+	_, err = q.push(&Reservation{ID: "a100", User: "a"})
+	assert.NoError(t, err)
+}
@@ -1,6 +1,7 @@
 package provision
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
@@ -8,6 +9,10 @@ import (
 	"time"
 
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/critical"
+	"github.com/threefoldtech/zos/pkg/ratelimit"
+	"github.com/threefoldtech/zos/pkg/tracing"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -21,9 +26,14 @@ type Engine struct {
 	cache          ReservationCache
 	feedback       Feedbacker
 	provisioners   map[ReservationType]ProvisionerFunc
+	updaters       map[ReservationType]UpdaterFunc
 	decomissioners map[ReservationType]DecomissionerFunc
 	signer         Signer
 	statser        Statser
+	limiter        *ratelimit.Limiter
+	audit          *audit.Logger
+	queue          *Queue
+	critical       *critical.Tracker
 }
 
 // EngineOps are the configuration of the engine
@@ -41,6 +51,10 @@ type EngineOps struct {
 	// Provisioners is a function map so the engine knows how to provision the different
 	// workloads supported by the system running the engine
 	Provisioners map[ReservationType]ProvisionerFunc
+	// Updaters are used to update in place the workloads already deployed when
+	// a new version of their reservation is received. The types without updater
+	// need to be deleted and deployed again to change
+	Updaters map[ReservationType]UpdaterFunc
 	// Decomissioners contains the opposite function from Provisioners
 	// they are used to decomission workloads from the system
 	Decomissioners map[ReservationType]DecomissionerFunc
@@ -50,6 +64,19 @@ type EngineOps struct {
 	// are reserved on the system running the engine
 	// After each provision/decomission the engine sends statistics update to the staster
 	Statser Statser
+	// Limiter throttles the provisioning requests per user, so a misbehaving client
+	// can't hammer the node modules. If nil, no throttling is done
+	Limiter *ratelimit.Limiter
+	// Audit records every provision and decommission in the node audit log.
+	// If nil, nothing is recorded
+	Audit *audit.Logger
+	// Queue is the durable queue the Source is wrapped with, reservations
+	// are removed from it once processed. If nil, nothing is acknowledged
+	Queue *Queue
+	// Critical marks the reservations being processed as critical
+	// operations, so the upgrades don't restart the modules meanwhile.
+	// If nil, nothing is marked
+	Critical *critical.Tracker
 }
 
 // New creates a new engine. Once started, the engine
@@ -65,9 +92,14 @@ func New(opts EngineOps) *Engine {
 		cache:          opts.Cache,
 		feedback:       opts.Feedback,
 		provisioners:   opts.Provisioners,
+		updaters:       opts.Updaters,
 		decomissioners: opts.Decomissioners,
 		signer:         opts.Signer,
 		statser:        opts.Statser,
+		limiter:        opts.Limiter,
+		audit:          opts.Audit,
+		queue:          opts.Queue,
+		critical:       opts.Critical,
 	}
 }
 
@@ -83,48 +115,145 @@ func (e *Engine) Run(ctx context.Context) error {
 
 	cReservation := e.source.Reservations(ctx)
 
+	// the reservations of the throttled users wait here for their turn
+	// while the other reservations go on. They stay in the queue, so
+	// they are processed on next start if the engine stops meanwhile
+	var waiting deferredQueue
 	for {
+		var (
+			timer *time.Timer
+			ready <-chan time.Time
+		)
+		if next, ok := waiting.next(); ok {
+			timer = time.NewTimer(time.Until(next))
+			ready = timer.C
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Info().Msg("provision engine context done, exiting")
 			return nil
 
+		case <-ready:
+			e.process(ctx, waiting.pop(), &waiting, true)
+
 		case reservation, ok := <-cReservation:
 			if !ok {
 				log.Info().Msg("reservation source is emptied. stopping engine")
 				return nil
 			}
 
-			expired := reservation.Expired()
-			slog := log.With().
-				Str("id", string(reservation.ID)).
-				Str("type", string(reservation.Type)).
-				Str("duration", fmt.Sprintf("%v", reservation.Duration)).
-				Str("tag", reservation.Tag.String()).
-				Bool("to-delete", reservation.ToDelete).
-				Bool("expired", expired).
-				Logger()
-
-			if expired || reservation.ToDelete {
-				slog.Info().Msg("start decommissioning reservation")
-				if err := e.decommission(ctx, reservation); err != nil {
-					log.Error().Err(err).Msgf("failed to decommission reservation %s", reservation.ID)
-					continue
-				}
-			} else {
-				slog.Info().Msg("start provisioning reservation")
-				if err := e.provision(ctx, reservation); err != nil {
-					log.Error().Err(err).Msgf("failed to provision reservation %s", reservation.ID)
-					continue
-				}
-			}
+			e.process(ctx, reservation, &waiting, false)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
 
-			if err := e.updateStats(); err != nil {
-				log.Error().Err(err).Msg("failed to updated the capacity counters")
+// process provisions or decommissions reservation, the provisioning is
+// deferred if its user is throttled. retried is set for the reservations
+// coming out of waiting
+func (e *Engine) process(ctx context.Context, reservation *Reservation, waiting *deferredQueue, retried bool) {
+	expired := reservation.Expired()
+	slog := log.With().
+		Str("id", string(reservation.ID)).
+		Str("type", string(reservation.Type)).
+		Str("duration", fmt.Sprintf("%v", reservation.Duration)).
+		Str("tag", reservation.Tag.String()).
+		Bool("to-delete", reservation.ToDelete).
+		Bool("expired", expired).
+		Logger()
+
+	if expired || reservation.ToDelete {
+		slog.Info().Msg("start decommissioning reservation")
+		done := e.critical.Begin("decommission " + reservation.ID)
+		sctx, span := e.trace(ctx, "decommission", reservation)
+		err := e.decommission(sctx, reservation)
+		span.Finish(err)
+		done()
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to decommission reservation %s", reservation.ID)
+			e.ack(reservation)
+			return
+		}
+	} else {
+		retry, ok, err := e.throttle(reservation, waiting, retried)
+		if err != nil {
+			slog.Error().Err(err).Msg("user is provisioning too fast, reservation rejected")
+			if replyErr := e.reply(ctx, reservation, err, nil); replyErr != nil {
+				log.Error().Err(replyErr).Msg("failed to send result to BCDB")
 			}
+			e.ack(reservation)
+			return
+		}
+		if !ok {
+			slog.Warn().Str("retry", retry.String()).Msg("user is provisioning too fast, reservation deferred")
+			return
+		}
 
+		slog.Info().Msg("start provisioning reservation")
+		done := e.critical.Begin("provision " + reservation.ID)
+		sctx, span := e.trace(ctx, "provision", reservation)
+		err = e.provision(sctx, reservation)
+		span.Finish(err)
+		done()
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to provision reservation %s", reservation.ID)
+			e.ack(reservation)
+			return
 		}
 	}
+
+	e.ack(reservation)
+	if err := e.updateStats(); err != nil {
+		log.Error().Err(err).Msg("failed to updated the capacity counters")
+	}
+}
+
+// ack removes a processed reservation from the queue. Failed reservations
+// are acknowledged too, their error is already reported to the explorer
+func (e *Engine) ack(r *Reservation) {
+	if err := e.queue.Done(r); err != nil {
+		log.Error().Err(err).Str("id", r.ID).Msg("failed to remove reservation from queue")
+	}
+}
+
+// trace starts the root span of the processing of reservation
+func (e *Engine) trace(ctx context.Context, operation string, r *Reservation) (context.Context, *tracing.Span) {
+	// the modules called for the reservation account the calls to its user
+	ctx, span := tracing.Start(tracing.WithCaller(ctx, r.User), operation)
+	span.SetAttribute("reservation.id", r.ID)
+	span.SetAttribute("reservation.type", string(r.Type))
+	span.SetAttribute("user", r.User)
+	return ctx, span
+}
+
+// throttle checks if the user of r is allowed to provision a new
+// reservation. If not, r is deferred and the time before it's retried is
+// returned. The new reservations of a user with deferred reservations are
+// deferred after them, so the reservations of a user keep their order. An
+// error is returned if r can't be deferred because its user already has too
+// many reservations waiting
+func (e *Engine) throttle(r *Reservation, waiting *deferredQueue, retried bool) (time.Duration, bool, error) {
+	if !retried && waiting.has(r.User) {
+		at, err := waiting.push(r)
+		return time.Until(at), false, err
+	}
+
+	err := e.limiter.Allow(r.User)
+	if err == nil {
+		return 0, true, nil
+	}
+
+	retry := time.Second
+	if limited, ok := err.(ratelimit.ErrRateLimited); ok {
+		retry = limited.RetryAfter
+	}
+
+	waiting.retry(r, time.Now().Add(retry))
+	return retry, false, nil
 }
 
 func (e *Engine) provision(ctx context.Context, r *Reservation) error {
@@ -137,13 +266,19 @@ func (e *Engine) provision(ctx context.Context, r *Reservation) error {
 		return fmt.Errorf("type of reservation not supported: %s", r.Type)
 	}
 
-	_, err := e.cache.Get(r.ID)
+	current, err := e.cache.Get(r.ID)
 	if err == nil {
-		log.Info().Str("id", r.ID).Msg("reservation already deployed")
-		return nil
+		// a new version of a reservation is signed again by its user
+		if bytes.Equal(current.Signature, r.Signature) {
+			log.Info().Str("id", r.ID).Msg("reservation already deployed")
+			return nil
+		}
+
+		return e.update(ctx, current, r)
 	}
 
 	result, err := fn(ctx, r)
+	e.audit.Record("provision", r.User, r.ID, r.Data, err)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -174,6 +309,53 @@ func (e *Engine) provision(ctx context.Context, r *Reservation) error {
 	return nil
 }
 
+// update replaces the deployed reservation current with its new version r
+func (e *Engine) update(ctx context.Context, current, r *Reservation) error {
+	fn, ok := e.updaters[r.Type]
+	if !ok {
+		err := fmt.Errorf("reservation of type %s cannot be updated in place, it needs to be deleted and deployed again", r.Type)
+		if replyErr := e.reply(ctx, r, err, nil); replyErr != nil {
+			log.Error().Err(replyErr).Msg("failed to send result to BCDB")
+		}
+		return err
+	}
+
+	log.Info().Str("id", r.ID).Msg("updating reservation in place")
+
+	result, err := fn(ctx, current, r)
+	e.audit.Record("update", r.User, r.ID, r.Data, err)
+	if err != nil {
+		log.Error().Err(err).Str("id", r.ID).Msg("failed to apply update")
+	} else {
+		log.Info().Str("result", fmt.Sprintf("%v", result)).Msg("workload updated")
+	}
+
+	if replyErr := e.reply(ctx, r, err, result); replyErr != nil {
+		log.Error().Err(replyErr).Msg("failed to send result to BCDB")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := e.cache.Remove(r.ID); err != nil {
+		return errors.Wrapf(err, "failed to remove previous version of reservation %s from cache", r.ID)
+	}
+
+	if err := e.cache.Add(r); err != nil {
+		return errors.Wrapf(err, "failed to cache reservation %s locally", r.ID)
+	}
+
+	if err := e.statser.Decrement(current); err != nil {
+		log.Err(err).Str("reservation_id", r.ID).Msg("failed to decrement workloads statistics")
+	}
+	if err := e.statser.Increment(r); err != nil {
+		log.Err(err).Str("reservation_id", r.ID).Msg("failed to increment workloads statistics")
+	}
+
+	return nil
+}
+
 func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
 	fn, ok := e.decomissioners[r.Type]
 	if !ok {
@@ -194,6 +376,7 @@ func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
 	}
 
 	err = fn(ctx, r)
+	e.audit.Record("decommission", r.User, r.ID, r.Data, err)
 	if err != nil {
 		return errors.Wrap(err, "decommissioning of reservation failed")
 	}
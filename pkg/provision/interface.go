@@ -18,6 +18,10 @@ type ReservationSource interface {
 // ProvisionerFunc is the function called by the Engine to provision a workload
 type ProvisionerFunc func(ctx context.Context, reservation *Reservation) (interface{}, error)
 
+// UpdaterFunc is the function called by the Engine to update in place a workload
+// already deployed. current is the reservation deployed, reservation its new version
+type UpdaterFunc func(ctx context.Context, current, reservation *Reservation) (interface{}, error)
+
 // DecomissionerFunc is the function called by the Engine to decomission a workload
 type DecomissionerFunc func(ctx context.Context, reservation *Reservation) error
 
@@ -0,0 +1,196 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// BlockProtocol is the protocol a virtual disk is exported with
+type BlockProtocol string
+
+const (
+	// BlockProtocolNBD exports the disk with the network block device protocol
+	BlockProtocolNBD BlockProtocol = "nbd"
+	// BlockProtocolISCSI exports the disk as an iSCSI target
+	BlockProtocolISCSI BlockProtocol = "iscsi"
+
+	// blockDevicePath is where the disk is mounted in the server container
+	blockDevicePath = "/export/disk"
+	// iqnPrefix is the prefix of the name of the iSCSI targets
+	iqnPrefix = "iqn.2020-01.tech.threefold"
+)
+
+// TODO: make this configurable
+var blockFlists = map[BlockProtocol]string{
+	BlockProtocolNBD:   "https://hub.grid.tf/tf-official-apps/nbd-server:latest.flist",
+	BlockProtocolISCSI: "https://hub.grid.tf/tf-official-apps/tgt:latest.flist",
+}
+
+var blockPorts = map[BlockProtocol]uint{
+	BlockProtocolNBD:   10809,
+	BlockProtocolISCSI: 3260,
+}
+
+// BlockExport is a virtual disk exported over the network, so the
+// virtual machines of the user network running on other nodes
+// can use it as their block storage
+type BlockExport struct {
+	// Size of the disk in GiB
+	Size uint64 `json:"size"`
+	// Protocol is the protocol the disk is exported with
+	Protocol BlockProtocol `json:"protocol"`
+	// ReadOnly exports the disk read-only
+	ReadOnly bool `json:"read_only"`
+	// Network is the user network the disk is exported in, only the
+	// members of the network can reach the server
+	Network Network `json:"network"`
+	// Capacity is the amount of resource to allocate to the server
+	Capacity ContainerCapacity `json:"capacity"`
+}
+
+// BlockExportResult is the information return to the BCDB
+// after exporting a disk
+type BlockExportResult struct {
+	ID   string `json:"id"`
+	IPv4 string `json:"ipv4"`
+	Port uint   `json:"port"`
+	// Export is the NBD export name or the iSCSI target name of the disk
+	Export string `json:"export"`
+}
+
+func (p *Provisioner) blockProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.blockProvisionImpl(ctx, reservation)
+}
+
+// blockProvisionImpl allocates the virtual disk of the reservation and runs
+// the server exporting it as a container joined to the user network
+func (p *Provisioner) blockProvisionImpl(ctx context.Context, reservation *provision.Reservation) (result BlockExportResult, err error) {
+	var config BlockExport
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return result, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateBlockConfig(config); err != nil {
+		return result, errors.Wrap(err, "block export schema not valid")
+	}
+
+	storage := stubs.NewVDiskModuleStub(p.zbus)
+
+	exists, err := storage.Exists(reservation.ID)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to check if disk exists")
+	}
+
+	var diskPath string
+	if exists {
+		info, err := storage.Inspect(reservation.ID)
+		if err != nil {
+			return result, errors.Wrap(err, "could not get path to existing disk")
+		}
+		diskPath = info.Path
+	} else {
+		diskPath, err = storage.Allocate(reservation.ID, int64(config.Size*1024))
+		if err != nil {
+			return result, errors.Wrap(err, "failed to allocate disk")
+		}
+
+		defer func() {
+			if err != nil {
+				_ = storage.Deallocate(reservation.ID)
+			}
+		}()
+	}
+
+	export := blockExportName(config.Protocol, reservation.ID)
+	container := Container{
+		FList: blockFlists[config.Protocol],
+		Env: map[string]string{
+			"DEVICE":    blockDevicePath,
+			"EXPORT":    export,
+			"PORT":      fmt.Sprint(blockPorts[config.Protocol]),
+			"READ_ONLY": fmt.Sprint(config.ReadOnly),
+			"CLIENTS":   networkClients(config.Network).String(),
+		},
+		Network:  config.Network,
+		Capacity: config.Capacity,
+	}
+
+	// the disk is bind mounted as a file at the device path
+	mounts := []pkg.MountInfo{
+		{Source: diskPath, Target: blockDevicePath},
+	}
+
+	server, err := p.containerRun(ctx, reservation, container, mounts)
+	if err != nil {
+		return result, err
+	}
+
+	log.Info().Str("id", reservation.ID).Str("protocol", string(config.Protocol)).Msg("disk exported")
+	return BlockExportResult{
+		ID:     server.ID,
+		IPv4:   server.IPv4,
+		Port:   blockPorts[config.Protocol],
+		Export: export,
+	}, nil
+}
+
+// blockDecommission stops the server then removes the disk
+func (p *Provisioner) blockDecommission(ctx context.Context, reservation *provision.Reservation) error {
+	// the network of the export is encoded
+	// the same way as the one of a container
+	if err := p.containerDecommission(ctx, reservation); err != nil {
+		return err
+	}
+
+	storage := stubs.NewVDiskModuleStub(p.zbus)
+	exists, err := storage.Exists(reservation.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if disk exists")
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := storage.Deallocate(reservation.ID); err != nil {
+		return errors.Wrapf(err, "failed to remove disk %s", reservation.ID)
+	}
+
+	return nil
+}
+
+// blockExportName is the name the clients use to
+// select the disk of the reservation id
+func blockExportName(protocol BlockProtocol, id string) string {
+	if protocol == BlockProtocolISCSI {
+		return fmt.Sprintf("%s:%s", iqnPrefix, id)
+	}
+
+	return id
+}
+
+func validateBlockConfig(config BlockExport) error {
+	if config.Size == 0 {
+		return fmt.Errorf("disk size cannot be 0")
+	}
+
+	if _, ok := blockFlists[config.Protocol]; !ok {
+		return fmt.Errorf("unsupported export protocol '%s'", config.Protocol)
+	}
+
+	if config.Network.NetworkID == "" || len(config.Network.IPs) == 0 {
+		return fmt.Errorf("network ID and IP are required")
+	}
+
+	if config.Network.IPs[0].To4() == nil {
+		return fmt.Errorf("the export IP must be an IPv4 address of the network")
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package primitives
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBlockConfig(t *testing.T) {
+	valid := BlockExport{
+		Size:     10,
+		Protocol: BlockProtocolNBD,
+		Network: Network{
+			NetworkID: "net",
+			IPs:       []net.IP{net.ParseIP("10.1.3.2")},
+		},
+	}
+	assert.NoError(t, validateBlockConfig(valid))
+
+	config := valid
+	config.Size = 0
+	assert.Error(t, validateBlockConfig(config))
+
+	config = valid
+	config.Protocol = "9p"
+	assert.Error(t, validateBlockConfig(config))
+
+	config = valid
+	config.Network.IPs = []net.IP{net.ParseIP("fd00::2")}
+	assert.Error(t, validateBlockConfig(config))
+}
+
+func TestBlockExportName(t *testing.T) {
+	assert.Equal(t, "1-2", blockExportName(BlockProtocolNBD, "1-2"))
+	assert.Equal(t, "iqn.2020-01.tech.threefold:1-2", blockExportName(BlockProtocolISCSI, "1-2"))
+}
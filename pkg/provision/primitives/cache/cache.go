@@ -213,6 +213,34 @@ func (s *Fs) GetExpired() ([]*provision.Reservation, error) {
 	return rs, nil
 }
 
+// List returns all the reservations that are not expired
+func (s *Fs) List() ([]*provision.Reservation, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	infos, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]*provision.Reservation, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || info.Size() == 0 {
+			continue
+		}
+
+		r, err := s.get(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		if !r.Expired() {
+			rs = append(rs, r)
+		}
+	}
+
+	return rs, nil
+}
+
 // Get retrieves a specific reservation using its ID
 // if returns a non nil error if the reservation is not present in the store
 func (s *Fs) Get(id string) (*provision.Reservation, error) {
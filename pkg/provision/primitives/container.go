@@ -7,15 +7,21 @@ import (
 	"net"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/cancel"
 	"github.com/threefoldtech/zos/pkg/container/logger"
 	"github.com/threefoldtech/zos/pkg/container/stats"
 	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/probe"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 )
 
 // Network struct
@@ -44,8 +50,18 @@ type Container struct {
 	// with the node public key. the env will be exposed to plain
 	// text to the entrypoint.
 	SecretEnv map[string]string `json:"secret_env"`
+	// SecretFiles are files that the value is encrypted with the node
+	// public key. each value is decrypted in the file /run/secrets/<name>,
+	// a tmpfs, so it never hits a disk of the node
+	SecretFiles map[string]string `json:"secret_files,omitempty"`
 	// Entrypoint the process to start inside the container
 	Entrypoint string `json:"entrypoint"`
+	// User is the <uid>[:<gid>] the entrypoint runs as, the gid defaults
+	// to the uid. The secret files are readable by the gid
+	User string `json:"user,omitempty"`
+	// Init are the steps to run before the entrypoint, like database
+	// migrations or rendering of configuration templates
+	Init []pkg.InitStep `json:"init,omitempty"`
 	// Interactivity enable Core X as PID 1 on the container
 	Interactive bool `json:"interactive"`
 	// Mounts extra mounts in the container
@@ -58,6 +74,27 @@ type Container struct {
 	Logs []logger.Logs `json:"logs,omitempty"`
 	// StatsAggregator container metrics backend
 	StatsAggregator []stats.Aggregator
+	// Probes are the readiness probes of the container
+	Probes []probe.Probe `json:"probes,omitempty"`
+	// Devices are the host devices the container needs, like tun or fuse
+	Devices []string `json:"devices,omitempty"`
+	// KVM exposes /dev/kvm in the container, the reservation fails
+	// on nodes without hardware virtualization
+	KVM bool `json:"kvm,omitempty"`
+	// Liveness are the checks contd runs to restart the container when it is not alive anymore
+	Liveness []pkg.LivenessCheck `json:"liveness,omitempty"`
+	// Restart is the policy applied when a liveness check fails
+	Restart pkg.RestartPolicy `json:"restart,omitempty"`
+	// CheckpointVolume is a volume of the user where the checkpoints of the
+	// container are kept, the container is then checkpointed before the node
+	// reboots and restored once it's back
+	CheckpointVolume string `json:"checkpoint_volume,omitempty"`
+	// Security restricts the syscalls of the container and confines it
+	// with an AppArmor profile or an SELinux label
+	Security pkg.SecurityProfile `json:"security,omitempty"`
+
+	// runOnce is set for the runs of the scheduled jobs
+	runOnce bool
 }
 
 // ContainerResult is the information return to the BCDB
@@ -78,6 +115,9 @@ type ContainerCapacity struct {
 	DiskType pkg.DeviceType `json:"disk_type"`
 	// DiskSize of the root fs in MiB
 	DiskSize uint64 `json:"disk_size"`
+	// IO caps the disk IO of the container on the disks
+	// backing its root fs and its volumes
+	IO pkg.IOLimit `json:"io"`
 }
 
 func (p *Provisioner) containerProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
@@ -86,28 +126,59 @@ func (p *Provisioner) containerProvision(ctx context.Context, reservation *provi
 
 // ContainerProvision is entry point to container reservation
 func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *provision.Reservation) (ContainerResult, error) {
-	containerClient := stubs.NewContainerModuleStub(p.zbus)
-	flistClient := stubs.NewFlisterStub(p.zbus)
-	storageClient := stubs.NewStorageModuleStub(p.zbus)
-
-	tenantNS := fmt.Sprintf("ns%s", reservation.User)
-	containerID := reservation.ID
-
 	var config Container
 	if err := json.Unmarshal(reservation.Data, &config); err != nil {
 		return ContainerResult{}, err
 	}
 
+	return p.containerRun(ctx, reservation, config, nil)
+}
+
+// containerRun deploys the container described by config. hostMounts are
+// mounted in the container next to the volumes of the config, they are
+// used by the primitives that expose host files to their container
+func (p *Provisioner) containerRun(ctx context.Context, reservation *provision.Reservation, config Container, hostMounts []pkg.MountInfo) (ContainerResult, error) {
+	containerClient := stubs.NewContainerModuleStub(p.zbus)
+
+	tenantNS := fmt.Sprintf("ns%s", reservation.User)
+	containerID := reservation.ID
+
 	// check if workload is already deployed
 	_, err := containerClient.Inspect(tenantNS, pkg.ContainerID(containerID))
 	if err == nil {
 		log.Info().Str("id", containerID).Msg("container already deployed")
+		if err := p.probes.Watch(containerID, containerProbeTarget(containerID, config), config.Probes); err != nil {
+			log.Error().Err(err).Str("container", containerID).Msg("failed to start readiness probes")
+		}
 		return ContainerResult{
 			ID:   containerID,
 			IPv4: config.Network.IPs[0].String(),
 		}, nil
 	}
 
+	return p.containerCreate(ctx, reservation, config, hostMounts, nil)
+}
+
+// inPlace is what a container updated in place keeps from the container it replaces
+type inPlace struct {
+	// member is the network membership of the container
+	member pkg.Member
+	// rootFS is the mounted root filesystem of the container,
+	// empty if the root filesystem needs to be mounted again
+	rootFS string
+}
+
+// containerCreate creates the container described by config. If keep is set,
+// the container replaces the existing one keeping its network namespace
+func (p *Provisioner) containerCreate(ctx context.Context, reservation *provision.Reservation, config Container, hostMounts []pkg.MountInfo, keep *inPlace) (ContainerResult, error) {
+	containerClient := stubs.NewContainerModuleStub(p.zbus)
+	flistClient := stubs.NewFlisterStub(p.zbus)
+	storageClient := stubs.NewStorageModuleStub(p.zbus)
+
+	tenantNS := fmt.Sprintf("ns%s", reservation.User)
+	containerID := reservation.ID
+
+	var err error
 	if err := validateContainerConfig(config); err != nil {
 		return ContainerResult{}, errors.Wrap(err, "container provision schema not valid")
 	}
@@ -124,18 +195,33 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 	}
 
 	var mnt string
-	mnt, err = flistClient.NamedMount(reservation.ID, config.FList, config.FlistStorage, rootfsMntOpt)
-	if err != nil {
-		return ContainerResult{}, err
-	}
-
-	defer func() {
+	if keep != nil && keep.rootFS != "" {
+		mnt = keep.rootFS
+	} else {
+		done := cancel.Call(ctx, "flist", "NamedMount", reservation.ID)
+		mnt, err = flistClient.NamedMount(reservation.ID, config.FList, config.FlistStorage, rootfsMntOpt)
+		done()
 		if err != nil {
-			if err := flistClient.Umount(mnt); err != nil {
-				log.Error().Err(err).Str("mnt", mnt).Msg("failed to unmount container root")
-			}
+			return ContainerResult{}, err
 		}
-	}()
+
+		defer func() {
+			if err != nil {
+				if err := flistClient.Umount(mnt); err != nil {
+					log.Error().Err(err).Str("mnt", mnt).Msg("failed to unmount container root")
+				}
+			}
+		}()
+	}
+
+	// the read-write layer of the rootfs is a volume named after the
+	// reservation, tag it so it can be traced back to its reservation
+	_, span := tracing.Call(ctx, "storage", "LabelVolume", reservation.ID)
+	err = storageClient.LabelVolume(reservation.ID, reservation.ID, reservationLabels(reservation))
+	span.Finish(err)
+	if err != nil {
+		return ContainerResult{}, errors.Wrap(err, "failed to label container rootfs volume")
+	}
 
 	var env []string
 	for k, v := range config.Env {
@@ -150,9 +236,60 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
+	var secretMounts []pkg.MountInfo
+	uid, gid, err := parseUser(config.User)
+	if err != nil {
+		return ContainerResult{}, err
+	}
+
+	secretMounts, err = p.containerSecrets(containerID, gid, config.SecretFiles)
+	if err != nil {
+		return ContainerResult{}, errors.Wrap(err, "failed to prepare container secrets")
+	}
+
+	if keep == nil {
+		defer func() {
+			if err != nil {
+				if err := removeSecrets(stubs.NewBrokerStub(p.zbus), secretsRoot, containerID); err != nil {
+					log.Error().Err(err).Str("container", containerID).Msg("failed to remove container secrets")
+				}
+			}
+		}()
+	}
+
+	// the disks the IO limit applies to, the root fs
+	// and the volumes can live in different pools
+	var ioDevices []string
+	addIODevices := func(volume string) error {
+		if config.Capacity.IO.IsZero() {
+			return nil
+		}
+
+		devices, err := storageClient.VolumeDevices(volume)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the disks of volume %s", volume)
+		}
+
+	next:
+		for _, device := range devices {
+			for _, known := range ioDevices {
+				if known == device {
+					continue next
+				}
+			}
+			ioDevices = append(ioDevices, device)
+		}
+
+		return nil
+	}
+
+	if err = addIODevices(reservation.ID); err != nil {
+		return ContainerResult{}, err
+	}
+
 	var mounts []pkg.MountInfo
 	for _, mount := range config.Mounts {
-		volumeRes, err := p.cache.Get(mount.VolumeID)
+		volumeRes, err := p.reservation(mount.VolumeID)
 		if err != nil {
 			return ContainerResult{}, errors.Wrapf(err, "failed to retrieve the owner of volume %s", mount.VolumeID)
 		}
@@ -173,6 +310,10 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 			return ContainerResult{}, errors.Wrapf(err, "failed to get the mountpoint path of the volume %s", mount.VolumeID)
 		}
 
+		if err = addIODevices(mount.VolumeID); err != nil {
+			return ContainerResult{}, err
+		}
+
 		mounts = append(
 			mounts,
 			pkg.MountInfo{
@@ -182,6 +323,33 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		)
 	}
 
+	mounts = append(mounts, hostMounts...)
+	mounts = append(mounts, secretMounts...)
+
+	var checkpoints string
+	if len(config.CheckpointVolume) != 0 {
+		var volumeRes *provision.Reservation
+		volumeRes, err = p.reservation(config.CheckpointVolume)
+		if err != nil {
+			return ContainerResult{}, errors.Wrapf(err, "failed to retrieve the owner of volume %s", config.CheckpointVolume)
+		}
+
+		if volumeRes.User != reservation.User {
+			return ContainerResult{}, fmt.Errorf("cannot use volume %s, user %s is not the owner of it", config.CheckpointVolume, reservation.User)
+		}
+
+		var volume string
+		volume, err = storageClient.Path(config.CheckpointVolume)
+		if err != nil {
+			return ContainerResult{}, errors.Wrapf(err, "failed to get the mountpoint path of the volume %s", config.CheckpointVolume)
+		}
+
+		checkpoints = path.Join(volume, "checkpoints", containerID)
+		if err = os.MkdirAll(checkpoints, 0700); err != nil {
+			return ContainerResult{}, errors.Wrap(err, "failed to create checkpoints directory")
+		}
+	}
+
 	netID := networkID(reservation.User, string(config.Network.NetworkID))
 	log.Debug().
 		Str("network-id", string(netID)).
@@ -195,22 +363,29 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		ips[i] = ip.String()
 	}
 	var join pkg.Member
-	join, err = networkMgr.Join(netID, containerID, ips, config.Network.PublicIP6)
-	if err != nil {
-		return ContainerResult{}, err
-	}
-
-	defer func() {
+	if keep != nil {
+		join = keep.member
+	} else {
+		_, span := tracing.Call(ctx, "network", "Join", containerID)
+		join, err = networkMgr.Join(netID, containerID, ips, config.Network.PublicIP6)
+		span.Finish(err)
 		if err != nil {
-			if err := networkMgr.Leave(netID, containerID); err != nil {
-				log.Error().Err(err).Msgf("failed leave containrt network namespace")
-			}
+			return ContainerResult{}, err
+		}
 
-			if err := flistClient.Umount(mnt); err != nil {
-				log.Error().Err(err).Str("path", mnt).Msgf("failed to unmount")
+		defer func() {
+			if err != nil {
+				_, span := tracing.Call(ctx, "network", "Leave", containerID)
+				err := networkMgr.Leave(netID, containerID)
+				span.Finish(err)
+				if err != nil {
+					log.Error().Err(err).Msgf("failed leave containrt network namespace")
+				}
 			}
-		}
-	}()
+		}()
+	}
+
+	env = append(env, join.Env...)
 
 	log.Info().
 		Str("ipv6", join.IPv6.String()).
@@ -218,8 +393,15 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		Str("container", reservation.ID).
 		Msg("assigned an IP")
 
+	run, api := containerClient.Run, "Run"
+	if keep != nil {
+		run, api = containerClient.Update, "Update"
+	}
+
+	_, span = tracing.Call(ctx, "container", api, containerID)
+	done := cancel.Call(ctx, "container", api, containerID)
 	var id pkg.ContainerID
-	id, err = containerClient.Run(
+	id, err = run(
 		tenantNS,
 		pkg.Container{
 			Name:   containerID,
@@ -230,13 +412,26 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 			},
 			Mounts:          mounts,
 			Entrypoint:      config.Entrypoint,
+			UID:             uid,
+			GID:             gid,
+			Init:            config.Init,
 			Interactive:     config.Interactive,
 			CPU:             config.Capacity.CPU,
 			Memory:          config.Capacity.Memory * mib,
+			IO:              config.Capacity.IO,
+			IODevices:       ioDevices,
+			Devices:         containerDevices(config),
+			Liveness:        config.Liveness,
+			Restart:         config.Restart,
+			Security:        config.Security,
+			Checkpoints:     checkpoints,
+			RunOnce:         config.runOnce,
 			Logs:            config.Logs,
 			StatsAggregator: config.StatsAggregator,
 		},
 	)
+	done()
+	span.Finish(err)
 	if err != nil {
 		return ContainerResult{}, errors.Wrap(err, "error starting container")
 	}
@@ -248,6 +443,10 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		}
 	}
 
+	if err := p.probes.Watch(reservation.ID, containerProbeTarget(reservation.ID, config), config.Probes); err != nil {
+		log.Error().Err(err).Str("container", reservation.ID).Msg("failed to start readiness probes")
+	}
+
 	log.Info().Msgf("container created with id: '%s'", id)
 	return ContainerResult{
 		ID:   string(id),
@@ -256,6 +455,123 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 	}, nil
 }
 
+func (p *Provisioner) containerUpdate(ctx context.Context, current, reservation *provision.Reservation) (interface{}, error) {
+	return p.containerUpdateImpl(ctx, current, reservation)
+}
+
+// containerUpdateImpl replaces the container of current with the one of reservation,
+// the container is only stopped the time to swap its root filesystem. It keeps
+// its network namespace, and so its IPs, and its volumes
+func (p *Provisioner) containerUpdateImpl(ctx context.Context, current, reservation *provision.Reservation) (ContainerResult, error) {
+	var deployed, config Container
+	if err := json.Unmarshal(current.Data, &deployed); err != nil {
+		return ContainerResult{}, errors.Wrap(err, "failed to decode deployed container schema")
+	}
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return ContainerResult{}, err
+	}
+
+	if err := validateContainerConfig(config); err != nil {
+		return ContainerResult{}, errors.Wrap(err, "container provision schema not valid")
+	}
+
+	if err := validateContainerUpdate(deployed, config); err != nil {
+		return ContainerResult{}, errors.Wrap(err, "container cannot be updated in place")
+	}
+
+	containerClient := stubs.NewContainerModuleStub(p.zbus)
+	flistClient := stubs.NewFlisterStub(p.zbus)
+
+	tenantNS := fmt.Sprintf("ns%s", reservation.User)
+	containerID := pkg.ContainerID(reservation.ID)
+
+	info, err := containerClient.Inspect(tenantNS, containerID)
+	if err != nil {
+		log.Warn().Err(err).Str("container", string(containerID)).Msg("container to update is not running, deploying it")
+		return p.containerRun(ctx, reservation, config, nil)
+	}
+
+	rootFS := info.RootFS
+	if info.Interactive {
+		if rootFS, err = findRootFS(info.Mounts); err != nil {
+			return ContainerResult{}, err
+		}
+	}
+
+	keep := inPlace{
+		member: pkg.Member{
+			Namespace: info.Network.Namespace,
+			Env:       proxyEnv(info.Env),
+		},
+		rootFS: rootFS,
+	}
+
+	p.probes.Stop(reservation.ID)
+
+	if deployed.FList != config.FList || deployed.FlistStorage != config.FlistStorage {
+		// the root filesystem can only be swapped once the container is stopped
+		if err := containerClient.Delete(tenantNS, containerID); err != nil {
+			return ContainerResult{}, errors.Wrapf(err, "failed to stop container %s", containerID)
+		}
+
+		if err := flistClient.Umount(rootFS); err != nil {
+			return ContainerResult{}, errors.Wrapf(err, "failed to unmount flist at %s", rootFS)
+		}
+		keep.rootFS = ""
+	}
+
+	result, err := p.containerCreate(ctx, reservation, config, nil, &keep)
+	if err == nil {
+		log.Info().Str("container", string(containerID)).Msg("container updated")
+		return result, nil
+	}
+
+	// bring back the deployed container, so a bad update doesn't take the workload down
+	log.Error().Err(err).Str("container", string(containerID)).Msg("failed to update container, restoring previous version")
+	if _, restoreErr := p.containerCreate(ctx, current, deployed, nil, &keep); restoreErr != nil {
+		log.Error().Err(restoreErr).Str("container", string(containerID)).Msg("failed to restore previous version of container")
+	}
+
+	return ContainerResult{}, err
+}
+
+// validateContainerUpdate makes sure only the flist, the environment,
+// the entrypoint and the monitoring of the container changed
+func validateContainerUpdate(deployed, config Container) error {
+	if deployed.Interactive != config.Interactive {
+		return fmt.Errorf("interactive mode cannot be changed")
+	}
+
+	if !reflect.DeepEqual(deployed.Network, config.Network) {
+		return fmt.Errorf("network cannot be changed")
+	}
+
+	if !reflect.DeepEqual(deployed.Mounts, config.Mounts) {
+		return fmt.Errorf("mounts cannot be changed")
+	}
+
+	if !reflect.DeepEqual(deployed.Capacity, config.Capacity) {
+		return fmt.Errorf("capacity cannot be changed")
+	}
+
+	return nil
+}
+
+// proxyEnv returns the egress proxy variables set in env when the
+// container joined its network
+func proxyEnv(env []string) []string {
+	var proxy []string
+	for _, kv := range env {
+		key := strings.ToLower(strings.SplitN(kv, "=", 2)[0])
+		switch key {
+		case "http_proxy", "https_proxy", "all_proxy", "no_proxy":
+			proxy = append(proxy, kv)
+		}
+	}
+
+	return proxy
+}
+
 func (p *Provisioner) containerDecommission(ctx context.Context, reservation *provision.Reservation) error {
 	container := stubs.NewContainerModuleStub(p.zbus)
 	flist := stubs.NewFlisterStub(p.zbus)
@@ -269,6 +585,8 @@ func (p *Provisioner) containerDecommission(ctx context.Context, reservation *pr
 		return err
 	}
 
+	p.probes.Stop(reservation.ID)
+
 	info, err := container.Inspect(tenantNS, containerID)
 	if err == nil {
 		if err := container.Delete(tenantNS, containerID); err != nil {
@@ -291,9 +609,16 @@ func (p *Provisioner) containerDecommission(ctx context.Context, reservation *pr
 		log.Error().Err(err).Str("container", string(containerID)).Msg("failed to inspect container for decomission")
 	}
 
+	if err := removeSecrets(stubs.NewBrokerStub(p.zbus), secretsRoot, string(containerID)); err != nil {
+		return err
+	}
+
 	netID := networkID(reservation.User, string(config.Network.NetworkID))
 	if _, err := networkMgr.GetSubnet(netID); err == nil { // simple check to make sure the network still exists on the node
-		if err := networkMgr.Leave(netID, string(containerID)); err != nil {
+		_, span := tracing.Call(ctx, "network", "Leave", string(containerID))
+		err := networkMgr.Leave(netID, string(containerID))
+		span.Finish(err)
+		if err != nil {
 			return errors.Wrap(err, "failed to delete container network namespace")
 		}
 	}
@@ -310,9 +635,117 @@ func validateContainerConfig(config Container) error {
 		return fmt.Errorf("missing flist url")
 	}
 
+	for i := range config.Probes {
+		if err := config.Probes[i].Valid(); err != nil {
+			return errors.Wrapf(err, "invalid probe %d", i)
+		}
+	}
+
+	for i := range config.Init {
+		if err := config.Init[i].Valid(); err != nil {
+			return errors.Wrapf(err, "invalid init step %d", i)
+		}
+	}
+
+	for i := range config.Liveness {
+		if err := config.Liveness[i].Valid(); err != nil {
+			return errors.Wrapf(err, "invalid liveness check %d", i)
+		}
+	}
+
+	if err := config.Security.Valid(); err != nil {
+		return errors.Wrap(err, "invalid security profile")
+	}
+
+	if _, _, err := parseUser(config.User); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// parseUser parses the <uid>[:<gid>] user of a container, an empty user
+// is root
+func parseUser(user string) (uid, gid uint32, err error) {
+	if user == "" {
+		return 0, 0, nil
+	}
+
+	parts := strings.SplitN(user, ":", 2)
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid user '%s', it must be <uid>[:<gid>]", user)
+	}
+	uid, gid = uint32(id), uint32(id)
+
+	if len(parts) == 2 {
+		id, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid user '%s', it must be <uid>[:<gid>]", user)
+		}
+		gid = uint32(id)
+	}
+
+	return uid, gid, nil
+}
+
+// containerDevices returns the host devices of the container, with the
+// devices asked for by the flags of the config
+func containerDevices(config Container) []string {
+	devices := config.Devices
+	if config.KVM {
+		devices = append(devices[:len(devices):len(devices)], "kvm")
+	}
+	return devices
+}
+
+// containerProbeTarget returns where the probes of a container are executed.
+// The network namespace of a container is named after the container ID
+func containerProbeTarget(id string, config Container) probe.Target {
+	return probe.Target{
+		Namespace: id,
+		IP:        config.Network.IPs[0],
+	}
+}
+
+// WatchProbes starts the readiness probes of an already deployed reservation.
+// It is used to restore the probes when provisiond restarts
+func (p *Provisioner) WatchProbes(reservation *provision.Reservation) error {
+	if reservation.Type == DeploymentReservation {
+		var config Deployment
+		if err := json.Unmarshal(reservation.Data, &config); err != nil {
+			return err
+		}
+
+		workloads, err := config.workloads(reservation)
+		if err != nil {
+			return err
+		}
+
+		for _, wl := range workloads {
+			if err := p.WatchProbes(wl); err != nil {
+				return errors.Wrapf(err, "workload %s", wl.ID)
+			}
+		}
+		return nil
+	}
+
+	if reservation.Type != ContainerReservation {
+		return nil
+	}
+
+	var config Container
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return err
+	}
+
+	if len(config.Network.IPs) == 0 {
+		return fmt.Errorf("container has no IP")
+	}
+
+	return p.probes.Watch(reservation.ID, containerProbeTarget(reservation.ID, config), config.Probes)
+}
+
 func findRootFS(mounts []pkg.MountInfo) (string, error) {
 	for _, m := range mounts {
 		if m.Target == "/sandbox" {
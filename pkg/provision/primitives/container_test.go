@@ -0,0 +1,66 @@
+package primitives
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestValidateContainerUpdate(t *testing.T) {
+	deployed := Container{
+		FList:      "https://hub.grid.tf/tf-official-apps/redis.flist",
+		Env:        map[string]string{"PORT": "6379"},
+		Entrypoint: "redis-server",
+		Mounts:     []Mount{{VolumeID: "1-1", Mountpoint: "/data"}},
+		Network: Network{
+			NetworkID: "net",
+			IPs:       []net.IP{net.ParseIP("10.0.0.2")},
+		},
+		Capacity: ContainerCapacity{CPU: 1, Memory: 512, DiskType: pkg.SSDDevice},
+	}
+
+	update := deployed
+	update.FList = "https://hub.grid.tf/tf-official-apps/redis-6.flist"
+	update.Env = map[string]string{"PORT": "6380"}
+	update.Entrypoint = "redis-server --port 6380"
+	assert.NoError(t, validateContainerUpdate(deployed, update))
+
+	update = deployed
+	update.Mounts = nil
+	assert.Error(t, validateContainerUpdate(deployed, update))
+
+	update = deployed
+	update.Network.IPs = []net.IP{net.ParseIP("10.0.0.3")}
+	assert.Error(t, validateContainerUpdate(deployed, update))
+
+	update = deployed
+	update.Capacity.Memory = 1024
+	assert.Error(t, validateContainerUpdate(deployed, update))
+}
+
+func TestProxyEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"http_proxy=http://10.0.0.1:3128",
+		"HTTPS_PROXY=http://10.0.0.1:3128",
+		"no_proxy=localhost",
+		"PORT=6379",
+	}
+
+	assert.Equal(t, []string{
+		"http_proxy=http://10.0.0.1:3128",
+		"HTTPS_PROXY=http://10.0.0.1:3128",
+		"no_proxy=localhost",
+	}, proxyEnv(env))
+}
+
+func TestContainerDevices(t *testing.T) {
+	assert.Empty(t, containerDevices(Container{}))
+	assert.Equal(t, []string{"kvm"}, containerDevices(Container{KVM: true}))
+
+	config := Container{Devices: []string{"tun"}, KVM: true}
+	assert.Equal(t, []string{"tun", "kvm"}, containerDevices(config))
+	assert.Equal(t, []string{"tun"}, config.Devices)
+}
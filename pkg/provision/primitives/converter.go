@@ -122,6 +122,8 @@ func VolumeToProvisionType(v workloads.Volume) (Volume, string, error) {
 		volume.Type = pkg.HDDDevice
 	case "ssd":
 		volume.Type = pkg.SSDDevice
+	case "memory":
+		volume.Type = pkg.MemoryDevice
 	default:
 		return volume, v.NodeId, fmt.Errorf("disk type %s not supported", v.Type.String())
 	}
@@ -292,7 +294,9 @@ func ResultToSchemaType(r provision.Result) (*workloads.Result, error) {
 	switch r.Type {
 	case VolumeReservation:
 		rType = workloads.WorkloadTypeVolume
-	case ContainerReservation:
+	case ContainerReservation, S3Reservation, NFSReservation, BlockReservation, DeploymentReservation, JobReservation:
+		// the explorer has no type for the S3 gateway, the NFS and
+		// block exports, which run as containers, the deployments and the jobs
 		rType = workloads.WorkloadTypeContainer
 	case ZDBReservation:
 		rType = workloads.WorkloadTypeZDB
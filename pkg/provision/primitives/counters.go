@@ -8,6 +8,7 @@ import (
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/cron"
 )
 
 // Counter interface
@@ -81,6 +82,9 @@ const (
 
 // Increment is called by the provision.Engine when a reservation has been provisionned
 func (c *Counters) Increment(r *provision.Reservation) error {
+	if r.Type == DeploymentReservation {
+		return c.each(r, c.Increment)
+	}
 
 	var (
 		u   resourceUnits
@@ -91,9 +95,14 @@ func (c *Counters) Increment(r *provision.Reservation) error {
 	case VolumeReservation:
 		c.volumes.Increment(1)
 		u, err = processVolume(r)
-	case ContainerReservation:
+	case ContainerReservation, S3Reservation, NFSReservation:
 		c.containers.Increment(1)
 		u, err = processContainer(r)
+	case BlockReservation:
+		c.containers.Increment(1)
+		u, err = processBlock(r)
+	case JobReservation:
+		u, err = processJob(r)
 	case ZDBReservation:
 		c.zdbs.Increment(1)
 		u, err = processZdb(r)
@@ -122,6 +131,9 @@ func (c *Counters) Increment(r *provision.Reservation) error {
 
 // Decrement is called by the provision.Engine when a reservation has been decommissioned
 func (c *Counters) Decrement(r *provision.Reservation) error {
+	if r.Type == DeploymentReservation {
+		return c.each(r, c.Decrement)
+	}
 
 	var (
 		u   resourceUnits
@@ -132,9 +144,14 @@ func (c *Counters) Decrement(r *provision.Reservation) error {
 	case VolumeReservation:
 		c.volumes.Decrement(1)
 		u, err = processVolume(r)
-	case ContainerReservation:
+	case ContainerReservation, S3Reservation, NFSReservation:
 		c.containers.Decrement(1)
 		u, err = processContainer(r)
+	case BlockReservation:
+		c.containers.Decrement(1)
+		u, err = processBlock(r)
+	case JobReservation:
+		u, err = processJob(r)
 	case ZDBReservation:
 		c.zdbs.Decrement(1)
 		u, err = processZdb(r)
@@ -161,6 +178,27 @@ func (c *Counters) Decrement(r *provision.Reservation) error {
 	return nil
 }
 
+// each calls fn with every workload of the deployment r
+func (c *Counters) each(r *provision.Reservation, fn func(*provision.Reservation) error) error {
+	var deployment Deployment
+	if err := json.Unmarshal(r.Data, &deployment); err != nil {
+		return err
+	}
+
+	workloads, err := deployment.workloads(r)
+	if err != nil {
+		return err
+	}
+
+	for _, wl := range workloads {
+		if err := fn(wl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type resourceUnits struct {
 	SRU uint64 `json:"sru,omitempty"`
 	HRU uint64 `json:"hru,omitempty"`
@@ -180,6 +218,9 @@ func processVolume(r *provision.Reservation) (u resourceUnits, err error) {
 		u.SRU = volume.Size * gib
 	case pkg.HDDDevice:
 		u.HRU = volume.Size * gib
+	case pkg.MemoryDevice:
+		// tmpfs volumes are held in memory
+		u.MRU = volume.Size * gib
 	}
 
 	return u, nil
@@ -198,6 +239,41 @@ func processContainer(r *provision.Reservation) (u resourceUnits, err error) {
 	return u, nil
 }
 
+// processJob reserves the capacity of the runs of the job, all the
+// runs can run at once with the allow concurrency policy
+func processJob(r *provision.Reservation) (u resourceUnits, err error) {
+	var job Job
+	if err = json.Unmarshal(r.Data, &job); err != nil {
+		return u, err
+	}
+
+	runs := uint64(1)
+	if job.Concurrency == cron.ConcurrencyAllow {
+		runs = uint64(len(job.Container.Network.IPs))
+	}
+
+	u.CRU = runs * uint64(job.Container.Capacity.CPU)
+	// memory is in MiB
+	u.MRU = runs * job.Container.Capacity.Memory * mib
+	u.SRU = runs * 256 * mib
+
+	return u, nil
+}
+
+func processBlock(r *provision.Reservation) (u resourceUnits, err error) {
+	var block BlockExport
+	if err = json.Unmarshal(r.Data, &block); err != nil {
+		return u, err
+	}
+	u.CRU = uint64(block.Capacity.CPU)
+	// memory is in MiB
+	u.MRU = block.Capacity.Memory * mib
+	// the root filesystem of the server and the exported disk
+	u.SRU = 256*mib + block.Size*gib
+
+	return u, nil
+}
+
 func processZdb(r *provision.Reservation) (u resourceUnits, err error) {
 	if r.Type != ZDBReservation {
 		return u, fmt.Errorf("wrong type or reservation %s, excepted %s", r.Type, ZDBReservation)
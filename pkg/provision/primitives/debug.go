@@ -51,7 +51,12 @@ func (p *Provisioner) startZLF(ctx context.Context, ID string, cfg Debug) (strin
 	}
 	defer z.Close()
 
-	channel := fmt.Sprintf("%s-logs", identity.NodeID().Identity())
+	nodeID, err := identity.NodeID()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get node identity")
+	}
+
+	channel := fmt.Sprintf("%s-logs", nodeID.Identity())
 	if cfg.Channel != "" {
 		channel = cfg.Channel
 	}
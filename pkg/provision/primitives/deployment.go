@@ -0,0 +1,374 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// Deployment groups workloads that are provisioned atomically: either
+// all of them come up, or the ones already deployed are rolled back.
+//
+// Every workload of the deployment is deployed with the reservation ID
+// <deployment ID>-<workload name>, this is the ID to use to reference it from
+// another workload of the same deployment (a volume mounted in a container,
+// a namespace used by a S3 gateway, ...)
+type Deployment struct {
+	Workloads []DeploymentWorkload `json:"workloads"`
+}
+
+// DeploymentWorkload is a workload part of a deployment
+type DeploymentWorkload struct {
+	// Name of the workload, unique in the deployment
+	Name string `json:"name"`
+	// Type of the workload, any type but a deployment
+	Type provision.ReservationType `json:"type"`
+	// Data is the workload schema, the same as the one of a reservation of this type
+	Data json.RawMessage `json:"data"`
+	// DependsOn are the names of the workloads that need to be
+	// deployed before this one
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// WorkloadState is the state of a workload of a deployment
+type WorkloadState string
+
+const (
+	// WorkloadStateOk the workload is deployed
+	WorkloadStateOk WorkloadState = "ok"
+	// WorkloadStateError the workload failed to deploy
+	WorkloadStateError WorkloadState = "error"
+	// WorkloadStateRolledBack the workload was deployed, then removed because
+	// another workload of the deployment failed
+	WorkloadStateRolledBack WorkloadState = "rolled_back"
+	// WorkloadStateSkipped the workload was not deployed because
+	// another workload of the deployment failed first
+	WorkloadStateSkipped WorkloadState = "skipped"
+)
+
+// WorkloadStatus reports the state of a workload of a deployment
+type WorkloadStatus struct {
+	Name  string                    `json:"name"`
+	Type  provision.ReservationType `json:"type"`
+	ID    string                    `json:"id"`
+	State WorkloadState             `json:"state"`
+	Error string                    `json:"error,omitempty"`
+	// Result is the result of the workload provisioning, when deployed
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// DeploymentResult is the information return to the BCDB
+// after deploying a deployment
+type DeploymentResult struct {
+	Workloads []WorkloadStatus `json:"workloads"`
+}
+
+var deploymentNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// deploymentWorkloadID is the reservation ID of the workload name of deployment id
+func deploymentWorkloadID(id, name string) string {
+	return fmt.Sprintf("%s-%s", id, name)
+}
+
+// workloads returns the reservations of the workloads of the
+// deployment in the order they need to be deployed
+func (d *Deployment) workloads(reservation *provision.Reservation) ([]*provision.Reservation, error) {
+	order, err := d.order()
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*provision.Reservation, 0, len(order))
+	for _, wl := range order {
+		r := *reservation
+		r.ID = deploymentWorkloadID(reservation.ID, wl.Name)
+		r.Type = wl.Type
+		r.Data = wl.Data
+		r.Tag = provision.AppendTag(nil, provision.Tag{"deployment": reservation.ID})
+		workloads = append(workloads, &r)
+	}
+
+	return workloads, nil
+}
+
+// order sorts the workloads so that each one comes after its dependencies.
+// Workloads without dependencies between them keep their order
+func (d *Deployment) order() ([]DeploymentWorkload, error) {
+	byName := make(map[string]DeploymentWorkload, len(d.Workloads))
+	for _, wl := range d.Workloads {
+		byName[wl.Name] = wl
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := make(map[string]int, len(d.Workloads))
+	order := make([]DeploymentWorkload, 0, len(d.Workloads))
+
+	var visit func(wl DeploymentWorkload, path []string) error
+	visit = func(wl DeploymentWorkload, path []string) error {
+		switch state[wl.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, wl.Name), " -> "))
+		}
+
+		state[wl.Name] = visiting
+		for _, dep := range wl.DependsOn {
+			if err := visit(byName[dep], append(path, wl.Name)); err != nil {
+				return err
+			}
+		}
+		state[wl.Name] = visited
+		order = append(order, wl)
+
+		return nil
+	}
+
+	for _, wl := range d.Workloads {
+		if err := visit(wl, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func validateDeployment(d Deployment) error {
+	if len(d.Workloads) == 0 {
+		return fmt.Errorf("a deployment needs at least one workload")
+	}
+
+	names := make(map[string]struct{}, len(d.Workloads))
+	for _, wl := range d.Workloads {
+		if !deploymentNameRegex.MatchString(wl.Name) {
+			return fmt.Errorf("invalid workload name '%s', only letters, digits and _ are allowed", wl.Name)
+		}
+
+		if _, ok := names[wl.Name]; ok {
+			return fmt.Errorf("workload name '%s' is used more than once", wl.Name)
+		}
+		names[wl.Name] = struct{}{}
+
+		if wl.Type == DeploymentReservation {
+			return fmt.Errorf("workload '%s': deployments cannot be nested", wl.Name)
+		}
+	}
+
+	for _, wl := range d.Workloads {
+		for _, dep := range wl.DependsOn {
+			if _, ok := names[dep]; !ok {
+				return fmt.Errorf("workload '%s' depends on unknown workload '%s'", wl.Name, dep)
+			}
+		}
+	}
+
+	_, err := d.order()
+	return err
+}
+
+func (p *Provisioner) deploymentProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.deploymentProvisionImpl(ctx, reservation)
+}
+
+// deploymentProvisionImpl deploys the workloads one after the other following their
+// dependencies. On the first failure, the workloads already deployed are
+// decommissioned in the opposite order
+func (p *Provisioner) deploymentProvisionImpl(ctx context.Context, reservation *provision.Reservation) (DeploymentResult, error) {
+	var config Deployment
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return DeploymentResult{}, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateDeployment(config); err != nil {
+		return DeploymentResult{}, errors.Wrap(err, "deployment schema not valid")
+	}
+
+	workloads, err := config.workloads(reservation)
+	if err != nil {
+		return DeploymentResult{}, err
+	}
+
+	for _, wl := range workloads {
+		if _, ok := p.Provisioners[wl.Type]; !ok {
+			return DeploymentResult{}, fmt.Errorf("workload %s: type of reservation not supported: %s", wl.ID, wl.Type)
+		}
+	}
+
+	// the workloads are only cached with the deployment once it's fully
+	// deployed, until then the workloads can find each other from here
+	for _, wl := range workloads {
+		p.members.Store(wl.ID, wl)
+	}
+	defer func() {
+		for _, wl := range workloads {
+			p.members.Delete(wl.ID)
+		}
+	}()
+
+	result := DeploymentResult{Workloads: make([]WorkloadStatus, len(workloads))}
+	for i, wl := range workloads {
+		result.Workloads[i] = WorkloadStatus{
+			Name:  strings.TrimPrefix(wl.ID, reservation.ID+"-"),
+			Type:  wl.Type,
+			ID:    wl.ID,
+			State: WorkloadStateSkipped,
+		}
+	}
+
+	for i, wl := range workloads {
+		status := &result.Workloads[i]
+
+		info, err := p.Provisioners[wl.Type](ctx, wl)
+		if err != nil {
+			status.State = WorkloadStateError
+			status.Error = err.Error()
+
+			log.Error().Err(err).Str("id", reservation.ID).Str("workload", wl.ID).Msg("deployment failed, rolling back")
+			p.deploymentRollback(ctx, workloads[:i], result.Workloads[:i])
+
+			return result, errors.Wrapf(err, "failed to deploy workload %s", status.Name)
+		}
+
+		status.State = WorkloadStateOk
+		if status.Result, err = json.Marshal(info); err != nil {
+			log.Error().Err(err).Str("workload", wl.ID).Msg("failed to encode workload result")
+		}
+	}
+
+	log.Info().Str("id", reservation.ID).Int("workloads", len(workloads)).Msg("deployment deployed")
+	return result, nil
+}
+
+// deploymentRollback decommissions the deployed workloads in the opposite order
+func (p *Provisioner) deploymentRollback(ctx context.Context, workloads []*provision.Reservation, statuses []WorkloadStatus) {
+	for i := len(workloads) - 1; i >= 0; i-- {
+		wl := workloads[i]
+		if err := p.Decommissioners[wl.Type](ctx, wl); err != nil {
+			// the workload is left behind, the garbage collector
+			// will reclaim what it can
+			log.Error().Err(err).Str("workload", wl.ID).Msg("failed to roll back workload")
+			statuses[i].Error = errors.Wrap(err, "failed to roll back").Error()
+			continue
+		}
+
+		statuses[i].State = WorkloadStateRolledBack
+		statuses[i].Result = nil
+	}
+}
+
+// deploymentDecommission decommissions all the workloads of the
+// deployment, in the opposite order of their deployment
+func (p *Provisioner) deploymentDecommission(ctx context.Context, reservation *provision.Reservation) error {
+	var config Deployment
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	workloads, err := config.workloads(reservation)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i := len(workloads) - 1; i >= 0; i-- {
+		wl := workloads[i]
+		fn, ok := p.Decommissioners[wl.Type]
+		if !ok {
+			continue
+		}
+
+		if err := fn(ctx, wl); err != nil {
+			log.Error().Err(err).Str("workload", wl.ID).Msg("failed to decommission workload")
+			failed = append(failed, wl.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to decommission workloads: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// reservation returns the reservation with id from the cache. id can also
+// be a workload of a deployment, being deployed or already deployed
+func (p *Provisioner) reservation(id string) (*provision.Reservation, error) {
+	if member, ok := p.members.Load(id); ok {
+		return member.(*provision.Reservation), nil
+	}
+
+	return lookupReservation(p.cache, id)
+}
+
+// lookupReservation gets the reservation id from the cache, falling back
+// to the workloads of the cached deployments
+func lookupReservation(cache provision.ReservationCache, id string) (*provision.Reservation, error) {
+	r, err := cache.Get(id)
+	if err == nil {
+		return r, nil
+	}
+
+	member, ok := deploymentMember(cache, id)
+	if !ok {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// reservationExists checks if the reservation id is in the cache,
+// either directly or as a workload of a deployment
+func reservationExists(cache provision.ReservationCache, id string) (bool, error) {
+	exists, err := cache.Exists(id)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	if _, ok := deploymentMember(cache, id); ok {
+		return true, nil
+	}
+
+	return isJobRun(cache, id), nil
+}
+
+func deploymentMember(cache provision.ReservationCache, id string) (*provision.Reservation, bool) {
+	// workload names can't contain a -
+	idx := strings.LastIndex(id, "-")
+	if idx <= 0 {
+		return nil, false
+	}
+
+	parent, name := id[:idx], id[idx+1:]
+	r, err := cache.Get(parent)
+	if err != nil || r == nil || r.Type != DeploymentReservation {
+		return nil, false
+	}
+
+	var config Deployment
+	if err := json.Unmarshal(r.Data, &config); err != nil {
+		return nil, false
+	}
+
+	workloads, err := config.workloads(r)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, wl := range workloads {
+		if wl.ID == deploymentWorkloadID(parent, name) {
+			return wl, true
+		}
+	}
+
+	return nil, false
+}
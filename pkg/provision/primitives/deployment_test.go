@@ -0,0 +1,176 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestDeploymentOrder(t *testing.T) {
+	deployment := Deployment{
+		Workloads: []DeploymentWorkload{
+			{Name: "web", Type: ContainerReservation, DependsOn: []string{"data", "net"}},
+			{Name: "data", Type: VolumeReservation},
+			{Name: "db", Type: ZDBReservation},
+			{Name: "net", Type: NetworkReservation},
+		},
+	}
+	require.NoError(t, validateDeployment(deployment))
+
+	workloads, err := deployment.workloads(&provision.Reservation{ID: "1-1", User: "user"})
+	require.NoError(t, err)
+
+	var ids []string
+	for _, wl := range workloads {
+		ids = append(ids, wl.ID)
+		assert.Equal(t, "user", wl.User)
+	}
+	assert.Equal(t, []string{"1-1-data", "1-1-net", "1-1-web", "1-1-db"}, ids)
+}
+
+func TestDeploymentValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		workloads []DeploymentWorkload
+	}{
+		{"empty", nil},
+		{"name", []DeploymentWorkload{{Name: "a-b", Type: VolumeReservation}}},
+		{"duplicate", []DeploymentWorkload{{Name: "a", Type: VolumeReservation}, {Name: "a", Type: ZDBReservation}}},
+		{"nested", []DeploymentWorkload{{Name: "a", Type: DeploymentReservation}}},
+		{"unknown", []DeploymentWorkload{{Name: "a", Type: VolumeReservation, DependsOn: []string{"b"}}}},
+		{"cycle", []DeploymentWorkload{
+			{Name: "a", Type: VolumeReservation, DependsOn: []string{"b"}},
+			{Name: "b", Type: VolumeReservation, DependsOn: []string{"a"}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Error(t, validateDeployment(Deployment{Workloads: test.workloads}))
+		})
+	}
+}
+
+type testDeployer struct {
+	fail     string
+	deployed []string
+	removed  []string
+}
+
+func (d *testDeployer) provision(ctx context.Context, r *provision.Reservation) (interface{}, error) {
+	if r.ID == d.fail {
+		return nil, fmt.Errorf("no space left")
+	}
+	d.deployed = append(d.deployed, r.ID)
+	return r.ID, nil
+}
+
+func (d *testDeployer) decommission(ctx context.Context, r *provision.Reservation) error {
+	d.removed = append(d.removed, r.ID)
+	return nil
+}
+
+func (d *testDeployer) provisioner() *Provisioner {
+	return &Provisioner{
+		Provisioners: map[provision.ReservationType]provision.ProvisionerFunc{
+			VolumeReservation:    d.provision,
+			ContainerReservation: d.provision,
+		},
+		Decommissioners: map[provision.ReservationType]provision.DecomissionerFunc{
+			VolumeReservation:    d.decommission,
+			ContainerReservation: d.decommission,
+		},
+	}
+}
+
+func deploymentReservation(t *testing.T) *provision.Reservation {
+	data, err := json.Marshal(Deployment{
+		Workloads: []DeploymentWorkload{
+			{Name: "data", Type: VolumeReservation},
+			{Name: "logs", Type: VolumeReservation},
+			{Name: "web", Type: ContainerReservation, DependsOn: []string{"data", "logs"}},
+		},
+	})
+	require.NoError(t, err)
+
+	return &provision.Reservation{ID: "1-1", Type: DeploymentReservation, Data: data}
+}
+
+func TestDeploymentProvision(t *testing.T) {
+	deployer := &testDeployer{}
+	p := deployer.provisioner()
+	r := deploymentReservation(t)
+
+	result, err := p.deploymentProvisionImpl(context.Background(), r)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1-1-data", "1-1-logs", "1-1-web"}, deployer.deployed)
+	for _, status := range result.Workloads {
+		assert.Equal(t, WorkloadStateOk, status.State)
+	}
+
+	require.NoError(t, p.deploymentDecommission(context.Background(), r))
+	assert.Equal(t, []string{"1-1-web", "1-1-logs", "1-1-data"}, deployer.removed)
+}
+
+func TestDeploymentRollback(t *testing.T) {
+	deployer := &testDeployer{fail: "1-1-logs"}
+	p := deployer.provisioner()
+
+	result, err := p.deploymentProvisionImpl(context.Background(), deploymentReservation(t))
+	require.Error(t, err)
+
+	assert.Equal(t, []string{"1-1-data"}, deployer.deployed)
+	assert.Equal(t, []string{"1-1-data"}, deployer.removed)
+
+	require.Len(t, result.Workloads, 3)
+	assert.Equal(t, WorkloadStateRolledBack, result.Workloads[0].State)
+	assert.Equal(t, WorkloadStateError, result.Workloads[1].State)
+	assert.Equal(t, "no space left", result.Workloads[1].Error)
+	assert.Equal(t, WorkloadStateSkipped, result.Workloads[2].State)
+
+	// the workloads are not reachable anymore once the deployment is done
+	_, ok := p.members.Load("1-1-data")
+	assert.False(t, ok)
+}
+
+type deploymentCache map[string]*provision.Reservation
+
+func (c deploymentCache) Add(r *provision.Reservation) error { return nil }
+func (c deploymentCache) Remove(id string) error             { return nil }
+func (c deploymentCache) Sync(provision.Statser) error       { return nil }
+func (c deploymentCache) Get(id string) (*provision.Reservation, error) {
+	r, ok := c[id]
+	if !ok {
+		return nil, fmt.Errorf("reservation %s not found", id)
+	}
+	return r, nil
+}
+func (c deploymentCache) Exists(id string) (bool, error) {
+	_, ok := c[id]
+	return ok, nil
+}
+
+func TestDeploymentLookup(t *testing.T) {
+	r := deploymentReservation(t)
+	cache := deploymentCache{r.ID: r}
+
+	wl, err := lookupReservation(cache, "1-1-web")
+	require.NoError(t, err)
+	assert.Equal(t, ContainerReservation, wl.Type)
+
+	_, err = lookupReservation(cache, "1-1-db")
+	assert.Error(t, err)
+
+	exists, err := reservationExists(cache, "1-1-data")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = reservationExists(cache, "2-1")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,350 @@
+package primitives
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
+)
+
+// GCPolicy defines what the garbage collector does with
+// the volumes and namespaces that don't belong to any reservation
+type GCPolicy string
+
+const (
+	// GCPolicyReport only logs the orphaned objects
+	GCPolicyReport GCPolicy = "report"
+	// GCPolicyQuarantine labels the orphaned volumes and makes the orphaned
+	// namespaces private, then removes them once they stayed orphaned for
+	// the quarantine period
+	GCPolicyQuarantine GCPolicy = "quarantine"
+	// GCPolicyRemove removes the orphaned objects
+	GCPolicyRemove GCPolicy = "remove"
+)
+
+// Valid checks the policy is known
+func (p GCPolicy) Valid() error {
+	switch p {
+	case GCPolicyReport, GCPolicyQuarantine, GCPolicyRemove:
+		return nil
+	default:
+		return fmt.Errorf("unknown garbage collection policy '%s'", p)
+	}
+}
+
+const (
+	// gcGracePeriod is how long an object must stay without a reservation
+	// before it is orphaned. A reservation is only cached once it is fully
+	// deployed, so a deployment in progress has no reservation yet
+	gcGracePeriod = time.Hour
+	// gcQuarantinePeriod is how long an orphaned object is kept
+	// in quarantine before it is removed
+	gcQuarantinePeriod = 24 * time.Hour
+
+	// quarantineLabel is the volume label holding the time
+	// the volume was put in quarantine
+	quarantineLabel = "quarantined"
+)
+
+// Orphan is a volume or a namespace collected by the garbage collector
+type Orphan struct {
+	// Kind is either "volume" or "namespace"
+	Kind  string
+	Name  string
+	Owner string
+	// Action is what happened to the orphan, one of
+	// "reported", "quarantined" or "removed"
+	Action string
+}
+
+// volumeStore is the part of the storage module used by the garbage collector
+type volumeStore interface {
+	ListVolumes(kind pkg.VolumeKind) ([]pkg.VolumeInfo, error)
+	LabelVolume(name, owner string, labels map[string]string) error
+	ReleaseFilesystem(name string) error
+}
+
+// GC finds the volumes and 0-db namespaces left behind by failed or
+// interrupted deployments, those for which no reservation exists, and
+// reclaims them according to its policy
+type GC struct {
+	cache   provision.ReservationCache
+	storage volumeStore
+	policy  GCPolicy
+
+	// orphaned are the namespaces found without reservation, namespaces
+	// have no metadata to keep it
+	orphaned map[string]*orphanedNamespace
+	mu       sync.Mutex
+}
+
+type orphanedNamespace struct {
+	since       time.Time
+	quarantined bool
+	// public is the flag of the namespace before it was quarantined, it's
+	// restored if the reservation shows up again
+	public bool
+}
+
+// NewGC creates a garbage collector for the reservations in cache
+func NewGC(cache provision.ReservationCache, zbus zbus.Client, policy GCPolicy) (*GC, error) {
+	if err := policy.Valid(); err != nil {
+		return nil, err
+	}
+
+	return &GC{
+		cache:    cache,
+		storage:  stubs.NewStorageModuleStub(zbus),
+		policy:   policy,
+		orphaned: make(map[string]*orphanedNamespace),
+	}, nil
+}
+
+// Run collects the orphans every interval until ctx is canceled
+func (g *GC) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+
+		orphans, err := g.Collect()
+		if err != nil {
+			log.Error().Err(err).Msg("garbage collection failed")
+		}
+		for _, orphan := range orphans {
+			log.Warn().
+				Str("kind", orphan.Kind).
+				Str("name", orphan.Name).
+				Str("owner", orphan.Owner).
+				Str("action", orphan.Action).
+				Msg("orphan without reservation")
+		}
+	}
+}
+
+// Collect runs a single garbage collection pass and returns
+// the orphans it found
+func (g *GC) Collect() ([]Orphan, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	volumes, err := g.collectVolumes(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := g.collectNamespaces(time.Now())
+	if err != nil {
+		return volumes, err
+	}
+
+	return append(volumes, namespaces...), nil
+}
+
+func (g *GC) collectVolumes(now time.Time) ([]Orphan, error) {
+	volumes, err := g.storage.ListVolumes("")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list volumes")
+	}
+
+	var orphans []Orphan
+	for _, volume := range volumes {
+		// only the volumes linked to a reservation are considered, the
+		// others belong to the system or were created before volumes
+		// had an owner
+		if volume.Owner == "" || now.Sub(volume.Created) < gcGracePeriod {
+			continue
+		}
+		if volume.Kind != pkg.VolumeKindVolume && volume.Kind != pkg.VolumeKindRootFSRW {
+			continue
+		}
+
+		exists, err := reservationExists(g.cache, volume.Owner)
+		if err != nil {
+			return orphans, errors.Wrapf(err, "failed to check reservation %s", volume.Owner)
+		}
+
+		quarantined, inQuarantine := volume.Labels[quarantineLabel]
+		if exists {
+			if inQuarantine {
+				// the reservation showed up again, release the volume
+				span := systemCall("LabelVolume", volume.Name)
+				err := g.storage.LabelVolume(volume.Name, "", map[string]string{quarantineLabel: ""})
+				span.Finish(err)
+				if err != nil {
+					log.Error().Err(err).Str("volume", volume.Name).Msg("failed to release volume from quarantine")
+				}
+			}
+			continue
+		}
+
+		orphan := Orphan{Kind: "volume", Name: volume.Name, Owner: volume.Owner}
+		switch g.policy {
+		case GCPolicyReport:
+			orphan.Action = "reported"
+		case GCPolicyQuarantine:
+			since, err := time.Parse(time.RFC3339, quarantined)
+			if !inQuarantine || err != nil {
+				orphan.Action = "quarantined"
+				labels := map[string]string{quarantineLabel: now.Format(time.RFC3339)}
+				span := systemCall("LabelVolume", volume.Name)
+				err := g.storage.LabelVolume(volume.Name, "", labels)
+				span.Finish(err)
+				if err != nil {
+					return orphans, errors.Wrapf(err, "failed to quarantine volume %s", volume.Name)
+				}
+				break
+			}
+			if now.Sub(since) < gcQuarantinePeriod {
+				continue
+			}
+			fallthrough
+		case GCPolicyRemove:
+			orphan.Action = "removed"
+			span := systemCall("ReleaseFilesystem", volume.Name)
+			err := g.storage.ReleaseFilesystem(volume.Name)
+			span.Finish(err)
+			if err != nil {
+				return orphans, errors.Wrapf(err, "failed to remove volume %s", volume.Name)
+			}
+		}
+
+		orphans = append(orphans, orphan)
+	}
+
+	return orphans, nil
+}
+
+// systemCall starts the span of a call to method of the storage module about
+// volume, the node itself is handed over as the caller of the collector calls
+func systemCall(method, volume string) *tracing.Span {
+	ctx := tracing.WithCaller(context.Background(), audit.System)
+	_, span := tracing.Call(ctx, "storage", method, volume)
+	return span
+}
+
+func (g *GC) collectNamespaces(now time.Time) ([]Orphan, error) {
+	volumes, err := g.storage.ListVolumes(pkg.VolumeKindZDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list 0-db volumes")
+	}
+
+	var orphans []Orphan
+	seen := make(map[string]struct{})
+	for _, volume := range volumes {
+		// the 0-db container is named after its volume
+		found, err := g.collectZDB(pkg.ContainerID(volume.Name), now, seen)
+		orphans = append(orphans, found...)
+		if err != nil {
+			log.Error().Err(err).Str("zdb", volume.Name).Msg("failed to collect 0-db namespaces")
+		}
+	}
+
+	// forget the namespaces that are gone or got a reservation
+	for name := range g.orphaned {
+		if _, ok := seen[name]; !ok {
+			delete(g.orphaned, name)
+		}
+	}
+
+	return orphans, nil
+}
+
+func (g *GC) collectZDB(containerID pkg.ContainerID, now time.Time, seen map[string]struct{}) ([]Orphan, error) {
+	cl := zdbConnection(containerID)
+	defer cl.Close()
+	if err := cl.Connect(); err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to 0-db: %s", containerID)
+	}
+
+	namespaces, err := cl.Namespaces()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list namespaces of 0-db: %s", containerID)
+	}
+
+	var orphans []Orphan
+	for _, name := range namespaces {
+		if name == "default" {
+			continue
+		}
+
+		// namespaces are named after their reservation
+		exists, err := reservationExists(g.cache, name)
+		if err != nil {
+			return orphans, errors.Wrapf(err, "failed to check reservation %s", name)
+		}
+		if exists {
+			orphaned, ok := g.orphaned[name]
+			if !ok || !orphaned.quarantined {
+				continue
+			}
+
+			// the reservation showed up again, release the namespace
+			if err := cl.NamespaceSetPublic(name, orphaned.public); err != nil {
+				// keep it to try again on the next pass
+				seen[name] = struct{}{}
+				log.Error().Err(err).Str("namespace", name).Msg("failed to release namespace from quarantine")
+				continue
+			}
+			delete(g.orphaned, name)
+			continue
+		}
+
+		seen[name] = struct{}{}
+		orphaned, ok := g.orphaned[name]
+		if !ok {
+			g.orphaned[name] = &orphanedNamespace{since: now}
+			continue
+		}
+		if now.Sub(orphaned.since) < gcGracePeriod {
+			continue
+		}
+
+		orphan := Orphan{Kind: "namespace", Name: name, Owner: name}
+		switch g.policy {
+		case GCPolicyReport:
+			orphan.Action = "reported"
+		case GCPolicyQuarantine:
+			if !orphaned.quarantined {
+				// a private namespace can only be used with its password
+				orphan.Action = "quarantined"
+				public, err := cl.NamespacePublic(name)
+				if err != nil {
+					return orphans, errors.Wrapf(err, "failed to get public flag of namespace %s", name)
+				}
+				if err := cl.NamespaceSetPublic(name, false); err != nil {
+					return orphans, errors.Wrapf(err, "failed to quarantine namespace %s", name)
+				}
+				orphaned.public = public
+				orphaned.quarantined = true
+				orphaned.since = now
+				break
+			}
+			if now.Sub(orphaned.since) < gcQuarantinePeriod {
+				continue
+			}
+			fallthrough
+		case GCPolicyRemove:
+			orphan.Action = "removed"
+			if err := cl.DeleteNamespace(name); err != nil {
+				return orphans, errors.Wrapf(err, "failed to delete namespace %s", name)
+			}
+			delete(g.orphaned, name)
+		}
+
+		orphans = append(orphans, orphan)
+	}
+
+	return orphans, nil
+}
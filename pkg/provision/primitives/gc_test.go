@@ -0,0 +1,153 @@
+package primitives
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/zdb"
+)
+
+type testVolumeStore struct {
+	mock.Mock
+}
+
+func (s *testVolumeStore) ListVolumes(kind pkg.VolumeKind) ([]pkg.VolumeInfo, error) {
+	args := s.Called(kind)
+	return args.Get(0).([]pkg.VolumeInfo), args.Error(1)
+}
+
+func (s *testVolumeStore) LabelVolume(name, owner string, labels map[string]string) error {
+	args := s.Called(name, owner, labels)
+	return args.Error(0)
+}
+
+func (s *testVolumeStore) ReleaseFilesystem(name string) error {
+	args := s.Called(name)
+	return args.Error(0)
+}
+
+// testCache only knows about the reservations in it
+type testCache map[string]struct{}
+
+func (c testCache) Add(r *provision.Reservation) error            { return nil }
+func (c testCache) Get(id string) (*provision.Reservation, error) { return nil, nil }
+func (c testCache) Remove(id string) error                        { return nil }
+func (c testCache) Sync(provision.Statser) error                  { return nil }
+func (c testCache) Exists(id string) (bool, error) {
+	_, ok := c[id]
+	return ok, nil
+}
+
+func TestGCVolumes(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * gcGracePeriod)
+
+	volumes := []pkg.VolumeInfo{
+		// deployed
+		{Name: "1-1", Kind: pkg.VolumeKindVolume, Owner: "1-1", Created: old},
+		// no owner
+		{Name: "cache", Kind: pkg.VolumeKindCache, Created: old},
+		// deployment in progress
+		{Name: "2-1", Kind: pkg.VolumeKindVolume, Owner: "2-1", Created: now},
+		// orphans
+		{Name: "3-1", Kind: pkg.VolumeKindVolume, Owner: "3-1", Created: old},
+		{
+			Name: "4-1", Kind: pkg.VolumeKindRootFSRW, Owner: "4-1", Created: old,
+			Labels: map[string]string{quarantineLabel: now.Add(-2 * gcQuarantinePeriod).Format(time.RFC3339)},
+		},
+	}
+
+	store := &testVolumeStore{}
+	store.On("ListVolumes", pkg.VolumeKind("")).Return(volumes, nil)
+
+	gc := GC{
+		cache:   testCache{"1-1": {}},
+		storage: store,
+		policy:  GCPolicyReport,
+	}
+
+	orphans, err := gc.collectVolumes(now)
+	require.NoError(t, err)
+	require.Len(t, orphans, 2)
+	assert.Equal(t, "3-1", orphans[0].Name)
+	assert.Equal(t, "reported", orphans[0].Action)
+	store.AssertNotCalled(t, "ReleaseFilesystem", mock.Anything)
+
+	gc.policy = GCPolicyQuarantine
+	store.On("LabelVolume", "3-1", "", map[string]string{quarantineLabel: now.Format(time.RFC3339)}).Return(nil)
+	store.On("ReleaseFilesystem", "4-1").Return(nil)
+
+	orphans, err = gc.collectVolumes(now)
+	require.NoError(t, err)
+	require.Len(t, orphans, 2)
+	assert.Equal(t, "quarantined", orphans[0].Action)
+	assert.Equal(t, "removed", orphans[1].Action)
+	store.AssertExpectations(t)
+}
+
+type testZDB struct {
+	mock.Mock
+	zdb.Client
+}
+
+func (z *testZDB) Connect() error { return nil }
+func (z *testZDB) Close() error   { return nil }
+
+func (z *testZDB) Namespaces() ([]string, error) {
+	args := z.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (z *testZDB) NamespacePublic(name string) (bool, error) {
+	args := z.Called(name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (z *testZDB) NamespaceSetPublic(name string, public bool) error {
+	args := z.Called(name, public)
+	return args.Error(0)
+}
+
+func TestGCNamespaceReappears(t *testing.T) {
+	cl := &testZDB{}
+	cl.On("Namespaces").Return([]string{"default", "5-1"}, nil)
+
+	old := zdbConnection
+	defer func() { zdbConnection = old }()
+	zdbConnection = func(id pkg.ContainerID) zdb.Client { return cl }
+
+	cache := testCache{}
+	gc := GC{
+		cache:    cache,
+		policy:   GCPolicyQuarantine,
+		orphaned: make(map[string]*orphanedNamespace),
+	}
+
+	now := time.Now()
+	_, err := gc.collectZDB("zdb", now, map[string]struct{}{})
+	require.NoError(t, err)
+
+	cl.On("NamespacePublic", "5-1").Return(true, nil)
+	cl.On("NamespaceSetPublic", "5-1", false).Return(nil)
+
+	now = now.Add(2 * gcGracePeriod)
+	orphans, err := gc.collectZDB("zdb", now, map[string]struct{}{})
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "quarantined", orphans[0].Action)
+
+	// the reservation shows up again before the namespace is removed
+	cache["5-1"] = struct{}{}
+	cl.On("NamespaceSetPublic", "5-1", true).Return(nil)
+
+	orphans, err = gc.collectZDB("zdb", now, map[string]struct{}{})
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.NotContains(t, gc.orphaned, "5-1")
+	cl.AssertExpectations(t)
+}
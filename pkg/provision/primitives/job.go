@@ -0,0 +1,209 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/cron"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// Job is a container run to completion on a schedule
+type Job struct {
+	// Schedule is a cron expression (minute hour day-of-month month day-of-week)
+	// evaluated in UTC
+	Schedule string `json:"schedule"`
+	// Concurrency is what to do when a run is due while the previous one
+	// is still running: forbid (the default), replace or allow. With allow
+	// each concurrent run takes one of the IPs of the container network
+	Concurrency cron.ConcurrencyPolicy `json:"concurrency,omitempty"`
+	// History is the number of finished runs kept, defaults to 10
+	History uint `json:"history,omitempty"`
+	// Timeout in seconds after which a run is stopped, 0 means no limit
+	Timeout uint `json:"timeout,omitempty"`
+	// Container is the container started for each run
+	Container Container `json:"container"`
+}
+
+// JobResult is the information return to the BCDB
+// after scheduling a job
+type JobResult struct {
+	ID string `json:"id"`
+	// Next is the time of the next run
+	Next time.Time `json:"next"`
+}
+
+func (p *Provisioner) jobProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.jobProvisionImpl(ctx, reservation)
+}
+
+// jobProvisionImpl schedules the job, the containers are only
+// created when the runs are due
+func (p *Provisioner) jobProvisionImpl(ctx context.Context, reservation *provision.Reservation) (JobResult, error) {
+	var config Job
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return JobResult{}, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateJobConfig(config); err != nil {
+		return JobResult{}, errors.Wrap(err, "job schema not valid")
+	}
+
+	next, err := p.jobs.Add(cron.Job{
+		ID:          reservation.ID,
+		Schedule:    config.Schedule,
+		Concurrency: config.Concurrency,
+		History:     config.History,
+		Timeout:     time.Duration(config.Timeout) * time.Second,
+		Slots:       len(config.Container.Network.IPs),
+	})
+	if err != nil {
+		return JobResult{}, errors.Wrap(err, "failed to schedule job")
+	}
+
+	log.Info().Str("id", reservation.ID).Str("schedule", config.Schedule).Time("next", next).Msg("job scheduled")
+	return JobResult{
+		ID:   reservation.ID,
+		Next: next,
+	}, nil
+}
+
+// jobDecommission unschedules the job and stops its running runs
+func (p *Provisioner) jobDecommission(ctx context.Context, reservation *provision.Reservation) error {
+	runs, err := p.jobs.Remove(reservation.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to unschedule job")
+	}
+
+	for _, run := range runs {
+		if err := p.stopRun(ctx, reservation, run); err != nil {
+			return errors.Wrapf(err, "failed to stop run %s", run.ID)
+		}
+	}
+
+	return nil
+}
+
+// runReservation is the container reservation of a run of the job
+func runReservation(reservation *provision.Reservation, run cron.Run) (*provision.Reservation, Container, error) {
+	var config Job
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return nil, Container{}, err
+	}
+
+	container := config.Container
+	if run.Slot >= len(container.Network.IPs) {
+		return nil, Container{}, fmt.Errorf("invalid slot %d", run.Slot)
+	}
+	container.Network.IPs = []net.IP{container.Network.IPs[run.Slot]}
+	// the run is over once the entrypoint exits
+	container.Probes = nil
+	container.Liveness = nil
+	container.runOnce = true
+
+	data, err := json.Marshal(container)
+	if err != nil {
+		return nil, Container{}, err
+	}
+
+	r := *reservation
+	r.ID = run.ID
+	r.Type = ContainerReservation
+	r.Data = data
+
+	return &r, container, nil
+}
+
+func (p *Provisioner) stopRun(ctx context.Context, reservation *provision.Reservation, run cron.Run) error {
+	r, _, err := runReservation(reservation, run)
+	if err != nil {
+		return err
+	}
+
+	return p.containerDecommission(ctx, r)
+}
+
+// jobRunner starts the runs of the jobs as containers
+type jobRunner struct {
+	p *Provisioner
+}
+
+var _ cron.Runner = (*jobRunner)(nil)
+
+// JobRunner returns the runner of the scheduled jobs
+func (p *Provisioner) JobRunner() cron.Runner {
+	return &jobRunner{p: p}
+}
+
+func (j *jobRunner) StartRun(job cron.Job, run cron.Run) error {
+	reservation, err := j.p.cache.Get(job.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get job reservation")
+	}
+
+	r, container, err := runReservation(reservation, run)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.p.containerRun(context.Background(), r, container, nil)
+	return err
+}
+
+func (j *jobRunner) RunStatus(job cron.Job, run cron.Run) (pkg.ContainerStatus, error) {
+	reservation, err := j.p.cache.Get(job.ID)
+	if err != nil {
+		return pkg.ContainerStatus{}, errors.Wrap(err, "failed to get job reservation")
+	}
+
+	containers := stubs.NewContainerModuleStub(j.p.zbus)
+	return containers.Status(fmt.Sprintf("ns%s", reservation.User), pkg.ContainerID(run.ID))
+}
+
+func (j *jobRunner) StopRun(job cron.Job, run cron.Run) error {
+	reservation, err := j.p.cache.Get(job.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get job reservation")
+	}
+
+	return j.p.stopRun(context.Background(), reservation, run)
+}
+
+// isJobRun checks if id is the ID of a run of a scheduled job, the runs
+// are named after the job with the time they were due
+func isJobRun(cache provision.ReservationCache, id string) bool {
+	idx := strings.LastIndex(id, "-")
+	if idx <= 0 {
+		return false
+	}
+
+	r, err := cache.Get(id[:idx])
+	return err == nil && r != nil && r.Type == JobReservation
+}
+
+func validateJobConfig(config Job) error {
+	if _, err := cron.Parse(config.Schedule); err != nil {
+		return err
+	}
+
+	switch config.Concurrency {
+	case "", cron.ConcurrencyForbid, cron.ConcurrencyReplace, cron.ConcurrencyAllow:
+	default:
+		return fmt.Errorf("unknown concurrency policy '%s'", config.Concurrency)
+	}
+
+	if len(config.Container.Network.IPs) == 0 {
+		return fmt.Errorf("the job container needs an IP")
+	}
+
+	return validateContainerConfig(config.Container)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
 	"github.com/threefoldtech/zos/pkg/provision"
 	"github.com/threefoldtech/zos/pkg/stubs"
 )
@@ -42,8 +43,14 @@ type Kubernetes struct {
 	// the later, the VM will retrieve the github keys for this username
 	// when it boots.
 	SSHKeys []string `json:"ssh_keys"`
+	// IO caps the disk IO of the VM
+	IO pkg.IOLimit `json:"io"`
+	// InteractiveConsole lets the owner of the reservation write to
+	// the serial console of the VM
+	InteractiveConsole bool `json:"interactive_console,omitempty"`
 
 	PlainClusterSecret string `json:"-"`
+	ConsoleOwner       string `json:"-"`
 }
 
 const k3osFlistURL = "https://hub.grid.tf/tf-official-apps/k3os.flist"
@@ -76,6 +83,10 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 		return result, errors.Wrap(err, "failed to decrypt namespace password")
 	}
 
+	if config.InteractiveConsole {
+		config.ConsoleOwner = reservation.User
+	}
+
 	cpu, memory, disk, err := vmSize(config.Size)
 	if err != nil {
 		return result, errors.Wrap(err, "could not interpret vm size")
@@ -100,7 +111,12 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 
 	var diskPath string
 	diskName := fmt.Sprintf("%s-%s", reservation.ID, "vda")
-	if storage.Exists(diskName) {
+	exists, err := storage.Exists(diskName)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to check if disk exists")
+	}
+
+	if exists {
 		needsInstall = false
 		info, err := storage.Inspect(diskName)
 		if err != nil {
@@ -122,7 +138,9 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 
 	var iface string
 	netID := networkID(reservation.User, string(config.NetworkID))
-	iface, err = network.SetupTap(netID)
+	// the MAC of the vm is derived from the reservation, the tap is pinned to it
+	mac := ifaceutil.HardwareAddrFromInputBytes([]byte(reservation.ID))
+	iface, err = network.SetupTap(netID, mac, config.IP)
 	if err != nil {
 		return result, errors.Wrap(err, "could not set up tap device")
 	}
@@ -135,7 +153,7 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 	}()
 
 	var netInfo pkg.VMNetworkInfo
-	netInfo, err = p.buildNetworkInfo(ctx, reservation.User, iface, config)
+	netInfo, err = p.buildNetworkInfo(ctx, reservation.User, iface, mac, config)
 	if err != nil {
 		return result, errors.Wrap(err, "could not generate network info")
 	}
@@ -174,19 +192,20 @@ func (p *Provisioner) kubernetesInstall(ctx context.Context, name string, cpu ui
 
 	disks := make([]pkg.VMDisk, 2)
 	// install disk
-	disks[0] = pkg.VMDisk{Path: diskPath, ReadOnly: false, Root: false}
+	disks[0] = pkg.VMDisk{Path: diskPath, ReadOnly: false, Root: false, IO: cfg.IO}
 	// install ISO
 	disks[1] = pkg.VMDisk{Path: imagePath + "/k3os-amd64.iso", ReadOnly: true, Root: false}
 
 	installVM := pkg.VM{
-		Name:        name,
-		CPU:         cpu,
-		Memory:      int64(memory),
-		Network:     networkInfo,
-		KernelImage: imagePath + "/k3os-vmlinux",
-		InitrdImage: imagePath + "/k3os-initrd-amd64",
-		KernelArgs:  cmdline,
-		Disks:       disks,
+		Name:         name,
+		CPU:          cpu,
+		Memory:       int64(memory),
+		Network:      networkInfo,
+		KernelImage:  imagePath + "/k3os-vmlinux",
+		InitrdImage:  imagePath + "/k3os-initrd-amd64",
+		KernelArgs:   cmdline,
+		Disks:        disks,
+		ConsoleOwner: cfg.ConsoleOwner,
 	}
 
 	if err := vm.Run(installVM); err != nil {
@@ -196,7 +215,11 @@ func (p *Provisioner) kubernetesInstall(ctx context.Context, name string, cpu ui
 	deadline, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
 	for {
-		if !vm.Exists(name) {
+		exists, err := vm.Exists(name)
+		if err != nil {
+			return errors.Wrap(err, "failed to check if vm exists")
+		}
+		if !exists {
 			// install is done
 			break
 		}
@@ -217,17 +240,18 @@ func (p *Provisioner) kubernetesRun(ctx context.Context, name string, cpu uint8,
 
 	disks := make([]pkg.VMDisk, 1)
 	// installed disk
-	disks[0] = pkg.VMDisk{Path: diskPath, ReadOnly: false, Root: false}
+	disks[0] = pkg.VMDisk{Path: diskPath, ReadOnly: false, Root: false, IO: cfg.IO}
 
 	kubevm := pkg.VM{
-		Name:        name,
-		CPU:         cpu,
-		Memory:      int64(memory),
-		Network:     networkInfo,
-		KernelImage: imagePath + "/k3os-vmlinux",
-		InitrdImage: imagePath + "/k3os-initrd-amd64",
-		KernelArgs:  "console=ttyS0 reboot=k panic=1",
-		Disks:       disks,
+		Name:         name,
+		CPU:          cpu,
+		Memory:       int64(memory),
+		Network:      networkInfo,
+		KernelImage:  imagePath + "/k3os-vmlinux",
+		InitrdImage:  imagePath + "/k3os-initrd-amd64",
+		KernelArgs:   "console=ttyS0 reboot=k panic=1",
+		Disks:        disks,
+		ConsoleOwner: cfg.ConsoleOwner,
 	}
 
 	return vm.Run(kubevm)
@@ -269,7 +293,7 @@ func (p *Provisioner) kubernetesDecomission(ctx context.Context, reservation *pr
 	return nil
 }
 
-func (p *Provisioner) buildNetworkInfo(ctx context.Context, userID string, iface string, cfg Kubernetes) (pkg.VMNetworkInfo, error) {
+func (p *Provisioner) buildNetworkInfo(ctx context.Context, userID string, iface string, mac net.HardwareAddr, cfg Kubernetes) (pkg.VMNetworkInfo, error) {
 	network := stubs.NewNetworkerStub(p.zbus)
 
 	netID := networkID(userID, string(cfg.NetworkID))
@@ -294,7 +318,7 @@ func (p *Provisioner) buildNetworkInfo(ctx context.Context, userID string, iface
 
 	networkInfo := pkg.VMNetworkInfo{
 		Tap:         iface,
-		MAC:         "", // rely on static IP configuration so we don't care here
+		MAC:         mac.String(),
 		AddressCIDR: addrCIDR,
 		GatewayIP:   net.IP(gw),
 		Nameservers: []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4")},
@@ -15,6 +15,7 @@ import (
 
 	"github.com/threefoldtech/zos/pkg/provision"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 )
 
 // networkProvision is entry point to provision a network
@@ -29,7 +30,9 @@ func (p *Provisioner) networkProvisionImpl(ctx context.Context, reservation *pro
 	mgr := stubs.NewNetworkerStub(p.zbus)
 	log.Debug().Str("network", fmt.Sprintf("%+v", network)).Msg("provision network")
 
-	_, err := mgr.CreateNR(*network)
+	_, span := tracing.Call(ctx, "network", "CreateNR", string(network.NetID))
+	_, err := mgr.CreateNRRequest(reservation.RequestID(), *network)
+	span.Finish(err)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create network resource for network %s", network.NetID)
 	}
@@ -51,7 +54,10 @@ func (p *Provisioner) networkDecommission(ctx context.Context, reservation *prov
 
 	network.NetID = networkID(reservation.User, network.Name)
 
-	if err := mgr.DeleteNR(*network); err != nil {
+	_, span := tracing.Call(ctx, "network", "DeleteNR", string(network.NetID))
+	err := mgr.DeleteNR(*network)
+	span.Finish(err)
+	if err != nil {
 		return errors.Wrap(err, "failed to delete network resource")
 	}
 	return nil
@@ -0,0 +1,129 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+const (
+	// TODO: make this configurable
+	nfsFlistURL = "https://hub.grid.tf/tf-official-apps/nfs-ganesha:latest.flist"
+	// nfsExportPath is where the volume is mounted in the
+	// server container, and the path clients mount
+	nfsExportPath = "/export"
+)
+
+// NFS exports a volume over NFSv4 in the user network, so the
+// containers and VMs of the network can share a filesystem
+type NFS struct {
+	// VolumeID is the reservation ID of the exported volume,
+	// it must belong to the user of the export
+	VolumeID string `json:"volume_id"`
+	// ReadOnly exports the volume read-only
+	ReadOnly bool `json:"read_only"`
+	// Network is the user network the volume is exported in, only the
+	// members of the network can reach the server
+	Network Network `json:"network"`
+	// Capacity is the amount of resource to allocate to the server
+	Capacity ContainerCapacity `json:"capacity"`
+}
+
+// NFSResult is the information return to the BCDB
+// after exporting a volume
+type NFSResult struct {
+	ID   string `json:"id"`
+	IPv4 string `json:"ipv4"`
+	Path string `json:"path"`
+}
+
+func (p *Provisioner) nfsProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.nfsProvisionImpl(ctx, reservation)
+}
+
+// nfsProvisionImpl runs the NFS server as a container joined to the user
+// network, with the volume mounted at the export path
+func (p *Provisioner) nfsProvisionImpl(ctx context.Context, reservation *provision.Reservation) (NFSResult, error) {
+	var config NFS
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return NFSResult{}, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateNFSConfig(config); err != nil {
+		return NFSResult{}, errors.Wrap(err, "NFS export schema not valid")
+	}
+
+	container := Container{
+		FList: nfsFlistURL,
+		Env: map[string]string{
+			"EXPORT_PATH": nfsExportPath,
+			"READ_ONLY":   fmt.Sprint(config.ReadOnly),
+			"CLIENTS":     networkClients(config.Network).String(),
+		},
+		// the ownership of the volume is checked when it is mounted
+		Mounts: []Mount{
+			{VolumeID: config.VolumeID, Mountpoint: nfsExportPath},
+		},
+		Network:  config.Network,
+		Capacity: config.Capacity,
+	}
+
+	data, err := json.Marshal(container)
+	if err != nil {
+		return NFSResult{}, err
+	}
+
+	server := *reservation
+	server.Data = data
+
+	result, err := p.containerProvisionImpl(ctx, &server)
+	if err != nil {
+		return NFSResult{}, err
+	}
+
+	log.Info().Str("id", reservation.ID).Str("volume", config.VolumeID).Msg("volume exported over NFS")
+	return NFSResult{
+		ID:   result.ID,
+		IPv4: result.IPv4,
+		Path: nfsExportPath,
+	}, nil
+}
+
+// nfsDecommission stops exporting the volume, the volume
+// itself is decommissioned with its own reservation
+func (p *Provisioner) nfsDecommission(ctx context.Context, reservation *provision.Reservation) error {
+	// the network of the export is encoded
+	// the same way as the one of a container
+	return p.containerDecommission(ctx, reservation)
+}
+
+// networkClients is the range of the user network, only
+// its members can reach the exports
+func networkClients(network Network) *net.IPNet {
+	mask := net.CIDRMask(16, 32)
+	return &net.IPNet{
+		IP:   network.IPs[0].To4().Mask(mask),
+		Mask: mask,
+	}
+}
+
+func validateNFSConfig(config NFS) error {
+	if config.VolumeID == "" {
+		return fmt.Errorf("volume ID cannot be empty")
+	}
+
+	if config.Network.NetworkID == "" || len(config.Network.IPs) == 0 {
+		return fmt.Errorf("network ID and IP are required")
+	}
+
+	if config.Network.IPs[0].To4() == nil {
+		return fmt.Errorf("the export IP must be an IPv4 address of the network")
+	}
+
+	return nil
+}
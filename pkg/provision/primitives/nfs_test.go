@@ -0,0 +1,28 @@
+package primitives
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNFSConfig(t *testing.T) {
+	valid := NFS{
+		VolumeID: "1-2",
+		Network: Network{
+			NetworkID: "net",
+			IPs:       []net.IP{net.ParseIP("10.1.3.2")},
+		},
+	}
+	assert.NoError(t, validateNFSConfig(valid))
+	assert.Equal(t, "10.1.0.0/16", networkClients(valid.Network).String())
+
+	config := valid
+	config.VolumeID = ""
+	assert.Error(t, validateNFSConfig(config))
+
+	config = valid
+	config.Network.IPs = []net.IP{net.ParseIP("fd00::2")}
+	assert.Error(t, validateNFSConfig(config))
+}
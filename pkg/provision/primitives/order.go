@@ -15,6 +15,16 @@ const (
 	DebugReservation provision.ReservationType = "debug"
 	// KubernetesReservation type
 	KubernetesReservation provision.ReservationType = "kubernetes"
+	// S3Reservation type
+	S3Reservation provision.ReservationType = "s3"
+	// NFSReservation type
+	NFSReservation provision.ReservationType = "nfs"
+	// BlockReservation type
+	BlockReservation provision.ReservationType = "block"
+	// DeploymentReservation type
+	DeploymentReservation provision.ReservationType = "deployment"
+	// JobReservation type
+	JobReservation provision.ReservationType = "job"
 )
 
 // ProvisionOrder is used to sort the workload type
@@ -26,4 +36,9 @@ var ProvisionOrder = map[provision.ReservationType]int{
 	VolumeReservation:     3,
 	ContainerReservation:  4,
 	KubernetesReservation: 5,
+	S3Reservation:         6,
+	NFSReservation:        7,
+	BlockReservation:      8,
+	DeploymentReservation: 9,
+	JobReservation:        10,
 }
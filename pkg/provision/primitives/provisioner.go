@@ -1,25 +1,39 @@
 package primitives
 
 import (
+	"sync"
+
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/cron"
+	"github.com/threefoldtech/zos/pkg/provision/probe"
 )
 
 // Provisioner hold all the logic responsible to provision and decomission
 // the different primitives workloads defined by this package
 type Provisioner struct {
-	cache provision.ReservationCache
-	zbus  zbus.Client
+	cache  provision.ReservationCache
+	zbus   zbus.Client
+	probes *probe.Manager
+	jobs   *cron.Scheduler
+
+	// members are the workloads of the deployments being deployed
+	members sync.Map
 
 	Provisioners    map[provision.ReservationType]provision.ProvisionerFunc
+	Updaters        map[provision.ReservationType]provision.UpdaterFunc
 	Decommissioners map[provision.ReservationType]provision.DecomissionerFunc
 }
 
 // NewProvisioner creates a new 0-OS provisioner
-func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisioner {
+// probes runs the readiness probes of the workloads
+// jobs schedules the runs of the jobs
+func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client, probes *probe.Manager, jobs *cron.Scheduler) *Provisioner {
 	p := &Provisioner{
-		cache: cache,
-		zbus:  zbus,
+		cache:  cache,
+		zbus:   zbus,
+		probes: probes,
+		jobs:   jobs,
 	}
 	p.Provisioners = map[provision.ReservationType]provision.ProvisionerFunc{
 		ContainerReservation:  p.containerProvision,
@@ -28,6 +42,14 @@ func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisi
 		ZDBReservation:        p.zdbProvision,
 		DebugReservation:      p.debugProvision,
 		KubernetesReservation: p.kubernetesProvision,
+		S3Reservation:         p.s3Provision,
+		NFSReservation:        p.nfsProvision,
+		BlockReservation:      p.blockProvision,
+		DeploymentReservation: p.deploymentProvision,
+		JobReservation:        p.jobProvision,
+	}
+	p.Updaters = map[provision.ReservationType]provision.UpdaterFunc{
+		ContainerReservation: p.containerUpdate,
 	}
 	p.Decommissioners = map[provision.ReservationType]provision.DecomissionerFunc{
 		ContainerReservation:  p.containerDecommission,
@@ -36,6 +58,11 @@ func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisi
 		ZDBReservation:        p.zdbDecommission,
 		DebugReservation:      p.debugDecommission,
 		KubernetesReservation: p.kubernetesDecomission,
+		S3Reservation:         p.s3Decommission,
+		NFSReservation:        p.nfsDecommission,
+		BlockReservation:      p.blockDecommission,
+		DeploymentReservation: p.deploymentDecommission,
+		JobReservation:        p.jobDecommission,
 	}
 
 	return p
@@ -0,0 +1,189 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	nwmod "github.com/threefoldtech/zos/pkg/network"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+const (
+	// TODO: make this configurable
+	minioFlistURL = "https://hub.grid.tf/tf-official-apps/minio:latest.flist"
+	minioPort     = 9000
+)
+
+// S3 is a S3 compatible gateway storing its objects
+// in 0-db namespaces deployed on the node
+type S3 struct {
+	// Namespaces are the reservation IDs of the 0-db namespaces holding the
+	// objects. They must belong to the user of the gateway
+	Namespaces []string `json:"namespaces"`
+	// DataShards and ParityShards configure the erasure coding of the
+	// objects over the namespaces
+	DataShards   uint `json:"data_shards"`
+	ParityShards uint `json:"parity_shards"`
+	// AccessKey is the access key of the S3 API
+	AccessKey string `json:"access_key"`
+	// SecretKey is the secret key of the S3 API encrypted
+	// with the node public key
+	SecretKey string `json:"secret_key"`
+	// Network is the user network the gateway is reachable on
+	Network Network `json:"network"`
+	// Capacity is the amount of resource to allocate to the gateway
+	Capacity ContainerCapacity `json:"capacity"`
+}
+
+// S3Result is the information return to the BCDB
+// after deploying a S3 gateway
+type S3Result struct {
+	ID   string `json:"id"`
+	IPv4 string `json:"ipv4"`
+	IPv6 string `json:"ipv6"`
+	Port uint   `json:"port"`
+}
+
+func (p *Provisioner) s3Provision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.s3ProvisionImpl(ctx, reservation)
+}
+
+// s3ProvisionImpl runs the gateway as a container joined to the user network
+func (p *Provisioner) s3ProvisionImpl(ctx context.Context, reservation *provision.Reservation) (S3Result, error) {
+	var config S3
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return S3Result{}, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateS3Config(config); err != nil {
+		return S3Result{}, errors.Wrap(err, "S3 gateway schema not valid")
+	}
+
+	shards := make([]string, len(config.Namespaces))
+	for i, nsID := range config.Namespaces {
+		shard, err := p.s3Shard(ctx, reservation.User, nsID)
+		if err != nil {
+			return S3Result{}, errors.Wrapf(err, "failed to get namespace %s", nsID)
+		}
+		shards[i] = shard
+	}
+
+	container := Container{
+		FList: minioFlistURL,
+		Env: map[string]string{
+			"SHARDS":     strings.Join(shards, ","),
+			"DATA":       fmt.Sprint(config.DataShards),
+			"PARITY":     fmt.Sprint(config.ParityShards),
+			"ACCESS_KEY": config.AccessKey,
+		},
+		SecretEnv: map[string]string{
+			"SECRET_KEY": config.SecretKey,
+		},
+		Network:  config.Network,
+		Capacity: config.Capacity,
+	}
+
+	data, err := json.Marshal(container)
+	if err != nil {
+		return S3Result{}, err
+	}
+
+	gateway := *reservation
+	gateway.Data = data
+
+	result, err := p.containerProvisionImpl(ctx, &gateway)
+	if err != nil {
+		return S3Result{}, err
+	}
+
+	log.Info().Str("id", reservation.ID).Int("shards", len(shards)).Msg("S3 gateway deployed")
+	return S3Result{
+		ID:   result.ID,
+		IPv4: result.IPv4,
+		IPv6: result.IPv6,
+		Port: minioPort,
+	}, nil
+}
+
+// s3Shard returns the shard of the gateway for the namespace of the
+// reservation nsID, formatted as namespace:password@[ip]:port
+func (p *Provisioner) s3Shard(ctx context.Context, user, nsID string) (string, error) {
+	r, err := p.reservation(nsID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to retrieve the namespace reservation")
+	}
+
+	if r.Type != ZDBReservation {
+		return "", fmt.Errorf("reservation %s is not a 0-db namespace", nsID)
+	}
+
+	if r.User != user {
+		return "", fmt.Errorf("cannot use namespace %s, user %s is not the owner of it", nsID, user)
+	}
+
+	var config ZDB
+	if err := json.Unmarshal(r.Data, &config); err != nil {
+		return "", errors.Wrap(err, "failed to decode namespace schema")
+	}
+
+	password, err := decryptSecret(p.zbus, config.Password)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt namespace password")
+	}
+
+	allocation, err := stubs.NewZDBAllocaterStub(p.zbus).Find(nsID)
+	if err != nil {
+		return "", err
+	}
+
+	cont, err := stubs.NewContainerModuleStub(p.zbus).Inspect(zdbContainerNS, pkg.ContainerID(allocation.VolumeID))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find namespace 0-db container")
+	}
+
+	ip, err := p.getIfaceIP(ctx, nwmod.ZDBIface, cont.Network.Namespace)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%s@[%s]:%d", nsID, password, ip.String(), zdbPort), nil
+}
+
+// s3Decommission removes the gateway container, the namespaces are
+// decommissioned with their own reservations
+func (p *Provisioner) s3Decommission(ctx context.Context, reservation *provision.Reservation) error {
+	// the network and capacity of the gateway are
+	// encoded the same way as the ones of a container
+	return p.containerDecommission(ctx, reservation)
+}
+
+func validateS3Config(config S3) error {
+	if len(config.Namespaces) == 0 {
+		return fmt.Errorf("at least one namespace is required")
+	}
+
+	if config.DataShards == 0 {
+		return fmt.Errorf("data shards cannot be 0")
+	}
+
+	if int(config.DataShards+config.ParityShards) > len(config.Namespaces) {
+		return fmt.Errorf("%d data and %d parity shards need at least as many namespaces, got %d",
+			config.DataShards, config.ParityShards, len(config.Namespaces))
+	}
+
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return fmt.Errorf("access key and secret key are required")
+	}
+
+	if config.Network.NetworkID == "" || len(config.Network.IPs) == 0 {
+		return fmt.Errorf("network ID and IP are required")
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package primitives
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateS3Config(t *testing.T) {
+	valid := S3{
+		Namespaces:   []string{"1-1", "1-2", "1-3"},
+		DataShards:   2,
+		ParityShards: 1,
+		AccessKey:    "access",
+		SecretKey:    "73656372657420",
+		Network: Network{
+			NetworkID: "net",
+			IPs:       []net.IP{net.ParseIP("10.1.1.2")},
+		},
+	}
+	assert.NoError(t, validateS3Config(valid))
+
+	config := valid
+	config.ParityShards = 2
+	assert.Error(t, validateS3Config(config), "more shards than namespaces")
+
+	config = valid
+	config.DataShards = 0
+	assert.Error(t, validateS3Config(config))
+
+	config = valid
+	config.SecretKey = ""
+	assert.Error(t, validateS3Config(config))
+
+	config = valid
+	config.Network.IPs = nil
+	assert.Error(t, validateS3Config(config))
+}
@@ -0,0 +1,137 @@
+package primitives
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+const (
+	// secretsRoot is where the secret files of the containers are written,
+	// each container gets its own tmpfs so a secret never hits a disk
+	secretsRoot = "/var/run/secrets"
+	// secretsMountpoint is where the secret files show up in the container
+	secretsMountpoint = "/run/secrets"
+	secretsSize       = 1024 * 1024
+)
+
+var secretNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+
+// the secret files of a container are sealed to the node identity: each value
+// is encrypted with the node public key, and only decrypted by the node when
+// the container starts. Unlike the secret env, the decrypted values never go
+// in the container spec, which containerd keeps on disk: each one is written
+// to its own file under /run/secrets, a tmpfs
+
+func validateSecretFiles(secrets map[string]string) error {
+	for name := range secrets {
+		if !secretNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid secret file name '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// containerSecrets decrypts the secret files of the container id in its
+// secrets tmpfs, readable by the group gid of the container. It returns the
+// mount exposing them
+func (p *Provisioner) containerSecrets(id string, gid uint32, secrets map[string]string) ([]pkg.MountInfo, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+
+	if err := validateSecretFiles(secrets); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for name, encrypted := range secrets {
+		value, err := decryptSecret(p.zbus, encrypted)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt secret file '%s'", name)
+		}
+
+		files[name] = []byte(value)
+	}
+
+	dir, err := writeSecrets(stubs.NewBrokerStub(p.zbus), secretsRoot, id, gid, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return []pkg.MountInfo{{Source: dir, Target: secretsMountpoint}}, nil
+}
+
+// writeSecrets writes the secret files of the container id in its own tmpfs
+// under root, mounted by broker. The files of a previous run are removed first.
+//
+// provisiond can't chown, so the tmpfs is owned by the group gid with the
+// setgid bit: the files written by provisiond get the group of the container,
+// which can read them whatever user it runs as
+func writeSecrets(broker pkg.Broker, root, id string, gid uint32, files map[string][]byte) (string, error) {
+	dir := filepath.Join(root, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create secrets directory of %s", id)
+	}
+
+	if !filesystem.IsMountPoint(dir) {
+		opts := []string{
+			"nosuid", "nodev", "noexec",
+			fmt.Sprintf("size=%d", secretsSize),
+			"mode=2750",
+			fmt.Sprintf("gid=%d", gid),
+		}
+		if err := broker.Mount("tmpfs", dir, "tmpfs", opts); err != nil {
+			return "", errors.Wrapf(err, "failed to mount secrets tmpfs of %s", id)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		if err := os.RemoveAll(filepath.Join(dir, info.Name())); err != nil {
+			return "", err
+		}
+	}
+
+	for name, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), value, 0440); err != nil {
+			return "", errors.Wrapf(err, "failed to write secret file '%s'", name)
+		}
+	}
+
+	return dir, nil
+}
+
+// removeSecrets drops the secret files of the container id, their tmpfs
+// is unmounted by broker
+func removeSecrets(broker pkg.Broker, root, id string) error {
+	dir := filepath.Join(root, id)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	if filesystem.IsMountPoint(dir) {
+		if err := broker.Unmount(dir); err != nil {
+			return errors.Wrapf(err, "failed to unmount secrets of %s", id)
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed to remove secrets of %s", id)
+	}
+
+	log.Debug().Str("container", id).Msg("secrets removed")
+	return nil
+}
@@ -0,0 +1,104 @@
+package primitives
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestValidateSecretFiles(t *testing.T) {
+	assert.NoError(t, validateSecretFiles(map[string]string{
+		"DB_PASSWORD": "aa",
+		"tls.key":     "bb",
+	}))
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"space", "MY KEY"},
+		{"traversal", "../../etc/shadow"},
+		{"path", "keys/tls.key"},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Error(t, validateSecretFiles(map[string]string{test.secret: "aa"}))
+		})
+	}
+}
+
+func TestRemoveSecrets(t *testing.T) {
+	root, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// nothing is mounted, the broker is not called
+	require.NoError(t, removeSecrets(nil, root, "1-1"))
+
+	dir := filepath.Join(root, "1-1")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "key"), []byte("secret"), 0400))
+
+	require.NoError(t, removeSecrets(nil, root, "1-1"))
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+type testBroker struct {
+	pkg.Broker
+	options []string
+}
+
+func (b *testBroker) Mount(source, target, fstype string, options []string) error {
+	b.options = options
+	return nil
+}
+
+func TestWriteSecrets(t *testing.T) {
+	root, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	broker := &testBroker{}
+	dir, err := writeSecrets(broker, root, "1-1", 1000, map[string][]byte{"DB_PASSWORD": []byte("secret")})
+	require.NoError(t, err)
+
+	// the tmpfs gives the files to the group of the container
+	assert.Contains(t, broker.options, "gid=1000")
+	assert.Contains(t, broker.options, "mode=2750")
+
+	info, err := os.Stat(filepath.Join(dir, "DB_PASSWORD"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0440), info.Mode().Perm())
+}
+
+func TestParseUser(t *testing.T) {
+	tests := []struct {
+		user string
+		uid  uint32
+		gid  uint32
+	}{
+		{"", 0, 0},
+		{"1000", 1000, 1000},
+		{"1000:100", 1000, 100},
+	}
+
+	for _, test := range tests {
+		uid, gid, err := parseUser(test.user)
+		require.NoError(t, err, test.user)
+		assert.Equal(t, test.uid, uid, test.user)
+		assert.Equal(t, test.gid, gid, test.user)
+	}
+
+	for _, user := range []string{"root", "1000:", "-1", "1000:users", "4294967296"} {
+		_, _, err := parseUser(user)
+		assert.Error(t, err, user)
+	}
+}
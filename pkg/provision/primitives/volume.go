@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/json"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg"
 
 	"github.com/threefoldtech/zos/pkg/provision"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 )
 
 const (
@@ -20,7 +22,8 @@ const (
 type Volume struct {
 	// Size of the volume in GiB
 	Size uint64 `json:"size"`
-	// Type of disk underneath the volume
+	// Type of disk underneath the volume, a volume of type
+	// memory is a tmpfs lost when the volume is decommissioned
 	Type pkg.DeviceType `json:"type"`
 }
 
@@ -46,11 +49,37 @@ func (p *Provisioner) volumeProvisionImpl(ctx context.Context, reservation *prov
 		}, nil
 	}
 
-	_, err = storageClient.CreateFilesystem(reservation.ID, config.Size*gigabyte, config.Type)
+	kind := pkg.VolumeKindVolume
+	if config.Type == pkg.MemoryDevice {
+		kind = pkg.VolumeKindTmpfs
+	}
+
+	_, span := tracing.Call(ctx, "storage", "CreateVolumeRequest", reservation.ID)
+	_, err = storageClient.CreateVolumeRequest(reservation.RequestID(), reservation.ID, config.Size*gigabyte, config.Type, kind)
+	span.Finish(err)
+	if err != nil {
+		return VolumeResult{}, err
+	}
+
+	_, span = tracing.Call(ctx, "storage", "LabelVolume", reservation.ID)
+	err = storageClient.LabelVolume(reservation.ID, reservation.ID, reservationLabels(reservation))
+	span.Finish(err)
+	if err != nil {
+		return VolumeResult{}, errors.Wrap(err, "failed to label volume")
+	}
 
 	return VolumeResult{
 		ID: reservation.ID,
-	}, err
+	}, nil
+}
+
+// reservationLabels are the labels set on the volumes
+// created for reservation
+func reservationLabels(reservation *provision.Reservation) map[string]string {
+	return map[string]string{
+		"user": reservation.User,
+		"type": string(reservation.Type),
+	}
 }
 
 // VolumeProvision is entry point to provision a volume
@@ -61,5 +90,8 @@ func (p *Provisioner) volumeProvision(ctx context.Context, reservation *provisio
 func (p *Provisioner) volumeDecommission(ctx context.Context, reservation *provision.Reservation) error {
 	storageClient := stubs.NewStorageModuleStub(p.zbus)
 
-	return storageClient.ReleaseFilesystem(reservation.ID)
+	_, span := tracing.Call(ctx, "storage", "ReleaseFilesystem", reservation.ID)
+	err := storageClient.ReleaseFilesystem(reservation.ID)
+	span.Finish(err)
+	return err
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/threefoldtech/zos/pkg"
 	nwmod "github.com/threefoldtech/zos/pkg/network"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/tracing"
 )
 
 const (
@@ -71,7 +72,9 @@ func (p *Provisioner) zdbProvisionImpl(ctx context.Context, reservation *provisi
 
 	// if we reached here, we need to create the 0-db namespace
 	log.Debug().Msg("allocating storage for namespace")
+	_, span := tracing.Call(ctx, "storage", "Allocate", nsID)
 	allocation, err := storage.Allocate(nsID, config.DiskType, config.Size*gigabyte, config.Mode)
+	span.Finish(err)
 	if err != nil {
 		return ZDBResult{}, errors.Wrap(err, "failed to allocate storage")
 	}
@@ -0,0 +1,207 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+type probeState struct {
+	threshold uint
+	failures  uint
+	passing   bool
+	err       error
+}
+
+type workload struct {
+	cancel context.CancelFunc
+	probes []probeState
+	ready  bool
+}
+
+var _ pkg.ReadinessMonitor = (*Manager)(nil)
+
+// Manager runs the probes of all the workloads of the node
+// and keeps track of their readiness
+type Manager struct {
+	check Checker
+
+	mu          sync.Mutex
+	workloads   map[string]*workload
+	subscribers map[chan pkg.ReadinessEvent]struct{}
+}
+
+// NewManager creates a new probes manager, the probes are run with check
+func NewManager(check Checker) *Manager {
+	return &Manager{
+		check:       check,
+		workloads:   make(map[string]*workload),
+		subscribers: make(map[chan pkg.ReadinessEvent]struct{}),
+	}
+}
+
+// Watch starts running the probes of workload id. Watching a workload
+// that is already watched restarts its probes. A workload without
+// probes is ready right away
+func (m *Manager) Watch(id string, target Target, probes []Probe) error {
+	for i := range probes {
+		if err := probes[i].Valid(); err != nil {
+			return err
+		}
+	}
+
+	m.Stop(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wl := &workload{
+		cancel: cancel,
+		probes: make([]probeState, len(probes)),
+	}
+	for i := range probes {
+		wl.probes[i].threshold = probes[i].failureThreshold()
+	}
+
+	m.mu.Lock()
+	m.workloads[id] = wl
+	m.mu.Unlock()
+
+	if len(probes) == 0 {
+		m.update(id, wl, -1, nil)
+		return nil
+	}
+
+	for i, probe := range probes {
+		go m.run(ctx, id, wl, i, target, probe)
+	}
+
+	return nil
+}
+
+// Stop stops the probes of workload id
+func (m *Manager) Stop(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if wl, ok := m.workloads[id]; ok {
+		wl.cancel()
+		delete(m.workloads, id)
+	}
+}
+
+func (m *Manager) run(ctx context.Context, id string, wl *workload, index int, target Target, probe Probe) {
+	for {
+		err := m.check(ctx, target, probe)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.update(id, wl, index, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(probe.interval()):
+		}
+	}
+}
+
+// update records the result of probe index and publishes an event
+// if the readiness of the workload changed. index -1 only re-evaluates
+// the workload readiness
+func (m *Manager) update(id string, wl *workload, index int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.workloads[id] != wl {
+		// workload was stopped or restarted
+		return
+	}
+
+	if index >= 0 {
+		state := &wl.probes[index]
+		if err == nil {
+			state.failures = 0
+			state.passing = true
+			state.err = nil
+		} else {
+			state.failures++
+			state.err = err
+			// a probe that never passed is failing right away, otherwise
+			// we wait for the threshold to avoid flapping
+			if !state.passing || state.failures >= state.threshold {
+				state.passing = false
+			}
+		}
+	}
+
+	ready := true
+	var reason error
+	for _, state := range wl.probes {
+		if !state.passing {
+			ready = false
+			reason = state.err
+			break
+		}
+	}
+
+	if ready == wl.ready && index >= 0 {
+		return
+	}
+
+	wl.ready = ready
+	event := pkg.ReadinessEvent{
+		ID:    id,
+		Ready: ready,
+		Time:  time.Now(),
+	}
+	if reason != nil {
+		event.Error = reason.Error()
+	}
+
+	log.Info().Str("id", id).Bool("ready", ready).Str("error", event.Error).Msg("workload readiness changed")
+
+	for sub := range m.subscribers {
+		select {
+		case sub <- event:
+		default:
+			// slow subscribers lose events rather than blocking the probes
+		}
+	}
+}
+
+// Ready implements pkg.ReadinessMonitor
+func (m *Manager) Ready(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wl, ok := m.workloads[id]
+	if !ok {
+		return false, fmt.Errorf("workload '%s' is not watched", id)
+	}
+
+	return wl.ready, nil
+}
+
+// Readiness implements pkg.ReadinessMonitor
+func (m *Manager) Readiness(ctx context.Context) <-chan pkg.ReadinessEvent {
+	ch := make(chan pkg.ReadinessEvent, 16)
+
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
@@ -0,0 +1,91 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+type fakeChecker struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *fakeChecker) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeChecker) check(ctx context.Context, target Target, probe Probe) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func next(t *testing.T, ch <-chan pkg.ReadinessEvent) pkg.ReadinessEvent {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for readiness event")
+	}
+	return pkg.ReadinessEvent{}
+}
+
+func TestManagerTransitions(t *testing.T) {
+	checker := &fakeChecker{err: fmt.Errorf("connection refused")}
+	m := NewManager(checker.check)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.Readiness(ctx)
+
+	probes := []Probe{{Type: TCP, Port: 80, Interval: 1, FailureThreshold: 1}}
+	require.NoError(t, m.Watch("wl1", Target{}, probes))
+	defer m.Stop("wl1")
+
+	ready, err := m.Ready("wl1")
+	require.NoError(t, err)
+	assert.False(t, ready)
+
+	checker.set(nil)
+	event := next(t, events)
+	assert.Equal(t, "wl1", event.ID)
+	assert.True(t, event.Ready)
+
+	checker.set(fmt.Errorf("connection refused"))
+	event = next(t, events)
+	assert.False(t, event.Ready)
+	assert.Equal(t, "connection refused", event.Error)
+}
+
+func TestManagerNoProbes(t *testing.T) {
+	m := NewManager(nil)
+
+	require.NoError(t, m.Watch("wl1", Target{}, nil))
+	ready, err := m.Ready("wl1")
+	require.NoError(t, err)
+	assert.True(t, ready)
+
+	m.Stop("wl1")
+	_, err = m.Ready("wl1")
+	assert.Error(t, err)
+}
+
+func TestProbeValid(t *testing.T) {
+	p := Probe{Type: "udp", Port: 53}
+	assert.Error(t, p.Valid())
+
+	p = Probe{Type: HTTP}
+	assert.Error(t, p.Valid())
+
+	p = Probe{Type: HTTP, Port: 80, Path: "/health"}
+	assert.NoError(t, p.Valid())
+}
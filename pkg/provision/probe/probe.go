@@ -0,0 +1,107 @@
+// Package probe implements the readiness probes of the workloads.
+//
+// A workload can declare a list of TCP or HTTP probes. The probes are executed
+// from inside the network namespace of the workload, and a workload is ready
+// once all its probes pass. Each readiness transition is published as a
+// pkg.ReadinessEvent, so orchestrators can wait for a workload to be ready
+// before exposing it (DNS, gateway, ...).
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// Type of probe
+type Type string
+
+const (
+	// TCP probes succeed if a tcp connection can be established
+	TCP Type = "tcp"
+	// HTTP probes succeed if a GET request returns a 2xx or 3xx status
+	HTTP Type = "http"
+)
+
+const (
+	defaultInterval         = 10
+	defaultTimeout          = 2
+	defaultFailureThreshold = 3
+)
+
+// Probe is a readiness check of a workload
+type Probe struct {
+	Type Type `json:"type"`
+	// Port to connect to
+	Port uint16 `json:"port"`
+	// Path of the request, only used by http probes
+	Path string `json:"path,omitempty"`
+	// Interval in seconds between 2 checks
+	Interval uint `json:"interval,omitempty"`
+	// Timeout in seconds of a check
+	Timeout uint `json:"timeout,omitempty"`
+	// FailureThreshold is the number of consecutive failures after
+	// which a ready workload is considered not ready anymore
+	FailureThreshold uint `json:"failure_threshold,omitempty"`
+}
+
+// Valid checks that the probe is valid
+func (p *Probe) Valid() error {
+	switch p.Type {
+	case TCP, HTTP:
+	default:
+		return fmt.Errorf("unsupported probe type '%s'", p.Type)
+	}
+
+	if p.Port == 0 {
+		return fmt.Errorf("probe port is required")
+	}
+
+	return nil
+}
+
+func (p *Probe) interval() time.Duration {
+	if p.Interval == 0 {
+		return defaultInterval * time.Second
+	}
+	return time.Duration(p.Interval) * time.Second
+}
+
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout == 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+func (p *Probe) failureThreshold() uint {
+	if p.FailureThreshold == 0 {
+		return defaultFailureThreshold
+	}
+	return p.FailureThreshold
+}
+
+// Target is where the probes of a workload are executed
+type Target struct {
+	// Namespace is the name of the network namespace of the workload
+	Namespace string
+	// IP of the workload
+	IP net.IP
+}
+
+// Checker executes a single probe against a target
+type Checker func(ctx context.Context, target Target, probe Probe) error
+
+// BrokerCheck returns a Checker running the probes with broker. The probes
+// are executed from inside the network namespace of the target, which needs
+// CAP_SYS_ADMIN that provisiond doesn't have
+func BrokerCheck(broker pkg.Broker) Checker {
+	return func(ctx context.Context, target Target, probe Probe) error {
+		addr := net.JoinHostPort(target.IP.String(), strconv.Itoa(int(probe.Port)))
+		return broker.Probe(target.Namespace, string(probe.Type), addr, probe.Path, probe.timeout())
+	}
+}
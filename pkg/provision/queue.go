@@ -0,0 +1,393 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+)
+
+// Queue is a durable work queue of reservations. Every reservation received
+// from the source is written to disk before it is handed to the engine, and is only
+// removed once the engine is done with it. So reservations fetched before
+// the node lost its connection to the grid (or before a restart) are still
+// processed.
+type Queue struct {
+	root string
+
+	mu   sync.Mutex
+	seq  uint64
+	keys map[*Reservation]string
+}
+
+// NewQueue creates a queue that stores its entries under root
+func NewQueue(root string) (*Queue, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create queue directory '%s'", root)
+	}
+
+	q := &Queue{
+		root: root,
+		keys: make(map[*Reservation]string),
+	}
+
+	names, err := entries(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		q.seq = sequence(names[len(names)-1])
+	}
+
+	return q, nil
+}
+
+// Push stores the reservation at the end of the queue
+func (q *Queue) Push(r *Reservation) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	key := fmt.Sprintf("%020d-%s.json", q.seq, r.ID)
+	if err := writeEntry(filepath.Join(q.root, key), r); err != nil {
+		return errors.Wrapf(err, "failed to queue reservation %s", r.ID)
+	}
+
+	q.keys[r] = key
+	return nil
+}
+
+// Pending returns the reservations still in the queue in the order they
+// were pushed
+func (q *Queue) Pending() ([]*Reservation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	names, err := entries(q.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Reservation
+	for _, name := range names {
+		var r Reservation
+		if err := readEntry(filepath.Join(q.root, name), &r); err != nil {
+			log.Error().Err(err).Str("entry", name).Msg("dropping corrupted queue entry")
+			_ = os.Remove(filepath.Join(q.root, name))
+			continue
+		}
+
+		q.keys[&r] = name
+		pending = append(pending, &r)
+	}
+
+	return pending, nil
+}
+
+// Done removes the reservation from the queue. It is safe to call on
+// a nil Queue or with a reservation that was never queued
+func (q *Queue) Done(r *Reservation) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, ok := q.keys[r]
+	if !ok {
+		return nil
+	}
+	delete(q.keys, r)
+
+	if err := os.Remove(filepath.Join(q.root, key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove reservation %s from queue", r.ID)
+	}
+
+	return nil
+}
+
+// Source wraps source so that every reservation it produces goes through the
+// queue. The reservations left in the queue from a previous run are sent first
+func (q *Queue) Source(source ReservationSource) ReservationSource {
+	return &queuedSource{queue: q, source: source}
+}
+
+type queuedSource struct {
+	queue  *Queue
+	source ReservationSource
+}
+
+func (s *queuedSource) Reservations(ctx context.Context) <-chan *Reservation {
+	ch := make(chan *Reservation)
+
+	go func() {
+		defer close(ch)
+
+		pending, err := s.queue.Pending()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to load queued reservations")
+		}
+
+		if len(pending) > 0 {
+			log.Info().Int("count", len(pending)).Msg("replaying queued reservations")
+		}
+
+		for _, r := range pending {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- r:
+			}
+		}
+
+		for r := range s.source.Reservations(ctx) {
+			if err := s.queue.Push(r); err != nil {
+				// still process the reservation, it's just not durable
+				log.Error().Err(err).Str("id", r.ID).Msg("failed to persist reservation")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- r:
+			}
+		}
+	}()
+
+	return ch
+}
+
+// StoreAndForward is a Feedbacker that keeps the results it fails to send on
+// disk, and forwards them in order once the connection to the explorer is back
+type StoreAndForward struct {
+	feedback Feedbacker
+	root     string
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+type feedbackKind string
+
+const (
+	feedbackResult  feedbackKind = "result"
+	feedbackDeleted feedbackKind = "deleted"
+)
+
+type feedbackEntry struct {
+	Kind   feedbackKind `json:"kind"`
+	NodeID string       `json:"node_id"`
+	ID     string       `json:"id"`
+	Result *Result      `json:"result,omitempty"`
+}
+
+// NewStoreAndForward wraps feedback, root is where undelivered results are kept
+func NewStoreAndForward(feedback Feedbacker, root string) (*StoreAndForward, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create outbox directory '%s'", root)
+	}
+
+	s := &StoreAndForward{
+		feedback: feedback,
+		root:     root,
+	}
+
+	names, err := entries(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		s.seq = sequence(names[len(names)-1])
+	}
+
+	return s, nil
+}
+
+// Feedback implements Feedbacker
+func (s *StoreAndForward) Feedback(nodeID string, r *Result) error {
+	return s.send(feedbackEntry{Kind: feedbackResult, NodeID: nodeID, ID: r.ID, Result: r})
+}
+
+// Deleted implements Feedbacker
+func (s *StoreAndForward) Deleted(nodeID, id string) error {
+	return s.send(feedbackEntry{Kind: feedbackDeleted, NodeID: nodeID, ID: id})
+}
+
+// UpdateStats implements Feedbacker. Statistics are not stored since
+// only the latest values matter, and they are sent again after each reservation
+func (s *StoreAndForward) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return s.feedback.UpdateStats(nodeID, w, u)
+}
+
+// Pending returns the number of results waiting to be sent
+func (s *StoreAndForward) Pending() (int, error) {
+	names, err := entries(s.root)
+	return len(names), err
+}
+
+// send delivers the entry directly if nothing is waiting in the outbox,
+// otherwise it is stored behind the other entries to keep the order
+func (s *StoreAndForward) send(entry feedbackEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := entries(s.root)
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		err := s.deliver(entry)
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Str("id", entry.ID).Msg("failed to send result, storing it for later")
+	}
+
+	s.seq++
+	key := fmt.Sprintf("%020d-%s.json", s.seq, entry.ID)
+	if err := writeEntry(filepath.Join(s.root, key), entry); err != nil {
+		return errors.Wrapf(err, "failed to store result of reservation %s", entry.ID)
+	}
+
+	return nil
+}
+
+func (s *StoreAndForward) deliver(entry feedbackEntry) error {
+	switch entry.Kind {
+	case feedbackResult:
+		return s.feedback.Feedback(entry.NodeID, entry.Result)
+	case feedbackDeleted:
+		return s.feedback.Deleted(entry.NodeID, entry.ID)
+	default:
+		return fmt.Errorf("unknown feedback kind '%s'", entry.Kind)
+	}
+}
+
+// Flush tries to send all the stored results in order. It stops at the
+// first failure, so the remaining results are retried on the next call
+func (s *StoreAndForward) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := entries(s.root)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(s.root, name)
+
+		var entry feedbackEntry
+		if err := readEntry(path, &entry); err != nil {
+			log.Error().Err(err).Str("entry", name).Msg("dropping corrupted outbox entry")
+			_ = os.Remove(path)
+			continue
+		}
+
+		if err := s.deliver(entry); err != nil {
+			return errors.Wrapf(err, "failed to forward result of reservation %s", entry.ID)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "failed to remove forwarded result of reservation %s", entry.ID)
+		}
+
+		log.Info().Str("id", entry.ID).Str("kind", string(entry.Kind)).Msg("forwarded stored result")
+	}
+
+	return nil
+}
+
+// Run flushes the stored results every interval until ctx is canceled
+func (s *StoreAndForward) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if err := s.Flush(); err != nil {
+			log.Warn().Err(err).Msg("results are still waiting for the explorer")
+		}
+	}
+}
+
+// entries returns the name of the entries in root in the order they were written
+func entries(root string) ([]string, error) {
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list '%s'", root)
+	}
+
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		names = append(names, info.Name())
+	}
+
+	// names start with a zero padded sequence number
+	sort.Strings(names)
+	return names, nil
+}
+
+func sequence(name string) uint64 {
+	parts := strings.SplitN(name, "-", 2)
+	seq, _ := strconv.ParseUint(parts[0], 10, 64)
+	return seq
+}
+
+// writeEntry writes v to path atomically, so a crash never leaves
+// a half written entry behind
+func writeEntry(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func readEntry(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,125 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+)
+
+type sliceSource []*Reservation
+
+func (s sliceSource) Reservations(ctx context.Context) <-chan *Reservation {
+	ch := make(chan *Reservation)
+	go func() {
+		defer close(ch)
+		for _, r := range s {
+			ch <- r
+		}
+	}()
+	return ch
+}
+
+type testFeedback struct {
+	offline bool
+	sent    []string
+}
+
+func (f *testFeedback) Feedback(nodeID string, r *Result) error {
+	if f.offline {
+		return fmt.Errorf("no route to host")
+	}
+	f.sent = append(f.sent, "result:"+r.ID)
+	return nil
+}
+
+func (f *testFeedback) Deleted(nodeID, id string) error {
+	if f.offline {
+		return fmt.Errorf("no route to host")
+	}
+	f.sent = append(f.sent, "deleted:"+id)
+	return nil
+}
+
+func (f *testFeedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return nil
+}
+
+func TestQueueReplay(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "queue")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	queue, err := NewQueue(root)
+	require.NoError(err)
+
+	var received []*Reservation
+	for r := range queue.Source(sliceSource{{ID: "1-1"}, {ID: "2-1"}, {ID: "3-1"}}).Reservations(context.Background()) {
+		received = append(received, r)
+	}
+	require.Len(received, 3)
+
+	// only the first one is processed before the "restart"
+	require.NoError(queue.Done(received[0]))
+
+	queue, err = NewQueue(root)
+	require.NoError(err)
+
+	var replayed []string
+	for r := range queue.Source(sliceSource{{ID: "4-1"}}).Reservations(context.Background()) {
+		replayed = append(replayed, r.ID)
+		require.NoError(queue.Done(r))
+	}
+	require.Equal([]string{"2-1", "3-1", "4-1"}, replayed)
+
+	pending, err := queue.Pending()
+	require.NoError(err)
+	require.Empty(pending)
+}
+
+func TestQueueDoneNil(t *testing.T) {
+	var queue *Queue
+	require.NoError(t, queue.Done(&Reservation{ID: "1-1"}))
+}
+
+func TestStoreAndForward(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "outbox")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	remote := &testFeedback{offline: true}
+	feedback, err := NewStoreAndForward(remote, root)
+	require.NoError(err)
+
+	require.NoError(feedback.Feedback("node", &Result{ID: "1-1"}))
+	require.NoError(feedback.Deleted("node", "2-1"))
+
+	pending, err := feedback.Pending()
+	require.NoError(err)
+	require.Equal(2, pending)
+
+	require.Error(feedback.Flush())
+
+	remote.offline = false
+	// must be queued behind the stored results to keep the order
+	require.NoError(feedback.Feedback("node", &Result{ID: "3-1"}))
+	require.Empty(remote.sent)
+
+	require.NoError(feedback.Flush())
+	require.Equal([]string{"result:1-1", "deleted:2-1", "result:3-1"}, remote.sent)
+
+	pending, err = feedback.Pending()
+	require.NoError(err)
+	require.Equal(0, pending)
+
+	require.NoError(feedback.Feedback("node", &Result{ID: "4-1"}))
+	require.Equal("result:4-1", remote.sent[3])
+}
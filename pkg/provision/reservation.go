@@ -2,6 +2,7 @@ package provision
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -91,6 +92,14 @@ func (r *Reservation) SplitID() (reservation uint64, workload uint64, err error)
 	return
 }
 
+// RequestID identifies the requests made to the modules to provision this
+// version of the reservation. A retried provisioning has the same ID, a new
+// version of the reservation is signed again and gets a new one
+func (r *Reservation) RequestID() string {
+	sum := sha256.Sum256(r.Signature)
+	return fmt.Sprintf("%s:%x", r.ID, sum[:8])
+}
+
 // Expired returns a boolean depending if the reservation
 // has expire or not at the time of the function call
 func (r *Reservation) Expired() bool {
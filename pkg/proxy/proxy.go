@@ -0,0 +1,145 @@
+// Package proxy configures the proxy of the outbound traffic of the node,
+// for the farms where the nodes can only reach the internet through an
+// HTTP CONNECT or a SOCKS5 proxy.
+//
+// The proxy is installed in the default http transport of the modules, so
+// it's used by the download manager, the explorer client and everything
+// else using the default http client. It's also exported in the proxy
+// environment variables for the processes started by the modules.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+// the kernel parameters configuring the proxy
+const (
+	proxyParam  = "proxy"
+	bypassParam = "proxy-bypass"
+)
+
+// Config is the proxy configuration set by the farmer
+type Config struct {
+	// URL of the proxy, http://, https:// or socks5://, nil if the node
+	// doesn't use a proxy
+	URL *url.URL
+	// Bypass are the destinations reached without the proxy: a host, a
+	// domain and its subdomains as .domain, an ip or a network as a CIDR
+	Bypass []string
+}
+
+// ConfigFromParams reads the proxy configuration from the kernel
+// parameters. proxy=<url> sends the traffic through the proxy,
+// proxy-bypass=<host|.domain|ip|cidr> reaches a destination without the
+// proxy, it can be set more than once
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	var config Config
+
+	values, ok := params.Get(proxyParam)
+	if !ok || len(values) == 0 || values[0] == "" {
+		return config, nil
+	}
+
+	u, err := url.Parse(values[0])
+	if err != nil || len(u.Host) == 0 {
+		return config, fmt.Errorf("invalid proxy '%s'", values[0])
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return config, fmt.Errorf("invalid proxy '%s', only http, https and socks5 proxies are supported", values[0])
+	}
+
+	config.URL = u
+	config.Bypass, _ = params.Get(bypassParam)
+	for _, bypass := range config.Bypass {
+		if strings.Contains(bypass, "/") {
+			if _, _, err := net.ParseCIDR(bypass); err != nil {
+				return config, fmt.Errorf("invalid proxy bypass '%s'", bypass)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// Enabled returns true if the node uses a proxy
+func (c *Config) Enabled() bool {
+	return c.URL != nil
+}
+
+// bypassed returns true if host is reached without the proxy
+func (c *Config) bypassed(host string) bool {
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, bypass := range c.Bypass {
+		switch {
+		case strings.HasPrefix(bypass, "."):
+			if strings.HasSuffix(host, bypass) || host == bypass[1:] {
+				return true
+			}
+		case strings.Contains(bypass, "/"):
+			_, network, _ := net.ParseCIDR(bypass)
+			if ip != nil && network != nil && network.Contains(ip) {
+				return true
+			}
+		case host == bypass:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Proxy returns the proxy of the request, it's used as the Proxy of an
+// http.Transport
+func (c *Config) Proxy(request *http.Request) (*url.URL, error) {
+	if !c.Enabled() || c.bypassed(request.URL.Hostname()) {
+		return nil, nil
+	}
+
+	return c.URL, nil
+}
+
+// Install makes the default http transport and the processes started by
+// the module use the proxy
+func (c *Config) Install() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected default http transport")
+	}
+	transport.Proxy = c.Proxy
+
+	env := map[string]string{
+		"http_proxy":  c.URL.String(),
+		"https_proxy": c.URL.String(),
+		"all_proxy":   c.URL.String(),
+		"no_proxy":    strings.Join(append([]string{"localhost", "127.0.0.1"}, c.Bypass...), ","),
+	}
+
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+		if err := os.Setenv(strings.ToUpper(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestConfigFromParams(t *testing.T) {
+	require := require.New(t)
+
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(err)
+	require.False(config.Enabled())
+
+	config, err = ConfigFromParams(kernel.Params{
+		"proxy":        {"socks5://10.0.0.1:1080"},
+		"proxy-bypass": {".grid.tf", "10.0.0.0/8"},
+	})
+	require.NoError(err)
+	require.True(config.Enabled())
+	require.Equal("10.0.0.1:1080", config.URL.Host)
+	require.Equal([]string{".grid.tf", "10.0.0.0/8"}, config.Bypass)
+
+	_, err = ConfigFromParams(kernel.Params{"proxy": {"ftp://10.0.0.1"}})
+	require.Error(err)
+
+	_, err = ConfigFromParams(kernel.Params{"proxy": {"10.0.0.1:3128"}})
+	require.Error(err)
+
+	_, err = ConfigFromParams(kernel.Params{
+		"proxy":        {"http://10.0.0.1:3128"},
+		"proxy-bypass": {"10.0.0.0/33"},
+	})
+	require.Error(err)
+}
+
+func TestProxy(t *testing.T) {
+	config, err := ConfigFromParams(kernel.Params{
+		"proxy":        {"http://proxy:3128"},
+		"proxy-bypass": {".grid.tf", "10.0.0.0/8", "explorer.local"},
+	})
+	require.NoError(t, err)
+
+	cases := map[string]bool{
+		"https://hub.grid.tf/api":          false,
+		"https://grid.tf/":                 false,
+		"https://notgrid.tf/":              true,
+		"http://10.1.2.3:8080/":            false,
+		"http://11.1.2.3/":                 true,
+		"http://explorer.local/":           false,
+		"http://localhost:8080/":           false,
+		"http://127.0.0.1/":                false,
+		"https://github.com/threefoldtech": true,
+	}
+
+	for u, proxied := range cases {
+		request, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+
+		proxy, err := config.Proxy(request)
+		require.NoError(t, err)
+		if proxied {
+			require.Equal(t, config.URL, proxy, u)
+		} else {
+			require.Nil(t, proxy, u)
+		}
+	}
+}
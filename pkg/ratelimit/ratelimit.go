@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a caller exhausted its quota
+type ErrRateLimited struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e ErrRateLimited) Error() string {
+	return fmt.Sprintf("rate limit exceeded for '%s', retry in %s", e.Key, e.RetryAfter)
+}
+
+// IsRateLimited checks if err is an ErrRateLimited
+func IsRateLimited(err error) bool {
+	_, ok := err.(ErrRateLimited)
+	return ok
+}
+
+// bucket is a token bucket. It holds up to burst tokens and
+// is refilled at rate tokens per second
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limit is the rate and the burst of a bucket
+type limit struct {
+	rate  float64
+	burst float64
+}
+
+// Limiter keeps a token bucket per key (usually the caller identity)
+type Limiter struct {
+	limit
+	idle time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// limits of the keys that don't get the default limit
+	limits map[string]limit
+}
+
+// New creates a new limiter that allows rate operations per second per key
+// with bursts of up to burst operations
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		limit:   limit{rate: rate, burst: float64(burst)},
+		idle:    10 * time.Minute,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+		limits:  make(map[string]limit),
+	}
+}
+
+// Limit sets the rate and the burst of the bucket of key, usually lower than
+// the default ones for a key shared by many callers (the callers that can't
+// be identified). It returns l so it can be chained with New
+func (l *Limiter) Limit(key string, rate float64, burst int) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits[key] = limit{rate: rate, burst: float64(burst)}
+	delete(l.buckets, key)
+
+	return l
+}
+
+// Allow consumes a token from the bucket of key. If the bucket is empty
+// an ErrRateLimited is returned with the time to wait for the next token.
+// A nil Limiter allows everything
+func (l *Limiter) Allow(key string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.evict(now)
+
+	lim, ok := l.limits[key]
+	if !ok {
+		lim = l.limit
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: lim.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * lim.rate
+	if b.tokens > lim.burst {
+		b.tokens = lim.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / lim.rate * float64(time.Second))
+		return ErrRateLimited{Key: key, RetryAfter: wait}
+	}
+
+	b.tokens--
+	return nil
+}
+
+// evict drops the buckets that have been idle long enough to be full again
+// so the map doesn't grow forever with one shot callers
+func (l *Limiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(1, 2)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Allow("a"))
+	require.NoError(t, l.Allow("a"))
+
+	err := l.Allow("a")
+	require.Error(t, err)
+	assert.True(t, IsRateLimited(err))
+	assert.Equal(t, time.Second, err.(ErrRateLimited).RetryAfter)
+
+	// other callers are not affected
+	require.NoError(t, l.Allow("b"))
+
+	now = now.Add(time.Second)
+	require.NoError(t, l.Allow("a"))
+	require.Error(t, l.Allow("a"))
+}
+
+func TestLimiterEvict(t *testing.T) {
+	now := time.Now()
+	l := New(1, 1)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Allow("a"))
+	assert.Len(t, l.buckets, 1)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, l.Allow("b"))
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiterLimit(t *testing.T) {
+	now := time.Now()
+	l := New(1, 2).Limit("unknown", 0.5, 1)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Allow("unknown"))
+
+	err := l.Allow("unknown")
+	require.Error(t, err)
+	assert.Equal(t, 2*time.Second, err.(ErrRateLimited).RetryAfter)
+
+	// the other keys keep the default limit
+	require.NoError(t, l.Allow("a"))
+	require.NoError(t, l.Allow("a"))
+
+	now = now.Add(time.Second)
+	require.Error(t, l.Allow("unknown"))
+	now = now.Add(time.Second)
+	require.NoError(t, l.Allow("unknown"))
+}
@@ -0,0 +1,74 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Conn is an open session, reading from it returns the output of the session
+// and writing to it sends its input
+type Conn struct {
+	*tls.Conn
+	reader *bufio.Reader
+	// Session is the ID of the session
+	Session string
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Dial opens the session of the request on the node at address. The node
+// must prove it's request.NodeID, then the request is signed with sk for the
+// TLS channel
+func Dial(address string, request *Request, sk ed25519.PrivateKey) (*Conn, error) {
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, clientTLS(request.NodeID))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	if request.Channel, err = binding(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := request.Sign(sk); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to sign request")
+	}
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read response")
+	}
+	conn.SetDeadline(time.Time{})
+
+	var response Response
+	if err := json.Unmarshal(line, &response); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "invalid response")
+	}
+
+	if len(response.Error) != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("session refused: %s", response.Error)
+	}
+
+	return &Conn{Conn: conn, reader: reader, Session: response.Session}, nil
+}
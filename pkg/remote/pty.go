@@ -0,0 +1,41 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a new pseudo terminal, the shell runs on the slave side
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open pty master")
+	}
+
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	var unlock int32
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, master.Fd(), unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		return nil, nil, errors.Wrap(errno, "failed to unlock pty")
+	}
+
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get pty number")
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open pty slave")
+	}
+
+	return master, slave, nil
+}
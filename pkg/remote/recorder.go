@@ -0,0 +1,97 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// the streams of a recorded session
+const (
+	streamInput  = "i"
+	streamOutput = "o"
+)
+
+// Frame is a chunk of data sent in a session
+type Frame struct {
+	// Time is the offset of the frame from the start of the session
+	Time float64 `json:"time"`
+	// Stream is i for the input of the farmer and o for the output
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// recorder writes every byte of a session as json lines, the sha256 of the
+// recording is kept in the audit log so it can't be changed afterwards
+type recorder struct {
+	file  *os.File
+	hash  hash.Hash
+	enc   *json.Encoder
+	start time.Time
+
+	mu sync.Mutex
+}
+
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	return &recorder{
+		file:  file,
+		hash:  h,
+		enc:   json.NewEncoder(io.MultiWriter(file, h)),
+		start: time.Now(),
+	}, nil
+}
+
+func (r *recorder) record(stream string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enc.Encode(Frame{
+		Time:   time.Since(r.start).Seconds(),
+		Stream: stream,
+		Data:   string(data),
+	})
+}
+
+// Close closes the recording and returns its hex encoded sha256
+func (r *recorder) Close() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return hex.EncodeToString(r.hash.Sum(nil)), r.file.Close()
+}
+
+// recordWriter records everything written to w on stream. fail is called,
+// if set, once a write fails
+type recordWriter struct {
+	w      io.Writer
+	rec    *recorder
+	stream string
+	fail   func()
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	// the data is recorded before it's sent, a failure to record stops the
+	// session so nothing goes through unrecorded
+	err := w.rec.record(w.stream, p)
+	n := 0
+	if err == nil {
+		n, err = w.w.Write(p)
+	}
+
+	if err != nil && w.fail != nil {
+		w.fail()
+	}
+
+	return n, err
+}
@@ -0,0 +1,288 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbenet/go-base58"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testNode is the identity of a node
+type testNode struct {
+	sk ed25519.PrivateKey
+}
+
+func newTestNode(t *testing.T) *testNode {
+	_, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return &testNode{sk: sk}
+}
+
+func (n *testNode) ID() string {
+	return base58.Encode(n.sk.Public().(ed25519.PublicKey))
+}
+
+func (n *testNode) Sign(message []byte) ([]byte, error) {
+	return crypto.Sign(n.sk, message)
+}
+
+func testKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return hex.EncodeToString(pk), sk
+}
+
+func signed(t *testing.T, sk ed25519.PrivateKey, r Request) *Request {
+	require.NoError(t, r.Sign(sk))
+	return &r
+}
+
+func TestConfigFromParams(t *testing.T) {
+	key, _ := testKey(t)
+
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Empty(t, config.Keys)
+	assert.False(t, config.Shell)
+	assert.Equal(t, DefaultCommands, config.Commands)
+
+	config, err = ConfigFromParams(kernel.Params{"remote-key": {key}, "remote-shell": {}, "remote-command": {"ps", "dmesg"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, config.Keys)
+	assert.True(t, config.Shell)
+	assert.Equal(t, []string{"ps", "dmesg"}, config.Commands)
+
+	_, err = ConfigFromParams(kernel.Params{"remote-key": {"abcd"}})
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	key, sk := testKey(t)
+	_, other := testKey(t)
+
+	now := time.Now()
+	config := Config{Keys: []string{key}, Commands: []string{"ps"}}
+	request := Request{
+		NodeID:     "node",
+		Command:    []string{"ps", "aux"},
+		Expiration: now.Add(10 * time.Minute).Unix(),
+		Nonce:      "1",
+		Channel:    "abcd",
+	}
+
+	assert.NoError(t, config.verify(signed(t, sk, request), "node", "abcd", now))
+	assert.Error(t, config.verify(signed(t, other, request), "node", "abcd", now), "unknown key")
+	assert.Error(t, config.verify(signed(t, sk, request), "other", "abcd", now), "other node")
+	assert.Error(t, config.verify(signed(t, sk, request), "node", "ef01", now), "other channel")
+
+	tampered := signed(t, sk, request)
+	tampered.Command = []string{"ps", "-ef"}
+	assert.Error(t, config.verify(tampered, "node", "abcd", now), "tampered request")
+
+	relayed := signed(t, sk, request)
+	relayed.Channel = "ef01"
+	assert.Error(t, config.verify(relayed, "node", "ef01", now), "relayed request")
+
+	expired := request
+	expired.Expiration = now.Add(-time.Minute).Unix()
+	assert.Error(t, config.verify(signed(t, sk, expired), "node", "abcd", now))
+
+	long := request
+	long.Expiration = now.Add(2 * MaxDuration).Unix()
+	assert.Error(t, config.verify(signed(t, sk, long), "node", "abcd", now))
+
+	command := request
+	command.Command = []string{"rm", "-rf", "/"}
+	assert.Error(t, config.verify(signed(t, sk, command), "node", "abcd", now))
+
+	shell := request
+	shell.Command = nil
+	assert.Error(t, config.verify(signed(t, sk, shell), "node", "abcd", now))
+	config.Shell = true
+	assert.NoError(t, config.verify(signed(t, sk, shell), "node", "abcd", now))
+}
+
+func TestReadOnlyCommands(t *testing.T) {
+	key, sk := testKey(t)
+	now := time.Now()
+	config := Config{Keys: []string{key}, Commands: DefaultCommands}
+
+	cases := []struct {
+		command []string
+		allowed bool
+	}{
+		{[]string{"ip", "addr"}, true},
+		{[]string{"ip", "-6", "-br", "route", "show", "table", "all"}, true},
+		{[]string{"ip", "route", "get", "1.1.1.1"}, true},
+		{[]string{"ip"}, false},
+		{[]string{"ip", "netns", "exec", "ndmz", "sh"}, false},
+		{[]string{"ip", "-n", "ndmz", "addr"}, false},
+		{[]string{"ip", "-batch", "/tmp/commands"}, false},
+		{[]string{"ip", "link", "set", "zos", "down"}, false},
+		{[]string{"ip", "route", "flush", "all"}, false},
+		{[]string{"zinit", "list"}, true},
+		{[]string{"zinit", "status", "networkd"}, true},
+		{[]string{"zinit", "stop", "networkd"}, false},
+		{[]string{"zinit"}, false},
+		{[]string{"ps", "aux"}, true},
+		{[]string{"mount"}, true},
+		{[]string{"mount", "-l"}, true},
+		{[]string{"mount", "-o", "remount,rw", "/"}, false},
+		{[]string{"mount", "--bind", "/tmp", "/etc"}, false},
+		{[]string{"mount", "-l", "-t", "tmpfs"}, false},
+		{[]string{"ss", "-tnp"}, true},
+		{[]string{"ss", "-tan", "state", "established"}, true},
+		{[]string{"ss", "-K", "dst", "10.0.0.1"}, false},
+		{[]string{"ss", "-tK"}, false},
+		{[]string{"ss", "--kill"}, false},
+		{[]string{"ss", "-D", "/tmp/diag"}, false},
+		{[]string{"ss", "--diag=/tmp/diag"}, false},
+		{[]string{"dmesg"}, true},
+		{[]string{"dmesg", "-T", "--level", "err,warn"}, true},
+		{[]string{"dmesg", "-C"}, false},
+		{[]string{"dmesg", "-c"}, false},
+		{[]string{"dmesg", "-TD"}, false},
+		{[]string{"dmesg", "-E"}, false},
+		{[]string{"dmesg", "-n", "1"}, false},
+		{[]string{"dmesg", "--clear"}, false},
+		{[]string{"dmesg", "--console-level=1"}, false},
+	}
+
+	for _, c := range cases {
+		request := signed(t, sk, Request{
+			NodeID:     "node",
+			Command:    c.command,
+			Expiration: now.Add(time.Minute).Unix(),
+			Nonce:      "1",
+		})
+
+		err := config.verify(request, "node", "", now)
+		if c.allowed {
+			assert.NoError(t, err, "%v", c.command)
+		} else {
+			assert.Error(t, err, "%v", c.command)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	root, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	key, sk := testKey(t)
+	logger, err := audit.New(filepath.Join(root, "audit"), "remote", nil)
+	require.NoError(t, err)
+
+	node := newTestNode(t)
+	server, err := NewServer(node.ID(), Config{Keys: []string{key}, Commands: []string{"cat"}}, filepath.Join(root, "sessions"), logger, node)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	request := Request{
+		NodeID:     node.ID(),
+		Command:    []string{"cat"},
+		Expiration: time.Now().Add(time.Minute).Unix(),
+		Nonce:      "1",
+	}
+
+	conn, err := Dial(l.Addr().String(), &request, sk)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+
+	output, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(output))
+	conn.Close()
+
+	// a request signed for the first channel can't open a session on
+	// another one
+	replay, err := tls.Dial("tcp", l.Addr().String(), clientTLS(node.ID()))
+	require.NoError(t, err)
+	require.NoError(t, json.NewEncoder(replay).Encode(&request))
+	var response Response
+	require.NoError(t, json.NewDecoder(replay).Decode(&response))
+	assert.Equal(t, "request was signed for another channel", response.Error)
+	replay.Close()
+
+	// a request of an unknown key is not audited
+	_, other := testKey(t)
+	forged := Request{
+		NodeID:     node.ID(),
+		Command:    []string{"cat"},
+		Expiration: time.Now().Add(time.Minute).Unix(),
+		Nonce:      "3",
+	}
+	_, err = Dial(l.Addr().String(), &forged, other)
+	assert.Error(t, err)
+
+	// the node must prove its identity
+	_, err = Dial(l.Addr().String(), &Request{
+		NodeID:     newTestNode(t).ID(),
+		Command:    []string{"cat"},
+		Expiration: time.Now().Add(time.Minute).Unix(),
+		Nonce:      "2",
+	}, sk)
+	assert.Error(t, err)
+
+	file, err := os.Open(filepath.Join(root, "sessions", conn.Session+".log"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	var frames []Frame
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var frame Frame
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &frame))
+		frames = append(frames, frame)
+	}
+
+	require.Len(t, frames, 2)
+	assert.Equal(t, Frame{Time: frames[0].Time, Stream: streamInput, Data: "hello\n"}, frames[0])
+	assert.Equal(t, Frame{Time: frames[1].Time, Stream: streamOutput, Data: "hello\n"}, frames[1])
+
+	// the audit log is written when the session is closed on the node
+	var entries []pkg.AuditEntry
+	for i := 0; i < 100 && len(entries) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		entries, err = audit.NewReader(filepath.Join(root, "audit"), nil).Query(pkg.AuditFilter{Object: conn.Session})
+		require.NoError(t, err)
+	}
+	require.Len(t, entries, 3)
+
+	operations := make(map[string]bool)
+	for _, entry := range entries {
+		assert.Equal(t, key, entry.Caller)
+		operations[entry.Operation] = true
+	}
+	assert.Equal(t, map[string]bool{"session.open": true, "session.close": true, "session.denied": true}, operations)
+
+	entries, err = audit.NewReader(filepath.Join(root, "audit"), nil).Query(pkg.AuditFilter{Object: sessionID(&forged)})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,283 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"golang.org/x/crypto/ed25519"
+)
+
+// the kernel parameters configuring the remote access
+const (
+	keyParam      = "remote-key"
+	shellParam    = "remote-shell"
+	commandsParam = "remote-command"
+)
+
+// MaxDuration is the longest session a request can open
+const MaxDuration = time.Hour
+
+// DefaultCommands are the diagnostic commands a farmer can run when the
+// full shell is not enabled
+var DefaultCommands = []string{
+	"df", "dmesg", "free", "ip", "ls", "lsblk", "mount",
+	"ps", "ss", "uptime", "zinit",
+}
+
+// readOnly restricts the commands that can change the node to the
+// subcommands that only show its state. ip netns exec would open a shell,
+// zinit would stop the services of the node, mount would remount its file
+// systems, ss -K would kill its sockets and dmesg -C would clear the kernel
+// log the session must leave intact
+var readOnly = map[string]func(args []string) bool{
+	"dmesg": dmesgReadOnly,
+	"ip":    ipReadOnly,
+	"mount": mountReadOnly,
+	"ss":    ssReadOnly,
+	"zinit": zinitReadOnly,
+}
+
+// the options of ip that don't change what it runs
+var ipOptions = []string{
+	"-4", "-6", "-s", "-stats", "-statistics", "-d", "-details",
+	"-br", "-brief", "-j", "-json", "-p", "-pretty", "-o", "-oneline", "-c", "-color",
+}
+
+// the objects of ip that can be shown, and the verbs that show them
+var (
+	ipObjects = []string{
+		"a", "addr", "address", "l", "link", "r", "route", "n", "neigh",
+		"neighbor", "neighbour", "ru", "rule", "m", "maddr", "maddress",
+	}
+	ipVerbs = []string{"show", "list", "lst", "ls", "get"}
+)
+
+func ipReadOnly(args []string) bool {
+	for len(args) > 0 && isIn(args[0], ipOptions) {
+		args = args[1:]
+	}
+
+	if len(args) == 0 || !isIn(args[0], ipObjects) {
+		return false
+	}
+
+	// the object alone shows it
+	return len(args) == 1 || isIn(args[1], ipVerbs)
+}
+
+func zinitReadOnly(args []string) bool {
+	return len(args) > 0 && isIn(args[0], []string{"list", "status", "log"})
+}
+
+// hasOption checks if args has one of the short options of short, alone or
+// grouped (-tK), or one of the long options, alone or with a value
+// (--level=1)
+func hasOption(args []string, short string, long []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			name := strings.SplitN(arg, "=", 2)[0]
+			if isIn(name, long) {
+				return true
+			}
+		} else if strings.HasPrefix(arg, "-") && strings.ContainsAny(arg[1:], short) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mountReadOnly only allows to list the mounts
+func mountReadOnly(args []string) bool {
+	return len(args) == 0 || (len(args) == 1 && args[0] == "-l")
+}
+
+// ssReadOnly refuses to kill the sockets or to write the diagnostics to a
+// file
+func ssReadOnly(args []string) bool {
+	return !hasOption(args, "KD", []string{"--kill", "--diag"})
+}
+
+// dmesgReadOnly refuses to clear the kernel log or to change what it prints
+// on the console
+func dmesgReadOnly(args []string) bool {
+	return !hasOption(args, "CcDEn", []string{
+		"--clear", "--read-clear", "--console-off", "--console-on", "--console-level",
+	})
+}
+
+// Config is the remote access configuration set by the farmer
+type Config struct {
+	// Keys are the hex encoded ed25519 public keys of the farmer allowed
+	// to open a session, the remote access is disabled if empty
+	Keys []string
+	// Shell allows to open an interactive shell, otherwise only Commands
+	// can be run
+	Shell bool
+	// Commands are the programs a session can run
+	Commands []string
+}
+
+// ConfigFromParams reads the remote access configuration from the kernel
+// parameters. remote-key=<hex key> is set once per farmer key allowed to open
+// a session, remote-shell allows an interactive shell and remote-command=<name>
+// replaces the default set of commands, it can be set more than once
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	config := Config{
+		Shell:    params.Exists(shellParam),
+		Commands: DefaultCommands,
+	}
+
+	keys, _ := params.Get(keyParam)
+	for _, key := range keys {
+		if _, err := crypto.KeyFromHex(key); err != nil {
+			return config, errors.Wrapf(err, "invalid remote key '%s'", key)
+		}
+		config.Keys = append(config.Keys, key)
+	}
+
+	if commands, ok := params.Get(commandsParam); ok && len(commands) > 0 {
+		config.Commands = commands
+	}
+
+	return config, nil
+}
+
+// Request opens a session on a node, it's signed by a key of the farmer
+type Request struct {
+	// NodeID is the node the session is opened on
+	NodeID string `json:"node_id"`
+	// Key is the hex encoded public key of the farmer signing the request
+	Key string `json:"key"`
+	// Command is run by the session, an empty command opens a shell
+	Command []string `json:"command,omitempty"`
+	// Expiration is the unix time the session is closed at
+	Expiration int64 `json:"expiration"`
+	// Nonce makes each request unique, so it can't be replayed
+	Nonce string `json:"nonce"`
+	// Channel is the hex encoded keying material of the TLS channel the
+	// request is sent on, the request can't be used on another channel
+	Channel string `json:"channel"`
+	// Signature is the hex encoded signature of the request
+	Signature string `json:"signature"`
+}
+
+// Bytes returns the bytes of the request covered by the signature
+func (r *Request) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(r.NodeID)
+	buf.WriteByte('|')
+	buf.WriteString(r.Key)
+	buf.WriteByte('|')
+	buf.WriteString(strings.Join(r.Command, "\x00"))
+	buf.WriteByte('|')
+	buf.WriteString(strconv.FormatInt(r.Expiration, 10))
+	buf.WriteByte('|')
+	buf.WriteString(r.Nonce)
+	buf.WriteByte('|')
+	buf.WriteString(r.Channel)
+
+	return buf.Bytes()
+}
+
+// Sign sets the key and the signature of the request, Dial signs the
+// request for the channel it opens
+func (r *Request) Sign(sk ed25519.PrivateKey) error {
+	r.Key = hex.EncodeToString(sk.Public().(ed25519.PublicKey))
+	sig, err := crypto.Sign(sk, r.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// Shell checks if the request opens an interactive shell
+func (r *Request) Shell() bool {
+	return len(r.Command) == 0
+}
+
+// Expires returns the time the session is closed at
+func (r *Request) Expires() time.Time {
+	return time.Unix(r.Expiration, 0)
+}
+
+// unauthenticated is the error of a request that is not signed by one of
+// the allowed keys. Anyone who can reach the node can send one, so it's
+// not worth an audit entry
+type unauthenticated struct {
+	error
+}
+
+// verify checks the request is signed by a key of the config for the TLS
+// channel it was received on, and allowed to open a session on the node at
+// now
+func (c *Config) verify(r *Request, nodeID, channel string, now time.Time) error {
+	if !isIn(r.Key, c.Keys) {
+		return unauthenticated{fmt.Errorf("key '%s' is not allowed to open a session", r.Key)}
+	}
+
+	key, err := crypto.KeyFromHex(r.Key)
+	if err != nil {
+		return unauthenticated{errors.Wrap(err, "invalid key")}
+	}
+
+	sig, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return unauthenticated{errors.Wrap(err, "invalid signature encoding")}
+	}
+
+	if err := crypto.Verify(key, r.Bytes(), sig); err != nil {
+		return unauthenticated{err}
+	}
+
+	if r.NodeID != nodeID {
+		return fmt.Errorf("request is for node '%s'", r.NodeID)
+	}
+
+	if !sameBinding(r.Channel, channel) {
+		return fmt.Errorf("request was signed for another channel")
+	}
+
+	if len(r.Nonce) == 0 {
+		return fmt.Errorf("request has no nonce")
+	}
+
+	expires := r.Expires()
+	if !expires.After(now) {
+		return fmt.Errorf("request expired at %s", expires.Format(time.RFC3339))
+	}
+
+	if expires.Sub(now) > MaxDuration {
+		return fmt.Errorf("session can't last more than %s", MaxDuration)
+	}
+
+	if r.Shell() {
+		if !c.Shell {
+			return fmt.Errorf("shell is not enabled on this node")
+		}
+	} else if !isIn(r.Command[0], c.Commands) {
+		return fmt.Errorf("command '%s' is not allowed", r.Command[0])
+	} else if check, ok := readOnly[r.Command[0]]; ok && !check(r.Command[1:]) {
+		return fmt.Errorf("only the read-only subcommands of '%s' are allowed", r.Command[0])
+	}
+
+	return nil
+}
+
+func isIn(s string, l []string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
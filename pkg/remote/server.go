@@ -0,0 +1,285 @@
+// Package remote implements the remote access of a farmer to its nodes.
+//
+// A session is opened with a request signed by one of the keys the farmer set
+// in the kernel parameters. It runs a shell, or a command of a restricted set,
+// until the expiration of the request. Every byte sent and received in the
+// session is recorded and the sha256 of the recording is kept in the audit
+// log of the node.
+//
+// The sessions run over TLS, with a certificate signed by the identity of the
+// node. The protocol is a json line with the Request sent by the client and
+// signed for the TLS channel, a json line with the Response sent by the node,
+// then the raw input and output of the session until it ends.
+package remote
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/audit"
+)
+
+const (
+	// DefaultPort is the port remoted listens on
+	DefaultPort = 8071
+	// DefaultRecordings is the directory of the session recordings, it's
+	// next to the audit logs so they are kept together
+	DefaultRecordings = "/var/cache/modules/audit/sessions"
+
+	// requestTimeout is the time a client has to send its request
+	requestTimeout = 10 * time.Second
+	// maxRequest is the max size of a request
+	maxRequest = 64 * 1024
+)
+
+// Response is the answer of the node to a request
+type Response struct {
+	// Session is the ID of the session, it's the name of its recording
+	Session string `json:"session,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// closed is recorded in the audit log when a session ends
+type closed struct {
+	Recording string `json:"recording"`
+	SHA256    string `json:"sha256"`
+}
+
+// Server opens the sessions requested by the farmer
+type Server struct {
+	nodeID     string
+	config     Config
+	recordings string
+	audit      *audit.Logger
+	tls        *tls.Config
+	shell      string
+	now        func() time.Time
+}
+
+// NewServer creates a server for the node nodeID, the sessions are recorded
+// in the recordings directory. signer signs the TLS certificate with the
+// identity of the node. audit can be nil
+func NewServer(nodeID string, config Config, recordings string, audit *audit.Logger, signer Signer) (*Server, error) {
+	if err := os.MkdirAll(recordings, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create recordings directory")
+	}
+
+	cert, err := certificate(nodeID, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		nodeID:     nodeID,
+		config:     config,
+		recordings: recordings,
+		audit:      audit,
+		tls:        serverTLS(cert),
+		shell:      "/bin/sh",
+		now:        time.Now,
+	}, nil
+}
+
+// sessionID is derived from the signature, the recording of a session is
+// created once so a request can't be replayed
+func sessionID(r *Request) string {
+	sum := sha256.Sum256([]byte(r.Signature))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Serve accepts sessions on l until ctx is canceled, the connections are
+// wrapped in TLS
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	l = tls.NewListener(l, s.tls)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go s.handle(ctx, conn)
+	}
+}
+
+func respond(conn net.Conn, response Response) error {
+	return json.NewEncoder(conn).Encode(response)
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(s.now().Add(requestTimeout))
+	channel, err := binding(conn.(*tls.Conn))
+	if err != nil {
+		log.Debug().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("failed to open session channel")
+		return
+	}
+
+	reader := bufio.NewReaderSize(conn, maxRequest)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		log.Debug().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("failed to read session request")
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var request Request
+	if err := json.Unmarshal(line, &request); err != nil {
+		respond(conn, Response{Error: "invalid request"})
+		return
+	}
+
+	id := sessionID(&request)
+	if err := s.config.verify(&request, s.nodeID, channel, s.now()); err != nil {
+		log.Warn().Err(err).Str("key", request.Key).Str("remote", conn.RemoteAddr().String()).Msg("session denied")
+		// only the requests signed by an allowed key are audited, the
+		// others can be sent by anyone and would flood the audit log
+		if _, ok := err.(unauthenticated); !ok {
+			s.audit.Record("session.denied", request.Key, id, &request, err)
+		}
+		respond(conn, Response{Error: err.Error()})
+		return
+	}
+
+	path := filepath.Join(s.recordings, id+".log")
+	rec, err := newRecorder(path)
+	if os.IsExist(err) {
+		err = fmt.Errorf("request was already used")
+		s.audit.Record("session.denied", request.Key, id, &request, err)
+		respond(conn, Response{Error: err.Error()})
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to create session recording")
+		respond(conn, Response{Error: "failed to record session"})
+		return
+	}
+
+	log.Info().
+		Str("session", id).
+		Str("key", request.Key).
+		Strs("command", request.Command).
+		Time("expires", request.Expires()).
+		Msg("session opened")
+	s.audit.Record("session.open", request.Key, id, &request, nil)
+
+	if err = respond(conn, Response{Session: id}); err == nil {
+		err = s.run(ctx, &request, rec, reader, conn)
+	}
+
+	s.close(id, &request, rec, path, err)
+}
+
+func (s *Server) close(id string, request *Request, rec *recorder, path string, runErr error) {
+	sum, err := rec.Close()
+	if err != nil {
+		log.Error().Err(err).Str("session", id).Msg("failed to close session recording")
+	}
+
+	log.Info().Str("session", id).Str("sha256", sum).Err(runErr).Msg("session closed")
+	s.audit.Record("session.close", request.Key, id, closed{Recording: path, SHA256: sum}, runErr)
+}
+
+// run runs the session until it ends or expires, the input is read from
+// input and the output is written to conn
+func (s *Server) run(ctx context.Context, request *Request, rec *recorder, input io.Reader, conn net.Conn) error {
+	ctx, cancel := context.WithDeadline(ctx, request.Expires())
+	defer cancel()
+
+	go func() {
+		// unblocks the copies when the session expires
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// the session is stopped once the farmer can't receive its output
+	output := &recordWriter{w: conn, rec: rec, stream: streamOutput, fail: cancel}
+
+	if request.Shell() {
+		return s.runShell(ctx, rec, input, output)
+	}
+
+	cmd := exec.CommandContext(ctx, request.Command[0], request.Command[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		io.Copy(&recordWriter{w: stdin, rec: rec, stream: streamInput}, input)
+	}()
+
+	return cmd.Wait()
+}
+
+func (s *Server) runShell(ctx context.Context, rec *recorder, input io.Reader, output io.Writer) error {
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	cmd := exec.CommandContext(ctx, s.shell, "-l")
+	cmd.Env = append(os.Environ(), "TERM=xterm")
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(&recordWriter{w: master, rec: rec, stream: streamInput}, input)
+		// the farmer is gone, closing the terminal hangs the shell up
+		master.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(output, master)
+	}()
+
+	err = cmd.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		// a process started by the shell still holds the terminal
+	}
+
+	return err
+}
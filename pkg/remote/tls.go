@@ -0,0 +1,173 @@
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"golang.org/x/crypto/ed25519"
+)
+
+// the sessions run over TLS 1.3. The node serves a certificate of a key
+// generated when remoted starts, the key is signed by the identity of the
+// node so the client knows it talks to the node and not to someone on the
+// path. The request of the session is bound to the TLS channel: it's signed
+// with the keying material exported from the channel, a request can't be
+// relayed to the node over another channel
+const (
+	// exporterLabel is the label of the keying material of the channel
+	exporterLabel = "EXPORTER-zos-remote-session"
+	// bindingLength is the length of the keying material of the channel
+	bindingLength = 32
+)
+
+// oidNodeSignature is the extension of the certificate with the signature of
+// its public key by the identity of the node
+var oidNodeSignature = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 54329, 1, 1}
+
+// certificateMessage is the message signed by the identity of the node for
+// the key pk of its certificate, it's prefixed so the signature can't be
+// used for anything else
+func certificateMessage(pk ed25519.PublicKey) []byte {
+	return append([]byte("zos remote certificate:"), pk...)
+}
+
+// Signer signs with the identity of the node
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// certificate generates the TLS certificate of the node nodeID, its key is
+// signed by signer
+func certificate(nodeID string, signer Signer) (tls.Certificate, error) {
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	signature, err := signer.Sign(certificateMessage(pk))
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to sign certificate key with node identity")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidNodeSignature, Value: signature},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, pk, sk)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to create certificate")
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: sk}, nil
+}
+
+// verifyNode checks that the certificate is signed by the identity of the
+// node nodeID
+func verifyNode(nodeID string, der []byte) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.Wrap(err, "invalid node certificate")
+	}
+
+	pk, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("node certificate key is not an ed25519 key")
+	}
+
+	// the certificate is self signed, it's only trusted for the signature
+	// of its key by the node
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return errors.Wrap(err, "invalid node certificate signature")
+	}
+
+	var signature []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidNodeSignature) {
+			signature = ext.Value
+		}
+	}
+	if signature == nil {
+		return fmt.Errorf("node certificate is not signed by the node identity")
+	}
+
+	nodeKey, err := crypto.KeyFromID(pkg.StrIdentifier(nodeID))
+	if err != nil {
+		return errors.Wrapf(err, "invalid node id '%s'", nodeID)
+	}
+
+	if err := crypto.Verify(nodeKey, certificateMessage(pk), signature); err != nil {
+		return errors.Wrapf(err, "node certificate is not signed by node '%s'", nodeID)
+	}
+
+	return nil
+}
+
+// serverTLS is the TLS configuration of the node
+func serverTLS(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	}
+}
+
+// clientTLS is the TLS configuration of a client of the node nodeID
+func clientTLS(nodeID string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		// the certificate is not signed by a CA, it's verified against the
+		// identity of the node instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return fmt.Errorf("node sent no certificate")
+			}
+			return verifyNode(nodeID, raw[0])
+		},
+	}
+}
+
+// binding returns the hex encoded keying material of the TLS channel conn,
+// the request of the session signs it
+func binding(conn *tls.Conn) (string, error) {
+	if err := conn.Handshake(); err != nil {
+		return "", errors.Wrap(err, "tls handshake failed")
+	}
+
+	state := conn.ConnectionState()
+	material, err := state.ExportKeyingMaterial(exporterLabel, nil, bindingLength)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to export channel keying material")
+	}
+
+	return hex.EncodeToString(material), nil
+}
+
+// sameBinding compares the bindings in constant time
+func sameBinding(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,310 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
+)
+
+// kernelModules are the kernel modules the modules can ask the broker to load
+var kernelModules = map[string]struct{}{
+	"zram": {},
+}
+
+// mountRoots are the file systems the modules can ask the broker to mount,
+// with the directory their mount points must be in
+var mountRoots = map[string]string{
+	// the secrets of the containers (provisiond)
+	"tmpfs": "/var/run/secrets",
+}
+
+// mountFlags are the mount options the broker accepts, nosuid and nodev are
+// always set
+var mountFlags = map[string]uintptr{
+	"ro":     syscall.MS_RDONLY,
+	"nosuid": syscall.MS_NOSUID,
+	"nodev":  syscall.MS_NODEV,
+	"noexec": syscall.MS_NOEXEC,
+}
+
+// maxProbeTimeout bounds the time a probe can keep a broker worker busy
+const maxProbeTimeout = 30 * time.Second
+
+var (
+	paramRegex     = regexp.MustCompile(`^[a-z0-9_]+=[a-zA-Z0-9_.,-]+$`)
+	mountDataRegex = regexp.MustCompile(`^(size=[0-9]+[kmg]?|mode=[0-7]{3,4}|uid=[0-9]{1,10}|gid=[0-9]{1,10})$`)
+	nameRegex      = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	pathRegex      = regexp.MustCompile(`^/[a-zA-Z0-9_./-]*$`)
+)
+
+// Broker runs the privileged operations for the sandboxed modules, it
+// runs with all the capabilities so it only accepts the operations the
+// modules are known to need
+type Broker struct{}
+
+var _ pkg.Broker = (*Broker)(nil)
+
+// NewBroker creates a new broker
+func NewBroker() *Broker {
+	return &Broker{}
+}
+
+func validateKernelModule(name string, params []string) error {
+	if _, ok := kernelModules[name]; !ok {
+		return fmt.Errorf("loading kernel module '%s' is not allowed", name)
+	}
+
+	for _, param := range params {
+		if !paramRegex.MatchString(param) {
+			return fmt.Errorf("invalid kernel module parameter '%s'", param)
+		}
+	}
+
+	return nil
+}
+
+// LoadKernelModule implements pkg.Broker
+func (b *Broker) LoadKernelModule(name string, params []string) error {
+	if err := validateKernelModule(name, params); err != nil {
+		return err
+	}
+
+	args := append([]string{name}, params...)
+	if output, err := exec.Command("modprobe", args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to load kernel module %s: %s", name, string(output))
+	}
+
+	log.Info().Str("module", name).Strs("params", params).Msg("kernel module loaded")
+	return nil
+}
+
+// validateMountPoint checks that target is a directory right under root.
+// The modules own the directories they mount in, so the links are
+// resolved and refused
+func validateMountPoint(root, target string) error {
+	if target != filepath.Clean(target) || filepath.Dir(target) != root {
+		return fmt.Errorf("mount point '%s' is not allowed", target)
+	}
+
+	if !nameRegex.MatchString(filepath.Base(target)) || strings.Trim(filepath.Base(target), ".") == "" {
+		return fmt.Errorf("invalid mount point '%s'", target)
+	}
+
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return err
+	}
+	if resolved != target {
+		return fmt.Errorf("mount point '%s' is a link", target)
+	}
+
+	return nil
+}
+
+func validateMount(source, target, fstype string, options []string) (uintptr, string, error) {
+	root, ok := mountRoots[fstype]
+	if !ok {
+		return 0, "", fmt.Errorf("mounting '%s' is not allowed", fstype)
+	}
+
+	// only the pseudo file systems can be mounted
+	if source != fstype {
+		return 0, "", fmt.Errorf("invalid source '%s' for %s", source, fstype)
+	}
+
+	if err := validateMountPoint(root, target); err != nil {
+		return 0, "", err
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	var data []string
+	for _, option := range options {
+		if flag, ok := mountFlags[option]; ok {
+			flags |= flag
+		} else if mountDataRegex.MatchString(option) {
+			data = append(data, option)
+		} else {
+			return 0, "", fmt.Errorf("mount option '%s' is not allowed", option)
+		}
+	}
+
+	return flags, strings.Join(data, ","), nil
+}
+
+// Mount implements pkg.Broker
+func (b *Broker) Mount(source, target, fstype string, options []string) error {
+	flags, data, err := validateMount(source, target, fstype, options)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(source, target, fstype, flags, data); err != nil {
+		return errors.Wrapf(err, "failed to mount %s on %s", fstype, target)
+	}
+
+	log.Info().Str("target", target).Str("type", fstype).Strs("options", options).Msg("mounted")
+	return nil
+}
+
+func validateUnmount(target string) error {
+	for _, root := range mountRoots {
+		if filepath.Dir(target) == root {
+			return validateMountPoint(root, target)
+		}
+	}
+
+	return fmt.Errorf("unmounting '%s' is not allowed", target)
+}
+
+// Unmount implements pkg.Broker
+func (b *Broker) Unmount(target string) error {
+	if err := validateUnmount(target); err != nil {
+		return err
+	}
+
+	if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil {
+		return errors.Wrapf(err, "failed to unmount %s", target)
+	}
+
+	log.Info().Str("target", target).Msg("unmounted")
+	return nil
+}
+
+func validateProbe(netns, kind, address, path string, timeout time.Duration) error {
+	if !nameRegex.MatchString(netns) || strings.Trim(netns, ".") == "" {
+		return fmt.Errorf("invalid network namespace '%s'", netns)
+	}
+
+	switch kind {
+	case "tcp", "http":
+	default:
+		return fmt.Errorf("unsupported probe type '%s'", kind)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid probe address '%s'", address)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid probe port '%s'", port)
+	}
+
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid probe path '%s'", path)
+	}
+
+	if timeout <= 0 || timeout > maxProbeTimeout {
+		return fmt.Errorf("probe timeout must be between 0 and %s", maxProbeTimeout)
+	}
+
+	return nil
+}
+
+// Probe implements pkg.Broker
+func (b *Broker) Probe(netns, kind, address, path string, timeout time.Duration) error {
+	if err := validateProbe(netns, kind, address, path, timeout); err != nil {
+		return err
+	}
+
+	netNS, err := namespace.GetByName(netns)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get network namespace '%s'", netns)
+	}
+	defer netNS.Close()
+
+	dial := func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		// the socket is created inside the namespace, it then
+		// can be used from any thread
+		err = netNS.Do(func(_ ns.NetNS) error {
+			var d net.Dialer
+			conn, err = d.DialContext(ctx, network, addr)
+			return err
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if kind == "tcp" {
+		conn, err := dial(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+		},
+		// probes should check the workload itself, not where it redirects to
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", address, path), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// ConfigureCoreDumps implements pkg.Broker. The kernel waits for contd to be
+// done with a dump before reaping the process, so the core is there when the
+// exit of the container is processed
+func (b *Broker) ConfigureCoreDumps(root string) error {
+	// the pattern is split on spaces by the kernel
+	if root != filepath.Clean(root) || !pathRegex.MatchString(root) {
+		return fmt.Errorf("invalid core dumps root '%s'", root)
+	}
+
+	// the kernel runs the handler with all the capabilities, it's never
+	// taken from the caller
+	contd, err := exec.LookPath("contd")
+	if err != nil {
+		return err
+	}
+
+	pattern := fmt.Sprintf("|%s -root %s -coredump %%P", contd, root)
+	if err := ioutil.WriteFile("/proc/sys/kernel/core_pattern", []byte(pattern), 0644); err != nil {
+		return errors.Wrap(err, "failed to set core pattern")
+	}
+
+	if err := ioutil.WriteFile("/proc/sys/kernel/core_pipe_limit", []byte("16"), 0644); err != nil {
+		return errors.Wrap(err, "failed to set core pipe limit")
+	}
+
+	log.Info().Str("pattern", pattern).Msg("core dumps handed to contd")
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package sandbox drops the capabilities of the 0-OS modules. Every module
+// only keeps the capabilities it needs, so a compromised module can't take
+// over the parts of the node it doesn't manage. The few privileged
+// operations a module needs once in a while are done by the broker
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/syndtr/gocapability/capability"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+const (
+	// envSandbox is set to the module name once the module runs sandboxed
+	envSandbox = "ZOS_SANDBOX"
+	// disableParam is the kernel parameter that disables the sandboxing
+	disableParam = "nosandbox"
+)
+
+// fs are the capabilities needed to manage files owned by other users
+var fs = []capability.Cap{
+	capability.CAP_CHOWN,
+	capability.CAP_DAC_OVERRIDE,
+	capability.CAP_DAC_READ_SEARCH,
+	capability.CAP_FOWNER,
+	capability.CAP_FSETID,
+}
+
+// Profiles are the capabilities kept by the modules, by zbus module name.
+// The capabilities are also the only ones the processes started by the
+// module can have. The modules run as root and only manage files owned by
+// root, they don't need to bypass the permissions of the files. The mounts
+// and namespace operations a module only needs once in a while are done by
+// the broker (see pkg.Broker)
+var Profiles = map[string][]capability.Cap{
+	// mounts, btrfs, swap, encrypted and loop devices. The usage of the
+	// volumes is computed by walking files owned by the workloads
+	"storage": {
+		capability.CAP_SYS_ADMIN,
+		capability.CAP_MKNOD,
+		capability.CAP_SYS_RAWIO,
+		capability.CAP_SYS_RESOURCE,
+		capability.CAP_DAC_READ_SEARCH,
+	},
+	// 0-fs fuse mounts. The 0-fs processes write the files of the workloads
+	// in the read-write volumes, with their owners and modes
+	"flist": append([]capability.Cap{capability.CAP_SYS_ADMIN, capability.CAP_MKNOD}, fs...),
+	// links, routes, firewall and namespaces, dhcp clients
+	"network": {
+		capability.CAP_NET_ADMIN,
+		capability.CAP_NET_RAW,
+		capability.CAP_NET_BIND_SERVICE,
+		capability.CAP_SYS_ADMIN,
+		capability.CAP_SYS_PTRACE,
+		capability.CAP_KILL,
+	},
+	// containerd runs the containers, the liveness checks and the core
+	// dumps setup are done by the broker
+	"container": {},
+	// the firecracker jailer creates the mount namespace and the device
+	// nodes of the machines, and sets their resource limits
+	"vmd": {capability.CAP_SYS_ADMIN, capability.CAP_MKNOD, capability.CAP_SYS_RESOURCE},
+	// hardware inventory: dmi tables and smart data, reading the smart
+	// data of the nvme disks is an admin command
+	"monitor": {capability.CAP_SYS_ADMIN, capability.CAP_SYS_RAWIO},
+	// the secrets mounts and the readiness probes are done by the broker
+	"provision": {},
+}
+
+// Enter drops the capabilities of the module to its profile. Capabilities
+// are per thread, so the bounding set is reduced on a single thread that
+// then executes the module again, the new process only gets the capabilities
+// of the profile. Enter returns once the module runs sandboxed. It must be
+// called early, before the module starts any work. The sandboxing is
+// disabled with the nosandbox kernel parameter
+func Enter(module string) error {
+	if os.Getenv(envSandbox) == module {
+		log.Info().Str("module", module).Msg("running sandboxed")
+		return nil
+	}
+
+	if kernel.GetParams().Exists(disableParam) {
+		log.Warn().Str("module", module).Msg("sandboxing disabled")
+		return nil
+	}
+
+	profile, ok := Profiles[module]
+	if !ok {
+		return fmt.Errorf("no capabilities profile for module '%s'", module)
+	}
+
+	// the thread is never unlocked, if the module can't run again the
+	// thread is left with less capabilities than the others. The module
+	// must exit if Enter fails
+	runtime.LockOSThread()
+
+	caps, err := capability.NewPid(0)
+	if err != nil {
+		return errors.Wrap(err, "failed to load capabilities")
+	}
+
+	caps.Clear(capability.BOUNDING)
+	caps.Set(capability.BOUNDING, profile...)
+	caps.Clear(capability.INHERITABLE)
+	caps.Set(capability.INHERITABLE, profile...)
+
+	if err := caps.Apply(capability.BOUNDS | capability.CAPS); err != nil {
+		return errors.Wrap(err, "failed to drop capabilities")
+	}
+
+	env := append(os.Environ(), fmt.Sprintf("%s=%s", envSandbox, module))
+	if err := syscall.Exec("/proc/self/exe", os.Args, env); err != nil {
+		return errors.Wrap(err, "failed to run sandboxed module")
+	}
+
+	// never reached
+	return nil
+}
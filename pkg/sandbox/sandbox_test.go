@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/gocapability/capability"
+	"github.com/threefoldtech/zos/pkg/startup"
+)
+
+func has(caps []capability.Cap, cap capability.Cap) bool {
+	for _, c := range caps {
+		if c == cap {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProfiles(t *testing.T) {
+	// identityd upgrades the node and the broker runs the privileged
+	// operations, they keep all their capabilities
+	unsandboxed := map[string]bool{"identityd": true, "broker": true}
+	// the modules that can't do their work without CAP_SYS_ADMIN, the
+	// others ask the broker
+	admin := map[string]bool{"storage": true, "flist": true, "network": true, "vmd": true, "monitor": true}
+
+	for name := range startup.Modules {
+		_, ok := Profiles[name]
+		assert.Equal(t, !unsandboxed[name], ok, "module %s", name)
+	}
+
+	for name, caps := range Profiles {
+		assert.False(t, has(caps, capability.CAP_SYS_MODULE), "module %s can load kernel modules", name)
+		assert.False(t, has(caps, capability.CAP_SETPCAP), "module %s can change capabilities", name)
+		assert.Equal(t, name == "network", has(caps, capability.CAP_NET_ADMIN), "module %s", name)
+		assert.Equal(t, admin[name], has(caps, capability.CAP_SYS_ADMIN), "module %s", name)
+		// only the 0-fs processes write files for other users
+		assert.Equal(t, name == "flist", has(caps, capability.CAP_DAC_OVERRIDE), "module %s", name)
+	}
+}
+
+func TestValidateMount(t *testing.T) {
+	root, err := ioutil.TempDir("", "broker")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	defer func(roots map[string]string) { mountRoots = roots }(mountRoots)
+	mountRoots = map[string]string{"tmpfs": root}
+
+	target := filepath.Join(root, "1-1")
+	require.NoError(t, os.Mkdir(target, 0700))
+	require.NoError(t, os.Symlink("/etc", filepath.Join(root, "link")))
+
+	flags, data, err := validateMount("tmpfs", target, "tmpfs", []string{"noexec", "size=1024", "mode=2750", "gid=1000"})
+	require.NoError(t, err)
+	assert.Equal(t, uintptr(syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC), flags)
+	assert.Equal(t, "size=1024,mode=2750,gid=1000", data)
+
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		fstype  string
+		options []string
+	}{
+		{"type", "/dev/sda", target, "ext4", nil},
+		{"source", "/dev/sda", target, "tmpfs", nil},
+		{"outside", "tmpfs", "/etc", "tmpfs", nil},
+		{"nested", "tmpfs", filepath.Join(target, "a"), "tmpfs", nil},
+		{"traversal", "tmpfs", root + "/../etc", "tmpfs", nil},
+		{"link", "tmpfs", filepath.Join(root, "link"), "tmpfs", nil},
+		{"missing", "tmpfs", filepath.Join(root, "1-2"), "tmpfs", nil},
+		{"option", "tmpfs", target, "tmpfs", []string{"suid"}},
+		{"data", "tmpfs", target, "tmpfs", []string{"size=1,uid=1000"}},
+		{"group", "tmpfs", target, "tmpfs", []string{"gid=users"}},
+		{"mode", "tmpfs", target, "tmpfs", []string{"mode=07777"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := validateMount(test.source, test.target, test.fstype, test.options)
+			assert.Error(t, err)
+		})
+	}
+
+	assert.NoError(t, validateUnmount(target))
+	assert.Error(t, validateUnmount("/"))
+	assert.Error(t, validateUnmount(filepath.Join(root, "link")))
+}
+
+func TestValidateProbe(t *testing.T) {
+	assert.NoError(t, validateProbe("ns-1", "tcp", "10.0.0.2:80", "", time.Second))
+	assert.NoError(t, validateProbe("ns-1", "http", "127.0.0.1:80", "/health", time.Second))
+
+	assert.Error(t, validateProbe("../1", "tcp", "10.0.0.2:80", "", time.Second))
+	assert.Error(t, validateProbe("..", "tcp", "10.0.0.2:80", "", time.Second))
+	assert.Error(t, validateProbe("ns-1", "udp", "10.0.0.2:80", "", time.Second))
+	assert.Error(t, validateProbe("ns-1", "tcp", "node:80", "", time.Second))
+	assert.Error(t, validateProbe("ns-1", "tcp", "10.0.0.2:http", "", time.Second))
+	assert.Error(t, validateProbe("ns-1", "http", "10.0.0.2:80", "@evil/", time.Second))
+	assert.Error(t, validateProbe("ns-1", "tcp", "10.0.0.2:80", "", time.Hour))
+	assert.Error(t, validateProbe("ns-1", "tcp", "10.0.0.2:80", "", 0))
+}
+
+func TestValidateKernelModule(t *testing.T) {
+	assert.NoError(t, validateKernelModule("zram", nil))
+	assert.NoError(t, validateKernelModule("zram", []string{"num_devices=1"}))
+
+	assert.Error(t, validateKernelModule("nbd", nil))
+	assert.Error(t, validateKernelModule("zram", []string{"num_devices"}))
+	assert.Error(t, validateKernelModule("zram", []string{"num_devices=1 -r"}))
+	assert.Error(t, validateKernelModule("zram", []string{"--install=sh"}))
+}
@@ -0,0 +1,171 @@
+// Package serial runs the operations on the same key one after the other.
+//
+// The operations on a key are queued and executed in the order they were
+// submitted, by one worker per key, while the operations on different keys
+// run concurrently. The caller either waits for its operation with Do, or
+// submits it with Submit and polls the status of the key.
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of an operation
+type State string
+
+const (
+	// Pending operations wait for the previous operations on their key
+	Pending State = "pending"
+	// Applying is the state of the running operation of a key
+	Applying State = "applying"
+	// Applied operations returned without error
+	Applied State = "applied"
+	// Failed operations returned an error
+	Failed State = "failed"
+)
+
+// Status is the status of the last operation submitted on a key
+type Status struct {
+	State State
+	// Operation is the name of the operation
+	Operation string
+	// Pending is the number of operations of the key waiting to run, the
+	// last one included
+	Pending int
+	// Value and Err are the result of the operation once it returned
+	Value interface{}
+	Err   error
+	// Updated is the time of the last change of state
+	Updated time.Time
+}
+
+type op struct {
+	name string
+	fn   func() (interface{}, error)
+	done chan struct{}
+
+	state   State
+	value   interface{}
+	err     error
+	updated time.Time
+}
+
+type lane struct {
+	queue   []*op
+	running bool
+	last    *op
+}
+
+// Queue serializes the operations by key. The zero value is not usable,
+// use New
+type Queue struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	lanes map[string]*lane
+}
+
+// New creates a new queue
+func New() *Queue {
+	return &Queue{
+		now:   time.Now,
+		lanes: make(map[string]*lane),
+	}
+}
+
+func (q *Queue) enqueue(key, name string, fn func() (interface{}, error)) *op {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.lanes[key]
+	if !ok {
+		l = &lane{}
+		q.lanes[key] = l
+	}
+
+	o := &op{
+		name:    name,
+		fn:      fn,
+		done:    make(chan struct{}),
+		state:   Pending,
+		updated: q.now(),
+	}
+
+	l.queue = append(l.queue, o)
+	l.last = o
+
+	if !l.running {
+		l.running = true
+		go q.run(l)
+	}
+
+	return o
+}
+
+// run executes the operations of l until its queue is empty
+func (q *Queue) run(l *lane) {
+	for {
+		q.mu.Lock()
+		if len(l.queue) == 0 {
+			l.running = false
+			q.mu.Unlock()
+			return
+		}
+
+		o := l.queue[0]
+		l.queue = l.queue[1:]
+		o.state = Applying
+		o.updated = q.now()
+		q.mu.Unlock()
+
+		value, err := o.fn()
+
+		q.mu.Lock()
+		o.value, o.err = value, err
+		o.state = Applied
+		if err != nil {
+			o.state = Failed
+		}
+		o.updated = q.now()
+		q.mu.Unlock()
+
+		close(o.done)
+	}
+}
+
+// Do queues the operation name on key and waits for its result
+func (q *Queue) Do(key, name string, fn func() (interface{}, error)) (interface{}, error) {
+	o := q.enqueue(key, name, fn)
+	<-o.done
+	return o.value, o.err
+}
+
+// Submit queues the operation name on key and returns the status of the
+// key without waiting for the operation
+func (q *Queue) Submit(key, name string, fn func() (interface{}, error)) Status {
+	q.enqueue(key, name, fn)
+	status, _ := q.Status(key)
+	return status
+}
+
+// Status returns the status of the last operation submitted on key, ok is
+// false if no operation was ever submitted on key
+func (q *Queue) Status(key string) (status Status, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.lanes[key]
+	if !ok {
+		return status, false
+	}
+
+	return Status{
+		State:     l.last.state,
+		Operation: l.last.name,
+		Pending:   len(l.queue),
+		Value:     l.last.value,
+		Err:       l.last.err,
+		Updated:   l.last.updated,
+	}, true
+}
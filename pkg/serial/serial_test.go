@@ -0,0 +1,117 @@
+package serial
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoSerialized(t *testing.T) {
+	q := New()
+
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+		order   []int
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := q.Do("net", "apply", func() (interface{}, error) {
+				mu.Lock()
+				running++
+				if running > max {
+					max = running
+				}
+				order = append(order, i)
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil, nil
+			})
+			require.NoError(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, 1, max)
+	assert.Len(t, order, 10)
+}
+
+func TestDoKeysConcurrent(t *testing.T) {
+	q := New()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go q.Do("a", "apply", func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	v, err := q.Do("b", "apply", func() (interface{}, error) {
+		return "b", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", v)
+
+	close(release)
+}
+
+func TestSubmitStatus(t *testing.T) {
+	q := New()
+
+	_, ok := q.Status("net")
+	assert.False(t, ok)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	q.Submit("net", "create", func() (interface{}, error) {
+		close(started)
+		<-release
+		return "ns", nil
+	})
+	<-started
+
+	status := q.Submit("net", "delete", func() (interface{}, error) {
+		return nil, fmt.Errorf("failed")
+	})
+	assert.Equal(t, Pending, status.State)
+	assert.Equal(t, "delete", status.Operation)
+	assert.Equal(t, 1, status.Pending)
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		status, _ := q.Status("net")
+		return status.State == Failed
+	}, time.Second, time.Millisecond)
+
+	status, ok = q.Status("net")
+	require.True(t, ok)
+	assert.Equal(t, 0, status.Pending)
+	assert.EqualError(t, status.Err, "failed")
+
+	v, err := q.Do("net", "create", func() (interface{}, error) {
+		return "ns", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ns", v)
+
+	status, _ = q.Status("net")
+	assert.Equal(t, Applied, status.State)
+	assert.Equal(t, "ns", status.Value)
+}
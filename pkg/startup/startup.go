@@ -0,0 +1,164 @@
+// Package startup orders the start of the 0-OS modules. Every module declares
+// the modules it calls, it waits for them to answer on zbus before serving
+// and it is restarted when one of them restarts, so it never keeps state
+// that was built against a previous instance of a dependency
+package startup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+)
+
+// ObjectID is the zbus object every module registers to report it's serving
+var ObjectID = zbus.ObjectID{Name: "startup", Version: "0.0.1"}
+
+// Module is a 0-OS module
+type Module struct {
+	// Name of the module on zbus
+	Name string
+	// Service is the zinit service running the module
+	Service string
+	// Requires are the modules called by the module
+	Requires []string
+}
+
+// Modules are the 0-OS modules by their zbus name
+var Modules = map[string]Module{
+	"broker":    {Name: "broker", Service: "brokerd"},
+	"storage":   {Name: "storage", Service: "storaged"},
+	"identityd": {Name: "identityd", Service: "identityd"},
+	"flist":     {Name: "flist", Service: "flistd", Requires: []string{"storage"}},
+	"network":   {Name: "network", Service: "networkd", Requires: []string{"identityd"}},
+	"container": {Name: "container", Service: "contd"},
+	"vmd":       {Name: "vmd", Service: "vmd"},
+	"monitor":   {Name: "monitor", Service: "capacityd", Requires: []string{"identityd", "network", "storage"}},
+	"provision": {
+		Name:     "provision",
+		Service:  "provisiond",
+		Requires: []string{"identityd", "network", "storage", "flist", "container", "vmd"},
+	},
+}
+
+// pollInterval is the interval between the checks of the dependencies
+var pollInterval = 10 * time.Second
+
+// Instance is the zbus object registered by a module, it identifies
+// the running instance of the module
+type Instance struct {
+	id string
+}
+
+// NewInstance creates the instance object of the running module
+func NewInstance() *Instance {
+	return &Instance{id: fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())}
+}
+
+// ID returns the ID of the instance, it changes every time the module restarts
+func (i *Instance) ID() string {
+	return i.id
+}
+
+// instance requests the instance ID of a module. The request stays queued
+// until the module serves it, so it blocks while the module is not running
+func instance(ctx context.Context, cl zbus.Client, module string) (string, error) {
+	type response struct {
+		id  string
+		err error
+	}
+
+	ch := make(chan response, 1)
+	go func() {
+		var r response
+		result, err := cl.Request(module, ObjectID, "ID")
+		if err != nil {
+			r.err = err
+		} else {
+			r.err = result.Unmarshal(0, &r.id)
+		}
+		ch <- r
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		return r.id, r.err
+	}
+}
+
+// Dependencies are the dependencies of a running module
+type Dependencies struct {
+	module    string
+	cl        zbus.Client
+	instances map[string]string
+}
+
+// New returns the dependencies of module
+func New(module string, cl zbus.Client) (*Dependencies, error) {
+	m, ok := Modules[module]
+	if !ok {
+		return nil, fmt.Errorf("unknown module '%s'", module)
+	}
+
+	instances := make(map[string]string)
+	for _, dep := range m.Requires {
+		instances[dep] = ""
+	}
+
+	return &Dependencies{module: module, cl: cl, instances: instances}, nil
+}
+
+// Wait blocks until all the dependencies serve requests
+func (d *Dependencies) Wait(ctx context.Context) error {
+	for dep := range d.instances {
+		log.Info().Str("module", d.module).Str("dependency", dep).Msg("waiting for dependency")
+		id, err := instance(ctx, d.cl, dep)
+		if err != nil {
+			return errors.Wrapf(err, "failed to wait for %s", dep)
+		}
+		d.instances[dep] = id
+	}
+
+	return nil
+}
+
+// WaitFor blocks until module serves requests, for the modules that use
+// a module starting after them (storaged signs its audit log with the
+// identity of identityd for example)
+func WaitFor(ctx context.Context, cl zbus.Client, module string) error {
+	_, err := instance(ctx, cl, module)
+	return err
+}
+
+// Watch returns an error once one of the dependencies restarted, the module
+// must then restart too. It returns nil when ctx is canceled. Wait must be
+// called before Watch
+func (d *Dependencies) Watch(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+
+		for dep, known := range d.instances {
+			id, err := instance(ctx, d.cl, dep)
+			if ctx.Err() != nil {
+				return nil
+			} else if err != nil {
+				log.Error().Err(err).Str("dependency", dep).Msg("failed to check dependency")
+				continue
+			}
+
+			if id != known {
+				return fmt.Errorf("dependency %s restarted", dep)
+			}
+		}
+	}
+}
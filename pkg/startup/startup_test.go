@@ -0,0 +1,53 @@
+package startup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/zinit"
+	"gopkg.in/yaml.v2"
+)
+
+// TestServices makes sure zinit starts the services after the services
+// of the modules they require
+func TestServices(t *testing.T) {
+	after := func(service string) []string {
+		data, err := ioutil.ReadFile(filepath.Join("../../etc/zinit", fmt.Sprintf("%s.yaml", service)))
+		if err != nil {
+			// services of the base image are not defined here
+			return nil
+		}
+
+		var s zinit.InitService
+		require.NoError(t, yaml.Unmarshal(data, &s))
+		return s.After
+	}
+
+	var startsAfter func(service, dep string, seen map[string]bool) bool
+	startsAfter = func(service, dep string, seen map[string]bool) bool {
+		for _, s := range after(service) {
+			if s == dep {
+				return true
+			}
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			if startsAfter(s, dep, seen) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, module := range Modules {
+		for _, dep := range module.Requires {
+			service := Modules[dep].Service
+			assert.True(t, startsAfter(module.Service, service, map[string]bool{}), "%s must start after %s", module.Service, service)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 //go:generate mkdir -p stubs
@@ -63,13 +64,83 @@ type (
 		Label string
 		// Err returned by the action which let to the pool being marked as broken
 		Err error
+		// Devices are the devices of the pool
+		Devices []string
+		// Diagnostics describe what is wrong with the pool, unlike Err
+		// they survive the trip over zbus
+		Diagnostics []string
+		// Since is when the pool was found broken
+		Since time.Time
 	}
 )
 
+// DiskHealthState is the health of a disk as predicted from
+// its SMART attributes and its IO errors
+type DiskHealthState string
+
+// Disk health states
+const (
+	DiskHealthy DiskHealthState = "healthy"
+	// DiskFailingSoon is a disk that shows signs of degradation, its
+	// storage pool doesn't get new allocations and its volumes are
+	// moved to healthy pools
+	DiskFailingSoon DiskHealthState = "failing-soon"
+	// DiskFailed is a disk past the failure threshold of its vendor
+	DiskFailed DiskHealthState = "failed"
+)
+
+// DiskHealth is the health of a disk used by a storage pool
+type DiskHealth struct {
+	Path  string          `json:"path"`
+	Pool  string          `json:"pool"`
+	State DiskHealthState `json:"state"`
+	// Reasons explain why the disk is not healthy
+	Reasons []string  `json:"reasons,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// DeviceIdentity maps a device of a storage pool to its current path
+type DeviceIdentity struct {
+	// ID is the identity of the device which doesn't change across
+	// reboots, built from its WWN, its serial or its partition UUID
+	ID     string `json:"id"`
+	WWN    string `json:"wwn,omitempty"`
+	Serial string `json:"serial,omitempty"`
+	// Path is the path of the device since the last boot
+	Path string `json:"path"`
+	Pool string `json:"pool"`
+}
+
+// PoolForecast is the usage trend of a storage pool
+type PoolForecast struct {
+	Pool     string     `json:"pool"`
+	DiskType DeviceType `json:"disk_type"`
+	Size     uint64     `json:"size"`
+	Used     uint64     `json:"used"`
+	// Growth is the smoothed usage growth in bytes per hour
+	Growth float64 `json:"growth"`
+	// Full is when the pool is predicted to be full at the current
+	// growth, it is zero if the usage doesn't grow
+	Full time.Time `json:"full"`
+	// Warning is set when the pool is predicted to be full soon
+	Warning bool `json:"warning"`
+}
+
+// OwnerQuota is the usage of the volumes of a user in a storage pool
+type OwnerQuota struct {
+	User string `json:"user"`
+	Pool string `json:"pool"`
+	Used uint64 `json:"used"`
+	// Limit is the size the volumes of the user can use in the pool, 0 if unlimited
+	Limit uint64 `json:"limit"`
+}
+
 // Known device types
 const (
 	SSDDevice DeviceType = "ssd"
 	HDDDevice DeviceType = "hdd"
+	// MemoryDevice is the node memory, it only holds tmpfs volumes
+	MemoryDevice DeviceType = "memory"
 )
 
 // Validate make sure profile is correct
@@ -112,6 +183,60 @@ type StoragePolicy struct {
 	MaxPools uint8
 }
 
+// VolumeKind is the usage of a volume. The storage module applies
+// defaults (quota, compression, placement) depending on the kind
+type VolumeKind string
+
+// Known volume kinds
+const (
+	// VolumeKindVolume is a volume reserved by a user and mounted in containers
+	VolumeKindVolume VolumeKind = "volume"
+	// VolumeKindZDB holds the namespaces of a 0-db instance
+	VolumeKindZDB VolumeKind = "zdb"
+	// VolumeKindVDisk holds the virtual disks of the virtual machines
+	VolumeKindVDisk VolumeKind = "vdisk"
+	// VolumeKindRootFSRW is the read-write layer of a container root filesystem
+	VolumeKindRootFSRW VolumeKind = "rootfs-rw"
+	// VolumeKindCache is the node cache
+	VolumeKindCache VolumeKind = "cache"
+	// VolumeKindTmpfs is a volume held in memory, its content is lost
+	// when it is released or when the node reboots
+	VolumeKindTmpfs VolumeKind = "tmpfs"
+	// VolumeKindSwap holds the encrypted swap of the node
+	VolumeKindSwap VolumeKind = "swap"
+)
+
+// Valid checks if the volume kind is known
+func (k VolumeKind) Valid() error {
+	switch k {
+	case VolumeKindVolume, VolumeKindZDB, VolumeKindVDisk, VolumeKindRootFSRW, VolumeKindCache, VolumeKindTmpfs, VolumeKindSwap:
+		return nil
+	}
+
+	return fmt.Errorf("unknown volume kind '%s'", k)
+}
+
+// VolumeInfo is a volume as reported by ListVolumes
+type VolumeInfo struct {
+	Name string     `json:"name"`
+	Path string     `json:"path"`
+	Kind VolumeKind `json:"kind"`
+	// Pool is the name of the storage pool of the volume
+	Pool     string     `json:"pool"`
+	DiskType DeviceType `json:"disk_type"`
+	// Size is the size limit of the volume in bytes, 0 if unlimited
+	Size uint64 `json:"size"`
+	// Used is the space used by the volume in bytes
+	Used uint64 `json:"used"`
+	// Owner is the reservation the volume belongs to, empty for
+	// the volumes of the system or shared between reservations
+	Owner string `json:"owner,omitempty"`
+	// Created is the creation time of the volume, zero for
+	// volumes created before it was recorded
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
 // VolumeAllocater is the zbus interface of the storage module responsible
 // for volume allocation
 type VolumeAllocater interface {
@@ -123,6 +248,17 @@ type VolumeAllocater interface {
 	// to try again on a different devicetype
 	CreateFilesystem(name string, size uint64, poolType DeviceType) (string, error)
 
+	// CreateVolume is CreateFilesystem for a volume of the given kind. The
+	// volume is created with the quota, compression and placement defaults
+	// of its kind. CreateFilesystem creates volumes of kind VolumeKindVolume
+	CreateVolume(name string, size uint64, poolType DeviceType, kind VolumeKind) (string, error)
+
+	// CreateVolumeRequest is CreateVolume with the ID of the request given
+	// by the caller. A retry of the request with the same ID returns the
+	// volume created by the first one. Without an ID, as with CreateVolume,
+	// a retry is recognized by its arguments only
+	CreateVolumeRequest(requestID, name string, size uint64, poolType DeviceType, kind VolumeKind) (string, error)
+
 	// ReleaseFilesystem signals that the named filesystem is no longer needed.
 	// The filesystem will be unmounted and subsequently removed.
 	// All data contained in the filesystem will be lost, and the
@@ -142,6 +278,32 @@ type VDisk struct {
 	Size int64
 }
 
+// IOLimit caps the disk IO of a workload, a zero field is not limited.
+// The bandwidths are in bytes per second
+type IOLimit struct {
+	ReadIOPS       uint64 `json:"read_iops"`
+	WriteIOPS      uint64 `json:"write_iops"`
+	ReadBandwidth  uint64 `json:"read_bandwidth"`
+	WriteBandwidth uint64 `json:"write_bandwidth"`
+}
+
+// IsZero returns true if the limit doesn't cap anything
+func (l IOLimit) IsZero() bool {
+	return l == IOLimit{}
+}
+
+// ImageImport is the progress of an image imported on a virtual disk
+type ImageImport struct {
+	// ID of the virtual disk the image is imported on
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Downloaded is the number of bytes of the image downloaded so far,
+	// including the ones of the previous attempts
+	Downloaded int64 `json:"downloaded"`
+	// Size of the image in bytes, 0 if not known yet
+	Size int64 `json:"size"`
+}
+
 // VDiskModule interface
 type VDiskModule interface {
 	// AllocateDisk with given id and size, return path to virtual disk
@@ -149,9 +311,21 @@ type VDiskModule interface {
 	// DeallocateVDisk removes a virtual disk
 	Deallocate(id string) error
 	// Exists checks if disk with that ID already allocated
-	Exists(id string) bool
+	Exists(id string) (bool, error)
 	// Inspect return info about the disk
 	Inspect(id string) (VDisk, error)
+	// Snapshot creates a read-only snapshot of the disk, the snapshot is a
+	// disk with id `id@name` that can be cloned or deallocated
+	Snapshot(id, name string) (string, error)
+	// Clone creates the disk id from the disk or snapshot source without
+	// copying its data, return path to the new virtual disk
+	Clone(source, id string) (string, error)
+	// ImportImage creates the disk id from the raw or qcow2 image at url.
+	// The download is resumed where it stopped if it fails and the image
+	// is verified against its sha256 checksum before it is used
+	ImportImage(url, sha256, id string) (string, error)
+	// ImportProgress returns the progress of the running image imports
+	ImportProgress(ctx context.Context) <-chan ImageImport
 }
 
 // StorageModule defines the api for storage
@@ -162,9 +336,58 @@ type StorageModule interface {
 	// Total gives the total amount of storage available for a device type
 	Total(kind DeviceType) (uint64, error)
 	// BrokenPools lists the broken storage pools that have been detected
-	BrokenPools() []BrokenPool
+	BrokenPools() ([]BrokenPool, error)
 	// BrokenDevices lists the broken devices that have been detected
-	BrokenDevices() []BrokenDevice
+	BrokenDevices() ([]BrokenDevice, error)
+	// RepairPool tries to bring back the broken pool with the given label,
+	// its volumes are available again if it succeeds
+	RepairPool(label string) error
+	// DeviceIdentities maps the devices of the storage pools to their
+	// current paths, the paths change when the kernel reorders the disks
+	DeviceIdentities() ([]DeviceIdentity, error)
+
+	// SwapOn enables the encrypted swap of the node if it has one, with a
+	// new random key. The key is never written anywhere, so the pages
+	// swapped out can't be read once the swap is turned off
+	SwapOn() error
+	// SwapOff moves the swapped out pages back to memory and destroys
+	// the key of the swap, it must be called before suspending the node
+	SwapOff() error
+
+	// ListVolumes lists the volumes of the given kind, or all
+	// the volumes if kind is empty
+	ListVolumes(kind VolumeKind) ([]VolumeInfo, error)
+	// LabelVolume sets the owner of the volume name, usually the ID of the
+	// reservation using it, and adds labels to it. A volume owner can't be
+	// changed and a label with an empty value is removed. An empty owner
+	// keeps the current one
+	LabelVolume(name, owner string, labels map[string]string) error
+
+	// VolumeDevices returns the paths of the disks of the pool the volume
+	// name lives in, the IO limits of the workloads using the volume are
+	// applied to them
+	VolumeDevices(name string) ([]string, error)
+
+	// OwnerQuotas returns the usage of the volumes of each user in the
+	// pools, all the volumes of a user in a pool share the same limit
+	OwnerQuotas() ([]OwnerQuota, error)
+
+	// ForensicMount mounts the volume name read-only in a diagnostic
+	// location for the given duration, to recover the data of a workload
+	// without touching it. The reason is recorded in the audit log
+	ForensicMount(name, reason string, duration time.Duration) (string, error)
+	// ForensicUnmount ends the forensic examination of the volume name
+	// before its mount expires
+	ForensicUnmount(name string) error
+
+	// DisksHealth returns the health of the disks used by the storage pools
+	DisksHealth() ([]DiskHealth, error)
+
+	// Forecast returns the usage trend of the storage pools
+	Forecast() ([]PoolForecast, error)
+	// ForecastWarnings returns a stream of the forecasts of the pools
+	// predicted to be full soon, they are sent after each usage check
+	ForecastWarnings(ctx context.Context) <-chan PoolForecast
 
 	//Monitor returns stats stream about pools
 	Monitor(ctx context.Context) <-chan PoolsStats
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/dedup"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/stubs"
+	"github.com/threefoldtech/zos/pkg/stubs/stubtest"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+// dirPool is a fake pool backed by a directory, its volumes are the sub
+// directories of the pool. It stands for a btrfs pool in the tests of
+// the module API
+type dirPool struct {
+	name  string
+	path  string
+	ptype pkg.DeviceType
+	size  uint64
+
+	mu     sync.Mutex
+	limits map[string]uint64
+}
+
+var _ filesystem.Pool = &dirPool{}
+
+func newDirPool(root, name string, ptype pkg.DeviceType, size uint64) (*dirPool, error) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &dirPool{
+		name:   name,
+		path:   path,
+		ptype:  ptype,
+		size:   size,
+		limits: make(map[string]uint64),
+	}, nil
+}
+
+func (p *dirPool) ID() int {
+	return 0
+}
+
+func (p *dirPool) Path() string {
+	return p.path
+}
+
+func (p *dirPool) Usage() (filesystem.Usage, error) {
+	return filesystem.Usage{Size: p.size}, nil
+}
+
+func (p *dirPool) Limit(_ uint64) error {
+	return nil
+}
+
+func (p *dirPool) Name() string {
+	return p.name
+}
+
+func (p *dirPool) FsType() string {
+	return "dir"
+}
+
+func (p *dirPool) Mounted() (string, bool) {
+	return p.path, true
+}
+
+func (p *dirPool) Mount() (string, error) {
+	return p.path, nil
+}
+
+func (p *dirPool) UnMount() error {
+	return nil
+}
+
+func (p *dirPool) AddDevice(_ *filesystem.Device) error {
+	return nil
+}
+
+func (p *dirPool) RemoveDevice(_ *filesystem.Device) error {
+	return nil
+}
+
+func (p *dirPool) Type() pkg.DeviceType {
+	return p.ptype
+}
+
+func (p *dirPool) Reserved() (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var reserved uint64
+	for _, limit := range p.limits {
+		reserved += limit
+	}
+	return reserved, nil
+}
+
+func (p *dirPool) Maintenance() error {
+	return nil
+}
+
+func (p *dirPool) Volumes() ([]filesystem.Volume, error) {
+	infos, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []filesystem.Volume
+	for _, info := range infos {
+		// the hidden directories hold the metadata of the volumes
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			volumes = append(volumes, &dirVolume{pool: p, name: info.Name()})
+		}
+	}
+	return volumes, nil
+}
+
+func (p *dirPool) AddVolume(name string) (filesystem.Volume, error) {
+	if err := validVolumeName(name); err != nil {
+		return nil, err
+	}
+
+	if err := os.Mkdir(filepath.Join(p.path, name), 0755); err != nil {
+		return nil, err
+	}
+	return &dirVolume{pool: p, name: name}, nil
+}
+
+func (p *dirPool) RemoveVolume(name string) error {
+	if err := validVolumeName(name); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.limits, name)
+	p.mu.Unlock()
+
+	return os.Remove(filepath.Join(p.path, name))
+}
+
+func (p *dirPool) Devices() []*filesystem.Device {
+	return nil
+}
+
+// validVolumeName refuses the names btrfs refuses, so the
+// fake pool never touches anything out of its directory
+func validVolumeName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/.") {
+		return fmt.Errorf("invalid volume name '%s'", name)
+	}
+	return nil
+}
+
+type dirVolume struct {
+	pool *dirPool
+	name string
+}
+
+func (v *dirVolume) ID() int {
+	return 0
+}
+
+func (v *dirVolume) Path() string {
+	return filepath.Join(v.pool.path, v.name)
+}
+
+func (v *dirVolume) Usage() (filesystem.Usage, error) {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+	return filesystem.Usage{Size: v.pool.limits[v.name]}, nil
+}
+
+func (v *dirVolume) Limit(size uint64) error {
+	v.pool.mu.Lock()
+	defer v.pool.mu.Unlock()
+	v.pool.limits[v.name] = size
+	return nil
+}
+
+func (v *dirVolume) Name() string {
+	return v.name
+}
+
+func (v *dirVolume) FsType() string {
+	return "dir"
+}
+
+// testModule returns a storage module with an ssd and an hdd pool
+// backed by directories in root
+func testModule(t *testing.T, root string) *storageModule {
+	ssd, err := newDirPool(root, "ssd", pkg.SSDDevice, 100*gib)
+	require.NoError(t, err)
+	hdd, err := newDirPool(root, "hdd", pkg.HDDDevice, 1000*gib)
+	require.NoError(t, err)
+
+	return &storageModule{
+		volumes:  []filesystem.Pool{ssd, hdd},
+		inflight: &utils.InFlight{},
+		requests: dedup.New(time.Minute),
+	}
+}
+
+func testGenerator() *stubtest.Generator {
+	g := stubtest.NewGenerator(1)
+	g.Values(
+		pkg.SSDDevice, pkg.HDDDevice, pkg.MemoryDevice,
+		pkg.VolumeKindVolume, pkg.VolumeKindZDB, pkg.VolumeKindVDisk,
+		pkg.VolumeKindRootFSRW, pkg.VolumeKindCache, pkg.VolumeKindTmpfs,
+		pkg.ZDBMode(pkg.ZDBModeSeq), pkg.ZDBMode(pkg.ZDBModeUser),
+		uint64(gib), int64(1),
+	)
+	return g
+}
+
+func TestStorageAPI(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// the swap, the forensic mounts and the exports need
+	// real devices and mounts, they are not exercised
+	stubtest.Exercise(t, testGenerator(), testModule(t, root), &stubs.StorageModuleStub{},
+		"Total", "BrokenPools", "BrokenDevices", "RepairPool", "DeviceIdentities",
+		"CreateFilesystem", "CreateVolume", "CreateVolumeRequest", "Path", "ListVolumes", "LabelVolume",
+		"VolumeDevices", "OwnerQuotas", "DisksHealth", "Forecast",
+		"Allocate", "Find", "ReleaseFilesystem",
+	)
+}
+
+func TestStorageAPIVolume(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "storage-api")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	module := testModule(t, root)
+	stub := &stubs.StorageModuleStub{}
+	call := func(method string, args ...interface{}) []interface{} {
+		results, err := stubtest.Call(module, stub, method, args...)
+		require.NoError(err)
+		return results
+	}
+
+	results := call("CreateVolume", "vol", uint64(gib), pkg.HDDDevice, pkg.VolumeKindVolume)
+	require.NoError(errOf(results))
+	require.Equal(filepath.Join(root, "hdd", "vol"), results[0])
+
+	results = call("LabelVolume", "vol", "1-1", map[string]string{"app": "db"})
+	require.NoError(errOf(results))
+
+	results = call("ListVolumes", pkg.VolumeKindVolume)
+	require.NoError(errOf(results))
+	volumes := results[0].([]pkg.VolumeInfo)
+	require.Len(volumes, 1)
+	require.Equal("vol", volumes[0].Name)
+	require.Equal("1-1", volumes[0].Owner)
+	require.Equal("db", volumes[0].Labels["app"])
+
+	results = call("Path", "vol")
+	require.NoError(errOf(results))
+	require.Equal(filepath.Join(root, "hdd", "vol"), results[0])
+
+	results = call("ReleaseFilesystem", "vol")
+	require.NoError(errOf(results))
+
+	results = call("Path", "vol")
+	require.Error(errOf(results))
+}
+
+func TestVDiskAPI(t *testing.T) {
+	root, err := ioutil.TempDir("", "vdisk-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	disks := filepath.Join(root, "vdisks")
+	require.NoError(t, os.Mkdir(disks, 0755))
+	module := &vdiskModule{path: disks, inflight: &utils.InFlight{}}
+
+	// the image imports download from the network, they are not exercised
+	stubtest.Exercise(t, testGenerator(), module, &stubs.VDiskModuleStub{},
+		"Allocate", "Deallocate", "Exists", "Inspect", "Snapshot", "Clone",
+	)
+
+	// nothing is ever written out of the disks directory
+	infos, err := ioutil.ReadDir(root)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+}
+
+// errOf returns the error returned by a call, it's always the last result
+func errOf(results []interface{}) error {
+	err, _ := results[len(results)-1].(error)
+	return err
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+const (
+	// cachePartitionParam is the kernel parameter giving the partition
+	// of the boot disk to format as cache if there is none yet
+	cachePartitionParam = "zos-cache"
+
+	pruneInterval = 10 * time.Minute
+)
+
+// cacheBudget is the maximum size of the data a node
+// service keeps in a directory of the cache
+type cacheBudget struct {
+	path string
+	size uint64
+}
+
+// the directories of the cache holding data which can be thrown away, the
+// state of the modules is never pruned
+var cacheBudgets = []cacheBudget{
+	{path: filepath.Join(CacheTarget, "log"), size: 1 * gib},
+	{path: filepath.Join(CacheTarget, "modules", "flistd", "cache"), size: 20 * gib},
+}
+
+// mountCachePartition mounts the partition labeled as the cache, so the
+// node data doesn't use the space of the storage pools. The partition
+// given on the kernel command line is formatted if no cache partition
+// exists. It returns false if the node has no cache partition
+func (s *storageModule) mountCachePartition() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	devices, err := s.devices.ByLabel(ctx, cacheLabel)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to look for cache partition")
+	}
+
+	var device *filesystem.Device
+	if len(devices) > 0 {
+		device = devices[0]
+	} else {
+		values, ok := kernel.GetParams().Get(cachePartitionParam)
+		if !ok || len(values) == 0 || values[0] == "" {
+			return false, nil
+		}
+
+		device, err = s.devices.Device(ctx, values[0])
+		if err != nil {
+			return false, errors.Wrapf(err, "cache partition %s not found", values[0])
+		}
+
+		// never format a partition which holds data
+		if device.Used() {
+			return false, fmt.Errorf("cache partition %s is already in use", device.Path)
+		}
+
+		log.Info().Str("device", device.Path).Msg("formatting cache partition")
+		if output, err := exec.CommandContext(ctx, "mkfs.btrfs", "-L", cacheLabel, device.Path).CombinedOutput(); err != nil {
+			return false, errors.Wrapf(err, "failed to format cache partition: %s", string(output))
+		}
+		device.Filesystem = filesystem.BtrfsFSType
+	}
+
+	fstype := string(device.Filesystem)
+	if fstype == "" {
+		fstype = string(filesystem.BtrfsFSType)
+	}
+
+	if err := os.MkdirAll(CacheTarget, 0755); err != nil {
+		return false, err
+	}
+
+	log.Info().Str("device", device.Path).Msgf("Mounting cache partition in %s", CacheTarget)
+	if err := syscall.Mount(device.Path, CacheTarget, fstype, 0, ""); err != nil {
+		return false, errors.Wrapf(err, "failed to mount cache partition %s", device.Path)
+	}
+
+	return true, nil
+}
+
+// PruneCache keeps the data of the node services in the cache under
+// their budget until ctx is canceled, the oldest files are removed first
+func PruneCache(ctx context.Context) {
+	for {
+		for _, budget := range cacheBudgets {
+			if err := prune(budget.path, budget.size); err != nil {
+				log.Error().Err(err).Str("path", budget.path).Msg("failed to prune cache")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pruneInterval):
+		}
+	}
+}
+
+type cacheFile struct {
+	path string
+	size uint64
+	mod  time.Time
+}
+
+// prune removes the oldest files under root until they all fit in size.
+// The newest file is truncated instead of removed if it doesn't fit
+// alone, since it is usually still open for writing and removing it
+// wouldn't free its space
+func prune(root string, size uint64) error {
+	var files []cacheFile
+	var total uint64
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		files = append(files, cacheFile{path: path, size: uint64(info.Size()), mod: info.ModTime()})
+		total += uint64(info.Size())
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if total <= size {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mod.Before(files[j].mod)
+	})
+
+	for i, file := range files {
+		if total <= size {
+			break
+		}
+
+		if i == len(files)-1 {
+			err = os.Truncate(file.path, 0)
+		} else {
+			err = os.Remove(file.path)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("file", file.path).Msg("failed to prune cache file")
+			continue
+		}
+
+		total -= file.size
+	}
+
+	log.Info().Str("path", root).Uint64("size", total).Msg("cache pruned")
+	return nil
+}
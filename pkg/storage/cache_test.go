@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "cache-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(root, name)
+		require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(ioutil.WriteFile(path, make([]byte, size), 0644))
+		require.NoError(os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		return path
+	}
+
+	oldest := write("a/oldest", 400, 3*time.Hour)
+	older := write("b/older", 400, 2*time.Hour)
+	newest := write("newest", 400, time.Hour)
+
+	// everything fits
+	require.NoError(prune(root, 1200))
+	require.FileExists(oldest)
+
+	require.NoError(prune(root, 900))
+	require.NoFileExists(oldest)
+	require.FileExists(older)
+	require.FileExists(newest)
+
+	// the newest file is truncated, not removed
+	require.NoError(prune(root, 100))
+	require.NoFileExists(older)
+	info, err := os.Stat(newest)
+	require.NoError(err)
+	require.EqualValues(0, info.Size())
+
+	// a missing directory has nothing to prune
+	require.NoError(prune(filepath.Join(root, "missing"), 100))
+}
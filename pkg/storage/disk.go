@@ -4,38 +4,64 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	vdiskVolumeName = "vdisks"
 
 	mib = 1024 * 1024
+
+	// snapshotSeparator separates the disk id from the
+	// snapshot name in the id of a snapshot
+	snapshotSeparator = "@"
+
+	// ficlone is the FICLONE ioctl, it makes a file share
+	// all the blocks of another file
+	ficlone = 0x40049409
 )
 
 type vdiskModule struct {
-	path string
+	path     string
+	inflight *utils.InFlight
+
+	importsMu sync.Mutex
+	imports   map[string]*imageImport
 }
 
 // NewVDiskModule creates a new disk allocator
-func NewVDiskModule(v pkg.VolumeAllocater) (pkg.VDiskModule, error) {
+func NewVDiskModule(v pkg.VolumeAllocater, inflight *utils.InFlight) (pkg.VDiskModule, error) {
 	path, err := v.Path(vdiskVolumeName)
 	if errors.Is(err, os.ErrNotExist) {
-		path, err = v.CreateFilesystem(vdiskVolumeName, 0, pkg.SSDDevice)
+		path, err = v.CreateVolume(vdiskVolumeName, 0, pkg.SSDDevice, pkg.VolumeKindVDisk)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &vdiskModule{path: filepath.Clean(path)}, nil
+	return &vdiskModule{path: filepath.Clean(path), inflight: inflight}, nil
 }
 
 // AllocateDisk with given size, return path to virtual disk (size in MB)
 func (d *vdiskModule) Allocate(id string, size int64) (string, error) {
+	done, err := d.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	if strings.Contains(id, snapshotSeparator) {
+		return "", fmt.Errorf("invalid disk id: '%s'", id)
+	}
+
 	path, err := d.safePath(id)
 	if err != nil {
 		return "", err
@@ -53,7 +79,13 @@ func (d *vdiskModule) Allocate(id string, size int64) (string, error) {
 
 	defer file.Close()
 
-	return path, syscall.Fallocate(int(file.Fd()), 0, 0, size*mib)
+	if err := syscall.Fallocate(int(file.Fd()), 0, 0, size*mib); err != nil {
+		// don't leave a half allocated disk behind
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
 }
 
 func (d *vdiskModule) safePath(id string) (string, error) {
@@ -71,6 +103,12 @@ func (d *vdiskModule) safePath(id string) (string, error) {
 
 // DeallocateVDisk removes a virtual disk
 func (d *vdiskModule) Deallocate(id string) error {
+	done, err := d.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	path, err := d.safePath(id)
 	if err != nil {
 		return err
@@ -79,18 +117,23 @@ func (d *vdiskModule) Deallocate(id string) error {
 	return os.Remove(path)
 }
 
-// DeallocateVDisk removes a virtual disk
-func (d *vdiskModule) Exists(id string) bool {
+// Exists checks if disk with that ID already allocated
+func (d *vdiskModule) Exists(id string) (bool, error) {
 	path, err := d.safePath(id)
 
 	if err != nil {
 		// invalid ID
-		return false
+		return false, nil
 	}
 
 	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
 
-	return err == nil
+	return true, nil
 }
 
 // Inspect return info about the disk
@@ -111,3 +154,97 @@ func (d *vdiskModule) Inspect(id string) (disk pkg.VDisk, err error) {
 	disk.Size = stat.Size()
 	return
 }
+
+// Snapshot creates a read-only snapshot of the disk id. The snapshot is a
+// disk with id `id@name` which shares its blocks with the disk, only the
+// blocks written to the disk after the snapshot take more space
+func (d *vdiskModule) Snapshot(id, name string) (string, error) {
+	done, err := d.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	if len(name) == 0 || strings.Contains(name, snapshotSeparator) {
+		return "", fmt.Errorf("invalid snapshot name: '%s'", name)
+	}
+
+	if strings.Contains(id, snapshotSeparator) {
+		return "", fmt.Errorf("cannot snapshot snapshot '%s'", id)
+	}
+
+	src, err := d.safePath(id)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := d.safePath(id + snapshotSeparator + name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := reflink(src, dst, 0444); err != nil {
+		return "", errors.Wrapf(err, "failed to snapshot disk '%s'", id)
+	}
+
+	return dst, nil
+}
+
+// Clone creates the disk id from the disk or snapshot source. The clone
+// shares its blocks with source, so cloning a disk is instant whatever
+// its size, and the clone doesn't depend on source once created
+func (d *vdiskModule) Clone(source, id string) (string, error) {
+	done, err := d.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	if strings.Contains(id, snapshotSeparator) {
+		return "", fmt.Errorf("invalid disk id: '%s'", id)
+	}
+
+	src, err := d.safePath(source)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := d.safePath(id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := reflink(src, dst, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to clone disk '%s'", source)
+	}
+
+	return dst, nil
+}
+
+// reflink creates the file dst sharing the blocks of src, it fails if the
+// filesystem doesn't support it instead of falling back to a full copy
+func reflink(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, out.Fd(), ficlone, in.Fd()); errno != 0 {
+		_ = os.Remove(dst)
+		return errno
+	}
+
+	if err := out.Sync(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+func TestVDiskSnapshotClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vdisks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var inflight utils.InFlight
+	d := &vdiskModule{path: filepath.Clean(dir), inflight: &inflight}
+
+	_, err = d.Allocate("golden", 1)
+	require.NoError(t, err)
+	_, err = d.Allocate("bad@name", 1)
+	assert.Error(t, err)
+
+	_, err = d.Snapshot("golden", "")
+	assert.Error(t, err)
+	_, err = d.Snapshot("golden", "../v1")
+	assert.Error(t, err)
+
+	path, err := d.Snapshot("golden", "v1")
+	if cause := errors.Cause(err); cause == unix.EOPNOTSUPP || cause == unix.EINVAL || cause == unix.ENOTTY {
+		t.Skip("filesystem does not support reflinks")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "golden@v1"), path)
+	exists, err := d.Exists("golden@v1")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = d.Snapshot("golden@v1", "v2")
+	assert.Error(t, err)
+
+	_, err = d.Clone("golden@v1", "vm@1")
+	assert.Error(t, err)
+
+	path, err = d.Clone("golden@v1", "vm")
+	require.NoError(t, err)
+
+	info, err := d.Inspect("vm")
+	require.NoError(t, err)
+	assert.Equal(t, path, info.Path)
+	assert.EqualValues(t, mib, info.Size)
+
+	_, err = d.Clone("golden", "vm")
+	assert.True(t, os.IsExist(errors.Cause(err)))
+}
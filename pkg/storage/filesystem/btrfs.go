@@ -213,13 +213,25 @@ func (p *btrfsPool) Mount() (string, error) {
 		return "", err
 	}
 
-	if err := syscall.Mount(fs.Devices[0].Path, mnt, "btrfs", 0, ""); err != nil {
+	if err := syscall.Mount(fs.Devices[0].Path, mnt, "btrfs", 0, p.deviceOptions()); err != nil {
 		return "", err
 	}
 
 	return mnt, p.utils.QGroupEnable(ctx, mnt)
 }
 
+// deviceOptions lists the current paths of the devices of the pool as
+// mount options, so a pool spanning disks the kernel found in a different
+// order than at the last boot is assembled from the right devices
+func (p *btrfsPool) deviceOptions() string {
+	var options []string
+	for _, device := range p.devices {
+		options = append(options, "device="+device.Path)
+	}
+
+	return strings.Join(options, ",")
+}
+
 func (p *btrfsPool) UnMount() error {
 	mnt, ok := p.Mounted()
 	if !ok {
@@ -443,6 +455,12 @@ func (p *btrfsPool) Maintenance() error {
 	}
 
 	for qgroupID := range qgroups {
+		// the higher level qgroups group the volumes
+		// and are not linked to one of them
+		if !strings.HasPrefix(qgroupID, "0/") {
+			continue
+		}
+
 		// for all qgroup that doesn't have an linked
 		// volume, delete the qgroup
 		_, ok := subVolsIDs[qgroupID]
@@ -1,18 +1,22 @@
 package filesystem
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/zos/pkg"
 )
 
 var (
 	reBtrfsFilesystemDf = regexp.MustCompile(`(?m:(\w+),\s(\w+):\s+total=(\d+),\s+used=(\d+))`)
 	reBtrfsQgroup       = regexp.MustCompile(`(?m:^(\d+/\d+)\s+(\d+)\s+(\d+)\s+(\d+|none)\s+(\d+|none).*$)`)
+	reBtrfsDeviceStats  = regexp.MustCompile(`(?m:^\[(.+)\]\.(\w+)\s+(\d+)$)`)
 )
 
 // Btrfs holds metadata of underlying btrfs filesystem
@@ -66,6 +70,21 @@ type BtrfsDiskUsage struct {
 	GlobalReserve DiskUsage `json:"globalreserve"`
 }
 
+// BtrfsDeviceStats are the IO error counters of a device in a btrfs filesystem
+type BtrfsDeviceStats struct {
+	Device           string `json:"device"`
+	WriteErrors      uint64 `json:"write_io_errs"`
+	ReadErrors       uint64 `json:"read_io_errs"`
+	FlushErrors      uint64 `json:"flush_io_errs"`
+	CorruptionErrors uint64 `json:"corruption_errs"`
+	GenerationErrors uint64 `json:"generation_errs"`
+}
+
+// Errors is the total number of errors of the device
+func (s *BtrfsDeviceStats) Errors() uint64 {
+	return s.WriteErrors + s.ReadErrors + s.FlushErrors + s.CorruptionErrors + s.GenerationErrors
+}
+
 // BtrfsUtil utils for btrfs
 type BtrfsUtil struct {
 	executer
@@ -151,6 +170,68 @@ func (u *BtrfsUtil) DeviceRemove(ctx context.Context, dev string, root string) e
 	return err
 }
 
+// DeviceStats returns the error counters of the devices of the filesystem mounted at root
+func (u *BtrfsUtil) DeviceStats(ctx context.Context, root string) ([]BtrfsDeviceStats, error) {
+	output, err := u.run(ctx, "btrfs", "device", "stats", root)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDeviceStats(string(output)), nil
+}
+
+// RescueZeroLog clears the log tree of the unmounted filesystem on dev, a
+// corrupted log tree prevents mounting. The last seconds of writes before
+// the crash are lost
+func (u *BtrfsUtil) RescueZeroLog(ctx context.Context, dev string) error {
+	_, err := u.run(ctx, "btrfs", "rescue", "zero-log", dev)
+	return err
+}
+
+// SubvolumeSnapshot creates a snapshot of the subvolume src at dst
+func (u *BtrfsUtil) SubvolumeSnapshot(ctx context.Context, src, dst string, readonly bool) error {
+	args := []string{"subvolume", "snapshot"}
+	if readonly {
+		args = append(args, "-r")
+	}
+
+	_, err := u.run(ctx, "btrfs", append(args, src, dst)...)
+	return err
+}
+
+// SubvolumeCopy copies the read-only snapshot to the directory dst, which
+// can be on another btrfs filesystem. The copy is created at dst with
+// the name of the snapshot
+func (u *BtrfsUtil) SubvolumeCopy(ctx context.Context, snapshot, dst string) error {
+	send := exec.CommandContext(ctx, "btrfs", "send", "-q", snapshot)
+	receive := exec.CommandContext(ctx, "btrfs", "receive", dst)
+
+	var stderr bytes.Buffer
+	send.Stderr = &stderr
+	receive.Stderr = &stderr
+
+	pipe, err := send.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	receive.Stdin = pipe
+
+	if err := receive.Start(); err != nil {
+		return errors.Wrap(err, "failed to start btrfs receive")
+	}
+
+	if err := send.Run(); err != nil {
+		receive.Wait()
+		return errors.Wrapf(err, "failed to send snapshot %s: %s", snapshot, stderr.String())
+	}
+
+	if err := receive.Wait(); err != nil {
+		return errors.Wrapf(err, "failed to receive snapshot %s: %s", snapshot, stderr.String())
+	}
+
+	return nil
+}
+
 // QGroupEnable enable quota
 func (u *BtrfsUtil) QGroupEnable(ctx context.Context, root string) error {
 	_, err := u.run(ctx, "btrfs", "quota", "enable", root)
@@ -179,6 +260,30 @@ func (u *BtrfsUtil) QGroupLimit(ctx context.Context, size uint64, path string) e
 	return err
 }
 
+// QGroupCreate creates the qgroup id in the filesystem of path
+func (u *BtrfsUtil) QGroupCreate(ctx context.Context, id, path string) error {
+	_, err := u.run(ctx, "btrfs", "qgroup", "create", id, path)
+	return err
+}
+
+// QGroupAssign makes the qgroup child a member of the qgroup parent, the
+// usage of child is then accounted in parent and limited by its limit
+func (u *BtrfsUtil) QGroupAssign(ctx context.Context, child, parent, path string) error {
+	_, err := u.run(ctx, "btrfs", "qgroup", "assign", child, parent, path)
+	return err
+}
+
+// QGroupLimitGroup limit size on the qgroup id
+func (u *BtrfsUtil) QGroupLimitGroup(ctx context.Context, size uint64, id, path string) error {
+	limit := "none"
+	if size > 0 {
+		limit = fmt.Sprint(size)
+	}
+
+	_, err := u.run(ctx, "btrfs", "qgroup", "limit", limit, id, path)
+	return err
+}
+
 // QGroupDestroy deletes a qgroup on a subvol
 func (u *BtrfsUtil) QGroupDestroy(ctx context.Context, id, path string) error {
 	_, err := u.run(ctx, "btrfs", "qgroup", "destroy", id, path)
@@ -329,6 +434,35 @@ func parseDevices(lines []string) ([]BtrfsDevice, error) {
 	return devs, nil
 }
 
+func parseDeviceStats(output string) []BtrfsDeviceStats {
+	var stats []BtrfsDeviceStats
+	index := make(map[string]int)
+	for _, line := range reBtrfsDeviceStats.FindAllStringSubmatch(output, -1) {
+		i, ok := index[line[1]]
+		if !ok {
+			i = len(stats)
+			index[line[1]] = i
+			stats = append(stats, BtrfsDeviceStats{Device: line[1]})
+		}
+
+		value, _ := strconv.ParseUint(line[3], 10, 64)
+		switch line[2] {
+		case "write_io_errs":
+			stats[i].WriteErrors = value
+		case "read_io_errs":
+			stats[i].ReadErrors = value
+		case "flush_io_errs":
+			stats[i].FlushErrors = value
+		case "corruption_errs":
+			stats[i].CorruptionErrors = value
+		case "generation_errs":
+			stats[i].GenerationErrors = value
+		}
+	}
+
+	return stats
+}
+
 func parseQGroups(output string) map[string]BtrfsQGroup {
 	qgroups := make(map[string]BtrfsQGroup)
 	for _, line := range reBtrfsQgroup.FindAllStringSubmatch(output, -1) {
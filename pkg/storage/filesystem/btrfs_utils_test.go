@@ -360,3 +360,57 @@ func TestBtrfsQGroupUnLimit(t *testing.T) {
 	err := utils.QGroupLimit(context.Background(), 0, "/tmp/root/subvol1")
 	require.NoError(err)
 }
+
+func TestParseDeviceStats(t *testing.T) {
+	output := `[/dev/sda].write_io_errs    0
+[/dev/sda].read_io_errs     2
+[/dev/sda].flush_io_errs    0
+[/dev/sda].corruption_errs  1
+[/dev/sda].generation_errs  0
+[/dev/sdb].write_io_errs    4
+[/dev/sdb].read_io_errs     0
+[/dev/sdb].flush_io_errs    0
+[/dev/sdb].corruption_errs  0
+[/dev/sdb].generation_errs  0
+`
+	stats := parseDeviceStats(output)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, BtrfsDeviceStats{Device: "/dev/sda", ReadErrors: 2, CorruptionErrors: 1}, stats[0])
+	assert.Equal(t, uint64(3), stats[0].Errors())
+	assert.Equal(t, "/dev/sdb", stats[1].Device)
+	assert.Equal(t, uint64(4), stats[1].WriteErrors)
+}
+
+func TestBtrfsQGroupOwner(t *testing.T) {
+	require := require.New(t)
+
+	var exec TestExecuter
+	utils := newUtils(&exec)
+
+	exec.On("run", mock.Anything, "btrfs", "qgroup", "create", "1/7", "/tmp/root").
+		Return([]byte{}, nil)
+	exec.On("run", mock.Anything, "btrfs", "qgroup", "assign", "0/260", "1/7", "/tmp/root").
+		Return([]byte{}, nil)
+	exec.On("run", mock.Anything, "btrfs", "qgroup", "limit", "1024", "1/7", "/tmp/root").
+		Return([]byte{}, nil)
+
+	ctx := context.Background()
+	require.NoError(utils.QGroupCreate(ctx, "1/7", "/tmp/root"))
+	require.NoError(utils.QGroupAssign(ctx, "0/260", "1/7", "/tmp/root"))
+	require.NoError(utils.QGroupLimitGroup(ctx, 1024, "1/7", "/tmp/root"))
+	exec.AssertExpectations(t)
+}
+
+func TestBtrfsRescueZeroLog(t *testing.T) {
+	require := require.New(t)
+
+	var exec TestExecuter
+	utils := newUtils(&exec)
+
+	exec.On("run", mock.Anything, "btrfs", "rescue", "zero-log", "/dev/sda").
+		Return([]byte{}, nil)
+
+	require.NoError(utils.RescueZeroLog(context.Background(), "/dev/sda"))
+	exec.AssertExpectations(t)
+}
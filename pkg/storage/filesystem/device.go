@@ -49,6 +49,9 @@ type Device struct {
 	Label      string         `json:"label"`
 	Filesystem FSType         `json:"fstype"`
 	Children   []Device       `json:"children"`
+	WWN        string         `json:"wwn"`
+	Serial     string         `json:"serial"`
+	PartUUID   string         `json:"partuuid"`
 	DiskType   pkg.DeviceType `json:"-"`
 	ReadTime   uint64         `json:"-"`
 	//HasPartions is different from children, because once the
@@ -63,6 +66,44 @@ func (d *Device) Used() bool {
 	return len(d.Label) != 0 || len(d.Filesystem) != 0 || len(d.Children) > 0 || d.HasPartions
 }
 
+// ID is the identity of the device which survives reboots, unlike its path
+// which depends on the order the kernel finds the disks in. It is empty
+// if the device reports neither a WWN nor a serial
+func (d *Device) ID() string {
+	// the partitions report the WWN and serial of their disk
+	if d.Type == "part" {
+		if d.PartUUID != "" {
+			return "partuuid-" + d.PartUUID
+		}
+		return ""
+	}
+
+	if d.WWN != "" {
+		return "wwn-" + d.WWN
+	}
+
+	if d.Serial != "" {
+		return "serial-" + d.Serial
+	}
+
+	return ""
+}
+
+// ByID returns the device with the given identity
+func (c DeviceCache) ByID(id string) (*Device, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	for idx := range c {
+		if c[idx].ID() == id {
+			return &c[idx], true
+		}
+	}
+
+	return nil, false
+}
+
 // lsblkDeviceManager uses the lsblk utility to scann the disk for devices, and
 // caches the result.
 //
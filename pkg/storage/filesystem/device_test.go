@@ -59,3 +59,26 @@ func TestDeviceManagerScan(t *testing.T) {
 	require.Equal("/tmp/dev1", cached[0].Path)
 
 }
+
+func TestDeviceID(t *testing.T) {
+	require := require.New(t)
+
+	devices := DeviceCache{
+		{Type: "disk", Path: "/dev/sda", WWN: "0x5000c500a1b2c3d4", Serial: "Z1D2"},
+		{Type: "disk", Path: "/dev/sdb", Serial: "S3Z9NB0K"},
+		{Type: "part", Path: "/dev/sdb1", Serial: "S3Z9NB0K", PartUUID: "c0ffee00-01"},
+		{Type: "disk", Path: "/dev/vda"},
+	}
+
+	require.Equal("wwn-0x5000c500a1b2c3d4", devices[0].ID())
+	require.Equal("serial-S3Z9NB0K", devices[1].ID())
+	require.Equal("partuuid-c0ffee00-01", devices[2].ID())
+	require.Equal("", devices[3].ID())
+
+	device, ok := devices.ByID("serial-S3Z9NB0K")
+	require.True(ok)
+	require.Equal("/dev/sdb", device.Path)
+
+	_, ok = devices.ByID("")
+	require.False(ok)
+}
@@ -0,0 +1,188 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// TmpfsFSType is the type of the volumes of a tmpfs pool
+	TmpfsFSType = "tmpfs"
+)
+
+// tmpfsPool is a pool of tmpfs volumes mounted in the directories of
+// its path. It has no device, its size is the memory its volumes
+// can use, and its volumes are lost on reboot
+type tmpfsPool struct {
+	path string
+	size uint64
+}
+
+// NewTmpfsPool creates a pool of tmpfs volumes in path, the volumes
+// can use at most size bytes of memory all together
+func NewTmpfsPool(path string, size uint64) (Pool, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &tmpfsPool{path: filepath.Clean(path), size: size}, nil
+}
+
+func (p *tmpfsPool) ID() int        { return 0 }
+func (p *tmpfsPool) Path() string   { return p.path }
+func (p *tmpfsPool) Name() string   { return "memory" }
+func (p *tmpfsPool) FsType() string { return TmpfsFSType }
+
+func (p *tmpfsPool) Type() pkg.DeviceType { return pkg.MemoryDevice }
+
+// Usage is the memory used by the volumes of the pool
+func (p *tmpfsPool) Usage() (usage Usage, err error) {
+	volumes, err := p.Volumes()
+	if err != nil {
+		return usage, err
+	}
+
+	usage.Size = p.size
+	for _, volume := range volumes {
+		volumeUsage, err := volume.Usage()
+		if err != nil {
+			return usage, err
+		}
+		usage.Used += volumeUsage.Used
+	}
+
+	return usage, nil
+}
+
+// Limit changes the memory the volumes of the pool can use
+func (p *tmpfsPool) Limit(size uint64) error {
+	p.size = size
+	return nil
+}
+
+// Reserved is the sum of the sizes of the volumes
+func (p *tmpfsPool) Reserved() (uint64, error) {
+	volumes, err := p.Volumes()
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, volume := range volumes {
+		usage, err := volume.Usage()
+		if err != nil {
+			return 0, err
+		}
+		total += usage.Size
+	}
+
+	return total, nil
+}
+
+func (p *tmpfsPool) Mounted() (string, bool) { return p.path, true }
+func (p *tmpfsPool) Mount() (string, error)  { return p.path, nil }
+func (p *tmpfsPool) Maintenance() error      { return nil }
+func (p *tmpfsPool) Devices() []*Device      { return nil }
+
+func (p *tmpfsPool) UnMount() error {
+	return fmt.Errorf("a tmpfs pool can't be unmounted")
+}
+
+func (p *tmpfsPool) AddDevice(device *Device) error {
+	return fmt.Errorf("a tmpfs pool has no device")
+}
+
+func (p *tmpfsPool) RemoveDevice(device *Device) error {
+	return fmt.Errorf("a tmpfs pool has no device")
+}
+
+// Volumes are the tmpfs mounted in the directories of the pool
+func (p *tmpfsPool) Volumes() ([]Volume, error) {
+	entries, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []Volume
+	for _, entry := range entries {
+		path := filepath.Join(p.path, entry.Name())
+		// the pool directory also holds the metadata of the
+		// volumes, only the mounted directories are volumes
+		if !entry.IsDir() || !IsMountPoint(path) {
+			continue
+		}
+
+		volumes = append(volumes, &tmpfsVolume{path: path})
+	}
+
+	return volumes, nil
+}
+
+// AddVolume mounts a new tmpfs, it can't hold anything until it is limited
+func (p *tmpfsPool) AddVolume(name string) (Volume, error) {
+	path := filepath.Join(p.path, name)
+	if filepath.Dir(path) != p.path {
+		return nil, fmt.Errorf("invalid volume name '%s'", name)
+	}
+
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+
+	// a size of 0 means no limit for tmpfs
+	if err := unix.Mount(TmpfsFSType, path, TmpfsFSType, unix.MS_NOSUID|unix.MS_NODEV, "size=4k,mode=0755"); err != nil {
+		_ = os.Remove(path)
+		return nil, errors.Wrapf(err, "failed to mount tmpfs volume %s", name)
+	}
+
+	return &tmpfsVolume{path: path}, nil
+}
+
+// RemoveVolume unmounts the tmpfs, its content is lost
+func (p *tmpfsPool) RemoveVolume(name string) error {
+	path := filepath.Join(p.path, name)
+	if filepath.Dir(path) != p.path {
+		return fmt.Errorf("invalid volume name '%s'", name)
+	}
+
+	if err := unix.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+		return errors.Wrapf(err, "failed to unmount tmpfs volume %s", name)
+	}
+
+	return os.Remove(path)
+}
+
+type tmpfsVolume struct {
+	path string
+}
+
+func (v *tmpfsVolume) ID() int        { return 0 }
+func (v *tmpfsVolume) Path() string   { return v.path }
+func (v *tmpfsVolume) Name() string   { return filepath.Base(v.path) }
+func (v *tmpfsVolume) FsType() string { return TmpfsFSType }
+
+func (v *tmpfsVolume) Usage() (usage Usage, err error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(v.path, &stat); err != nil {
+		return usage, err
+	}
+
+	usage.Size = stat.Blocks * uint64(stat.Bsize)
+	usage.Used = (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	return usage, nil
+}
+
+// Limit resizes the tmpfs, it fails if the content doesn't fit in size
+func (v *tmpfsVolume) Limit(size uint64) error {
+	if size == 0 {
+		return fmt.Errorf("a tmpfs volume must be limited")
+	}
+
+	return unix.Mount(TmpfsFSType, v.path, TmpfsFSType, unix.MS_REMOUNT|unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%d", size))
+}
@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+
+	"github.com/pkg/errors"
 )
 
 func getMountTarget(f io.Reader, device string) (string, bool) {
@@ -110,3 +112,25 @@ func run(ctx context.Context, name string, args ...string) ([]byte, error) {
 
 	return output, nil
 }
+
+// SetCompression sets the compression algorithm of the files
+// created under path. An empty algorithm disables compression
+func SetCompression(ctx context.Context, path, algorithm string) error {
+	if algorithm == "" {
+		algorithm = "none"
+	}
+
+	if _, err := run(ctx, "btrfs", "property", "set", path, "compression", algorithm); err != nil {
+		return errors.Wrapf(err, "failed to set compression of %s", path)
+	}
+	return nil
+}
+
+// DisableCOW disables copy on write for the files created
+// under path. It only works on an empty directory
+func DisableCOW(ctx context.Context, path string) error {
+	if _, err := run(ctx, "chattr", "+C", path); err != nil {
+		return errors.Wrapf(err, "failed to disable copy on write of %s", path)
+	}
+	return nil
+}
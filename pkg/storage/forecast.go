@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	forecastInterval = 15 * time.Minute
+	// forecastHorizon is how long before a pool is predicted
+	// to be full the farmer is warned
+	forecastHorizon = 7 * 24 * time.Hour
+	// forecastSmoothing is the weight of the last sample in the growth
+	// average, a low weight ignores the short bursts of writes
+	forecastSmoothing = 0.1
+)
+
+// usageTrend is the exponentially weighted moving average
+// of the usage growth of a pool
+type usageTrend struct {
+	used    uint64
+	at      time.Time
+	growth  float64
+	samples int
+}
+
+// update adds the usage sample taken at the given time to the trend
+func (t *usageTrend) update(used uint64, at time.Time) {
+	if t.samples > 0 && at.After(t.at) {
+		rate := (float64(used) - float64(t.used)) / at.Sub(t.at).Hours()
+		if t.samples == 1 {
+			t.growth = rate
+		} else {
+			t.growth = forecastSmoothing*rate + (1-forecastSmoothing)*t.growth
+		}
+	}
+
+	t.used = used
+	t.at = at
+	t.samples++
+}
+
+// forecast predicts when a pool of the given size is full
+func (t *usageTrend) forecast(size uint64) (full time.Time, warning bool) {
+	// the trend needs at least 2 samples to have a growth
+	if t.samples < 2 || t.growth <= 0 {
+		return full, false
+	}
+
+	free := float64(0)
+	if size > t.used {
+		free = float64(size - t.used)
+	}
+
+	left := time.Duration(free / t.growth * float64(time.Hour))
+	full = t.at.Add(left)
+	return full, left < forecastHorizon
+}
+
+// WatchUsage samples the usage of the storage pools until ctx is canceled
+// to forecast when they are full. A warning is logged for the pools
+// predicted to be full soon
+func WatchUsage(ctx context.Context, module pkg.StorageModule) {
+	s, ok := module.(*storageModule)
+	if !ok {
+		log.Error().Msg("usage forecast not supported by this storage module")
+		return
+	}
+
+	for {
+		s.sampleUsage()
+
+		for _, forecast := range s.forecast() {
+			if forecast.Warning {
+				log.Warn().
+					Str("alert", "storage").
+					Str("pool", forecast.Pool).
+					Time("full", forecast.Full).
+					Float64("growth", forecast.Growth).
+					Msg("storage pool predicted to be full soon, more disks are needed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(forecastInterval):
+		}
+	}
+}
+
+func (s *storageModule) sampleUsage() {
+	s.mu.RLock()
+	pools := s.volumes
+	s.mu.RUnlock()
+
+	now := time.Now()
+
+	s.trendsMu.Lock()
+	defer s.trendsMu.Unlock()
+
+	if s.trends == nil {
+		s.trends = make(map[string]*usageTrend)
+	}
+
+	for _, pool := range pools {
+		if _, mounted := pool.Mounted(); !mounted {
+			continue
+		}
+
+		usage, err := pool.Usage()
+		if err != nil {
+			log.Error().Err(err).Str("pool", pool.Name()).Msg("failed to get pool usage")
+			continue
+		}
+
+		trend, ok := s.trends[pool.Name()]
+		if !ok {
+			trend = &usageTrend{}
+			s.trends[pool.Name()] = trend
+		}
+		trend.update(usage.Used, now)
+	}
+}
+
+// Forecast implements pkg.StorageModule
+func (s *storageModule) Forecast() ([]pkg.PoolForecast, error) {
+	return s.forecast(), nil
+}
+
+// forecast returns the usage trend of the pools with enough samples
+func (s *storageModule) forecast() []pkg.PoolForecast {
+	s.mu.RLock()
+	pools := s.volumes
+	s.mu.RUnlock()
+
+	s.trendsMu.Lock()
+	defer s.trendsMu.Unlock()
+
+	var result []pkg.PoolForecast
+	for _, pool := range pools {
+		trend, ok := s.trends[pool.Name()]
+		if !ok {
+			continue
+		}
+
+		usage, err := pool.Usage()
+		if err != nil {
+			continue
+		}
+
+		full, warning := trend.forecast(usage.Size)
+		result = append(result, pkg.PoolForecast{
+			Pool:     pool.Name(),
+			DiskType: pool.Type(),
+			Size:     usage.Size,
+			Used:     trend.used,
+			Growth:   trend.growth,
+			Full:     full,
+			Warning:  warning,
+		})
+	}
+
+	return result
+}
+
+// ForecastWarnings implements pkg.StorageModule
+func (s *storageModule) ForecastWarnings(ctx context.Context) <-chan pkg.PoolForecast {
+	ch := make(chan pkg.PoolForecast)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(forecastInterval):
+			}
+
+			for _, forecast := range s.forecast() {
+				if !forecast.Warning {
+					continue
+				}
+
+				select {
+				case ch <- forecast:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageTrend(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	start := time.Now()
+
+	var trend usageTrend
+	trend.update(10*gib, start)
+	_, warning := trend.forecast(100 * gib)
+	assert.False(t, warning)
+
+	// 1 GiB per hour, 90 GiB left
+	trend.update(11*gib, start.Add(time.Hour))
+	assert.Equal(t, float64(gib), trend.growth)
+	full, warning := trend.forecast(100 * gib)
+	assert.True(t, warning)
+	assert.Equal(t, start.Add(90*time.Hour), full)
+
+	// a burst barely moves the trend
+	trend.update(21*gib, start.Add(2*time.Hour))
+	assert.InDelta(t, 1.9*gib, trend.growth, 1)
+
+	// shrinking usage never fills the pool
+	trend = usageTrend{}
+	trend.update(20*gib, start)
+	trend.update(10*gib, start.Add(time.Hour))
+	full, warning = trend.forecast(100 * gib)
+	assert.False(t, warning)
+	assert.True(t, full.IsZero())
+
+	// the growth is slow enough
+	trend = usageTrend{}
+	trend.update(10*gib, start)
+	trend.update(10*gib+gib/1000, start.Add(time.Hour))
+	_, warning = trend.forecast(100 * gib)
+	assert.False(t, warning)
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/tracing"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// forensicDir is where the volumes under forensic
+	// examination are mounted
+	forensicDir = "/var/run/forensic"
+	// forensicMaxDuration is the longest a volume can stay mounted,
+	// a longer examination needs a new mount with its own reason
+	forensicMaxDuration = 24 * time.Hour
+)
+
+// ForensicMount implements pkg.StorageModule
+func (s *storageModule) ForensicMount(name, reason string, duration time.Duration) (target string, err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("ForensicMount", name)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("ForensicMount", audit.Caller(ctx), name, []interface{}{name, reason, duration.String()}, err)
+	}()
+
+	if reason == "" {
+		return "", fmt.Errorf("a reason is required to examine a volume")
+	}
+
+	if duration <= 0 || duration > forensicMaxDuration {
+		return "", fmt.Errorf("invalid duration %s, it must be at most %s", duration, forensicMaxDuration)
+	}
+
+	s.mu.RLock()
+	_, volume, err := s.findVolume(name)
+	s.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+
+	s.forensicMu.Lock()
+	defer s.forensicMu.Unlock()
+
+	if _, ok := s.forensic[name]; ok {
+		return "", fmt.Errorf("volume %s is already under forensic examination", name)
+	}
+
+	target = filepath.Join(forensicDir, name)
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return "", err
+	}
+
+	if err := mountReadOnly(volume.Path(), target); err != nil {
+		_ = os.Remove(target)
+		return "", errors.Wrapf(err, "failed to mount volume %s", name)
+	}
+
+	if s.forensic == nil {
+		s.forensic = make(map[string]*time.Timer)
+	}
+	s.forensic[name] = time.AfterFunc(duration, func() {
+		if err := s.forensicUnmount(name, audit.System); err != nil {
+			log.Error().Err(err).Str("volume", name).Msg("failed to end expired forensic examination")
+		}
+	})
+
+	log.Warn().Str("volume", name).Str("reason", reason).Str("path", target).Dur("duration", duration).Msg("volume mounted for forensic examination")
+	return target, nil
+}
+
+// ForensicUnmount implements pkg.StorageModule
+func (s *storageModule) ForensicUnmount(name string) (err error) {
+	ctx, span := tracing.Serve("ForensicUnmount", name)
+	defer func() { span.Finish(err) }()
+
+	return s.forensicUnmount(name, audit.Caller(ctx))
+}
+
+// forensicUnmount ends the examination of volume name, the operation is
+// recorded for caller
+func (s *storageModule) forensicUnmount(name, caller string) (err error) {
+	defer func() {
+		s.audit.Record("ForensicUnmount", caller, name, name, err)
+	}()
+
+	s.forensicMu.Lock()
+	defer s.forensicMu.Unlock()
+
+	timer, ok := s.forensic[name]
+	if !ok {
+		return errors.Wrapf(os.ErrNotExist, "volume %s is not under forensic examination", name)
+	}
+
+	target := filepath.Join(forensicDir, name)
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil {
+		return errors.Wrapf(err, "failed to unmount %s", target)
+	}
+
+	timer.Stop()
+	delete(s.forensic, name)
+
+	log.Info().Str("volume", name).Msg("forensic examination ended")
+	return os.Remove(target)
+}
+
+func (s *storageModule) forensicMounted(name string) bool {
+	s.forensicMu.Lock()
+	defer s.forensicMu.Unlock()
+
+	_, ok := s.forensic[name]
+	return ok
+}
+
+// mountReadOnly bind mounts source read-only on target. A bind mount
+// ignores the read-only flag so it must be remounted to apply it
+func mountReadOnly(source, target string) error {
+	if err := unix.Mount(source, target, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	if err := unix.Mount("", target, "", flags, ""); err != nil {
+		_ = unix.Unmount(target, unix.MNT_DETACH)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+func TestForensicMountValidation(t *testing.T) {
+	s := &storageModule{inflight: &utils.InFlight{}}
+
+	_, err := s.ForensicMount("1-1", "", time.Hour)
+	assert.Error(t, err)
+
+	_, err = s.ForensicMount("1-1", "court order", 2*forensicMaxDuration)
+	assert.Error(t, err)
+
+	_, err = s.ForensicMount("1-1", "court order", time.Hour)
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+
+	err = s.ForensicUnmount("1-1")
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+	assert.False(t, s.forensicMounted("1-1"))
+}
@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/capacity/smartctl"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	healthInterval = 30 * time.Minute
+
+	// migratePrefix is the name prefix of the snapshots
+	// used to move a volume between pools
+	migratePrefix = ".migrate-"
+)
+
+// the SMART attributes that announce a disk failure, when their raw
+// value grows the disk is running out of spare sectors
+var smartWatched = map[int]string{
+	5:   "reallocated sectors",
+	187: "reported uncorrectable errors",
+	188: "command timeouts",
+	197: "pending sectors",
+	198: "offline uncorrectable sectors",
+}
+
+// diskSample is the state of a disk at a health check
+type diskSample struct {
+	smart  map[int]uint64
+	errors uint64
+}
+
+// diskHealth is the health of a disk together with the sample
+// it was computed from, to compare it with the next one
+type diskHealth struct {
+	pkg.DiskHealth
+	sample diskSample
+}
+
+// WatchDisks checks the health of the disks of the storage pools until ctx
+// is canceled. The volumes of pools with a failing disk are moved to
+// healthy pools of the same type
+func WatchDisks(ctx context.Context, module pkg.StorageModule) {
+	s, ok := module.(*storageModule)
+	if !ok {
+		log.Error().Msg("disk health checks not supported by this storage module")
+		return
+	}
+
+	for {
+		s.checkDisks(ctx)
+
+		for _, pool := range s.drainingPools() {
+			if err := s.drain(ctx, pool); err != nil {
+				log.Error().Err(err).Str("pool", pool.Name()).Msg("failed to move volumes off failing pool")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthInterval):
+		}
+	}
+}
+
+// DisksHealth implements pkg.StorageModule
+func (s *storageModule) DisksHealth() ([]pkg.DiskHealth, error) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	var result []pkg.DiskHealth
+	for _, disk := range s.health {
+		result = append(result, disk.DiskHealth)
+	}
+
+	return result, nil
+}
+
+func (s *storageModule) checkDisks(ctx context.Context) {
+	utils := filesystem.NewUtils()
+
+	s.mu.RLock()
+	pools := s.volumes
+	s.mu.RUnlock()
+
+	for _, pool := range pools {
+		errs := make(map[string]uint64)
+		if _, mounted := pool.Mounted(); mounted {
+			stats, err := utils.DeviceStats(ctx, pool.Path())
+			if err != nil {
+				log.Error().Err(err).Str("pool", pool.Name()).Msg("failed to get device errors")
+			}
+			for _, stat := range stats {
+				errs[stat.Device] = stat.Errors()
+			}
+		}
+
+		for _, device := range pool.Devices() {
+			sample := diskSample{errors: errs[device.Path]}
+
+			var attributes []smartctl.Attribute
+			if smartctl.Supported(device.Path) {
+				var err error
+				attributes, err = smartctl.DeviceAttributes(smartctl.Device{Path: device.Path, Type: "auto"})
+				if err != nil {
+					log.Debug().Err(err).Str("device", device.Path).Msg("failed to read SMART attributes")
+				}
+			}
+
+			s.updateHealth(pool.Name(), device.Path, sample, attributes)
+		}
+	}
+}
+
+// updateHealth classifies the disk at path from its current sample and
+// attributes, compared to the previous check. A disk never goes back to
+// healthy, the degradation of a disk is not temporary
+func (s *storageModule) updateHealth(pool, path string, sample diskSample, attributes []smartctl.Attribute) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	previous, known := s.health[path]
+	state := pkg.DiskHealthy
+	var reasons []string
+	if known {
+		state = previous.State
+		reasons = append(reasons, previous.Reasons...)
+	}
+
+	degrade := func(to pkg.DiskHealthState, reason string) {
+		if state != pkg.DiskFailed {
+			state = to
+		}
+		for _, known := range reasons {
+			if known == reason {
+				return
+			}
+		}
+		reasons = append(reasons, reason)
+	}
+
+	sample.smart = make(map[int]uint64)
+	for _, attr := range attributes {
+		if attr.Failed || (attr.Threshold > 0 && attr.Value <= attr.Threshold) {
+			degrade(pkg.DiskFailed, fmt.Sprintf("%s below failure threshold", attr.Name))
+			continue
+		}
+
+		name, ok := smartWatched[attr.ID]
+		if !ok {
+			continue
+		}
+		sample.smart[attr.ID] = attr.Raw
+		if known && attr.Raw > previous.sample.smart[attr.ID] {
+			degrade(pkg.DiskFailingSoon, fmt.Sprintf("%s grew from %d to %d", name, previous.sample.smart[attr.ID], attr.Raw))
+		}
+	}
+
+	if known && sample.errors > previous.sample.errors {
+		degrade(pkg.DiskFailingSoon, fmt.Sprintf("btrfs errors grew from %d to %d", previous.sample.errors, sample.errors))
+	}
+
+	if !known || state != previous.State {
+		event := log.Info()
+		if state != pkg.DiskHealthy {
+			event = log.Error().Str("alert", "disk")
+		}
+		event.Str("device", path).Str("pool", pool).Str("state", string(state)).Strs("reasons", reasons).Msg("disk health")
+	}
+	if known && state != previous.State {
+		s.audit.Record("DiskHealth", audit.System, path, reasons, fmt.Errorf("disk is %s", state))
+	}
+
+	if s.health == nil {
+		s.health = make(map[string]*diskHealth)
+	}
+	s.health[path] = &diskHealth{
+		DiskHealth: pkg.DiskHealth{
+			Path:    path,
+			Pool:    pool,
+			State:   state,
+			Reasons: reasons,
+			Checked: time.Now(),
+		},
+		sample: sample,
+	}
+}
+
+// draining returns true if pool has an unhealthy disk, such pools
+// don't get new allocations
+func (s *storageModule) draining(pool filesystem.Pool) bool {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	for _, disk := range s.health {
+		if disk.Pool == pool.Name() && disk.State != pkg.DiskHealthy {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *storageModule) drainingPools() []filesystem.Pool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pools []filesystem.Pool
+	for _, pool := range s.volumes {
+		if s.draining(pool) {
+			pools = append(pools, pool)
+		}
+	}
+
+	return pools
+}
+
+// drain moves the volumes of pool which are not in use to a healthy pool.
+// The volumes in use are moved at a later check, once they are released
+func (s *storageModule) drain(ctx context.Context, pool filesystem.Pool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	volumes, err := pool.Volumes()
+	if err != nil {
+		return errors.Wrap(err, "failed to list volumes")
+	}
+
+	for _, volume := range volumes {
+		meta, err := readMeta(pool, volume.Name())
+		if err != nil {
+			return err
+		}
+
+		// the other kinds are used through open files
+		// that can't be detected
+		if meta.Kind != pkg.VolumeKindVolume && meta.Kind != pkg.VolumeKindZDB {
+			continue
+		}
+
+		busy, err := volumeMounted(pool, volume)
+		if err != nil {
+			return err
+		}
+		if busy {
+			log.Info().Str("volume", volume.Name()).Msg("volume in use, it will be moved once released")
+			continue
+		}
+
+		if err := s.moveVolume(ctx, pool, volume, meta); err != nil {
+			log.Error().Err(err).Str("volume", volume.Name()).Msg("failed to move volume off failing pool")
+			continue
+		}
+
+		log.Info().Str("volume", volume.Name()).Str("pool", pool.Name()).Msg("volume moved off failing pool")
+	}
+
+	return nil
+}
+
+// moveVolume copies volume to a healthy pool then removes it from pool
+func (s *storageModule) moveVolume(ctx context.Context, pool filesystem.Pool, volume filesystem.Volume, meta volumeMeta) error {
+	usage, err := volume.Usage()
+	if err != nil {
+		return errors.Wrap(err, "failed to get volume usage")
+	}
+
+	var target filesystem.Pool
+	for _, candidate := range s.volumes {
+		if candidate.Type() != pool.Type() || s.draining(candidate) {
+			continue
+		}
+		if _, mounted := candidate.Mounted(); !mounted {
+			continue
+		}
+
+		total, err := candidate.Usage()
+		if err != nil {
+			continue
+		}
+		reserved, err := candidate.Reserved()
+		if err != nil || reserved+usage.Size > total.Size {
+			continue
+		}
+
+		target = candidate
+		break
+	}
+
+	if target == nil {
+		return pkg.ErrNotEnoughSpace{DeviceType: pool.Type()}
+	}
+
+	utils := filesystem.NewUtils()
+	snapshot := filepath.Join(pool.Path(), migratePrefix+volume.Name())
+	received := filepath.Join(target.Path(), migratePrefix+volume.Name())
+
+	if err := utils.SubvolumeSnapshot(ctx, volume.Path(), snapshot, true); err != nil {
+		return errors.Wrap(err, "failed to snapshot volume")
+	}
+	defer utils.SubvolumeRemove(ctx, snapshot)
+
+	if err := utils.SubvolumeCopy(ctx, snapshot, target.Path()); err != nil {
+		return err
+	}
+	defer utils.SubvolumeRemove(ctx, received)
+
+	if err := utils.SubvolumeSnapshot(ctx, received, filepath.Join(target.Path(), volume.Name()), false); err != nil {
+		return errors.Wrap(err, "failed to create volume on target pool")
+	}
+
+	moved, err := findPoolVolume(target, volume.Name())
+	if err != nil {
+		return err
+	}
+
+	if volumeKinds[meta.Kind].quota {
+		if err := moved.Limit(usage.Size); err != nil {
+			target.RemoveVolume(moved.Name())
+			return errors.Wrap(err, "failed to limit moved volume")
+		}
+	}
+
+	if err := writeMeta(target, volume.Name(), meta); err != nil {
+		target.RemoveVolume(moved.Name())
+		return err
+	}
+
+	if err := s.assignOwner(target, moved, meta); err != nil {
+		log.Error().Err(err).Str("volume", volume.Name()).Msg("failed to account moved volume to its user")
+	}
+
+	if err := pool.RemoveVolume(volume.Name()); err != nil {
+		return errors.Wrap(err, "volume copied but failed to remove it from failing pool")
+	}
+
+	return removeMeta(pool, volume.Name())
+}
+
+func findPoolVolume(pool filesystem.Pool, name string) (filesystem.Volume, error) {
+	volumes, err := pool.Volumes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volume := range volumes {
+		if volume.Name() == name {
+			return volume, nil
+		}
+	}
+
+	return nil, errors.Wrapf(os.ErrNotExist, "subvolume '%s' not found", name)
+}
+
+// volumeMounted checks if the volume is mounted in any mount namespace.
+// A bind mount of a subvolume shows the subvolume path as its root in
+// the mountinfo of the processes using it
+func volumeMounted(pool filesystem.Pool, volume filesystem.Volume) (bool, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(pool.Path(), &stat); err != nil {
+		return false, errors.Wrapf(err, "failed to stat pool %s", pool.Name())
+	}
+	device := fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Dev)), unix.Minor(uint64(stat.Dev)))
+	root := "/" + volume.Name()
+
+	files, err := filepath.Glob("/proc/[0-9]*/mountinfo")
+	if err != nil {
+		return false, err
+	}
+
+	// many processes share the same mount namespace
+	seen := make(map[string]struct{})
+	for _, file := range files {
+		ns, err := os.Readlink(filepath.Join(filepath.Dir(file), "ns", "mnt"))
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[ns]; ok {
+			continue
+		}
+		seen[ns] = struct{}{}
+
+		mounted, err := mountinfoHas(file, device, root)
+		if err != nil {
+			// the process is gone
+			continue
+		}
+		if mounted {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func mountinfoHas(path, device, root string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 0:42 /12-1 /mnt/data rw,relatime - btrfs /dev/sda rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[2] != device {
+			continue
+		}
+		if fields[3] == root || strings.HasPrefix(fields[3], root+"/") {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
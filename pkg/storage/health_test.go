@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity/smartctl"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+func TestUpdateHealth(t *testing.T) {
+	var s storageModule
+
+	disksHealth := func() []pkg.DiskHealth {
+		health, err := s.DisksHealth()
+		require.NoError(t, err)
+		return health
+	}
+
+	reallocated := func(raw uint64) []smartctl.Attribute {
+		return []smartctl.Attribute{
+			{ID: 5, Name: "Reallocated_Sector_Ct", Value: 100, Threshold: 36, Raw: raw},
+		}
+	}
+
+	s.updateHealth("pool", "/dev/sda", diskSample{}, reallocated(8))
+	require.Len(t, disksHealth(), 1)
+	assert.Equal(t, pkg.DiskHealthy, disksHealth()[0].State)
+
+	// a stable count of reallocated sectors is fine
+	s.updateHealth("pool", "/dev/sda", diskSample{}, reallocated(8))
+	assert.Equal(t, pkg.DiskHealthy, disksHealth()[0].State)
+
+	s.updateHealth("pool", "/dev/sda", diskSample{}, reallocated(12))
+	health := disksHealth()[0]
+	assert.Equal(t, pkg.DiskFailingSoon, health.State)
+	assert.Len(t, health.Reasons, 1)
+
+	// the disk doesn't recover
+	s.updateHealth("pool", "/dev/sda", diskSample{}, reallocated(12))
+	assert.Equal(t, pkg.DiskFailingSoon, disksHealth()[0].State)
+
+	failed := reallocated(20)
+	failed[0].Value = 30
+	s.updateHealth("pool", "/dev/sda", diskSample{}, failed)
+	assert.Equal(t, pkg.DiskFailed, disksHealth()[0].State)
+}
+
+func TestUpdateHealthBtrfsErrors(t *testing.T) {
+	root, err := ioutil.TempDir("", "pool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	pool := &testPool{
+		name:  filepath.Base(root),
+		usage: filesystem.Usage{Size: 10000},
+		ptype: pkg.SSDDevice,
+	}
+	s := storageModule{volumes: []filesystem.Pool{pool}}
+
+	s.updateHealth(pool.name, "/dev/sda", diskSample{errors: 2}, nil)
+	assert.False(t, s.draining(pool))
+
+	s.updateHealth(pool.name, "/dev/sda", diskSample{errors: 3}, nil)
+	assert.True(t, s.draining(pool))
+
+	// no new volumes on the failing pool
+	_, err = s.createSubvol(100, "sub", pkg.SSDDevice, pkg.VolumeKindVolume)
+	assert.IsType(t, pkg.ErrNotEnoughSpace{}, err)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+// devicesFile is the file at the root of a pool recording
+// the identities of its devices
+const devicesFile = ".devices.json"
+
+func poolIdentities(pool filesystem.Pool) []pkg.DeviceIdentity {
+	var identities []pkg.DeviceIdentity
+	for _, device := range pool.Devices() {
+		identities = append(identities, pkg.DeviceIdentity{
+			ID:     device.ID(),
+			WWN:    device.WWN,
+			Serial: device.Serial,
+			Path:   device.Path,
+			Pool:   pool.Name(),
+		})
+	}
+
+	return identities
+}
+
+// recordDevices stores the identities of the devices of the mounted pool
+// in the pool, and reports the devices whose path changed since they
+// were recorded
+func recordDevices(pool filesystem.Pool) error {
+	path := filepath.Join(pool.Path(), devicesFile)
+
+	var recorded []pkg.DeviceIdentity
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			log.Error().Err(err).Str("pool", pool.Name()).Msg("invalid device identities, overwriting them")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	previous := make(map[string]pkg.DeviceIdentity)
+	for _, identity := range recorded {
+		previous[identity.ID] = identity
+	}
+
+	current := poolIdentities(pool)
+	for _, identity := range current {
+		if identity.ID == "" {
+			log.Warn().Str("pool", pool.Name()).Str("device", identity.Path).Msg("device has no stable identity")
+			continue
+		}
+
+		if old, ok := previous[identity.ID]; ok && old.Path != identity.Path {
+			log.Info().
+				Str("pool", pool.Name()).
+				Str("id", identity.ID).
+				Str("from", old.Path).
+				Str("to", identity.Path).
+				Msg("device path changed since last boot")
+		}
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to record devices of pool %s", pool.Name())
+	}
+
+	return nil
+}
+
+// DeviceIdentities implements pkg.StorageModule
+func (s *storageModule) DeviceIdentities() ([]pkg.DeviceIdentity, error) {
+	if err := s.throttle("DeviceIdentities"); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []pkg.DeviceIdentity
+	for _, pool := range s.volumes {
+		result = append(result, poolIdentities(pool)...)
+	}
+
+	// the devices of the broken pools are the ones
+	// to find when replacing a disk
+	for _, pool := range s.degraded {
+		result = append(result, poolIdentities(pool)...)
+	}
+
+	return result, nil
+}
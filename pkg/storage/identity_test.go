@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+func TestRecordDevices(t *testing.T) {
+	require := require.New(t)
+
+	pool := &testPool{
+		name: "identity-pool",
+		devices: []*filesystem.Device{
+			{Type: "disk", Path: "/dev/sdb", WWN: "0x5000c500a1b2c3d4"},
+			{Type: "disk", Path: "/dev/sdc", Serial: "S3Z9NB0K"},
+		},
+	}
+
+	require.NoError(os.MkdirAll(pool.Path(), 0755))
+	defer os.RemoveAll(pool.Path())
+
+	require.NoError(recordDevices(pool))
+
+	// the kernel found the disks in another order
+	pool.devices[0].Path, pool.devices[1].Path = "/dev/sdc", "/dev/sdb"
+	require.NoError(recordDevices(pool))
+
+	data, err := ioutil.ReadFile(filepath.Join(pool.Path(), devicesFile))
+	require.NoError(err)
+
+	var recorded []pkg.DeviceIdentity
+	require.NoError(json.Unmarshal(data, &recorded))
+	require.Equal([]pkg.DeviceIdentity{
+		{ID: "wwn-0x5000c500a1b2c3d4", WWN: "0x5000c500a1b2c3d4", Path: "/dev/sdc", Pool: "identity-pool"},
+		{ID: "serial-S3Z9NB0K", Serial: "S3Z9NB0K", Path: "/dev/sdb", Pool: "identity-pool"},
+	}, recorded)
+
+	mod := storageModule{volumes: []filesystem.Pool{pool}}
+	identities, err := mod.DeviceIdentities()
+	require.NoError(err)
+	require.Equal(recorded, identities)
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/download"
+)
+
+const (
+	// importPrefix is the name prefix of the images being downloaded,
+	// they are kept between attempts to resume the download
+	importPrefix = ".import-"
+)
+
+// qcow2Magic starts all qcow2 images
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// imageImport tracks the progress of an import, it is
+// updated by the download while ImportProgress reads it
+type imageImport struct {
+	url        string
+	downloaded int64
+	size       int64
+}
+
+func (i *imageImport) progress(id string) pkg.ImageImport {
+	return pkg.ImageImport{
+		ID:         id,
+		URL:        i.url,
+		Downloaded: atomic.LoadInt64(&i.downloaded),
+		Size:       atomic.LoadInt64(&i.size),
+	}
+}
+
+// ImportImage implements pkg.VDiskModule
+func (d *vdiskModule) ImportImage(source, sum, id string) (string, error) {
+	done, err := d.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	if strings.Contains(id, snapshotSeparator) {
+		return "", fmt.Errorf("invalid disk id: '%s'", id)
+	}
+
+	if expected, err := hex.DecodeString(sum); err != nil || len(expected) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 checksum '%s'", sum)
+	}
+
+	if u, err := url.Parse(source); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid image url '%s', only http and https are supported", source)
+	}
+
+	path, err := d.safePath(id)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return path, errors.Wrapf(os.ErrExist, "disk with id '%s' already exists", id)
+	}
+
+	partial, err := d.safePath(importPrefix + id)
+	if err != nil {
+		return "", err
+	}
+
+	progress, err := d.startImport(id, source)
+	if err != nil {
+		return "", err
+	}
+	defer d.endImport(id)
+
+	// the partial image is kept if the download fails, so the next import
+	// resumes it. It's removed if it doesn't match the checksum
+	err = download.Default().Download(context.Background(), partial, download.Request{
+		URL:      source,
+		Checksum: sum,
+		Progress: func(p download.Progress) {
+			atomic.StoreInt64(&progress.downloaded, p.Downloaded)
+			atomic.StoreInt64(&progress.size, p.Size)
+		},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to import image '%s'", source)
+	}
+
+	qcow2, err := isQcow2(partial)
+	if err != nil {
+		return "", err
+	}
+
+	if !qcow2 {
+		if err := os.Rename(partial, path); err != nil {
+			return "", err
+		}
+		log.Info().Str("disk", id).Str("url", source).Msg("image imported")
+		return path, nil
+	}
+
+	output, err := exec.Command("qemu-img", "convert", "-f", "qcow2", "-O", "raw", partial, path).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(path)
+		return "", errors.Wrapf(err, "failed to convert qcow2 image: %s", string(output))
+	}
+
+	log.Info().Str("disk", id).Str("url", source).Msg("qcow2 image imported")
+	return path, os.Remove(partial)
+}
+
+func (d *vdiskModule) startImport(id, source string) (*imageImport, error) {
+	d.importsMu.Lock()
+	defer d.importsMu.Unlock()
+
+	if _, ok := d.imports[id]; ok {
+		return nil, fmt.Errorf("image import on disk '%s' is already running", id)
+	}
+
+	if d.imports == nil {
+		d.imports = make(map[string]*imageImport)
+	}
+
+	progress := &imageImport{url: source}
+	d.imports[id] = progress
+	return progress, nil
+}
+
+func (d *vdiskModule) endImport(id string) {
+	d.importsMu.Lock()
+	defer d.importsMu.Unlock()
+
+	delete(d.imports, id)
+}
+
+// ImportProgress implements pkg.VDiskModule
+func (d *vdiskModule) ImportProgress(ctx context.Context) <-chan pkg.ImageImport {
+	ch := make(chan pkg.ImageImport)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+
+			d.importsMu.Lock()
+			var running []pkg.ImageImport
+			for id, progress := range d.imports {
+				running = append(running, progress.progress(id))
+			}
+			d.importsMu.Unlock()
+
+			for _, progress := range running {
+				select {
+				case ch <- progress:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+func isQcow2(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := io.ReadFull(file, magic); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(magic, qcow2Magic), nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+func TestImportImage(t *testing.T) {
+	image := bytes.Repeat([]byte("zos image "), 10000)
+	sum := sha256.Sum256(image)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "image", time.Now(), bytes.NewReader(image))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vdisks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &vdiskModule{path: filepath.Clean(dir), inflight: &utils.InFlight{}}
+
+	_, err = d.ImportImage(server.URL, "abcd", "disk")
+	assert.Error(t, err)
+	_, err = d.ImportImage("ftp://host/image", hex.EncodeToString(sum[:]), "disk")
+	assert.Error(t, err)
+
+	// a previous attempt stopped half way
+	partial := filepath.Join(dir, importPrefix+"disk")
+	require.NoError(t, ioutil.WriteFile(partial, image[:len(image)/2], 0644))
+
+	path, err := d.ImportImage(server.URL, hex.EncodeToString(sum[:]), "disk")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bytes=50000-"}, ranges)
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, image, content)
+	assert.NoFileExists(t, partial)
+	assert.Empty(t, d.imports)
+
+	_, err = d.ImportImage(server.URL, hex.EncodeToString(sum[:]), "disk")
+	assert.True(t, os.IsExist(errors.Cause(err)))
+
+	other := sha256.Sum256([]byte("other"))
+	_, err = d.ImportImage(server.URL, hex.EncodeToString(other[:]), "corrupted")
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, importPrefix+"corrupted"))
+	exists, err := d.Exists("corrupted")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
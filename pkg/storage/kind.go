@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+// kindDefaults are the settings applied to the volumes of a kind
+type kindDefaults struct {
+	// quota enforces the requested size with a qgroup limit,
+	// otherwise the volume is unlimited
+	quota bool
+	// compression algorithm of the volume, empty for none
+	compression string
+	// nocow disables copy on write, for big files rewritten in place
+	nocow bool
+	// fallback is true if the volume can be created on the other
+	// device type when the requested one has no space left
+	fallback bool
+}
+
+var volumeKinds = map[pkg.VolumeKind]kindDefaults{
+	pkg.VolumeKindVolume: {quota: true},
+	// the size of a zdb volume is the sum of its namespaces
+	pkg.VolumeKindZDB: {},
+	// vdisks are allocated as files in a single shared volume
+	pkg.VolumeKindVDisk: {nocow: true},
+	// container root filesystems are mostly text and binaries
+	pkg.VolumeKindRootFSRW: {quota: true, compression: "zstd"},
+	pkg.VolumeKindCache:    {quota: true, compression: "zstd", fallback: true},
+	// tmpfs volumes are always created in the memory pool
+	pkg.VolumeKindTmpfs: {quota: true},
+	// the swap is a single preallocated file rewritten in place
+	pkg.VolumeKindSwap: {quota: true, nocow: true},
+}
+
+func inferKind(name string) pkg.VolumeKind {
+	switch {
+	case strings.HasPrefix(name, zdbPoolPrefix):
+		return pkg.VolumeKindZDB
+	case name == cacheLabel:
+		return pkg.VolumeKindCache
+	case name == vdiskVolumeName:
+		return pkg.VolumeKindVDisk
+	case name == swapVolume:
+		return pkg.VolumeKindSwap
+	default:
+		return pkg.VolumeKindVolume
+	}
+}
+
+// tune applies the compression and copy on write defaults to a new volume
+func tune(volume filesystem.Volume, defaults kindDefaults) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if defaults.compression != "" {
+		if err := filesystem.SetCompression(ctx, volume.Path(), defaults.compression); err != nil {
+			return err
+		}
+	}
+
+	if defaults.nocow {
+		if err := filesystem.DisableCOW(ctx, volume.Path()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+func TestInferKind(t *testing.T) {
+	assert.Equal(t, pkg.VolumeKindZDB, inferKind("zdb2b1c7ad6-8c1d-4a5f-9a0e-1d7e3b7c4c1a"))
+	assert.Equal(t, pkg.VolumeKindCache, inferKind(cacheLabel))
+	assert.Equal(t, pkg.VolumeKindVDisk, inferKind(vdiskVolumeName))
+	assert.Equal(t, pkg.VolumeKindVolume, inferKind("12-1"))
+}
+
+func TestListVolumesByKind(t *testing.T) {
+	root, err := ioutil.TempDir("", "pool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	pool := &testPool{
+		name:  filepath.Base(root),
+		usage: filesystem.Usage{Size: 10000},
+		ptype: pkg.SSDDevice,
+	}
+
+	mod := storageModule{
+		volumes: []filesystem.Pool{pool},
+	}
+
+	rootfs := &testVolume{name: "rootfs"}
+	pool.On("AddVolume", "rootfs").Return(rootfs, nil)
+	rootfs.On("Limit", uint64(100)).Return(nil)
+
+	// tuning the volume fails without btrfs, this is not fatal
+	_, err = mod.createSubvol(100, "rootfs", pkg.SSDDevice, pkg.VolumeKindRootFSRW)
+	require.NoError(t, err)
+
+	legacy := &testVolume{name: "zdbb0a7d6"}
+	pool.On("Volumes").Return([]filesystem.Volume{rootfs, legacy}, nil)
+
+	all, err := mod.ListVolumes("")
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, pkg.VolumeKindRootFSRW, all[0].Kind)
+	assert.Equal(t, pool.name, all[0].Pool)
+	assert.Equal(t, pkg.VolumeKindZDB, all[1].Kind)
+
+	zdbs, err := mod.ListVolumes(pkg.VolumeKindZDB)
+	require.NoError(t, err)
+	require.Len(t, zdbs, 1)
+	assert.Equal(t, "zdbb0a7d6", zdbs[0].Name)
+
+	_, err = mod.ListVolumes("unknown")
+	assert.Error(t, err)
+}
+
+func TestCreateSubvolFallback(t *testing.T) {
+	root, err := ioutil.TempDir("", "pool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	hdd := &testPool{
+		name:  filepath.Base(root),
+		usage: filesystem.Usage{Size: 10000},
+		ptype: pkg.HDDDevice,
+	}
+
+	mod := storageModule{
+		volumes: []filesystem.Pool{hdd},
+	}
+
+	// no SSD pool, a regular volume fails
+	_, err = mod.createSubvol(100, "sub", pkg.SSDDevice, pkg.VolumeKindVolume)
+	require.Error(t, err)
+
+	// but the cache falls back to the HDD pool
+	cache := &testVolume{name: cacheLabel}
+	hdd.On("AddVolume", cacheLabel).Return(cache, nil)
+	cache.On("Limit", uint64(100)).Return(nil)
+
+	_, err = mod.createSubvol(100, cacheLabel, pkg.SSDDevice, pkg.VolumeKindCache)
+	require.NoError(t, err)
+}
+
+func TestLabelVolume(t *testing.T) {
+	root, err := ioutil.TempDir("", "pool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	pool := &testPool{
+		name:  filepath.Base(root),
+		usage: filesystem.Usage{Size: 10000},
+		ptype: pkg.SSDDevice,
+	}
+
+	mod := storageModule{
+		volumes:  []filesystem.Pool{pool},
+		inflight: &utils.InFlight{},
+	}
+
+	vol := &testVolume{name: "12-1"}
+	pool.On("AddVolume", "12-1").Return(vol, nil)
+	vol.On("Limit", uint64(100)).Return(nil)
+
+	_, err = mod.createSubvol(100, "12-1", pkg.SSDDevice, pkg.VolumeKindVolume)
+	require.NoError(t, err)
+
+	pool.On("Volumes").Return([]filesystem.Volume{vol}, nil)
+
+	err = mod.LabelVolume("12-1", "12-1", map[string]string{"user": "7", "type": "volume"})
+	require.NoError(t, err)
+
+	// the owner can't change and an empty label is removed
+	err = mod.LabelVolume("12-1", "13-1", nil)
+	assert.Error(t, err)
+	err = mod.LabelVolume("12-1", "", map[string]string{"type": ""})
+	require.NoError(t, err)
+
+	volumes, err := mod.ListVolumes("")
+	require.NoError(t, err)
+	require.Len(t, volumes, 1)
+	assert.Equal(t, "12-1", volumes[0].Owner)
+	assert.Equal(t, map[string]string{"user": "7"}, volumes[0].Labels)
+	assert.False(t, volumes[0].Created.IsZero())
+
+	err = mod.LabelVolume("unknown", "12-1", nil)
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+)
+
+const (
+	// ownerLabel is the label of a volume holding the user it belongs to
+	ownerLabel = "user"
+
+	// qgroupIDMask is the maximum id of a qgroup in a level
+	qgroupIDMask = 1<<48 - 1
+)
+
+// qgroupManager manages the btrfs qgroups of a pool
+type qgroupManager interface {
+	QGroupList(ctx context.Context, path string) (map[string]filesystem.BtrfsQGroup, error)
+	QGroupCreate(ctx context.Context, id, path string) error
+	QGroupAssign(ctx context.Context, child, parent, path string) error
+	QGroupLimitGroup(ctx context.Context, size uint64, id, path string) error
+}
+
+// SetOwnerShare limits the volumes of each user to percent of the size of
+// every pool, 0 removes the limit. It is applied to the users whose
+// volumes are labeled after the call
+func SetOwnerShare(module pkg.StorageModule, percent uint) error {
+	s, ok := module.(*storageModule)
+	if !ok {
+		return fmt.Errorf("owner quotas not supported by this storage module")
+	}
+
+	if percent > 100 {
+		return fmt.Errorf("invalid owner share %d%%", percent)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ownerShare = percent
+	return nil
+}
+
+// ownerQGroup is the id of the level 1 qgroup grouping the volumes of
+// user. The numeric user ids are used as is, the others are hashed
+func ownerQGroup(user string) string {
+	id, err := strconv.ParseUint(user, 10, 64)
+	if err != nil || id > qgroupIDMask {
+		hash := fnv.New64a()
+		hash.Write([]byte(user))
+		id = hash.Sum64() & qgroupIDMask
+	}
+
+	return fmt.Sprintf("1/%d", id)
+}
+
+// assignOwner accounts volume in the qgroup of the user it belongs
+// to, so all the volumes of a user in the pool share one limit
+func (s *storageModule) assignOwner(pool filesystem.Pool, volume filesystem.Volume, meta volumeMeta) error {
+	user := meta.Labels[ownerLabel]
+	if user == "" || s.qgroups == nil || pool.Type() == pkg.MemoryDevice {
+		return nil
+	}
+
+	ctx := context.Background()
+	qgroups := s.qgroups
+	group := ownerQGroup(user)
+
+	groups, err := qgroups.QGroupList(ctx, pool.Path())
+	if err != nil {
+		return errors.Wrap(err, "failed to list qgroups")
+	}
+
+	if _, ok := groups[group]; !ok {
+		if err := qgroups.QGroupCreate(ctx, group, pool.Path()); err != nil {
+			return errors.Wrapf(err, "failed to create qgroup of user %s", user)
+		}
+	}
+
+	if err := qgroups.QGroupAssign(ctx, fmt.Sprintf("0/%d", volume.ID()), group, pool.Path()); err != nil {
+		return errors.Wrapf(err, "failed to assign volume to qgroup of user %s", user)
+	}
+
+	var limit uint64
+	if s.ownerShare > 0 {
+		usage, err := pool.Usage()
+		if err != nil {
+			return errors.Wrap(err, "failed to get pool size")
+		}
+		limit = usage.Size / 100 * uint64(s.ownerShare)
+	}
+
+	if err := qgroups.QGroupLimitGroup(ctx, limit, group, pool.Path()); err != nil {
+		return errors.Wrapf(err, "failed to limit qgroup of user %s", user)
+	}
+
+	log.Debug().Str("volume", volume.Name()).Str("user", user).Str("qgroup", group).Uint64("limit", limit).Msg("volume assigned to user qgroup")
+	return nil
+}
+
+// OwnerQuotas implements pkg.StorageModule
+func (s *storageModule) OwnerQuotas() ([]pkg.OwnerQuota, error) {
+	if err := s.throttle("OwnerQuotas"); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []pkg.OwnerQuota
+	if s.qgroups == nil {
+		return result, nil
+	}
+
+	ctx := context.Background()
+	for _, pool := range s.volumes {
+		if _, mounted := pool.Mounted(); !mounted {
+			continue
+		}
+
+		volumes, err := pool.Volumes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list volumes of pool %s", pool.Name())
+		}
+
+		users := make(map[string]struct{})
+		for _, volume := range volumes {
+			meta, err := readMeta(pool, volume.Name())
+			if err != nil {
+				return nil, err
+			}
+			if user := meta.Labels[ownerLabel]; user != "" {
+				users[user] = struct{}{}
+			}
+		}
+
+		if len(users) == 0 {
+			continue
+		}
+
+		groups, err := s.qgroups.QGroupList(ctx, pool.Path())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list qgroups of pool %s", pool.Name())
+		}
+
+		for user := range users {
+			group, ok := groups[ownerQGroup(user)]
+			if !ok {
+				continue
+			}
+
+			result = append(result, pkg.OwnerQuota{
+				User:  user,
+				Pool:  pool.Name(),
+				Used:  group.Rfer,
+				Limit: group.MaxRfer,
+			})
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+type testQGroups struct {
+	mock.Mock
+}
+
+func (q *testQGroups) QGroupList(ctx context.Context, path string) (map[string]filesystem.BtrfsQGroup, error) {
+	args := q.Called(path)
+	return args.Get(0).(map[string]filesystem.BtrfsQGroup), args.Error(1)
+}
+
+func (q *testQGroups) QGroupCreate(ctx context.Context, id, path string) error {
+	return q.Called(id, path).Error(0)
+}
+
+func (q *testQGroups) QGroupAssign(ctx context.Context, child, parent, path string) error {
+	return q.Called(child, parent, path).Error(0)
+}
+
+func (q *testQGroups) QGroupLimitGroup(ctx context.Context, size uint64, id, path string) error {
+	return q.Called(size, id, path).Error(0)
+}
+
+func TestOwnerQGroup(t *testing.T) {
+	assert.Equal(t, "1/7", ownerQGroup("7"))
+	assert.Equal(t, ownerQGroup("alice"), ownerQGroup("alice"))
+	assert.NotEqual(t, ownerQGroup("alice"), ownerQGroup("bob"))
+}
+
+func TestOwnerQuota(t *testing.T) {
+	root, err := ioutil.TempDir("", "pool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	pool := &testPool{
+		name:  filepath.Base(root),
+		usage: filesystem.Usage{Size: 10000},
+		ptype: pkg.SSDDevice,
+	}
+
+	qgroups := &testQGroups{}
+	mod := storageModule{
+		volumes:  []filesystem.Pool{pool},
+		inflight: &utils.InFlight{},
+		qgroups:  qgroups,
+	}
+	require.NoError(t, SetOwnerShare(&mod, 50))
+	assert.Error(t, SetOwnerShare(&mod, 150))
+
+	vol := &testVolume{name: "12-1"}
+	pool.On("AddVolume", "12-1").Return(vol, nil)
+	pool.On("Volumes").Return([]filesystem.Volume{vol}, nil)
+	vol.On("Limit", uint64(100)).Return(nil)
+
+	_, err = mod.createSubvol(100, "12-1", pkg.SSDDevice, pkg.VolumeKindVolume)
+	require.NoError(t, err)
+
+	// labels without user don't touch the qgroups
+	require.NoError(t, mod.LabelVolume("12-1", "12-1", map[string]string{"type": "volume"}))
+
+	qgroups.On("QGroupList", pool.Path()).Return(map[string]filesystem.BtrfsQGroup{}, nil).Once()
+	qgroups.On("QGroupCreate", "1/7", pool.Path()).Return(nil)
+	qgroups.On("QGroupAssign", "0/0", "1/7", pool.Path()).Return(nil)
+	qgroups.On("QGroupLimitGroup", uint64(5000), "1/7", pool.Path()).Return(nil)
+
+	require.NoError(t, mod.LabelVolume("12-1", "12-1", map[string]string{"user": "7"}))
+	qgroups.AssertExpectations(t)
+
+	qgroups.On("QGroupList", pool.Path()).Return(map[string]filesystem.BtrfsQGroup{
+		"1/7": {ID: "1/7", Rfer: 300, MaxRfer: 5000},
+	}, nil)
+
+	quotas, err := mod.OwnerQuotas()
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.OwnerQuota{{User: "7", Pool: pool.name, Used: 300, Limit: 5000}}, quotas)
+}
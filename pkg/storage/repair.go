@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/tracing"
+)
+
+// repairTimeout is the maximum time a pool repair can take
+const repairTimeout = 5 * time.Minute
+
+// markBroken records pool as broken with the reason it failed to mount.
+// The other pools keep serving allocations while it is broken
+func (s *storageModule) markBroken(ctx context.Context, pool filesystem.Pool, err error) {
+	broken := diagnosePool(ctx, pool.Name(), err)
+
+	log.Error().
+		Err(err).
+		Str("pool", pool.Name()).
+		Strs("devices", broken.Devices).
+		Strs("diagnostics", broken.Diagnostics).
+		Msg("storage pool is broken, running degraded")
+
+	if s.degraded == nil {
+		s.degraded = make(map[string]filesystem.Pool)
+	}
+	s.degraded[pool.Name()] = pool
+
+	for i := range s.brokenPools {
+		if s.brokenPools[i].Label == pool.Name() {
+			broken.Since = s.brokenPools[i].Since
+			s.brokenPools[i] = broken
+			return
+		}
+	}
+
+	s.brokenPools = append(s.brokenPools, broken)
+}
+
+// diagnosePool collects what can be known about the unmounted pool
+// label without touching its data
+func diagnosePool(ctx context.Context, label string, err error) pkg.BrokenPool {
+	broken := pkg.BrokenPool{
+		Label: label,
+		Err:   err,
+		Since: time.Now(),
+	}
+
+	diagnose := func(format string, args ...interface{}) {
+		broken.Diagnostics = append(broken.Diagnostics, fmt.Sprintf(format, args...))
+	}
+
+	if err != nil {
+		diagnose("mount failed: %s", err)
+	}
+
+	utils := filesystem.NewUtils()
+	list, listErr := utils.List(ctx, label, false)
+	if listErr != nil {
+		diagnose("failed to list the devices of the pool: %s", listErr)
+		return broken
+	}
+	if len(list) != 1 {
+		diagnose("expected 1 filesystem labeled %s, found %d", label, len(list))
+		return broken
+	}
+
+	fs := list[0]
+	if fs.Warnings != "" {
+		diagnose("btrfs warnings: %s", fs.Warnings)
+	}
+	if len(fs.Devices) < fs.TotalDevices {
+		diagnose("%d of %d devices are missing", fs.TotalDevices-len(fs.Devices), fs.TotalDevices)
+	}
+
+	for _, device := range fs.Devices {
+		broken.Devices = append(broken.Devices, device.Path)
+		if device.Missing {
+			diagnose("device %s is missing", device.Path)
+			continue
+		}
+		if err := probeDevice(device.Path); err != nil {
+			diagnose("device %s is not readable: %s", device.Path, err)
+		}
+	}
+
+	return broken
+}
+
+// probeDevice reads the start of the device to detect IO errors
+func probeDevice(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 64*1024)
+	_, err = io.ReadFull(file, buf)
+	return err
+}
+
+// RepairPool implements pkg.StorageModule
+func (s *storageModule) RepairPool(label string) (err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("RepairPool", label)
+	defer func() { span.Finish(err) }()
+
+	caller := audit.Caller(ctx)
+	defer func() {
+		s.audit.Record("RepairPool", caller, label, label, err)
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, ok := s.degraded[label]
+	if !ok {
+		return fmt.Errorf("pool %s is not broken", label)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), repairTimeout)
+	defer cancel()
+
+	if err := repairPool(ctx, pool); err != nil {
+		s.markBroken(ctx, pool, err)
+		return err
+	}
+
+	delete(s.degraded, label)
+	for i := range s.brokenPools {
+		if s.brokenPools[i].Label == label {
+			s.brokenPools = append(s.brokenPools[:i], s.brokenPools[i+1:]...)
+			break
+		}
+	}
+	s.volumes = append(s.volumes, pool)
+
+	if err := recordDevices(pool); err != nil {
+		log.Error().Err(err).Str("pool", label).Msg("failed to record device identities")
+	}
+
+	log.Info().Str("pool", label).Msg("storage pool repaired")
+	return nil
+}
+
+// repairPool mounts the pool, clearing its log tree if it can't be mounted
+// as is. Damage beyond that needs a manual btrfs check
+func repairPool(ctx context.Context, pool filesystem.Pool) error {
+	// the devices may have come back since the pool was found broken
+	if _, err := pool.Mount(); err == nil {
+		return nil
+	}
+
+	utils := filesystem.NewUtils()
+	list, err := utils.List(ctx, pool.Name(), false)
+	if err != nil {
+		return err
+	}
+	if len(list) != 1 {
+		return fmt.Errorf("unknown pool '%s'", pool.Name())
+	}
+
+	for _, device := range list[0].Devices {
+		if device.Missing {
+			return fmt.Errorf("device %s of pool %s is missing", device.Path, pool.Name())
+		}
+	}
+
+	for _, device := range list[0].Devices {
+		log.Info().Str("pool", pool.Name()).Str("device", device.Path).Msg("clearing log tree")
+		if err := utils.RescueZeroLog(ctx, device.Path); err != nil {
+			return fmt.Errorf("failed to clear log tree of %s: %s", device.Path, err)
+		}
+	}
+
+	_, err = pool.Mount()
+	return err
+}
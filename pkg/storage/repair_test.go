@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+func TestRepairPool(t *testing.T) {
+	require := require.New(t)
+
+	healthy := &testPool{name: "pool-1", ptype: pkg.SSDDevice}
+	broken := &testPool{name: "pool-2", ptype: pkg.SSDDevice}
+
+	mod := storageModule{
+		volumes:     []filesystem.Pool{healthy},
+		brokenPools: []pkg.BrokenPool{{Label: "pool-2", Err: fmt.Errorf("device gone")}},
+		degraded:    map[string]filesystem.Pool{"pool-2": broken},
+		inflight:    &utils.InFlight{},
+	}
+
+	require.Error(mod.RepairPool("pool-1"))
+
+	// the device came back since the boot
+	broken.On("Mount").Return("/mnt/pool-2", nil)
+
+	require.NoError(mod.RepairPool("pool-2"))
+	brokenPools, err := mod.BrokenPools()
+	require.NoError(err)
+	require.Empty(brokenPools)
+	require.Empty(mod.degraded)
+	require.Equal([]filesystem.Pool{healthy, broken}, mod.volumes)
+
+	require.Error(mod.RepairPool("pool-2"))
+}
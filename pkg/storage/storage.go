@@ -16,7 +16,13 @@ import (
 	"github.com/shirou/gopsutil/disk"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/dedup"
+	"github.com/threefoldtech/zos/pkg/logging"
+	"github.com/threefoldtech/zos/pkg/ratelimit"
 	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/tracing"
+	"github.com/threefoldtech/zos/pkg/utils"
 )
 
 const (
@@ -25,6 +31,13 @@ const (
 	cacheLabel  = "zos-cache"
 	gib         = 1024 * 1024 * 1024
 	cacheSize   = 100 * gib
+
+	// memoryPoolPath is where the tmpfs volumes are mounted
+	memoryPoolPath = "/var/run/volumes"
+
+	// allocations is the bucket of the limiter shared by all the volume
+	// and 0-db allocations
+	allocations = "allocations"
 )
 
 var (
@@ -38,14 +51,45 @@ var (
 type storageModule struct {
 	volumes       []filesystem.Pool
 	brokenPools   []pkg.BrokenPool
+	degraded      map[string]filesystem.Pool
 	devices       filesystem.DeviceManager
 	brokenDevices []pkg.BrokenDevice
+	inflight      *utils.InFlight
+	limiter       *ratelimit.Limiter
+	diagnostics   *ratelimit.Limiter
+	requests      *dedup.Cache
+	audit         *audit.Logger
 
 	mu sync.RWMutex
+
+	// memory holds the tmpfs volumes, it is kept out of the disk pools
+	// so it is not counted in their storage capacity
+	memory filesystem.Pool
+
+	health   map[string]*diskHealth
+	healthMu sync.Mutex
+
+	trends   map[string]*usageTrend
+	trendsMu sync.Mutex
+
+	forensic   map[string]*time.Timer
+	forensicMu sync.Mutex
+
+	// qgroups groups the volumes of each user, the volumes
+	// are not grouped if it is not set
+	qgroups qgroupManager
+	// ownerShare is the percentage of a pool the volumes of a user can use
+	ownerShare uint
+
+	// swapSize is the size of the encrypted swap, 0 if the node has none
+	swapSize uint64
+	swapMu   sync.Mutex
 }
 
 // New create a new storage module service
-func New() (pkg.StorageModule, error) {
+// inflight is used to track the allocations so storaged can drain
+// them before exiting
+func New(inflight *utils.InFlight) (pkg.StorageModule, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
@@ -56,11 +100,21 @@ func New() (pkg.StorageModule, error) {
 		return nil, err
 	}
 
+	btrfs := filesystem.NewUtils()
+
 	s := &storageModule{
 		volumes:       []filesystem.Pool{},
 		brokenPools:   []pkg.BrokenPool{},
 		devices:       m,
 		brokenDevices: []pkg.BrokenDevice{},
+		inflight:      inflight,
+		// the caller handed over is not authenticated, so all the
+		// allocations share a single bucket
+		limiter: ratelimit.New(5, 50),
+		// the diagnostics walk all the pools under the lock of the module
+		diagnostics: ratelimit.New(1, 20),
+		requests:    dedup.New(10 * time.Minute),
+		qgroups:     &btrfs,
 	}
 
 	// go for a simple linear setup right now
@@ -70,15 +124,47 @@ func New() (pkg.StorageModule, error) {
 		MaxPools: 0,
 	})
 
-	if err == nil {
+	// the pools which could be mounted keep serving allocations,
+	// the broken ones are reported and can be repaired later
+	if err != nil {
+		log.Error().Err(err).Msg("storage initialization failed, running degraded")
+	} else {
 		log.Info().Msgf("Finished initializing storage module")
 	}
 
+	// the audit log lives on the cache, so it can only be opened
+	// once the cache is mounted by the initialization
+	if auditLog, err := audit.New(audit.DefaultRoot, "storage", nil); err != nil {
+		log.Error().Err(err).Msg("failed to open audit log, operations won't be audited")
+	} else {
+		s.audit = auditLog
+	}
+
+	if memory, err := newMemoryPool(); err != nil {
+		log.Error().Err(err).Msg("failed to create memory pool, tmpfs volumes won't be available")
+	} else {
+		s.memory = memory
+	}
+
 	if err := s.Maintenance(); err != nil {
 		log.Error().Err(err).Msg("storage devices maintenance failed")
 	}
 
-	return s, err
+	return s, nil
+}
+
+// SetAuditSigner signs the next entries of the audit log of module with
+// signer. storaged starts before identityd, the entries recorded before
+// are covered by the first signed entry through the chain. Once the log
+// has a signed entry, the next ones wait for the signer to be written
+func SetAuditSigner(module pkg.StorageModule, signer audit.Signer) error {
+	s, ok := module.(*storageModule)
+	if !ok {
+		return fmt.Errorf("audit signing not supported by this storage module")
+	}
+
+	s.audit.SetSigner(signer)
+	return nil
 }
 
 // Total gives the total amount of storage available for a device type
@@ -105,17 +191,27 @@ func (s *storageModule) Total(kind pkg.DeviceType) (uint64, error) {
 }
 
 // BrokenPools lists the broken storage pools that have been detected
-func (s *storageModule) BrokenPools() []pkg.BrokenPool {
+func (s *storageModule) BrokenPools() ([]pkg.BrokenPool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.brokenPools
+	return s.brokenPools, nil
 }
 
 // BrokenDevices lists the broken devices that have been detected
-func (s *storageModule) BrokenDevices() []pkg.BrokenDevice {
+func (s *storageModule) BrokenDevices() ([]pkg.BrokenDevice, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.brokenDevices
+	return s.brokenDevices, nil
+}
+
+// throttle limits the calls to the diagnostics method, all the callers
+// share the bucket of the method
+func (s *storageModule) throttle(method string) error {
+	_, span := tracing.Serve(method, "")
+	err := s.diagnostics.Allow(method)
+	span.Finish(err)
+
+	return err
 }
 
 func (s *storageModule) Dump() {
@@ -131,13 +227,16 @@ func (s *storageModule) Dump() {
 
 }
 
-/**
+/*
+*
 initialize, must be called at least onetime each boot.
 What Initialize will do is the following:
- - Try to mount prepared pools (if they are not mounted already)
- - Scan free devices, apply the policy.
- - If new pools were created, the pool is going to be mounted automatically
-**/
+  - Try to mount prepared pools (if they are not mounted already)
+  - Scan free devices, apply the policy.
+  - If new pools were created, the pool is going to be mounted automatically
+
+*
+*/
 func (s *storageModule) initialize(policy pkg.StoragePolicy) error {
 	// lock for the entire initialization method, so other code which relies
 	// on this observes this as an atomic operation
@@ -156,10 +255,18 @@ func (s *storageModule) initialize(policy pkg.StoragePolicy) error {
 	log.Debug().Msgf("Searching for existing volumes")
 	existingPools, err := fs.List(ctx, filesystem.All)
 	if err != nil {
-		return err
+		// new pools could be created over the ones we failed
+		// to list, so only the cache is set up
+		s.cacheOnly()
+		return errors.Wrap(err, "failed to list existing pools")
 	}
 
 	for _, volume := range existingPools {
+		// the cache partition is not a storage pool
+		if volume.Name() == cacheLabel {
+			continue
+		}
+
 		if _, mounted := volume.Mounted(); mounted {
 			log.Debug().Msgf("Volume %s already mounted", volume.Name())
 			// volume is already mounted, skip mounting it again, make sure it is
@@ -171,8 +278,7 @@ func (s *storageModule) initialize(policy pkg.StoragePolicy) error {
 		}
 		_, err = volume.Mount()
 		if err != nil {
-			s.brokenPools = append(s.brokenPools, pkg.BrokenPool{Label: volume.Name(), Err: err})
-			log.Warn().Msgf("Failed to mount volume %v", volume.Name())
+			s.markBroken(ctx, volume, err)
 			continue
 		}
 		log.Debug().Msgf("Mounted volume %s", volume.Name())
@@ -183,7 +289,8 @@ func (s *storageModule) initialize(policy pkg.StoragePolicy) error {
 	log.Info().Msgf("Finding free disks")
 	disks, err := s.devices.Devices(ctx)
 	if err != nil {
-		return err
+		s.cacheOnly()
+		return errors.Wrap(err, "failed to list disks")
 	}
 
 	freeDisks := filesystem.DeviceCache{}
@@ -272,20 +379,34 @@ func (s *storageModule) initialize(policy pkg.StoragePolicy) error {
 		if _, mounted := newPools[idx].Mounted(); !mounted {
 			log.Debug().Msgf("Mounting volume %s", newPools[idx].Name())
 			if _, err = newPools[idx].Mount(); err != nil {
-				s.brokenPools = append(s.brokenPools, pkg.BrokenPool{Label: newPools[idx].Name(), Err: err})
+				s.markBroken(ctx, newPools[idx], err)
 				continue
 			}
 			s.volumes = append(s.volumes, newPools[idx])
 		}
 	}
 
+	for _, pool := range s.volumes {
+		if err := recordDevices(pool); err != nil {
+			log.Error().Err(err).Str("pool", pool.Name()).Msg("failed to record device identities")
+		}
+	}
+
 	if err := filesystem.Partprobe(ctx); err != nil {
-		return err
+		log.Error().Err(err).Msg("failed to reload partition tables")
 	}
 
 	return s.ensureCache()
 }
 
+// cacheOnly sets up the cache when the initialization can't go any
+// further, so the node keeps working with the pools mounted so far
+func (s *storageModule) cacheOnly() {
+	if err := s.ensureCache(); err != nil {
+		log.Error().Err(err).Msg("failed to set up cache")
+	}
+}
+
 func (s *storageModule) Maintenance() error {
 	for _, pool := range s.volumes {
 		log.Info().
@@ -307,33 +428,115 @@ func (s *storageModule) Maintenance() error {
 
 // CreateFilesystem with the given size in a storage pool.
 func (s *storageModule) CreateFilesystem(name string, size uint64, poolType pkg.DeviceType) (string, error) {
-	log.Info().Msgf("Creating new volume with size %d", size)
-	if strings.HasPrefix(name, "zdb") {
-		return "", fmt.Errorf("invalid volume name. zdb prefix is reserved")
+	return s.createVolume("CreateFilesystem", "", name, size, poolType, pkg.VolumeKindVolume)
+}
+
+// CreateVolume with the given size and kind in a storage pool
+func (s *storageModule) CreateVolume(name string, size uint64, poolType pkg.DeviceType, kind pkg.VolumeKind) (string, error) {
+	return s.createVolume("CreateVolume", "", name, size, poolType, kind)
+}
+
+// CreateVolumeRequest implements pkg.StorageModule
+func (s *storageModule) CreateVolumeRequest(requestID, name string, size uint64, poolType pkg.DeviceType, kind pkg.VolumeKind) (string, error) {
+	return s.createVolume("CreateVolumeRequest", requestID, name, size, poolType, kind)
+}
+
+// createVolume creates the volume, the request is identified by requestID
+// or by its arguments if it's empty
+func (s *storageModule) createVolume(api, requestID, name string, size uint64, poolType pkg.DeviceType, kind pkg.VolumeKind) (string, error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	if err := kind.Valid(); err != nil {
+		return "", err
+	}
+
+	key, err := dedup.Key(requestID, name, size, poolType, kind)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to compute request key")
+	}
+
+	// a retried request returns the volume created by the original one
+	// instead of failing on the already existing subvolume
+	ctx, span := tracing.Serve(api, name)
+	span.SetAttribute("kind", string(kind))
+	path, replayed, err := s.requests.Do(name, key, func() (interface{}, error) {
+		if err := s.limiter.Allow(allocations); err != nil {
+			return "", err
+		}
+
+		log.Info().Str("kind", string(kind)).Msgf("Creating new volume with size %d", size)
+		if strings.HasPrefix(name, "zdb") {
+			return "", fmt.Errorf("invalid volume name. zdb prefix is reserved")
+		}
+
+		var fs filesystem.Volume
+		err := tracing.Step(ctx, "create subvolume", func() (err error) {
+			fs, err = s.createSubvol(size, name, poolType, kind)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return fs.Path(), nil
+	})
+	span.Finish(err)
+
+	if !replayed {
+		s.audit.Record(api, audit.Caller(ctx), name, []interface{}{name, size, poolType, kind}, err)
 	}
 
-	fs, err := s.createSubvol(size, name, poolType)
 	if err != nil {
 		return "", err
 	}
-	return fs.Path(), nil
+
+	if replayed {
+		log.Info().Str("volume", name).Msg("volume already created, replaying result")
+	}
+
+	return path.(string), nil
 }
 
 // ReleaseFilesystem with the given name, this will unmount and then delete
 // the filesystem. After this call, the caller must not perform any more actions
 // on this filesystem
-func (s *storageModule) ReleaseFilesystem(name string) error {
+func (s *storageModule) ReleaseFilesystem(name string) (err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("ReleaseFilesystem", name)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("ReleaseFilesystem", audit.Caller(ctx), name, name, err)
+	}()
+
+	if s.forensicMounted(name) {
+		return fmt.Errorf("volume %s is under forensic examination", name)
+	}
+
 	log.Info().Msgf("Deleting volume %v", name)
+	s.requests.Forget(name)
 
-	for idx := range s.volumes {
-		filesystems, err := s.volumes[idx].Volumes()
+	pools := s.allPools()
+	for idx := range pools {
+		filesystems, err := pools[idx].Volumes()
 		if err != nil {
 			return err
 		}
 		for jdx := range filesystems {
 			if filesystems[jdx].Name() == name {
-				log.Debug().Msgf("Removing filesystem %v in volume %v", filesystems[jdx].Name(), s.volumes[idx].Name())
-				return s.volumes[idx].RemoveVolume(filesystems[jdx].Name())
+				log.Debug().Msgf("Removing filesystem %v in volume %v", filesystems[jdx].Name(), pools[idx].Name())
+				if err := pools[idx].RemoveVolume(filesystems[jdx].Name()); err != nil {
+					return err
+				}
+				return removeMeta(pools[idx], name)
 			}
 		}
 	}
@@ -345,8 +548,9 @@ func (s *storageModule) ReleaseFilesystem(name string) error {
 // Path return the path of the mountpoint of the named filesystem
 // if no volume with name exists, an empty path and an error is returned
 func (s *storageModule) Path(name string) (string, error) {
-	for idx := range s.volumes {
-		filesystems, err := s.volumes[idx].Volumes()
+	pools := s.allPools()
+	for idx := range pools {
+		filesystems, err := pools[idx].Volumes()
 		if err != nil {
 			return "", err
 		}
@@ -360,7 +564,26 @@ func (s *storageModule) Path(name string) (string, error) {
 	return "", errors.Wrapf(os.ErrNotExist, "subvolume '%s' not found", name)
 }
 
-// ensureCache creates a "cache" subvolume and mounts it in /var
+// VolumeDevices implements pkg.StorageModule
+func (s *storageModule) VolumeDevices(name string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pool, _, err := s.findVolume(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, device := range pool.Devices() {
+		devices = append(devices, device.Path)
+	}
+
+	return devices, nil
+}
+
+// ensureCache mounts the cache partition in /var/cache, or creates a
+// "cache" subvolume and mounts it there if there is no cache partition
 func (s *storageModule) ensureCache() error {
 	log.Info().Msgf("Setting up cache")
 
@@ -373,6 +596,13 @@ func (s *storageModule) ensureCache() error {
 		return nil
 	}
 
+	// a dedicated partition keeps the node data out of the storage pools
+	if mounted, err := s.mountCachePartition(); err != nil {
+		log.Error().Err(err).Msg("failed to use cache partition, falling back to a cache volume")
+	} else if mounted {
+		return nil
+	}
+
 	// check if cache volume available
 	for idx := range s.volumes {
 		filesystems, err := s.volumes[idx].Volumes()
@@ -394,22 +624,10 @@ func (s *storageModule) ensureCache() error {
 	if cacheFs == nil {
 		log.Debug().Msgf("No cache found, try to create new cache")
 
-		log.Debug().Msgf("Trying to create new cache on SSD")
-		fs, err := s.createSubvol(cacheSize, cacheLabel, pkg.SSDDevice)
-
+		// the cache falls back to HDD if there is no SSD space left
+		fs, err := s.createSubvol(cacheSize, cacheLabel, pkg.SSDDevice, pkg.VolumeKindCache)
 		if err != nil {
-			log.Warn().Err(err).Msg("failed to create new cache on SSD")
-		} else {
-			cacheFs = fs
-		}
-	}
-
-	if cacheFs == nil {
-		log.Debug().Msgf("Trying to create new cache on HDD")
-		fs, err := s.createSubvol(cacheSize, cacheLabel, pkg.HDDDevice)
-
-		if err != nil {
-			log.Warn().Err(err).Msg("failed to create new cache on HDD")
+			log.Warn().Err(err).Msg("failed to create new cache")
 		} else {
 			cacheFs = fs
 		}
@@ -436,11 +654,44 @@ func (s *storageModule) ensureCache() error {
 	return filesystem.BindMount(cacheFs, CacheTarget)
 }
 
-// createSubvol creates a subvolume with the given name and limits it to the given size
-// if the requested disk type does not have a storage pool available, an error is
-// returned
-func (s *storageModule) createSubvol(size uint64, name string, poolType pkg.DeviceType) (filesystem.Volume, error) {
+// createSubvol creates a subvolume with the given name and kind, and limits it
+// to the given size if the kind has a quota. If the requested disk type does
+// not have a storage pool available, the other disk type is tried if the kind
+// allows it, otherwise an error is returned
+func (s *storageModule) createSubvol(size uint64, name string, poolType pkg.DeviceType, kind pkg.VolumeKind) (filesystem.Volume, error) {
+	defaults, ok := volumeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown volume kind '%s'", kind)
+	}
+
+	if kind == pkg.VolumeKindTmpfs || poolType == pkg.MemoryDevice {
+		if kind != pkg.VolumeKindTmpfs || poolType != pkg.MemoryDevice {
+			return nil, fmt.Errorf("tmpfs volumes can only be created in memory")
+		}
+		return s.createTmpfs(size, name)
+	}
+
+	volume, err := s.createSubvolOn(size, name, poolType, kind, defaults)
+	if _, noSpace := err.(pkg.ErrNotEnoughSpace); noSpace && defaults.fallback {
+		other := pkg.HDDDevice
+		if poolType == pkg.HDDDevice {
+			other = pkg.SSDDevice
+		}
+
+		log.Info().Str("volume", name).Msgf("no space left on %s, falling back to %s", poolType, other)
+		volume, err = s.createSubvolOn(size, name, other, kind, defaults)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+func (s *storageModule) createSubvolOn(size uint64, name string, poolType pkg.DeviceType, kind pkg.VolumeKind, defaults kindDefaults) (filesystem.Volume, error) {
 	var err error
+	log := logging.Component("allocator")
 
 	if poolType != pkg.HDDDevice && poolType != pkg.SSDDevice {
 		return nil, pkg.ErrInvalidDeviceType{DeviceType: poolType}
@@ -460,6 +711,11 @@ func (s *storageModule) createSubvol(size uint64, name string, poolType pkg.Devi
 			continue
 		}
 
+		if s.draining(pool) {
+			log.Info().Str("pool", pool.Name()).Msg("skipping pool with failing disk")
+			continue
+		}
+
 		usage, err := pool.Usage()
 		if err != nil {
 			log.Error().Msgf("Failed to get current volume usage: %v", err)
@@ -499,6 +755,11 @@ func (s *storageModule) createSubvol(size uint64, name string, poolType pkg.Devi
 		return candidates[i].Available > candidates[j].Available
 	})
 
+	limit := size
+	if !defaults.quota {
+		limit = 0
+	}
+
 	var volume filesystem.Volume
 	for _, candidate := range candidates {
 		volume, err = candidate.Pool.AddVolume(name)
@@ -506,11 +767,20 @@ func (s *storageModule) createSubvol(size uint64, name string, poolType pkg.Devi
 			log.Error().Err(err).Str("pool", candidate.Pool.Name()).Msg("failed to create new filesystem")
 			continue
 		}
-		if err = volume.Limit(size); err != nil {
+		if err = volume.Limit(limit); err != nil {
 			candidate.Pool.RemoveVolume(volume.Name()) // try to recover
 			log.Error().Err(err).Str("volume", volume.Path()).Msg("failed to set volume size limit")
 			continue
 		}
+		if err = tune(volume, defaults); err != nil {
+			// the volume is still usable with the pool defaults
+			log.Error().Err(err).Str("volume", volume.Path()).Msg("failed to apply volume defaults")
+		}
+		if err = writeMeta(candidate.Pool, name, volumeMeta{Kind: kind, Created: time.Now()}); err != nil {
+			candidate.Pool.RemoveVolume(volume.Name()) // try to recover
+			log.Error().Err(err).Str("volume", volume.Path()).Msg("failed to write volume metadata")
+			continue
+		}
 
 		return volume, nil
 	}
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/threefoldtech/zos/pkg"
@@ -47,6 +49,7 @@ type testPool struct {
 	usage    filesystem.Usage
 	reserved uint64
 	ptype    pkg.DeviceType
+	devices  []*filesystem.Device
 }
 
 var _ filesystem.Pool = &testPool{}
@@ -80,7 +83,8 @@ func (p *testPool) Mounted() (string, bool) {
 }
 
 func (p *testPool) Mount() (string, error) {
-	return "", fmt.Errorf("not implemented")
+	args := p.Called()
+	return args.String(0), args.Error(1)
 }
 
 func (p *testPool) UnMount() error {
@@ -123,7 +127,7 @@ func (p *testPool) RemoveVolume(name string) error {
 }
 
 func (p *testPool) Devices() []*filesystem.Device {
-	return []*filesystem.Device{}
+	return p.devices
 }
 
 func TestCreateSubvol(t *testing.T) {
@@ -174,7 +178,7 @@ func TestCreateSubvol(t *testing.T) {
 	pool2.On("AddVolume", "sub").Return(sub, nil)
 	sub.On("Limit", uint64(500)).Return(nil)
 
-	_, err := mod.createSubvol(500, "sub", pkg.SSDDevice)
+	_, err := mod.createSubvol(500, "sub", pkg.SSDDevice, pkg.VolumeKindVolume)
 
 	require.NoError(err)
 }
@@ -227,7 +231,7 @@ func TestCreateSubvolUnlimited(t *testing.T) {
 	pool2.On("AddVolume", "sub").Return(sub, nil)
 	sub.On("Limit", uint64(0)).Return(nil)
 
-	_, err := mod.createSubvol(0, "sub", pkg.SSDDevice)
+	_, err := mod.createSubvol(0, "sub", pkg.SSDDevice, pkg.VolumeKindVolume)
 
 	require.NoError(err)
 }
@@ -274,7 +278,100 @@ func TestCreateSubvolNoSpaceLeft(t *testing.T) {
 	// from the data above the create subvol will prefer pool 2 because it
 	// after adding the subvol, it will still has more space.
 
-	_, err := mod.createSubvol(20000, "sub", pkg.SSDDevice)
+	_, err := mod.createSubvol(20000, "sub", pkg.SSDDevice, pkg.VolumeKindVolume)
 
 	require.EqualError(err, "Not enough space left in pools of this type SSD")
 }
+
+func TestCreateTmpfs(t *testing.T) {
+	require := require.New(t)
+
+	disk := &testPool{
+		name: "pool-1",
+		usage: filesystem.Usage{
+			Size: 100000,
+		},
+		ptype: pkg.SSDDevice,
+	}
+
+	memory := &testPool{
+		name:     "memory",
+		reserved: 1000,
+		usage: filesystem.Usage{
+			Size: 10000,
+		},
+		ptype: pkg.MemoryDevice,
+	}
+
+	mod := storageModule{
+		volumes: []filesystem.Pool{disk},
+		memory:  memory,
+	}
+
+	sub := &testVolume{
+		name: "scratch",
+	}
+
+	memory.On("AddVolume", "scratch").Return(sub, nil)
+	sub.On("Limit", uint64(500)).Return(nil)
+
+	_, err := mod.createSubvol(500, "scratch", pkg.MemoryDevice, pkg.VolumeKindTmpfs)
+	require.NoError(err)
+	disk.AssertNotCalled(t, "AddVolume", "scratch")
+
+	_, err = mod.createSubvol(500, "scratch", pkg.SSDDevice, pkg.VolumeKindTmpfs)
+	require.Error(err)
+
+	_, err = mod.createSubvol(500, "scratch", pkg.MemoryDevice, pkg.VolumeKindVolume)
+	require.Error(err)
+
+	_, err = mod.createSubvol(9500, "scratch", pkg.MemoryDevice, pkg.VolumeKindTmpfs)
+	require.Equal(pkg.ErrNotEnoughSpace{DeviceType: pkg.MemoryDevice}, err)
+}
+
+func TestVolumeDevices(t *testing.T) {
+	pool1 := &testPool{
+		name: "pool-1",
+		devices: []*filesystem.Device{
+			{Path: "/dev/sda"},
+			{Path: "/dev/sdb"},
+		},
+	}
+	pool1.On("Volumes").Return([]filesystem.Volume{&testVolume{name: "vol-1"}}, nil)
+
+	pool2 := &testPool{
+		name:    "pool-2",
+		devices: []*filesystem.Device{{Path: "/dev/sdc"}},
+	}
+	pool2.On("Volumes").Return([]filesystem.Volume{&testVolume{name: "vol-2"}}, nil)
+
+	// the memory pool has no disk to throttle
+	memory := &testPool{name: "memory", ptype: pkg.MemoryDevice}
+	memory.On("Volumes").Return([]filesystem.Volume{&testVolume{name: "scratch"}}, nil)
+
+	mod := storageModule{
+		volumes: []filesystem.Pool{pool1, pool2},
+		memory:  memory,
+	}
+
+	cases := []struct {
+		volume   string
+		expected []string
+	}{
+		{"vol-1", []string{"/dev/sda", "/dev/sdb"}},
+		{"vol-2", []string{"/dev/sdc"}},
+		{"scratch", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.volume, func(t *testing.T) {
+			devices, err := mod.VolumeDevices(c.volume)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, devices)
+		})
+	}
+
+	_, err := mod.VolumeDevices("missing")
+	require.Error(t, err)
+	require.True(t, os.IsNotExist(errors.Cause(err)))
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"github.com/threefoldtech/zos/pkg/tracing"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// swapVolume is the volume holding the swap file
+	swapVolume = "zos-swap"
+	swapFile   = "swap"
+	// swapMapper is the name of the device mapper decrypting the swap
+	swapMapper = "zos-swap"
+	// swapParam is the kernel parameter giving the size of the swap in GiB
+	swapParam = "swap"
+)
+
+var swapDevice = filepath.Join("/dev/mapper", swapMapper)
+
+// SwapSizeFromParams reads the size of the swap of the node from its kernel
+// parameters, swap=<size in GiB>. It returns 0 if the node has no swap
+func SwapSizeFromParams(params kernel.Params) (uint64, error) {
+	values, ok := params.Get(swapParam)
+	if !ok || len(values) == 0 || values[0] == "" {
+		return 0, nil
+	}
+
+	size, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid swap size '%s', it must be in GiB", values[0])
+	}
+
+	return size * gib, nil
+}
+
+// SetupSwap sets the size of the encrypted swap of the node and turns it
+// on, 0 disables the swap
+func SetupSwap(module pkg.StorageModule, size uint64) error {
+	s, ok := module.(*storageModule)
+	if !ok {
+		return fmt.Errorf("swap not supported by this storage module")
+	}
+
+	s.swapMu.Lock()
+	s.swapSize = size
+	s.swapMu.Unlock()
+
+	if size == 0 {
+		log.Info().Msg("swap disabled")
+		return nil
+	}
+
+	return s.enableSwap(audit.System)
+}
+
+// SwapOn implements pkg.StorageModule
+func (s *storageModule) SwapOn() (err error) {
+	// the node has a single swap, its calls have no key
+	ctx, span := tracing.Serve("SwapOn", "")
+	defer func() { span.Finish(err) }()
+
+	return s.enableSwap(audit.Caller(ctx))
+}
+
+// enableSwap turns the swap on, the operation is recorded for caller
+func (s *storageModule) enableSwap(caller string) (err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	defer func() {
+		s.audit.Record("SwapOn", caller, swapVolume, nil, err)
+	}()
+
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	if s.swapSize == 0 {
+		return fmt.Errorf("swap is not enabled on this node")
+	}
+
+	// the mapping survives storaged restarts
+	if _, err := os.Stat(swapDevice); err == nil {
+		if err := swapOn(swapDevice); err != nil && err != unix.EBUSY {
+			return err
+		}
+		return nil
+	}
+
+	path, err := s.swapFile()
+	if err != nil {
+		return err
+	}
+
+	output, err := exec.Command("losetup", "--find", "--show", path).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to attach swap file: %s", string(output))
+	}
+	loop := strings.TrimSpace(string(output))
+
+	defer func() {
+		if err != nil {
+			_ = exec.Command("cryptsetup", "close", swapMapper).Run()
+			_ = exec.Command("losetup", "--detach", loop).Run()
+		}
+	}()
+
+	// the key is read from the random device by cryptsetup and only
+	// lives in the kernel, the previous content of the file is garbage
+	output, err = exec.Command("cryptsetup", "open",
+		"--type", "plain",
+		"--cipher", "aes-xts-plain64",
+		"--key-size", "512",
+		"--key-file", "/dev/urandom",
+		loop, swapMapper,
+	).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to encrypt swap: %s", string(output))
+	}
+
+	if output, err := exec.Command("mkswap", swapDevice).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to format swap: %s", string(output))
+	}
+
+	if err := swapOn(swapDevice); err != nil {
+		return err
+	}
+
+	log.Info().Uint64("size", s.swapSize).Str("device", loop).Msg("encrypted swap enabled")
+	return nil
+}
+
+// SwapOff implements pkg.StorageModule
+func (s *storageModule) SwapOff() (err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("SwapOff", "")
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("SwapOff", audit.Caller(ctx), swapVolume, nil, err)
+	}()
+
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	if _, err := os.Stat(swapDevice); os.IsNotExist(err) {
+		return nil
+	}
+
+	// the loop device is needed to detach it once the mapping is gone
+	loop, err := swapBackingDevice()
+	if err != nil {
+		return err
+	}
+
+	if err := swapOff(swapDevice); err != nil && err != unix.EINVAL {
+		return err
+	}
+
+	// closing the mapping destroys the key
+	if output, err := exec.Command("cryptsetup", "close", swapMapper).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to close encrypted swap: %s", string(output))
+	}
+
+	if output, err := exec.Command("losetup", "--detach", loop).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to detach swap file: %s", string(output))
+	}
+
+	log.Info().Msg("encrypted swap disabled")
+	return nil
+}
+
+// swapFile returns the preallocated swap file, creating it on the
+// first boot with swap enabled or when the swap size changed
+func (s *storageModule) swapFile() (string, error) {
+	s.mu.RLock()
+	_, volume, err := s.findVolume(swapVolume)
+	s.mu.RUnlock()
+
+	if errors.Cause(err) == os.ErrNotExist {
+		volume, err = s.createSubvol(s.swapSize, swapVolume, pkg.SSDDevice, pkg.VolumeKindSwap)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get swap volume")
+	}
+
+	if err := volume.Limit(s.swapSize); err != nil {
+		return "", errors.Wrap(err, "failed to resize swap volume")
+	}
+
+	path := filepath.Join(volume.Path(), swapFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// the space is allocated up front, running out of space while
+	// swapping would kill the processes being swapped out
+	if err := unix.Fallocate(int(file.Fd()), 0, 0, int64(s.swapSize)); err != nil {
+		return "", errors.Wrap(err, "failed to allocate swap file")
+	}
+
+	// the swap may have been bigger at the previous boot
+	if err := file.Truncate(int64(s.swapSize)); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// swapBackingDevice returns the loop device under the swap mapping
+func swapBackingDevice() (string, error) {
+	output, err := exec.Command("cryptsetup", "status", swapMapper).CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get encrypted swap status: %s", string(output))
+	}
+
+	return parseCryptStatus(string(output))
+}
+
+func parseCryptStatus(output string) (string, error) {
+	//   device:  /dev/loop0
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "device:" {
+			return fields[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("no backing device in encrypted swap status")
+}
+
+// swapOn and swapOff have no wrapper in x/sys
+func swapOn(device string) error {
+	return swapCall(unix.SYS_SWAPON, device)
+}
+
+func swapOff(device string) error {
+	return swapCall(unix.SYS_SWAPOFF, device)
+}
+
+func swapCall(trap uintptr, device string) error {
+	path, err := unix.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+
+	if _, _, errno := unix.Syscall(trap, uintptr(unsafe.Pointer(path)), 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestSwapSizeFromParams(t *testing.T) {
+	require := require.New(t)
+
+	size, err := SwapSizeFromParams(kernel.Params{})
+	require.NoError(err)
+	require.EqualValues(0, size)
+
+	size, err = SwapSizeFromParams(kernel.Params{"swap": {"4"}})
+	require.NoError(err)
+	require.EqualValues(4*gib, size)
+
+	_, err = SwapSizeFromParams(kernel.Params{"swap": {"4G"}})
+	require.Error(err)
+}
+
+func TestParseCryptStatus(t *testing.T) {
+	require := require.New(t)
+
+	const status = `/dev/mapper/zos-swap is active and is in use.
+  type:    PLAIN
+  cipher:  aes-xts-plain64
+  keysize: 512 bits
+  key location: dm-crypt
+  device:  /dev/loop3
+  loop:    /mnt/pool/zos-swap/swap
+  sector size:  512
+  offset:  0 sectors
+  size:    8388608 sectors
+  mode:    read/write
+`
+
+	device, err := parseCryptStatus(status)
+	require.NoError(err)
+	require.Equal("/dev/loop3", device)
+
+	_, err = parseCryptStatus("/dev/mapper/zos-swap is inactive.\n")
+	require.Error(err)
+}
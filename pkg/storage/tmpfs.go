@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"golang.org/x/sys/unix"
+)
+
+// newMemoryPool creates the pool of the tmpfs volumes, they can
+// use at most half of the node memory all together
+func newMemoryPool() (filesystem.Pool, error) {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return nil, errors.Wrap(err, "failed to get memory size")
+	}
+
+	size := uint64(info.Totalram) * uint64(info.Unit) / 2
+	return filesystem.NewTmpfsPool(memoryPoolPath, size)
+}
+
+// allPools returns the disk pools followed by the memory pool
+func (s *storageModule) allPools() []filesystem.Pool {
+	if s.memory == nil {
+		return s.volumes
+	}
+
+	pools := make([]filesystem.Pool, 0, len(s.volumes)+1)
+	pools = append(pools, s.volumes...)
+	return append(pools, s.memory)
+}
+
+// createTmpfs creates a tmpfs volume of size bytes in the memory pool. The
+// memory is not allocated until it is written, but the sizes of the volumes
+// can't add up to more than the pool size
+func (s *storageModule) createTmpfs(size uint64, name string) (filesystem.Volume, error) {
+	if s.memory == nil {
+		return nil, pkg.ErrNotEnoughSpace{DeviceType: pkg.MemoryDevice}
+	}
+
+	if size == 0 {
+		return nil, fmt.Errorf("tmpfs volume size cannot be 0")
+	}
+
+	usage, err := s.memory.Usage()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get memory pool usage")
+	}
+
+	reserved, err := s.memory.Reserved()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get memory pool size")
+	}
+
+	if reserved+size > usage.Size {
+		return nil, pkg.ErrNotEnoughSpace{DeviceType: pkg.MemoryDevice}
+	}
+
+	volume, err := s.memory.AddVolume(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tmpfs volume")
+	}
+
+	if err := volume.Limit(size); err != nil {
+		s.memory.RemoveVolume(name) // try to recover
+		return nil, errors.Wrap(err, "failed to set tmpfs volume size")
+	}
+
+	if err := writeMeta(s.memory, name, volumeMeta{Kind: pkg.VolumeKindTmpfs, Created: time.Now()}); err != nil {
+		s.memory.RemoveVolume(name) // try to recover
+		return nil, err
+	}
+
+	log.Debug().Str("volume", name).Uint64("size", size).Msg("tmpfs volume created")
+	return volume, nil
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
+	"github.com/threefoldtech/zos/pkg/storage/filesystem"
+	"github.com/threefoldtech/zos/pkg/tracing"
+)
+
+const (
+	// metaDir is the directory at the root of a pool where
+	// the metadata of the volumes is kept
+	metaDir = ".volumes"
+)
+
+// volumeMeta is the metadata stored for each volume
+type volumeMeta struct {
+	Kind pkg.VolumeKind `json:"kind"`
+	// Owner is the reservation the volume belongs to, empty for
+	// the volumes of the system or shared between reservations
+	Owner   string            `json:"owner,omitempty"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func metaPath(pool filesystem.Pool, name string) string {
+	return filepath.Join(pool.Path(), metaDir, name+".json")
+}
+
+func writeMeta(pool filesystem.Pool, name string, meta volumeMeta) error {
+	path := metaPath(pool, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeFileSync(path, data)
+}
+
+// writeFileSync writes data to a temporary file then moves it to
+// path, so a crash never leaves a partially written file
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readMeta reads the metadata of the volume name. Volumes created before
+// the metadata was kept get their kind derived from their name
+func readMeta(pool filesystem.Pool, name string) (volumeMeta, error) {
+	data, err := ioutil.ReadFile(metaPath(pool, name))
+	if os.IsNotExist(err) {
+		return volumeMeta{Kind: inferKind(name)}, nil
+	} else if err != nil {
+		return volumeMeta{}, err
+	}
+
+	var meta volumeMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, errors.Wrapf(err, "invalid metadata of volume %s", name)
+	}
+
+	return meta, nil
+}
+
+func removeMeta(pool filesystem.Pool, name string) error {
+	if err := os.Remove(metaPath(pool, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// findVolume returns the volume called name and the pool it lives in
+func (s *storageModule) findVolume(name string) (filesystem.Pool, filesystem.Volume, error) {
+	for _, pool := range s.allPools() {
+		volumes, err := pool.Volumes()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to list volumes of pool %s", pool.Name())
+		}
+
+		for _, volume := range volumes {
+			if volume.Name() == name {
+				return pool, volume, nil
+			}
+		}
+	}
+
+	return nil, nil, errors.Wrapf(os.ErrNotExist, "subvolume '%s' not found", name)
+}
+
+// LabelVolume implements pkg.StorageModule
+func (s *storageModule) LabelVolume(name, owner string, labels map[string]string) (err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("LabelVolume", name)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("LabelVolume", audit.Caller(ctx), name, []interface{}{name, owner, labels}, err)
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, volume, err := s.findVolume(name)
+	if err != nil {
+		return err
+	}
+
+	meta, err := readMeta(pool, name)
+	if err != nil {
+		return err
+	}
+
+	if meta.Owner != "" && owner != "" && meta.Owner != owner {
+		return fmt.Errorf("volume %s is already owned by %s", name, meta.Owner)
+	}
+	if owner != "" {
+		meta.Owner = owner
+	}
+
+	for key, value := range labels {
+		if meta.Labels == nil {
+			meta.Labels = make(map[string]string)
+		}
+		if value == "" {
+			delete(meta.Labels, key)
+			continue
+		}
+		meta.Labels[key] = value
+	}
+
+	if err := writeMeta(pool, name, meta); err != nil {
+		return err
+	}
+
+	if _, ok := labels[ownerLabel]; !ok {
+		return nil
+	}
+
+	return s.assignOwner(pool, volume, meta)
+}
+
+// ListVolumes implements pkg.StorageModule
+func (s *storageModule) ListVolumes(kind pkg.VolumeKind) ([]pkg.VolumeInfo, error) {
+	if kind != "" {
+		if err := kind.Valid(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []pkg.VolumeInfo
+	for _, pool := range s.allPools() {
+		volumes, err := pool.Volumes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list volumes of pool %s", pool.Name())
+		}
+
+		for _, volume := range volumes {
+			meta, err := readMeta(pool, volume.Name())
+			if err != nil {
+				return nil, err
+			}
+
+			if kind != "" && meta.Kind != kind {
+				continue
+			}
+
+			info := pkg.VolumeInfo{
+				Name:     volume.Name(),
+				Path:     volume.Path(),
+				Kind:     meta.Kind,
+				Pool:     pool.Name(),
+				DiskType: pool.Type(),
+				Owner:    meta.Owner,
+				Created:  meta.Created,
+				Labels:   meta.Labels,
+			}
+
+			if usage, err := volume.Usage(); err != nil {
+				log.Error().Err(err).Str("volume", volume.Name()).Msg("failed to get volume usage")
+			} else {
+				info.Size = usage.Size
+				info.Used = usage.Used
+			}
+
+			result = append(result, info)
+		}
+	}
+
+	return result, nil
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/rs/zerolog/log"
@@ -11,8 +14,10 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/audit"
 	"github.com/threefoldtech/zos/pkg/storage/filesystem"
 	"github.com/threefoldtech/zos/pkg/storage/zdbpool"
+	"github.com/threefoldtech/zos/pkg/tracing"
 )
 
 func (s *storageModule) Find(nsID string) (allocation pkg.Allocation, err error) {
@@ -51,6 +56,19 @@ func (s *storageModule) Find(nsID string) (allocation pkg.Allocation, err error)
 // of specified size, type and mode
 // it returns the volume ID and its path or an error if it couldn't allocate enough storage
 func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint64, mode pkg.ZDBMode) (allocation pkg.Allocation, err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return allocation, err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("Allocate", nsID)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("Allocate", audit.Caller(ctx), nsID, []interface{}{nsID, diskType, size, mode}, err)
+	}()
+
 	log := log.With().
 		Str("type", string(diskType)).
 		Uint64("size", size).
@@ -61,6 +79,10 @@ func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint
 		return allocation, pkg.ErrInvalidDeviceType{DeviceType: diskType}
 	}
 
+	if err := s.limiter.Allow(allocations); err != nil {
+		return allocation, err
+	}
+
 	log.Info().Msg("try to allocation space for 0-DB")
 
 	for _, pool := range s.volumes {
@@ -98,6 +120,27 @@ func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint
 		}
 	}
 
+	volume, err := s.zdbVolume(diskType, size, mode)
+	if err != nil {
+		return allocation, err
+	}
+
+	zdb := zdbpool.New(volume.Path())
+
+	if err := zdb.Create(nsID, "", size); err != nil {
+		return allocation, errors.Wrapf(err, "failed to create namespace directory: '%s/%s'", volume.Path(), nsID)
+	}
+
+	return pkg.Allocation{
+		VolumeID:   volume.Name(),
+		VolumePath: volume.Path(),
+	}, nil
+
+}
+
+// zdbVolume returns a 0-db volume running in mode with enough space for a new
+// namespace of size. A new volume is created if none of the existing ones fit
+func (s *storageModule) zdbVolume(diskType pkg.DeviceType, size uint64, mode pkg.ZDBMode) (filesystem.Volume, error) {
 	type Candidate struct {
 		filesystem.Volume
 		Free uint64
@@ -118,14 +161,19 @@ func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint
 			continue
 		}
 
+		// new namespaces don't go on failing disks
+		if s.draining(pool) {
+			continue
+		}
+
 		usage, err := pool.Usage()
 		if err != nil {
-			return allocation, errors.Wrapf(err, "failed to read usage of pool %s", pool.Name())
+			return nil, errors.Wrapf(err, "failed to read usage of pool %s", pool.Name())
 		}
 
 		volumes, err := pool.Volumes()
 		if err != nil {
-			return allocation, errors.Wrapf(err, "failed to list volume on pool %s", pool.Name())
+			return nil, errors.Wrapf(err, "failed to list volume on pool %s", pool.Name())
 		}
 
 		for _, volume := range volumes {
@@ -136,7 +184,7 @@ func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint
 
 			volumeUsage, err := volume.Usage()
 			if err != nil {
-				return allocation, errors.Wrapf(err, "failed to list namespaces from volume '%s'", volume.Path())
+				return nil, errors.Wrapf(err, "failed to list namespaces from volume '%s'", volume.Path())
 			}
 
 			if volumeUsage.Size+size > usage.Size {
@@ -167,45 +215,37 @@ func (s *storageModule) Allocate(nsID string, diskType pkg.DeviceType, size uint
 		}
 	}
 
-	var volume filesystem.Volume
 	if len(candidates) > 0 {
 		// reverse sort by free space
 		sort.Slice(candidates, func(i, j int) bool {
 			return candidates[i].Free > candidates[j].Free
 		})
 
-		volume = candidates[0]
-	} else {
-		// no candidates, so we have to try to create a new subvolume.
-		// and start a new zdb instance
-		name, err := genZDBPoolName()
-		if err != nil {
-			return allocation, errors.Wrap(err, "failed to generate new sub-volume name")
-		}
-
-		// we create the zdb instance with 0 (unlimited) because this subvolume is gonna
-		// be used for a new instance of ZDB.
-		volume, err = s.createSubvol(0, name, diskType)
-		if err != nil {
-			return allocation, errors.Wrap(err, "failed to create sub-volume")
-		}
+		return candidates[0], nil
 	}
 
-	zdb := zdbpool.New(volume.Path())
-
-	if err := zdb.Create(nsID, "", size); err != nil {
-		return allocation, errors.Wrapf(err, "failed to create namespace directory: '%s/%s'", volume.Path(), nsID)
+	// no candidates, so we have to try to create a new subvolume.
+	// and start a new zdb instance
+	name, err := genZDBPoolName()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate new sub-volume name")
 	}
 
-	return pkg.Allocation{
-		VolumeID:   volume.Name(),
-		VolumePath: volume.Path(),
-	}, nil
+	// we create the zdb instance with 0 (unlimited) because this subvolume is gonna
+	// be used for a new instance of ZDB.
+	volume, err := s.createSubvol(0, name, diskType, pkg.VolumeKindZDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create sub-volume")
+	}
 
+	return volume, nil
 }
 
 const zdbPoolPrefix = "zdb"
 
+// exportDir is where the archives of the exported namespaces are written
+var exportDir = filepath.Join(CacheTarget, "modules", "storaged", "exports")
+
 func genZDBPoolName() (string, error) {
 	id, err := uuid.NewRandom()
 	if err != nil {
@@ -214,3 +254,111 @@ func genZDBPoolName() (string, error) {
 	name := zdbPoolPrefix + id.String()
 	return name, nil
 }
+
+// Export implements pkg.ZDBAllocater
+func (s *storageModule) Export(nsID string) (path string, err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("Export", nsID)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("Export", audit.Caller(ctx), nsID, nsID, err)
+	}()
+
+	allocation, err := s.Find(nsID)
+	if err != nil {
+		return "", errors.Wrapf(err, "namespace %s", nsID)
+	}
+
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return "", errors.Wrap(err, "failed to create exports directory")
+	}
+
+	path = filepath.Join(exportDir, nsID+".tar.gz")
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	zdb := zdbpool.New(allocation.VolumePath)
+	if err := zdb.Export(nsID, file); err != nil {
+		return "", errors.Wrapf(err, "failed to export namespace %s", nsID)
+	}
+
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", err
+	}
+
+	log.Info().Str("namespace", nsID).Str("archive", path).Msg("namespace exported")
+	return path, nil
+}
+
+// Import implements pkg.ZDBAllocater
+func (s *storageModule) Import(path string, diskType pkg.DeviceType, mode pkg.ZDBMode) (allocation pkg.Allocation, err error) {
+	done, err := s.inflight.Begin()
+	if err != nil {
+		return allocation, err
+	}
+	defer done()
+
+	ctx, span := tracing.Serve("Import", path)
+	defer func() { span.Finish(err) }()
+
+	defer func() {
+		s.audit.Record("Import", audit.Caller(ctx), path, []interface{}{path, diskType, mode}, err)
+	}()
+
+	if diskType != pkg.HDDDevice && diskType != pkg.SSDDevice {
+		return allocation, pkg.ErrInvalidDeviceType{DeviceType: diskType}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return allocation, err
+	}
+	defer file.Close()
+
+	header, indexMode, err := zdbpool.ReadArchive(file)
+	if err != nil {
+		return allocation, err
+	}
+
+	if (mode == pkg.ZDBModeSeq) != (indexMode == zdbpool.IndexModeSequential) {
+		return allocation, fmt.Errorf("namespace %s is in %s mode", header.Name, indexMode)
+	}
+
+	if _, err := s.Find(header.Name); err == nil {
+		return allocation, fmt.Errorf("namespace %s already exists", header.Name)
+	}
+
+	volume, err := s.zdbVolume(diskType, header.MaxSize, mode)
+	if err != nil {
+		return allocation, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return allocation, err
+	}
+
+	zdb := zdbpool.New(volume.Path())
+	if _, err := zdb.Import(file); err != nil {
+		return allocation, errors.Wrapf(err, "failed to import namespace %s", header.Name)
+	}
+
+	log.Info().Str("namespace", header.Name).Str("volume", volume.Name()).Msg("namespace imported")
+	return pkg.Allocation{
+		VolumeID:   volume.Name(),
+		VolumePath: volume.Path(),
+	}, nil
+}
@@ -0,0 +1,188 @@
+package zdbpool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	namespaceFile = "zdb-namespace"
+	indexFile     = "zdb-index-00000"
+	// filePrefix is the prefix of all the files 0-db
+	// creates in the directory of a namespace
+	filePrefix = "zdb-"
+)
+
+// Export writes the files of the namespace name to w as a gzipped tar
+// archive. The namespace must not be written to while it is exported
+func (p *ZDBPool) Export(name string, w io.Writer) error {
+	if !p.Exists(name) {
+		return errors.Wrapf(os.ErrNotExist, "namespace '%s' not found", name)
+	}
+
+	dir := filepath.Join(p.path, name)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !strings.HasPrefix(file.Name(), filePrefix) {
+			continue
+		}
+
+		if err := addFile(archive, filepath.Join(dir, file.Name()), file); err != nil {
+			return errors.Wrapf(err, "failed to archive '%s'", file.Name())
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func addFile(archive *tar.Writer, path string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the size in the header must match, files grown since
+	// listing them are truncated
+	_, err = io.CopyN(archive, file, info.Size())
+	return err
+}
+
+// ReadArchive reads the namespace header and the index mode of the
+// namespace stored in the archive created by Export
+func ReadArchive(r io.Reader) (header Header, mode IndexMode, err error) {
+	var foundHeader, foundMode bool
+	err = walkArchive(r, func(name string, content io.Reader) error {
+		switch name {
+		case namespaceFile:
+			header, err = ReadHeader(content)
+			foundHeader = true
+		case indexFile:
+			var index IndexHeader
+			index, err = ReadIndex(content)
+			mode = index.Mode
+			foundMode = true
+		}
+		return err
+	})
+
+	if err != nil {
+		return header, mode, err
+	}
+
+	if !foundHeader || !foundMode {
+		return header, mode, fmt.Errorf("invalid namespace archive, missing namespace descriptor or index")
+	}
+
+	return header, mode, nil
+}
+
+// Import extracts the namespace archive created by Export from r
+// into the pool and returns the header of the imported namespace
+func (p *ZDBPool) Import(r io.Reader) (header Header, err error) {
+	tmp, err := ioutil.TempDir(p.path, ".import-")
+	if err != nil {
+		return header, err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = walkArchive(r, func(name string, content io.Reader) error {
+		file, err := os.OpenFile(filepath.Join(tmp, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, content); err != nil {
+			return err
+		}
+
+		return file.Sync()
+	})
+	if err != nil {
+		return header, errors.Wrap(err, "failed to extract namespace archive")
+	}
+
+	descriptor, err := ioutil.ReadFile(filepath.Join(tmp, namespaceFile))
+	if err != nil {
+		return header, errors.Wrap(err, "invalid namespace archive, missing namespace descriptor")
+	}
+
+	header, err = ReadHeader(bytes.NewReader(descriptor))
+	if err != nil {
+		return header, errors.Wrap(err, "invalid namespace descriptor")
+	}
+
+	if len(header.Name) == 0 || header.Name != filepath.Base(header.Name) || header.Name == "default" {
+		return header, fmt.Errorf("invalid namespace name '%s'", header.Name)
+	}
+
+	if p.Exists(header.Name) {
+		return header, fmt.Errorf("namespace '%s' already exists", header.Name)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(p.path, header.Name)); err != nil {
+		return header, err
+	}
+
+	return header, os.Chmod(filepath.Join(p.path, header.Name), 0755)
+}
+
+// walkArchive calls fn for each file of the namespace archive. Only flat
+// regular files named like the 0-db files are accepted
+func walkArchive(r io.Reader, fn func(name string, content io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg ||
+			header.Name != filepath.Base(header.Name) ||
+			!strings.HasPrefix(header.Name, filePrefix) {
+			return fmt.Errorf("unexpected entry '%s' in namespace archive", header.Name)
+		}
+
+		if err := fn(header.Name, archive); err != nil {
+			return err
+		}
+	}
+}
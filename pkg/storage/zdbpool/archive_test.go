@@ -0,0 +1,76 @@
+package zdbpool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	root, err := ioutil.TempDir("", "zdbpool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	src := New(filepath.Join(root, "src"))
+	dst := New(filepath.Join(root, "dst"))
+	require.NoError(t, os.MkdirAll(filepath.Join(src.path, "test"), 0755))
+	require.NoError(t, os.MkdirAll(dst.path, 0755))
+
+	// the test namespace header is named "test"
+	for _, name := range []string{"zdb-namespace", "zdb-index-00000"} {
+		data, err := ioutil.ReadFile(filepath.Join("test_data", name))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src.path, "test", name), data, 0644))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src.path, "test", "zdb-data-00000"), []byte("data"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Export("test", &buf))
+	assert.Error(t, src.Export("unknown", ioutil.Discard))
+
+	header, mode, err := ReadArchive(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "test", header.Name)
+	assert.Equal(t, IndexModeKeyValue, mode)
+
+	header, err = dst.Import(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "test", header.Name)
+	assert.True(t, dst.Exists("test"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dst.path, "test", "zdb-data-00000"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// importing twice fails
+	_, err = dst.Import(bytes.NewReader(buf.Bytes()))
+	assert.Error(t, err)
+}
+
+func TestImportRejectsPaths(t *testing.T) {
+	root, err := ioutil.TempDir("", "zdbpool")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	require.NoError(t, archive.WriteHeader(&tar.Header{Name: "../zdb-namespace", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, archive.Close())
+	require.NoError(t, gz.Close())
+
+	pool := New(root)
+	_, err = pool.Import(&buf)
+	assert.Error(t, err)
+
+	files, err := ioutil.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ZramDevice is the device the node swaps to
+	ZramDevice = "/dev/zram0"
+
+	zramSysfs = "/sys/block/zram0"
+
+	// the kernel parameters configuring the swap of the node
+	zramParam          = "zram"
+	zramAlgorithmParam = "zram-algorithm"
+)
+
+// ZramConfig is the compressed swap of the node, it is held in memory so
+// it only holds the pages that compress well
+type ZramConfig struct {
+	// Enabled is true if the node swaps to zram
+	Enabled bool
+	// Fraction is the size of the swap as a fraction of the memory, the
+	// memory used is this size divided by the compression ratio
+	Fraction float64
+	// Algorithm is the compression algorithm, one of the
+	// algorithms listed by the kernel in comp_algorithm
+	Algorithm string
+}
+
+// DefaultZramConfig is the swap of a node with zram enabled
+// and no size or algorithm set
+var DefaultZramConfig = ZramConfig{
+	Fraction:  0.25,
+	Algorithm: "lzo-rle",
+}
+
+// ZramConfigFromParams reads the swap configuration of the node from its
+// kernel parameters. zram enables the swap, zram=<fraction> sets its size
+// and zram-algorithm=<name> its compression algorithm
+func ZramConfigFromParams(params kernel.Params) (ZramConfig, error) {
+	config := DefaultZramConfig
+
+	values, ok := params.Get(zramParam)
+	if !ok {
+		return config, nil
+	}
+	config.Enabled = true
+
+	if len(values) > 0 && values[0] != "" {
+		fraction, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			return config, fmt.Errorf("invalid zram fraction '%s', it must be in ]0, 1]", values[0])
+		}
+		config.Fraction = fraction
+	}
+
+	if values, ok := params.Get(zramAlgorithmParam); ok && len(values) > 0 && values[0] != "" {
+		config.Algorithm = values[0]
+	}
+
+	return config, nil
+}
+
+// SetupZram creates the zram device and swaps to it. It does
+// nothing if the swap is disabled or already set up. The zram
+// kernel module is loaded by the broker
+func SetupZram(config ZramConfig, broker pkg.Broker) error {
+	if !config.Enabled {
+		log.Info().Msg("zram swap disabled")
+		return nil
+	}
+
+	if _, err := os.Stat(zramSysfs); os.IsNotExist(err) {
+		if err := broker.LoadKernelModule("zram", []string{"num_devices=1"}); err != nil {
+			return errors.Wrap(err, "failed to load zram module")
+		}
+	}
+
+	// the size can only be set once until the device is reset
+	disksize, err := readZram("disksize")
+	if err != nil {
+		return err
+	}
+	if disksize != "0" {
+		log.Info().Str("size", disksize).Msg("zram swap already set up")
+		return nil
+	}
+
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return errors.Wrap(err, "failed to get memory size")
+	}
+	size := uint64(float64(uint64(info.Totalram)*uint64(info.Unit)) * config.Fraction)
+
+	// the algorithm must be set before the size
+	if err := writeZram("comp_algorithm", config.Algorithm); err != nil {
+		return errors.Wrapf(err, "unsupported zram compression algorithm '%s'", config.Algorithm)
+	}
+
+	if err := writeZram("disksize", fmt.Sprint(size)); err != nil {
+		return err
+	}
+
+	if output, err := exec.Command("mkswap", ZramDevice).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to format zram swap: %s", string(output))
+	}
+
+	if err := swapOn(ZramDevice); err != nil {
+		return errors.Wrap(err, "failed to enable zram swap")
+	}
+
+	log.Info().Uint64("size", size).Str("algorithm", config.Algorithm).Msg("zram swap enabled")
+	return nil
+}
+
+func readZram(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(zramSysfs, name))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read zram %s", name)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeZram(name, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(zramSysfs, name), []byte(value), 0644); err != nil {
+		return errors.Wrapf(err, "failed to set zram %s", name)
+	}
+
+	return nil
+}
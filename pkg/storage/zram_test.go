@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestZramConfigFromParams(t *testing.T) {
+	require := require.New(t)
+
+	config, err := ZramConfigFromParams(kernel.Params{})
+	require.NoError(err)
+	require.False(config.Enabled)
+
+	config, err = ZramConfigFromParams(kernel.Params{"zram": nil})
+	require.NoError(err)
+	require.True(config.Enabled)
+	require.Equal(DefaultZramConfig.Fraction, config.Fraction)
+	require.Equal(DefaultZramConfig.Algorithm, config.Algorithm)
+
+	config, err = ZramConfigFromParams(kernel.Params{"zram": {"0.5"}, "zram-algorithm": {"zstd"}})
+	require.NoError(err)
+	require.True(config.Enabled)
+	require.Equal(0.5, config.Fraction)
+	require.Equal("zstd", config.Algorithm)
+
+	_, err = ZramConfigFromParams(kernel.Params{"zram": {"2"}})
+	require.Error(err)
+
+	_, err = ZramConfigFromParams(kernel.Params{"zram": {"half"}})
+	require.Error(err)
+}
@@ -0,0 +1,57 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type AuditorStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewAuditorStub(client zbus.Client) *AuditorStub {
+	return &AuditorStub{
+		client: client,
+		module: "identityd",
+		object: zbus.ObjectID{
+			Name:    "audit",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *AuditorStub) Query(arg0 pkg.AuditFilter) (ret0 []pkg.AuditEntry, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Query", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Query", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Query", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Query", err)
+		return
+	}
+	return
+}
+
+func (s *AuditorStub) Verify(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Verify", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Verify", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Verify", err)
+		return
+	}
+	return
+}
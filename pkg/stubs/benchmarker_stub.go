@@ -0,0 +1,76 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type BenchmarkerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewBenchmarkerStub(client zbus.Client) *BenchmarkerStub {
+	return &BenchmarkerStub{
+		client: client,
+		module: "monitor",
+		object: zbus.ObjectID{
+			Name:    "benchmark",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *BenchmarkerStub) Report() (ret0 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Report", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Report", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Report", err)
+		return
+	}
+	return
+}
+
+func (s *BenchmarkerStub) Run() (ret0 pkg.Scorecard, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Run", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Run", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Run", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Run", err)
+		return
+	}
+	return
+}
+
+func (s *BenchmarkerStub) Scorecards() (ret0 []pkg.Scorecard, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Scorecards", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Scorecards", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Scorecards", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Scorecards", err)
+		return
+	}
+	return
+}
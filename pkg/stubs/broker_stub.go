@@ -0,0 +1,98 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	"time"
+)
+
+type BrokerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewBrokerStub(client zbus.Client) *BrokerStub {
+	return &BrokerStub{
+		client: client,
+		module: "broker",
+		object: zbus.ObjectID{
+			Name:    "broker",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *BrokerStub) ConfigureCoreDumps(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "ConfigureCoreDumps", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "ConfigureCoreDumps", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "ConfigureCoreDumps", err)
+		return
+	}
+	return
+}
+
+func (s *BrokerStub) LoadKernelModule(arg0 string, arg1 []string) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "LoadKernelModule", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "LoadKernelModule", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "LoadKernelModule", err)
+		return
+	}
+	return
+}
+
+func (s *BrokerStub) Mount(arg0 string, arg1 string, arg2 string, arg3 []string) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "Mount", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Mount", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Mount", err)
+		return
+	}
+	return
+}
+
+func (s *BrokerStub) Probe(arg0 string, arg1 string, arg2 string, arg3 string, arg4 time.Duration) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3, arg4}
+	result, err := s.client.Request(s.module, s.object, "Probe", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Probe", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Probe", err)
+		return
+	}
+	return
+}
+
+func (s *BrokerStub) Unmount(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Unmount", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Unmount", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Unmount", err)
+		return
+	}
+	return
+}
@@ -1,6 +1,8 @@
 package stubs
 
 import (
+	"context"
+
 	zbus "github.com/threefoldtech/zbus"
 	pkg "github.com/threefoldtech/zos/pkg"
 )
@@ -22,31 +24,109 @@ func NewContainerModuleStub(client zbus.Client) *ContainerModuleStub {
 	}
 }
 
+func (s *ContainerModuleStub) Checkpoint(arg0 string, arg1 pkg.ContainerID, arg2 bool) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "Checkpoint", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Checkpoint", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Checkpoint", err)
+		return
+	}
+	return
+}
+
+func (s *ContainerModuleStub) CoreDump(arg0 string, arg1 pkg.ContainerID, arg2 string, arg3 string) (ret0 []byte, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "CoreDump", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "CoreDump", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "CoreDump", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "CoreDump", err)
+		return
+	}
+	return
+}
+
+func (s *ContainerModuleStub) Crashes(arg0 string, arg1 pkg.ContainerID) (ret0 []pkg.CrashReport, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Crashes", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Crashes", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Crashes", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Crashes", err)
+		return
+	}
+	return
+}
+
 func (s *ContainerModuleStub) Delete(arg0 string, arg1 pkg.ContainerID) (ret0 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Delete", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Delete", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Delete", err)
+		return
 	}
 	return
 }
 
+func (s *ContainerModuleStub) Health(ctx context.Context) (<-chan pkg.HealthEvent, error) {
+	ch := make(chan pkg.HealthEvent)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Health")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.HealthEvent
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Health", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
 func (s *ContainerModuleStub) Inspect(arg0 string, arg1 pkg.ContainerID) (ret0 pkg.Container, ret1 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Inspect", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Inspect", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
 	}
 	return
 }
@@ -55,14 +135,75 @@ func (s *ContainerModuleStub) Run(arg0 string, arg1 pkg.Container) (ret0 pkg.Con
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Run", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Run", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Run", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Run", err)
+		return
+	}
+	return
+}
+
+func (s *ContainerModuleStub) Status(arg0 string, arg1 pkg.ContainerID) (ret0 pkg.ContainerStatus, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Status", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Status", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Status", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Status", err)
+		return
 	}
 	return
 }
+
+func (s *ContainerModuleStub) Update(arg0 string, arg1 pkg.Container) (ret0 pkg.ContainerID, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Update", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Update", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Update", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Update", err)
+		return
+	}
+	return
+}
+
+func (s *ContainerModuleStub) Violations(ctx context.Context) (<-chan pkg.SecurityViolation, error) {
+	ch := make(chan pkg.SecurityViolation)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Violations")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.SecurityViolation
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Violations", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
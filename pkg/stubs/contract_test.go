@@ -0,0 +1,219 @@
+package stubs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs/stubtest"
+)
+
+// The contract tests make sure the stubs stay in sync with the module
+// interfaces, and that every type crossing zbus survives the msgpack
+// encoding used on the wire. They don't need a running broker. The modules
+// themselves are exercised through the wire with stubtest by the tests of
+// their packages, against fake backends.
+
+var contracts = []struct {
+	iface interface{}
+	stub  interface{}
+}{
+	{(*pkg.Auditor)(nil), &AuditorStub{}},
+	{(*pkg.Benchmarker)(nil), &BenchmarkerStub{}},
+	{(*pkg.Broker)(nil), &BrokerStub{}},
+	{(*pkg.ContainerModule)(nil), &ContainerModuleStub{}},
+	{(*pkg.DNSCache)(nil), &DNSCacheStub{}},
+	{(*pkg.FeatureFlags)(nil), &FeatureFlagsStub{}},
+	{(*pkg.Flister)(nil), &FlisterStub{}},
+	{(*pkg.HostMonitor)(nil), &HostMonitorStub{}},
+	{(*pkg.IdentityBackup)(nil), &IdentityBackupStub{}},
+	{(*pkg.IdentityManager)(nil), &IdentityManagerStub{}},
+	{(*pkg.InventoryManager)(nil), &InventoryManagerStub{}},
+	{(*pkg.JobMonitor)(nil), &JobMonitorStub{}},
+	{(*pkg.Networker)(nil), &NetworkerStub{}},
+	{(*pkg.ProvisionMonitor)(nil), &ProvisionMonitorStub{}},
+	{(*pkg.ReadinessMonitor)(nil), &ReadinessMonitorStub{}},
+	{(*pkg.StorageModule)(nil), &StorageModuleStub{}},
+	{(*pkg.SystemMonitor)(nil), &SystemMonitorStub{}},
+	{(*pkg.UpgradeChannels)(nil), &UpgradeChannelsStub{}},
+	{(*pkg.UpgradePlanner)(nil), &UpgradePlannerStub{}},
+	{(*pkg.VDiskModule)(nil), &VDiskModuleStub{}},
+	{(*pkg.VersionMonitor)(nil), &VersionMonitorStub{}},
+	{(*pkg.VMModule)(nil), &VMModuleStub{}},
+	{(*pkg.ZDBAllocater)(nil), &ZDBAllocaterStub{}},
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// compatible checks that a and b have the same wire representation.
+// zbusc renders some named types with their underlying type
+// (net.IP becomes []uint8 for example)
+func compatible(a, b reflect.Type) bool {
+	if a == b {
+		return true
+	}
+
+	if a.Kind() != b.Kind() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Array:
+		return a.Len() == b.Len() && compatible(a.Elem(), b.Elem())
+	case reflect.Slice, reflect.Ptr, reflect.Chan:
+		return compatible(a.Elem(), b.Elem())
+	case reflect.Map:
+		return compatible(a.Key(), b.Key()) && compatible(a.Elem(), b.Elem())
+	case reflect.Struct, reflect.Interface, reflect.Func:
+		return false
+	}
+
+	return true
+}
+
+func isStream(m reflect.Type) bool {
+	return m.NumIn() == 1 && m.In(0) == contextType &&
+		m.NumOut() == 1 && m.Out(0).Kind() == reflect.Chan
+}
+
+// wireTypes returns all the types of the method m that are sent over zbus
+func wireTypes(m reflect.Type) []reflect.Type {
+	if isStream(m) {
+		return []reflect.Type{m.Out(0).Elem()}
+	}
+
+	var types []reflect.Type
+	for i := 0; i < m.NumIn(); i++ {
+		types = append(types, m.In(i))
+	}
+	for i := 0; i < m.NumOut(); i++ {
+		if m.Out(i) != errorType {
+			types = append(types, m.Out(i))
+		}
+	}
+
+	return types
+}
+
+func TestStubsContract(t *testing.T) {
+	for _, c := range contracts {
+		iface := reflect.TypeOf(c.iface).Elem()
+		stub := reflect.TypeOf(c.stub)
+
+		t.Run(iface.Name(), func(t *testing.T) {
+			for i := 0; i < iface.NumMethod(); i++ {
+				method := iface.Method(i)
+				stubMethod, ok := stub.MethodByName(method.Name)
+				if !assert.True(t, ok, "stub is missing method %s", method.Name) {
+					continue
+				}
+
+				// drop the receiver
+				var in []reflect.Type
+				for j := 1; j < stubMethod.Type.NumIn(); j++ {
+					in = append(in, stubMethod.Type.In(j))
+				}
+				var out []reflect.Type
+				for j := 0; j < stubMethod.Type.NumOut(); j++ {
+					out = append(out, stubMethod.Type.Out(j))
+				}
+
+				expectedOut := make([]reflect.Type, 0, method.Type.NumOut())
+				for j := 0; j < method.Type.NumOut(); j++ {
+					expectedOut = append(expectedOut, method.Type.Out(j))
+				}
+				if isStream(method.Type) {
+					// streams also return an error on the client side
+					expectedOut = append(expectedOut, errorType)
+				}
+
+				if !assert.Len(t, in, method.Type.NumIn(), "arguments of %s", method.Name) ||
+					!assert.Len(t, out, len(expectedOut), "return values of %s", method.Name) {
+					continue
+				}
+
+				for j := range in {
+					assert.True(t, compatible(method.Type.In(j), in[j]),
+						"argument %d of %s: %s != %s", j, method.Name, method.Type.In(j), in[j])
+				}
+				for j := range out {
+					assert.True(t, compatible(expectedOut[j], out[j]),
+						"return value %d of %s: %s != %s", j, method.Name, expectedOut[j], out[j])
+				}
+			}
+		})
+	}
+}
+
+// encode encodes v with sorted map keys so the
+// output of 2 equal values can be compared
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := msgpack.NewEncoder(&buf).SortMapKeys(true).Encode(v)
+	return buf.Bytes(), err
+}
+
+// roundTrip encodes v, decodes it in a new value of the same type
+// and makes sure it encodes to the same bytes
+func roundTrip(typ reflect.Type, v reflect.Value) error {
+	data, err := encode(v.Interface())
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	decoded := reflect.New(typ)
+	if err := msgpack.Unmarshal(data, decoded.Interface()); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	again, err := encode(decoded.Elem().Interface())
+	if err != nil {
+		return fmt.Errorf("marshal decoded value: %w", err)
+	}
+
+	if !bytes.Equal(data, again) {
+		return fmt.Errorf("value changed after round trip")
+	}
+
+	return nil
+}
+
+func TestStubsMarshaling(t *testing.T) {
+	const iterations = 20
+	g := stubtest.NewGenerator(1)
+
+	for _, c := range contracts {
+		iface := reflect.TypeOf(c.iface).Elem()
+		for i := 0; i < iface.NumMethod(); i++ {
+			method := iface.Method(i)
+			for _, typ := range wireTypes(method.Type) {
+				if typ == contextType {
+					continue
+				}
+
+				name := fmt.Sprintf("%s.%s/%s", iface.Name(), method.Name, typ)
+				t.Run(name, func(t *testing.T) {
+					// zero values first, they catch the nil handling issues
+					value := reflect.New(typ).Elem()
+					require.NoError(t, roundTrip(typ, value), "zero value")
+
+					for j := 0; j < iterations; j++ {
+						value := reflect.New(typ).Elem()
+						g.Fill(value)
+						require.NoError(t, roundTrip(typ, value), "value: %#v", value.Interface())
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package stubs
+
+import (
+	"context"
+
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type DNSCacheStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewDNSCacheStub(client zbus.Client) *DNSCacheStub {
+	return &DNSCacheStub{
+		client: client,
+		module: "network",
+		object: zbus.ObjectID{
+			Name:    "dns",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *DNSCacheStub) Flush(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Flush", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Flush", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Flush", err)
+		return
+	}
+	return
+}
+
+func (s *DNSCacheStub) Monitor(ctx context.Context) (<-chan pkg.DNSStats, error) {
+	ch := make(chan pkg.DNSStats)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Monitor")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.DNSStats
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Monitor", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *DNSCacheStub) Stats() (ret0 pkg.DNSStats, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Stats", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Stats", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Stats", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Stats", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package stubs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/types"
+)
+
+// The encoding benchmarks measure the cost of sending large network
+// objects over zbus. The protobuf wire format (see pkg/network_wire.go)
+// is compared to the msgpack maps and to the json representation of the
+// networks.
+//   go test -run NONE -bench Network ./pkg/stubs
+
+func largeNetwork(peers int) pkg.Network {
+	resource := pkg.NetResource{
+		NodeID:       "HAcDwf7oCWEbn7ME1W4j3ACfsUo5kUgJqhk5MEDkbKis",
+		Subnet:       types.MustParseIPNet("10.0.0.0/24"),
+		WGPrivateKey: "988c1e12dd04e5878b4cf008569f7b7163e7f3b2b619d339753c841c07dd0d6daf0b4dbc0b16e6ba29b21e7b600af76766e41e46419b05f9480e296f7934e83243680d6b7ad91a79442cfcbaf3a4898c603f15a024c2086a266fd18d",
+		WGPublicKey:  "L+V9o0fNYkMVKNqsX7spBzD/9oSvxM/C7ZCZX1jLO3Q=",
+		WGListenPort: 6380,
+	}
+
+	for i := 1; i <= peers; i++ {
+		subnet := types.NewIPNet(&net.IPNet{
+			IP:   net.IPv4(10, byte(i>>8), byte(i), 0),
+			Mask: net.CIDRMask(24, 32),
+		})
+		resource.Peers = append(resource.Peers, pkg.Peer{
+			Subnet:      subnet,
+			WGPublicKey: "L+V9o0fNYkMVKNqsX7spBzD/9oSvxM/C7ZCZX1jLO3Q=",
+			Endpoint:    fmt.Sprintf("[2a02:1802:5e:ff02::%x]:6380", i),
+			AllowedIPs: []types.IPNet{
+				subnet,
+				types.MustParseIPNet(fmt.Sprintf("100.64.%d.%d/32", byte(i>>8), byte(i))),
+			},
+		})
+	}
+
+	return pkg.Network{
+		Name:         "large",
+		NetID:        "large",
+		IPRange:      types.MustParseIPNet("10.0.0.0/8"),
+		NetResources: []pkg.NetResource{resource},
+	}
+}
+
+func benchmarkEncoding(b *testing.B, marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) {
+	for _, peers := range []int{10, 100, 500} {
+		network := largeNetwork(peers)
+		b.Run(fmt.Sprintf("peers-%d", peers), func(b *testing.B) {
+			data, err := marshal(network)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				data, err := marshal(network)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				var decoded pkg.Network
+				if err := unmarshal(data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNetworkWire(b *testing.B) {
+	benchmarkEncoding(b,
+		func(v interface{}) ([]byte, error) {
+			return msgpack.Marshal(pkg.WireNetwork(v.(pkg.Network)))
+		},
+		msgpack.Unmarshal,
+	)
+}
+
+func BenchmarkNetworkMsgpack(b *testing.B) {
+	benchmarkEncoding(b, msgpack.Marshal, msgpack.Unmarshal)
+}
+
+func BenchmarkNetworkJSON(b *testing.B) {
+	benchmarkEncoding(b, json.Marshal, json.Unmarshal)
+}
@@ -0,0 +1,134 @@
+package stubs
+
+//go:generate go run ./safestubs .
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+)
+
+// The stubs never panic on a transport or decoding failure: zbusc output is
+// rewritten by safestubs (see the go:generate above) so the failure is
+// returned as an ErrTransport or an ErrMarshal instead, which is why every
+// method of a module interface returns an error. The failures are counted
+// by method, every module serves its counters under FailuresObjectID and
+// the monitor module collects them.
+
+// ErrTransport is returned when a request didn't reach the module, or its
+// response didn't come back
+type ErrTransport struct {
+	Module string
+	Object string
+	Method string
+	Err    error
+}
+
+func (e ErrTransport) Error() string {
+	return fmt.Sprintf("failed to call %s.%s.%s: %s", e.Module, e.Object, e.Method, e.Err)
+}
+
+// Unwrap returns the error of the client
+func (e ErrTransport) Unwrap() error {
+	return e.Err
+}
+
+// ErrMarshal is returned when the response of a module can't be decoded,
+// the module and the stub disagree on the method signature
+type ErrMarshal struct {
+	Module string
+	Object string
+	Method string
+	Err    error
+}
+
+func (e ErrMarshal) Error() string {
+	return fmt.Sprintf("failed to decode response of %s.%s.%s: %s", e.Module, e.Object, e.Method, e.Err)
+}
+
+// Unwrap returns the decoding error
+func (e ErrMarshal) Unwrap() error {
+	return e.Err
+}
+
+// IsTransport checks if err is an ErrTransport
+func IsTransport(err error) bool {
+	_, ok := err.(ErrTransport)
+	return ok
+}
+
+// IsMarshal checks if err is an ErrMarshal
+func IsMarshal(err error) bool {
+	_, ok := err.(ErrMarshal)
+	return ok
+}
+
+// FailuresObjectID is the zbus object every module registers to report
+// the failed calls of its stubs
+var FailuresObjectID = zbus.ObjectID{Name: "stubs", Version: "0.0.1"}
+
+// Counters counts the failed calls of the stubs of a module
+type Counters struct {
+	mu       sync.Mutex
+	failures map[string]uint64
+}
+
+var counters = &Counters{failures: make(map[string]uint64)}
+
+// FailureCounters returns the counters of the stubs of the running module
+func FailureCounters() *Counters {
+	return counters
+}
+
+func (c *Counters) add(module string, object zbus.ObjectID, method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures[fmt.Sprintf("%s.%s.%s", module, object.Name, method)]++
+}
+
+// Failures returns the number of failed calls by module.object.method
+func (c *Counters) Failures() (map[string]uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failures := make(map[string]uint64, len(c.failures))
+	for key, count := range c.failures {
+		failures[key] = count
+	}
+
+	return failures, nil
+}
+
+func transportError(module string, object zbus.ObjectID, method string, err error) error {
+	counters.add(module, object, method)
+	return ErrTransport{Module: module, Object: object.Name, Method: method, Err: err}
+}
+
+func marshalError(module string, object zbus.ObjectID, method string, err error) error {
+	counters.add(module, object, method)
+	return ErrMarshal{Module: module, Object: object.Name, Method: method, Err: err}
+}
+
+// eventError reports an event of a stream that can't be decoded, the event
+// is skipped
+func eventError(module string, object zbus.ObjectID, method string, err error) {
+	err = marshalError(module, object, method, err)
+	log.Error().Err(err).Msg("dropping stream event")
+}
+
+// ModuleFailures requests the failed calls of the stubs of module
+func ModuleFailures(client zbus.Client, module string) (map[string]uint64, error) {
+	result, err := client.Request(module, FailuresObjectID, "Failures")
+	if err != nil {
+		return nil, ErrTransport{Module: module, Object: FailuresObjectID.Name, Method: "Failures", Err: err}
+	}
+
+	var failures map[string]uint64
+	if err := result.Unmarshal(0, &failures); err != nil {
+		return nil, ErrMarshal{Module: module, Object: FailuresObjectID.Name, Method: "Failures", Err: err}
+	}
+
+	return failures, nil
+}
@@ -0,0 +1,33 @@
+package stubs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zbus"
+)
+
+func TestFailures(t *testing.T) {
+	require := require.New(t)
+
+	object := zbus.ObjectID{Name: "network", Version: "0.0.1"}
+	cause := fmt.Errorf("connection refused")
+
+	err := transportError("network", object, "CreateNR", cause)
+	require.True(IsTransport(err))
+	require.False(IsMarshal(err))
+	require.True(errors.Is(err, cause))
+	require.Equal("failed to call network.network.CreateNR: connection refused", err.Error())
+
+	err = marshalError("network", object, "CreateNR", cause)
+	require.True(IsMarshal(err))
+
+	eventError("network", object, "DMZAddresses", cause)
+
+	failures, err := FailureCounters().Failures()
+	require.NoError(err)
+	require.Equal(uint64(2), failures["network.network.CreateNR"])
+	require.Equal(uint64(1), failures["network.network.DMZAddresses"])
+}
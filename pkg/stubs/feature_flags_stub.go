@@ -0,0 +1,91 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type FeatureFlagsStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewFeatureFlagsStub(client zbus.Client) *FeatureFlagsStub {
+	return &FeatureFlagsStub{
+		client: client,
+		module: "identityd",
+		object: zbus.ObjectID{
+			Name:    "features",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *FeatureFlagsStub) Enabled(arg0 string) (ret0 bool, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Enabled", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Enabled", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Enabled", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Enabled", err)
+		return
+	}
+	return
+}
+
+func (s *FeatureFlagsStub) Features() (ret0 []pkg.Feature, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Features", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Features", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Features", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Features", err)
+		return
+	}
+	return
+}
+
+func (s *FeatureFlagsStub) Reset(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Reset", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Reset", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Reset", err)
+		return
+	}
+	return
+}
+
+func (s *FeatureFlagsStub) Set(arg0 string, arg1 bool) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Set", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Set", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Set", err)
+		return
+	}
+	return
+}
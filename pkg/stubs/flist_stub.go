@@ -26,14 +26,17 @@ func (s *FlisterStub) Mount(arg0 string, arg1 string, arg2 pkg.MountOptions) (re
 	args := []interface{}{arg0, arg1, arg2}
 	result, err := s.client.Request(s.module, s.object, "Mount", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Mount", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Mount", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Mount", err)
+		return
 	}
 	return
 }
@@ -42,14 +45,17 @@ func (s *FlisterStub) NamedMount(arg0 string, arg1 string, arg2 string, arg3 pkg
 	args := []interface{}{arg0, arg1, arg2, arg3}
 	result, err := s.client.Request(s.module, s.object, "NamedMount", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "NamedMount", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "NamedMount", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "NamedMount", err)
+		return
 	}
 	return
 }
@@ -58,11 +64,13 @@ func (s *FlisterStub) NamedUmount(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "NamedUmount", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "NamedUmount", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "NamedUmount", err)
+		return
 	}
 	return
 }
@@ -71,11 +79,13 @@ func (s *FlisterStub) Umount(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Umount", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Umount", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Umount", err)
+		return
 	}
 	return
 }
@@ -34,7 +34,8 @@ func (s *HostMonitorStub) Uptime(ctx context.Context) (<-chan time.Duration, err
 		for event := range recv {
 			var obj time.Duration
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Uptime", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -0,0 +1,57 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type IdentityBackupStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewIdentityBackupStub(client zbus.Client) *IdentityBackupStub {
+	return &IdentityBackupStub{
+		client: client,
+		module: "identityd",
+		object: zbus.ObjectID{
+			Name:    "backup",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *IdentityBackupStub) Backup() (ret0 pkg.SeedBackup, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Backup", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Backup", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Backup", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Backup", err)
+		return
+	}
+	return
+}
+
+func (s *IdentityBackupStub) Restore(arg0 []string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Restore", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Restore", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Restore", err)
+		return
+	}
+	return
+}
@@ -26,14 +26,17 @@ func (s *IdentityManagerStub) Decrypt(arg0 []uint8) (ret0 []uint8, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Decrypt", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Decrypt", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Decrypt", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Decrypt", err)
+		return
 	}
 	return
 }
@@ -42,14 +45,17 @@ func (s *IdentityManagerStub) Encrypt(arg0 []uint8) (ret0 []uint8, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Encrypt", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Encrypt", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Encrypt", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Encrypt", err)
+		return
 	}
 	return
 }
@@ -58,26 +64,36 @@ func (s *IdentityManagerStub) FarmID() (ret0 pkg.FarmID, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "FarmID", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "FarmID", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "FarmID", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "FarmID", err)
+		return
 	}
 	return
 }
 
-func (s *IdentityManagerStub) NodeID() (ret0 pkg.StrIdentifier) {
+func (s *IdentityManagerStub) NodeID() (ret0 pkg.StrIdentifier, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "NodeID", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "NodeID", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "NodeID", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "NodeID", err)
+		return
 	}
 	return
 }
@@ -86,14 +102,17 @@ func (s *IdentityManagerStub) Sign(arg0 []uint8) (ret0 []uint8, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Sign", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Sign", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Sign", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Sign", err)
+		return
 	}
 	return
 }
@@ -102,11 +121,13 @@ func (s *IdentityManagerStub) Verify(arg0 []uint8, arg1 []uint8) (ret0 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Verify", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Verify", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Verify", err)
+		return
 	}
 	return
 }
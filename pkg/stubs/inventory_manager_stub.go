@@ -0,0 +1,80 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type InventoryManagerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewInventoryManagerStub(client zbus.Client) *InventoryManagerStub {
+	return &InventoryManagerStub{
+		client: client,
+		module: "monitor",
+		object: zbus.ObjectID{
+			Name:    "inventory",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *InventoryManagerStub) Attest(arg0 []uint8) (ret0 pkg.Attestation, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Attest", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Attest", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Attest", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Attest", err)
+		return
+	}
+	return
+}
+
+func (s *InventoryManagerStub) Fingerprint() (ret0 string, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Fingerprint", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Fingerprint", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Fingerprint", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Fingerprint", err)
+		return
+	}
+	return
+}
+
+func (s *InventoryManagerStub) Inventory() (ret0 pkg.Inventory, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Inventory", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Inventory", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inventory", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inventory", err)
+		return
+	}
+	return
+}
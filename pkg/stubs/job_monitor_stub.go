@@ -0,0 +1,42 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type JobMonitorStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewJobMonitorStub(client zbus.Client) *JobMonitorStub {
+	return &JobMonitorStub{
+		client: client,
+		module: "provision",
+		object: zbus.ObjectID{
+			Name:    "jobs",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *JobMonitorStub) JobRuns(arg0 string) (ret0 []pkg.JobRun, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "JobRuns", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "JobRuns", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "JobRuns", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "JobRuns", err)
+		return
+	}
+	return
+}
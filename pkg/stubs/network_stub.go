@@ -4,6 +4,7 @@ import (
 	"context"
 	zbus "github.com/threefoldtech/zbus"
 	pkg "github.com/threefoldtech/zos/pkg"
+	types "github.com/threefoldtech/zos/pkg/network/types"
 	"net"
 )
 
@@ -24,18 +25,55 @@ func NewNetworkerStub(client zbus.Client) *NetworkerStub {
 	}
 }
 
+func (s *NetworkerStub) AddPeer(arg0 pkg.NetID, arg1 pkg.Peer) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "AddPeer", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "AddPeer", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "AddPeer", err)
+		return
+	}
+	return
+}
+
 func (s *NetworkerStub) Addrs(arg0 string, arg1 string) (ret0 [][]uint8, ret1 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Addrs", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Addrs", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Addrs", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Addrs", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) ApplyStatus(arg0 pkg.NetID) (ret0 pkg.ApplyStatus, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "ApplyStatus", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "ApplyStatus", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "ApplyStatus", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "ApplyStatus", err)
+		return
 	}
 	return
 }
@@ -44,14 +82,36 @@ func (s *NetworkerStub) CreateNR(arg0 pkg.Network) (ret0 string, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "CreateNR", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "CreateNR", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateNR", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateNR", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) CreateNRRequest(arg0 string, arg1 pkg.Network) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "CreateNRRequest", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "CreateNRRequest", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "CreateNRRequest", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "CreateNRRequest", err)
+		return
 	}
 	return
 }
@@ -67,7 +127,8 @@ func (s *NetworkerStub) DMZAddresses(ctx context.Context) (<-chan pkg.NetlinkAdd
 		for event := range recv {
 			var obj pkg.NetlinkAddresses
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "DMZAddresses", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -79,27 +140,52 @@ func (s *NetworkerStub) DeleteNR(arg0 pkg.Network) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "DeleteNR", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "DeleteNR", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "DeleteNR", err)
+		return
 	}
 	return
 }
 
+func (s *NetworkerStub) FloodCounters(ctx context.Context) (<-chan pkg.FloodCounters, error) {
+	ch := make(chan pkg.FloodCounters)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "FloodCounters")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.FloodCounters
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "FloodCounters", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
 func (s *NetworkerStub) GetDefaultGwIP(arg0 pkg.NetID) (ret0 []uint8, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "GetDefaultGwIP", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "GetDefaultGwIP", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "GetDefaultGwIP", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "GetDefaultGwIP", err)
+		return
 	}
 	return
 }
@@ -108,14 +194,17 @@ func (s *NetworkerStub) GetSubnet(arg0 pkg.NetID) (ret0 net.IPNet, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "GetSubnet", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "GetSubnet", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "GetSubnet", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "GetSubnet", err)
+		return
 	}
 	return
 }
@@ -124,14 +213,56 @@ func (s *NetworkerStub) Join(arg0 pkg.NetID, arg1 string, arg2 []string, arg3 bo
 	args := []interface{}{arg0, arg1, arg2, arg3}
 	result, err := s.client.Request(s.module, s.object, "Join", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Join", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Join", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Join", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) LLDPMonitor(ctx context.Context) (<-chan []pkg.LLDPNeighbor, error) {
+	ch := make(chan []pkg.LLDPNeighbor)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "LLDPMonitor")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj []pkg.LLDPNeighbor
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "LLDPMonitor", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *NetworkerStub) LLDPNeighbors() (ret0 []pkg.LLDPNeighbor, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "LLDPNeighbors", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "LLDPNeighbors", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "LLDPNeighbors", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "LLDPNeighbors", err)
+		return
 	}
 	return
 }
@@ -140,11 +271,143 @@ func (s *NetworkerStub) Leave(arg0 pkg.NetID, arg1 string) (ret0 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Leave", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Leave", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Leave", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) LinkEvents(ctx context.Context) (<-chan pkg.LinkEvent, error) {
+	ch := make(chan pkg.LinkEvent)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "LinkEvents")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.LinkEvent
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "LinkEvents", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *NetworkerStub) MoveIP(arg0 pkg.NetID, arg1 string, arg2 net.IP) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "MoveIP", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "MoveIP", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "MoveIP", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) NamesAudit() (ret0 []pkg.InterfaceName, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "NamesAudit", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "NamesAudit", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "NamesAudit", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "NamesAudit", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) Neighbors(arg0 pkg.NetID, arg1 string) (ret0 []pkg.NeighborEntry, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Neighbors", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Neighbors", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Neighbors", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Neighbors", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) Offloads(arg0 pkg.NetID) (ret0 []pkg.LinkOffloads, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Offloads", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Offloads", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Offloads", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Offloads", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) PeersStatus(arg0 pkg.NetID) (ret0 []pkg.PeerStatus, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "PeersStatus", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "PeersStatus", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "PeersStatus", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "PeersStatus", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) PlanNR(arg0 pkg.Network) (ret0 pkg.NetResourcePlan, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "PlanNR", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "PlanNR", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "PlanNR", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "PlanNR", err)
+		return
 	}
 	return
 }
@@ -160,7 +423,28 @@ func (s *NetworkerStub) PublicAddresses(ctx context.Context) (<-chan pkg.Netlink
 		for event := range recv {
 			var obj pkg.NetlinkAddresses
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "PublicAddresses", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *NetworkerStub) PublicAdvertisements(ctx context.Context) (<-chan pkg.RouterAdvertisement, error) {
+	ch := make(chan pkg.RouterAdvertisement)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "PublicAdvertisements")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.RouterAdvertisement
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "PublicAdvertisements", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -172,11 +456,28 @@ func (s *NetworkerStub) Ready() (ret0 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "Ready", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Ready", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Ready", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) RemovePeer(arg0 pkg.NetID, arg1 types.IPNet) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "RemovePeer", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "RemovePeer", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "RemovePeer", err)
+		return
 	}
 	return
 }
@@ -185,27 +486,123 @@ func (s *NetworkerStub) RemoveTap(arg0 pkg.NetID) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "RemoveTap", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "RemoveTap", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "RemoveTap", err)
+		return
 	}
 	return
 }
 
-func (s *NetworkerStub) SetupTap(arg0 pkg.NetID) (ret0 string, ret1 error) {
-	args := []interface{}{arg0}
+func (s *NetworkerStub) SetEgressPolicy(arg0 pkg.NetID, arg1 *pkg.EgressPolicy) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "SetEgressPolicy", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "SetEgressPolicy", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "SetEgressPolicy", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) SetupTap(arg0 pkg.NetID, arg1 []uint8, arg2 net.IP) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
 	result, err := s.client.Request(s.module, s.object, "SetupTap", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "SetupTap", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "SetupTap", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "SetupTap", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) SocketStats(arg0 pkg.NetID, arg1 string) (ret0 pkg.SocketStats, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "SocketStats", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "SocketStats", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "SocketStats", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "SocketStats", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) SubmitNR(arg0 pkg.Network) (ret0 pkg.ApplyStatus, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "SubmitNR", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "SubmitNR", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "SubmitNR", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "SubmitNR", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) SysctlsAudit() (ret0 []pkg.SysctlRecord, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "SysctlsAudit", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "SysctlsAudit", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "SysctlsAudit", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "SysctlsAudit", err)
+		return
+	}
+	return
+}
+
+func (s *NetworkerStub) VerifyIsolation(arg0 pkg.NetID) (ret0 pkg.IsolationReport, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "VerifyIsolation", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "VerifyIsolation", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "VerifyIsolation", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "VerifyIsolation", err)
+		return
 	}
 	return
 }
@@ -214,14 +611,17 @@ func (s *NetworkerStub) ZDBPrepare(arg0 []uint8) (ret0 string, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "ZDBPrepare", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "ZDBPrepare", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "ZDBPrepare", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "ZDBPrepare", err)
+		return
 	}
 	return
 }
@@ -237,7 +637,8 @@ func (s *NetworkerStub) ZOSAddresses(ctx context.Context) (<-chan pkg.NetlinkAdd
 		for event := range recv {
 			var obj pkg.NetlinkAddresses
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "ZOSAddresses", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -0,0 +1,99 @@
+package stubs
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// The networks are sent to networkd in the wire format (see
+// pkg/network_wire.go) only once networkd advertises it, by serving the
+// network object under pkg.NetworkWireVersion. A networkd that is not
+// upgraded yet keeps getting msgpack maps, so the modules can be upgraded
+// one at a time.
+
+// wireClient sends the networks in the wire format once the networkd of
+// the connection advertises it
+type wireClient struct {
+	zbus.Client
+	// probe returns nil if networkd supports the wire format
+	probe func() error
+
+	mu sync.Mutex
+	// negotiated is true once networkd was asked if it supports the
+	// wire format, wire holds the answer
+	negotiated bool
+	wire       bool
+}
+
+// NewWireClient wraps client so the network stubs using it send the
+// networks in the wire format when networkd supports it. The support is
+// checked on the first network sent and again after a failed request
+func NewWireClient(client zbus.Client) zbus.Client {
+	// networkd is asked through the wire version of its object
+	stub := NewNetworkerStub(client)
+	stub.object.Version = pkg.NetworkWireVersion
+
+	return &wireClient{Client: client, probe: stub.Ready}
+}
+
+// supported checks if networkd serves the wire version of the network object
+func (c *wireClient) supported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.negotiated {
+		return c.wire
+	}
+
+	err := c.probe()
+
+	c.negotiated = true
+	c.wire = err == nil
+	log.Debug().Err(err).Bool("wire", c.wire).Msg("network encoding negotiated")
+
+	return c.wire
+}
+
+func (c *wireClient) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.negotiated = false
+}
+
+// Request implements zbus.Client
+func (c *wireClient) Request(module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	if object.Name != "network" || !hasNetwork(args) || !c.supported() {
+		return c.Client.Request(module, object, method, args...)
+	}
+
+	wireArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		if network, ok := arg.(pkg.Network); ok {
+			arg = pkg.WireNetwork(network)
+		}
+		wireArgs[i] = arg
+	}
+	object.Version = pkg.NetworkWireVersion
+
+	result, err := c.Client.Request(module, object, method, wireArgs...)
+	if err != nil {
+		// networkd might have been downgraded
+		c.reset()
+	}
+
+	return result, err
+}
+
+func hasNetwork(args []interface{}) bool {
+	for _, arg := range args {
+		if _, ok := arg.(pkg.Network); ok {
+			return true
+		}
+	}
+
+	return false
+}
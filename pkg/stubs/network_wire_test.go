@@ -0,0 +1,70 @@
+package stubs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// recordClient records the requests, it fails them if err is set
+type recordClient struct {
+	zbus.Client
+	objects []zbus.ObjectID
+	args    [][]interface{}
+	err     error
+}
+
+func (c *recordClient) Request(module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	c.objects = append(c.objects, object)
+	c.args = append(c.args, args)
+	return nil, c.err
+}
+
+func TestWireClient(t *testing.T) {
+	require := require.New(t)
+
+	network := pkg.Network{Name: "net", NetID: "net"}
+	object := zbus.ObjectID{Name: "network", Version: "0.0.1"}
+
+	recorder := &recordClient{}
+	probes := 0
+	var probeErr error
+	client := &wireClient{Client: recorder, probe: func() error {
+		probes++
+		return probeErr
+	}}
+
+	// networkd not upgraded yet gets the msgpack maps
+	probeErr = fmt.Errorf("unknown object")
+	_, err := client.Request("network", object, "CreateNR", network)
+	require.NoError(err)
+	require.Equal(object, recorder.objects[0])
+	require.IsType(pkg.Network{}, recorder.args[0][0])
+
+	// the answer is kept for the connection
+	_, err = client.Request("network", object, "DeleteNR", network)
+	require.NoError(err)
+	require.Equal(1, probes)
+
+	// once networkd advertises the wire format the networks are sent in it
+	client.reset()
+	probeErr = nil
+	_, err = client.Request("network", object, "CreateNR", network)
+	require.NoError(err)
+	require.Equal(pkg.NetworkWireVersion, recorder.objects[2].Version)
+	require.IsType(pkg.WireNetwork{}, recorder.args[2][0])
+
+	// the requests without network are left alone
+	_, err = client.Request("network", object, "Ready")
+	require.NoError(err)
+	require.Equal(object, recorder.objects[3])
+
+	// a failed request negotiates again
+	recorder.err = fmt.Errorf("connection reset")
+	_, err = client.Request("network", object, "CreateNR", network)
+	require.Error(err)
+	require.False(client.negotiated)
+}
@@ -34,7 +34,8 @@ func (s *ProvisionMonitorStub) Counters(ctx context.Context) (<-chan pkg.Provisi
 		for event := range recv {
 			var obj pkg.ProvisionCounters
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Counters", err)
+				continue
 			}
 			ch <- obj
 		}
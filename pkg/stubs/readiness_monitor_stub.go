@@ -0,0 +1,63 @@
+package stubs
+
+import (
+	"context"
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type ReadinessMonitorStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewReadinessMonitorStub(client zbus.Client) *ReadinessMonitorStub {
+	return &ReadinessMonitorStub{
+		client: client,
+		module: "provision",
+		object: zbus.ObjectID{
+			Name:    "readiness",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *ReadinessMonitorStub) Readiness(ctx context.Context) (<-chan pkg.ReadinessEvent, error) {
+	ch := make(chan pkg.ReadinessEvent)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Readiness")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.ReadinessEvent
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Readiness", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *ReadinessMonitorStub) Ready(arg0 string) (ret0 bool, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Ready", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Ready", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Ready", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Ready", err)
+		return
+	}
+	return
+}
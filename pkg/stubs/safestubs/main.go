@@ -0,0 +1,164 @@
+// safestubs rewrites the stubs generated by zbusc so they never panic. It
+// runs with go generate right after zbusc, on all the stubs of the
+// directory given as argument:
+//
+//   - a failed request of a method returning an error returns an ErrTransport
+//   - a response that can't be decoded returns an ErrMarshal
+//   - a method without error is refused: its failure would be hidden
+//     behind zero values, the method must return an error in pkg
+//   - the events of a stream that can't be decoded are dropped
+//
+// The failures are counted by the helpers of failures.go. The stubs are
+// only changed where zbusc panics, running it twice changes nothing.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	methodRegex  = regexp.MustCompile(`^func \(s \*\w+\) (\w+)\(.*\) \((.*)\) \{$`)
+	streamRegex  = regexp.MustCompile(`^func \(s \*\w+\) (\w+)\(ctx context\.Context\) \(<-chan .*, error\) \{$`)
+	requestRegex = regexp.MustCompile(`^\s*result, err := s\.client\.Request\(`)
+	resultRegex  = regexp.MustCompile(`^\s*if err := result\.Unmarshal\(`)
+	eventRegex   = regexp.MustCompile(`^\s*if err := event\.Unmarshal\(`)
+	panicRegex   = regexp.MustCompile(`^(\s*)panic\(err\)$`)
+)
+
+// method is the stub method being rewritten
+type method struct {
+	name   string
+	stream bool
+	// errResult is the name of the error result, empty if the method
+	// doesn't return an error
+	errResult string
+}
+
+// parseMethod returns the method declared by line, ok is false if line
+// doesn't declare a stub method
+func parseMethod(line string) (m method, ok bool) {
+	if match := streamRegex.FindStringSubmatch(line); match != nil {
+		return method{name: match[1], stream: true}, true
+	}
+
+	match := methodRegex.FindStringSubmatch(line)
+	if match == nil {
+		return m, false
+	}
+
+	m.name = match[1]
+	results := strings.Split(match[2], ",")
+	last := strings.Fields(results[len(results)-1])
+	if len(last) == 2 && last[1] == "error" {
+		m.errResult = last[0]
+	}
+
+	return m, true
+}
+
+// rewrite replaces the panics of the stubs in source
+func rewrite(source []byte) ([]byte, error) {
+	lines := strings.Split(string(source), "\n")
+
+	var (
+		output  []string
+		current method
+		// call is the kind of the call checked by the last if
+		call string
+	)
+
+	for _, line := range lines {
+		if m, ok := parseMethod(line); ok {
+			current, call = m, ""
+		}
+
+		switch {
+		case requestRegex.MatchString(line):
+			call = "transportError"
+		case resultRegex.MatchString(line):
+			call = "marshalError"
+		case eventRegex.MatchString(line):
+			call = "event"
+		}
+
+		match := panicRegex.FindStringSubmatch(line)
+		if match == nil {
+			output = append(output, line)
+			continue
+		}
+
+		if current.name == "" || call == "" {
+			return nil, fmt.Errorf("unexpected panic: %s", strings.TrimSpace(line))
+		}
+
+		indent := match[1]
+		args := fmt.Sprintf("s.module, s.object, %q, err", current.name)
+		switch {
+		case call == "event":
+			output = append(output,
+				fmt.Sprintf("%seventError(%s)", indent, args),
+				indent+"continue",
+			)
+		case current.errResult != "":
+			output = append(output,
+				fmt.Sprintf("%s%s = %s(%s)", indent, current.errResult, call, args),
+				indent+"return",
+			)
+		default:
+			return nil, fmt.Errorf("method %s doesn't return an error", current.name)
+		}
+	}
+
+	return format.Source([]byte(strings.Join(output, "\n")))
+}
+
+func run(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*_stub.go"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		rewritten, err := rewrite(source)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+
+		if bytes.Equal(source, rewritten) {
+			continue
+		}
+
+		if err := ioutil.WriteFile(file, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := run(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generated is the output of zbusc for a method with an error and a stream
+const generated = `package stubs
+
+func (s *TestStub) Get(arg0 string) (ret0 []uint8, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Get", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *TestStub) Events(ctx context.Context) (<-chan uint64, error) {
+	ch := make(chan uint64)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Events")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj uint64
+			if err := event.Unmarshal(&obj); err != nil {
+				panic(err)
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+`
+
+const safe = `package stubs
+
+func (s *TestStub) Get(arg0 string) (ret0 []uint8, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Get", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Get", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Get", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Get", err)
+		return
+	}
+	return
+}
+
+func (s *TestStub) Events(ctx context.Context) (<-chan uint64, error) {
+	ch := make(chan uint64)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Events")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj uint64
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Events", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+`
+
+func TestRewrite(t *testing.T) {
+	output, err := rewrite([]byte(generated))
+	require.NoError(t, err)
+	assert.Equal(t, safe, string(output))
+
+	// already rewritten
+	output, err = rewrite([]byte(safe))
+	require.NoError(t, err)
+	assert.Equal(t, safe, string(output))
+
+	_, err = rewrite([]byte("package stubs\n\nfunc f() {\n\tpanic(err)\n}\n"))
+	assert.Error(t, err)
+
+	// a method without error can't report its failure
+	_, err = rewrite([]byte(`package stubs
+
+func (s *TestStub) Exists(arg0 string) (ret0 bool) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Exists", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
+`))
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	zbus "github.com/threefoldtech/zbus"
 	pkg "github.com/threefoldtech/zos/pkg"
+	"time"
 )
 
 type StorageModuleStub struct {
@@ -27,38 +28,55 @@ func (s *StorageModuleStub) Allocate(arg0 string, arg1 pkg.DeviceType, arg2 uint
 	args := []interface{}{arg0, arg1, arg2, arg3}
 	result, err := s.client.Request(s.module, s.object, "Allocate", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Allocate", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
 	}
 	return
 }
 
-func (s *StorageModuleStub) BrokenDevices() (ret0 []pkg.BrokenDevice) {
+func (s *StorageModuleStub) BrokenDevices() (ret0 []pkg.BrokenDevice, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "BrokenDevices", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "BrokenDevices", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "BrokenDevices", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "BrokenDevices", err)
+		return
 	}
 	return
 }
 
-func (s *StorageModuleStub) BrokenPools() (ret0 []pkg.BrokenPool) {
+func (s *StorageModuleStub) BrokenPools() (ret0 []pkg.BrokenPool, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "BrokenPools", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "BrokenPools", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "BrokenPools", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "BrokenPools", err)
+		return
 	}
 	return
 }
@@ -67,14 +85,112 @@ func (s *StorageModuleStub) CreateFilesystem(arg0 string, arg1 uint64, arg2 pkg.
 	args := []interface{}{arg0, arg1, arg2}
 	result, err := s.client.Request(s.module, s.object, "CreateFilesystem", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "CreateFilesystem", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateFilesystem", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateFilesystem", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) CreateVolume(arg0 string, arg1 uint64, arg2 pkg.DeviceType, arg3 pkg.VolumeKind) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "CreateVolume", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "CreateVolume", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "CreateVolume", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "CreateVolume", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) CreateVolumeRequest(arg0 string, arg1 string, arg2 uint64, arg3 pkg.DeviceType, arg4 pkg.VolumeKind) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3, arg4}
+	result, err := s.client.Request(s.module, s.object, "CreateVolumeRequest", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "CreateVolumeRequest", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateVolumeRequest", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "CreateVolumeRequest", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) DeviceIdentities() (ret0 []pkg.DeviceIdentity, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "DeviceIdentities", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "DeviceIdentities", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "DeviceIdentities", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "DeviceIdentities", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) DisksHealth() (ret0 []pkg.DiskHealth, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "DisksHealth", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "DisksHealth", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "DisksHealth", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "DisksHealth", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) Export(arg0 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Export", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Export", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Export", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Export", err)
+		return
 	}
 	return
 }
@@ -83,14 +199,143 @@ func (s *StorageModuleStub) Find(arg0 string) (ret0 pkg.Allocation, ret1 error)
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Find", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Find", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Find", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Find", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) Forecast() (ret0 []pkg.PoolForecast, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Forecast", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Forecast", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Forecast", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Forecast", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) ForecastWarnings(ctx context.Context) (<-chan pkg.PoolForecast, error) {
+	ch := make(chan pkg.PoolForecast)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "ForecastWarnings")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.PoolForecast
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "ForecastWarnings", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *StorageModuleStub) ForensicMount(arg0 string, arg1 string, arg2 time.Duration) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "ForensicMount", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "ForensicMount", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "ForensicMount", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "ForensicMount", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) ForensicUnmount(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "ForensicUnmount", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "ForensicUnmount", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "ForensicUnmount", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) Import(arg0 string, arg1 pkg.DeviceType, arg2 pkg.ZDBMode) (ret0 pkg.Allocation, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "Import", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Import", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Import", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Import", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) LabelVolume(arg0 string, arg1 string, arg2 map[string]string) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "LabelVolume", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "LabelVolume", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "LabelVolume", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) ListVolumes(arg0 pkg.VolumeKind) (ret0 []pkg.VolumeInfo, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "ListVolumes", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "ListVolumes", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "ListVolumes", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "ListVolumes", err)
+		return
 	}
 	return
 }
@@ -106,7 +351,8 @@ func (s *StorageModuleStub) Monitor(ctx context.Context) (<-chan pkg.PoolsStats,
 		for event := range recv {
 			var obj pkg.PoolsStats
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Monitor", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -114,18 +360,40 @@ func (s *StorageModuleStub) Monitor(ctx context.Context) (<-chan pkg.PoolsStats,
 	return ch, nil
 }
 
+func (s *StorageModuleStub) OwnerQuotas() (ret0 []pkg.OwnerQuota, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "OwnerQuotas", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "OwnerQuotas", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "OwnerQuotas", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "OwnerQuotas", err)
+		return
+	}
+	return
+}
+
 func (s *StorageModuleStub) Path(arg0 string) (ret0 string, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Path", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Path", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Path", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Path", err)
+		return
 	}
 	return
 }
@@ -134,11 +402,58 @@ func (s *StorageModuleStub) ReleaseFilesystem(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "ReleaseFilesystem", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "ReleaseFilesystem", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "ReleaseFilesystem", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) RepairPool(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "RepairPool", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "RepairPool", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "RepairPool", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) SwapOff() (ret0 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "SwapOff", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "SwapOff", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "SwapOff", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) SwapOn() (ret0 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "SwapOn", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "SwapOn", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "SwapOn", err)
+		return
 	}
 	return
 }
@@ -147,14 +462,36 @@ func (s *StorageModuleStub) Total(arg0 pkg.DeviceType) (ret0 uint64, ret1 error)
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Total", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Total", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Total", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Total", err)
+		return
+	}
+	return
+}
+
+func (s *StorageModuleStub) VolumeDevices(arg0 string) (ret0 []string, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "VolumeDevices", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "VolumeDevices", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "VolumeDevices", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "VolumeDevices", err)
+		return
 	}
 	return
 }
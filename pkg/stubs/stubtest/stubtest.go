@@ -0,0 +1,248 @@
+// Package stubtest exercises the modules the way their stubs call them over
+// zbus, without a broker. The arguments of a call are generated for the
+// types of the stub, encoded with msgpack like on the wire and decoded to
+// the types of the module, and the results go the other way. It catches
+// the types that don't survive the wire and the methods that panic on
+// unexpected inputs, the modules being set up with fake backends by the
+// tests of their packages.
+package stubtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Iterations is the number of calls with random arguments Exercise makes
+// to each method
+const Iterations = 20
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// Generator fills values with random data
+type Generator struct {
+	rnd    *rand.Rand
+	values map[reflect.Type][]reflect.Value
+}
+
+// NewGenerator creates a generator, the same seed generates the same values
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		rnd:    rand.New(rand.NewSource(seed)),
+		values: make(map[reflect.Type][]reflect.Value),
+	}
+}
+
+// Values makes the generator pick the values of their type among values
+// half of the time, so the enums (device types, volume kinds, ...) are not
+// only generated with invalid values
+func (g *Generator) Values(values ...interface{}) {
+	for _, value := range values {
+		v := reflect.ValueOf(value)
+		g.values[v.Type()] = append(g.values[v.Type()], v)
+	}
+}
+
+// Fill sets v to a random value of its type
+func (g *Generator) Fill(v reflect.Value) {
+	g.fill(v, 0)
+}
+
+func (g *Generator) fill(v reflect.Value, depth int) {
+	if depth > 5 {
+		return
+	}
+
+	if values := g.values[v.Type()]; len(values) > 0 && g.rnd.Intn(2) == 0 {
+		v.Set(values[g.rnd.Intn(len(values))])
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(g.rnd.Intn(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// the shift keeps the value in the range of the type
+		v.SetInt(g.rnd.Int63() >> uint(64-v.Type().Bits()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(g.rnd.Uint64() >> uint(64-v.Type().Bits()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(g.rnd.Int31()) / 8)
+	case reflect.String:
+		v.SetString(fmt.Sprintf("value-%d", g.rnd.Int()))
+	case reflect.Slice:
+		// empty non nil slices and maps are not generated since
+		// msgpack can decode them as nil
+		n := g.rnd.Intn(3) + 1
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			g.fill(s.Index(i), depth+1)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			g.fill(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := g.rnd.Intn(3) + 1; i > 0; i-- {
+			key := reflect.New(v.Type().Key()).Elem()
+			g.fill(key, depth+1)
+			value := reflect.New(v.Type().Elem()).Elem()
+			g.fill(value, depth+1)
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		g.fill(p.Elem(), depth+1)
+		v.Set(p)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(time.Unix(g.rnd.Int63n(1<<32), 0)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				g.fill(v.Field(i), depth+1)
+			}
+		}
+	}
+	// interfaces (errors mostly), channels and functions are left nil
+}
+
+// send encodes value like zbus does and decodes it to typ
+func send(value reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	var buf bytes.Buffer
+	if err := msgpack.NewEncoder(&buf).Encode(value.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("marshal %s: %w", value.Type(), err)
+	}
+
+	decoded := reflect.New(typ)
+	if err := msgpack.Unmarshal(buf.Bytes(), decoded.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("unmarshal %s to %s: %w", value.Type(), typ, err)
+	}
+
+	return decoded.Elem(), nil
+}
+
+func isStream(m reflect.Type) bool {
+	return m.NumIn() == 1 && m.In(0) == contextType &&
+		m.NumOut() == 1 && m.Out(0).Kind() == reflect.Chan
+}
+
+// Call calls method of module with args, the arguments of the stub. The
+// results are returned as the stub returns them, the error returned by the
+// method is carried by its message like zbus does. An error is returned if
+// a value doesn't survive the wire or if the method panics
+func Call(module, stub interface{}, method string, args ...interface{}) (results []interface{}, err error) {
+	target := reflect.ValueOf(module).MethodByName(method)
+	if !target.IsValid() {
+		return nil, fmt.Errorf("module has no method %s", method)
+	}
+	client, ok := reflect.TypeOf(stub).MethodByName(method)
+	if !ok {
+		return nil, fmt.Errorf("stub has no method %s", method)
+	}
+
+	typ := target.Type()
+	if isStream(typ) {
+		return nil, fmt.Errorf("%s is a stream", method)
+	}
+	if len(args) != typ.NumIn() {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", method, typ.NumIn(), len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		if in[i], err = send(reflect.ValueOf(arg), typ.In(i)); err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %w", i, method, err)
+		}
+	}
+
+	out, err := safeCall(target, in)
+	if err != nil {
+		return nil, fmt.Errorf("%s%v: %w", method, args, err)
+	}
+
+	// the first input of the stub method is its receiver
+	if client.Type.NumOut() != len(out) {
+		return nil, fmt.Errorf("stub %s returns %d values, module returns %d", method, client.Type.NumOut(), len(out))
+	}
+
+	for i, value := range out {
+		if typ.Out(i) == errorType {
+			var err error
+			if !value.IsNil() {
+				err = fmt.Errorf("%s", value.Interface().(error).Error())
+			}
+			results = append(results, err)
+			continue
+		}
+
+		decoded, err := send(value, client.Type.Out(i))
+		if err != nil {
+			return nil, fmt.Errorf("return value %d of %s: %w", i, method, err)
+		}
+		results = append(results, decoded.Interface())
+	}
+
+	return results, nil
+}
+
+func safeCall(target reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return target.Call(in), nil
+}
+
+// Exercise calls methods of module through the wire with the zero values
+// and with random values generated by g. It fails t if a call panics or if
+// a value doesn't survive the wire, the errors returned by the methods are
+// expected
+func Exercise(t *testing.T, g *Generator, module, stub interface{}, methods ...string) {
+	for _, method := range methods {
+		client, ok := reflect.TypeOf(stub).MethodByName(method)
+		if !ok {
+			t.Errorf("stub has no method %s", method)
+			continue
+		}
+
+		t.Run(method, func(t *testing.T) {
+			// the first input of the stub method is its receiver
+			call := func(fill func(reflect.Value)) {
+				args := make([]interface{}, client.Type.NumIn()-1)
+				for i := range args {
+					arg := reflect.New(client.Type.In(i + 1)).Elem()
+					fill(arg)
+					args[i] = arg.Interface()
+				}
+
+				if _, err := Call(module, stub, method, args...); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// zero values first, they catch the nil handling issues
+			call(func(reflect.Value) {})
+			for i := 0; i < Iterations; i++ {
+				call(g.Fill)
+			}
+		})
+	}
+}
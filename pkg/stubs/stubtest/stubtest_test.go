@@ -0,0 +1,89 @@
+package stubtest
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testModule struct{}
+
+func (testModule) Resolve(name string, n uint8) ([]net.IP, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return make([]net.IP, n), nil
+}
+
+func (testModule) First(names []string) string {
+	return names[0]
+}
+
+func (testModule) Count(names []string) int {
+	return len(names)
+}
+
+// testStub is what zbusc generates for testModule, net.IP is rendered
+// with its underlying type
+type testStub struct{}
+
+func (testStub) Resolve(name string, n uint8) ([]([]uint8), error) {
+	return nil, nil
+}
+
+func (testStub) First(names []string) string {
+	return ""
+}
+
+func (testStub) Count(names []string) string {
+	return ""
+}
+
+func TestCall(t *testing.T) {
+	require := require.New(t)
+
+	results, err := Call(testModule{}, testStub{}, "Resolve", "node", uint8(2))
+	require.NoError(err)
+	require.Len(results, 2)
+	require.Len(results[0], 2)
+	require.Nil(results[1])
+
+	results, err = Call(testModule{}, testStub{}, "Resolve", "", uint8(2))
+	require.NoError(err)
+	require.EqualError(results[1].(error), "name is required")
+
+	// the nil slice makes the module panic
+	_, err = Call(testModule{}, testStub{}, "First", []string(nil))
+	require.Error(err)
+	require.Contains(err.Error(), "panic")
+
+	// the stub expects a string where the module returns an int
+	_, err = Call(testModule{}, testStub{}, "Count", []string{"a"})
+	require.Error(err)
+
+	_, err = Call(testModule{}, testStub{}, "Missing")
+	require.Error(err)
+}
+
+func TestGenerator(t *testing.T) {
+	type kind string
+
+	g := NewGenerator(1)
+	g.Values(kind("volume"))
+
+	var picked bool
+	for i := 0; i < 20; i++ {
+		var value struct {
+			Kind  kind
+			Names map[string][]int
+		}
+		g.Fill(reflect.ValueOf(&value).Elem())
+		require.NotEmpty(t, value.Kind)
+		require.NotEmpty(t, value.Names)
+		picked = picked || value.Kind == "volume"
+	}
+	require.True(t, picked)
+}
@@ -23,6 +23,26 @@ func NewSystemMonitorStub(client zbus.Client) *SystemMonitorStub {
 	}
 }
 
+func (s *SystemMonitorStub) CallFailures(ctx context.Context) (<-chan pkg.CallFailuresStat, error) {
+	ch := make(chan pkg.CallFailuresStat)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "CallFailures")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.CallFailuresStat
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "CallFailures", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
 func (s *SystemMonitorStub) CPU(ctx context.Context) (<-chan pkg.CPUTimesStat, error) {
 	ch := make(chan pkg.CPUTimesStat)
 	recv, err := s.client.Stream(ctx, s.module, s.object, "CPU")
@@ -34,7 +54,8 @@ func (s *SystemMonitorStub) CPU(ctx context.Context) (<-chan pkg.CPUTimesStat, e
 		for event := range recv {
 			var obj pkg.CPUTimesStat
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "CPU", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -53,7 +74,8 @@ func (s *SystemMonitorStub) Disks(ctx context.Context) (<-chan pkg.DisksIOCounte
 		for event := range recv {
 			var obj pkg.DisksIOCountersStat
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Disks", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -72,7 +94,8 @@ func (s *SystemMonitorStub) Memory(ctx context.Context) (<-chan pkg.VirtualMemor
 		for event := range recv {
 			var obj pkg.VirtualMemoryStat
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Memory", err)
+				continue
 			}
 			ch <- obj
 		}
@@ -91,7 +114,48 @@ func (s *SystemMonitorStub) Nics(ctx context.Context) (<-chan pkg.NicsIOCounterS
 		for event := range recv {
 			var obj pkg.NicsIOCounterStat
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Nics", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *SystemMonitorStub) Sensors(ctx context.Context) (<-chan pkg.SensorsStat, error) {
+	ch := make(chan pkg.SensorsStat)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Sensors")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.SensorsStat
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Sensors", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *SystemMonitorStub) Swap(ctx context.Context) (<-chan pkg.SwapStat, error) {
+	ch := make(chan pkg.SwapStat)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Swap")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.SwapStat
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "Swap", err)
+				continue
 			}
 			ch <- obj
 		}
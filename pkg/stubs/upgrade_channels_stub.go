@@ -0,0 +1,57 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type UpgradeChannelsStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewUpgradeChannelsStub(client zbus.Client) *UpgradeChannelsStub {
+	return &UpgradeChannelsStub{
+		client: client,
+		module: "identityd",
+		object: zbus.ObjectID{
+			Name:    "channel",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *UpgradeChannelsStub) Channel() (ret0 pkg.UpgradeChannel, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Channel", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Channel", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Channel", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Channel", err)
+		return
+	}
+	return
+}
+
+func (s *UpgradeChannelsStub) SetChannel(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "SetChannel", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "SetChannel", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "SetChannel", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package stubs
+
+import (
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos/pkg"
+)
+
+type UpgradePlannerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewUpgradePlannerStub(client zbus.Client) *UpgradePlannerStub {
+	return &UpgradePlannerStub{
+		client: client,
+		module: "identityd",
+		object: zbus.ObjectID{
+			Name:    "planner",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *UpgradePlannerStub) Plan() (ret0 pkg.UpgradePlan, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Plan", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Plan", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Plan", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Plan", err)
+		return
+	}
+	return
+}
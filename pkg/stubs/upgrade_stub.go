@@ -26,14 +26,17 @@ func (s *UpgradeModuleStub) Version() (ret0 semver.Version, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.Request(s.module, s.object, "Version", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Version", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Version", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Version", err)
+		return
 	}
 	return
 }
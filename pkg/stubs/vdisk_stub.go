@@ -1,6 +1,7 @@
 package stubs
 
 import (
+	"context"
 	zbus "github.com/threefoldtech/zbus"
 	pkg "github.com/threefoldtech/zos/pkg"
 )
@@ -26,14 +27,36 @@ func (s *VDiskModuleStub) Allocate(arg0 string, arg1 int64) (ret0 string, ret1 e
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Allocate", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Allocate", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
+	}
+	return
+}
+
+func (s *VDiskModuleStub) Clone(arg0 string, arg1 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Clone", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Clone", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Clone", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Clone", err)
+		return
 	}
 	return
 }
@@ -42,39 +65,109 @@ func (s *VDiskModuleStub) Deallocate(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Deallocate", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Deallocate", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Deallocate", err)
+		return
 	}
 	return
 }
 
-func (s *VDiskModuleStub) Exists(arg0 string) (ret0 bool) {
+func (s *VDiskModuleStub) Exists(arg0 string) (ret0 bool, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Exists", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Exists", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Exists", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Exists", err)
+		return
 	}
 	return
 }
 
+func (s *VDiskModuleStub) ImportImage(arg0 string, arg1 string, arg2 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "ImportImage", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "ImportImage", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "ImportImage", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "ImportImage", err)
+		return
+	}
+	return
+}
+
+func (s *VDiskModuleStub) ImportProgress(ctx context.Context) (<-chan pkg.ImageImport, error) {
+	ch := make(chan pkg.ImageImport)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "ImportProgress")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.ImageImport
+			if err := event.Unmarshal(&obj); err != nil {
+				eventError(s.module, s.object, "ImportProgress", err)
+				continue
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
 func (s *VDiskModuleStub) Inspect(arg0 string) (ret0 pkg.VDisk, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Inspect", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Inspect", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
+	}
+	return
+}
+
+func (s *VDiskModuleStub) Snapshot(arg0 string, arg1 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "Snapshot", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Snapshot", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Snapshot", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Snapshot", err)
+		return
 	}
 	return
 }
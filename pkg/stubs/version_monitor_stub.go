@@ -34,7 +34,8 @@ func (s *VersionMonitorStub) Version(ctx context.Context) (<-chan semver.Version
 		for event := range recv {
 			var obj semver.Version
 			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
+				eventError(s.module, s.object, "Version", err)
+				continue
 			}
 			ch <- obj
 		}
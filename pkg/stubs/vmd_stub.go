@@ -22,27 +22,70 @@ func NewVMModuleStub(client zbus.Client) *VMModuleStub {
 	}
 }
 
+func (s *VMModuleStub) ConsoleLog(arg0 string, arg1 int64) (ret0 pkg.VMConsoleOutput, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "ConsoleLog", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "ConsoleLog", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "ConsoleLog", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "ConsoleLog", err)
+		return
+	}
+	return
+}
+
+func (s *VMModuleStub) ConsoleWrite(arg0 string, arg1 uint64, arg2 []byte, arg3 []byte) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "ConsoleWrite", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "ConsoleWrite", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "ConsoleWrite", err)
+		return
+	}
+	return
+}
+
 func (s *VMModuleStub) Delete(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Delete", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Delete", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Delete", err)
+		return
 	}
 	return
 }
 
-func (s *VMModuleStub) Exists(arg0 string) (ret0 bool) {
+func (s *VMModuleStub) Exists(arg0 string) (ret0 bool, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Exists", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Exists", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Exists", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Exists", err)
+		return
 	}
 	return
 }
@@ -51,14 +94,51 @@ func (s *VMModuleStub) Inspect(arg0 string) (ret0 pkg.VMInfo, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Inspect", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Inspect", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Inspect", err)
+		return
+	}
+	return
+}
+
+func (s *VMModuleStub) Migrate(arg0 string, arg1 string, arg2 string) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "Migrate", args...)
+	if err != nil {
+		ret0 = transportError(s.module, s.object, "Migrate", err)
+		return
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret0 = marshalError(s.module, s.object, "Migrate", err)
+		return
+	}
+	return
+}
+
+func (s *VMModuleStub) MigrationAccept(arg0 string, arg1 pkg.VMMigration) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "MigrationAccept", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "MigrationAccept", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "MigrationAccept", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "MigrationAccept", err)
+		return
 	}
 	return
 }
@@ -67,11 +147,13 @@ func (s *VMModuleStub) Run(arg0 pkg.VM) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Run", args...)
 	if err != nil {
-		panic(err)
+		ret0 = transportError(s.module, s.object, "Run", err)
+		return
 	}
 	ret0 = new(zbus.RemoteError)
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret0 = marshalError(s.module, s.object, "Run", err)
+		return
 	}
 	return
 }
@@ -26,14 +26,36 @@ func (s *ZDBAllocaterStub) Allocate(arg0 string, arg1 pkg.DeviceType, arg2 uint6
 	args := []interface{}{arg0, arg1, arg2, arg3}
 	result, err := s.client.Request(s.module, s.object, "Allocate", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Allocate", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Allocate", err)
+		return
+	}
+	return
+}
+
+func (s *ZDBAllocaterStub) Export(arg0 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "Export", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Export", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Export", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Export", err)
+		return
 	}
 	return
 }
@@ -42,14 +64,36 @@ func (s *ZDBAllocaterStub) Find(arg0 string) (ret0 pkg.Allocation, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Find", args...)
 	if err != nil {
-		panic(err)
+		ret1 = transportError(s.module, s.object, "Find", err)
+		return
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		ret1 = marshalError(s.module, s.object, "Find", err)
+		return
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		ret1 = marshalError(s.module, s.object, "Find", err)
+		return
+	}
+	return
+}
+
+func (s *ZDBAllocaterStub) Import(arg0 string, arg1 pkg.DeviceType, arg2 pkg.ZDBMode) (ret0 pkg.Allocation, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "Import", args...)
+	if err != nil {
+		ret1 = transportError(s.module, s.object, "Import", err)
+		return
 	}
 	if err := result.Unmarshal(0, &ret0); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Import", err)
+		return
 	}
 	ret1 = new(zbus.RemoteError)
 	if err := result.Unmarshal(1, &ret1); err != nil {
-		panic(err)
+		ret1 = marshalError(s.module, s.object, "Import", err)
+		return
 	}
 	return
 }
@@ -0,0 +1,89 @@
+// Package timesync keeps the clock of the node in sync when it can't reach
+// the NTP servers, as behind a proxy: NTP is not proxied, so the time is
+// read from the Date header of an HTTPS response, which goes through the
+// proxy like the rest of the traffic of the node.
+package timesync
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultURL is the url the time is read from
+	DefaultURL = "https://hub.grid.tf/"
+
+	// maxDrift is the max difference with the time of the server before the
+	// clock is set, the Date header has a precision of a second
+	maxDrift = 2 * time.Second
+	// interval is the time between two syncs
+	interval = time.Hour
+)
+
+// offset returns the difference between the time of the server and the
+// time of the node
+func offset(ctx context.Context, client *http.Client, u string) (time.Duration, error) {
+	request, err := http.NewRequest(http.MethodHead, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	end := time.Now()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid date from '%s'", u)
+	}
+
+	// the date is set by the server half way through the request, and
+	// truncated to the second
+	local := start.Add(end.Sub(start) / 2)
+	return date.Add(500 * time.Millisecond).Sub(local), nil
+}
+
+// Sync sets the clock of the node to the time of the server at u if they
+// differ by more than a couple of seconds
+func Sync(ctx context.Context, u string) error {
+	diff, err := offset(ctx, http.DefaultClient, u)
+	if err != nil {
+		return err
+	}
+
+	if diff > -maxDrift && diff < maxDrift {
+		return nil
+	}
+
+	tv := unix.NsecToTimeval(time.Now().Add(diff).UnixNano())
+	if err := unix.Settimeofday(&tv); err != nil {
+		return errors.Wrap(err, "failed to set the time")
+	}
+
+	log.Info().Str("offset", diff.String()).Msg("clock synced")
+	return nil
+}
+
+// Run syncs the clock every hour until ctx is canceled
+func Run(ctx context.Context, u string) {
+	for {
+		if err := Sync(ctx, u); err != nil {
+			log.Error().Err(err).Str("url", u).Msg("failed to sync clock")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package timesync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffset(t *testing.T) {
+	require := require.New(t)
+
+	ahead := time.Hour
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(ahead).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	diff, err := offset(context.Background(), srv.Client(), srv.URL)
+	require.NoError(err)
+	require.True(diff > ahead-time.Second && diff < ahead+time.Second, "offset %s", diff)
+
+	ahead = 0
+	diff, err = offset(context.Background(), srv.Client(), srv.URL)
+	require.NoError(err)
+	require.True(diff > -time.Second && diff < time.Second, "offset %s", diff)
+}
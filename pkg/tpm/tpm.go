@@ -0,0 +1,244 @@
+// Package tpm seals small secrets, like the node seed or the wireguard keys,
+// to the TPM 2.0 of the node with the tpm2-tools. A sealed secret can only be
+// unsealed by the TPM that sealed it, and only while the PCRs it's sealed
+// to hold the same values, so the disks of a node are useless on their own.
+package tpm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+const (
+	// device is the resource manager of the TPM, it allows concurrent
+	// users of the TPM
+	device       = "/dev/tpmrm0"
+	versionFile  = "/sys/class/tpm/tpm0/tpm_version_major"
+	disableParam = "notpm"
+
+	// maxSecret is the max size of a sealed secret
+	maxSecret = 128
+)
+
+// DefaultPCRs are the PCRs the secrets are sealed to: the firmware (0) and
+// the secure boot state (7). The kernel is not measured so the upgrades of
+// the node don't prevent the unsealing
+const DefaultPCRs = "sha256:0,7"
+
+// Sealed is a secret sealed to the TPM. The blobs are encrypted by the TPM,
+// they can be stored on the disks
+type Sealed struct {
+	PCRs    string `json:"pcrs"`
+	Public  []byte `json:"public"`
+	Private []byte `json:"private"`
+}
+
+// tool runs a command of the tpm2-tools in dir with stdin, it returns the
+// standard output. It's replaced in tests
+var tool = func(dir string, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, append(args, "-T", "device:"+device)...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// Available checks if the node has a TPM 2.0
+func Available() bool {
+	if _, err := os.Stat(device); err != nil {
+		return false
+	}
+
+	version, err := ioutil.ReadFile(versionFile)
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(version)) == "2"
+}
+
+// Enabled checks if the secrets of the node are sealed to the TPM, the
+// sealing is disabled with the notpm kernel parameter
+func Enabled(params kernel.Params) bool {
+	return !params.Exists(disableParam) && Available()
+}
+
+// primary creates the primary key of the owner hierarchy in dir. The key
+// is derived from the seed of the TPM, the same key is created every time
+// so it doesn't need to be persisted
+func primary(dir string) error {
+	_, err := tool(dir, nil, "tpm2_createprimary", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", "primary.ctx")
+	return err
+}
+
+func workdir() (string, error) {
+	return ioutil.TempDir("", "tpm")
+}
+
+// Seal seals secret to the current values of pcrs
+func Seal(secret []byte, pcrs string) (Sealed, error) {
+	if len(secret) == 0 || len(secret) > maxSecret {
+		return Sealed{}, fmt.Errorf("secret size must be between 1 and %d", maxSecret)
+	}
+
+	dir, err := workdir()
+	if err != nil {
+		return Sealed{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := primary(dir); err != nil {
+		return Sealed{}, err
+	}
+
+	if _, err := tool(dir, nil, "tpm2_createpolicy", "--policy-pcr", "-l", pcrs, "-L", "pcr.policy"); err != nil {
+		return Sealed{}, err
+	}
+
+	if _, err := tool(dir, secret, "tpm2_create",
+		"-C", "primary.ctx", "-g", "sha256",
+		"-L", "pcr.policy", "-i", "-",
+		"-u", "seal.pub", "-r", "seal.priv",
+	); err != nil {
+		return Sealed{}, err
+	}
+
+	sealed := Sealed{PCRs: pcrs}
+	if sealed.Public, err = ioutil.ReadFile(filepath.Join(dir, "seal.pub")); err != nil {
+		return Sealed{}, err
+	}
+	if sealed.Private, err = ioutil.ReadFile(filepath.Join(dir, "seal.priv")); err != nil {
+		return Sealed{}, err
+	}
+
+	return sealed, nil
+}
+
+// Unseal returns the sealed secret, it fails if the PCRs changed since
+// the secret was sealed or on another TPM
+func Unseal(sealed Sealed) ([]byte, error) {
+	dir, err := workdir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "seal.pub"), sealed.Public, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "seal.priv"), sealed.Private, 0600); err != nil {
+		return nil, err
+	}
+
+	if err := primary(dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := tool(dir, nil, "tpm2_load", "-C", "primary.ctx", "-u", "seal.pub", "-r", "seal.priv", "-c", "seal.ctx"); err != nil {
+		return nil, err
+	}
+
+	secret, err := tool(dir, nil, "tpm2_unseal", "-c", "seal.ctx", "-p", "pcr:"+sealed.PCRs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unseal, the boot state of the node may have changed")
+	}
+
+	return secret, nil
+}
+
+// Key returns the key of size bytes stored at path, the key is created if
+// it doesn't exist. The key is sealed to the TPM if sealing is enabled,
+// otherwise or if the sealing fails it's kept in a file only readable by
+// root. A key stored in a file is sealed the next time it's read on a node
+// with a TPM.
+//
+// A sealed key that can't be unsealed anymore (the boot state changed) is
+// moved aside and replaced by a new key, so Key must only be used for the
+// keys the node can replace on its own, like the keys of its wireguard
+// interfaces, never for the keys of data kept on the disks
+func Key(path string, size int, enabled bool) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newKey(path, size, enabled)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sealed Sealed
+	if err := json.Unmarshal(data, &sealed); err == nil && len(sealed.Private) != 0 {
+		key, err := Unseal(sealed)
+		if err == nil {
+			return key, nil
+		}
+
+		// the sealed key is kept in case the boot state is restored
+		aside := fmt.Sprintf("%s.unsealable.%d", path, time.Now().Unix())
+		log.Error().Err(err).Str("path", path).Str("aside", aside).Msg("failed to unseal key, replacing it with a new key")
+		if err := os.Rename(path, aside); err != nil {
+			return nil, err
+		}
+
+		return newKey(path, size, enabled)
+	}
+
+	if len(data) != size {
+		return nil, fmt.Errorf("key at '%s' has the wrong size", path)
+	}
+
+	if enabled {
+		if err := writeKey(path, data, enabled); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// newKey creates a random key of size bytes at path
+func newKey(path string, size int, enabled bool) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, writeKey(path, key, enabled)
+}
+
+func writeKey(path string, key []byte, enabled bool) error {
+	data := key
+	if enabled {
+		sealed, err := Seal(key, DefaultPCRs)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("failed to seal key, keeping it in a file")
+		} else if data, err = json.Marshal(sealed); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0400); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,167 @@
+package tpm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTPM replaces the tpm2-tools, the sealed blob is the secret itself
+type fakeTPM struct {
+	calls []string
+	fail  bool
+}
+
+func (f *fakeTPM) tool(dir string, stdin []byte, name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, name)
+	if f.fail {
+		return nil, fmt.Errorf("no tpm")
+	}
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+	switch name {
+	case "tpm2_create":
+		if err := ioutil.WriteFile(path("seal.pub"), []byte("public"), 0600); err != nil {
+			return nil, err
+		}
+		return nil, ioutil.WriteFile(path("seal.priv"), stdin, 0600)
+	case "tpm2_load":
+		data, err := ioutil.ReadFile(path("seal.priv"))
+		if err != nil {
+			return nil, err
+		}
+		return nil, ioutil.WriteFile(path("seal.ctx"), data, 0600)
+	case "tpm2_unseal":
+		if args[len(args)-1] != "pcr:"+DefaultPCRs {
+			return nil, fmt.Errorf("wrong pcrs %v", args)
+		}
+		return ioutil.ReadFile(path("seal.ctx"))
+	}
+
+	return nil, nil
+}
+
+func withFake(t *testing.T, f *fakeTPM) func() {
+	original := tool
+	tool = f.tool
+	return func() { tool = original }
+}
+
+func TestSealUnseal(t *testing.T) {
+	fake := &fakeTPM{}
+	defer withFake(t, fake)()
+
+	sealed, err := Seal([]byte("secret"), DefaultPCRs)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPCRs, sealed.PCRs)
+	assert.Equal(t, []string{"tpm2_createprimary", "tpm2_createpolicy", "tpm2_create"}, fake.calls)
+
+	fake.calls = nil
+	secret, err := Unseal(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), secret)
+	assert.Equal(t, []string{"tpm2_createprimary", "tpm2_load", "tpm2_unseal"}, fake.calls)
+
+	_, err = Seal(nil, DefaultPCRs)
+	assert.Error(t, err)
+	_, err = Seal(make([]byte, maxSecret+1), DefaultPCRs)
+	assert.Error(t, err)
+}
+
+func TestKey(t *testing.T) {
+	fake := &fakeTPM{}
+	defer withFake(t, fake)()
+
+	root, err := ioutil.TempDir("", "tpm")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "key")
+
+	// no tpm, the key is kept in a file
+	key, err := Key(path, 32, false)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+	assert.Empty(t, fake.calls)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, data)
+
+	// the key is sealed once the tpm is enabled
+	loaded, err := Key(path, 32, true)
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var sealed Sealed
+	require.NoError(t, json.Unmarshal(data, &sealed))
+	assert.Equal(t, key, sealed.Private)
+
+	loaded, err = Key(path, 32, true)
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+}
+
+func TestKeySealFailure(t *testing.T) {
+	fake := &fakeTPM{fail: true}
+	defer withFake(t, fake)()
+
+	root, err := ioutil.TempDir("", "tpm")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "key")
+	key, err := Key(path, 16, true)
+	require.NoError(t, err)
+
+	// the key falls back to a file
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, data)
+
+	_, err = Key(path, 32, false)
+	assert.Error(t, err, "wrong size")
+}
+
+func TestKeyUnsealFailure(t *testing.T) {
+	fake := &fakeTPM{}
+	defer withFake(t, fake)()
+
+	root, err := ioutil.TempDir("", "tpm")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "key")
+	key, err := Key(path, 32, true)
+	require.NoError(t, err)
+
+	// the boot state changed, the key is replaced
+	fake.fail = true
+	replaced, err := Key(path, 32, true)
+	require.NoError(t, err)
+	require.Len(t, replaced, 32)
+	assert.NotEqual(t, key, replaced)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, replaced, data)
+
+	// the sealed key is moved aside, not deleted
+	aside, err := filepath.Glob(path + ".unsealable.*")
+	require.NoError(t, err)
+	require.Len(t, aside, 1)
+
+	data, err = ioutil.ReadFile(aside[0])
+	require.NoError(t, err)
+	var sealed Sealed
+	require.NoError(t, json.Unmarshal(data, &sealed))
+	assert.Equal(t, key, sealed.Private)
+}
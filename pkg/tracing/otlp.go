@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+// the kernel parameter setting the OTLP collector
+const tracingParam = "tracing"
+
+const (
+	// batchSize is the number of spans sent in a single request
+	batchSize = 128
+	// flushInterval is the longest a finished span waits to be sent
+	flushInterval = 5 * time.Second
+	// queueSize is the number of spans kept while the collector is slow,
+	// the spans finished while the queue is full are dropped
+	queueSize = 2048
+)
+
+// Config is the tracing configuration set by the farmer
+type Config struct {
+	// Endpoint is the url of the OTLP/HTTP collector, tracing is
+	// disabled if it's nil
+	Endpoint *url.URL
+}
+
+// ConfigFromParams reads the tracing configuration from the kernel
+// parameters. tracing=<url> exports the spans to the OTLP/HTTP collector
+// at url, the spans are posted to <url>/v1/traces
+func ConfigFromParams(params kernel.Params) (Config, error) {
+	var config Config
+
+	values, ok := params.Get(tracingParam)
+	if !ok || len(values) == 0 || values[0] == "" {
+		return config, nil
+	}
+
+	u, err := url.Parse(values[0])
+	if err != nil || len(u.Host) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return config, fmt.Errorf("invalid tracing collector '%s'", values[0])
+	}
+
+	config.Endpoint = u
+	return config, nil
+}
+
+// Enabled returns true if the spans are exported
+func (c *Config) Enabled() bool {
+	return c.Endpoint != nil
+}
+
+// OTLPExporter sends the spans to an OTLP/HTTP collector in batches
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	spans    chan *Span
+}
+
+// NewOTLPExporter creates an exporter to the collector at endpoint, the
+// spans are reported as coming from service. The spans are only sent once
+// the exporter runs
+func NewOTLPExporter(endpoint *url.URL, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: strings.TrimSuffix(endpoint.String(), "/") + "/v1/traces",
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *Span, queueSize),
+	}
+}
+
+// Export queues a finished span, it never blocks
+func (e *OTLPExporter) Export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		log.Debug().Str("span", span.Name).Msg("tracing queue is full, dropping span")
+	}
+}
+
+// Run sends the queued spans until ctx is canceled
+func (e *OTLPExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Warn().Err(err).Int("spans", len(batch)).Msg("failed to export spans")
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	body, err := json.Marshal(encode(e.service, spans))
+	if err != nil {
+		return err
+	}
+
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// the OTLP/JSON encoding of the spans
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+var otlpKinds = map[string]int{
+	KindInternal: 1,
+	KindServer:   2,
+	KindClient:   3,
+}
+
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func encode(service string, spans []*Span) otlpRequest {
+	var scope otlpScopeSpans
+	scope.Scope.Name = "github.com/threefoldtech/zos/pkg/tracing"
+
+	for _, span := range spans {
+		encoded := otlpSpan{
+			TraceID: span.Context.TraceID.String(),
+			SpanID:  span.Context.SpanID.String(),
+			Name:    span.Name,
+			Kind:    otlpKinds[span.Kind],
+			Start:   strconv.FormatInt(span.Start.UnixNano(), 10),
+			End:     strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:  otlpStatus{Code: otlpStatusOK},
+		}
+		if span.Parent.IsValid() {
+			encoded.ParentSpanID = span.Parent.SpanID.String()
+		}
+		if span.Error != "" {
+			encoded.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		for key, value := range span.Attributes {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+
+		scope.Spans = append(scope.Spans, encoded)
+	}
+
+	var resource otlpResourceSpans
+	resource.Resource.Attributes = []otlpAttribute{
+		{Key: "service.name", Value: otlpValue{StringValue: service}},
+	}
+	resource.ScopeSpans = []otlpScopeSpans{scope}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{resource}}
+}
+
+// Init sets the global tracer of module from the kernel parameters. The
+// spans are exported until ctx is canceled, they are dropped if tracing is
+// not configured. broker is the address of the message broker, the
+// context of the zbus calls is handed over through its redis. The context
+// is handed over even if tracing is not configured, the called modules
+// need the caller of the calls
+func Init(ctx context.Context, module, broker string) error {
+	config, err := ConfigFromParams(kernel.GetParams())
+	if err != nil {
+		return err
+	}
+
+	store, err := NewRedisStore(broker)
+	if err != nil {
+		return err
+	}
+
+	if !config.Enabled() {
+		SetTracer(NewTracer(module, nil, store))
+		return nil
+	}
+
+	exporter := NewOTLPExporter(config.Endpoint, module)
+	go exporter.Run(ctx)
+
+	SetTracer(NewTracer(module, exporter, store))
+	log.Info().Str("collector", config.Endpoint.String()).Msg("exporting traces")
+	return nil
+}
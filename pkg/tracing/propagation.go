@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/utils"
+)
+
+// callTTL is how long the context of a call waits for the called module,
+// a zbus call is served long before. A handover older than that belongs to
+// a call that never reached the module and is dropped
+const callTTL = 30 * time.Second
+
+// Handover is the context of a zbus call handed over to the called module
+type Handover struct {
+	// Context is the context of the client span of the call
+	Context SpanContext
+	// Caller is the user the call is made for, empty if unknown. It's
+	// declared by the client and nothing authenticates it, it's only a
+	// hint to attribute the operation in the traces and the audit log
+	Caller string
+	// Time the call was handed over
+	Time time.Time
+}
+
+// Store hands the context of the zbus calls over to the called modules.
+// The handovers of the calls on the same object are queued, each one is
+// removed when it's taken so a call never gets the handover of another
+// call that was already served
+type Store interface {
+	Push(key string, h Handover) error
+	Pop(key string) (Handover, error)
+}
+
+// callKey is the key the context of a call is stored under. key identifies
+// the object of the call (a network, a volume, a container)
+func callKey(module, method, key string) string {
+	return fmt.Sprintf("trace:%s:%s:%s", module, method, key)
+}
+
+// Call starts the client span of a zbus call to method of module about the
+// object identified by key, and hands its context over to the module. The
+// span must be finished by the caller once the call returns
+func (t *Tracer) Call(ctx context.Context, module, method, key string) (context.Context, *Span) {
+	var parent SpanContext
+	if current := SpanFromContext(ctx); current != nil {
+		parent = current.Context
+	}
+
+	span := t.newSpan(fmt.Sprintf("%s.%s", module, method), KindClient, parent)
+	span.Attributes["zbus.module"] = module
+	span.Attributes["zbus.method"] = method
+	span.Attributes["key"] = key
+
+	caller := Caller(ctx)
+	if caller != "" {
+		span.Attributes["caller"] = caller
+	}
+
+	if t.store != nil {
+		h := Handover{Context: span.Context, Caller: caller, Time: time.Now()}
+		if err := t.store.Push(callKey(module, method, key), h); err != nil {
+			log.Debug().Err(err).Str("method", method).Msg("failed to hand over trace context")
+		}
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Serve starts the server span of a zbus call to method about the object
+// identified by key. Its parent is the client span of the caller if it
+// handed its context over, a new trace is started otherwise. The returned
+// context holds the caller hint of the call if it was handed over
+func (t *Tracer) Serve(method, key string) (context.Context, *Span) {
+	var handover Handover
+	if t.store != nil {
+		if h, err := t.store.Pop(callKey(t.module, method, key)); err == nil {
+			handover = h
+		}
+	}
+
+	span := t.newSpan(fmt.Sprintf("%s.%s", t.module, method), KindServer, handover.Context)
+	span.Attributes["zbus.method"] = method
+	span.Attributes["key"] = key
+
+	ctx := ContextWithSpan(context.Background(), span)
+	if handover.Caller != "" {
+		span.Attributes["caller"] = handover.Caller
+		ctx = WithCaller(ctx, handover.Caller)
+	}
+
+	return ctx, span
+}
+
+// Call starts the client span of a zbus call with the global tracer
+func Call(ctx context.Context, module, method, key string) (context.Context, *Span) {
+	return Global().Call(ctx, module, method, key)
+}
+
+// Serve starts the server span of a zbus call with the global tracer
+func Serve(method, key string) (context.Context, *Span) {
+	return Global().Serve(method, key)
+}
+
+// redisStore keeps the span contexts in the redis of the message broker
+type redisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore creates a store in the redis server at address, a
+// unix:// or tcp:// url as the one of the message broker
+func NewRedisStore(address string) (Store, error) {
+	pool, err := utils.NewRedisPool(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisStore{pool: pool}, nil
+}
+
+// the handovers of an object are queued in a list, each one is stored as
+// the time of the call and its traceparent, followed by the caller if known
+func (s *redisStore) Push(key string, h Handover) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	value := fmt.Sprintf("%d %s", h.Time.UnixNano(), h.Context.String())
+	if h.Caller != "" {
+		value += " " + h.Caller
+	}
+
+	if err := con.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := con.Send("RPUSH", key, value); err != nil {
+		return err
+	}
+	if err := con.Send("EXPIRE", key, int(callTTL.Seconds())); err != nil {
+		return err
+	}
+	_, err := con.Do("EXEC")
+	return err
+}
+
+func (s *redisStore) Pop(key string) (Handover, error) {
+	con := s.pool.Get()
+	defer con.Close()
+
+	for {
+		value, err := redis.String(con.Do("LPOP", key))
+		if err != nil {
+			return Handover{}, err
+		}
+
+		h, err := parseHandover(value)
+		if err != nil {
+			return h, err
+		}
+
+		if time.Since(h.Time) <= callTTL {
+			return h, nil
+		}
+	}
+}
+
+func parseHandover(value string) (Handover, error) {
+	var h Handover
+	parts := strings.SplitN(value, " ", 3)
+	if len(parts) < 2 {
+		return h, fmt.Errorf("invalid trace handover '%s'", value)
+	}
+
+	nano, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return h, fmt.Errorf("invalid trace handover time '%s'", parts[0])
+	}
+	h.Time = time.Unix(0, nano)
+
+	if len(parts) == 3 {
+		h.Caller = parts[2]
+	}
+
+	sc, err := ParseSpanContext(parts[1])
+	if err != nil {
+		return h, err
+	}
+	h.Context = sc
+
+	return h, nil
+}
+
+// memoryStore is a Store local to the process
+type memoryStore struct {
+	mu    sync.Mutex
+	calls map[string][]Handover
+}
+
+// NewMemoryStore creates a store local to the process, it's used when the
+// caller and the called module share the same process
+func NewMemoryStore() Store {
+	return &memoryStore{calls: make(map[string][]Handover)}
+}
+
+func (s *memoryStore) Push(key string, h Handover) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key] = append(s.calls[key], h)
+	return nil
+}
+
+func (s *memoryStore) Pop(key string) (Handover, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.calls[key]
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if time.Since(h.Time) <= callTTL {
+			s.setQueue(key, queue)
+			return h, nil
+		}
+	}
+
+	delete(s.calls, key)
+	return Handover{}, fmt.Errorf("no trace context for '%s'", key)
+}
+
+func (s *memoryStore) setQueue(key string, queue []Handover) {
+	if len(queue) == 0 {
+		delete(s.calls, key)
+		return
+	}
+	s.calls[key] = queue
+}
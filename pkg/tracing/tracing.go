@@ -0,0 +1,271 @@
+// Package tracing records the spans of the operations of the modules, so a
+// slow deployment can be broken down to the module and the step that took
+// the time. The spans are exported to an OTLP collector set by the farmer.
+//
+// zbus requests don't carry any metadata, so the trace context of a call is
+// handed over to the called module through redis: the caller stores the
+// context of its client span under the key of the call (the module, the
+// method and the workload the call is about), and the called module picks it
+// up as the parent of its server span. The user the call is made for is
+// handed over with it, so the called module can account the call to them.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span in a trace
+type SpanID [8]byte
+
+// IsValid returns true if the id is not all zeros
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns true if the id is not all zeros
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is the part of a span propagated to its children
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns true if the context belongs to a span
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// String encodes the context as a w3c traceparent header
+func (c SpanContext) String() string {
+	return fmt.Sprintf("00-%s-%s-01", c.TraceID, c.SpanID)
+}
+
+// ParseSpanContext parses a w3c traceparent header
+func ParseSpanContext(value string) (SpanContext, error) {
+	var c SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return c, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+
+	trace, err := hex.DecodeString(parts[1])
+	if err != nil || len(trace) != len(c.TraceID) {
+		return c, fmt.Errorf("invalid trace id '%s'", parts[1])
+	}
+	span, err := hex.DecodeString(parts[2])
+	if err != nil || len(span) != len(c.SpanID) {
+		return c, fmt.Errorf("invalid span id '%s'", parts[2])
+	}
+
+	copy(c.TraceID[:], trace)
+	copy(c.SpanID[:], span)
+	if !c.IsValid() {
+		return c, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+
+	return c, nil
+}
+
+// the kinds of span
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+// Span is an operation of a module
+type Span struct {
+	Name    string
+	Kind    string
+	Context SpanContext
+	// Parent is the context of the parent span, it's invalid for the root
+	// span of a trace
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	// Error is the error the operation failed with, empty on success
+	Error string
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// SetAttribute sets an attribute of the span. A nil span is ignored
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// Finish ends the span with the result of the operation, it can be called
+// more than once, only the first call counts. A nil span is ignored
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		s.End = s.tracer.now()
+		if err != nil {
+			s.Error = err.Error()
+		}
+		s.tracer.export(s)
+	})
+}
+
+// Exporter sends the finished spans to a collector
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer creates the spans of a module
+type Tracer struct {
+	module   string
+	exporter Exporter
+	store    Store
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewTracer creates a tracer for module. The spans are sent to exporter
+// and the context of the zbus calls are handed over through store, both
+// can be nil
+func NewTracer(module string, exporter Exporter, store Store) *Tracer {
+	return &Tracer{
+		module:   module,
+		exporter: exporter,
+		store:    store,
+		now:      time.Now,
+	}
+}
+
+// Module returns the name of the module of the tracer
+func (t *Tracer) Module() string {
+	return t.module
+}
+
+func (t *Tracer) export(span *Span) {
+	if t.exporter != nil {
+		t.exporter.Export(span)
+	}
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the current span of ctx, nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a copy of ctx with span as the current span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx with the user the operations are made for
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// Caller returns the user the operations of ctx are made for, empty if it's
+// not known
+func Caller(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// Start starts a span child of the current span of ctx, a new trace is
+// started if ctx has no span. The span must be finished by the caller
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	var parent SpanContext
+	if current := SpanFromContext(ctx); current != nil {
+		parent = current.Context
+	}
+
+	span := t.newSpan(name, KindInternal, parent)
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) newSpan(name, kind string, parent SpanContext) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		Start:      t.now(),
+		Attributes: map[string]string{"module": t.module},
+		tracer:     t,
+	}
+
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return span
+}
+
+var (
+	global   = NewTracer("", nil, nil)
+	globalMu sync.RWMutex
+)
+
+// SetTracer sets the tracer used by the package level functions
+func SetTracer(tracer *Tracer) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = tracer
+}
+
+// Global returns the tracer used by the package level functions. It drops
+// the spans until a tracer is set with SetTracer
+func Global() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// Start starts a span with the global tracer
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return Global().Start(ctx, name)
+}
+
+// Step runs fn in a span child of the current span of ctx, with the global
+// tracer
+func Step(ctx context.Context, name string, fn func() error) error {
+	_, span := Start(ctx, name)
+	err := fn()
+	span.Finish(err)
+	return err
+}
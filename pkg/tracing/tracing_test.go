@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+type recorder struct {
+	spans []*Span
+}
+
+func (r *recorder) Export(span *Span) {
+	r.spans = append(r.spans, span)
+}
+
+func TestSpanContext(t *testing.T) {
+	require := require.New(t)
+
+	sc, err := ParseSpanContext("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(err)
+	require.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal("00f067aa0ba902b7", sc.SpanID.String())
+	require.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.String())
+
+	for _, value := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseSpanContext(value)
+		require.Error(err, value)
+	}
+}
+
+func TestStart(t *testing.T) {
+	require := require.New(t)
+
+	exported := &recorder{}
+	tracer := NewTracer("provision", exported, nil)
+
+	ctx, root := tracer.Start(context.Background(), "provision")
+	_, child := tracer.Start(ctx, "volume")
+	child.Finish(fmt.Errorf("no space left"))
+	child.Finish(nil)
+	root.Finish(nil)
+
+	require.Len(exported.spans, 2)
+	require.False(root.Parent.IsValid())
+	require.Equal(root.Context.TraceID, child.Context.TraceID)
+	require.Equal(root.Context, child.Parent)
+	require.NotEqual(root.Context.SpanID, child.Context.SpanID)
+	require.Equal("no space left", child.Error)
+	require.Equal("provision", child.Attributes["module"])
+}
+
+func TestCallServe(t *testing.T) {
+	require := require.New(t)
+
+	store := NewMemoryStore()
+	exported := &recorder{}
+	caller := NewTracer("provision", exported, store)
+	called := NewTracer("storage", exported, store)
+
+	ctx, root := caller.Start(WithCaller(context.Background(), "42"), "provision")
+	_, call := caller.Call(ctx, "storage", "CreateFilesystem", "1-1")
+
+	served, serve := called.Serve("CreateFilesystem", "1-1")
+	require.Equal(call.Context, serve.Parent)
+	require.Equal(root.Context.TraceID, serve.Context.TraceID)
+	require.Equal(KindServer, serve.Kind)
+	require.Equal("42", Caller(served))
+	require.Equal("42", serve.Attributes["caller"])
+
+	// a call nobody handed over starts a new trace
+	served, other := called.Serve("CreateFilesystem", "2-1")
+	require.False(other.Parent.IsValid())
+	require.NotEqual(root.Context.TraceID, other.Context.TraceID)
+	require.Empty(Caller(served))
+
+	// the handover is taken by the call it was made for
+	served, _ = called.Serve("CreateFilesystem", "1-1")
+	require.Empty(Caller(served))
+
+	// two calls on the same object get one handover each
+	_, first := caller.Call(ctx, "storage", "CreateFilesystem", "1-1")
+	_, second := caller.Call(WithCaller(ctx, "43"), "storage", "CreateFilesystem", "1-1")
+	served, serve = called.Serve("CreateFilesystem", "1-1")
+	require.Equal(first.Context, serve.Parent)
+	require.Equal("42", Caller(served))
+	served, serve = called.Serve("CreateFilesystem", "1-1")
+	require.Equal(second.Context, serve.Parent)
+	require.Equal("43", Caller(served))
+}
+
+func TestHandoverExpired(t *testing.T) {
+	require := require.New(t)
+
+	store := NewMemoryStore()
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	require.NoError(store.Push("key", Handover{Context: sc, Caller: "42", Time: time.Now().Add(-2 * callTTL)}))
+
+	// the handover of a call that never reached the module is dropped
+	_, err := store.Pop("key")
+	require.Error(err)
+}
+
+func TestParseHandover(t *testing.T) {
+	require := require.New(t)
+
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+
+	now := time.Unix(0, 1591000000000000000)
+
+	h, err := parseHandover("1591000000000000000 " + sc.String())
+	require.NoError(err)
+	require.Equal(Handover{Context: sc, Time: now}, h)
+
+	h, err = parseHandover("1591000000000000000 " + sc.String() + " 42")
+	require.NoError(err)
+	require.Equal(Handover{Context: sc, Caller: "42", Time: now}, h)
+
+	_, err = parseHandover("42")
+	require.Error(err)
+
+	_, err = parseHandover(sc.String() + " 42")
+	require.Error(err)
+}
+
+func TestConfigFromParams(t *testing.T) {
+	require := require.New(t)
+
+	config, err := ConfigFromParams(kernel.Params{})
+	require.NoError(err)
+	require.False(config.Enabled())
+
+	config, err = ConfigFromParams(kernel.Params{"tracing": {"http://10.0.0.1:4318"}})
+	require.NoError(err)
+	require.True(config.Enabled())
+
+	_, err = ConfigFromParams(kernel.Params{"tracing": {"10.0.0.1:4318"}})
+	require.Error(err)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	require := require.New(t)
+
+	received := make(chan otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal("/v1/traces", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		var request otlpRequest
+		require.NoError(json.Unmarshal(body, &request))
+		received <- request
+	}))
+	defer server.Close()
+
+	endpoint, err := url.Parse(server.URL)
+	require.NoError(err)
+
+	exporter := NewOTLPExporter(endpoint, "storage")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tracer := NewTracer("storage", exporter, nil)
+	_, span := tracer.Start(context.Background(), "allocate")
+	span.Finish(fmt.Errorf("pool is full"))
+
+	go exporter.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case request := <-received:
+		require.Len(request.ResourceSpans, 1)
+		require.Equal("storage", request.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+		spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+		require.Len(spans, 1)
+		require.Equal("allocate", spans[0].Name)
+		require.Equal(span.Context.TraceID.String(), spans[0].TraceID)
+		require.Equal(otlpStatusError, spans[0].Status.Code)
+	case <-time.After(time.Second):
+		t.Fatal("spans not exported")
+	}
+}
@@ -0,0 +1,251 @@
+package upgrade
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+// Channel is a release channel of 0-OS
+type Channel string
+
+// The release channels, from the most to the least stable
+const (
+	ChannelProduction Channel = "production"
+	ChannelTesting    Channel = "testing"
+	ChannelCanary     Channel = "canary"
+)
+
+// ChannelRepo is the hub repository of the flists of the channels
+const ChannelRepo = "tf-zos"
+
+// the sources of the channel of a node
+const (
+	sourceNode    = "node"
+	sourceRollout = "rollout"
+	sourceFarm    = "farm"
+	sourceBoot    = "boot"
+)
+
+// the kernel parameters configuring the channels of a farm
+const (
+	channelParam = "upgrade-channel"
+	rolloutParam = "upgrade-rollout"
+)
+
+// Valid checks the channel is known
+func (c Channel) Valid() error {
+	switch c {
+	case ChannelProduction, ChannelTesting, ChannelCanary:
+		return nil
+	}
+
+	return fmt.Errorf("unknown channel '%s'", c)
+}
+
+// FList returns the flist of the channel
+func (c Channel) FList() string {
+	return path.Join(ChannelRepo, fmt.Sprintf("zos:%s:latest.flist", c))
+}
+
+// Rollout is the percentage of the nodes of a farm following a channel
+type Rollout struct {
+	Channel Channel
+	Percent int
+}
+
+// ChannelConfig is the channel configuration of the farm
+type ChannelConfig struct {
+	// Default is the channel of the nodes of the farm, the channel the
+	// node booted from is used if it's not set
+	Default Channel
+	// Rollouts are the staged rollouts of the farm, a node of the farm
+	// follows the channel of the first rollout it's part of
+	Rollouts []Rollout
+}
+
+// ChannelConfigFromParams reads the channel configuration from the kernel
+// parameters. upgrade-channel=<channel> sets the channel of the farm and
+// upgrade-rollout=<channel>:<percent> moves a percentage of the nodes of
+// the farm to an early channel, it can be set once per channel, like
+// upgrade-rollout=canary:5 upgrade-rollout=testing:20
+func ChannelConfigFromParams(params kernel.Params) (ChannelConfig, error) {
+	var config ChannelConfig
+
+	if values, ok := params.Get(channelParam); ok && len(values) > 0 {
+		config.Default = Channel(values[0])
+		if err := config.Default.Valid(); err != nil {
+			return config, err
+		}
+	}
+
+	values, _ := params.Get(rolloutParam)
+	total := 0
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return config, fmt.Errorf("invalid rollout '%s', expected <channel>:<percent>", value)
+		}
+
+		rollout := Rollout{Channel: Channel(parts[0])}
+		if err := rollout.Channel.Valid(); err != nil {
+			return config, err
+		}
+
+		percent, err := strconv.Atoi(parts[1])
+		if err != nil || percent < 0 || percent > 100 {
+			return config, fmt.Errorf("invalid rollout percentage '%s'", parts[1])
+		}
+		rollout.Percent = percent
+
+		total += percent
+		config.Rollouts = append(config.Rollouts, rollout)
+	}
+
+	if total > 100 {
+		return config, fmt.Errorf("rollout percentages add up to more than 100")
+	}
+
+	return config, nil
+}
+
+// bucket places the node in [0, 100), the same node is always in the same
+// bucket so it stays in the same rollout
+func bucket(nodeID string) int {
+	sum := sha256.Sum256([]byte(nodeID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// bootChannel returns the channel of the boot flist name, its name is
+// <repo>/zos:<channel>:latest.flist
+func bootChannel(boot string) Channel {
+	parts := strings.Split(path.Base(boot), ":")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return Channel(parts[1])
+}
+
+// Channels implements pkg.UpgradeChannels. The channel set on the node is
+// stored in a file
+type Channels struct {
+	path   string
+	boot   string
+	nodeID string
+	config ChannelConfig
+
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+var _ pkg.UpgradeChannels = (*Channels)(nil)
+
+// NewChannels creates the channels of the node, the channel set on the node
+// is stored at path. boot is the name of the flist the node booted from
+func NewChannels(path, boot, nodeID string, config ChannelConfig) *Channels {
+	return &Channels{
+		path:    path,
+		boot:    boot,
+		nodeID:  nodeID,
+		config:  config,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (c *Channels) node() (Channel, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to read node channel")
+	}
+
+	return Channel(strings.TrimSpace(string(data))), nil
+}
+
+// Channel implements pkg.UpgradeChannels
+func (c *Channels) Channel() (pkg.UpgradeChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.node()
+	if err != nil {
+		return pkg.UpgradeChannel{}, err
+	}
+
+	channel, source := c.config.Default, sourceFarm
+	if len(node) != 0 {
+		channel, source = node, sourceNode
+	} else {
+		b, lower := bucket(c.nodeID), 0
+		for _, rollout := range c.config.Rollouts {
+			if b < lower+rollout.Percent {
+				channel, source = rollout.Channel, sourceRollout
+				break
+			}
+			lower += rollout.Percent
+		}
+	}
+
+	if len(channel) == 0 {
+		source = sourceBoot
+	}
+
+	if len(channel) == 0 || channel == bootChannel(c.boot) {
+		// following the boot flist keeps the repository it's in
+		return pkg.UpgradeChannel{
+			Channel: string(bootChannel(c.boot)),
+			Source:  source,
+			FList:   c.boot,
+		}, nil
+	}
+
+	return pkg.UpgradeChannel{
+		Channel: string(channel),
+		Source:  source,
+		FList:   channel.FList(),
+	}, nil
+}
+
+// SetChannel implements pkg.UpgradeChannels
+func (c *Channels) SetChannel(channel string) error {
+	if len(channel) != 0 {
+		if err := Channel(channel).Valid(); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(channel) == 0 {
+		if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := ioutil.WriteFile(c.path, []byte(channel), 0644); err != nil {
+		return errors.Wrap(err, "failed to store node channel")
+	}
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Changed receives when the channel set on the node changes
+func (c *Channels) Changed() <-chan struct{} {
+	return c.changed
+}
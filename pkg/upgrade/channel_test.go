@@ -0,0 +1,111 @@
+package upgrade
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/kernel"
+)
+
+func TestChannelConfigFromParams(t *testing.T) {
+	config, err := ChannelConfigFromParams(kernel.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, ChannelConfig{}, config)
+
+	config, err = ChannelConfigFromParams(kernel.Params{
+		"upgrade-channel": {"testing"},
+		"upgrade-rollout": {"canary:5", "testing:20"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ChannelConfig{
+		Default: ChannelTesting,
+		Rollouts: []Rollout{
+			{Channel: ChannelCanary, Percent: 5},
+			{Channel: ChannelTesting, Percent: 20},
+		},
+	}, config)
+
+	for _, params := range []kernel.Params{
+		{"upgrade-channel": {"nightly"}},
+		{"upgrade-rollout": {"canary"}},
+		{"upgrade-rollout": {"canary:-1"}},
+		{"upgrade-rollout": {"canary:60", "testing:60"}},
+	} {
+		_, err := ChannelConfigFromParams(params)
+		assert.Error(t, err, params)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	root, err := ioutil.TempDir("", "channels")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	const boot = "tf-zos/zos:production:latest.flist"
+	path := filepath.Join(root, "channel")
+
+	channels := NewChannels(path, boot, "node", ChannelConfig{})
+	channel, err := channels.Channel()
+	require.NoError(t, err)
+	assert.Equal(t, "production", channel.Channel)
+	assert.Equal(t, sourceBoot, channel.Source)
+	assert.Equal(t, boot, channel.FList)
+
+	channels = NewChannels(path, boot, "node", ChannelConfig{Default: ChannelTesting})
+	channel, err = channels.Channel()
+	require.NoError(t, err)
+	assert.Equal(t, "testing", channel.Channel)
+	assert.Equal(t, sourceFarm, channel.Source)
+	assert.Equal(t, "tf-zos/zos:testing:latest.flist", channel.FList)
+
+	require.NoError(t, channels.SetChannel("canary"))
+	select {
+	case <-channels.Changed():
+	default:
+		t.Fatal("channel change not notified")
+	}
+
+	channel, err = channels.Channel()
+	require.NoError(t, err)
+	assert.Equal(t, "canary", channel.Channel)
+	assert.Equal(t, sourceNode, channel.Source)
+	assert.Equal(t, "tf-zos/zos:canary:latest.flist", channel.FList)
+
+	assert.Error(t, channels.SetChannel("nightly"))
+
+	require.NoError(t, channels.SetChannel(""))
+	channel, err = channels.Channel()
+	require.NoError(t, err)
+	assert.Equal(t, sourceFarm, channel.Source)
+}
+
+func TestChannelsRollout(t *testing.T) {
+	config := ChannelConfig{
+		Rollouts: []Rollout{
+			{Channel: ChannelCanary, Percent: 10},
+			{Channel: ChannelTesting, Percent: 30},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		channels := NewChannels("/nonexisting", "tf-zos/zos:production:latest.flist", fmt.Sprintf("node-%d", i), config)
+		channel, err := channels.Channel()
+		require.NoError(t, err)
+		counts[channel.Channel]++
+
+		// a node always gets the same channel
+		again, err := channels.Channel()
+		require.NoError(t, err)
+		assert.Equal(t, channel, again)
+	}
+
+	assert.InDelta(t, 100, counts["canary"], 40)
+	assert.InDelta(t, 300, counts["testing"], 60)
+	assert.InDelta(t, 600, counts["production"], 60)
+}
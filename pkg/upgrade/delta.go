@@ -0,0 +1,280 @@
+package upgrade
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// the flists are mounted with 0-fs, a file is only downloaded from the hub
+// when it's read. An flist can hold bsdiff patches under DeltaDir named
+// <sha256 of old file>-<sha256 of new file>.bsdiff, a changed file is then
+// patched from the installed one instead of downloaded completely, which
+// matters for the farms on slow or metered links. The patched file is
+// checked against the signed manifest like a downloaded one
+
+const (
+	deltaExt    = ".bsdiff"
+	bsdiffMagic = "BSDIFF40"
+)
+
+// deltaPath is the path in the flist of the patch from the file with hash
+// from to the file with hash to
+func deltaPath(from, to string) string {
+	return filepath.Join(DeltaDir, from+"-"+to+deltaExt)
+}
+
+// offtin decodes the sign-magnitude integers of bsdiff
+func offtin(buf []byte) int64 {
+	y := int64(binary.LittleEndian.Uint64(buf) &^ (1 << 63))
+	if buf[7]&0x80 != 0 {
+		y = -y
+	}
+
+	return y
+}
+
+// bspatch applies a bsdiff patch to old and returns the new file
+func bspatch(old, patch []byte) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != bsdiffMagic {
+		return nil, fmt.Errorf("invalid patch header")
+	}
+
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || 32+ctrlLen+diffLen > int64(len(patch)) {
+		return nil, fmt.Errorf("corrupted patch")
+	}
+
+	body := patch[32:]
+	ctrl := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+
+	new := make([]byte, newSize)
+	var oldPos, newPos int64
+	buf := make([]byte, 8)
+	for newPos < newSize {
+		var c [3]int64
+		for i := range c {
+			if _, err := io.ReadFull(ctrl, buf); err != nil {
+				return nil, errors.Wrap(err, "corrupted patch control block")
+			}
+			c[i] = offtin(buf)
+		}
+
+		if c[0] < 0 || c[1] < 0 || newPos+c[0] > newSize {
+			return nil, fmt.Errorf("corrupted patch")
+		}
+
+		// diff block, added to the old bytes
+		if _, err := io.ReadFull(diff, new[newPos:newPos+c[0]]); err != nil {
+			return nil, errors.Wrap(err, "corrupted patch diff block")
+		}
+		for i := int64(0); i < c[0]; i++ {
+			if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+				new[newPos+i] += old[oldPos+i]
+			}
+		}
+		newPos += c[0]
+		oldPos += c[0]
+
+		if newPos+c[1] > newSize {
+			return nil, fmt.Errorf("corrupted patch")
+		}
+
+		// extra block, copied as is
+		if _, err := io.ReadFull(extra, new[newPos:newPos+c[1]]); err != nil {
+			return nil, errors.Wrap(err, "corrupted patch extra block")
+		}
+		newPos += c[1]
+		oldPos += c[2]
+	}
+
+	return new, nil
+}
+
+// installStats sums up how the files of an flist were installed
+type installStats struct {
+	Unchanged int
+	Patched   int
+	Copied    int
+	// Downloaded is the number of bytes read from the flist
+	Downloaded int64
+}
+
+// writeTemp writes the content of r next to path and returns its sha256,
+// path is then replaced with a rename so a running binary can be replaced
+func writeTemp(path string, r io.Reader, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp := path + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_SYNC, mode.Perm())
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replace writes the content of r to path if its hash is expected
+func replace(path string, r io.Reader, mode os.FileMode, expected string) error {
+	hash, err := writeTemp(path, r, mode)
+	if err != nil {
+		return err
+	}
+
+	if hash != expected {
+		os.Remove(path + ".new")
+		return verificationError("file '%s' doesn't match the release manifest", path)
+	}
+
+	return os.Rename(path+".new", path)
+}
+
+// patch tries to install dest from the patch in the flist mounted at root
+// from the installed version, it returns the size of the patch
+func patch(root, dest, current, expected string, mode os.FileMode) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, deltaPath(current, expected)))
+	if err != nil {
+		return 0, err
+	}
+
+	old, err := ioutil.ReadFile(dest)
+	if err != nil {
+		return 0, err
+	}
+
+	new, err := bspatch(old, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(data)), replace(dest, bytes.NewReader(new), mode, expected)
+}
+
+// install installs the files and the symlinks of the flist mounted at root
+// listed in the manifest under destination, except the files in skip. The files already
+// installed are not read from the flist, the changed files are patched when
+// the flist has a patch from the installed version, or copied otherwise
+func install(root, destination string, manifest *Manifest, skip ...string) (installStats, error) {
+	var stats installStats
+
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		expected := manifest.Files[name]
+		dest := filepath.Join(destination, name)
+		if release(name) || isIn(dest, skip) {
+			continue
+		}
+
+		src := filepath.Join(root, name)
+		info, err := os.Stat(src)
+		if err != nil {
+			return stats, err
+		}
+
+		current, err := hashFile(dest)
+		if err == nil && current == expected {
+			if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+				return stats, err
+			}
+			stats.Unchanged++
+			continue
+		}
+
+		if err == nil {
+			size, err := patch(root, dest, current, expected, info.Mode())
+			if err == nil {
+				log.Debug().Str("file", dest).Int64("size", size).Msg("file patched")
+				stats.Patched++
+				stats.Downloaded += size
+				continue
+			} else if !os.IsNotExist(err) {
+				log.Warn().Err(err).Str("file", dest).Msg("failed to patch file, downloading it")
+			}
+		}
+
+		log.Info().Str("source", src).Str("destination", dest).Msg("copy file")
+		f, err := os.Open(src)
+		if err != nil {
+			return stats, err
+		}
+
+		err = replace(dest, f, info.Mode(), expected)
+		f.Close()
+		if err != nil {
+			return stats, errors.Wrapf(err, "failed to install '%s'", name)
+		}
+
+		stats.Copied++
+		stats.Downloaded += info.Size()
+	}
+
+	links := make([]string, 0, len(manifest.Links))
+	for name := range manifest.Links {
+		links = append(links, name)
+	}
+	sort.Strings(links)
+
+	for _, name := range links {
+		dest := filepath.Join(destination, name)
+		if isIn(dest, skip) {
+			continue
+		}
+
+		if err := symlink(dest, manifest.Links[name]); err != nil {
+			return stats, errors.Wrapf(err, "failed to install symlink '%s'", name)
+		}
+	}
+
+	return stats, nil
+}
+
+// symlink makes path a symlink to target, unless it already is
+func symlink(path, target string) error {
+	if current, err := os.Readlink(path); err == nil && current == target {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".new"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,113 @@
+package upgrade
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	deltaOld = "zos module binary v1.0.0"
+	deltaNew = "zos module binary v1.1.0 patched"
+	// deltaPatch is the bsdiff patch from deltaOld to deltaNew
+	deltaPatch = "42534449464634302b000000000000002a000000000000002000000000000000425a6839314159265359bced0117000005e0004848004020002186819a0c56c9b8bb9229c28485e76808b8425a6839314159265359058b2f42000001600060002000200030cc0cf50599c5dc914e14240162cbd080425a6839314159265359273c4966000000118040002e40440020002200f28430230451f177245385090273c49660"
+)
+
+func hashOf(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "hash")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	hash, err := hashFile(f.Name())
+	require.NoError(t, err)
+	return hash
+}
+
+func TestBSPatch(t *testing.T) {
+	patch, err := hex.DecodeString(deltaPatch)
+	require.NoError(t, err)
+
+	new, err := bspatch([]byte(deltaOld), patch)
+	require.NoError(t, err)
+	assert.Equal(t, deltaNew, string(new))
+
+	_, err = bspatch([]byte(deltaOld), patch[:40])
+	assert.Error(t, err)
+
+	_, err = bspatch([]byte(deltaOld), []byte("not a patch"))
+	assert.Error(t, err)
+}
+
+func TestInstall(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	patch, err := hex.DecodeString(deltaPatch)
+	require.NoError(t, err)
+
+	root := signedFList(t, sk, map[string]string{
+		"/bin/patched":   deltaNew,
+		"/bin/unchanged": "unchanged binary",
+		"/bin/new":       "new binary",
+		deltaPath(hashOf(t, deltaOld), hashOf(t, deltaNew)): string(patch),
+	})
+	defer os.RemoveAll(root)
+
+	manifest, err := LoadManifest(root, hex.EncodeToString(sk.Public().(ed25519.PublicKey)))
+	require.NoError(t, err)
+	require.NoError(t, manifest.VerifyTree(root))
+
+	dest, err := ioutil.TempDir("", "install")
+	require.NoError(t, err)
+	defer os.RemoveAll(dest)
+
+	writeFile(t, dest, "/bin/patched", deltaOld)
+	writeFile(t, dest, "/bin/unchanged", "unchanged binary")
+
+	stats, err := install(root, dest, &manifest)
+	require.NoError(t, err)
+	assert.Equal(t, installStats{Unchanged: 1, Patched: 1, Copied: 1, Downloaded: int64(len(patch) + len("new binary"))}, stats)
+	assert.NoError(t, manifest.VerifyInstalled(dest))
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "bin", "patched"))
+	require.NoError(t, err)
+	assert.Equal(t, deltaNew, string(data))
+
+	_, err = os.Stat(filepath.Join(dest, DeltaDir))
+	assert.True(t, os.IsNotExist(err))
+
+	// the symlinks are installed with their target
+	manifest.Links = map[string]string{"/bin/link": "new"}
+	writeFile(t, dest, "/bin/link", "old file")
+	_, err = install(root, dest, &manifest)
+	require.NoError(t, err)
+	assert.NoError(t, manifest.VerifyInstalled(dest))
+
+	target, err := os.Readlink(filepath.Join(dest, "bin", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", target)
+
+	require.NoError(t, os.Remove(filepath.Join(dest, "bin", "link")))
+	assert.True(t, IsVerificationError(manifest.VerifyInstalled(dest)))
+	manifest.Links = nil
+
+	// a tampered file of the flist is not installed
+	writeFile(t, root, "/bin/new", "evil binary")
+	writeFile(t, dest, "/bin/new", "old binary")
+	_, err = install(root, dest, &manifest)
+	assert.True(t, IsVerificationError(err))
+
+	data, err = ioutil.ReadFile(filepath.Join(dest, "bin", "new"))
+	require.NoError(t, err)
+	assert.Equal(t, "old binary", string(data))
+}
@@ -1,10 +1,9 @@
 package upgrade
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -12,6 +11,8 @@ import (
 	"strings"
 
 	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/download"
 )
 
 const (
@@ -50,17 +51,11 @@ func (h *hubClient) Info(flist string) (info flistInfo, err error) {
 	info.Repository = filepath.Dir(flist)
 
 	u.Path = filepath.Join("api", "flist", flist, "light")
-	response, err := http.Get(u.String())
+	response, err := download.Default().Get(context.Background(), u.String())
 	if err != nil {
-		return info, err
+		return info, errors.Wrap(err, "failed to get flist info")
 	}
 
-	defer response.Body.Close()
-	defer ioutil.ReadAll(response.Body)
-
-	if response.StatusCode != http.StatusOK {
-		return info, fmt.Errorf("failed to get flist info: %s", response.Status)
-	}
 	defer response.Body.Close()
 
 	dec := json.NewDecoder(response.Body)
@@ -76,17 +71,12 @@ func (h *hubClient) List(repo string) ([]listFListInfo, error) {
 	}
 
 	u.Path = filepath.Join("api", "flist", repo)
-	response, err := http.Get(u.String())
+	response, err := download.Default().Get(context.Background(), u.String())
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "failed to get repository listing")
 	}
 
 	defer response.Body.Close()
-	defer ioutil.ReadAll(response.Body)
-
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get repository listing: %s", response.Status)
-	}
 
 	dec := json.NewDecoder(response.Body)
 
@@ -186,17 +176,12 @@ func (b *listFListInfo) Files() ([]fileInfo, error) {
 	}
 
 	u.Path = filepath.Join("api", "flist", flist)
-	response, err := http.Get(u.String())
+	response, err := download.Default().Get(context.Background(), u.String())
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "failed to get flist info")
 	}
 
 	defer response.Body.Close()
-	defer ioutil.ReadAll(response.Body)
-
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get flist info: %s", response.Status)
-	}
 
 	dec := json.NewDecoder(response.Body)
 
@@ -0,0 +1,258 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/critical"
+	"github.com/threefoldtech/zos/pkg/zinit"
+	"golang.org/x/sys/unix"
+)
+
+const mb = 1024 * 1024
+
+// Preflight configures the checks run before an upgrade. The upgrade is
+// deferred while a module it restarts runs a critical operation or is
+// failing, up to MaxDefer. It fails if there is not enough disk space
+// after MaxDefer
+type Preflight struct {
+	// Critical is the directory of the critical operations of the modules
+	Critical string
+	// MaxDefer is the max time an upgrade waits for the checks to pass
+	MaxDefer time.Duration
+	// Interval is the time between two runs of the checks
+	Interval time.Duration
+	// MinFree is the free space kept on the root filesystem after the
+	// files of the flist are installed
+	MinFree uint64
+}
+
+// DefaultPreflight is the pre-flight configuration of the node
+var DefaultPreflight = Preflight{
+	Critical: critical.DefaultRoot,
+	MaxDefer: 2 * time.Hour,
+	Interval: time.Minute,
+	MinFree:  50 * mb,
+}
+
+// the names of the pre-flight checks
+const (
+	checkDisk       = "disk"
+	checkModules    = "modules"
+	checkOperations = "operations"
+)
+
+var _ pkg.UpgradePlanner = (*Upgrader)(nil)
+
+// Plan implements pkg.UpgradePlanner
+func (u *Upgrader) Plan() (pkg.UpgradePlan, error) {
+	u.planMu.Lock()
+	defer u.planMu.Unlock()
+
+	if len(u.plan.State) == 0 {
+		return pkg.UpgradePlan{State: pkg.UpgradeIdle}, nil
+	}
+
+	return u.plan, nil
+}
+
+func (u *Upgrader) setPlan(update func(plan *pkg.UpgradePlan)) {
+	u.planMu.Lock()
+	defer u.planMu.Unlock()
+
+	update(&u.plan)
+}
+
+// done marks the end of the upgrade of the plan
+func (u *Upgrader) done(err error) {
+	u.setPlan(func(plan *pkg.UpgradePlan) {
+		*plan = pkg.UpgradePlan{State: pkg.UpgradeIdle}
+		if err != nil && err != ErrRestartNeeded {
+			plan.Error = err.Error()
+		}
+	})
+}
+
+// services returns the zinit services of the flist mounted at root
+func services(root string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(root, "etc", "zinit", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".yaml"))
+	}
+
+	return names, nil
+}
+
+// required returns the size of the files of the flist mounted at root, the
+// files are not read so nothing is downloaded
+func required(root string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+func (u *Upgrader) checkDisk(root string) pkg.PreflightCheck {
+	check := pkg.PreflightCheck{Name: checkDisk}
+
+	size, err := required(root)
+	if err != nil {
+		check.Message = fmt.Sprintf("failed to list flist files: %s", err)
+		return check
+	}
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs("/", &stat); err != nil {
+		check.Message = fmt.Sprintf("failed to get free space: %s", err)
+		return check
+	}
+
+	free := stat.Bavail * uint64(stat.Bsize)
+	needed := size + u.Preflight.MinFree
+	check.OK = free >= needed
+	check.Message = fmt.Sprintf("%d MB free, %d MB needed", free/mb, needed/mb)
+	return check
+}
+
+func (u *Upgrader) checkModules(modules []string) pkg.PreflightCheck {
+	check := pkg.PreflightCheck{Name: checkModules, OK: true}
+	if u.Zinit == nil {
+		return check
+	}
+
+	states, err := u.Zinit.List()
+	if err != nil {
+		check.OK = false
+		check.Message = fmt.Sprintf("failed to list services: %s", err)
+		return check
+	}
+
+	var failing []string
+	for _, name := range modules {
+		state, ok := states[name]
+		if !ok {
+			// new service
+			continue
+		}
+
+		if state.Is(zinit.ServiceStateError) || state.Is(zinit.ServiceStateFailure) || state.Is(zinit.ServiceStateBlocked) {
+			failing = append(failing, fmt.Sprintf("%s (%s)", name, state.String()))
+		}
+	}
+
+	if len(failing) > 0 {
+		check.OK = false
+		check.Message = fmt.Sprintf("failing modules: %s", strings.Join(failing, ", "))
+	}
+
+	return check
+}
+
+func (u *Upgrader) checkOperations(modules []string) (pkg.PreflightCheck, []pkg.CriticalOperation) {
+	check := pkg.PreflightCheck{Name: checkOperations, OK: true}
+
+	ops, err := critical.List(u.Preflight.Critical)
+	if err != nil {
+		check.OK = false
+		check.Message = fmt.Sprintf("failed to list critical operations: %s", err)
+		return check, nil
+	}
+
+	var waiting []pkg.CriticalOperation
+	for _, op := range ops {
+		if isIn(op.Module, modules) {
+			waiting = append(waiting, op)
+		}
+	}
+
+	if len(waiting) > 0 {
+		check.OK = false
+		check.Message = fmt.Sprintf("%d critical operations running", len(waiting))
+	}
+
+	return check, waiting
+}
+
+// preflight blocks until the pre-flight checks of the upgrade of the flist
+// mounted at root pass. modules are the services restarted by the upgrade
+func (u *Upgrader) preflight(ctx context.Context, flist, version, root string, modules []string) error {
+	if u.Preflight.Interval == 0 {
+		// not configured
+		u.Preflight = DefaultPreflight
+	}
+
+	sort.Strings(modules)
+	now := time.Now()
+	deadline := now.Add(u.Preflight.MaxDefer)
+	u.setPlan(func(plan *pkg.UpgradePlan) {
+		*plan = pkg.UpgradePlan{
+			State:    pkg.UpgradeWaiting,
+			FList:    flist,
+			Version:  version,
+			Modules:  modules,
+			Since:    now,
+			Deadline: deadline,
+		}
+	})
+
+	for {
+		disk := u.checkDisk(root)
+		health := u.checkModules(modules)
+		operations, waiting := u.checkOperations(modules)
+		checks := []pkg.PreflightCheck{disk, health, operations}
+
+		expired := time.Now().After(deadline)
+		ready := disk.OK && ((health.OK && operations.OK) || expired)
+
+		u.setPlan(func(plan *pkg.UpgradePlan) {
+			plan.Checks = checks
+			plan.Waiting = waiting
+			if ready {
+				plan.State = pkg.UpgradeApplying
+			}
+		})
+
+		if ready {
+			if !health.OK || !operations.OK {
+				log.Warn().Str("flist", flist).Msg("upgrade deferred for too long, applying it anyway")
+			}
+			return nil
+		}
+
+		if expired {
+			return fmt.Errorf("pre-flight checks failed: %s", disk.Message)
+		}
+
+		for _, check := range checks {
+			if !check.OK {
+				log.Info().Str("flist", flist).Str("check", check.Name).Str("reason", check.Message).Msg("upgrade deferred")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.Preflight.Interval):
+		}
+	}
+}
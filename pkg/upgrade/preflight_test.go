@@ -0,0 +1,109 @@
+package upgrade
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/critical"
+)
+
+func TestPreflightOperations(t *testing.T) {
+	root, err := ioutil.TempDir("", "preflight")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	flist, err := ioutil.TempDir("", "flist")
+	require.NoError(t, err)
+	defer os.RemoveAll(flist)
+	writeFile(t, flist, "/etc/zinit/provisiond.yaml", "exec: provisiond")
+
+	u := Upgrader{
+		Preflight: Preflight{
+			Critical: root,
+			MaxDefer: time.Minute,
+			Interval: 10 * time.Millisecond,
+		},
+	}
+
+	plan, err := u.Plan()
+	require.NoError(t, err)
+	assert.Equal(t, pkg.UpgradeIdle, plan.State)
+
+	// an operation of a module not restarted by the upgrade doesn't wait
+	critical.New(root, "vmd").Begin("migrate")
+
+	done := critical.New(root, "provisiond").Begin("provision 1-1")
+	result := make(chan error)
+	go func() {
+		result <- u.preflight(context.Background(), "tf-zos-bins/provisiond.flist", "", flist, []string{"provisiond"})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	plan, err = u.Plan()
+	require.NoError(t, err)
+	assert.Equal(t, pkg.UpgradeWaiting, plan.State)
+	assert.Equal(t, []string{"provisiond"}, plan.Modules)
+	require.Len(t, plan.Waiting, 1)
+	assert.Equal(t, "provision 1-1", plan.Waiting[0].Operation)
+
+	done()
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("upgrade still deferred")
+	}
+
+	plan, err = u.Plan()
+	require.NoError(t, err)
+	assert.Equal(t, pkg.UpgradeApplying, plan.State)
+	assert.Empty(t, plan.Waiting)
+
+	u.done(nil)
+	plan, err = u.Plan()
+	require.NoError(t, err)
+	assert.Equal(t, pkg.UpgradeIdle, plan.State)
+}
+
+func TestPreflightDeadline(t *testing.T) {
+	root, err := ioutil.TempDir("", "preflight")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	flist, err := ioutil.TempDir("", "flist")
+	require.NoError(t, err)
+	defer os.RemoveAll(flist)
+
+	u := Upgrader{
+		Preflight: Preflight{
+			Critical: root,
+			MaxDefer: 50 * time.Millisecond,
+			Interval: 10 * time.Millisecond,
+		},
+	}
+
+	// a critical operation never finishing doesn't block the upgrade forever
+	critical.New(root, "provisiond").Begin("provision 1-1")
+	assert.NoError(t, u.preflight(context.Background(), "zos.flist", "1.0.0", flist, []string{"provisiond"}))
+
+	// not enough disk space
+	u.Preflight.MinFree = 1 << 62
+	err = u.preflight(context.Background(), "zos.flist", "1.0.0", flist, []string{"provisiond"})
+	assert.Error(t, err)
+
+	plan, err := u.Plan()
+	require.NoError(t, err)
+	require.Len(t, plan.Checks, 3)
+	assert.False(t, plan.Checks[0].OK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	u.Preflight.MaxDefer = time.Minute
+	assert.Equal(t, context.Canceled, u.preflight(ctx, "zos.flist", "1.0.0", flist, nil))
+}
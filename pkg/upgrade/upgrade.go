@@ -1,16 +1,19 @@
 package upgrade
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/pkg/errors"
 
+	"github.com/threefoldtech/zos/pkg/audit"
 	"github.com/threefoldtech/zos/pkg/zinit"
 
 	"github.com/rs/zerolog/log"
@@ -34,20 +37,33 @@ type Upgrader struct {
 	FLister      pkg.Flister
 	Zinit        *zinit.Client
 	NoSelfUpdate bool
-	hub          hubClient
+	// AllowUnsigned installs flists that fail the verification against
+	// the release key, it's only meant for development nodes
+	AllowUnsigned bool
+	// Audit records the verification failures as security events
+	Audit *audit.Logger
+	// Preflight configures the checks run before an upgrade, the
+	// DefaultPreflight is used if it's not set
+	Preflight Preflight
+	hub       hubClient
+
+	planMu sync.Mutex
+	plan   pkg.UpgradePlan
 }
 
 // Upgrade is the method that does a full upgrade flow
 // first check if a new version is available
 // if yes, applies the upgrade
 // on a successfully update, upgrade WILL NOT RETURN
-// instead the upgraded daemon will be completely stopped
-func (u *Upgrader) Upgrade(from, to FListEvent) error {
-	return u.applyUpgrade(from, to)
+// instead the upgraded daemon will be completely stopped.
+// The upgrade waits for its pre-flight checks to pass, it returns early if
+// ctx is canceled
+func (u *Upgrader) Upgrade(ctx context.Context, from, to FListEvent) error {
+	return u.applyUpgrade(ctx, from, to)
 }
 
 // InstallBinary from a single flist.
-func (u *Upgrader) InstallBinary(flist RepoFList) error {
+func (u *Upgrader) InstallBinary(ctx context.Context, flist RepoFList) (err error) {
 	log.Info().Str("flist", flist.Fqdn()).Msg("start applying upgrade")
 
 	flistRoot, err := u.FLister.Mount(u.hub.MountURL(flist.Fqdn()), u.hub.StorageURL(), pkg.ReadOnlyMountOptions)
@@ -61,30 +77,32 @@ func (u *Upgrader) InstallBinary(flist RepoFList) error {
 		}
 	}()
 
-	if err := copyRecursive(flistRoot, "/"); err != nil {
-		return errors.Wrapf(err, "failed to install flist: %s", flist.Fqdn())
+	manifest, err := u.verify(flist.Fqdn(), flistRoot, newRelease(flist.Absolute(), nil))
+	if err != nil {
+		return err
 	}
 
-	p := filepath.Join(flistRoot, "etc", "zinit")
-	log.Debug().Str("path", p).Msg("checking for zinit unit files")
-	files, err := ioutil.ReadDir(p)
-	if os.IsNotExist(err) {
-		log.Debug().Err(err).Msg("/etc/zinit not found on flist")
-		return nil
-	} else if err != nil {
+	log.Debug().Str("flist", flist.Fqdn()).Msg("checking for zinit unit files")
+	services, err := services(flistRoot)
+	if err != nil {
 		return errors.Wrap(err, "failed to list package services")
 	}
 
-	var services []string
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".yaml") {
-			continue
-		}
+	if err := u.preflight(ctx, flist.Fqdn(), "", flistRoot, services); err != nil {
+		return err
+	}
+	defer func() { u.done(err) }()
 
-		service := strings.TrimSuffix(file.Name(), ".yaml")
-		services = append(services, service)
+	if err := u.installFiles(flist.Fqdn(), flistRoot, manifest); err != nil {
+		return errors.Wrapf(err, "failed to install flist: %s", flist.Fqdn())
 	}
 
+	if err := u.verifyInstalled(flist.Fqdn(), manifest); err != nil {
+		return err
+	}
+
+	u.installed(manifest)
+
 	return u.ensureRestarted(services...)
 }
 
@@ -117,7 +135,7 @@ func (u *Upgrader) UninstallBinary(flist RepoFList) error {
 	return u.uninstall(flist.listFListInfo)
 }
 
-func (u Upgrader) stopMultiple(timeout time.Duration, service ...string) error {
+func (u *Upgrader) stopMultiple(timeout time.Duration, service ...string) error {
 	services := make(map[string]struct{})
 	for _, name := range service {
 		log.Info().Str("service", name).Msg("stopping service")
@@ -183,7 +201,7 @@ func (u Upgrader) stopMultiple(timeout time.Duration, service ...string) error {
 // it will copy the new binary and ask for a restart.
 // next time this method is called, it will match the flist
 // revision, and hence will continue updating all the other daemons
-func (u *Upgrader) upgradeSelf(root string) error {
+func (u *Upgrader) upgradeSelf(root string, manifest *Manifest) error {
 	if u.NoSelfUpdate {
 		log.Debug().Msg("skipping self upgrade")
 		return nil
@@ -200,6 +218,13 @@ func (u *Upgrader) upgradeSelf(root string) error {
 		return nil
 	}
 
+	// the new binary is executed to get its revision
+	if manifest != nil {
+		if err := manifest.VerifyFile(root, currentBinPath()); err != nil {
+			return err
+		}
+	}
+
 	// the timeout here is set to 1 min because
 	// this most probably will trigger a download
 	// of the binary over 0-fs, hence we need to
@@ -293,7 +318,7 @@ func (u *Upgrader) uninstall(flist listFListInfo) error {
 	return nil
 }
 
-func (u *Upgrader) applyUpgrade(from, to FListEvent) error {
+func (u *Upgrader) applyUpgrade(ctx context.Context, from, to FListEvent) (err error) {
 	log.Info().Str("flist", to.Fqdn()).Str("version", to.TryVersion().String()).Msg("start applying upgrade")
 
 	flistRoot, err := u.FLister.Mount(u.hub.MountURL(to.Fqdn()), u.hub.StorageURL(), pkg.ReadOnlyMountOptions)
@@ -307,39 +332,75 @@ func (u *Upgrader) applyUpgrade(from, to FListEvent) error {
 		}
 	}()
 
-	if err := u.upgradeSelf(flistRoot); err != nil {
-		return err
+	// the release can't be older than the one the node booted from
+	var installed *semver.Version
+	if version, err := from.Version(); err == nil {
+		installed = &version
 	}
 
-	if err := u.uninstall(from.listFListInfo); err != nil {
-		log.Error().Err(err).Msg("failed to unistall current flist. Upgraded anyway")
+	expected := newRelease(to.Absolute(), installed)
+	return u.apply(ctx, to.Fqdn(), to.TryVersion().String(), flistRoot, expected, func() error {
+		return u.uninstall(from.listFListInfo)
+	})
+}
+
+// InstallRelease installs the release extracted at root, as read from an
+// offline bundle. The files of the current release are replaced, they
+// can't be uninstalled first since the current flist can't be listed
+// without the hub
+func (u *Upgrader) InstallRelease(ctx context.Context, name, root string) error {
+	return u.apply(ctx, name, "", root, flistRelease{name: OSRelease}, nil)
+}
+
+// apply installs the release at root and restarts its services, uninstall
+// removes the current release once the new one is verified
+func (u *Upgrader) apply(ctx context.Context, flist, version, root string, expected flistRelease, uninstall func() error) (err error) {
+	// the flist is verified before anything is executed from it,
+	// including the new upgrade daemon to read its revision
+	manifest, err := u.verify(flist, root, expected)
+	if err != nil {
+		return err
 	}
 
-	log.Info().Msg("clean up complete, copying new files")
 	// once the flist is mounted we can inspect
 	// it for all zinit config files.
-	files, err := ioutil.ReadDir(filepath.Join(flistRoot, "etc", "zinit"))
-	if err != nil {
-		return errors.Wrap(err, "invalid flist. no zinit services")
+	names, err := services(root)
+	if err != nil || len(names) == 0 {
+		return fmt.Errorf("invalid flist. no zinit services")
 	}
 
-	var names []string
-	for _, service := range files {
-		name := service.Name()
-		if service.IsDir() || !strings.HasSuffix(name, ".yaml") {
-			continue
-		}
+	log.Debug().Strs("services", names).Msg("new services")
 
-		names = append(names, strings.TrimSuffix(name, ".yaml"))
+	// all the modules are restarted, the upgrade waits for all of them
+	if err := u.preflight(ctx, flist, version, root, names); err != nil {
+		return err
 	}
+	defer func() { u.done(err) }()
 
-	log.Debug().Strs("services", names).Msg("new services")
+	if err := u.upgradeSelf(root, manifest); err != nil {
+		return err
+	}
+
+	if uninstall != nil {
+		if err := uninstall(); err != nil {
+			log.Error().Err(err).Msg("failed to unistall current flist. Upgraded anyway")
+		}
+	}
 
-	if err := copyRecursive(flistRoot, "/", flistIdentityPath); err != nil {
+	log.Info().Msg("clean up complete, copying new files")
+
+	if err := u.installFiles(flist, root, manifest, flistIdentityPath); err != nil {
 		return err
 	}
 
 	log.Debug().Msg("copying files complete")
+
+	if err := u.verifyInstalled(flist, manifest, flistIdentityPath); err != nil {
+		return err
+	}
+
+	u.installed(manifest)
+
 	// start all services in the flist
 	for _, name := range names {
 		if err := u.Zinit.Monitor(name); err != nil {
@@ -357,6 +418,121 @@ func (u *Upgrader) applyUpgrade(from, to FListEvent) error {
 	return nil
 }
 
+// flistRelease is the release an flist is expected to hold
+type flistRelease struct {
+	name string
+	// version is the version in the name of the flist, nil if it has none
+	version *semver.Version
+	// installed is the version the release can't be older than, nil if
+	// it's unknown
+	installed *semver.Version
+}
+
+// newRelease returns the release expected in flist, installed is the
+// version of the release the node runs if it's known
+func newRelease(flist string, installed *semver.Version) flistRelease {
+	r := flistRelease{name: ReleaseName(flist), installed: installed}
+
+	info := listFListInfo{Name: filepath.Base(flist)}
+	if version, err := info.Version(); err == nil {
+		r.version = &version
+	}
+
+	return r
+}
+
+// verify checks the flist mounted at root is signed by the release key,
+// that it holds the expected release, not older than the installed one,
+// and its files match the signed manifest. The returned manifest is nil
+// if an unverified flist is allowed
+func (u *Upgrader) verify(flist, root string, expected flistRelease) (*Manifest, error) {
+	// the release installed last by this node is the oldest accepted
+	if recorded := installedVersion(expected.name); recorded != nil {
+		if expected.installed == nil || recorded.GT(*expected.installed) {
+			expected.installed = recorded
+		}
+	}
+
+	manifest, err := LoadManifest(root, ReleaseKey)
+	if err == nil {
+		err = manifest.VerifyRelease(expected.name, expected.version, expected.installed)
+	}
+	if err == nil {
+		err = manifest.VerifyTree(root)
+	}
+
+	if err == nil {
+		log.Info().Str("flist", flist).Msg("flist signature verified")
+		return &manifest, nil
+	}
+
+	u.securityEvent("VerifyFList", flist, err)
+	if u.AllowUnsigned && IsVerificationError(err) {
+		log.Warn().Err(err).Str("flist", flist).Msg("installing unverified flist")
+		return nil, nil
+	}
+
+	return nil, errors.Wrapf(err, "refusing to install flist '%s'", flist)
+}
+
+// installed records the version of the release installed from manifest
+func (u *Upgrader) installed(manifest *Manifest) {
+	if manifest == nil {
+		return
+	}
+
+	if err := recordInstalled(*manifest); err != nil {
+		log.Error().Err(err).Str("release", manifest.Name).Msg("failed to record installed release")
+	}
+}
+
+// verifyInstalled checks the installed files still match the manifest
+// before the services are started, except skip
+func (u *Upgrader) verifyInstalled(flist string, manifest *Manifest, skip ...string) error {
+	if manifest == nil {
+		return nil
+	}
+
+	if err := manifest.VerifyInstalled("/", skip...); err != nil {
+		u.securityEvent("VerifyInstalled", flist, err)
+		return errors.Wrapf(err, "refusing to start services of flist '%s'", flist)
+	}
+
+	return nil
+}
+
+// installFiles installs the files of the flist mounted at root, except
+// skip. A verified flist is installed against its manifest, using the
+// patches of the flist when possible
+func (u *Upgrader) installFiles(flist, root string, manifest *Manifest, skip ...string) error {
+	if manifest == nil {
+		return copyRecursive(root, "/", skip...)
+	}
+
+	stats, err := install(root, "/", manifest, skip...)
+	if IsVerificationError(err) {
+		u.securityEvent("InstallFList", flist, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("flist", flist).
+		Int("unchanged", stats.Unchanged).
+		Int("patched", stats.Patched).
+		Int("copied", stats.Copied).
+		Int64("downloaded", stats.Downloaded).
+		Msg("flist installed")
+
+	return nil
+}
+
+func (u *Upgrader) securityEvent(op, flist string, err error) {
+	log.Error().Err(err).Str("flist", flist).Str("event", "security").Msg("flist verification failed")
+	u.Audit.Record(op, "", flist, flist, err)
+}
+
 func copyRecursive(source string, destination string, skip ...string) error {
 	return filepath.Walk(source, func(path string, info os.FileInfo, _ error) error {
 		rel, err := filepath.Rel(source, path)
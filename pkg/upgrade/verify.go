@@ -0,0 +1,349 @@
+package upgrade
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/crypto"
+)
+
+const (
+	// ManifestPath is the path of the release manifest in an flist
+	ManifestPath = "/etc/zos/release.json"
+	// SignaturePath is the path of the hex encoded signature of the
+	// manifest by the release key in an flist
+	SignaturePath = "/etc/zos/release.sig"
+	// DeltaDir is the directory of the binary patches in an flist
+	DeltaDir = "/etc/zos/delta"
+
+	// OSRelease is the name of the release of the 0-OS flist
+	OSRelease = "zos"
+)
+
+// InstalledDir is the directory where the manifests of the installed
+// releases are kept, the releases installed next can't be older. The
+// upgrades run in identityd, it's in its cache
+var InstalledDir = "/var/cache/modules/identityd/releases"
+
+// ReleaseKey is the hex encoded ed25519 public key the flists are signed
+// with. It's set at build time with
+// -ldflags "-X github.com/threefoldtech/zos/pkg/upgrade.ReleaseKey=<key>"
+var ReleaseKey = ""
+
+// ErrVerification is the cause of the errors returned when an flist is not
+// signed by the release key or one of its files doesn't match the manifest
+var ErrVerification = fmt.Errorf("flist verification failed")
+
+// Manifest lists the files of an flist with their sha256, it's signed
+// by the release key. The name and the version of the release are signed
+// with the files so an older release can't be installed in place of a
+// newer one
+type Manifest struct {
+	// Name is the name of the release, see ReleaseName
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Files are the sha256 of the regular files of the flist
+	Files map[string]string `json:"files"`
+	// Links are the targets of the symlinks of the flist
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// ReleaseName returns the name of the release in flist, the base name of
+// the flist up to its first ':' so tf-zos/zos:production:latest.flist is
+// a release of zos
+func ReleaseName(flist string) string {
+	name := strings.TrimSuffix(filepath.Base(flist), ".flist")
+	return strings.SplitN(name, ":", 2)[0]
+}
+
+func verificationError(format string, args ...interface{}) error {
+	return errors.Wrapf(ErrVerification, format, args...)
+}
+
+// IsVerificationError checks if err is caused by a failed verification
+func IsVerificationError(err error) bool {
+	return errors.Cause(err) == ErrVerification
+}
+
+// LoadManifest reads the manifest of the flist mounted at root and checks
+// its signature against the hex encoded public key
+func LoadManifest(root, key string) (Manifest, error) {
+	var manifest Manifest
+	if len(key) == 0 {
+		return manifest, verificationError("no release key in this build")
+	}
+
+	pk, err := crypto.KeyFromHex(key)
+	if err != nil {
+		return manifest, errors.Wrap(err, "invalid release key")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, ManifestPath))
+	if os.IsNotExist(err) {
+		return manifest, verificationError("flist is not signed")
+	} else if err != nil {
+		return manifest, errors.Wrap(err, "failed to read release manifest")
+	}
+
+	sig, err := ioutil.ReadFile(filepath.Join(root, SignaturePath))
+	if os.IsNotExist(err) {
+		return manifest, verificationError("flist is not signed")
+	} else if err != nil {
+		return manifest, errors.Wrap(err, "failed to read release signature")
+	}
+
+	sig, err = hex.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return manifest, verificationError("invalid release signature")
+	}
+
+	if err := crypto.Verify(pk, data, sig); err != nil {
+		return manifest, verificationError("release manifest: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, verificationError("invalid release manifest: %s", err)
+	}
+
+	return manifest, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// release tells if name is one of the release files of an flist, like the
+// manifest and the deltas, those files are not installed
+func release(name string) bool {
+	return name == ManifestPath || name == SignaturePath || strings.HasPrefix(name, DeltaDir+"/")
+}
+
+// VerifyRelease checks the manifest is the one of the release name at
+// version, and that it's not older than the installed version. version
+// and installed are not checked if they are nil
+func (m *Manifest) VerifyRelease(name string, version, installed *semver.Version) error {
+	if m.Name != name {
+		return verificationError("manifest of release '%s' in an flist of release '%s'", m.Name, name)
+	}
+
+	release, err := semver.ParseTolerant(m.Version)
+	if err != nil {
+		return verificationError("invalid release version '%s'", m.Version)
+	}
+
+	if version != nil && !release.Equals(*version) {
+		return verificationError("manifest of version %s in an flist of version %s", release, version)
+	}
+
+	if installed != nil && release.LT(*installed) {
+		return verificationError("release %s is older than the installed release %s", release, installed)
+	}
+
+	return nil
+}
+
+// VerifyTree checks that every entry under root is in the manifest: the
+// regular files in the files, with the files of the manifest missing, the
+// symlinks in the links with the same target, and the directories hold at
+// least one of them. The files are not hashed here since reading them
+// downloads them, their hash is checked when they are installed
+func (m *Manifest) VerifyTree(root string) error {
+	seen := make(map[string]struct{})
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Join("/", rel)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if name != "/" {
+				dirs = append(dirs, name)
+			}
+			return nil
+		case mode&os.ModeSymlink != 0:
+			expected, ok := m.Links[name]
+			if !ok {
+				return verificationError("symlink '%s' is not in the release manifest", name)
+			}
+
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			if target != expected {
+				return verificationError("symlink '%s' points to '%s' instead of '%s'", name, target, expected)
+			}
+		case mode.IsRegular():
+			if name == ManifestPath || name == SignaturePath {
+				// not covered by the manifest
+				return nil
+			}
+
+			if _, ok := m.Files[name]; !ok {
+				return verificationError("file '%s' is not in the release manifest", name)
+			}
+		default:
+			return verificationError("unexpected %s '%s' in the flist", mode.Type(), name)
+		}
+
+		seen[name] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range m.Files {
+		if _, ok := seen[name]; !ok {
+			return verificationError("file '%s' of the release manifest is missing", name)
+		}
+	}
+
+	for name := range m.Links {
+		if _, ok := seen[name]; !ok {
+			return verificationError("symlink '%s' of the release manifest is missing", name)
+		}
+	}
+
+	// the release files are in the directory of the manifest
+	seen[ManifestPath] = struct{}{}
+	for _, dir := range dirs {
+		if !holds(dir, seen) {
+			return verificationError("directory '%s' is not in the release manifest", dir)
+		}
+	}
+
+	return nil
+}
+
+// holds checks if one of the entries is under dir
+func holds(dir string, entries map[string]struct{}) bool {
+	for name := range entries {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyFile checks the file name of the flist mounted at root matches
+// the manifest
+func (m *Manifest) VerifyFile(root, name string) error {
+	expected, ok := m.Files[name]
+	if !ok {
+		return verificationError("file '%s' is not in the release manifest", name)
+	}
+
+	hash, err := hashFile(filepath.Join(root, name))
+	if err != nil {
+		return errors.Wrapf(err, "failed to hash '%s'", name)
+	}
+
+	if hash != expected {
+		return verificationError("file '%s' was tampered with", name)
+	}
+
+	return nil
+}
+
+// VerifyInstalled checks the files and the symlinks of the manifest
+// installed under root, except skip, still match the manifest
+func (m *Manifest) VerifyInstalled(root string, skip ...string) error {
+	for name, expected := range m.Files {
+		if release(name) || isIn(name, skip) {
+			continue
+		}
+
+		hash, err := hashFile(filepath.Join(root, name))
+		if err != nil {
+			return verificationError("failed to hash installed file '%s': %s", name, err)
+		}
+
+		if hash != expected {
+			return verificationError("installed file '%s' was tampered with", name)
+		}
+	}
+
+	for name, expected := range m.Links {
+		if isIn(name, skip) {
+			continue
+		}
+
+		if target, err := os.Readlink(filepath.Join(root, name)); err != nil || target != expected {
+			return verificationError("installed symlink '%s' was tampered with", name)
+		}
+	}
+
+	return nil
+}
+
+// recordInstalled keeps manifest as the manifest of the installed release
+func recordInstalled(manifest Manifest) error {
+	if err := os.MkdirAll(InstalledDir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(InstalledDir, manifest.Name+".json")
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// installedVersion returns the version of the installed release name, nil
+// if it's not known
+func installedVersion(name string) *semver.Version {
+	data, err := ioutil.ReadFile(filepath.Join(InstalledDir, name+".json"))
+	if err != nil {
+		return nil
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Warn().Err(err).Str("release", name).Msg("invalid record of installed release")
+		return nil
+	}
+
+	version, err := semver.ParseTolerant(manifest.Version)
+	if err != nil {
+		return nil
+	}
+
+	return &version
+}
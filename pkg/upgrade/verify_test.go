@@ -0,0 +1,193 @@
+package upgrade
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func writeFile(t *testing.T, root, name, content string) {
+	path := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0755))
+}
+
+// signedFList creates an flist tree with a manifest signed by sk
+func signedFList(t *testing.T, sk ed25519.PrivateKey, files map[string]string) string {
+	root, err := ioutil.TempDir("", "flist")
+	require.NoError(t, err)
+
+	manifest := Manifest{Name: OSRelease, Version: "1.2.0", Files: make(map[string]string)}
+	for name, content := range files {
+		writeFile(t, root, name, content)
+		manifest.Files[name], err = hashFile(filepath.Join(root, name))
+		require.NoError(t, err)
+	}
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	writeFile(t, root, ManifestPath, string(data))
+	writeFile(t, root, SignaturePath, hex.EncodeToString(ed25519.Sign(sk, data)))
+
+	return root
+}
+
+func TestVerifyFList(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key := hex.EncodeToString(pk)
+
+	root := signedFList(t, sk, map[string]string{
+		"/bin/contd":            "contd binary",
+		"/etc/zinit/contd.yaml": "exec: contd",
+	})
+	defer os.RemoveAll(root)
+
+	manifest, err := LoadManifest(root, key)
+	require.NoError(t, err)
+	assert.NoError(t, manifest.VerifyTree(root))
+
+	// other key
+	other, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, err = LoadManifest(root, hex.EncodeToString(other))
+	assert.True(t, IsVerificationError(err))
+
+	// no key in the build
+	_, err = LoadManifest(root, "")
+	assert.True(t, IsVerificationError(err))
+
+	assert.NoError(t, manifest.VerifyFile(root, "/bin/contd"))
+
+	// tampered binary
+	writeFile(t, root, "/bin/contd", "evil binary")
+	err = manifest.VerifyFile(root, "/bin/contd")
+	assert.True(t, IsVerificationError(err))
+	writeFile(t, root, "/bin/contd", "contd binary")
+
+	// file not in the manifest
+	writeFile(t, root, "/bin/extra", "extra binary")
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+	require.NoError(t, os.Remove(filepath.Join(root, "bin", "extra")))
+
+	// symlink not in the manifest
+	require.NoError(t, os.Symlink("contd", filepath.Join(root, "bin", "link")))
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+
+	manifest.Links = map[string]string{"/bin/link": "contd"}
+	assert.NoError(t, manifest.VerifyTree(root))
+
+	// retargeted symlink
+	require.NoError(t, os.Remove(filepath.Join(root, "bin", "link")))
+	require.NoError(t, os.Symlink("/bin/sh", filepath.Join(root, "bin", "link")))
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+
+	// missing symlink
+	require.NoError(t, os.Remove(filepath.Join(root, "bin", "link")))
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+	manifest.Links = nil
+
+	// directory not in the manifest
+	require.NoError(t, os.Mkdir(filepath.Join(root, "extra"), 0755))
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+	require.NoError(t, os.Remove(filepath.Join(root, "extra")))
+	assert.NoError(t, manifest.VerifyTree(root))
+
+	// missing file
+	require.NoError(t, os.Remove(filepath.Join(root, "bin", "contd")))
+	err = manifest.VerifyTree(root)
+	assert.True(t, IsVerificationError(err))
+
+	// tampered manifest
+	writeFile(t, root, ManifestPath, `{"files": {}}`)
+	_, err = LoadManifest(root, key)
+	assert.True(t, IsVerificationError(err))
+
+	// unsigned flist
+	require.NoError(t, os.Remove(filepath.Join(root, SignaturePath)))
+	_, err = LoadManifest(root, key)
+	assert.True(t, IsVerificationError(err))
+}
+
+func TestVerifyInstalled(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	root := signedFList(t, sk, map[string]string{
+		"/bin/contd":     "contd binary",
+		"/bin/identityd": "identityd binary",
+	})
+	defer os.RemoveAll(root)
+
+	manifest, err := LoadManifest(root, hex.EncodeToString(sk.Public().(ed25519.PublicKey)))
+	require.NoError(t, err)
+
+	assert.NoError(t, manifest.VerifyInstalled(root))
+
+	writeFile(t, root, "/bin/identityd", "old identityd")
+	assert.True(t, IsVerificationError(manifest.VerifyInstalled(root)))
+	assert.NoError(t, manifest.VerifyInstalled(root, "/bin/identityd"))
+
+	writeFile(t, root, "/bin/contd", "evil binary")
+	assert.True(t, IsVerificationError(manifest.VerifyInstalled(root, "/bin/identityd")))
+}
+
+func TestVerifyRelease(t *testing.T) {
+	manifest := Manifest{Name: OSRelease, Version: "v1.2.0"}
+
+	version := semver.MustParse("1.2.0")
+	older := semver.MustParse("1.1.0")
+	newer := semver.MustParse("1.3.0")
+
+	assert.NoError(t, manifest.VerifyRelease(OSRelease, nil, nil))
+	assert.NoError(t, manifest.VerifyRelease(OSRelease, &version, &older))
+	assert.NoError(t, manifest.VerifyRelease(OSRelease, &version, &version))
+
+	// the manifest of another release
+	assert.True(t, IsVerificationError(manifest.VerifyRelease("contd", nil, nil)))
+	// the manifest of another version
+	assert.True(t, IsVerificationError(manifest.VerifyRelease(OSRelease, &newer, nil)))
+	// downgrade
+	assert.True(t, IsVerificationError(manifest.VerifyRelease(OSRelease, &version, &newer)))
+
+	manifest.Version = ""
+	assert.True(t, IsVerificationError(manifest.VerifyRelease(OSRelease, nil, nil)))
+}
+
+func TestReleaseName(t *testing.T) {
+	assert.Equal(t, "zos", ReleaseName("tf-zos/zos:production:latest.flist"))
+	assert.Equal(t, "zos", ReleaseName("tf-autobuilder/zos:v2.1.0.flist"))
+	assert.Equal(t, "contd", ReleaseName("tf-zos-bins/contd.flist"))
+}
+
+func TestInstalledVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "installed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	original := InstalledDir
+	InstalledDir = filepath.Join(dir, "releases")
+	defer func() { InstalledDir = original }()
+
+	assert.Nil(t, installedVersion(OSRelease))
+
+	require.NoError(t, recordInstalled(Manifest{Name: OSRelease, Version: "1.2.0"}))
+	version := installedVersion(OSRelease)
+	require.NotNil(t, version)
+	assert.Equal(t, semver.MustParse("1.2.0"), *version)
+
+	assert.Nil(t, installedVersion("contd"))
+}
@@ -0,0 +1,27 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module identityd -version 0.0.1 -name channel -package stubs github.com/threefoldtech/zos/pkg+UpgradeChannels stubs/upgrade_channels_stub.go
+
+// UpgradeChannel is the release channel the node upgrades from
+type UpgradeChannel struct {
+	// Channel is production, testing or canary
+	Channel string `json:"channel"`
+	// Source tells why the node follows the channel: node if it's set on
+	// the node, rollout if the node is part of the staged rollout of its
+	// farm or farm for the default channel of the farm
+	Source string `json:"source"`
+	// FList is the flist watched for new versions
+	FList string `json:"flist"`
+}
+
+// UpgradeChannels selects the release channel of the node
+type UpgradeChannels interface {
+	// Channel returns the channel the node follows
+	Channel() (UpgradeChannel, error)
+	// SetChannel sets the channel of this node, it overrides the channel
+	// of the farm. An empty channel goes back to the channel of the farm.
+	// A node moved to a channel with an older release keeps its version
+	// until the channel catches up
+	SetChannel(channel string) error
+}
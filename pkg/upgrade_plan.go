@@ -0,0 +1,59 @@
+package pkg
+
+//go:generate mkdir -p stubs
+//go:generate zbusc -module identityd -version 0.0.1 -name planner -package stubs github.com/threefoldtech/zos/pkg+UpgradePlanner stubs/upgrade_planner_stub.go
+
+import "time"
+
+// the states of an upgrade plan
+const (
+	// UpgradeIdle is the state when no upgrade is pending
+	UpgradeIdle = "idle"
+	// UpgradeWaiting is the state of an upgrade waiting for its pre-flight
+	// checks to pass
+	UpgradeWaiting = "waiting"
+	// UpgradeApplying is the state of an upgrade being installed
+	UpgradeApplying = "applying"
+)
+
+// CriticalOperation is an operation of a module that must not be
+// interrupted by a restart of the module
+type CriticalOperation struct {
+	// Module is the service running the operation
+	Module    string    `json:"module"`
+	Operation string    `json:"operation"`
+	Since     time.Time `json:"since"`
+	PID       int       `json:"pid"`
+}
+
+// PreflightCheck is the result of a check run before an upgrade
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// UpgradePlan is the upgrade pending on the node
+type UpgradePlan struct {
+	State   string `json:"state"`
+	FList   string `json:"flist,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Modules are the services restarted by the upgrade
+	Modules []string `json:"modules,omitempty"`
+	// Since is the time the upgrade started waiting
+	Since  time.Time        `json:"since,omitempty"`
+	Checks []PreflightCheck `json:"checks,omitempty"`
+	// Waiting are the critical operations the upgrade waits for
+	Waiting []CriticalOperation `json:"waiting,omitempty"`
+	// Deadline is the time the upgrade stops waiting for the critical
+	// operations and the failing modules
+	Deadline time.Time `json:"deadline,omitempty"`
+	// Error is the error of the last upgrade if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// UpgradePlanner shows the pending upgrade of the node
+type UpgradePlanner interface {
+	// Plan returns the pending upgrade and why it's waiting
+	Plan() (UpgradePlan, error)
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrShuttingDown is returned by InFlight.Begin once the module
+	// started its shutdown sequence and no longer accepts new operations
+	ErrShuttingDown = fmt.Errorf("module is shutting down")
+)
+
+// InFlight keeps track of the operations currently executed by a module
+// so that on shutdown the module can stop accepting new operations and
+// wait for the running ones to finish before exiting.
+// The zero value is ready to use
+type InFlight struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool
+	running int
+}
+
+// Begin marks the start of an operation. The returned done function must
+// be called once the operation is finished. If the tracker is draining
+// ErrShuttingDown is returned and the operation must not be started
+func (f *InFlight) Begin() (done func(), err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrShuttingDown
+	}
+
+	f.running++
+	f.wg.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			f.running--
+			f.mu.Unlock()
+			f.wg.Done()
+		})
+	}, nil
+}
+
+// Running returns the number of operations currently in flight
+func (f *InFlight) Running() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.running
+}
+
+// Drain stops accepting new operations and blocks until all the in-flight
+// operations are done or the context is canceled, in that case the
+// context error is returned
+func (f *InFlight) Drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	ch := make(chan struct{})
+	go func() {
+		defer close(ch)
+		f.wg.Wait()
+	}()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInFlightDrain(t *testing.T) {
+	var f InFlight
+
+	done, err := f.Begin()
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.Running())
+
+	drained := make(chan error)
+	go func() {
+		drained <- f.Drain(context.Background())
+	}()
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned while an operation is still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = f.Begin()
+	assert.Equal(t, ErrShuttingDown, err)
+
+	done()
+	// calling done twice must not corrupt the counter
+	done()
+
+	require.NoError(t, <-drained)
+	assert.Equal(t, 0, f.Running())
+}
+
+func TestInFlightDrainTimeout(t *testing.T) {
+	var f InFlight
+
+	_, err := f.Begin()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, f.Drain(ctx))
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// NewRedisPool creates a pool of connections to the redis server at
+// address, a unix:// or tcp:// url as the one of the message broker
+func NewRedisPool(address string) (*redis.Pool, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var network, host string
+	switch u.Scheme {
+	case "unix":
+		network, host = "unix", u.Path
+	case "tcp", "redis":
+		network, host = "tcp", u.Host
+	default:
+		return nil, fmt.Errorf("unknown redis scheme '%s'", u.Scheme)
+	}
+
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial(network, host)
+		},
+		MaxIdle:     2,
+		IdleTimeout: time.Minute,
+	}, nil
+}
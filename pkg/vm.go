@@ -27,6 +27,42 @@ type VMDisk struct {
 	Path     string
 	ReadOnly bool
 	Root     bool
+	// IO limit of the disk
+	IO IOLimit
+}
+
+// CloudInitDatasource is the format of the cloud-init datasource given to a VM
+type CloudInitDatasource string
+
+const (
+	// CloudInitNoCloud is a disk labeled cidata with the meta-data,
+	// user-data and network-config files
+	CloudInitNoCloud CloudInitDatasource = "nocloud"
+	// CloudInitConfigDrive is an openstack config drive, labeled config-2
+	CloudInitConfigDrive CloudInitDatasource = "configdrive"
+)
+
+// CloudInit is the configuration cloud-init finds in the VM on boot
+type CloudInit struct {
+	// Datasource format, defaults to nocloud
+	Datasource CloudInitDatasource
+	// Hostname of the VM, defaults to the VM name
+	Hostname string
+	// UserData is given as is to the VM (#cloud-config document, script, ...)
+	UserData string
+	// SSHKeys are the public keys authorized on the default user
+	SSHKeys []string
+}
+
+// Valid checks the cloud-init configuration
+func (c *CloudInit) Valid() error {
+	switch c.Datasource {
+	case "", CloudInitNoCloud, CloudInitConfigDrive:
+	default:
+		return fmt.Errorf("unsupported cloud-init datasource '%s'", c.Datasource)
+	}
+
+	return nil
 }
 
 // VM config structure
@@ -48,6 +84,12 @@ type VM struct {
 	// Disks are a list of disks that are going to
 	// be auto allocated on the provided storage path
 	Disks []VMDisk
+	// CloudInit if set, a datasource disk is attached to the VM so
+	// standard cloud images configure themselves on boot
+	CloudInit *CloudInit
+	// ConsoleOwner is the ID of the user allowed to write to the serial
+	// console of the VM. The console is read only if empty
+	ConsoleOwner string
 }
 
 // Validate vm data
@@ -72,6 +114,12 @@ func (vm *VM) Validate() error {
 		return fmt.Errorf("invalid cpu must be between 1 and 32")
 	}
 
+	if vm.CloudInit != nil {
+		if err := vm.CloudInit.Valid(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -89,10 +137,52 @@ type VMInfo struct {
 	CPU int64
 }
 
+// VMConsoleOutput is a chunk of the serial console output of a VM
+type VMConsoleOutput struct {
+	// Data read from the console
+	Data []byte
+	// Offset to read the next chunk from
+	Offset int64
+}
+
+// VMMigration describes how a VM migrated from another node of the farm
+// is received on this node
+type VMMigration struct {
+	// Listen is the address (on the management network) the VM is received on
+	Listen string
+	// Disks maps the file names of the writable disks of the VM to the path
+	// of the same disks on this node, the disks must be on storage reachable
+	// from both nodes (shared pool or NBD). Read only disks that are not
+	// mapped are copied from the sending node
+	Disks map[string]string
+}
+
 // VMModule defines the virtual machine module interface
 type VMModule interface {
 	Run(vm VM) error
 	Inspect(name string) (VMInfo, error)
 	Delete(name string) error
-	Exists(id string) bool
+	Exists(id string) (bool, error)
+
+	// ConsoleLog reads the serial console output of the VM starting at offset.
+	// Callers follow the console by reading again from the returned offset
+	ConsoleLog(name string, offset int64) (VMConsoleOutput, error)
+	// ConsoleWrite sends input to the serial console of the VM. The input must be
+	// signed by the console owner, the signed message is the VM name, seq as a
+	// big endian uint64 then the input. seq must be greater than the one of the
+	// previous write so a write can't be replayed
+	ConsoleWrite(name string, seq uint64, input []byte, signature []byte) error
+
+	// MigrationAccept prepares this node to receive the VM name from another
+	// node of the farm. It returns the one time ticket of the migration: the
+	// token the sending node must present and the fingerprint of the TLS
+	// certificate the VM is received with. The network of the VM must
+	// already be set up on this node
+	MigrationAccept(name string, migration VMMigration) (string, error)
+	// Migrate live migrates the VM name to the node listening on target,
+	// over TLS with the certificate of the ticket. The VM keeps running
+	// while its memory is copied, it's only paused for the final copy of the
+	// pages written meanwhile. The VM is deleted from this node once it's
+	// resumed on the target
+	Migrate(name string, target string, ticket string) error
 }
@@ -0,0 +1,273 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	cloudInitDriveID = "cloud-init"
+	// cloudInitMinSize is the minimum size of the datasource disk, vfat
+	// needs some room for its own structures
+	cloudInitMinSize = 1024 * 1024 // 1MiB
+)
+
+// cloudInitMAC derives a stable, locally administered, mac address from the
+// vm name. cloud-init needs to know the mac address to match the interface
+// the network configuration applies to
+func cloudInitMAC(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac.String()
+}
+
+// cloudInitFiles returns the files of the datasource disk of the vm and
+// the label of the disk
+func cloudInitFiles(vm *pkg.VM) (map[string][]byte, string, error) {
+	cfg := vm.CloudInit
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname = vm.Name
+	}
+
+	userData := []byte(cfg.UserData)
+	if len(userData) == 0 {
+		userData = []byte("#cloud-config\n")
+	}
+
+	switch cfg.Datasource {
+	case "", pkg.CloudInitNoCloud:
+		// yaml is a superset of json, so all documents are written as json
+		meta, err := json.Marshal(map[string]interface{}{
+			"instance-id":    vm.Name,
+			"local-hostname": hostname,
+			"public-keys":    cfg.SSHKeys,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		network, err := json.Marshal(noCloudNetwork(vm.Network))
+		if err != nil {
+			return nil, "", err
+		}
+
+		return map[string][]byte{
+			"meta-data":      meta,
+			"user-data":      userData,
+			"network-config": network,
+		}, "CIDATA", nil
+	case pkg.CloudInitConfigDrive:
+		keys := make(map[string]string)
+		for i, key := range cfg.SSHKeys {
+			keys[fmt.Sprintf("key-%d", i)] = key
+		}
+
+		meta, err := json.Marshal(map[string]interface{}{
+			"uuid":        vm.Name,
+			"name":        vm.Name,
+			"hostname":    hostname,
+			"public_keys": keys,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		network, err := json.Marshal(configDriveNetwork(vm.Network))
+		if err != nil {
+			return nil, "", err
+		}
+
+		return map[string][]byte{
+			"openstack/latest/meta_data.json":    meta,
+			"openstack/latest/user_data":         userData,
+			"openstack/latest/network_data.json": network,
+		}, "CONFIG-2", nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported cloud-init datasource '%s'", cfg.Datasource)
+}
+
+func nameservers(network pkg.VMNetworkInfo) []string {
+	servers := make([]string, 0, len(network.Nameservers))
+	for _, ns := range network.Nameservers {
+		servers = append(servers, ns.String())
+	}
+	return servers
+}
+
+// noCloudNetwork is the network configuration (version 2) of the vm
+func noCloudNetwork(network pkg.VMNetworkInfo) map[string]interface{} {
+	ethernet := map[string]interface{}{
+		"match": map[string]string{
+			"macaddress": network.MAC,
+		},
+		"set-name":  "eth0",
+		"addresses": []string{network.AddressCIDR.String()},
+		"nameservers": map[string]interface{}{
+			"addresses": nameservers(network),
+		},
+	}
+
+	if network.GatewayIP != nil {
+		gateway := "gateway4"
+		if network.GatewayIP.To4() == nil {
+			gateway = "gateway6"
+		}
+		ethernet[gateway] = network.GatewayIP.String()
+	}
+
+	return map[string]interface{}{
+		"version": 2,
+		"ethernets": map[string]interface{}{
+			"eth0": ethernet,
+		},
+	}
+}
+
+// configDriveNetwork is the openstack network_data.json of the vm
+func configDriveNetwork(network pkg.VMNetworkInfo) map[string]interface{} {
+	kind, anyAddr := "ipv4", "0.0.0.0"
+	if network.AddressCIDR.IP.To4() == nil {
+		kind, anyAddr = "ipv6", "::"
+	}
+
+	config := map[string]interface{}{
+		"id":         "network0",
+		"link":       "eth0",
+		"type":       kind,
+		"ip_address": network.AddressCIDR.IP.String(),
+		"netmask":    net.IP(network.AddressCIDR.Mask).String(),
+	}
+
+	if network.GatewayIP != nil {
+		config["routes"] = []map[string]string{
+			{
+				"network": anyAddr,
+				"netmask": anyAddr,
+				"gateway": network.GatewayIP.String(),
+			},
+		}
+	}
+
+	services := make([]map[string]string, 0, len(network.Nameservers))
+	for _, ns := range nameservers(network) {
+		services = append(services, map[string]string{"type": "dns", "address": ns})
+	}
+
+	return map[string]interface{}{
+		"links": []map[string]string{
+			{
+				"id":                   "eth0",
+				"type":                 "phy",
+				"ethernet_mac_address": network.MAC,
+			},
+		},
+		"networks": []map[string]interface{}{config},
+		"services": services,
+	}
+}
+
+func (m *vmModuleImpl) cloudInitImage(name string) string {
+	return filepath.Join(m.root, "cloud-init", fmt.Sprintf("%s.img", name))
+}
+
+// makeCloudInit writes the datasource disk of the vm and returns the drive to attach
+func (m *vmModuleImpl) makeCloudInit(vm *pkg.VM) (Drive, error) {
+	files, label, err := cloudInitFiles(vm)
+	if err != nil {
+		return Drive{}, err
+	}
+
+	path := m.cloudInitImage(vm.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Drive{}, err
+	}
+
+	if err := writeVFAT(path, label, files); err != nil {
+		_ = os.Remove(path)
+		return Drive{}, errors.Wrap(err, "failed to write cloud-init datasource")
+	}
+
+	return Drive{
+		ID:       cloudInitDriveID,
+		Path:     path,
+		ReadOnly: true,
+	}, nil
+}
+
+// writeVFAT creates a vfat image at path with the given label and files
+func writeVFAT(path, label string, files map[string][]byte) (err error) {
+	size := int64(cloudInitMinSize)
+	for _, data := range files {
+		// files take at least a cluster each
+		size += int64(len(data)) + 4096
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if output, err := exec.Command("mkfs.vfat", "-n", label, path).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to format image: %s", string(output))
+	}
+
+	output, err := exec.Command("losetup", "--find", "--show", path).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to attach image: %s", string(output))
+	}
+	loop := strings.TrimSpace(string(output))
+	defer func() {
+		if output, err := exec.Command("losetup", "--detach", loop).CombinedOutput(); err != nil {
+			log.Error().Err(err).Str("device", loop).Msgf("failed to detach image: %s", string(output))
+		}
+	}()
+
+	mnt, err := ioutil.TempDir("", "cloud-init")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mnt)
+
+	if err := syscall.Mount(loop, mnt, "vfat", 0, ""); err != nil {
+		return errors.Wrap(err, "failed to mount image")
+	}
+	defer func() {
+		if uerr := syscall.Unmount(mnt, 0); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, "failed to unmount image")
+		}
+	}()
+
+	for name, data := range files {
+		target := filepath.Join(mnt, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %s", name)
+		}
+	}
+
+	return nil
+}
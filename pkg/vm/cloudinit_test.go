@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func testVM(datasource pkg.CloudInitDatasource) pkg.VM {
+	return pkg.VM{
+		Name: "vm1",
+		Network: pkg.VMNetworkInfo{
+			MAC: cloudInitMAC("vm1"),
+			AddressCIDR: net.IPNet{
+				IP:   net.ParseIP("10.1.2.10"),
+				Mask: net.CIDRMask(24, 32),
+			},
+			GatewayIP:   net.ParseIP("10.1.2.1"),
+			Nameservers: []net.IP{net.ParseIP("8.8.8.8")},
+		},
+		CloudInit: &pkg.CloudInit{
+			Datasource: datasource,
+			SSHKeys:    []string{"ssh-ed25519 AAAA"},
+		},
+	}
+}
+
+func TestCloudInitMAC(t *testing.T) {
+	mac, err := net.ParseMAC(cloudInitMAC("vm1"))
+	require.NoError(t, err)
+
+	assert.Equal(t, cloudInitMAC("vm1"), mac.String())
+	assert.NotEqual(t, cloudInitMAC("vm1"), cloudInitMAC("vm2"))
+	// locally administered unicast
+	assert.Equal(t, byte(0x02), mac[0]&0x03)
+}
+
+func TestCloudInitNoCloud(t *testing.T) {
+	vm := testVM(pkg.CloudInitNoCloud)
+	files, label, err := cloudInitFiles(&vm)
+	require.NoError(t, err)
+	assert.Equal(t, "CIDATA", label)
+	assert.Equal(t, "#cloud-config\n", string(files["user-data"]))
+
+	var meta map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["meta-data"], &meta))
+	assert.Equal(t, "vm1", meta["instance-id"])
+	assert.Equal(t, "vm1", meta["local-hostname"])
+
+	var network struct {
+		Version   int `json:"version"`
+		Ethernets map[string]struct {
+			Match     map[string]string `json:"match"`
+			Addresses []string          `json:"addresses"`
+			Gateway4  string            `json:"gateway4"`
+		} `json:"ethernets"`
+	}
+	require.NoError(t, json.Unmarshal(files["network-config"], &network))
+	assert.Equal(t, 2, network.Version)
+	eth0 := network.Ethernets["eth0"]
+	assert.Equal(t, vm.Network.MAC, eth0.Match["macaddress"])
+	assert.Equal(t, []string{"10.1.2.10/24"}, eth0.Addresses)
+	assert.Equal(t, "10.1.2.1", eth0.Gateway4)
+}
+
+func TestCloudInitConfigDrive(t *testing.T) {
+	vm := testVM(pkg.CloudInitConfigDrive)
+	vm.CloudInit.Hostname = "web"
+	vm.CloudInit.UserData = "#!/bin/sh\necho hello\n"
+
+	files, label, err := cloudInitFiles(&vm)
+	require.NoError(t, err)
+	assert.Equal(t, "CONFIG-2", label)
+	assert.Equal(t, vm.CloudInit.UserData, string(files["openstack/latest/user_data"]))
+
+	var meta map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["openstack/latest/meta_data.json"], &meta))
+	assert.Equal(t, "web", meta["hostname"])
+	assert.Equal(t, map[string]interface{}{"key-0": "ssh-ed25519 AAAA"}, meta["public_keys"])
+
+	var network struct {
+		Networks []map[string]interface{} `json:"networks"`
+	}
+	require.NoError(t, json.Unmarshal(files["openstack/latest/network_data.json"], &network))
+	require.Len(t, network.Networks, 1)
+	assert.Equal(t, "ipv4", network.Networks[0]["type"])
+	assert.Equal(t, "255.255.255.0", network.Networks[0]["netmask"])
+}
@@ -0,0 +1,192 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/crypto"
+)
+
+const (
+	// consoleChunk is the max size of console output returned at once
+	consoleChunk = 64 * 1024 // 64KiB
+	// consoleInput is the fifo firecracker reads the serial console input from
+	consoleInput = "console.in"
+)
+
+// consoleAccess is who can write to the console of a machine
+type consoleAccess struct {
+	Owner string `json:"owner"`
+	Seq   uint64 `json:"seq"`
+}
+
+func (m *vmModuleImpl) consoleAccessPath(name string) string {
+	return filepath.Join(m.machineRoot(name), "console.json")
+}
+
+func (m *vmModuleImpl) consoleInputPath(name string) string {
+	return filepath.Join(m.machineRoot(name), "root", consoleInput)
+}
+
+func (m *vmModuleImpl) saveConsoleAccess(name string, access consoleAccess) error {
+	data, err := json.Marshal(access)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(m.consoleAccessPath(name), data, 0600)
+}
+
+// consoleSeq returns the last console input sequence of the machine name, 0
+// if its console is read only. The caller holds consoleMu
+func (m *vmModuleImpl) consoleSeq(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(m.consoleAccessPath(name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var access consoleAccess
+	if err := json.Unmarshal(data, &access); err != nil {
+		return 0, errors.Wrap(err, "invalid console access")
+	}
+
+	return access.Seq, nil
+}
+
+// advanceConsoleSeq moves the last console input sequence of the machine
+// name to seq, if its console is writable and seq is ahead
+func (m *vmModuleImpl) advanceConsoleSeq(name string, seq uint64) error {
+	m.consoleMu.Lock()
+	defer m.consoleMu.Unlock()
+
+	data, err := ioutil.ReadFile(m.consoleAccessPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var access consoleAccess
+	if err := json.Unmarshal(data, &access); err != nil {
+		return errors.Wrap(err, "invalid console access")
+	}
+
+	if seq <= access.Seq {
+		return nil
+	}
+
+	access.Seq = seq
+	return m.saveConsoleAccess(name, access)
+}
+
+// ConsoleLog implements pkg.VMModule
+func (m *vmModuleImpl) ConsoleLog(name string, offset int64) (pkg.VMConsoleOutput, error) {
+	machine := Machine{ID: name}
+	return readConsole(machine.Log(m.root), offset)
+}
+
+// readConsole reads at most consoleChunk bytes of the console log from offset
+func readConsole(path string, offset int64) (pkg.VMConsoleOutput, error) {
+	output := pkg.VMConsoleOutput{Offset: offset}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return output, fmt.Errorf("no console output available")
+	} else if err != nil {
+		return output, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return output, err
+	}
+
+	// the log was truncated (or the machine restarted), start over
+	if offset > stat.Size() || offset < 0 {
+		offset = 0
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return output, err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, f, consoleChunk)
+	if err != nil && err != io.EOF {
+		return output, err
+	}
+
+	output.Data = buf.Bytes()
+	output.Offset = offset + n
+	return output, nil
+}
+
+// consoleMessage is the message signed by the owner to write input to the console
+func consoleMessage(name string, seq uint64, input []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(name)
+	_ = binary.Write(&buf, binary.BigEndian, seq)
+	buf.Write(input)
+	return buf.Bytes()
+}
+
+// ConsoleWrite implements pkg.VMModule
+func (m *vmModuleImpl) ConsoleWrite(name string, seq uint64, input []byte, signature []byte) error {
+	m.consoleMu.Lock()
+	defer m.consoleMu.Unlock()
+
+	data, err := ioutil.ReadFile(m.consoleAccessPath(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("console of vm '%s' is read only", name)
+	} else if err != nil {
+		return err
+	}
+
+	var access consoleAccess
+	if err := json.Unmarshal(data, &access); err != nil {
+		return errors.Wrap(err, "invalid console access")
+	}
+
+	if seq <= access.Seq {
+		return fmt.Errorf("console input sequence %d was already used", seq)
+	}
+
+	key, err := crypto.KeyFromID(pkg.StrIdentifier(access.Owner))
+	if err != nil {
+		return errors.Wrap(err, "failed to get the public key of the console owner")
+	}
+
+	if err := crypto.Verify(key, consoleMessage(name, seq, input), signature); err != nil {
+		return errors.Wrap(err, "invalid console input signature")
+	}
+
+	access.Seq = seq
+	if err := m.saveConsoleAccess(name, access); err != nil {
+		return err
+	}
+
+	// firecracker holds the fifo open, so opening it for writing doesn't block
+	fifo, err := os.OpenFile(m.consoleInputPath(name), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open console")
+	}
+	defer fifo.Close()
+
+	if _, err := fifo.Write(input); err != nil {
+		return errors.Wrap(err, "failed to write to console")
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConsole(t *testing.T) {
+	root, err := ioutil.TempDir("", "console")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "machine.log")
+	_, err = readConsole(path, 0)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("booting\nlogin: "), 0600))
+
+	output, err := readConsole(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "booting\nlogin: ", string(output.Data))
+	assert.Equal(t, int64(15), output.Offset)
+
+	output, err = readConsole(path, output.Offset)
+	require.NoError(t, err)
+	assert.Empty(t, output.Data)
+	assert.Equal(t, int64(15), output.Offset)
+
+	// the log was truncated
+	require.NoError(t, ioutil.WriteFile(path, []byte("reboot\n"), 0600))
+	output, err = readConsole(path, output.Offset)
+	require.NoError(t, err)
+	assert.Equal(t, "reboot\n", string(output.Data))
+}
+
+func TestConsoleWriteAccess(t *testing.T) {
+	root, err := ioutil.TempDir("", "console")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	m := &vmModuleImpl{root: root}
+	require.NoError(t, os.MkdirAll(m.machineRoot("vm1"), 0755))
+
+	// read only console
+	assert.Error(t, m.ConsoleWrite("vm1", 1, []byte("ls\n"), nil))
+
+	require.NoError(t, m.saveConsoleAccess("vm1", consoleAccess{Owner: "owner", Seq: 5}))
+	err = m.ConsoleWrite("vm1", 5, []byte("ls\n"), nil)
+	assert.EqualError(t, err, "console input sequence 5 was already used")
+}
+
+func TestConsoleMessage(t *testing.T) {
+	msg := consoleMessage("vm1", 258, []byte("ls\n"))
+	assert.Equal(t, []byte("vm1\x00\x00\x00\x00\x00\x00\x01\x02ls\n"), msg)
+}
@@ -20,12 +20,26 @@ type Boot struct {
 	Args   string `json:"boot_args"`
 }
 
+// TokenBucket struct, size tokens are added
+// to the bucket every refill time
+type TokenBucket struct {
+	Size       uint64 `json:"size"`
+	RefillTime uint64 `json:"refill_time"`
+}
+
+// RateLimiter struct
+type RateLimiter struct {
+	Bandwidth *TokenBucket `json:"bandwidth,omitempty"`
+	Ops       *TokenBucket `json:"ops,omitempty"`
+}
+
 // Drive struct
 type Drive struct {
-	ID         string `json:"drive_id"`
-	Path       string `json:"path_on_host"`
-	RootDevice bool   `json:"is_root_device"`
-	ReadOnly   bool   `json:"is_read_only"`
+	ID          string       `json:"drive_id"`
+	Path        string       `json:"path_on_host"`
+	RootDevice  bool         `json:"is_root_device"`
+	ReadOnly    bool         `json:"is_read_only"`
+	RateLimiter *RateLimiter `json:"rate_limiter,omitempty"`
 }
 
 // Interface nic struct
@@ -40,6 +54,8 @@ type Config struct {
 	CPU       uint8 `json:"vcpu_count"`
 	Mem       int64 `json:"mem_size_mib"`
 	HTEnabled bool  `json:"ht_enabled"`
+	// TrackDirtyPages is needed for diff snapshots
+	TrackDirtyPages bool `json:"track_dirty_pages"`
 }
 
 // Machine struct
@@ -139,7 +155,31 @@ func (m *Machine) Start(ctx context.Context, base string) error {
 
 	cfg.Close()
 
-	return jailed.exec(ctx, base)
+	if err := makeConsole(root); err != nil {
+		return err
+	}
+
+	return jailed.exec(ctx, base, "--config-file", "/config.json")
+}
+
+// Restore starts firecracker without configuration, so a snapshot can be
+// loaded. The snapshot, its disks and config.json must already be in the
+// machine root
+func (m *Machine) Restore(ctx context.Context, base string) error {
+	if err := makeConsole(m.root(base)); err != nil {
+		return err
+	}
+
+	return m.exec(ctx, base)
+}
+
+// makeConsole creates the serial console input, firecracker reads it from its stdin
+func makeConsole(root string) error {
+	if err := syscall.Mkfifo(filepath.Join(root, consoleInput), 0600); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "failed to create console input")
+	}
+
+	return nil
 }
 
 // Log returns machine log file path
@@ -147,7 +187,7 @@ func (m *Machine) Log(base string) string {
 	return filepath.Join(m.root(base), "machine.log")
 }
 
-func (m *Machine) exec(ctx context.Context, base string) error {
+func (m *Machine) exec(ctx context.Context, base string, flags ...string) error {
 	// prepare command
 	// because the --daemonize flag does not work as expected
 	// we are daemonizing with `ash and &` so we can use cmd.Run().
@@ -173,11 +213,10 @@ func (m *Machine) exec(ctx context.Context, base string) error {
 		"--uid", "0", "--gid", "0",
 		"--chroot-base-dir", base, // this stupid flag creates so many layers but is needed
 		"--exec-file", fcBin,
-		"--node", "0",
 		"--", // fc flags starts here
-		"--config-file", "/config.json",
 		"--api-sock", "/api.socket",
 	}
+	args = append(args, flags...)
 
 	const (
 		// if this is enabled machine will start in the
@@ -189,12 +228,14 @@ func (m *Machine) exec(ctx context.Context, base string) error {
 	)
 
 	logFile := m.Log(base)
+	consoleFile := filepath.Join(m.root(base), consoleInput)
 
 	var cmd *exec.Cmd
 	if !testing {
 		cmd = exec.CommandContext(ctx,
 			"ash", "-c",
-			fmt.Sprintf("%s > %s 2>&1 &", strings.Join(args, " "), logFile),
+			// the fifo is opened read-write so the shell doesn't block until a writer shows up
+			fmt.Sprintf("%s > %s 2>&1 <> %s &", strings.Join(args, " "), logFile, consoleFile),
 		)
 	} else {
 		cmd = exec.CommandContext(ctx,
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/critical"
 )
 
 const (
@@ -31,6 +33,13 @@ const (
 // vmModuleImpl implements the VMModule interface
 type vmModuleImpl struct {
 	root string
+
+	consoleMu sync.Mutex
+
+	migrationsMu sync.Mutex
+	migrations   map[string]*migrationTarget
+
+	critical *critical.Tracker
 }
 
 var (
@@ -46,7 +55,9 @@ func NewVMModule(root string) (pkg.VMModule, error) {
 	}
 
 	return &vmModuleImpl{
-		root: root,
+		root:       root,
+		migrations: make(map[string]*migrationTarget),
+		critical:   critical.New(critical.DefaultRoot, "vmd"),
 	}, nil
 }
 
@@ -56,16 +67,39 @@ func (m *vmModuleImpl) makeDevices(vm *pkg.VM) ([]Drive, error) {
 		id := fmt.Sprintf("%d", i+2)
 
 		drives = append(drives, Drive{
-			ID:         id,
-			ReadOnly:   disk.ReadOnly,
-			RootDevice: disk.Root,
-			Path:       disk.Path,
+			ID:          id,
+			ReadOnly:    disk.ReadOnly,
+			RootDevice:  disk.Root,
+			Path:        disk.Path,
+			RateLimiter: rateLimiter(disk.IO),
 		})
 	}
 
 	return drives, nil
 }
 
+// rateLimiter converts the IO limit of a disk to a firecracker rate
+// limiter. Firecracker limits the total IO of a drive so reads and
+// writes are only capped when both of them are
+func rateLimiter(limit pkg.IOLimit) *RateLimiter {
+	// the buckets are refilled every second
+	const refill = 1000
+
+	var limiter RateLimiter
+	if limit.ReadIOPS != 0 && limit.WriteIOPS != 0 {
+		limiter.Ops = &TokenBucket{Size: limit.ReadIOPS + limit.WriteIOPS, RefillTime: refill}
+	}
+	if limit.ReadBandwidth != 0 && limit.WriteBandwidth != 0 {
+		limiter.Bandwidth = &TokenBucket{Size: limit.ReadBandwidth + limit.WriteBandwidth, RefillTime: refill}
+	}
+
+	if limiter.Ops == nil && limiter.Bandwidth == nil {
+		return nil
+	}
+
+	return &limiter
+}
+
 func (m *vmModuleImpl) machineRoot(id string) string {
 	return filepath.Join(m.root, "firecracker", id)
 }
@@ -74,7 +108,12 @@ func (m *vmModuleImpl) socket(id string) string {
 	return filepath.Join(m.machineRoot(id), "root", "api.socket")
 }
 
-func (m *vmModuleImpl) Exists(id string) bool {
+// Exists checks if the vm id is running
+func (m *vmModuleImpl) Exists(id string) (bool, error) {
+	return m.exists(id), nil
+}
+
+func (m *vmModuleImpl) exists(id string) bool {
 	_, err := m.find(id)
 	return err == nil
 }
@@ -82,6 +121,10 @@ func (m *vmModuleImpl) Exists(id string) bool {
 func (m *vmModuleImpl) cleanFs(id string) error {
 	root := filepath.Join(m.machineRoot(id), "root")
 
+	if err := os.Remove(m.cloudInitImage(id)); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("vm", id).Msg("failed to remove cloud-init datasource")
+	}
+
 	files, err := ioutil.ReadDir(root)
 	if os.IsNotExist(err) {
 		return nil
@@ -90,7 +133,7 @@ func (m *vmModuleImpl) cleanFs(id string) error {
 	}
 
 	for _, entry := range files {
-		if entry.IsDir() {
+		if entry.IsDir() || entry.Mode()&os.ModeNamedPipe != 0 {
 			continue
 		}
 
@@ -199,7 +242,7 @@ func (m *vmModuleImpl) Run(vm pkg.VM) error {
 
 	ctx := context.Background()
 
-	if m.Exists(vm.Name) {
+	if m.exists(vm.Name) {
 		return fmt.Errorf("a vm with same name already exists")
 	}
 
@@ -213,6 +256,19 @@ func (m *vmModuleImpl) Run(vm pkg.VM) error {
 		return err
 	}
 
+	if vm.CloudInit != nil {
+		if len(vm.Network.MAC) == 0 {
+			vm.Network.MAC = cloudInitMAC(vm.Name)
+		}
+
+		var drive Drive
+		drive, err = m.makeCloudInit(&vm)
+		if err != nil {
+			return errors.Wrap(err, "failed to prepare cloud-init datasource")
+		}
+		devices = append(devices, drive)
+	}
+
 	var kargs strings.Builder
 	kargs.WriteString(vm.KernelArgs)
 	if kargs.Len() == 0 {
@@ -241,6 +297,8 @@ func (m *vmModuleImpl) Run(vm pkg.VM) error {
 			CPU:       vm.CPU,
 			Mem:       vm.Memory,
 			HTEnabled: false,
+			// dirty pages are tracked so the machine can be live migrated
+			TrackDirtyPages: true,
 		},
 		Interfaces: []Interface{
 			nic,
@@ -260,12 +318,27 @@ func (m *vmModuleImpl) Run(vm pkg.VM) error {
 		return m.withLogs(logFile, err)
 	}
 
+	if len(vm.ConsoleOwner) != 0 {
+		if err = m.saveConsoleAccess(machine.ID, consoleAccess{Owner: vm.ConsoleOwner}); err != nil {
+			return errors.Wrap(err, "failed to enable interactive console")
+		}
+	}
+
+	if err = m.wait(ctx, machine.ID); err != nil {
+		return m.withLogs(logFile, err)
+	}
+
+	return nil
+}
+
+// wait waits for the machine to answer on its api socket
+func (m *vmModuleImpl) wait(ctx context.Context, id string) error {
 	check := func() error {
-		if !m.Exists(machine.ID) {
-			return fmt.Errorf("failed to spawn vm machine process '%s'", machine.ID)
+		if !m.exists(id) {
+			return fmt.Errorf("failed to spawn vm machine process '%s'", id)
 		}
 		//TODO: check unix connection
-		socket := m.socket(machine.ID)
+		socket := m.socket(id)
 		con, err := net.Dial("unix", socket)
 		if err != nil {
 			return err
@@ -278,16 +351,11 @@ func (m *vmModuleImpl) Run(vm pkg.VM) error {
 	ctx, cancel := context.WithTimeout(ctx, 6*time.Second)
 	defer cancel()
 
-	// wait for the machine to answer
-	if err = backoff.Retry(check, backoff.WithContext(backoff.NewConstantBackOff(2*time.Second), ctx)); err != nil {
-		return m.withLogs(logFile, err)
-	}
-
-	return nil
+	return backoff.Retry(check, backoff.WithContext(backoff.NewConstantBackOff(2*time.Second), ctx))
 }
 
 func (m *vmModuleImpl) Inspect(name string) (pkg.VMInfo, error) {
-	if !m.Exists(name) {
+	if !m.exists(name) {
 		return pkg.VMInfo{}, fmt.Errorf("machine '%s' does not exist", name)
 	}
 
@@ -395,7 +463,7 @@ func (m *vmModuleImpl) Delete(name string) error {
 	}
 
 	for {
-		if !m.Exists(name) {
+		if !m.exists(name) {
 			return nil
 		}
 
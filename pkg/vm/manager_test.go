@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestRateLimiter(t *testing.T) {
+	cases := []struct {
+		name     string
+		limit    pkg.IOLimit
+		expected *RateLimiter
+	}{
+		{
+			name:     "unlimited",
+			limit:    pkg.IOLimit{},
+			expected: nil,
+		},
+		{
+			// firecracker can't cap the reads alone
+			name:     "reads only",
+			limit:    pkg.IOLimit{ReadIOPS: 100, ReadBandwidth: 1024},
+			expected: nil,
+		},
+		{
+			name:  "iops",
+			limit: pkg.IOLimit{ReadIOPS: 100, WriteIOPS: 50},
+			expected: &RateLimiter{
+				Ops: &TokenBucket{Size: 150, RefillTime: 1000},
+			},
+		},
+		{
+			name:  "bandwidth",
+			limit: pkg.IOLimit{ReadBandwidth: 1024, WriteBandwidth: 2048},
+			expected: &RateLimiter{
+				Bandwidth: &TokenBucket{Size: 3072, RefillTime: 1000},
+			},
+		},
+		{
+			name:  "all",
+			limit: pkg.IOLimit{ReadIOPS: 100, WriteIOPS: 50, ReadBandwidth: 1024, WriteBandwidth: 2048},
+			expected: &RateLimiter{
+				Ops:       &TokenBucket{Size: 150, RefillTime: 1000},
+				Bandwidth: &TokenBucket{Size: 3072, RefillTime: 1000},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, rateLimiter(c.limit))
+		})
+	}
+}
@@ -0,0 +1,647 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	// migrationTimeout is how long a node waits for a migrated vm
+	migrationTimeout = 30 * time.Minute
+	// migrationRounds is the max number of diff snapshots sent while the vm
+	// is running, before the final one
+	migrationRounds = 3
+	// migrationConverged is the amount of dirty memory that is small enough
+	// to be sent while the vm is paused
+	migrationConverged = 32 * 1024 * 1024 // 32MiB
+
+	// snapshot files of the sending node, in the machine root. They are not
+	// named like the received snapshot because a restored machine memory is
+	// mapped from its snapshot file
+	migrationMemory = "migration.mem"
+	migrationState  = "migration.state"
+
+	// snapshot files of the receiving node, in the machine root
+	restoreMemory = "memory"
+	restoreState  = "state"
+)
+
+// migrationRequest is the first request of a migration, it is the jailed
+// configuration of the machine
+type migrationRequest struct {
+	Machine Machine        `json:"machine"`
+	Console *consoleAccess `json:"console,omitempty"`
+}
+
+// migrationResume is the last request of a migration, it's sent once the
+// console of the machine is blocked on the sending node
+type migrationResume struct {
+	// ConsoleSeq is the last console input sequence accepted by the
+	// sending node, the inputs signed for it can't be replayed on the target
+	ConsoleSeq uint64 `json:"console_seq"`
+}
+
+// migrationMounts splits the drives of a migrated machine between the ones
+// mounted from a disk of this node and the ones copied from the sending node
+func migrationMounts(machine Machine, disks map[string]string) (map[string]string, []string, error) {
+	mounts := make(map[string]string)
+	var copies []string
+	for _, drive := range machine.Drives {
+		file := drive.Path
+		if file != filepath.Base(file) || file == "." || file == ".." {
+			return nil, nil, fmt.Errorf("invalid drive path '%s'", file)
+		}
+
+		if path, ok := disks[file]; ok {
+			mounts[file] = path
+			continue
+		}
+
+		if !drive.ReadOnly {
+			return nil, nil, fmt.Errorf("writable disk '%s' is not mapped to a disk of this node", file)
+		}
+
+		copies = append(copies, file)
+	}
+
+	return mounts, copies, nil
+}
+
+// migrationTarget receives a migrated machine
+type migrationTarget struct {
+	m         *vmModuleImpl
+	name      string
+	token     string
+	migration pkg.VMMigration
+	server    *http.Server
+	timer     *time.Timer
+
+	mu      sync.Mutex
+	copies  map[string]bool
+	resumed bool
+	once    sync.Once
+}
+
+func (t *migrationTarget) root() string {
+	return filepath.Join(t.m.machineRoot(t.name), "root")
+}
+
+// MigrationAccept implements pkg.VMModule
+func (m *vmModuleImpl) MigrationAccept(name string, migration pkg.VMMigration) (string, error) {
+	if m.exists(name) {
+		return "", fmt.Errorf("a vm with same name already exists")
+	}
+
+	m.migrationsMu.Lock()
+	defer m.migrationsMu.Unlock()
+
+	if _, ok := m.migrations[name]; ok {
+		return "", fmt.Errorf("vm '%s' is already being migrated to this node", name)
+	}
+
+	var token [32]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", errors.Wrap(err, "failed to generate migration token")
+	}
+
+	cert, fingerprint, err := migrationCertificate(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.cleanFs(name); err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", migration.Listen)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to listen on '%s'", migration.Listen)
+	}
+	listener = tls.NewListener(listener, migrationServerTLS(cert))
+
+	target := &migrationTarget{
+		m:         m,
+		name:      name,
+		token:     hex.EncodeToString(token[:]),
+		migration: migration,
+	}
+	target.server = &http.Server{Handler: target}
+	target.timer = time.AfterFunc(migrationTimeout, func() {
+		target.finish(fmt.Errorf("migration timed out"))
+	})
+
+	m.migrations[name] = target
+	go func() {
+		if err := target.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			target.finish(err)
+		}
+	}()
+
+	log.Info().Str("vm", name).Str("listen", listener.Addr().String()).Msg("waiting for vm migration")
+	return migrationTicket(target.token, fingerprint), nil
+}
+
+// finish stops receiving the machine, the machine is deleted if the migration failed
+func (t *migrationTarget) finish(err error) {
+	t.once.Do(func() {
+		t.timer.Stop()
+		go func() {
+			// the response of the last request must still be sent
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = t.server.Shutdown(ctx)
+		}()
+
+		t.m.migrationsMu.Lock()
+		delete(t.m.migrations, t.name)
+		t.m.migrationsMu.Unlock()
+
+		if err == nil {
+			log.Info().Str("vm", t.name).Msg("vm migrated to this node")
+			return
+		}
+
+		log.Error().Err(err).Str("vm", t.name).Msg("vm migration failed")
+		t.m.kill(t.name)
+	})
+}
+
+func (t *migrationTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(t.token)) != 1 {
+		http.Error(w, "invalid migration token", http.StatusUnauthorized)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.resumed {
+		http.Error(w, "vm already migrated", http.StatusConflict)
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/machine":
+		result, err = t.machine(r.Body)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/drive/"):
+		err = t.drive(strings.TrimPrefix(r.URL.Path, "/drive/"), r.Body)
+	case r.Method == http.MethodPut && r.URL.Path == "/memory":
+		err = t.memory(r.Body)
+	case r.Method == http.MethodPut && r.URL.Path == "/state":
+		err = t.state(r.Body)
+	case r.Method == http.MethodPost && r.URL.Path == "/resume":
+		err = t.resume(r.Body)
+		defer t.finish(err)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("vm", t.name).Str("path", r.URL.Path).Msg("migration request failed")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error().Err(err).Msg("failed to write migration response")
+	}
+}
+
+// machine prepares the machine root from the machine configuration, it
+// returns the drives that must be copied
+func (t *migrationTarget) machine(body io.Reader) ([]string, error) {
+	if t.copies != nil {
+		return nil, fmt.Errorf("machine configuration already received")
+	}
+
+	var request migrationRequest
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return nil, errors.Wrap(err, "invalid machine configuration")
+	}
+
+	machine := request.Machine
+	for _, nic := range machine.Interfaces {
+		// the tap is named after the network, it's the same on all nodes
+		if _, err := net.InterfaceByName(nic.Tap); err != nil {
+			return nil, errors.Wrapf(err, "network of the vm is not ready, tap '%s'", nic.Tap)
+		}
+	}
+
+	mounts, copies, err := migrationMounts(machine, t.migration.Disks)
+	if err != nil {
+		return nil, err
+	}
+
+	root := t.root()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create machine root")
+	}
+
+	// the restored machine finds the drives under the same names
+	for file, path := range mounts {
+		if err := mount(path, filepath.Join(root, file)); err != nil {
+			return nil, err
+		}
+	}
+
+	// config.json is kept so the machine can be migrated again
+	data, err := json.Marshal(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "config.json"), data, 0644); err != nil {
+		return nil, errors.Wrap(err, "failed to write config file")
+	}
+
+	if request.Console != nil {
+		// the sequence is carried over, the inputs already written on the
+		// sending node can't be replayed here
+		if err := t.m.saveConsoleAccess(t.name, *request.Console); err != nil {
+			return nil, errors.Wrap(err, "failed to enable interactive console")
+		}
+	}
+
+	t.copies = make(map[string]bool)
+	for _, file := range copies {
+		t.copies[file] = true
+	}
+
+	return copies, nil
+}
+
+func (t *migrationTarget) receive(file string, body io.Reader) error {
+	f, err := os.OpenFile(filepath.Join(t.root(), file), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := receiveSegments(body, f); err != nil {
+		return errors.Wrapf(err, "failed to receive %s", file)
+	}
+
+	return f.Sync()
+}
+
+func (t *migrationTarget) drive(file string, body io.Reader) error {
+	if !t.copies[file] {
+		return fmt.Errorf("unexpected drive '%s'", file)
+	}
+
+	return t.receive(file, body)
+}
+
+// memory receives the memory of the machine, the memory is sent multiple
+// times, first in full then only the pages written since the last time
+func (t *migrationTarget) memory(body io.Reader) error {
+	if t.copies == nil {
+		return fmt.Errorf("machine configuration not received")
+	}
+
+	return t.receive(restoreMemory, body)
+}
+
+func (t *migrationTarget) state(body io.Reader) error {
+	if t.copies == nil {
+		return fmt.Errorf("machine configuration not received")
+	}
+
+	f, err := os.Create(filepath.Join(t.root(), restoreState))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return errors.Wrap(err, "failed to receive machine state")
+	}
+
+	return f.Sync()
+}
+
+// resume starts the machine from the received snapshot
+func (t *migrationTarget) resume(body io.Reader) error {
+	var request migrationResume
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return errors.Wrap(err, "invalid resume request")
+	}
+
+	if err := t.m.advanceConsoleSeq(t.name, request.ConsoleSeq); err != nil {
+		return errors.Wrap(err, "failed to update console access")
+	}
+
+	root := t.root()
+	for _, file := range []string{restoreMemory, restoreState} {
+		if _, err := os.Stat(filepath.Join(root, file)); err != nil {
+			return errors.Wrapf(err, "snapshot is incomplete")
+		}
+	}
+
+	ctx := context.Background()
+	machine := Machine{ID: t.name}
+	if err := machine.Restore(ctx, t.m.root); err != nil {
+		return t.m.withLogs(machine.Log(t.m.root), err)
+	}
+
+	if err := t.m.wait(ctx, t.name); err != nil {
+		return t.m.withLogs(machine.Log(t.m.root), err)
+	}
+
+	api := newAPI(t.m.socket(t.name))
+	if err := api.load(ctx, "/"+restoreState, "/"+restoreMemory); err != nil {
+		return errors.Wrap(err, "failed to load snapshot")
+	}
+
+	if err := api.resume(ctx); err != nil {
+		return errors.Wrap(err, "failed to resume vm")
+	}
+
+	t.resumed = true
+	return nil
+}
+
+// migrationClient sends a machine to a migration target
+type migrationClient struct {
+	url    string
+	token  string
+	client http.Client
+}
+
+// newMigrationClient creates a client of the target of the ticket
+func newMigrationClient(target, ticket string) (*migrationClient, error) {
+	token, fingerprint, err := parseMigrationTicket(ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrationClient{
+		url:   "https://" + target,
+		token: token,
+		client: http.Client{
+			Transport: &http.Transport{TLSClientConfig: migrationClientTLS(fingerprint)},
+		},
+	}, nil
+}
+
+func (c *migrationClient) do(method, path string, body io.Reader, result interface{}) error {
+	request, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.token)
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s %s failed (%s): %s", method, path, response.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// send sends the data of the file at path to the target, it returns the
+// number of bytes sent
+func (c *migrationClient) send(path, file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader, writer := io.Pipe()
+	sent := make(chan int64, 1)
+	go func() {
+		n, err := sendSegments(writer, f)
+		writer.CloseWithError(err)
+		sent <- n
+	}()
+
+	err = c.do(http.MethodPut, path, reader, nil)
+	// unblock the sender if the request failed before reading everything
+	reader.CloseWithError(io.ErrClosedPipe)
+	return <-sent, err
+}
+
+// Migrate implements pkg.VMModule
+func (m *vmModuleImpl) Migrate(name string, target string, ticket string) (err error) {
+	if !m.exists(name) {
+		return fmt.Errorf("machine '%s' does not exist", name)
+	}
+
+	client, err := newMigrationClient(target, ticket)
+	if err != nil {
+		return err
+	}
+
+	// restarting vmd would leave the machine paused
+	defer m.critical.Begin("migrate " + name)()
+
+	root := filepath.Join(m.machineRoot(name), "root")
+	var request migrationRequest
+	data, err := ioutil.ReadFile(filepath.Join(root, "config.json"))
+	if err != nil {
+		return errors.Wrap(err, "failed to read machine configuration")
+	}
+
+	if err := json.Unmarshal(data, &request.Machine); err != nil {
+		return errors.Wrap(err, "invalid machine configuration")
+	}
+
+	if !request.Machine.Config.TrackDirtyPages {
+		return fmt.Errorf("vm '%s' was not started with dirty pages tracking", name)
+	}
+
+	data, err = ioutil.ReadFile(m.consoleAccessPath(name))
+	if err == nil {
+		var access consoleAccess
+		if err := json.Unmarshal(data, &access); err != nil {
+			return errors.Wrap(err, "invalid console access")
+		}
+		request.Console = &access
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	data, err = json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	var copies []string
+	if err := client.do(http.MethodPost, "/machine", bytes.NewReader(data), &copies); err != nil {
+		return errors.Wrap(err, "failed to send machine configuration")
+	}
+
+	// the copied drives are read only, so they are sent while the vm is running
+	for _, file := range copies {
+		if _, err := client.send("/drive/"+file, filepath.Join(root, file)); err != nil {
+			return errors.Wrapf(err, "failed to send drive '%s'", file)
+		}
+	}
+
+	memory := filepath.Join(root, migrationMemory)
+	state := filepath.Join(root, migrationState)
+	defer os.Remove(memory)
+	defer os.Remove(state)
+
+	ctx := context.Background()
+	api := newAPI(m.socket(name))
+	paused := false
+	defer func() {
+		if err != nil && paused {
+			if rerr := api.resume(ctx); rerr != nil {
+				log.Error().Err(rerr).Str("vm", name).Msg("failed to resume vm after failed migration")
+			}
+		}
+	}()
+
+	// snapshot writes a snapshot of the vm, the vm is left paused
+	snapshot := func(kind string) error {
+		if err := api.pause(ctx); err != nil {
+			return errors.Wrap(err, "failed to pause vm")
+		}
+		paused = true
+
+		// firecracker writes the dirty pages in place so the previous
+		// memory file is removed
+		if err := os.Remove(memory); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return api.snapshot(ctx, kind, "/"+migrationState, "/"+migrationMemory)
+	}
+
+	resume := func() error {
+		if err := api.resume(ctx); err != nil {
+			return errors.Wrap(err, "failed to resume vm")
+		}
+		paused = false
+		return nil
+	}
+
+	kind := snapshotFull
+	for round := 0; round <= migrationRounds; round++ {
+		if err := snapshot(kind); err != nil {
+			return errors.Wrap(err, "failed to snapshot vm")
+		}
+
+		if err := resume(); err != nil {
+			return err
+		}
+
+		sent, err := client.send("/memory", memory)
+		if err != nil {
+			return errors.Wrap(err, "failed to send vm memory")
+		}
+
+		log.Debug().Str("vm", name).Int("round", round).Int64("bytes", sent).Msg("vm memory sent")
+		kind = snapshotDiff
+		if round > 0 && sent <= migrationConverged {
+			break
+		}
+	}
+
+	// the last pages are sent while the vm is paused
+	if err := snapshot(snapshotDiff); err != nil {
+		return errors.Wrap(err, "failed to snapshot vm")
+	}
+
+	// the disks are on storage reachable from the target, make sure
+	// all writes are on it before the target uses them
+	syscall.Sync()
+
+	if _, err := client.send("/memory", memory); err != nil {
+		return errors.Wrap(err, "failed to send vm memory")
+	}
+
+	f, err := os.Open(state)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := client.do(http.MethodPut, "/state", f, nil); err != nil {
+		return errors.Wrap(err, "failed to send vm state")
+	}
+
+	// the console input is blocked until the vm is gone from this node, so
+	// the target knows the last input written here
+	m.consoleMu.Lock()
+	defer m.consoleMu.Unlock()
+
+	seq, err := m.consoleSeq(name)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(migrationResume{ConsoleSeq: seq})
+	if err != nil {
+		return err
+	}
+
+	if err := client.do(http.MethodPost, "/resume", bytes.NewReader(data), nil); err != nil {
+		return errors.Wrap(err, "failed to resume vm on target")
+	}
+
+	// the vm is running on the target now, it's paused here so it can be
+	// killed right away
+	m.kill(name)
+	return nil
+}
+
+// kill kills the machine process and cleans up its files
+func (m *vmModuleImpl) kill(name string) {
+	defer m.cleanFs(name)
+
+	pid, err := m.find(name)
+	if err != nil {
+		return
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		log.Error().Err(err).Str("vm", name).Msg("failed to kill vm")
+		return
+	}
+
+	for i := 0; i < 10 && m.exists(name); i++ {
+		<-time.After(500 * time.Millisecond)
+	}
+}
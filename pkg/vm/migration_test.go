@@ -0,0 +1,169 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegments(t *testing.T) {
+	root, err := ioutil.TempDir("", "segments")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	const size = 8 * maxSegment
+
+	src, err := os.Create(filepath.Join(root, "src"))
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, src.Truncate(size))
+
+	// a sparse file with data at the start, a large region and a hole at the end
+	first := bytes.Repeat([]byte("a"), 4096)
+	second := bytes.Repeat([]byte("b"), 2*maxSegment+4096)
+	_, err = src.WriteAt(first, 0)
+	require.NoError(t, err)
+	_, err = src.WriteAt(second, 3*maxSegment)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	sent, err := sendSegments(&buf, src)
+	require.NoError(t, err)
+	// file systems might report data in larger blocks than written
+	assert.True(t, sent >= int64(len(first)+len(second)))
+	assert.True(t, sent < size)
+
+	dst, err := os.Create(filepath.Join(root, "dst"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, receiveSegments(&buf, dst))
+
+	expected, err := ioutil.ReadFile(src.Name())
+	require.NoError(t, err)
+	received, err := ioutil.ReadFile(dst.Name())
+	require.NoError(t, err)
+	assert.Equal(t, len(expected), len(received))
+	assert.True(t, bytes.Equal(expected, received))
+
+	// a diff only overwrites the segments it has
+	diff, err := os.Create(filepath.Join(root, "diff"))
+	require.NoError(t, err)
+	defer diff.Close()
+	require.NoError(t, diff.Truncate(size))
+	_, err = diff.WriteAt([]byte("c"), 0)
+	require.NoError(t, err)
+
+	buf.Reset()
+	_, err = sendSegments(&buf, diff)
+	require.NoError(t, err)
+	require.NoError(t, receiveSegments(&buf, dst))
+
+	received, err = ioutil.ReadFile(dst.Name())
+	require.NoError(t, err)
+	assert.Equal(t, byte('c'), received[0])
+	assert.Equal(t, byte('b'), received[3*maxSegment])
+}
+
+func TestMigrationMounts(t *testing.T) {
+	machine := Machine{
+		Drives: []Drive{
+			{ID: "2", Path: "root-disk", RootDevice: true},
+			{ID: "3", Path: "flist", ReadOnly: true},
+			{ID: cloudInitDriveID, Path: "vm.img", ReadOnly: true},
+		},
+	}
+
+	mounts, copies, err := migrationMounts(machine, map[string]string{
+		"root-disk": "/mnt/shared/root-disk",
+		"flist":     "/var/cache/flist",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"root-disk": "/mnt/shared/root-disk",
+		"flist":     "/var/cache/flist",
+	}, mounts)
+	assert.Equal(t, []string{"vm.img"}, copies)
+
+	_, _, err = migrationMounts(machine, nil)
+	assert.Error(t, err)
+
+	machine.Drives = []Drive{{ID: "2", Path: "../etc/passwd", ReadOnly: true}}
+	_, _, err = migrationMounts(machine, nil)
+	assert.Error(t, err)
+}
+
+func TestMigrationTicket(t *testing.T) {
+	_, fingerprint, err := migrationCertificate("vm1")
+	require.NoError(t, err)
+
+	token, parsed, err := parseMigrationTicket(migrationTicket("abcd", fingerprint))
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", token)
+	assert.Equal(t, fingerprint, parsed)
+
+	for _, ticket := range []string{"", "abcd", "abcd." + fingerprint[:10], "." + fingerprint, "abcd." + strings.Repeat("z", len(fingerprint))} {
+		_, _, err := parseMigrationTicket(ticket)
+		assert.Error(t, err, ticket)
+	}
+}
+
+func TestMigrationTLS(t *testing.T) {
+	cert, fingerprint, err := migrationCertificate("vm1")
+	require.NoError(t, err)
+	_, other, err := migrationCertificate("vm1")
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("null"))
+	}))
+	server.TLS = migrationServerTLS(cert)
+	server.StartTLS()
+	defer server.Close()
+
+	target := strings.TrimPrefix(server.URL, "https://")
+
+	client, err := newMigrationClient(target, migrationTicket("abcd", fingerprint))
+	require.NoError(t, err)
+	assert.NoError(t, client.do(http.MethodPost, "/resume", nil, nil))
+
+	// someone else on the path
+	client, err = newMigrationClient(target, migrationTicket("abcd", other))
+	require.NoError(t, err)
+	assert.Error(t, client.do(http.MethodPost, "/resume", nil, nil))
+}
+
+func TestMigrationConsoleSeq(t *testing.T) {
+	root, err := ioutil.TempDir("", "migration")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	m := &vmModuleImpl{root: root}
+	target := &migrationTarget{m: m, name: "vm1"}
+
+	_, err = target.machine(strings.NewReader(`{"machine": {}, "console": {"owner": "owner", "seq": 7}}`))
+	require.NoError(t, err)
+
+	// the inputs written on the sending node are not accepted again
+	err = m.ConsoleWrite("vm1", 7, []byte("ls\n"), nil)
+	assert.EqualError(t, err, "console input sequence 7 was already used")
+
+	require.NoError(t, m.advanceConsoleSeq("vm1", 9))
+	seq, err := m.consoleSeq("vm1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9), seq)
+
+	// never goes back
+	require.NoError(t, m.advanceConsoleSeq("vm1", 3))
+	seq, err = m.consoleSeq("vm1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9), seq)
+}
@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a migration runs over TLS. The receiving node generates a certificate for
+// each migration, the ticket returned by MigrationAccept is the token the
+// sending node presents and the fingerprint of the certificate. The sending
+// node only talks to the node with this certificate, so the memory of the
+// machine and the token can't be read or used by someone on the path
+
+// migrationCertificate generates the certificate of a migration and returns
+// its hex encoded sha256 fingerprint
+func migrationCertificate(name string) (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "migration of " + name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(migrationTimeout + time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", errors.Wrap(err, "failed to create migration certificate")
+	}
+
+	sum := sha256.Sum256(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, hex.EncodeToString(sum[:]), nil
+}
+
+// migrationTicket joins the token and the fingerprint of the certificate of
+// a migration
+func migrationTicket(token, fingerprint string) string {
+	return token + "." + fingerprint
+}
+
+// parseMigrationTicket splits a ticket in its token and fingerprint
+func parseMigrationTicket(ticket string) (token, fingerprint string, err error) {
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) != 2*sha256.Size {
+		return "", "", fmt.Errorf("invalid migration ticket")
+	}
+
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", "", errors.Wrap(err, "invalid migration ticket fingerprint")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// migrationServerTLS is the TLS configuration of the receiving node
+func migrationServerTLS(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// migrationClientTLS is the TLS configuration of the sending node, it only
+// accepts the certificate with fingerprint
+func migrationClientTLS(fingerprint string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the certificate is not signed by a CA, it's pinned instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return fmt.Errorf("migration target sent no certificate")
+			}
+
+			sum := sha256.Sum256(raw[0])
+			if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(fingerprint)) != 1 {
+				return fmt.Errorf("migration target certificate does not match the ticket")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// snapshot types of firecracker
+const (
+	snapshotFull = "Full"
+	snapshotDiff = "Diff"
+)
+
+// api is a minimal client of the firecracker api, for the calls
+// the sdk doesn't support (pause, resume and snapshots)
+type api struct {
+	client http.Client
+}
+
+func newAPI(socket string) *api {
+	return &api{
+		client: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (a *api) call(ctx context.Context, method, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(method, "http://localhost"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := a.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("firecracker %s %s failed (%s): %s", method, path, response.Status, string(msg))
+	}
+
+	return nil
+}
+
+func (a *api) pause(ctx context.Context) error {
+	return a.call(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Paused"})
+}
+
+func (a *api) resume(ctx context.Context) error {
+	return a.call(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Resumed"})
+}
+
+// snapshot writes the state and memory of the paused vm, the paths are
+// relative to the jail of the vm. A diff snapshot only has the pages
+// written since the previous snapshot
+func (a *api) snapshot(ctx context.Context, kind, state, memory string) error {
+	return a.call(ctx, http.MethodPut, "/snapshot/create", map[string]string{
+		"snapshot_type": kind,
+		"snapshot_path": state,
+		"mem_file_path": memory,
+	})
+}
+
+// load restores a snapshot in a fresh (not configured) firecracker
+func (a *api) load(ctx context.Context, state, memory string) error {
+	return a.call(ctx, http.MethodPut, "/snapshot/load", map[string]string{
+		"snapshot_path": state,
+		"mem_file_path": memory,
+	})
+}
+
+// segments are how (sparse) files are sent to another node, a segment is the
+// offset and the length of the data as big endian uint64 followed by the data.
+// The last segment is empty, its offset is the size of the file
+const maxSegment = 1024 * 1024 // 1MiB
+
+// sendSegments writes the data regions of f to w, holes are skipped. It
+// returns the number of data bytes sent
+func sendSegments(w io.Writer, f *os.File) (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		sent   int64
+		offset int64
+		buf    = make([]byte, maxSegment)
+		header [16]byte
+	)
+
+	for offset < stat.Size() {
+		start, err := unix.Seek(int(f.Fd()), offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// no more data after offset
+			break
+		} else if err != nil {
+			return sent, errors.Wrap(err, "failed to find data")
+		}
+
+		end, err := unix.Seek(int(f.Fd()), start, unix.SEEK_HOLE)
+		if err != nil {
+			return sent, errors.Wrap(err, "failed to find hole")
+		}
+
+		for start < end {
+			length := end - start
+			if length > maxSegment {
+				length = maxSegment
+			}
+
+			if _, err := f.ReadAt(buf[:length], start); err != nil {
+				return sent, err
+			}
+
+			binary.BigEndian.PutUint64(header[:8], uint64(start))
+			binary.BigEndian.PutUint64(header[8:], uint64(length))
+			if _, err := w.Write(header[:]); err != nil {
+				return sent, err
+			}
+			if _, err := w.Write(buf[:length]); err != nil {
+				return sent, err
+			}
+
+			sent += length
+			start += length
+		}
+
+		offset = end
+	}
+
+	binary.BigEndian.PutUint64(header[:8], uint64(stat.Size()))
+	binary.BigEndian.PutUint64(header[8:], 0)
+	if _, err := w.Write(header[:]); err != nil {
+		return sent, err
+	}
+
+	return sent, nil
+}
+
+// receiveSegments writes the segments read from r to f at their offsets
+func receiveSegments(r io.Reader, f *os.File) error {
+	var header [16]byte
+	buf := make([]byte, maxSegment)
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read segment header")
+		}
+
+		offset := int64(binary.BigEndian.Uint64(header[:8]))
+		length := int64(binary.BigEndian.Uint64(header[8:]))
+		if length > maxSegment || offset < 0 {
+			return fmt.Errorf("invalid segment at %d of %d bytes", offset, length)
+		}
+
+		if length == 0 {
+			// end of the file, the file might end with a hole
+			if err := extend(f, offset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := io.ReadFull(r, buf[:length]); err != nil {
+			return errors.Wrap(err, "failed to read segment")
+		}
+
+		if _, err := f.WriteAt(buf[:length], offset); err != nil {
+			return err
+		}
+	}
+}
+
+// extend grows f to size if it's smaller
+func extend(f *os.File, size int64) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() >= size {
+		return nil
+	}
+
+	return f.Truncate(size)
+}
@@ -42,4 +42,13 @@ type ZDBAllocater interface {
 	// Find searches the system for the current allocation for the namespace
 	// Return error = "not found" if no allocation exists.
 	Find(namespace string) (allocation Allocation, err error)
+
+	// Export packages the data files of the namespace in an archive and
+	// returns the path of the archive, to move the namespace to another
+	// node. The namespace must not be written to while it is exported
+	Export(namespace string) (path string, err error)
+
+	// Import creates the namespace stored in the archive at path, as made
+	// by Export, in a 0-db running in mode on a disk of type diskType
+	Import(path string, diskType DeviceType, mode ZDBMode) (Allocation, error)
 }
@@ -108,6 +108,27 @@ func (c *clientImpl) NamespaceSetPublic(name string, public bool) error {
 	return nil
 }
 
+// NamespacePublic returns the public flag of the namespace
+func (c *clientImpl) NamespacePublic(name string) (bool, error) {
+	con := c.pool.Get()
+	defer con.Close()
+
+	info, err := redis.String(con.Do("NSINFO", name))
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(info, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "public" {
+			continue
+		}
+		return strings.TrimSpace(parts[1]) == "yes", nil
+	}
+
+	return false, fmt.Errorf("public flag of namespace %s not found", name)
+}
+
 // DBSize returns the size of the database in bytes
 func (c *clientImpl) DBSize() (uint64, error) {
 	con := c.pool.Get()
@@ -21,6 +21,7 @@ type Client interface {
 	NamespaceSetSize(name string, size uint64) error
 	NamespaceSetPassword(name, password string) error
 	NamespaceSetPublic(name string, public bool) error
+	NamespacePublic(name string) (bool, error)
 	DBSize() (uint64, error)
 }
 
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -15,9 +16,13 @@ const defaultSocketPath = "/var/run/zinit.sock"
 
 // Client is a client for zinit action
 // it talks to zinit directly over its unis socket
+// it's safe to use from several goroutines
 type Client struct {
 	conn net.Conn
 	scan *bufio.Scanner
+	// mu makes sure a response is read by the goroutine that
+	// sent the command
+	mu sync.Mutex
 }
 
 // New create a new Zinit client
@@ -48,6 +53,9 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) cmd(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.conn == nil {
 		return "", fmt.Errorf("not connected, call Connect() before executing command ")
 	}